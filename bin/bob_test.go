@@ -69,6 +69,14 @@ var dataEncodingTestCases = []xmpptest.EncodingTestCase{
 		XML:       `<data xmlns="urn:xmpp:bob">a=</data>`,
 		NoMarshal: true,
 	},
+	5: {
+		// Padding must not result in extra bytes.
+		Value: &bin.Data{
+			Type: "image/png",
+			Data: []byte{0x89, 'P', 'N', 'G'},
+		},
+		XML: `<data xmlns="urn:xmpp:bob" type="image/png">iVBORw==</data>`,
+	},
 }
 
 func TestDataEncoding(t *testing.T) {
@@ -36,10 +36,12 @@ func BindResource() StreamFeature {
 // should be returned to the client. If server is nil, BindCustom is identical
 // to BindResource.
 //
-// The server function is passed the current client JID and the resource
-// requested by the client (or an empty string if a specific resource was not
-// requested). Resources generated by the server function should be random to
-// prevent certain security issues related to guessing resourceparts.
+// The server function is passed the bare JID that the client authenticated as
+// (or the stream origin if authentication was not negotiated by this package)
+// and the resource requested by the client (or an empty string if a specific
+// resource was not requested).
+// Resources generated by the server function should be random to prevent
+// certain security issues related to guessing resourceparts.
 func BindCustom(server func(jid.JID, string) (jid.JID, error)) StreamFeature {
 	return bind(server)
 }
@@ -153,9 +155,9 @@ func bind(server func(jid.JID, string) (jid.JID, error)) StreamFeature {
 
 				var j jid.JID
 				if server != nil {
-					j, err = server(session.RemoteAddr(), resReq.Bind.Resource)
+					j, err = server(session.peerAddr(), resReq.Bind.Resource)
 				} else {
-					j, err = session.RemoteAddr().WithResource(attr.RandomID())
+					j, err = session.peerAddr().WithResource(attr.RandomID())
 				}
 				stanzaErr, ok := err.(stanza.Error)
 				if err != nil && !ok {
@@ -177,6 +179,7 @@ func bind(server func(jid.JID, string) (jid.JID, error)) StreamFeature {
 					resp.Err = &stanzaErr
 				} else {
 					resp.Bind = bindPayload{JID: j}
+					session.updateRemoteAddr(j)
 				}
 
 				_, err = resp.WriteXML(w)
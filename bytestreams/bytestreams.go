@@ -0,0 +1,423 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package bytestreams implements data transfer with XEP-0065: SOCKS5
+// Bytestreams.
+//
+// SOCKS5 bytestreams (S5B) are a bidirectional data transfer mechanism where
+// the data is sent over a TCP connection instead of over the XMPP session.
+// The entity that requests a bytestream offers a list of streamhosts: itself,
+// if it can accept direct connections, and any proxies that it knows about.
+// The target connects to the first streamhost that it can reach and the
+// requester either uses the connection directly or activates the bytestream
+// on the proxy.
+// Because the data is not base64 encoded and does not pass through the XMPP
+// server, bytestreams are much faster than IBB (see the ibb package), which
+// should be used as a fallback if no streamhost can be reached.
+package bytestreams // import "github.com/kamrankamilli/xmpp/bytestreams"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/internal/socks5"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// NS is the XML namespace used by SOCKS5 bytestreams, provided as a
+// convenience.
+const NS = `http://jabber.org/protocol/bytestreams`
+
+// DefaultTimeout is the time that the default dialer waits for a connection to
+// a streamhost.
+const DefaultTimeout = 10 * time.Second
+
+// ErrNoStreamhost is returned when none of the streamhosts could be used to
+// establish a bytestream.
+var ErrNoStreamhost = errors.New("bytestreams: no streamhost could be connected")
+
+// Handle is an option that registers a handler for all the correct stanza
+// types and payloads.
+func Handle(h *Handler) mux.Option {
+	q := xml.Name{Space: NS, Local: "query"}
+	return func(m *mux.ServeMux) {
+		mux.IQ(stanza.SetIQ, q, h)(m)
+		mux.IQ(stanza.GetIQ, q, h)(m)
+	}
+}
+
+// Handler is an xmpp.Handler that negotiates bytestreams.
+type Handler struct {
+	// Addr is the TCP address that is listened on for direct connections from
+	// targets, for example "127.0.0.1:0".
+	// The host is offered to targets as a streamhost so it must be reachable by
+	// them.
+	// If Addr is empty, only proxies are offered.
+	Addr string
+
+	// Proxies are offered to targets after the direct streamhost, if any.
+	// They can be discovered using FetchProxies.
+	Proxies []Streamhost
+
+	// Dialer is used to connect to streamhosts.
+	// Incoming requests are not answered until a streamhost has been connected
+	// to, so it should have a short timeout.
+	// If it is nil a net.Dialer with a timeout of DefaultTimeout is used.
+	Dialer *net.Dialer
+
+	// Proxy, if set, makes the handler act as a proxy for other entities.
+	Proxy *Proxy
+
+	l  map[string]*Listener
+	lM sync.Mutex
+
+	mu      sync.Mutex
+	ln      net.Listener
+	pending map[string]chan net.Conn
+}
+
+// Listen creates a listener that accepts incoming bytestream requests.
+//
+// If a listener has already been created for the given session it is returned
+// unaltered.
+func (h *Handler) Listen(s *xmpp.Session) *Listener {
+	addrStr := s.LocalAddr().String()
+	h.lM.Lock()
+	defer h.lM.Unlock()
+	if h.l == nil {
+		h.l = make(map[string]*Listener)
+	}
+	l, ok := h.l[addrStr]
+	if ok {
+		return l
+	}
+	l = &Listener{
+		s:      s,
+		h:      h,
+		c:      make(chan *Conn),
+		closed: make(chan struct{}),
+	}
+	h.l[addrStr] = l
+	return l
+}
+
+// Close stops listening for direct connections.
+// Already established bytestreams are not closed.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ln == nil {
+		return nil
+	}
+	err := h.ln.Close()
+	h.ln = nil
+	return err
+}
+
+func (h *Handler) dialer() *net.Dialer {
+	if h.Dialer == nil {
+		return &net.Dialer{Timeout: DefaultTimeout}
+	}
+	return h.Dialer
+}
+
+// ForFeatures implements info.FeatureIter.
+func (h *Handler) ForFeatures(node string, f func(info.Feature) error) error {
+	if node != "" {
+		return nil
+	}
+	return f(info.Feature{Var: NS})
+}
+
+// ForIdentities implements info.IdentityIter.
+// Proxies are advertised using the "proxy/bytestreams" identity.
+func (h *Handler) ForIdentities(node string, f func(info.Identity) error) error {
+	if node != "" || h.Proxy == nil {
+		return nil
+	}
+	return f(disco.ProxyBytestreams)
+}
+
+// HandleIQ implements mux.IQHandler.
+func (h *Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	q := query{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&q)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case iq.Type == stanza.GetIQ && h.Proxy != nil:
+		_, err = xmlstream.Copy(t, iq.Result(wrapQuery(h.Proxy.streamhost(iq.To).TokenReader(), "")))
+		return err
+	case iq.Type == stanza.SetIQ && q.Activate != nil && h.Proxy != nil:
+		err = h.Proxy.activate(q.SID, iq.From, *q.Activate)
+		if err != nil {
+			_, err = xmlstream.Copy(t, iq.Error(stanza.Error{
+				Type:      stanza.Cancel,
+				Condition: stanza.ItemNotFound,
+			}))
+			return err
+		}
+		_, err = xmlstream.Copy(t, iq.Result(nil))
+		return err
+	case iq.Type == stanza.SetIQ && q.SID != "" && len(q.Streamhosts) > 0:
+		return h.handleRequest(iq, q, t)
+	case iq.Type == stanza.GetIQ:
+		_, err = xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.ServiceUnavailable,
+		}))
+		return err
+	}
+	_, err = xmlstream.Copy(t, iq.Error(stanza.Error{
+		Type:      stanza.Cancel,
+		Condition: stanza.BadRequest,
+	}))
+	return err
+}
+
+// handleRequest tries to connect to the streamhosts offered by a requester.
+// The response must be sent once the connection is established, so the session
+// is not read from until then.
+func (h *Handler) handleRequest(iq stanza.IQ, q query, e xmlstream.Encoder) error {
+	if q.Mode != "" && q.Mode != "tcp" {
+		_, err := xmlstream.Copy(e, iq.Error(stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.FeatureNotImplemented,
+		}))
+		return err
+	}
+	h.lM.Lock()
+	l, ok := h.l[iq.To.String()]
+	if !ok {
+		l, ok = h.l[""]
+	}
+	h.lM.Unlock()
+	if !ok {
+		_, err := xmlstream.Copy(e, iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.NotAcceptable,
+		}))
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-l.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	dstAddr := socks5.DstAddr(q.SID, iq.From.String(), l.s.LocalAddr().String())
+	for _, host := range q.Streamhosts {
+		conn, err := h.dial(ctx, host, dstAddr)
+		if err != nil {
+			continue
+		}
+		_, err = xmlstream.Copy(e, iq.Result(usedPayload(q.SID, host.JID)))
+		if err != nil {
+			/* #nosec */
+			conn.Close()
+			return err
+		}
+		// Delivering blocks until the stream is accepted, which may not happen
+		// until the requester has received the response.
+		go l.deliver(iq.From, &Conn{
+			conn:       conn,
+			sid:        q.SID,
+			local:      l.s.LocalAddr(),
+			remote:     iq.From,
+			streamhost: host.JID,
+		})
+		return nil
+	}
+	_, err := xmlstream.Copy(e, iq.Error(stanza.Error{
+		Type:      stanza.Cancel,
+		Condition: stanza.ItemNotFound,
+	}))
+	return err
+}
+
+// dial connects to a streamhost and performs the SOCKS5 handshake.
+func (h *Handler) dial(ctx context.Context, host Streamhost, dstAddr string) (net.Conn, error) {
+	conn, err := h.dialer().DialContext(ctx, "tcp", host.Addr())
+	if err != nil {
+		return nil, err
+	}
+	err = socks5.Connect(conn, dstAddr)
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// listen starts listening for direct connections if it has not already been
+// done and returns the streamhost that targets should connect to.
+func (h *Handler) listen(local jid.JID) (Streamhost, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Addr == "" {
+		return Streamhost{}, false, nil
+	}
+	if h.ln == nil {
+		ln, err := net.Listen("tcp", h.Addr)
+		if err != nil {
+			return Streamhost{}, false, err
+		}
+		h.ln = ln
+		go h.serve(ln)
+	}
+	host, port, err := net.SplitHostPort(h.ln.Addr().String())
+	if err != nil {
+		return Streamhost{}, false, err
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return Streamhost{}, false, err
+	}
+	return Streamhost{JID: local, Host: host, Port: uint16(portNum)}, true, nil
+}
+
+// serve accepts direct connections from targets and matches them to pending
+// requests by their destination address.
+func (h *Handler) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			addr, err := socks5.ReadRequest(conn)
+			if err != nil {
+				/* #nosec */
+				conn.Close()
+				return
+			}
+			h.mu.Lock()
+			c, ok := h.pending[addr]
+			delete(h.pending, addr)
+			h.mu.Unlock()
+			err = socks5.WriteReply(conn, addr, ok)
+			if err != nil || !ok {
+				/* #nosec */
+				conn.Close()
+				return
+			}
+			c <- conn
+		}()
+	}
+}
+
+func (h *Handler) expect(dstAddr string) chan net.Conn {
+	c := make(chan net.Conn, 1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pending == nil {
+		h.pending = make(map[string]chan net.Conn)
+	}
+	h.pending[dstAddr] = c
+	return c
+}
+
+func (h *Handler) unexpect(dstAddr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.pending, dstAddr)
+}
+
+// Open attempts to create a new bytestream with the provided entity.
+func (h *Handler) Open(ctx context.Context, s *xmpp.Session, to jid.JID) (*Conn, error) {
+	return h.OpenIQ(ctx, stanza.IQ{To: to}, s, attr.RandomID())
+}
+
+// OpenIQ is like Open except that it allows you to customize the IQ and the
+// session ID, for example when the session ID was negotiated out-of-band.
+// Changing the type of the provided IQ has no effect.
+func (h *Handler) OpenIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session, sid string) (*Conn, error) {
+	iq.Type = stanza.SetIQ
+	local := s.LocalAddr()
+	if !iq.From.Equal(jid.JID{}) {
+		local = iq.From
+	}
+	dstAddr := socks5.DstAddr(sid, local.String(), iq.To.String())
+
+	var hosts []Streamhost
+	direct, ok, err := h.listen(local)
+	if err != nil {
+		return nil, err
+	}
+	var inbound chan net.Conn
+	if ok {
+		hosts = append(hosts, direct)
+		inbound = h.expect(dstAddr)
+		defer h.unexpect(dstAddr)
+	}
+	hosts = append(hosts, h.Proxies...)
+	if len(hosts) == 0 {
+		return nil, ErrNoStreamhost
+	}
+
+	resp := query{}
+	err = s.UnmarshalIQElement(ctx, streamhostsPayload(sid, hosts), iq, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Used == nil {
+		return nil, ErrNoStreamhost
+	}
+	conn := &Conn{
+		sid:        sid,
+		local:      local,
+		remote:     iq.To,
+		streamhost: resp.Used.JID,
+	}
+
+	if ok && resp.Used.JID.Equal(direct.JID) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case conn.conn = <-inbound:
+			return conn, nil
+		}
+	}
+
+	for _, proxy := range h.Proxies {
+		if !proxy.JID.Equal(resp.Used.JID) {
+			continue
+		}
+		conn.conn, err = h.dial(ctx, proxy, dstAddr)
+		if err != nil {
+			return nil, err
+		}
+		err = s.UnmarshalIQElement(ctx, activatePayload(sid, iq.To), stanza.IQ{
+			Type: stanza.SetIQ,
+			To:   proxy.JID,
+		}, nil)
+		if err != nil {
+			/* #nosec */
+			conn.conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return nil, ErrNoStreamhost
+}
@@ -0,0 +1,199 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bytestreams_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/bytestreams"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+func TestEncodeStreamhost(t *testing.T) {
+	xmpptest.RunEncodingTests(t, []xmpptest.EncodingTestCase{
+		0: {
+			Value: &bytestreams.Streamhost{
+				JID:  jid.MustParse("proxy.example.net"),
+				Host: "192.0.2.1",
+				Port: 7777,
+			},
+			XML: `<streamhost xmlns="http://jabber.org/protocol/bytestreams" jid="proxy.example.net" host="192.0.2.1" port="7777"></streamhost>`,
+		},
+		1: {
+			Value: &bytestreams.Streamhost{
+				JID:  jid.MustParse("proxy.example.net"),
+				Host: "192.0.2.1",
+			},
+			XML: `<streamhost xmlns="http://jabber.org/protocol/bytestreams" jid="proxy.example.net" host="192.0.2.1"></streamhost>`,
+		},
+	})
+}
+
+func TestStreamhostAddr(t *testing.T) {
+	h := bytestreams.Streamhost{Host: "2001:db8::1"}
+	if addr := h.Addr(); addr != "[2001:db8::1]:1080" {
+		t.Errorf("wrong address: %q", addr)
+	}
+}
+
+// stamp sets the from attribute on stanzas like a server would do.
+func stamp(from jid.JID, h xmpp.Handler) xmpp.Handler {
+	return xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "from"}, Value: from.String()})
+		return h.HandleXMPP(t, start)
+	})
+}
+
+func newClientServer(client, server *bytestreams.Handler, opts ...mux.Option) *xmpptest.ClientServer {
+	return xmpptest.NewClientServer(
+		xmpptest.ClientHandler(stamp(jid.MustParse("example.net"), mux.New("", bytestreams.Handle(client)))),
+		xmpptest.ServerHandler(stamp(jid.MustParse("test@example.net"), mux.New(stanza.NSClient, append(opts, bytestreams.Handle(server))...))),
+	)
+}
+
+func transfer(t *testing.T, client *bytestreams.Handler, cs *xmpptest.ClientServer, l *bytestreams.Listener, sid string) *bytestreams.Conn {
+	t.Helper()
+	const payload = "Not all those who wander are lost."
+	ctx := context.Background()
+	recv := make(chan string, 1)
+	go func() {
+		defer close(recv)
+		var (
+			conn net.Conn
+			err  error
+		)
+		if sid == "" {
+			conn, err = l.Accept()
+		} else {
+			conn, err = l.Expect(ctx, cs.Client.LocalAddr(), sid)
+		}
+		if err != nil {
+			t.Errorf("error accepting bytestream: %v", err)
+			return
+		}
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			t.Errorf("error reading: %v", err)
+		}
+		recv <- string(b)
+	}()
+
+	var (
+		conn *bytestreams.Conn
+		err  error
+	)
+	if sid == "" {
+		conn, err = client.Open(ctx, cs.Client, cs.Server.LocalAddr())
+	} else {
+		conn, err = client.OpenIQ(ctx, stanza.IQ{To: cs.Server.LocalAddr()}, cs.Client, sid)
+	}
+	if err != nil {
+		t.Fatalf("error opening bytestream: %v", err)
+	}
+	_, err = io.WriteString(conn, payload)
+	if err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	err = conn.Close()
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	if got := <-recv; got != payload {
+		t.Errorf("wrong payload: want=%q, got=%q", payload, got)
+	}
+	return conn
+}
+
+func TestDirect(t *testing.T) {
+	client := &bytestreams.Handler{Addr: "127.0.0.1:0"}
+	defer client.Close()
+	server := &bytestreams.Handler{}
+	cs := newClientServer(client, server)
+	l := server.Listen(cs.Server)
+	defer l.Close()
+
+	conn := transfer(t, client, cs, l, "")
+	if !conn.Streamhost().Equal(cs.Client.LocalAddr()) {
+		t.Errorf("expected direct connection, got streamhost %v", conn.Streamhost())
+	}
+	if conn.SID() == "" {
+		t.Errorf("expected bytestream to have a session ID")
+	}
+}
+
+func TestProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	proxy := &bytestreams.Proxy{}
+	go func() {
+		/* #nosec */
+		proxy.Serve(ln)
+	}()
+
+	client := &bytestreams.Handler{}
+	server := &bytestreams.Handler{Proxy: proxy}
+	cs := newClientServer(client, server, disco.Handle())
+	l := server.Listen(cs.Server)
+	defer l.Close()
+
+	proxies, err := bytestreams.FetchProxies(context.Background(), cs.Client, cs.Server.LocalAddr())
+	if err != nil {
+		t.Fatalf("error discovering proxies: %v", err)
+	}
+	if len(proxies) != 1 || proxies[0].Addr() != ln.Addr().String() {
+		t.Fatalf("wrong proxies: %+v", proxies)
+	}
+	client.Proxies = proxies
+
+	conn := transfer(t, client, cs, l, "vxf9n471bn46")
+	if !conn.Streamhost().Equal(cs.Server.LocalAddr()) {
+		t.Errorf("expected proxied connection, got streamhost %v", conn.Streamhost())
+	}
+}
+
+func TestNoStreamhost(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	/* #nosec */
+	ln.Close()
+
+	client := &bytestreams.Handler{
+		Proxies: []bytestreams.Streamhost{{
+			JID:  jid.MustParse("proxy.example.net"),
+			Host: addr.IP.String(),
+			Port: uint16(addr.Port),
+		}},
+	}
+	server := &bytestreams.Handler{}
+	cs := newClientServer(client, server)
+	server.Listen(cs.Server)
+
+	_, err = client.Open(context.Background(), cs.Client, cs.Server.LocalAddr())
+	if !errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+		t.Errorf("wrong error: want=%v, got=%v", stanza.ItemNotFound, err)
+	}
+
+	_, err = (&bytestreams.Handler{}).Open(context.Background(), cs.Client, cs.Server.LocalAddr())
+	if !errors.Is(err, bytestreams.ErrNoStreamhost) {
+		t.Errorf("wrong error without streamhosts: want=%v, got=%v", bytestreams.ErrNoStreamhost, err)
+	}
+}
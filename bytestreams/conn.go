@@ -0,0 +1,77 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bytestreams
+
+import (
+	"net"
+	"time"
+
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// Conn is a SOCKS5 bytestream.
+// Unlike an IBB stream, data is sent directly over a TCP connection to the
+// peer or to a proxy that relays it to the peer.
+type Conn struct {
+	conn       net.Conn
+	sid        string
+	local      jid.JID
+	remote     jid.JID
+	streamhost jid.JID
+}
+
+// SID returns the session ID of the bytestream.
+func (c *Conn) SID() string {
+	return c.sid
+}
+
+// Streamhost returns the address of the streamhost that the bytestream was
+// established through.
+// If it is the address of either party the connection is direct, otherwise it
+// is the address of a proxy.
+func (c *Conn) Streamhost() jid.JID {
+	return c.streamhost
+}
+
+// Read reads data from the bytestream.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.conn.Read(b)
+}
+
+// Write writes data to the bytestream.
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.conn.Write(b)
+}
+
+// Close closes the bytestream.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local address of the underlying XMPP session.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline sets the read and write deadlines of the underlying TCP
+// connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying TCP connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying TCP connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,130 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bytestreams
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+var errClosed = errors.New("bytestreams: accept on closed listener")
+
+type expected struct {
+	c      chan *Conn
+	cancel context.CancelFunc
+}
+
+// Listener is an implementation of net.Listener that is used to accept
+// incoming bytestreams.
+type Listener struct {
+	s        *xmpp.Session
+	h        *Handler
+	c        chan *Conn
+	closed   chan struct{}
+	once     sync.Once
+	expected map[string]expected
+	eLock    sync.Mutex
+}
+
+// Accept waits for the next incoming bytestream and returns the connection.
+// If the listener is closed pending Accept calls unblock and return an error.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.c:
+		return conn, nil
+	case <-l.closed:
+		return nil, errClosed
+	}
+}
+
+// Expect is like Accept except that it accepts a specific bytestream that has
+// been negotiated out-of-band.
+// If Accept and Expect are both waiting on connections, Expect will take
+// precedence.
+// If Expect is called twice for the same session the original call will be
+// canceled and return a context error and the new Expect call will take over.
+func (l *Listener) Expect(ctx context.Context, from jid.JID, sid string) (net.Conn, error) {
+	l.eLock.Lock()
+	if l.expected == nil {
+		l.expected = make(map[string]expected)
+	}
+	key := from.String() + ":" + sid
+	e, ok := l.expected[key]
+	if ok {
+		e.cancel()
+	}
+	e.c = make(chan *Conn, 1)
+	ctx, cancel := context.WithCancel(ctx)
+	e.cancel = cancel
+	l.expected[key] = e
+	l.eLock.Unlock()
+
+	defer func() {
+		l.eLock.Lock()
+		defer l.eLock.Unlock()
+		if cur, ok := l.expected[key]; ok && cur.c == e.c {
+			delete(l.expected, key)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.closed:
+		return nil, errClosed
+	case conn := <-e.c:
+		return conn, nil
+	}
+}
+
+// deliver passes an established bytestream to a pending Expect call or to
+// Accept.
+// If the listener is closed before the stream is accepted it is closed.
+func (l *Listener) deliver(from jid.JID, conn *Conn) {
+	l.eLock.Lock()
+	key := from.String() + ":" + conn.sid
+	e, ok := l.expected[key]
+	if ok {
+		delete(l.expected, key)
+	}
+	l.eLock.Unlock()
+	if ok {
+		e.c <- conn
+		return
+	}
+	select {
+	case l.c <- conn:
+	case <-l.closed:
+		/* #nosec */
+		conn.Close()
+	}
+}
+
+// Close stops listening and causes any pending Accept calls to unblock and
+// return an error.
+// Already accepted connections are not closed.
+func (l *Listener) Close() error {
+	l.h.lM.Lock()
+	defer l.h.lM.Unlock()
+	addr := l.s.LocalAddr().String()
+	if l.h.l[addr] == l {
+		delete(l.h.l, addr)
+	}
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr returns the local address for which this listener is accepting
+// connections.
+func (l *Listener) Addr() net.Addr {
+	return l.s.LocalAddr()
+}
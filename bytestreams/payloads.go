@@ -0,0 +1,130 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bytestreams
+
+import (
+	"encoding/xml"
+	"net"
+	"strconv"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// DefaultPort is the port assumed for streamhosts that do not specify one.
+const DefaultPort = 1080
+
+// Streamhost is a host that a bytestream can be established through.
+// It is either the entity requesting the bytestream or a proxy.
+type Streamhost struct {
+	JID  jid.JID
+	Host string
+	Port uint16
+}
+
+// Addr returns the network address of the streamhost suitable for use with
+// net.Dial.
+func (h Streamhost) Addr() string {
+	port := h.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(h.Host, strconv.FormatUint(uint64(port), 10))
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (h Streamhost) TokenReader() xml.TokenReader {
+	attrs := []xml.Attr{
+		{Name: xml.Name{Local: "jid"}, Value: h.JID.String()},
+		{Name: xml.Name{Local: "host"}, Value: h.Host},
+	}
+	if h.Port != 0 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "port"}, Value: strconv.FormatUint(uint64(h.Port), 10)})
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "streamhost"},
+		Attr: attrs,
+	})
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (h Streamhost) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, h.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (h Streamhost) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := h.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (h *Streamhost) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	in := struct {
+		JID  jid.JID `xml:"jid,attr"`
+		Host string  `xml:"host,attr"`
+		Port uint16  `xml:"port,attr"`
+	}{}
+	err := d.DecodeElement(&in, &start)
+	if err != nil {
+		return err
+	}
+	h.JID = in.JID
+	h.Host = in.Host
+	h.Port = in.Port
+	return nil
+}
+
+// query is the payload of all bytestream IQs.
+type query struct {
+	XMLName     xml.Name     `xml:"http://jabber.org/protocol/bytestreams query"`
+	SID         string       `xml:"sid,attr"`
+	Mode        string       `xml:"mode,attr"`
+	Streamhosts []Streamhost `xml:"streamhost"`
+	Used        *struct {
+		JID jid.JID `xml:"jid,attr"`
+	} `xml:"streamhost-used"`
+	Activate *jid.JID `xml:"activate"`
+}
+
+func wrapQuery(payload xml.TokenReader, sid string) xml.TokenReader {
+	var attrs []xml.Attr
+	if sid != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "sid"}, Value: sid})
+	}
+	return xmlstream.Wrap(payload, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "query"},
+		Attr: attrs,
+	})
+}
+
+func streamhostsPayload(sid string, hosts []Streamhost) xml.TokenReader {
+	inner := make([]xml.TokenReader, 0, len(hosts))
+	for _, h := range hosts {
+		inner = append(inner, h.TokenReader())
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "query"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "sid"}, Value: sid},
+			{Name: xml.Name{Local: "mode"}, Value: "tcp"},
+		},
+	})
+}
+
+func usedPayload(sid string, used jid.JID) xml.TokenReader {
+	return wrapQuery(xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "streamhost-used"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: used.String()}},
+	}), sid)
+}
+
+func activatePayload(sid string, target jid.JID) xml.TokenReader {
+	return wrapQuery(xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(target.String())),
+		xml.StartElement{Name: xml.Name{Local: "activate"}},
+	), sid)
+}
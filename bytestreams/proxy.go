@@ -0,0 +1,196 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bytestreams
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/disco/items"
+	"github.com/kamrankamilli/xmpp/internal/socks5"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+var errNotConnected = errors.New("bytestreams: both parties must connect before activation")
+
+// Proxy relays bytestreams between entities that cannot connect to each other
+// directly.
+//
+// A proxy is served by setting it as the Proxy of a Handler, which answers
+// streamhost queries and activation requests, and calling Serve with the TCP
+// listener that the entities connect to.
+// It is minimal and does not limit who may use it.
+type Proxy struct {
+	// Host and Port are advertised to entities that query the proxy.
+	// If Host is empty the address of the listener passed to Serve is used.
+	Host string
+	Port uint16
+
+	mu      sync.Mutex
+	addr    net.Addr
+	pending map[string][]net.Conn
+}
+
+// Serve accepts connections on l until it is closed.
+func (p *Proxy) Serve(l net.Listener) error {
+	p.mu.Lock()
+	p.addr = l.Addr()
+	p.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handshake(conn)
+	}
+}
+
+func (p *Proxy) handshake(conn net.Conn) {
+	addr, err := socks5.ReadRequest(conn)
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		return
+	}
+	p.mu.Lock()
+	// The target and the requester use the same destination address, so at most
+	// two connections may be waiting for activation.
+	ok := len(p.pending[addr]) < 2
+	if ok {
+		if p.pending == nil {
+			p.pending = make(map[string][]net.Conn)
+		}
+		p.pending[addr] = append(p.pending[addr], conn)
+	}
+	p.mu.Unlock()
+	err = socks5.WriteReply(conn, addr, ok)
+	if err != nil || !ok {
+		/* #nosec */
+		conn.Close()
+	}
+}
+
+func (p *Proxy) streamhost(self jid.JID) Streamhost {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := Streamhost{JID: self, Host: p.Host, Port: p.Port}
+	if h.Host == "" && p.addr != nil {
+		host, port, err := net.SplitHostPort(p.addr.String())
+		if err == nil {
+			portNum, _ := strconv.ParseUint(port, 10, 16)
+			h.Host = host
+			h.Port = uint16(portNum)
+		}
+	}
+	return h
+}
+
+// activate starts relaying data between the requester and the target.
+func (p *Proxy) activate(sid string, requester, target jid.JID) error {
+	addr := socks5.DstAddr(sid, requester.String(), target.String())
+	p.mu.Lock()
+	conns := p.pending[addr]
+	if len(conns) == 2 {
+		delete(p.pending, addr)
+	}
+	p.mu.Unlock()
+	if len(conns) != 2 {
+		return errNotConnected
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go relay(&wg, conns[0], conns[1])
+	go relay(&wg, conns[1], conns[0])
+	go func() {
+		wg.Wait()
+		/* #nosec */
+		conns[0].Close()
+		/* #nosec */
+		conns[1].Close()
+	}()
+	return nil
+}
+
+// relay copies data from src to dst and signals the end of the data to dst.
+func relay(wg *sync.WaitGroup, dst, src net.Conn) {
+	defer wg.Done()
+	/* #nosec */
+	io.Copy(dst, src)
+	if c, ok := dst.(interface{ CloseWrite() error }); ok {
+		/* #nosec */
+		c.CloseWrite()
+		return
+	}
+	/* #nosec */
+	dst.Close()
+}
+
+// GetStreamhost asks a proxy for the streamhost that it can be reached on.
+func GetStreamhost(ctx context.Context, s *xmpp.Session, proxy jid.JID) (Streamhost, error) {
+	resp := query{}
+	err := s.UnmarshalIQElement(ctx, wrapQuery(nil, ""), stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   proxy,
+	}, &resp)
+	if err != nil {
+		return Streamhost{}, err
+	}
+	if len(resp.Streamhosts) == 0 {
+		return Streamhost{}, ErrNoStreamhost
+	}
+	h := resp.Streamhosts[0]
+	if h.JID.Equal(jid.JID{}) {
+		h.JID = proxy
+	}
+	return h, nil
+}
+
+// FetchProxies discovers proxies by checking the identity of the server and of
+// the items that it advertises, and returns their streamhosts.
+// The result is suitable for use as the Proxies of a Handler.
+func FetchProxies(ctx context.Context, s *xmpp.Session, server jid.JID) ([]Streamhost, error) {
+	iter := disco.FetchItems(ctx, items.Item{JID: server}, s)
+	candidates := []jid.JID{server}
+	for iter.Next() {
+		candidates = append(candidates, iter.Item().JID)
+	}
+	err := iter.Err()
+	if err != nil {
+		/* #nosec */
+		iter.Close()
+		return nil, err
+	}
+	err = iter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []Streamhost
+	for _, j := range candidates {
+		info, err := disco.GetInfo(ctx, "", j, s)
+		if err != nil {
+			continue
+		}
+		for _, ident := range info.Identity {
+			if ident.Category != disco.ProxyBytestreams.Category || ident.Type != disco.ProxyBytestreams.Type {
+				continue
+			}
+			h, err := GetStreamhost(ctx, s, j)
+			if err != nil {
+				break
+			}
+			hosts = append(hosts, h)
+			break
+		}
+	}
+	return hosts, nil
+}
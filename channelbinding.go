@@ -0,0 +1,256 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"strings"
+
+	"mellium.im/sasl"
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/internal/ns"
+	"github.com/kamrankamilli/xmpp/internal/scram"
+)
+
+// Channel binding types in order of preference.
+const (
+	cbTLSExporter       = "tls-exporter"
+	cbTLSServerEndPoint = "tls-server-end-point"
+	cbTLSUnique         = "tls-unique"
+)
+
+var errNoChannelBinding = errors.New("xmpp: channel binding type not supported by the connection")
+
+// channelBindings returns the channel binding types that can be used on the
+// current TLS connection in order of preference.
+func (s *Session) channelBindings() []string {
+	cs := s.ConnectionState()
+	if cs.Version == 0 {
+		return nil
+	}
+	var types []string
+	for _, typ := range []string{cbTLSExporter, cbTLSServerEndPoint, cbTLSUnique} {
+		if _, err := s.channelBindingData(typ); err == nil {
+			types = append(types, typ)
+		}
+	}
+	return types
+}
+
+// channelBindingData returns the channel binding data of the given type for the
+// current TLS connection.
+func (s *Session) channelBindingData(typ string) ([]byte, error) {
+	cs := s.ConnectionState()
+	if cs.Version == 0 {
+		return nil, errNoChannelBinding
+	}
+	switch typ {
+	case cbTLSExporter:
+		// RFC 9266 §3: tls-exporter must not be used with TLS 1.2 unless the
+		// extended master secret extension was negotiated, in which case the TLS
+		// package will refuse to export keying material.
+		return cs.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32)
+	case cbTLSServerEndPoint:
+		var cert *x509.Certificate
+		if s.State()&Received == Received {
+			if s.tlsCert == nil || len(s.tlsCert.Certificate) == 0 {
+				return nil, errNoChannelBinding
+			}
+			cert = s.tlsCert.Leaf
+			if cert == nil {
+				var err error
+				cert, err = x509.ParseCertificate(s.tlsCert.Certificate[0])
+				if err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			if len(cs.PeerCertificates) == 0 {
+				return nil, errNoChannelBinding
+			}
+			cert = cs.PeerCertificates[0]
+		}
+		return serverEndPoint(cert), nil
+	case cbTLSUnique:
+		if cs.Version >= tls.VersionTLS13 || len(cs.TLSUnique) == 0 {
+			return nil, errNoChannelBinding
+		}
+		return cs.TLSUnique, nil
+	}
+	return nil, errNoChannelBinding
+}
+
+// serverEndPoint returns the tls-server-end-point channel binding data for
+// cert as defined in RFC 5929 §4.1.
+func serverEndPoint(cert *x509.Certificate) []byte {
+	h := crypto.SHA256
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		h = crypto.SHA384
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		h = crypto.SHA512
+	}
+	hash := h.New()
+	/* #nosec */
+	hash.Write(cert.Raw)
+	return hash.Sum(nil)
+}
+
+// clientBinding returns the channel binding type that the client should use,
+// or the empty string if channel binding is not possible.
+// If the server advertised its supported channel binding types the first
+// mutually supported type is used, otherwise we fall back to the default type
+// for the TLS version.
+func (s *Session) clientBinding() string {
+	local := s.channelBindings()
+	remote, advertised := s.features[ns.SASLCB].([]string)
+	if !advertised {
+		remote = []string{cbTLSUnique}
+		if s.ConnectionState().Version >= tls.VersionTLS13 {
+			remote = []string{cbTLSExporter}
+		}
+	}
+	for _, typ := range local {
+		for _, r := range remote {
+			if typ == r {
+				return typ
+			}
+		}
+	}
+	return ""
+}
+
+// clientMechanism returns the mechanism that should be used by a client to
+// authenticate with m given the mechanisms advertised by the server.
+// SCRAM mechanisms are replaced by an implementation that supports all of our
+// channel binding types and downgrade protection.
+// If m requires channel binding but no channel binding type can be used, ok is
+// false.
+func clientMechanism(s *Session, m sasl.Mechanism, remote []string) (mech sasl.Mechanism, ok bool) {
+	fn, isSCRAM := scram.Hash(m.Name)
+	binding := s.clientBinding()
+	if strings.HasSuffix(m.Name, "-PLUS") && binding == "" {
+		return m, false
+	}
+	if !isSCRAM {
+		return m, true
+	}
+	bindings, _ := s.features[ns.SASLCB].([]string)
+	return scram.Mechanism(m.Name, fn, scram.Config{
+		Binding:    binding,
+		Data:       s.channelBindingData,
+		Mechanisms: remote,
+		Bindings:   bindings,
+	}), true
+}
+
+// serverMechanism is like clientMechanism except that it returns the mechanism
+// used by the server when the client selects m from the advertised mechanisms.
+func serverMechanism(s *Session, m sasl.Mechanism, advertised []sasl.Mechanism) sasl.Mechanism {
+	fn, ok := scram.Hash(m.Name)
+	if !ok {
+		return m
+	}
+	names := make([]string, 0, len(advertised))
+	for _, a := range advertised {
+		names = append(names, a.Name)
+	}
+	return scram.Mechanism(m.Name, fn, scram.Config{
+		Data:       s.channelBindingData,
+		Mechanisms: names,
+		Bindings:   s.channelBindings(),
+	})
+}
+
+// writeChannelBindings advertises the channel binding types supported on the
+// current connection as defined in XEP-0440: SASL Channel-Binding Type
+// Capability.
+func writeChannelBindings(s *Session, w xmlstream.TokenWriter) error {
+	types := s.channelBindings()
+	if len(types) == 0 {
+		return nil
+	}
+	var inner []xml.TokenReader
+	for _, typ := range types {
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "channel-binding"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "type"}, Value: typ}},
+		}))
+	}
+	_, err := xmlstream.Copy(w, xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: ns.SASLCB, Local: "sasl-channel-binding"}},
+	))
+	return err
+}
+
+// parseChannelBindings decodes the channel binding types advertised by the
+// server.
+func parseChannelBindings(d *xml.Decoder, start *xml.StartElement) ([]string, error) {
+	parsed := struct {
+		Types []struct {
+			Type string `xml:"type,attr"`
+		} `xml:"urn:xmpp:sasl-cb:0 channel-binding"`
+	}{}
+	err := d.DecodeElement(&parsed, start)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, 0, len(parsed.Types))
+	for _, t := range parsed.Types {
+		types = append(types, t.Type)
+	}
+	return types, nil
+}
+
+// recordCertificate returns a copy of cfg that calls record with the
+// certificate that is presented to clients during the handshake so that it can
+// be used for tls-server-end-point channel binding.
+func recordCertificate(cfg *tls.Config, record func(*tls.Certificate)) *tls.Config {
+	getCert := func(cfg *tls.Config, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if cfg.GetCertificate != nil {
+			cert, err := cfg.GetCertificate(hello)
+			if cert != nil || err != nil {
+				return cert, err
+			}
+		}
+		if len(cfg.Certificates) == 0 {
+			return nil, errors.New("xmpp: no certificates configured")
+		}
+		for i := range cfg.Certificates {
+			if hello.SupportsCertificate(&cfg.Certificates[i]) == nil {
+				return &cfg.Certificates[i], nil
+			}
+		}
+		return &cfg.Certificates[0], nil
+	}
+	wrap := func(cfg *tls.Config) *tls.Config {
+		orig := cfg.Clone()
+		cfg = cfg.Clone()
+		cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := getCert(orig, hello)
+			if cert != nil {
+				record(cert)
+			}
+			return cert, err
+		}
+		return cfg
+	}
+	wrapped := wrap(cfg)
+	if cfg.GetConfigForClient != nil {
+		wrapped.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := cfg.GetConfigForClient(hello)
+			if c == nil || err != nil {
+				return c, err
+			}
+			return wrap(c), nil
+		}
+	}
+	return wrapped
+}
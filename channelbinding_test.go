@@ -0,0 +1,105 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/sasl"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// tlsConfigs returns TLS configs for a server with a self signed certificate
+// for example.net and a client that trusts it.
+func tlsConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.net"},
+		DNSNames:     []string{"example.net"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}
+	client = &tls.Config{
+		RootCAs:    pool,
+		ServerName: "example.net",
+		MinVersion: tls.VersionTLS12,
+	}
+	return server, client
+}
+
+func TestChannelBindingAdvertised(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverCfg, clientCfg := tlsConfigs(t)
+	clientConn, serverConn := smPipe(t)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := xmpp.ReceiveSession(ctx, serverConn, 0, smNegotiator(
+			xmpp.StartTLS(serverCfg),
+			xmpp.SASLServer(allowPlain, sasl.ScramSha256Plus, sasl.Plain),
+			xmpp.BindResource(),
+		))
+		errs <- err
+	}()
+
+	in := &bytes.Buffer{}
+	client, err := xmpp.NewSession(ctx, jid.MustParse("example.net"), jid.MustParse("test@example.net"), clientConn, 0, xmpp.NewNegotiator(func(*xmpp.Session, *xmpp.StreamConfig) xmpp.StreamConfig {
+		return xmpp.StreamConfig{
+			Features: []xmpp.StreamFeature{
+				xmpp.StartTLS(clientCfg),
+				xmpp.SASL("", "pass", sasl.Plain),
+				xmpp.BindResource(),
+			},
+			TeeIn: in,
+		}
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating client session: %v", err)
+	}
+	defer client.Close()
+	if err = <-errs; err != nil {
+		t.Fatalf("error negotiating server session: %v", err)
+	}
+	if v := client.ConnectionState().Version; v != tls.VersionTLS13 {
+		t.Fatalf("expected TLS 1.3 to be negotiated, got %x", v)
+	}
+
+	const want = `<sasl-channel-binding xmlns="urn:xmpp:sasl-cb:0"><channel-binding type="tls-exporter"></channel-binding><channel-binding type="tls-server-end-point"></channel-binding></sasl-channel-binding>`
+	if !strings.Contains(in.String(), want) {
+		t.Errorf("expected channel binding types to be advertised:\nwant=%s\n got=%s", want, in)
+	}
+}
@@ -0,0 +1,471 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"encoding/xml"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/disco/items"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// DefaultTimeout is the amount of time after which an idle session is expired
+// if no other timeout is set on the Provider.
+const DefaultTimeout = 10 * time.Minute
+
+// A list of the possible statuses of a command.
+const (
+	StatusExecuting = "executing"
+	StatusCompleted = "completed"
+	StatusCanceled  = "canceled"
+)
+
+// condError is a stanza error with an additional commands specific condition.
+type condError struct {
+	err  stanza.Error
+	cond string
+}
+
+func (e condError) Error() string {
+	return e.err.Error()
+}
+
+func (e condError) Unwrap() error {
+	return e.err
+}
+
+func (e condError) TokenReader() xml.TokenReader {
+	return e.err.Wrap(xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: e.cond},
+	}))
+}
+
+var (
+	errMalformedAction = condError{err: stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}, cond: "malformed-action"}
+	errBadAction       = condError{err: stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}, cond: "bad-action"}
+	errBadPayload      = condError{err: stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}, cond: "bad-payload"}
+	errBadSessionID    = condError{err: stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}, cond: "bad-sessionid"}
+	errSessionExpired  = condError{err: stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAllowed}, cond: "session-expired"}
+	errForbidden       = stanza.Error{Type: stanza.Cancel, Condition: stanza.Forbidden}
+	errItemNotFound    = stanza.Error{Type: stanza.Cancel, Condition: stanza.ItemNotFound}
+)
+
+// Session is the state of a command that is being executed.
+type Session struct {
+	ID   string
+	Node string
+	From jid.JID
+
+	// Stage starts at 0 when the command is first executed and is incremented or
+	// decremented when the requester moves to the next or previous stage.
+	Stage int
+
+	// Data may be set by handlers to keep state between stages.
+	Data interface{}
+
+	actions Actions
+	last    time.Time
+}
+
+// Request is a single stage of a command being executed.
+type Request struct {
+	*Session
+
+	// Action is one of "execute", "next", "prev", "complete", or "cancel".
+	// If the requester used the "execute" action on a later stage, Action is set
+	// to the default action of that stage.
+	Action string
+
+	// Form is the data form submitted by the requester, if any.
+	Form *form.Data
+}
+
+// Result is the response to a single stage of a command.
+type Result struct {
+	// Actions are the actions that the requester may take next.
+	// If no actions are set the command is completed and the session ends.
+	Actions Actions
+
+	// Form, if set, is included in the response.
+	Form *form.Data
+
+	// Notes are included in the response.
+	Notes []Note
+}
+
+// Handler responds to the stages of a command.
+//
+// If an error is returned the session ends.
+// If the error is a stanza.Error it is returned to the requester, otherwise an
+// internal-server-error is returned.
+// When the requester cancels the command the handler is called so that any
+// state can be cleaned up but the result is ignored.
+type Handler interface {
+	HandleCommand(req Request) (Result, error)
+}
+
+// The HandlerFunc type is an adapter to allow the use of ordinary functions as
+// command handlers.
+type HandlerFunc func(req Request) (Result, error)
+
+// HandleCommand calls f(req).
+func (f HandlerFunc) HandleCommand(req Request) (Result, error) {
+	return f(req)
+}
+
+// Node is a command that can be registered with a Provider.
+type Node struct {
+	// Node uniquely identifies the command.
+	Node string
+
+	// Name is the human readable name of the command.
+	Name string
+
+	// Handler is called for each stage of the command.
+	Handler Handler
+
+	// Allow reports whether the requester may execute the command.
+	// If Allow is nil, any entity may execute the command.
+	Allow func(from jid.JID) bool
+}
+
+// AllowJIDs returns a function that can be used as the Allow field of a Node
+// that allows any resource of the provided bare JIDs to execute the command.
+func AllowJIDs(j ...jid.JID) func(jid.JID) bool {
+	allowed := make(map[string]struct{}, len(j))
+	for _, addr := range j {
+		allowed[addr.Bare().String()] = struct{}{}
+	}
+	return func(from jid.JID) bool {
+		_, ok := allowed[from.Bare().String()]
+		return ok
+	}
+}
+
+// Handle returns an option that registers a Provider to execute commands.
+// The provider also advertises the commands over service discovery if
+// disco.Handle is registered on the same mux.
+func Handle(p *Provider) mux.Option {
+	return mux.IQ(stanza.SetIQ, xml.Name{Space: NS, Local: "command"}, p)
+}
+
+// Provider is a registry of commands that can be executed by other entities.
+//
+// Commands are listed on the commands node using disco#items regardless of
+// whether the requesting entity is allowed to execute them.
+type Provider struct {
+	// JID is the address of the entity providing the commands.
+	// It is used when listing the commands.
+	JID jid.JID
+
+	// Timeout is the amount of time after which an idle session is expired.
+	// If it is zero, DefaultTimeout is used.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	nodes    map[string]Node
+	sessions map[string]*Session
+}
+
+// Register adds a command to the provider, replacing any existing command with
+// the same node.
+func (p *Provider) Register(n Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.nodes == nil {
+		p.nodes = make(map[string]Node)
+	}
+	p.nodes[n.Node] = n
+}
+
+// Unregister removes a command and ends any of its sessions.
+func (p *Provider) Unregister(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.nodes, node)
+	for id, session := range p.sessions {
+		if session.Node == node {
+			delete(p.sessions, id)
+		}
+	}
+}
+
+// ForItems implements items.Iter.
+func (p *Provider) ForItems(node string, f func(items.Item) error) error {
+	if node != NS {
+		return nil
+	}
+	p.mu.Lock()
+	list := make([]Node, 0, len(p.nodes))
+	for _, n := range p.nodes {
+		list = append(list, n)
+	}
+	p.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Node < list[j].Node
+	})
+	for _, n := range list {
+		err := f(items.Item{
+			JID:  p.JID,
+			Node: n.Node,
+			Name: n.Name,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForFeatures implements info.FeatureIter.
+func (p *Provider) ForFeatures(node string, f func(info.Feature) error) error {
+	switch {
+	case node == "":
+		return f(Feature)
+	case p.registered(node):
+		err := f(Feature)
+		if err != nil {
+			return err
+		}
+		return f(info.Feature{Var: form.NS})
+	}
+	return nil
+}
+
+// ForIdentities implements info.IdentityIter.
+func (p *Provider) ForIdentities(node string, f func(info.Identity) error) error {
+	switch {
+	case node == NS:
+		return f(disco.AutomationCommandList)
+	case p.registered(node):
+		p.mu.Lock()
+		ident := disco.AutomationCommandNode
+		ident.Name = p.nodes[node].Name
+		p.mu.Unlock()
+		return f(ident)
+	}
+	return nil
+}
+
+func (p *Provider) registered(node string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.nodes[node]
+	return ok
+}
+
+// HandleIQ satisfies mux.IQHandler.
+// It should generally be registered on a mux using Handle.
+func (p *Provider) HandleIQ(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	resp, err := p.execute(iq, r, start)
+	if err != nil {
+		var condErr condError
+		stanzaErr := stanza.Error{}
+		var payload xml.TokenReader
+		switch {
+		case errors.As(err, &condErr):
+			payload = condErr.TokenReader()
+		case errors.As(err, &stanzaErr):
+			payload = stanzaErr.TokenReader()
+		default:
+			payload = stanza.Error{
+				Type:      stanza.Wait,
+				Condition: stanza.InternalServerError,
+			}.TokenReader()
+		}
+		iq.Type = stanza.ErrorIQ
+		iq.From, iq.To = iq.To, iq.From
+		_, err = xmlstream.Copy(r, iq.Wrap(payload))
+		return err
+	}
+	_, err = xmlstream.Copy(r, iq.Result(resp))
+	return err
+}
+
+func (p *Provider) execute(iq stanza.IQ, r xml.TokenReader, start *xml.StartElement) (xml.TokenReader, error) {
+	cmd := struct {
+		Node   string     `xml:"node,attr"`
+		SID    string     `xml:"sessionid,attr"`
+		Action string     `xml:"action,attr"`
+		Form   *form.Data `xml:"jabber:x:data x"`
+	}{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&cmd)
+	if err != nil {
+		return nil, errBadPayload
+	}
+	switch cmd.Action {
+	case "":
+		cmd.Action = "execute"
+	case "execute", "next", "prev", "complete", "cancel":
+	default:
+		return nil, errMalformedAction
+	}
+
+	p.mu.Lock()
+	n, ok := p.nodes[cmd.Node]
+	if !ok {
+		p.mu.Unlock()
+		return nil, errItemNotFound
+	}
+	if n.Allow != nil && !n.Allow(iq.From) {
+		p.mu.Unlock()
+		return nil, errForbidden
+	}
+	session, err := p.session(iq.From, cmd.Node, cmd.SID, cmd.Action)
+	p.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	action := cmd.Action
+	if cmd.SID != "" && action == "execute" {
+		action = defaultAction(session.actions)
+	}
+	switch action {
+	case "next":
+		session.Stage++
+	case "prev":
+		if session.Stage > 0 {
+			session.Stage--
+		}
+	}
+
+	result, err := n.Handler.HandleCommand(Request{
+		Session: session,
+		Action:  action,
+		Form:    cmd.Form,
+	})
+	status := StatusExecuting
+	switch {
+	case action == "cancel":
+		status = StatusCanceled
+		result = Result{}
+		err = nil
+	case err == nil && result.Actions&^Execute == 0:
+		status = StatusCompleted
+	}
+	p.mu.Lock()
+	if err != nil || status != StatusExecuting {
+		delete(p.sessions, session.ID)
+	} else {
+		session.actions = result.Actions
+		session.last = time.Now()
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var payload []xml.TokenReader
+	if status == StatusExecuting {
+		payload = append(payload, result.Actions.TokenReader())
+	}
+	for _, note := range result.Notes {
+		payload = append(payload, note.TokenReader())
+	}
+	if result.Form != nil {
+		payload = append(payload, result.Form.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(payload...),
+		xml.StartElement{
+			Name: xml.Name{Space: NS, Local: "command"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "node"}, Value: cmd.Node},
+				{Name: xml.Name{Local: "sessionid"}, Value: session.ID},
+				{Name: xml.Name{Local: "status"}, Value: status},
+			},
+		},
+	), nil
+}
+
+// session creates a new session or looks up an existing one and checks that
+// the action is allowed.
+// It must be called with the lock held.
+func (p *Provider) session(from jid.JID, node, id, action string) (*Session, error) {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	now := time.Now()
+
+	if id == "" {
+		if action != "execute" {
+			return nil, errBadAction
+		}
+		// Expire old sessions before creating new ones so that abandoned sessions
+		// do not accumulate.
+		for sid, session := range p.sessions {
+			if now.Sub(session.last) > timeout {
+				delete(p.sessions, sid)
+			}
+		}
+		if p.sessions == nil {
+			p.sessions = make(map[string]*Session)
+		}
+		session := &Session{
+			ID:   attr.RandomID(),
+			Node: node,
+			From: from,
+			last: now,
+		}
+		p.sessions[session.ID] = session
+		return session, nil
+	}
+
+	session, ok := p.sessions[id]
+	if !ok || session.Node != node || !session.From.Equal(from) {
+		return nil, errBadSessionID
+	}
+	if now.Sub(session.last) > timeout {
+		delete(p.sessions, id)
+		return nil, errSessionExpired
+	}
+	switch action {
+	case "cancel":
+	case "execute":
+		if defaultAction(session.actions) == "" {
+			return nil, errBadAction
+		}
+	default:
+		var allowed bool
+		for a := Prev; a <= Complete; a <<= 1 {
+			if session.actions&a != 0 && a.String() == action {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, errBadAction
+		}
+	}
+	return session, nil
+}
+
+// defaultAction returns the action that is taken if the requester uses the
+// execute action.
+// If no default is set, next is used if it is allowed and complete otherwise.
+func defaultAction(a Actions) string {
+	switch execute := (a & Execute) >> 3; {
+	case execute == Prev, execute == Next, execute == Complete:
+		return execute.String()
+	case a&Next != 0:
+		return Next.String()
+	case a&Complete != 0:
+		return Complete.String()
+	}
+	return ""
+}
@@ -0,0 +1,234 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package commands_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/commands"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+var (
+	service = jid.MustParse("bot@example.net/admin")
+	admin   = jid.MustParse("admin@example.net/laptop")
+	mallory = jid.MustParse("mallory@example.net/laptop")
+)
+
+type stage struct {
+	actions commands.Actions
+	notes   []string
+	fields  []string
+}
+
+// readStage decodes the payload of a command response.
+func readStage(t *testing.T, r xml.TokenReader) stage {
+	t.Helper()
+	var s stage
+	d := xml.NewTokenDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return s
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "actions":
+			err = d.DecodeElement(&s.actions, &start)
+		case "note":
+			note := commands.Note{}
+			err = d.DecodeElement(&note, &start)
+			s.notes = append(s.notes, note.Value)
+		case "x":
+			data := &form.Data{}
+			err = d.DecodeElement(data, &start)
+			data.ForFields(func(f form.FieldData) {
+				s.fields = append(s.fields, f.Var)
+			})
+		}
+		if err != nil {
+			t.Fatalf("error decoding payload: %v", err)
+		}
+	}
+}
+
+func execute(t *testing.T, ctx context.Context, c commands.Command, payload xml.TokenReader, s *xmpp.Session) (commands.Response, stage) {
+	t.Helper()
+	resp, r, err := c.Execute(ctx, payload, s)
+	if err != nil {
+		t.Fatalf("error executing %s %s: %v", c.Node, c.Action, err)
+	}
+	defer r.Close()
+	return resp, readStage(t, r)
+}
+
+// executeErr executes c and returns the error response, if any.
+// The request is sent directly instead of using Execute so that error responses
+// are always closed.
+func executeErr(ctx context.Context, c commands.Command, s *xmpp.Session) error {
+	return s.UnmarshalIQElement(ctx, c.TokenReader(), stanza.IQ{
+		To:   c.JID,
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+func TestProvider(t *testing.T) {
+	p := &commands.Provider{JID: service}
+	p.Register(commands.Node{
+		Node:  "reboot",
+		Name:  "Reboot",
+		Allow: commands.AllowJIDs(admin.Bare()),
+		Handler: commands.HandlerFunc(func(commands.Request) (commands.Result, error) {
+			return commands.Result{
+				Notes: []commands.Note{{Type: commands.NoteInfo, Value: "rebooting"}},
+			}, nil
+		}),
+	})
+	var stages []string
+	p.Register(commands.Node{
+		Node: "config",
+		Name: "Configure",
+		Handler: commands.HandlerFunc(func(req commands.Request) (commands.Result, error) {
+			stages = append(stages, req.Action)
+			if req.Action == "complete" {
+				name, _ := req.Form.GetString("name")
+				return commands.Result{
+					Notes: []commands.Note{{Type: commands.NoteInfo, Value: "saved " + name}},
+				}, nil
+			}
+			if req.Stage == 0 {
+				return commands.Result{
+					Actions: commands.Next | (commands.Next << 3),
+					Form:    form.New(form.Text("name")),
+				}, nil
+			}
+			return commands.Result{
+				Actions: commands.Prev | commands.Complete,
+				Form:    form.New(form.Boolean("confirm")),
+			}, nil
+		}),
+	})
+
+	var from atomic.Value
+	from.Store(admin)
+	serverMux := mux.New(stanza.NSClient, commands.Handle(p), disco.Handle())
+	cs := xmpptest.NewClientServer(
+		// Stamp the address of the client on stanzas like a server would do.
+		xmpptest.ServerHandler(xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "from"}, Value: from.Load().(jid.JID).String()})
+			return serverMux.HandleXMPP(t, start)
+		})),
+	)
+	ctx := context.Background()
+
+	// Commands are listed over service discovery.
+	var nodes []string
+	iter := commands.Fetch(ctx, service, cs.Client)
+	for iter.Next() {
+		cmd := iter.Command()
+		if !cmd.JID.Equal(service) {
+			t.Errorf("wrong command JID: want=%v, got=%v", service, cmd.JID)
+		}
+		nodes = append(nodes, cmd.Node+":"+cmd.Name)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error listing commands: %v", err)
+	}
+	/* #nosec */
+	iter.Close()
+	if want := []string{"config:Configure", "reboot:Reboot"}; !reflect.DeepEqual(nodes, want) {
+		t.Errorf("wrong commands: want=%v, got=%v", want, nodes)
+	}
+	info, err := disco.GetInfo(ctx, "config", service, cs.Client)
+	if err != nil {
+		t.Fatalf("error fetching command info: %v", err)
+	}
+	if len(info.Identity) != 1 || info.Identity[0].Type != "command-node" || info.Identity[0].Name != "Configure" {
+		t.Errorf("wrong command identity: %+v", info.Identity)
+	}
+
+	// Single stage commands complete immediately.
+	resp, s := execute(t, ctx, commands.Command{JID: service, Node: "reboot"}, nil, cs.Client)
+	if resp.Status != commands.StatusCompleted || !reflect.DeepEqual(s.notes, []string{"rebooting"}) {
+		t.Errorf("wrong response to single stage command: status=%q, notes=%v", resp.Status, s.notes)
+	}
+
+	// Multi-stage commands can move forward and back.
+	resp, s = execute(t, ctx, commands.Command{JID: service, Node: "config"}, nil, cs.Client)
+	if resp.Status != commands.StatusExecuting || resp.SID == "" {
+		t.Fatalf("wrong response to first stage: status=%q, sid=%q", resp.Status, resp.SID)
+	}
+	if s.actions != commands.Next|(commands.Next<<3) || !reflect.DeepEqual(s.fields, []string{"name"}) {
+		t.Errorf("wrong first stage: %+v", s)
+	}
+	data := form.New(form.Text("name"))
+	/* #nosec */
+	data.Set("name", "test")
+	submission, _ := data.Submit()
+	resp, s = execute(t, ctx, resp.Next(), submission, cs.Client)
+	if s.actions != commands.Prev|commands.Complete || !reflect.DeepEqual(s.fields, []string{"confirm"}) {
+		t.Errorf("wrong second stage: %+v", s)
+	}
+	resp, _ = execute(t, ctx, resp.Prev(), nil, cs.Client)
+	// Execute performs the default action of the stage.
+	exec := resp.Next()
+	exec.Action = "execute"
+	resp, _ = execute(t, ctx, exec, submission, cs.Client)
+	submission, _ = data.Submit()
+	resp, s = execute(t, ctx, resp.Complete(), submission, cs.Client)
+	if resp.Status != commands.StatusCompleted || !reflect.DeepEqual(s.notes, []string{"saved test"}) {
+		t.Errorf("wrong final stage: status=%q, notes=%v", resp.Status, s.notes)
+	}
+	if want := []string{"execute", "next", "prev", "next", "complete"}; !reflect.DeepEqual(stages, want) {
+		t.Errorf("wrong stages: want=%v, got=%v", want, stages)
+	}
+
+	// The session ends after the command completes.
+	err = executeErr(ctx, resp.Next(), cs.Client)
+	if stanzaErr := (stanza.Error{}); !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.BadRequest {
+		t.Errorf("wrong error executing ended session: %v", err)
+	}
+
+	// Sessions can be canceled.
+	resp, _ = execute(t, ctx, commands.Command{JID: service, Node: "config"}, nil, cs.Client)
+	resp, _ = execute(t, ctx, resp.Cancel(), nil, cs.Client)
+	if resp.Status != commands.StatusCanceled {
+		t.Errorf("wrong status after canceling: %q", resp.Status)
+	}
+
+	// Actions that were not offered are rejected.
+	resp, _ = execute(t, ctx, commands.Command{JID: service, Node: "config"}, nil, cs.Client)
+	err = executeErr(ctx, resp.Complete(), cs.Client)
+	if stanzaErr := (stanza.Error{}); !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.BadRequest {
+		t.Errorf("wrong error using bad action: %v", err)
+	}
+
+	// Access control is enforced by the requesting JID.
+	from.Store(mallory)
+	err = executeErr(ctx, commands.Command{JID: service, Node: "reboot"}, cs.Client)
+	if stanzaErr := (stanza.Error{}); !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.Forbidden {
+		t.Errorf("wrong error executing forbidden command: %v", err)
+	}
+	// Sessions cannot be used by other entities.
+	err = executeErr(ctx, resp.Next(), cs.Client)
+	if stanzaErr := (stanza.Error{}); !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.BadRequest {
+		t.Errorf("wrong error using session of other entity: %v", err)
+	}
+}
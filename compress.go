@@ -0,0 +1,214 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"compress/zlib"
+	"context"
+	"encoding/xml"
+	"io"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/internal/ns"
+)
+
+const compressZlib = "zlib"
+
+// CompressionConfig configures stream compression.
+type CompressionConfig struct {
+	// Level is the zlib compression level.
+	// If it is zero, zlib.DefaultCompression is used.
+	Level int
+
+	// DisableSecure prohibits compression on streams that are already Secure, for
+	// instance after StartTLS has been negotiated.
+	// Compressing encrypted data can allow attackers that are able to inject
+	// data into the stream to recover secrets based on the size of the
+	// compressed output (see CRIME).
+	DisableSecure bool
+}
+
+// Compression returns a stream feature that can be used for negotiating
+// XEP-0138: Stream Compression using zlib.
+// Compression is optional and is only negotiated after the session has been
+// authenticated.
+// If the server does not support zlib or fails to setup compression the stream
+// continues uncompressed.
+//
+// Once negotiated the stream is restarted over the compressed connection and
+// the Compressed bit is set on the session.
+// Compressed data is flushed every time the session is flushed (ie. after
+// every stanza) so that stanzas are never held back in the compressor.
+func Compression(cfg CompressionConfig) StreamFeature {
+	prohibited := Compressed
+	if cfg.DisableSecure {
+		prohibited |= Secure
+	}
+	return StreamFeature{
+		Name:        xml.Name{Space: ns.CompressFeature, Local: "compression"},
+		Necessary:   Authn,
+		Prohibited:  prohibited,
+		negotiateNS: ns.Compress,
+		List: func(ctx context.Context, e xmlstream.TokenWriter, start xml.StartElement) (bool, error) {
+			_, err := xmlstream.Copy(e, xmlstream.Wrap(
+				xmlstream.Wrap(
+					xmlstream.Token(xml.CharData(compressZlib)),
+					xml.StartElement{Name: xml.Name{Local: "method"}},
+				),
+				start,
+			))
+			return false, err
+		},
+		Parse: func(ctx context.Context, d *xml.Decoder, start *xml.StartElement) (bool, interface{}, error) {
+			parsed := struct {
+				XMLName xml.Name `xml:"http://jabber.org/features/compress compression"`
+				Methods []string `xml:"http://jabber.org/features/compress method"`
+			}{}
+			err := d.DecodeElement(&parsed, start)
+			return false, parsed.Methods, err
+		},
+		Negotiate: func(ctx context.Context, session *Session, data interface{}) (SessionState, io.ReadWriter, error) {
+			w := session.TokenWriter()
+			/* #nosec */
+			defer w.Close()
+			r := session.TokenReader()
+			/* #nosec */
+			defer r.Close()
+			d := xml.NewTokenDecoder(r)
+
+			if (session.State() & Received) == Received {
+				req := struct {
+					XMLName xml.Name `xml:"http://jabber.org/protocol/compress compress"`
+					Method  string   `xml:"http://jabber.org/protocol/compress method"`
+				}{}
+				err := d.Decode(&req)
+				if err != nil {
+					return 0, nil, err
+				}
+				if req.Method != compressZlib {
+					return 0, nil, sendCompressError(w, "unsupported-method")
+				}
+				_, err = xmlstream.Copy(w, xmlstream.Wrap(nil, xml.StartElement{
+					Name: xml.Name{Space: ns.Compress, Local: "compressed"},
+				}))
+				if err != nil {
+					return 0, nil, err
+				}
+				err = w.Flush()
+				if err != nil {
+					return 0, nil, err
+				}
+				rw, err := newCompressConn(session.Conn(), cfg.Level)
+				if err != nil {
+					return 0, nil, err
+				}
+				return Compressed, rw, nil
+			}
+
+			var supported bool
+			methods, _ := data.([]string)
+			for _, m := range methods {
+				supported = supported || m == compressZlib
+			}
+			if !supported {
+				return 0, nil, nil
+			}
+			_, err := xmlstream.Copy(w, xmlstream.Wrap(
+				xmlstream.Wrap(
+					xmlstream.Token(xml.CharData(compressZlib)),
+					xml.StartElement{Name: xml.Name{Local: "method"}},
+				),
+				xml.StartElement{Name: xml.Name{Space: ns.Compress, Local: "compress"}},
+			))
+			if err != nil {
+				return 0, nil, err
+			}
+			err = w.Flush()
+			if err != nil {
+				return 0, nil, err
+			}
+
+			tok, err := d.Token()
+			if err != nil {
+				return 0, nil, err
+			}
+			start, ok := tok.(xml.StartElement)
+			if !ok || start.Name.Space != ns.Compress {
+				return 0, nil, errUnexpectedPayload
+			}
+			err = d.Skip()
+			if err != nil {
+				return 0, nil, err
+			}
+			switch start.Name.Local {
+			case "compressed":
+				rw, err := newCompressConn(session.Conn(), cfg.Level)
+				if err != nil {
+					return 0, nil, err
+				}
+				return Compressed, rw, nil
+			case "failure":
+				// The stream continues without compression.
+				return 0, nil, nil
+			}
+			return 0, nil, errUnexpectedPayload
+		},
+	}
+}
+
+func sendCompressError(w xmlstream.TokenWriteFlusher, condition string) error {
+	_, err := xmlstream.Copy(w, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: condition}}),
+		xml.StartElement{Name: xml.Name{Space: ns.Compress, Local: "failure"}},
+	))
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// compressConn compresses data written to the underlying connection and
+// decompresses data read from it using zlib.
+type compressConn struct {
+	rw io.ReadWriter
+	r  io.ReadCloser
+	w  *zlib.Writer
+}
+
+func newCompressConn(rw io.ReadWriter, level int) (*compressConn, error) {
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
+	w, err := zlib.NewWriterLevel(rw, level)
+	if err != nil {
+		return nil, err
+	}
+	return &compressConn{rw: rw, w: w}, nil
+}
+
+// Read decompresses data from the underlying connection.
+// The zlib reader is created on the first read because it blocks until the
+// zlib header has been received, which the remote entity only sends once it
+// restarts the stream.
+func (c *compressConn) Read(p []byte) (int, error) {
+	if c.r == nil {
+		r, err := zlib.NewReader(c.rw)
+		if err != nil {
+			return 0, err
+		}
+		c.r = r
+	}
+	return c.r.Read(p)
+}
+
+// Write compresses p and flushes it to the underlying connection so that the
+// remote entity can decompress everything that has been written.
+func (c *compressConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
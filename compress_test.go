@@ -0,0 +1,172 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/xml"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+var compressTestCases = [...]xmpptest.FeatureTestCase{
+	0: {
+		State:   xmpp.Authn,
+		Feature: xmpp.Compression(xmpp.CompressionConfig{}),
+		In:      `<failure xmlns="http://jabber.org/protocol/compress"><setup-failed/></failure>`,
+		Out:     `<compress xmlns="http://jabber.org/protocol/compress"><method>zlib</method></compress>`,
+	},
+	1: {
+		State:   xmpp.Authn,
+		Feature: xmpp.Compression(xmpp.CompressionConfig{}),
+		In:      `<success xmlns="urn:ietf:params:xml:ns:xmpp-sasl"/>`,
+		Out:     `<compress xmlns="http://jabber.org/protocol/compress"><method>zlib</method></compress>`,
+		Err:     xmpp.ErrUnexpectedPayload,
+	},
+	2: {
+		State:   xmpp.Authn | xmpp.Received,
+		Feature: xmpp.Compression(xmpp.CompressionConfig{}),
+		In:      `<compress xmlns="http://jabber.org/protocol/compress"><method>lzw</method></compress>`,
+		Out:     `<failure xmlns="http://jabber.org/protocol/compress"><unsupported-method></unsupported-method></failure>`,
+	},
+	3: {
+		State:      xmpp.Authn | xmpp.Received,
+		Feature:    xmpp.Compression(xmpp.CompressionConfig{}),
+		In:         `<compress xmlns="http://jabber.org/protocol/compress"><method>zlib</method></compress>`,
+		Out:        `<compressed xmlns="http://jabber.org/protocol/compress"></compressed>`,
+		FinalState: xmpp.Compressed,
+	},
+}
+
+func TestCompression(t *testing.T) {
+	xmpptest.RunFeatureTests(t, compressTestCases[:])
+}
+
+// recordConn records everything written to the underlying connection.
+type recordConn struct {
+	net.Conn
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	c.Lock()
+	c.buf.Write(p)
+	c.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *recordConn) String() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.buf.String()
+}
+
+func TestCompressionSession(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cfg      xmpp.CompressionConfig
+		compress bool
+	}{
+		{name: "enabled", compress: true},
+		{name: "level", cfg: xmpp.CompressionConfig{Level: 9}, compress: true},
+		{name: "disable_secure", cfg: xmpp.CompressionConfig{DisableSecure: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			clientConn, serverConn := smPipe(t)
+			conn := &recordConn{Conn: clientConn}
+			msgs := make(chan string, 10)
+			errs := make(chan error, 1)
+			go func() {
+				s, err := xmpp.ReceiveSession(ctx, serverConn, xmpp.Secure|xmpp.Authn, smNegotiator(
+					xmpp.Compression(tc.cfg),
+					xmpp.BindResource(),
+				))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if got := s.State()&xmpp.Compressed == xmpp.Compressed; got != tc.compress {
+					t.Errorf("wrong server compression state: want=%t, got=%t", tc.compress, got)
+				}
+				errs <- s.Serve(xmpp.HandlerFunc(func(_ xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+					if start.Name.Local == "message" {
+						for _, a := range start.Attr {
+							if a.Name.Local == "id" {
+								msgs <- a.Value
+							}
+						}
+					}
+					return nil
+				}))
+			}()
+
+			client, err := xmpp.NewSession(ctx, jid.MustParse("example.net"), jid.MustParse("test@example.net"), conn, xmpp.Secure|xmpp.Authn, smNegotiator(
+				xmpp.Compression(tc.cfg),
+				xmpp.BindResource(),
+			))
+			if err != nil {
+				t.Fatalf("error negotiating client session: %v", err)
+			}
+			/* #nosec */
+			go client.Serve(nil)
+			if got := client.State()&xmpp.Compressed == xmpp.Compressed; got != tc.compress {
+				t.Errorf("wrong client compression state: want=%t, got=%t", tc.compress, got)
+			}
+
+			// Every stanza must be flushed through the compressor without waiting for
+			// more data.
+			for _, id := range []string{"one", "two", "three"} {
+				err = client.Send(ctx, smMessage(id))
+				if err != nil {
+					t.Fatalf("error sending message %s: %v", id, err)
+				}
+				expectMessage(ctx, t, msgs, id)
+			}
+			// Small stanzas may be sent in stored blocks, so decompress everything sent
+			// after the compress request to make sure that it is valid zlib data.
+			wire := conn.String()
+			if idx := strings.Index(wire, "</compress>"); tc.compress {
+				if idx < 0 {
+					t.Fatalf("compression was not requested: %q", wire)
+				}
+				r, err := zlib.NewReader(strings.NewReader(wire[idx+len("</compress>"):]))
+				if err != nil {
+					t.Fatalf("error reading compressed stream: %v", err)
+				}
+				plain, _ := io.ReadAll(r)
+				if !bytes.Contains(plain, []byte(`id="three"`)) {
+					t.Errorf("expected messages to be compressed, got %q", plain)
+				}
+			} else if idx >= 0 || !strings.Contains(wire, `id="three"`) {
+				t.Errorf("expected messages not to be compressed, got %q", wire)
+			}
+
+			err = client.Close()
+			if err != nil {
+				t.Errorf("error closing client session: %v", err)
+			}
+			select {
+			case <-errs:
+			case <-ctx.Done():
+				t.Errorf("timed out waiting for server session to close")
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+	"mellium.im/sasl"
+	"github.com/kamrankamilli/xmpp/internal/saslerr"
+	"github.com/kamrankamilli/xmpp/internal/scram"
+)
+
+// Errors that may be returned by a CredentialStore to report why a user could
+// not be authenticated.
+// Unknown users and incorrect passwords should be reported using sasl.ErrAuthn.
+var (
+	ErrAccountDisabled    = errors.New("xmpp: account disabled")
+	ErrCredentialsExpired = errors.New("xmpp: credentials expired")
+	ErrInvalidAuthzID     = errors.New("xmpp: not authorized to act on behalf of the requested identity")
+)
+
+// accountIterations is the PBKDF2 iteration count used by NewAccount.
+const accountIterations = 4096
+
+// accountHashes are the hashes for which NewAccount derives salted passwords,
+// in the order they are preferred when verifying passwords.
+var accountHashes = []string{"SHA-256", "SHA-512", "SHA-1"}
+
+// CredentialStore is used by servers to authenticate clients (see
+// SASLServerCredentials).
+type CredentialStore interface {
+	// SaltedCredentials returns the salt, salted password, and PBKDF2 iteration
+	// count for username as used by SCRAM with the given hash (one of "SHA-1",
+	// "SHA-256", or "SHA-512").
+	// If the account is disabled or its credentials have expired the credentials
+	// should still be returned along with ErrAccountDisabled or
+	// ErrCredentialsExpired so that the error is only reported to clients that
+	// prove they know the password.
+	SaltedCredentials(ctx context.Context, username, hash string) (salt, saltedPassword []byte, iterations int64, err error)
+
+	// VerifyPassword checks a plain text password, for example one sent using
+	// the PLAIN mechanism.
+	VerifyPassword(ctx context.Context, username, password string) error
+
+	// Authorize checks that username may act on behalf of identity, the
+	// authorization identity requested by the client.
+	// It is only called after the user has been authenticated and if identity is
+	// not the user's own bare JID.
+	// If the user may not act on behalf of identity it should return
+	// ErrInvalidAuthzID.
+	Authorize(ctx context.Context, username, identity string) error
+}
+
+// Account is a user account stored by MemoryCredentials or FileCredentials.
+type Account struct {
+	Username string
+
+	// Salt and Iterations are the PBKDF2 parameters used to derive the salted
+	// passwords.
+	Salt       []byte
+	Iterations int64
+
+	// SaltedPasswords maps hash names ("SHA-1", "SHA-256", or "SHA-512") to the
+	// password salted using that hash.
+	// SCRAM mechanisms can only be used with hashes that have a salted password.
+	SaltedPasswords map[string][]byte
+
+	// Disabled accounts cannot be authenticated.
+	Disabled bool
+
+	// Expires is the time after which the password must be changed before the
+	// user can be authenticated again.
+	// If it is the zero value the password never expires.
+	Expires time.Time
+
+	// Authorize lists the identities, other than the user's own bare JID, that
+	// the user may act on behalf of.
+	Authorize []string
+}
+
+// NewAccount returns an account with a random salt and salted passwords
+// derived from password for every supported hash.
+func NewAccount(username, password string) (Account, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return Account{}, err
+	}
+	a := Account{
+		Username:        username,
+		Salt:            salt,
+		Iterations:      accountIterations,
+		SaltedPasswords: make(map[string][]byte),
+	}
+	for _, h := range accountHashes {
+		fn, _ := scram.Hash("SCRAM-" + h)
+		a.SaltedPasswords[h] = pbkdf2.Key([]byte(password), salt, accountIterations, fn().Size(), fn)
+	}
+	return a, nil
+}
+
+// status returns an error if the account cannot currently be used.
+func (a Account) status() error {
+	switch {
+	case a.Disabled:
+		return ErrAccountDisabled
+	case !a.Expires.IsZero() && !time.Now().Before(a.Expires):
+		return ErrCredentialsExpired
+	}
+	return nil
+}
+
+func (a Account) verify(password string) error {
+	for _, h := range accountHashes {
+		salted, ok := a.SaltedPasswords[h]
+		if !ok {
+			continue
+		}
+		fn, _ := scram.Hash("SCRAM-" + h)
+		key := pbkdf2.Key([]byte(password), a.Salt, int(a.Iterations), fn().Size(), fn)
+		if subtle.ConstantTimeCompare(key, salted) != 1 {
+			return sasl.ErrAuthn
+		}
+		return a.status()
+	}
+	return sasl.ErrAuthn
+}
+
+func (a Account) authorize(identity string) error {
+	for _, id := range a.Authorize {
+		if id == identity {
+			return nil
+		}
+	}
+	return ErrInvalidAuthzID
+}
+
+// MarshalText implements encoding.TextMarshaler.
+// The account is encoded as a single line of colon separated fields similar to
+// an htpasswd file:
+//
+//	username:iterations:salt:hash=salted,…[:flag,…]
+//
+// The salt and salted passwords are base64 encoded.
+// The optional flags are "disabled", "expires=" followed by an RFC 3339
+// timestamp, and "authorize=" followed by an identity.
+func (a Account) MarshalText() ([]byte, error) {
+	if a.Username == "" || strings.ContainsAny(a.Username, ":\n") {
+		return nil, fmt.Errorf("xmpp: invalid account username %q", a.Username)
+	}
+	hashes := make([]string, 0, len(a.SaltedPasswords))
+	for h := range a.SaltedPasswords {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	for i, h := range hashes {
+		hashes[i] = h + "=" + base64.StdEncoding.EncodeToString(a.SaltedPasswords[h])
+	}
+	var flags []string
+	if a.Disabled {
+		flags = append(flags, "disabled")
+	}
+	if !a.Expires.IsZero() {
+		flags = append(flags, "expires="+a.Expires.UTC().Format(time.RFC3339))
+	}
+	for _, id := range a.Authorize {
+		if strings.ContainsAny(id, ",\n") {
+			return nil, fmt.Errorf("xmpp: invalid authorized identity %q", id)
+		}
+		flags = append(flags, "authorize="+id)
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "%s:%d:%s:%s", a.Username, a.Iterations, base64.StdEncoding.EncodeToString(a.Salt), strings.Join(hashes, ","))
+	if len(flags) > 0 {
+		fmt.Fprintf(b, ":%s", strings.Join(flags, ","))
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// See MarshalText for the format.
+func (a *Account) UnmarshalText(text []byte) error {
+	fields := strings.SplitN(string(text), ":", 5)
+	if len(fields) < 4 || fields[0] == "" {
+		return errors.New("xmpp: malformed account")
+	}
+	iter, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || iter <= 0 {
+		return fmt.Errorf("xmpp: invalid iteration count for account %s", fields[0])
+	}
+	salt, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return fmt.Errorf("xmpp: invalid salt for account %s: %w", fields[0], err)
+	}
+	parsed := Account{
+		Username:        fields[0],
+		Salt:            salt,
+		Iterations:      iter,
+		SaltedPasswords: make(map[string][]byte),
+	}
+	for _, v := range strings.Split(fields[3], ",") {
+		h, salted, ok := strings.Cut(v, "=")
+		if _, known := scram.Hash("SCRAM-" + h); !ok || !known {
+			return fmt.Errorf("xmpp: invalid salted password for account %s", fields[0])
+		}
+		parsed.SaltedPasswords[h], err = base64.StdEncoding.DecodeString(salted)
+		if err != nil {
+			return fmt.Errorf("xmpp: invalid salted password for account %s: %w", fields[0], err)
+		}
+	}
+	if len(fields) == 5 && fields[4] != "" {
+		for _, flag := range strings.Split(fields[4], ",") {
+			name, value, _ := strings.Cut(flag, "=")
+			switch name {
+			case "disabled":
+				parsed.Disabled = true
+			case "expires":
+				parsed.Expires, err = time.Parse(time.RFC3339, value)
+				if err != nil {
+					return fmt.Errorf("xmpp: invalid expiry for account %s: %w", fields[0], err)
+				}
+			case "authorize":
+				parsed.Authorize = append(parsed.Authorize, value)
+			default:
+				return fmt.Errorf("xmpp: unknown flag %q for account %s", name, fields[0])
+			}
+		}
+	}
+	*a = parsed
+	return nil
+}
+
+// MemoryCredentials is a CredentialStore that keeps accounts in memory.
+type MemoryCredentials struct {
+	mu       sync.RWMutex
+	accounts map[string]Account
+}
+
+// NewMemoryCredentials returns a CredentialStore containing accounts.
+func NewMemoryCredentials(accounts ...Account) *MemoryCredentials {
+	m := &MemoryCredentials{accounts: make(map[string]Account, len(accounts))}
+	for _, a := range accounts {
+		m.accounts[a.Username] = a
+	}
+	return m
+}
+
+// SetAccount adds an account, replacing any existing account with the same
+// username.
+func (m *MemoryCredentials) SetAccount(a Account) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accounts[a.Username] = a
+}
+
+// RemoveAccount removes the account with the given username.
+func (m *MemoryCredentials) RemoveAccount(username string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.accounts, username)
+}
+
+func (m *MemoryCredentials) account(username string) (Account, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.accounts[username]
+	return a, ok
+}
+
+// SaltedCredentials implements CredentialStore.
+func (m *MemoryCredentials) SaltedCredentials(_ context.Context, username, hash string) ([]byte, []byte, int64, error) {
+	a, ok := m.account(username)
+	if !ok {
+		return nil, nil, 0, sasl.ErrAuthn
+	}
+	salted, ok := a.SaltedPasswords[hash]
+	if !ok {
+		return nil, nil, 0, sasl.ErrAuthn
+	}
+	return a.Salt, salted, a.Iterations, a.status()
+}
+
+// VerifyPassword implements CredentialStore.
+func (m *MemoryCredentials) VerifyPassword(_ context.Context, username, password string) error {
+	a, ok := m.account(username)
+	if !ok {
+		return sasl.ErrAuthn
+	}
+	return a.verify(password)
+}
+
+// Authorize implements CredentialStore.
+func (m *MemoryCredentials) Authorize(_ context.Context, username, identity string) error {
+	a, ok := m.account(username)
+	if !ok {
+		return ErrInvalidAuthzID
+	}
+	return a.authorize(identity)
+}
+
+// FileCredentials is a CredentialStore that reads accounts from a file similar
+// to an htpasswd file.
+// Each line of the file is an account in the format described by
+// Account.MarshalText.
+// Empty lines and lines beginning with "#" are ignored.
+//
+// The file is read again whenever it changes so that accounts can be added or
+// modified without restarting the server.
+type FileCredentials struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	mem     *MemoryCredentials
+}
+
+// NewFileCredentials returns a CredentialStore backed by the file at path.
+// The file is not read until the first client authenticates.
+func NewFileCredentials(path string) *FileCredentials {
+	return &FileCredentials{path: path}
+}
+
+// load returns the accounts from the file, reading it again if it has changed.
+func (f *FileCredentials) load() (*MemoryCredentials, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if f.mem != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.mem, nil
+	}
+	fd, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	/* #nosec */
+	defer fd.Close()
+
+	mem := NewMemoryCredentials()
+	scanner := bufio.NewScanner(fd)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var a Account
+		err = a.UnmarshalText([]byte(text))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", f.path, line, err)
+		}
+		mem.SetAccount(a)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	f.mem, f.modTime, f.size = mem, info.ModTime(), info.Size()
+	return mem, nil
+}
+
+// SaltedCredentials implements CredentialStore.
+func (f *FileCredentials) SaltedCredentials(ctx context.Context, username, hash string) ([]byte, []byte, int64, error) {
+	mem, err := f.load()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return mem.SaltedCredentials(ctx, username, hash)
+}
+
+// VerifyPassword implements CredentialStore.
+func (f *FileCredentials) VerifyPassword(ctx context.Context, username, password string) error {
+	mem, err := f.load()
+	if err != nil {
+		return err
+	}
+	return mem.VerifyPassword(ctx, username, password)
+}
+
+// Authorize implements CredentialStore.
+func (f *FileCredentials) Authorize(ctx context.Context, username, identity string) error {
+	mem, err := f.load()
+	if err != nil {
+		return err
+	}
+	return mem.Authorize(ctx, username, identity)
+}
+
+// saslCondition returns the condition that is sent to clients when
+// authentication fails with err.
+func saslCondition(err error) saslerr.Condition {
+	switch {
+	case errors.Is(err, ErrAccountDisabled):
+		return saslerr.ConditionAccountDisabled
+	case errors.Is(err, ErrCredentialsExpired):
+		return saslerr.ConditionCredentialsExpired
+	case errors.Is(err, ErrInvalidAuthzID):
+		return saslerr.ConditionInvalidAuthzID
+	case errors.Is(err, sasl.ErrInvalidChallenge):
+		return saslerr.ConditionMalformedRequest
+	case errors.Is(err, sasl.ErrAuthn), errors.Is(err, scram.ErrBinding), errors.Is(err, scram.ErrDowngrade):
+		return saslerr.ConditionNotAuthorized
+	}
+	return saslerr.ConditionTemporaryAuthFailure
+}
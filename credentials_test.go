@@ -0,0 +1,383 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/sasl"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/internal/saslerr"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+func testAccount(t *testing.T, username, password string) xmpp.Account {
+	t.Helper()
+	a, err := xmpp.NewAccount(username, password)
+	if err != nil {
+		t.Fatalf("error creating account: %v", err)
+	}
+	return a
+}
+
+func TestAccountText(t *testing.T) {
+	a := testAccount(t, "test", "pass")
+	a.Disabled = true
+	a.Expires = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	a.Authorize = []string{"admin@example.net", "[::1]"}
+
+	text, err := a.MarshalText()
+	if err != nil {
+		t.Fatalf("error marshaling account: %v", err)
+	}
+	var b xmpp.Account
+	err = b.UnmarshalText(text)
+	if err != nil {
+		t.Fatalf("error unmarshaling account %s: %v", text, err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("account did not round trip:\nwant=%+v\n got=%+v", a, b)
+	}
+
+	for i, bad := range []string{
+		"",
+		"test:4096:c2FsdA==",
+		"test:x:c2FsdA==:SHA-256=a2V5",
+		"test:4096:!:SHA-256=a2V5",
+		"test:4096:c2FsdA==:MD5=a2V5",
+		"test:4096:c2FsdA==:SHA-256=a2V5:unknown",
+		"test:4096:c2FsdA==:SHA-256=a2V5:expires=tomorrow",
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if err := (&xmpp.Account{}).UnmarshalText([]byte(bad)); err == nil {
+				t.Errorf("expected error unmarshaling %q", bad)
+			}
+		})
+	}
+}
+
+func TestMemoryCredentials(t *testing.T) {
+	ctx := context.Background()
+	disabled := testAccount(t, "disabled", "pass")
+	disabled.Disabled = true
+	expired := testAccount(t, "expired", "pass")
+	expired.Expires = time.Now().Add(-time.Minute)
+	admin := testAccount(t, "admin", "pass")
+	admin.Authorize = []string{"test@example.net"}
+	store := xmpp.NewMemoryCredentials(testAccount(t, "test", "pass"), disabled, expired, admin)
+
+	for i, tc := range [...]struct {
+		user, pass string
+		err        error
+	}{
+		0: {user: "test", pass: "pass"},
+		1: {user: "test", pass: "wrong", err: sasl.ErrAuthn},
+		2: {user: "unknown", pass: "pass", err: sasl.ErrAuthn},
+		3: {user: "disabled", pass: "pass", err: xmpp.ErrAccountDisabled},
+		4: {user: "disabled", pass: "wrong", err: sasl.ErrAuthn},
+		5: {user: "expired", pass: "pass", err: xmpp.ErrCredentialsExpired},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := store.VerifyPassword(ctx, tc.user, tc.pass)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+
+	salt, salted, iter, err := store.SaltedCredentials(ctx, "disabled", "SHA-1")
+	if len(salt) == 0 || len(salted) == 0 || iter != 4096 || !errors.Is(err, xmpp.ErrAccountDisabled) {
+		t.Errorf("expected credentials to be returned with error, got %x, %x, %d, %v", salt, salted, iter, err)
+	}
+	if err = store.Authorize(ctx, "admin", "test@example.net"); err != nil {
+		t.Errorf("expected admin to be authorized: %v", err)
+	}
+	if err = store.Authorize(ctx, "test", "admin@example.net"); !errors.Is(err, xmpp.ErrInvalidAuthzID) {
+		t.Errorf("unexpected error authorizing test: %v", err)
+	}
+
+	store.RemoveAccount("test")
+	if err = store.VerifyPassword(ctx, "test", "pass"); !errors.Is(err, sasl.ErrAuthn) {
+		t.Errorf("expected removed account to be unknown, got %v", err)
+	}
+}
+
+func TestFileCredentials(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "passwd")
+	writeAccounts := func(accounts ...xmpp.Account) {
+		t.Helper()
+		b := []byte("# accounts\n\n")
+		for _, a := range accounts {
+			text, err := a.MarshalText()
+			if err != nil {
+				t.Fatalf("error marshaling account: %v", err)
+			}
+			b = append(append(b, text...), '\n')
+		}
+		err := os.WriteFile(path, b, 0o600)
+		if err != nil {
+			t.Fatalf("error writing accounts: %v", err)
+		}
+	}
+
+	store := xmpp.NewFileCredentials(path)
+	if err := store.VerifyPassword(ctx, "test", "pass"); err == nil {
+		t.Fatalf("expected error reading missing file")
+	}
+
+	a := testAccount(t, "test", "pass")
+	writeAccounts(a)
+	if err := store.VerifyPassword(ctx, "test", "pass"); err != nil {
+		t.Fatalf("error verifying password: %v", err)
+	}
+
+	// Changes to the file are picked up.
+	a.Disabled = true
+	writeAccounts(a, testAccount(t, "other", "pass"))
+	if err := store.VerifyPassword(ctx, "test", "pass"); !errors.Is(err, xmpp.ErrAccountDisabled) {
+		t.Errorf("expected account to be disabled, got %v", err)
+	}
+	if err := store.VerifyPassword(ctx, "other", "pass"); err != nil {
+		t.Errorf("error verifying password of new account: %v", err)
+	}
+}
+
+// credentialLogin negotiates a session with a server that uses store and
+// returns any error from either side.
+// If tlsCfg is not nil StartTLS is negotiated first.
+func credentialLogin(ctx context.Context, t *testing.T, identity, password string, store xmpp.CredentialStore, tlsCfg func(*testing.T) (*tls.Config, *tls.Config), mechanisms ...sasl.Mechanism) (clientErr, serverErr error) {
+	t.Helper()
+
+	state := xmpp.Secure
+	var serverFeatures, clientFeatures []xmpp.StreamFeature
+	if tlsCfg != nil {
+		serverTLS, clientTLS := tlsCfg(t)
+		state = 0
+		serverFeatures = append(serverFeatures, xmpp.StartTLS(serverTLS))
+		clientFeatures = append(clientFeatures, xmpp.StartTLS(clientTLS))
+	}
+	serverFeatures = append(serverFeatures, xmpp.SASLServerCredentials(store, mechanisms...), xmpp.BindResource())
+	clientFeatures = append(clientFeatures, xmpp.SASL(identity, password, mechanisms...), xmpp.BindResource())
+
+	clientConn, serverConn := smPipe(t)
+	errs := make(chan error, 1)
+	go func() {
+		s, err := xmpp.ReceiveSession(ctx, serverConn, state, smNegotiator(serverFeatures...))
+		if err == nil {
+			err = s.Close()
+		}
+		errs <- err
+	}()
+	s, clientErr := xmpp.NewSession(ctx, jid.MustParse("example.net"), jid.MustParse("test@example.net"), clientConn, state, smNegotiator(clientFeatures...))
+	if clientErr == nil {
+		/* #nosec */
+		s.Close()
+	} else {
+		/* #nosec */
+		clientConn.Close()
+	}
+	return clientErr, <-errs
+}
+
+func TestSASLServerCredentials(t *testing.T) {
+	disabled := testAccount(t, "test", "pass")
+	disabled.Disabled = true
+	expired := testAccount(t, "test", "pass")
+	expired.Expires = time.Now().Add(-time.Minute)
+
+	for i, tc := range [...]struct {
+		account    xmpp.Account
+		identity   string
+		password   string
+		tls        bool
+		mechanisms []sasl.Mechanism
+		condition  saslerr.Condition
+		serverErr  error
+	}{
+		0: {account: testAccount(t, "test", "pass"), mechanisms: []sasl.Mechanism{sasl.Plain}},
+		1: {account: testAccount(t, "test", "pass"), mechanisms: []sasl.Mechanism{sasl.ScramSha1}},
+		2: {account: testAccount(t, "test", "pass"), mechanisms: []sasl.Mechanism{sasl.ScramSha256}},
+		3: {account: testAccount(t, "test", "pass"), tls: true, mechanisms: []sasl.Mechanism{sasl.ScramSha256Plus}},
+		4: {
+			account:    testAccount(t, "test", "pass"),
+			password:   "wrong",
+			mechanisms: []sasl.Mechanism{sasl.ScramSha256},
+			condition:  saslerr.ConditionNotAuthorized,
+			serverErr:  sasl.ErrAuthn,
+		},
+		5: {
+			account:    testAccount(t, "other", "pass"),
+			mechanisms: []sasl.Mechanism{sasl.ScramSha256},
+			condition:  saslerr.ConditionNotAuthorized,
+			serverErr:  sasl.ErrAuthn,
+		},
+		6: {
+			account:    disabled,
+			mechanisms: []sasl.Mechanism{sasl.ScramSha256},
+			condition:  saslerr.ConditionAccountDisabled,
+			serverErr:  xmpp.ErrAccountDisabled,
+		},
+		7: {
+			// Disabled accounts are not revealed to clients that do not know the
+			// password.
+			account:    disabled,
+			password:   "wrong",
+			mechanisms: []sasl.Mechanism{sasl.ScramSha256},
+			condition:  saslerr.ConditionNotAuthorized,
+			serverErr:  sasl.ErrAuthn,
+		},
+		8: {
+			account:    expired,
+			mechanisms: []sasl.Mechanism{sasl.Plain},
+			condition:  saslerr.ConditionCredentialsExpired,
+			serverErr:  xmpp.ErrCredentialsExpired,
+		},
+		9: {
+			account:    testAccount(t, "test", "pass"),
+			identity:   "admin@example.net",
+			mechanisms: []sasl.Mechanism{sasl.ScramSha256},
+			condition:  saslerr.ConditionInvalidAuthzID,
+			serverErr:  xmpp.ErrInvalidAuthzID,
+		},
+		10: {
+			account:    testAccount(t, "test", "pass"),
+			identity:   "test@example.net",
+			mechanisms: []sasl.Mechanism{sasl.Plain},
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			pass := tc.password
+			if pass == "" {
+				pass = "pass"
+			}
+			var tlsCfg func(*testing.T) (*tls.Config, *tls.Config)
+			if tc.tls {
+				tlsCfg = tlsConfigs
+			}
+			clientErr, serverErr := credentialLogin(ctx, t, tc.identity, pass, xmpp.NewMemoryCredentials(tc.account), tlsCfg, tc.mechanisms...)
+			if !errors.Is(serverErr, tc.serverErr) {
+				t.Errorf("unexpected server error: want=%v, got=%v", tc.serverErr, serverErr)
+			}
+			if tc.condition == saslerr.ConditionNone {
+				if clientErr != nil {
+					t.Errorf("unexpected client error: %v", clientErr)
+				}
+				return
+			}
+			var fail saslerr.Error
+			if !errors.As(clientErr, &fail) || fail.Condition != tc.condition {
+				t.Errorf("unexpected client error: want=%v, got=%v", tc.condition, clientErr)
+			}
+		})
+	}
+}
+
+func TestSASLServerCredentialsBind(t *testing.T) {
+	admin := testAccount(t, "admin", "pass")
+	admin.Authorize = []string{"test@example.net"}
+	store := xmpp.NewMemoryCredentials(admin, testAccount(t, "test", "pass"))
+
+	for i, tc := range [...]struct {
+		origin   string
+		identity string
+		bound    string
+	}{
+		0: {origin: "test@example.net", bound: "test@example.net"},
+		1: {origin: "admin@example.net", identity: "test@example.net", bound: "test@example.net"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			clientConn, serverConn := smPipe(t)
+			type result struct {
+				addr jid.JID
+				err  error
+			}
+			server := make(chan result, 1)
+			go func() {
+				s, err := xmpp.ReceiveSession(ctx, serverConn, xmpp.Secure, smNegotiator(
+					xmpp.SASLServerCredentials(store, sasl.ScramSha256),
+					xmpp.BindResource(),
+				))
+				if err != nil {
+					server <- result{err: err}
+					return
+				}
+				server <- result{addr: s.RemoteAddr(), err: s.Close()}
+			}()
+			s, err := xmpp.NewSession(ctx, jid.MustParse("example.net"), jid.MustParse(tc.origin), clientConn, xmpp.Secure, smNegotiator(
+				xmpp.SASL(tc.identity, "pass", sasl.ScramSha256),
+				xmpp.BindResource(),
+			))
+			if err != nil {
+				t.Fatalf("error negotiating client session: %v", err)
+			}
+			/* #nosec */
+			defer s.Close()
+			if addr := s.LocalAddr(); addr.Bare().String() != tc.bound || addr.Resourcepart() == "" {
+				t.Errorf("wrong address bound by client: want=%s/…, got=%s", tc.bound, addr)
+			}
+			res := <-server
+			if res.err != nil {
+				t.Fatalf("error negotiating server session: %v", res.err)
+			}
+			if !res.addr.Equal(s.LocalAddr()) {
+				t.Errorf("wrong remote address on server: want=%s, got=%s", s.LocalAddr(), res.addr)
+			}
+		})
+	}
+
+	// The stream origin (test@example.net) names a different account than the
+	// authenticated user.
+	xmpptest.RunFeatureTests(t, []xmpptest.FeatureTestCase{{
+		State:   xmpp.Received,
+		Feature: xmpp.SASLServerCredentials(store, sasl.Plain),
+		In:      `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">AGFkbWluAHBhc3M=</auth>`,
+		Out:     `<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><not-authorized></not-authorized></failure>`,
+		Err:     sasl.ErrAuthn,
+	}})
+}
+
+func TestSASL2ServerCredentials(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	disabled := testAccount(t, "test", "pass")
+	disabled.Disabled = true
+	store := xmpp.NewMemoryCredentials(testAccount(t, "test", "pass"))
+	for _, password := range []string{"pass", "wrong"} {
+		clientErr, serverErr := fastLogin(ctx, t, password, nil, xmpp.SASL2ServerConfig{Credentials: store}, nil)
+		if password == "pass" && (clientErr != nil || serverErr != nil) {
+			t.Errorf("error authenticating: client=%v, server=%v", clientErr, serverErr)
+		}
+		if password == "wrong" && !errors.Is(serverErr, sasl.ErrAuthn) {
+			t.Errorf("expected wrong password to be rejected, got %v", serverErr)
+		}
+	}
+
+	store.SetAccount(disabled)
+	clientErr, serverErr := fastLogin(ctx, t, "pass", nil, xmpp.SASL2ServerConfig{Credentials: store}, nil)
+	var fail saslerr.Error
+	if !errors.As(clientErr, &fail) || fail.Condition != saslerr.ConditionAccountDisabled {
+		t.Errorf("unexpected client error: %v", clientErr)
+	}
+	if !errors.Is(serverErr, xmpp.ErrAccountDisabled) {
+		t.Errorf("unexpected server error: %v", serverErr)
+	}
+}
@@ -0,0 +1,379 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"sort"
+	"sync"
+
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// NSATM is the usage of trust messages sent for XEP-0450: Automatic Trust
+// Management.
+const NSATM = "urn:xmpp:atm:1"
+
+// TrustState is the trust that has been placed in a key.
+type TrustState uint8
+
+// A list of possible trust states.
+const (
+	// Undecided keys have not been authenticated or distrusted.
+	// Applications may choose to use them anyways (for example, when using
+	// "Blind Trust Before Verification").
+	Undecided TrustState = iota
+
+	// Authenticated keys have been verified, either manually (for example, by
+	// scanning a QR code or comparing fingerprints) or by a trust message from
+	// another authenticated key.
+	Authenticated
+
+	// Distrusted keys must not be used.
+	Distrusted
+)
+
+// TrustStore persists the trust placed in keys and trust messages that cannot
+// be processed yet.
+// It may be shared by several encryption schemes, so all keys are scoped to
+// the namespace of the encryption scheme that uses them.
+// All addresses passed to the store are bare JIDs.
+type TrustStore interface {
+	// Trust returns the trust state of the key owned by owner.
+	// Unknown keys are Undecided.
+	Trust(ctx context.Context, encryption string, owner jid.JID, key []byte) (TrustState, error)
+
+	// SetTrust sets the trust state of the key owned by owner.
+	SetTrust(ctx context.Context, encryption string, owner jid.JID, key []byte, state TrustState) error
+
+	// Keys returns all keys that have been authenticated or distrusted grouped
+	// by owner.
+	// Authenticated keys are trusted.
+	Keys(ctx context.Context, encryption string) ([]OwnedKeys, error)
+
+	// CacheTrustMessage stores a trust message sent using a key that has not
+	// been authenticated yet.
+	CacheTrustMessage(ctx context.Context, sender jid.JID, senderKey []byte, tm TrustMessage) error
+
+	// TakeTrustMessages removes and returns the trust messages that were cached
+	// for the key of sender.
+	TakeTrustMessages(ctx context.Context, encryption string, sender jid.JID, senderKey []byte) ([]TrustMessage, error)
+}
+
+// NewTrustStore returns a TrustStore that keeps all state in memory.
+func NewTrustStore() TrustStore {
+	return &memTrustStore{
+		keys:  make(map[string]memKey),
+		cache: make(map[string][]TrustMessage),
+	}
+}
+
+type memKey struct {
+	owner jid.JID
+	key   []byte
+	state TrustState
+}
+
+type memTrustStore struct {
+	sync.Mutex
+	keys  map[string]memKey
+	cache map[string][]TrustMessage
+}
+
+func trustKey(encryption string, owner jid.JID, key []byte) string {
+	return encryption + " " + owner.String() + " " + base64.StdEncoding.EncodeToString(key)
+}
+
+func (m *memTrustStore) Trust(_ context.Context, encryption string, owner jid.JID, key []byte) (TrustState, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.keys[trustKey(encryption, owner, key)].state, nil
+}
+
+func (m *memTrustStore) SetTrust(_ context.Context, encryption string, owner jid.JID, key []byte, state TrustState) error {
+	m.Lock()
+	defer m.Unlock()
+	k := trustKey(encryption, owner, key)
+	if state == Undecided {
+		delete(m.keys, k)
+		return nil
+	}
+	m.keys[k] = memKey{owner: owner, key: append([]byte(nil), key...), state: state}
+	return nil
+}
+
+func (m *memTrustStore) Keys(_ context.Context, encryption string) ([]OwnedKeys, error) {
+	m.Lock()
+	defer m.Unlock()
+	ids := make([]string, 0, len(m.keys))
+	for id := range m.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var owned []OwnedKeys
+	for _, id := range ids {
+		k := m.keys[id]
+		if id != trustKey(encryption, k.owner, k.key) {
+			continue
+		}
+		key := Key{Trusted: k.state == Authenticated, KeyID: k.key}
+		if n := len(owned); n > 0 && owned[n-1].Owner.Equal(k.owner) {
+			owned[n-1].Keys = append(owned[n-1].Keys, key)
+			continue
+		}
+		owned = append(owned, OwnedKeys{Owner: k.owner, Keys: []Key{key}})
+	}
+	return owned, nil
+}
+
+func (m *memTrustStore) CacheTrustMessage(_ context.Context, sender jid.JID, senderKey []byte, tm TrustMessage) error {
+	m.Lock()
+	defer m.Unlock()
+	k := trustKey(tm.Encryption, sender, senderKey)
+	m.cache[k] = append(m.cache[k], tm)
+	return nil
+}
+
+func (m *memTrustStore) TakeTrustMessages(_ context.Context, encryption string, sender jid.JID, senderKey []byte) ([]TrustMessage, error) {
+	m.Lock()
+	defer m.Unlock()
+	k := trustKey(encryption, sender, senderKey)
+	msgs := m.cache[k]
+	delete(m.cache, k)
+	return msgs, nil
+}
+
+// TrustUpdate is a trust message that should be sent to other endpoints after
+// the trust in a key changes.
+type TrustUpdate struct {
+	// To is the account that owns the endpoints that the message should be sent
+	// to.
+	To jid.JID
+
+	// Keys are the keys of the endpoints that the message should be sent to.
+	// The message must be encrypted only for these keys.
+	Keys [][]byte
+
+	Message TrustMessage
+}
+
+// ATM implements the trust logic of XEP-0450: Automatic Trust Management for
+// a single encryption scheme.
+//
+// Trust messages received from authenticated keys are applied immediately,
+// trust messages received from keys that have not been authenticated yet are
+// cached until the key is authenticated, and trust messages from distrusted
+// keys are ignored.
+// Trust messages sent by contacts may only change the trust in the contacts'
+// own keys, while trust messages sent by our own endpoints may change the trust
+// in any key.
+type ATM struct {
+	// Store persists the trust in keys and any cached trust messages.
+	// It must not be nil.
+	Store TrustStore
+
+	// Encryption is the namespace of the encryption scheme that keys belong to.
+	// Trust messages for other encryption schemes are ignored.
+	Encryption string
+
+	// Addr is the address of our own account.
+	Addr jid.JID
+
+	// Key is the key of our own endpoint.
+	// It is included in trust messages sent to contacts but is never a recipient
+	// of trust messages.
+	Key []byte
+}
+
+// Receive processes a trust message sent by the endpoint of sender that uses
+// senderKey.
+// Trust messages that do not have the ATM usage or encryption scheme are
+// ignored.
+func (a *ATM) Receive(ctx context.Context, sender jid.JID, senderKey []byte, tm TrustMessage) error {
+	if tm.Usage != NSATM || tm.Encryption != a.Encryption {
+		return nil
+	}
+	sender = sender.Bare()
+	state, err := a.Store.Trust(ctx, a.Encryption, sender, senderKey)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case Authenticated:
+		return a.apply(ctx, sender, tm)
+	case Distrusted:
+		return nil
+	}
+	return a.Store.CacheTrustMessage(ctx, sender, senderKey, tm)
+}
+
+// apply applies a trust message from an authenticated sender.
+func (a *ATM) apply(ctx context.Context, sender jid.JID, tm TrustMessage) error {
+	own := sender.Equal(a.Addr.Bare())
+	for _, owned := range tm.Keys {
+		owner := owned.Owner.Bare()
+		if !own && !owner.Equal(sender) {
+			continue
+		}
+		for _, key := range owned.Keys {
+			state := Distrusted
+			if key.Trusted {
+				state = Authenticated
+			}
+			err := a.setTrust(ctx, owner, key.KeyID, state)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setTrust sets the trust in a key and, if the key has been authenticated,
+// applies any trust messages that were sent using it.
+func (a *ATM) setTrust(ctx context.Context, owner jid.JID, key []byte, state TrustState) error {
+	prev, err := a.Store.Trust(ctx, a.Encryption, owner, key)
+	if err != nil {
+		return err
+	}
+	err = a.Store.SetTrust(ctx, a.Encryption, owner, key, state)
+	if err != nil || state != Authenticated || prev == Authenticated {
+		return err
+	}
+	cached, err := a.Store.TakeTrustMessages(ctx, a.Encryption, owner, key)
+	if err != nil {
+		return err
+	}
+	for _, tm := range cached {
+		err = a.apply(ctx, owner, tm)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Authenticate marks the key of owner as authenticated after it has been
+// verified manually and returns the trust messages that should be sent as a
+// result.
+// Any trust messages that were cached for the key are applied.
+func (a *ATM) Authenticate(ctx context.Context, owner jid.JID, key []byte) ([]TrustUpdate, error) {
+	owner = owner.Bare()
+	err := a.setTrust(ctx, owner, key, Authenticated)
+	if err != nil {
+		return nil, err
+	}
+	all, err := a.Store.Keys(ctx, a.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	self := a.Addr.Bare()
+	ownKeys := a.authenticated(all, self)
+	newKey := []OwnedKeys{{Owner: owner, Keys: []Key{{Trusted: true, KeyID: key}}}}
+
+	var updates []TrustUpdate
+	if !owner.Equal(self) {
+		// Tell our other endpoints about the contact's key, and tell the contact's
+		// endpoints about our keys.
+		updates = a.appendUpdate(updates, self, without(ownKeys, key), newKey)
+		updates = a.appendUpdate(updates, owner, a.authenticated(all, owner), []OwnedKeys{{
+			Owner: self,
+			Keys:  trusted(append(ownKeys, a.Key)),
+		}})
+		return updates, nil
+	}
+
+	if bytes.Equal(key, a.Key) {
+		return nil, nil
+	}
+
+	// Tell our other endpoints and our contacts' endpoints about our new
+	// endpoint, and tell the new endpoint about every key that we have decided
+	// on.
+	updates = a.appendUpdate(updates, self, without(ownKeys, key), newKey)
+	updates = a.appendUpdate(updates, self, [][]byte{key}, all)
+	for _, contact := range all {
+		if contact.Owner.Equal(self) {
+			continue
+		}
+		updates = a.appendUpdate(updates, contact.Owner, a.authenticated(all, contact.Owner), newKey)
+	}
+	return updates, nil
+}
+
+// Distrust marks the key of owner as distrusted after a manual decision and
+// returns the trust messages that should be sent to our other endpoints as a
+// result.
+func (a *ATM) Distrust(ctx context.Context, owner jid.JID, key []byte) ([]TrustUpdate, error) {
+	owner = owner.Bare()
+	err := a.setTrust(ctx, owner, key, Distrusted)
+	if err != nil {
+		return nil, err
+	}
+	all, err := a.Store.Keys(ctx, a.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	self := a.Addr.Bare()
+	return a.appendUpdate(nil, self, a.authenticated(all, self), []OwnedKeys{{
+		Owner: owner,
+		Keys:  []Key{{KeyID: key}},
+	}}), nil
+}
+
+// authenticated returns the authenticated keys of owner other than the key of
+// our own endpoint.
+func (a *ATM) authenticated(all []OwnedKeys, owner jid.JID) [][]byte {
+	self := owner.Equal(a.Addr.Bare())
+	var keys [][]byte
+	for _, owned := range all {
+		if !owned.Owner.Equal(owner) {
+			continue
+		}
+		for _, k := range owned.Keys {
+			if k.Trusted && !(self && bytes.Equal(k.KeyID, a.Key)) {
+				keys = append(keys, k.KeyID)
+			}
+		}
+	}
+	return keys
+}
+
+func (a *ATM) appendUpdate(updates []TrustUpdate, to jid.JID, recipients [][]byte, keys []OwnedKeys) []TrustUpdate {
+	if len(recipients) == 0 || len(keys) == 0 {
+		return updates
+	}
+	return append(updates, TrustUpdate{
+		To:   to,
+		Keys: recipients,
+		Message: TrustMessage{
+			Usage:      NSATM,
+			Encryption: a.Encryption,
+			Keys:       keys,
+		},
+	})
+}
+
+func without(keys [][]byte, key []byte) [][]byte {
+	var out [][]byte
+	for _, k := range keys {
+		if !bytes.Equal(k, key) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func trusted(keys [][]byte) []Key {
+	var out []Key
+	for _, k := range keys {
+		if len(k) > 0 {
+			out = append(out, Key{Trusted: true, KeyID: k})
+		}
+	}
+	return out
+}
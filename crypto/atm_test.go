@@ -0,0 +1,220 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package crypto_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kamrankamilli/xmpp/crypto"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+const testEncryption = "urn:xmpp:omemo:2"
+
+var (
+	alice = jid.MustParse("alice@example.net")
+	bob   = jid.MustParse("bob@example.net")
+	carol = jid.MustParse("carol@example.net")
+)
+
+func trustMessage(owner jid.JID, keys ...crypto.Key) crypto.TrustMessage {
+	return crypto.TrustMessage{
+		Usage:      crypto.NSATM,
+		Encryption: testEncryption,
+		Keys:       []crypto.OwnedKeys{{Owner: owner, Keys: keys}},
+	}
+}
+
+func expectState(t *testing.T, store crypto.TrustStore, owner jid.JID, key string, want crypto.TrustState) {
+	t.Helper()
+	got, err := store.Trust(context.Background(), testEncryption, owner, []byte(key))
+	if err != nil {
+		t.Fatalf("error loading trust: %v", err)
+	}
+	if got != want {
+		t.Errorf("wrong trust in %s key %q: want=%v, got=%v", owner, key, want, got)
+	}
+}
+
+func TestATMReceive(t *testing.T) {
+	ctx := context.Background()
+	store := crypto.NewTrustStore()
+	atm := &crypto.ATM{
+		Store:      store,
+		Encryption: testEncryption,
+		Addr:       jid.MustParse("alice@example.net/phone"),
+		Key:        []byte("phone"),
+	}
+	for _, k := range []struct {
+		owner jid.JID
+		key   string
+		state crypto.TrustState
+	}{
+		{alice, "phone", crypto.Authenticated},
+		{alice, "tablet", crypto.Authenticated},
+		{bob, "laptop", crypto.Authenticated},
+		{bob, "stolen", crypto.Distrusted},
+	} {
+		err := store.SetTrust(ctx, testEncryption, k.owner, []byte(k.key), k.state)
+		if err != nil {
+			t.Fatalf("error setting trust: %v", err)
+		}
+	}
+
+	// Our own devices can make decisions about any key.
+	err := atm.Receive(ctx, alice, []byte("tablet"), trustMessage(carol, crypto.Key{Trusted: true, KeyID: []byte("desktop")}))
+	if err != nil {
+		t.Fatalf("error receiving trust message: %v", err)
+	}
+	expectState(t, store, carol, "desktop", crypto.Authenticated)
+
+	// Contacts can only make decisions about their own keys.
+	err = atm.Receive(ctx, bob, []byte("laptop"), crypto.TrustMessage{
+		Usage:      crypto.NSATM,
+		Encryption: testEncryption,
+		Keys: []crypto.OwnedKeys{
+			{Owner: bob, Keys: []crypto.Key{{Trusted: true, KeyID: []byte("phone")}}},
+			{Owner: carol, Keys: []crypto.Key{{KeyID: []byte("desktop")}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error receiving trust message: %v", err)
+	}
+	expectState(t, store, bob, "phone", crypto.Authenticated)
+	expectState(t, store, carol, "desktop", crypto.Authenticated)
+
+	// Trust messages from distrusted keys, for other usages, and for other
+	// encryption schemes are ignored.
+	err = atm.Receive(ctx, bob, []byte("stolen"), trustMessage(bob, crypto.Key{Trusted: true, KeyID: []byte("evil")}))
+	if err != nil {
+		t.Fatalf("error receiving trust message: %v", err)
+	}
+	tm := trustMessage(bob, crypto.Key{Trusted: true, KeyID: []byte("evil")})
+	tm.Encryption = "urn:xmpp:openpgp:0"
+	err = atm.Receive(ctx, bob, []byte("laptop"), tm)
+	if err != nil {
+		t.Fatalf("error receiving trust message: %v", err)
+	}
+	tm = trustMessage(bob, crypto.Key{Trusted: true, KeyID: []byte("evil")})
+	tm.Usage = "urn:example"
+	err = atm.Receive(ctx, bob, []byte("laptop"), tm)
+	if err != nil {
+		t.Fatalf("error receiving trust message: %v", err)
+	}
+	expectState(t, store, bob, "evil", crypto.Undecided)
+
+	// Trust messages from keys that have not been authenticated are cached until
+	// the key is authenticated, including any keys that are authenticated as a
+	// result.
+	err = atm.Receive(ctx, carol, []byte("tablet"), trustMessage(carol, crypto.Key{Trusted: true, KeyID: []byte("watch")}))
+	if err != nil {
+		t.Fatalf("error receiving trust message: %v", err)
+	}
+	err = atm.Receive(ctx, carol, []byte("phone"), trustMessage(carol, crypto.Key{Trusted: true, KeyID: []byte("tablet")}))
+	if err != nil {
+		t.Fatalf("error receiving trust message: %v", err)
+	}
+	expectState(t, store, carol, "tablet", crypto.Undecided)
+	_, err = atm.Authenticate(ctx, carol, []byte("phone"))
+	if err != nil {
+		t.Fatalf("error authenticating key: %v", err)
+	}
+	expectState(t, store, carol, "tablet", crypto.Authenticated)
+	expectState(t, store, carol, "watch", crypto.Authenticated)
+	msgs, err := store.TakeTrustMessages(ctx, testEncryption, carol, []byte("tablet"))
+	if err != nil {
+		t.Fatalf("error loading cached trust messages: %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Errorf("cached trust messages were not removed: %+v", msgs)
+	}
+}
+
+func TestATMAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	store := crypto.NewTrustStore()
+	atm := &crypto.ATM{
+		Store:      store,
+		Encryption: testEncryption,
+		Addr:       jid.MustParse("alice@example.net/phone"),
+		Key:        []byte("phone"),
+	}
+	err := store.SetTrust(ctx, testEncryption, alice, []byte("phone"), crypto.Authenticated)
+	if err != nil {
+		t.Fatalf("error setting trust: %v", err)
+	}
+	trusted := func(key string) crypto.Key {
+		return crypto.Key{Trusted: true, KeyID: []byte(key)}
+	}
+	update := func(to jid.JID, keys []string, tm crypto.TrustMessage) crypto.TrustUpdate {
+		u := crypto.TrustUpdate{To: to, Message: tm}
+		for _, k := range keys {
+			u.Keys = append(u.Keys, []byte(k))
+		}
+		return u
+	}
+
+	for i, tc := range []struct {
+		owner   jid.JID
+		key     string
+		updates []crypto.TrustUpdate
+	}{
+		0: {
+			// Nobody else to tell about the first contact key.
+			owner: bob,
+			key:   "laptop",
+			updates: []crypto.TrustUpdate{
+				update(bob, []string{"laptop"}, trustMessage(alice, trusted("phone"))),
+			},
+		},
+		1: {
+			// A new device of our own learns about every key and everyone else
+			// learns about the new device.
+			owner: alice,
+			key:   "tablet",
+			updates: []crypto.TrustUpdate{
+				update(alice, []string{"tablet"}, crypto.TrustMessage{
+					Usage:      crypto.NSATM,
+					Encryption: testEncryption,
+					Keys: []crypto.OwnedKeys{
+						{Owner: alice, Keys: []crypto.Key{trusted("phone"), trusted("tablet")}},
+						{Owner: bob, Keys: []crypto.Key{trusted("laptop")}},
+					},
+				}),
+				update(bob, []string{"laptop"}, trustMessage(alice, trusted("tablet"))),
+			},
+		},
+		2: {
+			owner: bob,
+			key:   "phone",
+			updates: []crypto.TrustUpdate{
+				update(alice, []string{"tablet"}, trustMessage(bob, trusted("phone"))),
+				update(bob, []string{"laptop", "phone"}, trustMessage(alice, trusted("tablet"), trusted("phone"))),
+			},
+		},
+	} {
+		updates, err := atm.Authenticate(ctx, tc.owner, []byte(tc.key))
+		if err != nil {
+			t.Fatalf("%d: error authenticating key: %v", i, err)
+		}
+		if !reflect.DeepEqual(updates, tc.updates) {
+			t.Errorf("%d: wrong updates:\nwant=%+v,\n got=%+v", i, tc.updates, updates)
+		}
+	}
+
+	updates, err := atm.Distrust(ctx, bob, []byte("phone"))
+	if err != nil {
+		t.Fatalf("error distrusting key: %v", err)
+	}
+	want := []crypto.TrustUpdate{
+		update(alice, []string{"tablet"}, trustMessage(bob, crypto.Key{KeyID: []byte("phone")})),
+	}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("wrong updates for distrust:\nwant=%+v,\n got=%+v", want, updates)
+	}
+	expectState(t, store, bob, "phone", crypto.Distrusted)
+}
@@ -0,0 +1,338 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package disco
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Errors returned by CapsCache.
+var (
+	ErrNoCaps       = errors.New("disco: no usable entity capabilities known for the entity")
+	ErrVerification = errors.New("disco: caps verification string does not match info")
+)
+
+// CapsStore persists the service discovery information that entity
+// capabilities refer to.
+//
+// Keys are of the form namespace#algo.hash, for example
+// "urn:xmpp:caps#sha-256.kzBZbkqJ3ADrj7v08reD1qcWUwNGHaidNUgD7nHpiw8=".
+// The information for a key never changes, so entries do not need to expire.
+type CapsStore interface {
+	// Get returns the information stored for key.
+	// If nothing has been stored for key, ok is false.
+	Get(ctx context.Context, key string) (info Info, ok bool, err error)
+
+	// Put stores the information for key.
+	Put(ctx context.Context, key string, info Info) error
+}
+
+// NewCapsStore returns a CapsStore that keeps information in memory.
+func NewCapsStore() CapsStore {
+	return &memCapsStore{
+		info: make(map[string]Info),
+	}
+}
+
+type memCapsStore struct {
+	sync.Mutex
+	info map[string]Info
+}
+
+func (m *memCapsStore) Get(_ context.Context, key string) (Info, bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	info, ok := m.info[key]
+	return info, ok, nil
+}
+
+func (m *memCapsStore) Put(_ context.Context, key string, info Info) error {
+	m.Lock()
+	defer m.Unlock()
+	m.info[key] = info
+	return nil
+}
+
+// HandleCache returns an option that registers the cache to receive entity
+// capabilities from available presence.
+// It may not be used on the same mux as HandleCaps or HandleCaps2, instead the
+// functions passed to them can call SetCaps and SetCaps2.
+// Similarly, if a presence.Tracker is used the cache should be set as its Caps
+// field instead of being registered separately.
+func HandleCache(c *CapsCache) mux.Option {
+	return func(m *mux.ServeMux) {
+		mux.Presence(stanza.AvailablePresence, xml.Name{Space: NSCaps, Local: "c"}, c)(m)
+		mux.Presence(stanza.AvailablePresence, xml.Name{Space: NSCaps2, Local: "c"}, c)(m)
+	}
+}
+
+// CapsCache records the entity capabilities advertised by other entities and
+// looks up and verifies the service discovery information that they refer to
+// the first time that it is needed.
+// Both XEP-0115: Entity Capabilities and XEP-0390: Entity Capabilities 2.0 are
+// supported.
+// Because many entities advertise the same capabilities, once the information
+// has been retrieved it can be used for any entity without another round trip.
+//
+// The zero value is a cache that stores information in memory.
+type CapsCache struct {
+	// Store is used to persist information between sessions.
+	// If nil, information is stored in memory.
+	Store CapsStore
+
+	mu       sync.Mutex
+	store    CapsStore
+	entities map[string]entityCaps
+	inflight map[string]*capsCall
+}
+
+type entityCaps struct {
+	caps  Caps
+	caps2 Caps2
+}
+
+type capsCall struct {
+	done chan struct{}
+	info Info
+	err  error
+}
+
+// capsCandidate is a verification string that information can be looked up by.
+type capsCandidate struct {
+	key    string
+	node   string
+	verify func(Info) bool
+}
+
+// HandlePresence implements mux.PresenceHandler.
+// It should generally be registered on a mux using HandleCache.
+func (c *CapsCache) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+	if p.From.Equal(jid.JID{}) {
+		return nil
+	}
+	// The handler is called once for each payload, so only the payload that the
+	// presence was dispatched for is decoded.
+	pres := struct {
+		stanza.Presence
+		Caps struct {
+			Hash string `xml:"hash,attr"`
+			Node string `xml:"node,attr"`
+			Ver  string `xml:"ver,attr"`
+		} `xml:"http://jabber.org/protocol/caps c"`
+		Caps2 *Caps2 `xml:"urn:xmpp:caps c"`
+	}{}
+	err := xml.NewTokenDecoder(r).Decode(&pres)
+	if err != nil {
+		return err
+	}
+	if pres.Caps2 != nil {
+		c.SetCaps2(p.From, *pres.Caps2)
+	}
+	if pres.Caps.Ver != "" {
+		caps := Caps{Node: pres.Caps.Node, Ver: pres.Caps.Ver}
+		// Legacy caps without a hash function cannot be verified and are ignored.
+		err = (&caps.Hash).UnmarshalXMLAttr(xml.Attr{Value: pres.Caps.Hash})
+		if err == nil {
+			c.SetCaps(p.From, caps)
+		}
+	}
+	return nil
+}
+
+// SetCaps records the XEP-0115 entity capabilities advertised by addr.
+func (c *CapsCache) SetCaps(addr jid.JID, caps Caps) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entities == nil {
+		c.entities = make(map[string]entityCaps)
+	}
+	e := c.entities[addr.String()]
+	e.caps = caps
+	c.entities[addr.String()] = e
+}
+
+// SetCaps2 records the XEP-0390 entity capabilities advertised by addr.
+func (c *CapsCache) SetCaps2(addr jid.JID, caps Caps2) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entities == nil {
+		c.entities = make(map[string]entityCaps)
+	}
+	e := c.entities[addr.String()]
+	e.caps2 = caps
+	c.entities[addr.String()] = e
+}
+
+// Forget removes any entity capabilities recorded for addr, for example
+// because it has gone offline.
+// Information that has already been retrieved is kept.
+func (c *CapsCache) Forget(addr jid.JID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entities, addr.String())
+}
+
+// Info returns the service discovery information of addr as advertised by its
+// entity capabilities.
+// If the information is not already known it is requested from addr over s and
+// verified before being stored.
+// If no entity capabilities using a supported hash function have been recorded
+// for addr, ErrNoCaps is returned.
+func (c *CapsCache) Info(ctx context.Context, s *xmpp.Session, addr jid.JID) (Info, error) {
+	candidates := c.candidates(addr)
+	if len(candidates) == 0 {
+		return Info{}, ErrNoCaps
+	}
+	store := c.getStore()
+	for _, cand := range candidates {
+		info, ok, err := store.Get(ctx, cand.key)
+		if err != nil {
+			return Info{}, err
+		}
+		if ok {
+			return info, nil
+		}
+	}
+
+	var err error
+	for _, cand := range candidates {
+		var info Info
+		info, err = c.resolve(ctx, s, addr, cand, candidates)
+		if err == nil {
+			return info, nil
+		}
+		// Only try the next hash if the entity lied about this one.
+		if !errors.Is(err, ErrVerification) {
+			return Info{}, err
+		}
+	}
+	return Info{}, err
+}
+
+// Supports reports whether addr advertises support for feature.
+// See Info for details.
+func (c *CapsCache) Supports(ctx context.Context, s *xmpp.Session, addr jid.JID, feature string) (bool, error) {
+	info, err := c.Info(ctx, s, addr)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range info.Features {
+		if f.Var == feature {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *CapsCache) getStore() CapsStore {
+	if c.Store != nil {
+		return c.Store
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.store == nil {
+		c.store = NewCapsStore()
+	}
+	return c.store
+}
+
+// candidates returns the verification strings that addr advertised using
+// available hash functions, preferring XEP-0390.
+func (c *CapsCache) candidates(addr jid.JID) []capsCandidate {
+	c.mu.Lock()
+	e, ok := c.entities[addr.String()]
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	var candidates []capsCandidate
+	for _, h := range e.caps2.Hashes {
+		if !h.Hash.Available() {
+			continue
+		}
+		h := h
+		node := Caps2Node(h)
+		candidates = append(candidates, capsCandidate{
+			key:  node,
+			node: node,
+			verify: func(info Info) bool {
+				return bytes.Equal(info.HashCaps2(h.Hash.New()), h.Out)
+			},
+		})
+	}
+	if caps := e.caps; caps.Ver != "" && caps.Hash.Available() {
+		candidates = append(candidates, capsCandidate{
+			key:  NSCaps + "#" + caps.Hash.String() + "." + caps.Ver,
+			node: caps.Node + "#" + caps.Ver,
+			verify: func(info Info) bool {
+				return info.Hash(caps.Hash.New()) == caps.Ver
+			},
+		})
+	}
+	return candidates
+}
+
+// resolve looks up the information for cand and stores it under every key
+// that it matches.
+// Concurrent lookups of the same key share a single request.
+func (c *CapsCache) resolve(ctx context.Context, s *xmpp.Session, addr jid.JID, cand capsCandidate, all []capsCandidate) (Info, error) {
+	c.mu.Lock()
+	if call, ok := c.inflight[cand.key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.info, call.err
+		case <-ctx.Done():
+			return Info{}, ctx.Err()
+		}
+	}
+	if c.inflight == nil {
+		c.inflight = make(map[string]*capsCall)
+	}
+	call := &capsCall{done: make(chan struct{})}
+	c.inflight[cand.key] = call
+	c.mu.Unlock()
+
+	call.info, call.err = c.fetch(ctx, s, addr, cand, all)
+
+	c.mu.Lock()
+	delete(c.inflight, cand.key)
+	c.mu.Unlock()
+	close(call.done)
+	return call.info, call.err
+}
+
+func (c *CapsCache) fetch(ctx context.Context, s *xmpp.Session, addr jid.JID, cand capsCandidate, all []capsCandidate) (Info, error) {
+	info, err := GetInfo(ctx, cand.node, addr, s)
+	if err != nil {
+		return Info{}, err
+	}
+	info.Node = ""
+	if !cand.verify(info) {
+		return Info{}, ErrVerification
+	}
+	store := c.getStore()
+	for _, other := range all {
+		if other.key != cand.key && !other.verify(info) {
+			continue
+		}
+		err = store.Put(ctx, other.key, info)
+		if err != nil {
+			return Info{}, err
+		}
+	}
+	return info, nil
+}
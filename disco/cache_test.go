@@ -0,0 +1,373 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package disco_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/crypto"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+var bombusInfo = disco.Info{
+	Identity: []info.Identity{{
+		Category: "client",
+		Type:     "mobile",
+		Name:     "BombusMod",
+	}},
+	Features: []info.Feature{
+		{Var: "http://jabber.org/protocol/si"},
+		{Var: "http://jabber.org/protocol/bytestreams"},
+		{Var: "http://jabber.org/protocol/chatstates"},
+		{Var: "http://jabber.org/protocol/disco#info"},
+		{Var: "http://jabber.org/protocol/disco#items"},
+		{Var: "urn:xmpp:ping"},
+		{Var: "jabber:iq:time"},
+		{Var: "jabber:iq:privacy"},
+		{Var: "jabber:iq:version"},
+		{Var: "http://jabber.org/protocol/rosterx"},
+		{Var: "urn:xmpp:time"},
+		{Var: "jabber:x:oob"},
+		{Var: "http://jabber.org/protocol/ibb"},
+		{Var: "http://jabber.org/protocol/si/profile/file-transfer"},
+		{Var: "urn:xmpp:receipts"},
+		{Var: "jabber:iq:roster"},
+		{Var: "jabber:iq:last"},
+	},
+}
+
+func TestVerificationCaps2(t *testing.T) {
+	const out = "kzBZbkqJ3ADrj7v08reD1qcWUwNGHaidNUgD7nHpiw8="
+	hash := bombusInfo.HashCaps2(sha256.New())
+	if s := base64.StdEncoding.EncodeToString(hash); s != out {
+		t.Fatalf("wrong hash output: want=%s, got=%s", out, s)
+	}
+	c := disco.NewCaps2(bombusInfo, crypto.SHA256)
+	if node := disco.Caps2Node(c.Hashes[0]); node != disco.NSCaps2+"#sha-256."+out {
+		t.Errorf("wrong node: %s", node)
+	}
+}
+
+var caps2TestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: &disco.Caps2{
+			XMLName: xml.Name{Space: disco.NSCaps2, Local: "c"},
+			Hashes: []crypto.HashOutput{{
+				Hash: crypto.SHA256,
+				Out:  []byte{1, 2, 3},
+			}},
+		},
+		XML: `<c xmlns="urn:xmpp:caps"><hash xmlns="urn:xmpp:hashes:2" algo="sha-256">AQID</hash></c>`,
+	},
+	1: {
+		NoMarshal: true,
+		Value: &disco.Caps2{
+			XMLName: xml.Name{Space: disco.NSCaps2, Local: "c"},
+			Hashes: []crypto.HashOutput{{
+				Hash: crypto.SHA256,
+				Out:  []byte{1, 2, 3},
+			}},
+		},
+		XML: `<c xmlns="urn:xmpp:caps"><hash xmlns="urn:xmpp:hashes:2" algo="md5">AQID</hash><hash xmlns="urn:xmpp:hashes:2" algo="sha-256">AQID</hash></c>`,
+	},
+}
+
+func TestEncodeCaps2(t *testing.T) {
+	xmpptest.RunEncodingTests(t, caps2TestCases)
+}
+
+// infoServer answers all disco#info queries with i and counts them.
+func infoServer(i disco.Info, count *int32) mux.Option {
+	return mux.IQFunc(stanza.GetIQ, xml.Name{Space: disco.NSInfo, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		atomic.AddInt32(count, 1)
+		_, err := xmlstream.Copy(t, iq.Result(i.TokenReader()))
+		return err
+	})
+}
+
+func TestCapsCache(t *testing.T) {
+	exodus := verificationTestCases[0].info
+	ver := exodus.Hash(sha1.New())
+	bombus := disco.NewCaps2(bombusInfo, crypto.SHA256)
+
+	for i, tc := range []struct {
+		info   disco.Info
+		caps   disco.Caps
+		caps2  disco.Caps2
+		err    error
+		lookup bool
+		ping   bool
+	}{
+		0: {info: exodus, caps: disco.Caps{Hash: crypto.SHA1, Node: "https://example.net", Ver: ver}, lookup: true},
+		1: {info: bombusInfo, caps2: bombus, lookup: true, ping: true},
+		2: {info: exodus, caps2: bombus, err: disco.ErrVerification, lookup: true},
+		3: {info: exodus, err: disco.ErrNoCaps},
+		4: {
+			info:   bombusInfo,
+			caps:   disco.Caps{Hash: crypto.SHA1, Node: "https://example.net", Ver: ver},
+			caps2:  bombus,
+			lookup: true,
+			ping:   true,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var count int32
+			cs := xmpptest.NewClientServer(
+				xmpptest.ServerHandler(mux.New(stanza.NSClient, infoServer(tc.info, &count))),
+			)
+			addr := jid.MustParse("juliet@example.com/balcony")
+			cache := &disco.CapsCache{}
+			if tc.caps.Ver != "" {
+				cache.SetCaps(addr, tc.caps)
+			}
+			cache.SetCaps2(addr, tc.caps2)
+
+			ctx := context.Background()
+			for j := 0; j < 2; j++ {
+				ok, err := cache.Supports(ctx, cs.Client, addr, "urn:xmpp:ping")
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("wrong error: want=%v, got=%v", tc.err, err)
+				}
+				if ok != tc.ping {
+					t.Errorf("wrong support for feature: want=%t, got=%t", tc.ping, ok)
+				}
+			}
+			want := int32(0)
+			switch {
+			case tc.lookup && tc.err == nil:
+				want = 1
+			case tc.lookup:
+				want = 2
+			}
+			if count != want {
+				t.Errorf("wrong number of lookups: want=%d, got=%d", want, count)
+			}
+		})
+	}
+}
+
+func TestCapsCacheStore(t *testing.T) {
+	var count int32
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(stanza.NSClient, infoServer(bombusInfo, &count))),
+	)
+	store := disco.NewCapsStore()
+	caps := disco.NewCaps2(bombusInfo, crypto.SHA256)
+	ctx := context.Background()
+
+	first := &disco.CapsCache{Store: store}
+	first.SetCaps2(jid.MustParse("juliet@example.com/balcony"), caps)
+	_, err := first.Info(ctx, cs.Client, jid.MustParse("juliet@example.com/balcony"))
+	if err != nil {
+		t.Fatalf("error fetching info: %v", err)
+	}
+
+	second := &disco.CapsCache{Store: store}
+	second.SetCaps2(jid.MustParse("romeo@example.net/orchard"), caps)
+	info, err := second.Info(ctx, cs.Client, jid.MustParse("romeo@example.net/orchard"))
+	if err != nil {
+		t.Fatalf("error fetching info: %v", err)
+	}
+	if len(info.Features) != len(bombusInfo.Features) {
+		t.Errorf("wrong info: %+v", info)
+	}
+	if count != 1 {
+		t.Errorf("expected info to be fetched once, got %d requests", count)
+	}
+
+	second.Forget(jid.MustParse("romeo@example.net/orchard"))
+	_, err = second.Info(ctx, cs.Client, jid.MustParse("romeo@example.net/orchard"))
+	if !errors.Is(err, disco.ErrNoCaps) {
+		t.Errorf("wrong error after forgetting entity: %v", err)
+	}
+}
+
+func TestHandleCache(t *testing.T) {
+	cache := &disco.CapsCache{}
+	m := mux.New(stanza.NSClient, disco.HandleCache(cache))
+	var count int32
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(stanza.NSClient, infoServer(bombusInfo, &count))),
+	)
+
+	addr := jid.MustParse("juliet@example.com/balcony")
+	p := stanza.Presence{XMLName: xml.Name{Space: stanza.NSClient, Local: "presence"}, From: addr}
+	r := p.Wrap(disco.NewCaps2(bombusInfo, crypto.SHA256).TokenReader())
+	d := xml.NewTokenDecoder(r)
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatalf("error reading start token: %v", err)
+	}
+	start := tok.(xml.StartElement)
+	err = m.HandleXMPP(struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: d,
+		Encoder:     xml.NewEncoder(discard{}),
+	}, &start)
+	if err != nil {
+		t.Fatalf("error handling presence: %v", err)
+	}
+
+	ok, err := cache.Supports(context.Background(), cs.Client, addr, "urn:xmpp:receipts")
+	if err != nil {
+		t.Fatalf("error checking support: %v", err)
+	}
+	if !ok {
+		t.Errorf("expected feature to be supported")
+	}
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestAdvertiseCaps(t *testing.T) {
+	cache := &disco.CapsCache{}
+	received := make(chan struct{}, 1)
+	serverMux := mux.New(stanza.NSClient, disco.HandleCache(cache))
+	clientMux := mux.New("", disco.Handle(), mux.Feature(crypto.Features(crypto.SHA256)))
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(clientMux),
+		xmpptest.ServerHandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "from"}, Value: "test@example.net"})
+			err := serverMux.HandleXMPP(t, start)
+			received <- struct{}{}
+			return err
+		}),
+	)
+
+	err := disco.AdvertiseCaps(cs.Client, clientMux, "https://example.net")
+	if err != nil {
+		t.Fatalf("error advertising caps: %v", err)
+	}
+	ctx := context.Background()
+	err = cs.Client.Send(ctx, stanza.Presence{}.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending presence: %v", err)
+	}
+	<-received
+
+	clientAddr := cs.Client.LocalAddr()
+	feature, err := crypto.SHA256.Namespace()
+	if err != nil {
+		t.Fatalf("error getting feature: %v", err)
+	}
+	ok, err := cache.Supports(ctx, cs.Server, clientAddr, feature)
+	if err != nil {
+		t.Fatalf("error checking support: %v", err)
+	}
+	if !ok {
+		t.Errorf("expected advertised feature %q to be supported", feature)
+	}
+
+	// Both XEP-0115 and XEP-0390 nodes are answered.
+	info, err := disco.MuxInfo(clientMux, "")
+	if err != nil {
+		t.Fatalf("error calculating info: %v", err)
+	}
+	caps := disco.NewCaps(info, "https://example.net", crypto.SHA1)
+	for _, node := range []string{
+		caps.Node + "#" + caps.Ver,
+		disco.Caps2Node(disco.NewCaps2(info, crypto.SHA256).Hashes[0]),
+	} {
+		nodeInfo, err := disco.GetInfo(ctx, node, clientAddr, cs.Server)
+		if err != nil {
+			t.Fatalf("error querying node %s: %v", node, err)
+		}
+		if len(nodeInfo.Features) != len(info.Features) {
+			t.Errorf("wrong features for node %s: want=%v, got=%v", node, info.Features, nodeInfo.Features)
+		}
+	}
+	nodeInfo, err := disco.GetInfo(ctx, "https://example.net#bad", clientAddr, cs.Server)
+	if err != nil {
+		t.Fatalf("error querying unknown node: %v", err)
+	}
+	if len(nodeInfo.Features) != 0 {
+		t.Errorf("expected no features for unknown node, got %v", nodeInfo.Features)
+	}
+}
+
+type toggleFeature struct {
+	enabled atomic.Bool
+}
+
+func (f *toggleFeature) ForFeatures(node string, fn func(info.Feature) error) error {
+	if node != "" || !f.enabled.Load() {
+		return nil
+	}
+	return fn(info.Feature{Var: "urn:example:toggle"})
+}
+
+func TestAdvertiseCapsLive(t *testing.T) {
+	feature := &toggleFeature{}
+	received := make(chan disco.Caps, 1)
+	serverMux := mux.New(stanza.NSClient, disco.HandleCaps(func(_ stanza.Presence, c disco.Caps) {
+		received <- c
+	}))
+	clientMux := mux.New("", disco.Handle(), mux.Feature(feature))
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(clientMux),
+		xmpptest.ServerHandler(serverMux),
+	)
+
+	err := disco.AdvertiseCaps(cs.Client, clientMux, "https://example.net")
+	if err != nil {
+		t.Fatalf("error advertising caps: %v", err)
+	}
+	ctx := context.Background()
+	err = cs.Client.Send(ctx, stanza.Presence{}.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending presence: %v", err)
+	}
+	before := <-received
+
+	feature.enabled.Store(true)
+	err = cs.Client.Send(ctx, stanza.Presence{}.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending presence: %v", err)
+	}
+	after := <-received
+	if before.Ver == after.Ver {
+		t.Fatalf("expected ver to change after registering a feature, got %q", after.Ver)
+	}
+
+	nodeInfo, err := disco.GetInfo(ctx, after.Node+"#"+after.Ver, cs.Client.LocalAddr(), cs.Server)
+	if err != nil {
+		t.Fatalf("error querying node: %v", err)
+	}
+	var found bool
+	for _, f := range nodeInfo.Features {
+		if f.Var == "urn:example:toggle" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected advertised node to include new feature, got %v", nodeInfo.Features)
+	}
+}
+
+func TestAdvertiseCapsUnavailable(t *testing.T) {
+	cs := xmpptest.NewClientServer()
+	err := disco.AdvertiseCaps(cs.Client, mux.New(""), "https://example.net", crypto.Hash(0))
+	if err == nil {
+		t.Errorf("expected error advertising caps with unavailable hash")
+	}
+}
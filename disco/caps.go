@@ -7,6 +7,7 @@ package disco
 import (
 	"context"
 	"encoding/xml"
+	"fmt"
 
 	"mellium.im/xmlstream"
 	"github.com/kamrankamilli/xmpp"
@@ -32,6 +33,58 @@ func HandleCaps(f func(stanza.Presence, Caps)) mux.Option {
 	})
 }
 
+// AdvertiseCaps configures s to include entity capabilities in every available
+// presence that it sends.
+// The capabilities are calculated from the features, identities, and forms
+// registered on m each time a presence is sent, and m should also be
+// configured using Handle so that other entities can look them up.
+//
+// Capabilities are advertised using XEP-0115 with the SHA-1 hash function and
+// node, which should uniquely identify the software (eg.
+// https://example.com/myclient), and using XEP-0390 with each of the hash
+// functions h, or SHA-256 if none are provided.
+// If any of the hash functions are not available an error is returned.
+func AdvertiseCaps(s *xmpp.Session, m *mux.ServeMux, node string, h ...crypto.Hash) error {
+	if len(h) == 0 {
+		h = []crypto.Hash{crypto.SHA256}
+	}
+	for _, hash := range append([]crypto.Hash{crypto.SHA1}, h...) {
+		if !hash.Available() {
+			return fmt.Errorf("disco: hash function %s is not available", hash.HashFunc())
+		}
+	}
+	_, err := MuxInfo(m, "")
+	if err != nil {
+		return err
+	}
+	s.SetPresencePayload(xml.Name{Space: NSCaps, Local: "c"}, func() xml.TokenReader {
+		i, err := MuxInfo(m, "")
+		if err != nil {
+			return xmlstream.MultiReader()
+		}
+		return NewCaps(i, node, crypto.SHA1).TokenReader()
+	})
+	s.SetPresencePayload(xml.Name{Space: NSCaps2, Local: "c"}, func() xml.TokenReader {
+		i, err := MuxInfo(m, "")
+		if err != nil {
+			return xmlstream.MultiReader()
+		}
+		return NewCaps2(i, h...).TokenReader()
+	})
+	return nil
+}
+
+// NewCaps calculates the XEP-0115 entity capabilities of i using the hash
+// function h.
+// NewCaps panics if the hash function is not available.
+func NewCaps(i Info, node string, h crypto.Hash) Caps {
+	return Caps{
+		Hash: h,
+		Node: node,
+		Ver:  i.Hash(h.New()),
+	}
+}
+
 // StreamFeature is an informational stream feature that saves any entity caps
 // information that was published by the server during session negotiation.
 // StreamFeature should not be used on the server side.
@@ -0,0 +1,204 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package disco
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"hash"
+	"io"
+	"sort"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/crypto"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Separators used when generating the XEP-0390 hash input.
+const (
+	unitSep   = 0x1f
+	recordSep = 0x1e
+	groupSep  = 0x1d
+	fileSep   = 0x1c
+)
+
+// HandleCaps2 calls f for each incoming presence containing XEP-0390: Entity
+// Capabilities 2.0 information.
+func HandleCaps2(f func(stanza.Presence, Caps2)) mux.Option {
+	return mux.PresenceFunc("", xml.Name{Space: NSCaps2, Local: "c"}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+		s := struct {
+			stanza.Presence
+			Caps Caps2
+		}{}
+		err := xml.NewTokenDecoder(r).Decode(&s)
+		if err != nil {
+			return err
+		}
+		f(p, s.Caps)
+		return nil
+	})
+}
+
+// Caps2 advertises entity capabilities using XEP-0390: Entity Capabilities 2.0.
+// Unlike Caps it may contain several hashes of the same Info, each calculated
+// using a different hash function.
+type Caps2 struct {
+	XMLName xml.Name            `xml:"urn:xmpp:caps c"`
+	Hashes  []crypto.HashOutput `xml:"urn:xmpp:hashes:2 hash"`
+}
+
+// NewCaps2 calculates the entity capabilities of i using each of the provided
+// hash functions.
+// NewCaps2 panics if any of the hash functions are not available.
+func NewCaps2(i Info, h ...crypto.Hash) Caps2 {
+	c := Caps2{}
+	for _, hash := range h {
+		c.Hashes = append(c.Hashes, crypto.HashOutput{
+			Hash: hash,
+			Out:  i.HashCaps2(hash.New()),
+		})
+	}
+	return c
+}
+
+// Caps2Node returns the service discovery node that can be queried to retrieve
+// the Info that resulted in the hash output h.
+func Caps2Node(h crypto.HashOutput) string {
+	return NSCaps2 + "#" + h.Hash.String() + "." + base64.StdEncoding.EncodeToString(h.Out)
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (c Caps2) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, h := range c.Hashes {
+		inner = append(inner, h.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NSCaps2, Local: "c"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (c Caps2) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (c Caps2) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// Hashes using unknown hash functions are skipped.
+func (c *Caps2) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	c.XMLName = start.Name
+	c.Hashes = c.Hashes[:0]
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space != crypto.NS || t.Name.Local != "hash" {
+				err = d.Skip()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			var algo string
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "algo" {
+					algo = attr.Value
+					break
+				}
+			}
+			if _, err = crypto.Parse(algo); err != nil {
+				err = d.Skip()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			h := crypto.HashOutput{}
+			err = d.DecodeElement(&h, &t)
+			if err != nil {
+				return err
+			}
+			c.Hashes = append(c.Hashes, h)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// HashCaps2 returns the XEP-0390: Entity Capabilities 2.0 hash of the info.
+// Unlike Hash, the output is not base64 encoded.
+func (i Info) HashCaps2(h hash.Hash) []byte {
+	features := make([][]byte, 0, len(i.Features))
+	for _, f := range i.Features {
+		features = append(features, append([]byte(f.Var), unitSep))
+	}
+	writeSorted(h, features, fileSep)
+
+	identities := make([][]byte, 0, len(i.Identity))
+	for _, ident := range i.Identity {
+		var b []byte
+		for _, s := range []string{ident.Category, ident.Type, ident.Lang, ident.Name} {
+			b = append(b, s...)
+			b = append(b, unitSep)
+		}
+		identities = append(identities, append(b, recordSep))
+	}
+	writeSorted(h, identities, fileSep)
+
+	forms := make([][]byte, 0, len(i.Form))
+	for _, infoForm := range i.Form {
+		forms = append(forms, formCaps2(infoForm))
+	}
+	writeSorted(h, forms, fileSep)
+
+	return h.Sum(nil)
+}
+
+// formCaps2 returns the hash input for a data form.
+// Unlike the legacy entity capabilities hash, FORM_TYPE is treated like any
+// other field.
+func formCaps2(infoForm form.Data) []byte {
+	fields := make([][]byte, 0, infoForm.Len())
+	infoForm.ForFields(func(f form.FieldData) {
+		vals, _ := infoForm.Raw(f.Var)
+		vals = append([]string(nil), vals...)
+		sort.Strings(vals)
+		b := append([]byte(f.Var), unitSep)
+		for _, val := range vals {
+			b = append(b, val...)
+			b = append(b, unitSep)
+		}
+		fields = append(fields, append(b, recordSep))
+	})
+	buf := &bytes.Buffer{}
+	writeSorted(buf, fields, groupSep)
+	return buf.Bytes()
+}
+
+// writeSorted sorts the octet strings b, writes them to w, and then writes sep.
+func writeSorted(w io.Writer, b [][]byte, sep byte) {
+	sort.Slice(b, func(i, j int) bool {
+		return bytes.Compare(b[i], b[j]) < 0
+	})
+	for _, s := range b {
+		/* #nosec */
+		w.Write(s)
+	}
+	/* #nosec */
+	w.Write([]byte{sep})
+}
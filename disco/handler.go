@@ -5,9 +5,13 @@
 package disco
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/xml"
+	"strings"
 
 	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/crypto"
 	"github.com/kamrankamilli/xmpp/disco/info"
 	"github.com/kamrankamilli/xmpp/disco/items"
 	"github.com/kamrankamilli/xmpp/form"
@@ -18,6 +22,10 @@ import (
 // Handle returns an option that configures a multiplexer to handle service
 // discovery requests by iterating over its own handlers and checking if they
 // implement info.FeatureIter, info.IdentityIter, form.Iter, or items.Iter.
+//
+// Info queries for a node of the form node#ver where ver is the entity
+// capabilities verification string of the multiplexer are answered in the same
+// way as queries without a node (see AdvertiseCaps).
 func Handle() mux.Option {
 	return func(m *mux.ServeMux) {
 		h := &discoHandler{ServeMux: m}
@@ -44,6 +52,9 @@ func (h *discoHandler) HandleIQ(iq stanza.IQ, r xmlstream.TokenReadEncoder, star
 			break
 		}
 	}
+	if start.Name.Space == NSInfo && h.isCapsNode(node) {
+		node = ""
+	}
 
 	go func() {
 		switch start.Name.Space {
@@ -102,3 +113,82 @@ func (h *discoHandler) HandleIQ(iq stanza.IQ, r xmlstream.TokenReadEncoder, star
 	)))
 	return err
 }
+
+// capsHashes are the hash functions that XEP-0115 verification strings are
+// checked against.
+// The hash function used is not part of the node, so only common ones are
+// tried.
+var capsHashes = []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA512}
+
+// isCapsNode reports whether node refers to the entity capabilities of the
+// multiplexer.
+func (h *discoHandler) isCapsNode(node string) bool {
+	idx := strings.LastIndexByte(node, '#')
+	if idx == -1 {
+		return false
+	}
+	prefix, ver := node[:idx], node[idx+1:]
+	if prefix == NSCaps2 {
+		algo, out, ok := strings.Cut(ver, ".")
+		if !ok {
+			return false
+		}
+		hash, err := crypto.Parse(algo)
+		if err != nil || !hash.Available() {
+			return false
+		}
+		b, err := base64.StdEncoding.DecodeString(out)
+		if err != nil {
+			return false
+		}
+		i, err := MuxInfo(h.ServeMux, "")
+		return err == nil && bytes.Equal(i.HashCaps2(hash.New()), b)
+	}
+	i, err := MuxInfo(h.ServeMux, "")
+	if err != nil {
+		return false
+	}
+	for _, hash := range capsHashes {
+		if hash.Available() && i.Hash(hash.New()) == ver {
+			return true
+		}
+	}
+	return false
+}
+
+// MuxInfo returns the features, identities, and forms for node that are
+// registered on m in the same way that they would be reported by the service
+// discovery handler.
+func MuxInfo(m *mux.ServeMux, node string) (Info, error) {
+	i := Info{InfoQuery: InfoQuery{Node: node}}
+	seen := make(map[string]struct{})
+	err := m.ForFeatures(node, func(f info.Feature) error {
+		if _, ok := seen[f.Var]; ok {
+			return nil
+		}
+		seen[f.Var] = struct{}{}
+		i.Features = append(i.Features, f)
+		return nil
+	})
+	if err != nil {
+		return i, err
+	}
+	seen = make(map[string]struct{})
+	err = m.ForIdentities(node, func(ident info.Identity) error {
+		key := ident.Category + ":" + ident.Type + ":" + ident.Name + ":" + ident.Lang
+		if _, ok := seen[key]; ok {
+			return nil
+		}
+		seen[key] = struct{}{}
+		i.Identity = append(i.Identity, ident)
+		return nil
+	})
+	if err != nil {
+		return i, err
+	}
+	err = m.ForForms(node, func(f *form.Data) error {
+		i.Form = append(i.Form, *f)
+		return nil
+	})
+	return i, err
+}
@@ -68,7 +68,7 @@
 // The default Negotiator and related functions use a list of StreamFeature's to
 // negotiate the state of the session.
 // Implementations of the most commonly used features (StartTLS, SASL-based
-// authentication, and resource binding) are provided.
+// authentication, resource binding, and stream management) are provided.
 // Custom stream features may be created using the StreamFeature struct.
 // StreamFeatures defined in this module are safe for concurrent use by multiple
 // goroutines and may be created once and then re-used.
@@ -0,0 +1,534 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mellium.im/sasl"
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/internal/ns"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// DefaultFASTLifetime is the lifetime of FAST tokens issued by servers if no
+// other lifetime is configured.
+const DefaultFASTLifetime = 14 * 24 * time.Hour
+
+var (
+	errFASTReplay   = errors.New("xmpp: FAST token count was already used")
+	errFASTNotFound = errors.New("xmpp: FAST token not found")
+)
+
+// fastMechanisms are the HT mechanisms advertised by servers in order of
+// preference.
+var fastMechanisms = []string{"HT-SHA-256-EXPR", "HT-SHA-256-ENDP", "HT-SHA-256-NONE"}
+
+// FASTToken is a token used for XEP-0484: Fast Authentication Streamlining
+// Tokens.
+type FASTToken struct {
+	// Mechanism is the HT mechanism that the token can be used with, for example
+	// HT-SHA-256-NONE.
+	Mechanism string
+
+	// Token is the secret token issued by the server.
+	Token string
+
+	// Expiry is the time after which the token can no longer be used.
+	Expiry time.Time
+
+	// Count is used to prevent replay attacks.
+	// Clients increment it every time they authenticate using the token and
+	// servers reject counts that are not greater than the last count that they
+	// saw.
+	Count uint32
+}
+
+func (t FASTToken) expired(now time.Time) bool {
+	return !t.Expiry.IsZero() && !now.Before(t.Expiry)
+}
+
+// FASTClientStore is used by clients to persist the FAST token issued by the
+// server for an account.
+type FASTClientStore interface {
+	// Token returns the token for the bare JID addr.
+	// If no token exists, the zero value is returned.
+	Token(ctx context.Context, addr jid.JID) (FASTToken, error)
+
+	// SetToken replaces the token for the bare JID addr.
+	// Setting the zero value removes the token.
+	SetToken(ctx context.Context, addr jid.JID, token FASTToken) error
+}
+
+// NewFASTClientStore returns a FASTClientStore that keeps tokens in memory.
+func NewFASTClientStore() FASTClientStore {
+	return &memFASTClientStore{tokens: make(map[string]FASTToken)}
+}
+
+type memFASTClientStore struct {
+	sync.Mutex
+	tokens map[string]FASTToken
+}
+
+func (m *memFASTClientStore) Token(_ context.Context, addr jid.JID) (FASTToken, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.tokens[addr.String()], nil
+}
+
+func (m *memFASTClientStore) SetToken(_ context.Context, addr jid.JID, token FASTToken) error {
+	m.Lock()
+	defer m.Unlock()
+	if token == (FASTToken{}) {
+		delete(m.tokens, addr.String())
+		return nil
+	}
+	m.tokens[addr.String()] = token
+	return nil
+}
+
+// FASTServerStore is used by servers to persist the FAST tokens that have been
+// issued to each user agent of an account.
+// User agents are identified by the ID sent in their UserAgent.
+type FASTServerStore interface {
+	// Tokens returns the tokens that are valid for the user agent, the most
+	// recently issued token first.
+	Tokens(ctx context.Context, username, agent string) ([]FASTToken, error)
+
+	// AddToken stores a newly issued token for the user agent.
+	// The previously issued token remains valid until the new token is used so
+	// that clients that did not receive the new token can still authenticate.
+	AddToken(ctx context.Context, username, agent string, token FASTToken) error
+
+	// Use records that the token was used to authenticate with count.
+	// It must return an error if count is not greater than the count that the
+	// token was last used with.
+	// If the most recently issued token is used any older tokens are removed.
+	Use(ctx context.Context, username, agent, token string, count uint32) error
+
+	// Invalidate removes all tokens issued to the user agent.
+	Invalidate(ctx context.Context, username, agent string) error
+}
+
+// NewFASTServerStore returns a FASTServerStore that keeps tokens in memory.
+func NewFASTServerStore() FASTServerStore {
+	return &memFASTServerStore{tokens: make(map[string][]FASTToken)}
+}
+
+type memFASTServerStore struct {
+	sync.Mutex
+	tokens map[string][]FASTToken
+}
+
+func fastKey(username, agent string) string {
+	return username + "\x00" + agent
+}
+
+func (m *memFASTServerStore) Tokens(_ context.Context, username, agent string) ([]FASTToken, error) {
+	m.Lock()
+	defer m.Unlock()
+	return append([]FASTToken(nil), m.tokens[fastKey(username, agent)]...), nil
+}
+
+func (m *memFASTServerStore) AddToken(_ context.Context, username, agent string, token FASTToken) error {
+	m.Lock()
+	defer m.Unlock()
+	k := fastKey(username, agent)
+	tokens := m.tokens[k]
+	if len(tokens) > 1 {
+		tokens = tokens[:1]
+	}
+	m.tokens[k] = append([]FASTToken{token}, tokens...)
+	return nil
+}
+
+func (m *memFASTServerStore) Use(_ context.Context, username, agent, token string, count uint32) error {
+	m.Lock()
+	defer m.Unlock()
+	k := fastKey(username, agent)
+	tokens := m.tokens[k]
+	for i, t := range tokens {
+		if t.Token != token {
+			continue
+		}
+		if count <= t.Count {
+			return errFASTReplay
+		}
+		tokens[i].Count = count
+		if i == 0 {
+			m.tokens[k] = tokens[:1]
+		}
+		return nil
+	}
+	return errFASTNotFound
+}
+
+func (m *memFASTServerStore) Invalidate(_ context.Context, username, agent string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.tokens, fastKey(username, agent))
+	return nil
+}
+
+// htMechanism returns the hash function and channel binding type used by the
+// HT mechanism with the given name.
+// The channel binding type is empty for -NONE mechanisms.
+func htMechanism(name string) (fn func() hash.Hash, binding string, ok bool) {
+	if !strings.HasPrefix(name, "HT-") {
+		return nil, "", false
+	}
+	idx := strings.LastIndexByte(name, '-')
+	switch name[3:idx] {
+	case "SHA-256":
+		fn = sha256.New
+	case "SHA-512":
+		fn = sha512.New
+	default:
+		return nil, "", false
+	}
+	switch name[idx+1:] {
+	case "NONE":
+	case "UNIQ":
+		binding = cbTLSUnique
+	case "EXPR":
+		binding = cbTLSExporter
+	case "ENDP":
+		binding = cbTLSServerEndPoint
+	default:
+		return nil, "", false
+	}
+	return fn, binding, true
+}
+
+// htData returns the channel binding data used by the HT mechanism with the
+// given name on the current connection.
+func htData(s *Session, name string) (fn func() hash.Hash, cb []byte, err error) {
+	fn, binding, ok := htMechanism(name)
+	if !ok {
+		return nil, nil, errNoMechanisms
+	}
+	if binding == "" {
+		return fn, nil, nil
+	}
+	cb, err = s.channelBindingData(binding)
+	return fn, cb, err
+}
+
+func htMAC(fn func() hash.Hash, token string, label string, cb []byte) []byte {
+	h := hmac.New(fn, []byte(token))
+	/* #nosec */
+	h.Write([]byte(label))
+	/* #nosec */
+	h.Write(cb)
+	return h.Sum(nil)
+}
+
+// fastClient holds the state of a client using FAST during SASL2
+// authentication.
+type fastClient struct {
+	store FASTClientStore
+	addr  jid.JID
+
+	// The token used for authentication, if any.
+	token FASTToken
+
+	// The mechanism of the token that was requested, if any.
+	request string
+}
+
+// newFASTClient determines whether a stored token can be used to authenticate
+// or, if not, which mechanism a new token should be requested for.
+func newFASTClient(ctx context.Context, session *Session, cfg SASL2Config, offered []string) (*fastClient, error) {
+	if cfg.FAST == nil || cfg.UserAgent.ID == "" || len(offered) == 0 {
+		return nil, nil
+	}
+	f := &fastClient{store: cfg.FAST, addr: session.LocalAddr().Bare()}
+	supported := func(name string) bool {
+		for _, o := range offered {
+			if o == name {
+				_, _, err := htData(session, name)
+				return err == nil
+			}
+		}
+		return false
+	}
+
+	token, err := f.store.Token(ctx, f.addr)
+	if err != nil {
+		return nil, err
+	}
+	if token.Token != "" {
+		if !token.expired(time.Now()) && supported(token.Mechanism) {
+			// Save the new count before using the token so that it is never reused
+			// even if we crash.
+			token.Count++
+			err = f.store.SetToken(ctx, f.addr, token)
+			if err != nil {
+				return nil, err
+			}
+			f.token = token
+			return f, nil
+		}
+		err = f.store.SetToken(ctx, f.addr, FASTToken{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range fastMechanisms {
+		if supported(name) {
+			f.request = name
+			break
+		}
+	}
+	return f, nil
+}
+
+// mechanism returns the HT mechanism to authenticate with if a token is being
+// used.
+func (f *fastClient) mechanism(session *Session) (sasl.Mechanism, bool) {
+	if f == nil || f.token.Token == "" {
+		return sasl.Mechanism{}, false
+	}
+	name := f.token.Mechanism
+	token := f.token.Token
+	return sasl.Mechanism{
+		Name: name,
+		Start: func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+			fn, cb, err := htData(session, name)
+			if err != nil {
+				return false, nil, nil, err
+			}
+			user, _, _ := n.Credentials()
+			resp := append(append(user, 0), htMAC(fn, token, "Initiator", cb)...)
+			return true, resp, htMAC(fn, token, "Responder", cb), nil
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			expected, _ := data.([]byte)
+			if !hmac.Equal(challenge, expected) {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, nil, nil, nil
+		},
+	}, true
+}
+
+// element returns the FAST element to include in the authenticate request.
+func (f *fastClient) element() xml.TokenReader {
+	switch {
+	case f == nil:
+		return nil
+	case f.token.Token != "":
+		return xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: ns.FAST, Local: "fast"},
+			Attr: []xml.Attr{{
+				Name:  xml.Name{Local: "count"},
+				Value: strconv.FormatUint(uint64(f.token.Count), 10),
+			}},
+		})
+	case f.request != "":
+		return xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: ns.FAST, Local: "request-token"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "mechanism"}, Value: f.request}},
+		})
+	}
+	return nil
+}
+
+type fastTokenPayload struct {
+	Token  string `xml:"token,attr"`
+	Expiry string `xml:"expiry,attr"`
+}
+
+// success stores any new token sent by the server.
+func (f *fastClient) success(ctx context.Context, payload *fastTokenPayload) error {
+	if f == nil || payload == nil || payload.Token == "" {
+		return nil
+	}
+	mechanism := f.request
+	if f.token.Token != "" {
+		mechanism = f.token.Mechanism
+	}
+	if mechanism == "" {
+		return nil
+	}
+	expiry, err := time.Parse(time.RFC3339, payload.Expiry)
+	if err != nil {
+		return err
+	}
+	return f.store.SetToken(ctx, f.addr, FASTToken{
+		Mechanism: mechanism,
+		Token:     payload.Token,
+		Expiry:    expiry,
+	})
+}
+
+// failure removes a token that was rejected by the server so that the next
+// attempt falls back to another mechanism.
+func (f *fastClient) failure(ctx context.Context) error {
+	if f == nil || f.token.Token == "" {
+		return nil
+	}
+	return f.store.SetToken(ctx, f.addr, FASTToken{})
+}
+
+type fastRequest struct {
+	RequestToken *struct {
+		Mechanism string `xml:"mechanism,attr"`
+	} `xml:"urn:xmpp:fast:0 request-token"`
+	FAST *struct {
+		Count      uint32 `xml:"count,attr"`
+		Invalidate bool   `xml:"invalidate,attr"`
+	} `xml:"urn:xmpp:fast:0 fast"`
+}
+
+// fastServer holds the state of a server offering FAST during SASL2
+// authentication.
+type fastServer struct {
+	cfg     SASL2ServerConfig
+	session *Session
+	auth    *saslAuth
+	agent   string
+	req     fastRequest
+
+	// The token that the client authenticated with, if any.
+	used *FASTToken
+}
+
+func newFASTServer(cfg SASL2ServerConfig, session *Session, auth *saslAuth, agent string, req fastRequest) *fastServer {
+	if cfg.FAST == nil || agent == "" {
+		return nil
+	}
+	return &fastServer{
+		cfg:     cfg,
+		session: session,
+		auth:    auth,
+		agent:   agent,
+		req:     req,
+	}
+}
+
+func (f *fastServer) lifetime() time.Duration {
+	if f.cfg.FASTLifetime > 0 {
+		return f.cfg.FASTLifetime
+	}
+	return DefaultFASTLifetime
+}
+
+// mechanism returns the HT mechanism with the given name if it was advertised.
+func (f *fastServer) mechanism(ctx context.Context, name string) (sasl.Mechanism, bool) {
+	if f == nil {
+		return sasl.Mechanism{}, false
+	}
+	advertised := false
+	for _, m := range fastMechanisms {
+		advertised = advertised || m == name
+	}
+	if !advertised {
+		return sasl.Mechanism{}, false
+	}
+	return sasl.Mechanism{
+		Name: name,
+		Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+			return false, nil, nil, sasl.ErrInvalidState
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			if n.State()&sasl.StepMask != sasl.AuthTextSent {
+				return false, nil, nil, sasl.ErrTooManySteps
+			}
+			idx := bytes.IndexByte(challenge, 0)
+			if idx < 0 || f.req.FAST == nil {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			user := string(challenge[:idx])
+			fn, cb, err := htData(f.session, name)
+			if err != nil {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			tokens, err := f.cfg.FAST.Tokens(ctx, user, f.agent)
+			if err != nil {
+				return false, nil, nil, err
+			}
+			now := time.Now()
+			for _, t := range tokens {
+				if t.Mechanism != name || t.expired(now) || !hmac.Equal(challenge[idx+1:], htMAC(fn, t.Token, "Initiator", cb)) {
+					continue
+				}
+				err = f.cfg.FAST.Use(ctx, user, f.agent, t.Token, f.req.FAST.Count)
+				if err != nil {
+					return false, nil, nil, sasl.ErrAuthn
+				}
+				t.Count = f.req.FAST.Count
+				f.used = &t
+				f.auth.user = user
+				return false, htMAC(fn, t.Token, "Responder", cb), nil, nil
+			}
+			return false, nil, nil, sasl.ErrAuthn
+		},
+	}, true
+}
+
+// finish is called after the client has authenticated and returns the token
+// element to include in the success, if any.
+// New tokens are issued if the client requested one or if the token that the
+// client authenticated with is past half of its lifetime.
+// Tokens are always stored for the user that was authenticated by the
+// mechanism.
+func (f *fastServer) finish(ctx context.Context) (xml.TokenReader, error) {
+	if f == nil {
+		return nil, nil
+	}
+	if f.used != nil && f.req.FAST.Invalidate {
+		return nil, f.cfg.FAST.Invalidate(ctx, f.auth.user, f.agent)
+	}
+	var mechanism string
+	switch {
+	case f.req.RequestToken != nil:
+		for _, m := range fastMechanisms {
+			if m == f.req.RequestToken.Mechanism {
+				mechanism = m
+			}
+		}
+	case f.used != nil && time.Until(f.used.Expiry) < f.lifetime()/2:
+		mechanism = f.used.Mechanism
+	}
+	if mechanism == "" {
+		return nil, nil
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	token := FASTToken{
+		Mechanism: mechanism,
+		Token:     base64.RawURLEncoding.EncodeToString(b),
+		Expiry:    time.Now().Add(f.lifetime()).UTC().Truncate(time.Second),
+	}
+	err = f.cfg.FAST.AddToken(ctx, f.auth.user, f.agent, token)
+	if err != nil {
+		return nil, err
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: ns.FAST, Local: "token"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "expiry"}, Value: token.Expiry.Format(time.RFC3339)},
+			{Name: xml.Name{Local: "token"}, Value: token.Token},
+		},
+	}), nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"mellium.im/sasl"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+const fastAgent = "d4565fa7-4d72-4749-b3d3-740edbf87770"
+
+var fastAddr = jid.MustParse("test@example.net")
+
+// fastLogin negotiates a client and server session using SASL2 and FAST and
+// returns any error from either side.
+// If tlsCfg is not nil StartTLS is negotiated first.
+func fastLogin(ctx context.Context, t *testing.T, password string, client xmpp.FASTClientStore, server xmpp.SASL2ServerConfig, tlsCfg func(*testing.T) (*tls.Config, *tls.Config)) (clientErr, serverErr error) {
+	t.Helper()
+
+	state := xmpp.Secure
+	var serverFeatures, clientFeatures []xmpp.StreamFeature
+	if tlsCfg != nil {
+		serverTLS, clientTLS := tlsCfg(t)
+		state = 0
+		serverFeatures = append(serverFeatures, xmpp.StartTLS(serverTLS))
+		clientFeatures = append(clientFeatures, xmpp.StartTLS(clientTLS))
+	}
+	serverFeatures = append(serverFeatures, xmpp.SASL2Server(allowPlain, server, sasl.Plain))
+	clientFeatures = append(clientFeatures, xmpp.SASL2("", password, xmpp.SASL2Config{
+		UserAgent: xmpp.UserAgent{ID: fastAgent},
+		FAST:      client,
+	}, sasl.Plain))
+
+	clientConn, serverConn := smPipe(t)
+	errs := make(chan error, 1)
+	go func() {
+		s, err := xmpp.ReceiveSession(ctx, serverConn, state, smNegotiator(serverFeatures...))
+		if err == nil {
+			err = s.Close()
+		}
+		errs <- err
+	}()
+	s, clientErr := xmpp.NewSession(ctx, jid.MustParse("example.net"), fastAddr, clientConn, state, smNegotiator(clientFeatures...))
+	if clientErr == nil {
+		/* #nosec */
+		s.Close()
+	} else {
+		/* #nosec */
+		clientConn.Close()
+	}
+	return clientErr, <-errs
+}
+
+func fastToken(ctx context.Context, t *testing.T, store xmpp.FASTClientStore) xmpp.FASTToken {
+	t.Helper()
+	token, err := store.Token(ctx, fastAddr)
+	if err != nil {
+		t.Fatalf("error fetching token: %v", err)
+	}
+	return token
+}
+
+func TestFAST(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientStore := xmpp.NewFASTClientStore()
+	serverCfg := xmpp.SASL2ServerConfig{FAST: xmpp.NewFASTServerStore()}
+
+	// Authenticating with a password and requesting a token.
+	clientErr, serverErr := fastLogin(ctx, t, "pass", clientStore, serverCfg, nil)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("error authenticating with password: client=%v, server=%v", clientErr, serverErr)
+	}
+	issued := fastToken(ctx, t, clientStore)
+	if issued.Token == "" || issued.Mechanism != "HT-SHA-256-NONE" || issued.Count != 0 {
+		t.Fatalf("unexpected token issued: %+v", issued)
+	}
+	if d := time.Until(issued.Expiry); d < xmpp.DefaultFASTLifetime-time.Minute || d > xmpp.DefaultFASTLifetime {
+		t.Errorf("unexpected token expiry: %v", issued.Expiry)
+	}
+	tokens, err := serverCfg.FAST.Tokens(ctx, "test", fastAgent)
+	if err != nil || len(tokens) != 1 || tokens[0].Token != issued.Token {
+		t.Errorf("expected token to be stored for the authenticated user, got %+v (%v)", tokens, err)
+	}
+
+	// Authenticating with the token does not need the password.
+	clientErr, serverErr = fastLogin(ctx, t, "wrong", clientStore, serverCfg, nil)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("error authenticating with token: client=%v, server=%v", clientErr, serverErr)
+	}
+	used := fastToken(ctx, t, clientStore)
+	if used.Token != issued.Token || used.Count != 1 {
+		t.Errorf("unexpected token after use: want count 1 of %+v, got %+v", issued, used)
+	}
+
+	// Replaying a count is rejected and the token is removed from the client.
+	err = clientStore.SetToken(ctx, fastAddr, issued)
+	if err != nil {
+		t.Fatalf("error resetting token: %v", err)
+	}
+	clientErr, serverErr = fastLogin(ctx, t, "wrong", clientStore, serverCfg, nil)
+	if clientErr == nil || serverErr == nil {
+		t.Fatalf("expected replayed count to be rejected: client=%v, server=%v", clientErr, serverErr)
+	}
+	if token := fastToken(ctx, t, clientStore); token != (xmpp.FASTToken{}) {
+		t.Errorf("expected rejected token to be removed, got %+v", token)
+	}
+
+	// Without a token the password is used again.
+	clientErr, serverErr = fastLogin(ctx, t, "wrong", clientStore, serverCfg, nil)
+	if clientErr == nil || serverErr == nil {
+		t.Fatalf("expected wrong password to be rejected: client=%v, server=%v", clientErr, serverErr)
+	}
+}
+
+func TestFASTRotation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	old := xmpp.FASTToken{
+		Mechanism: "HT-SHA-256-NONE",
+		Token:     "secret",
+		Expiry:    time.Now().Add(10 * time.Minute),
+	}
+	serverStore := xmpp.NewFASTServerStore()
+	err := serverStore.AddToken(ctx, "test", fastAgent, old)
+	if err != nil {
+		t.Fatalf("error storing server token: %v", err)
+	}
+	clientStore := xmpp.NewFASTClientStore()
+	err = clientStore.SetToken(ctx, fastAddr, old)
+	if err != nil {
+		t.Fatalf("error storing client token: %v", err)
+	}
+	serverCfg := xmpp.SASL2ServerConfig{FAST: serverStore, FASTLifetime: time.Hour}
+
+	// The token is close to expiring so a new one is issued.
+	clientErr, serverErr := fastLogin(ctx, t, "", clientStore, serverCfg, nil)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("error authenticating with old token: client=%v, server=%v", clientErr, serverErr)
+	}
+	rotated := fastToken(ctx, t, clientStore)
+	if rotated.Token == "" || rotated.Token == old.Token || rotated.Count != 0 {
+		t.Fatalf("expected token to be rotated, got %+v", rotated)
+	}
+	tokens, err := serverStore.Tokens(ctx, "test", fastAgent)
+	if err != nil {
+		t.Fatalf("error fetching server tokens: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].Token != rotated.Token || tokens[1].Token != old.Token {
+		t.Fatalf("expected old token to remain valid until the new one is used, got %+v", tokens)
+	}
+
+	// Using the new token invalidates the old one.
+	clientErr, serverErr = fastLogin(ctx, t, "", clientStore, serverCfg, nil)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("error authenticating with new token: client=%v, server=%v", clientErr, serverErr)
+	}
+	if token := fastToken(ctx, t, clientStore); token.Token != rotated.Token {
+		t.Errorf("did not expect token to be rotated again, got %+v", token)
+	}
+	old.Count = 10
+	err = clientStore.SetToken(ctx, fastAddr, old)
+	if err != nil {
+		t.Fatalf("error storing client token: %v", err)
+	}
+	clientErr, serverErr = fastLogin(ctx, t, "", clientStore, serverCfg, nil)
+	if clientErr == nil || serverErr == nil {
+		t.Fatalf("expected old token to be rejected: client=%v, server=%v", clientErr, serverErr)
+	}
+}
+
+func TestFASTOtherUser(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Tokens are only looked up for the user named in the HT exchange.
+	token := xmpp.FASTToken{
+		Mechanism: "HT-SHA-256-NONE",
+		Token:     "secret",
+		Expiry:    time.Now().Add(time.Hour),
+	}
+	serverStore := xmpp.NewFASTServerStore()
+	err := serverStore.AddToken(ctx, "other", fastAgent, token)
+	if err != nil {
+		t.Fatalf("error storing server token: %v", err)
+	}
+	clientStore := xmpp.NewFASTClientStore()
+	err = clientStore.SetToken(ctx, fastAddr, token)
+	if err != nil {
+		t.Fatalf("error storing client token: %v", err)
+	}
+	clientErr, serverErr := fastLogin(ctx, t, "", clientStore, xmpp.SASL2ServerConfig{FAST: serverStore}, nil)
+	if clientErr == nil || serverErr == nil {
+		t.Fatalf("expected token of another user to be rejected: client=%v, server=%v", clientErr, serverErr)
+	}
+}
+
+func TestFASTChannelBinding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientStore := xmpp.NewFASTClientStore()
+	serverCfg := xmpp.SASL2ServerConfig{FAST: xmpp.NewFASTServerStore()}
+	clientErr, serverErr := fastLogin(ctx, t, "pass", clientStore, serverCfg, tlsConfigs)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("error authenticating with password: client=%v, server=%v", clientErr, serverErr)
+	}
+	if token := fastToken(ctx, t, clientStore); token.Mechanism != "HT-SHA-256-EXPR" {
+		t.Fatalf("expected token for tls-exporter channel binding, got %+v", token)
+	}
+	clientErr, serverErr = fastLogin(ctx, t, "", clientStore, serverCfg, tlsConfigs)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("error authenticating with token: client=%v, server=%v", clientErr, serverErr)
+	}
+	if token := fastToken(ctx, t, clientStore); token.Count != 1 {
+		t.Errorf("expected token to be used, got %+v", token)
+	}
+}
+
+func TestFASTServerStore(t *testing.T) {
+	ctx := context.Background()
+	store := xmpp.NewFASTServerStore()
+	err := store.AddToken(ctx, "test", fastAgent, xmpp.FASTToken{Token: "a"})
+	if err != nil {
+		t.Fatalf("error adding token: %v", err)
+	}
+	if err = store.Use(ctx, "test", fastAgent, "a", 1); err != nil {
+		t.Fatalf("error using token: %v", err)
+	}
+	if err = store.Use(ctx, "test", fastAgent, "a", 1); err == nil {
+		t.Errorf("expected replayed count to be rejected")
+	}
+	if err = store.Use(ctx, "test", "other", "a", 2); err == nil {
+		t.Errorf("expected token of another user agent to be rejected")
+	}
+	if err = store.Invalidate(ctx, "test", fastAgent); err != nil {
+		t.Fatalf("error invalidating tokens: %v", err)
+	}
+	tokens, err := store.Tokens(ctx, "test", fastAgent)
+	if err != nil || len(tokens) != 0 {
+		t.Errorf("expected tokens to be invalidated, got %+v (%v)", tokens, err)
+	}
+}
@@ -78,6 +78,20 @@ type StreamFeature struct {
 	// still be listed or parsed. This can be used to implement informational
 	// stream features.
 	Negotiate func(ctx context.Context, session *Session, data interface{}) (mask SessionState, rw io.ReadWriter, err error)
+
+	// Stream management is enabled after resource binding, once the session is
+	// already ready, so the session needs to know whether the feature was listed
+	// or parsed.
+	sm *smConfig
+
+	// SASL features advertise the channel binding types supported by the TLS
+	// connection alongside their mechanisms, which depends on the session.
+	channelBinding bool
+
+	// The namespace of the element sent by the client to select the feature if it
+	// differs from the namespace of the feature itself, eg. stream compression
+	// is advertised in one namespace and negotiated in another.
+	negotiateNS string
 }
 
 func containsStartTLS(features []StreamFeature) (startTLS StreamFeature, ok bool) {
@@ -271,9 +285,10 @@ func negotiateFeatures(ctx context.Context, s *Session, first, ws bool, features
 		}
 		s.negotiated[data.feature.Name.Space] = struct{}{}
 
-		// If we negotiated a required feature or a stream restart is required
+		// If we negotiated a required feature, a stream restart is required, or
+		// the feature made the session ready (eg. by resuming a previous session)
 		// we're done with this feature set.
-		if rw != nil || data.req {
+		if rw != nil || data.req || s.state&Ready == Ready {
 			break
 		}
 	}
@@ -329,6 +344,7 @@ func writeStreamFeatures(ctx context.Context, s *Session, ws bool, features []St
 		cache: make(map[string]sfData),
 	}
 
+	var cbListed bool
 	for _, feature := range features {
 		// Check if all the necessary bits are set and none of the prohibited bits
 		// are set.
@@ -345,6 +361,18 @@ func writeStreamFeatures(ctx context.Context, s *Session, ws bool, features []St
 				req:     r,
 				feature: feature,
 			}
+			if feature.negotiateNS != "" {
+				list.cache[feature.negotiateNS] = list.cache[feature.Name.Space]
+			}
+			if feature.sm != nil {
+				s.sm = newSMState(feature.sm)
+			}
+			if feature.channelBinding && !cbListed {
+				cbListed = true
+				if err = writeChannelBindings(s, s.out.e); err != nil {
+					return list, err
+				}
+			}
 			if r {
 				list.req = true
 			}
@@ -387,6 +415,8 @@ func readStreamFeatures(ctx context.Context, s *Session, start xml.StartElement,
 	sf := &streamFeaturesList{
 		cache: make(map[string]sfData),
 	}
+	// The supported channel binding types may change after a stream restart.
+	delete(s.features, ns.SASLCB)
 
 parsefeatures:
 	for {
@@ -398,6 +428,16 @@ parsefeatures:
 		case xml.StartElement:
 			limitDecoder := nextElementDecoder(s.in.d, tok)
 
+			// Channel binding types are not negotiated, but are used by SASL.
+			if tok.Name.Space == ns.SASLCB && tok.Name.Local == "sasl-channel-binding" {
+				sf.total++
+				s.features[ns.SASLCB], err = parseChannelBindings(limitDecoder, &tok)
+				if err != nil {
+					return nil, err
+				}
+				continue parsefeatures
+			}
+
 			// If the token is a new feature, see if it's one we handle. If so, parse
 			// it. Increment the total features count regardless.
 			sf.total++
@@ -425,6 +465,9 @@ parsefeatures:
 					// Since we do support the feature, add it to the connections list
 					// along with any data returned from Parse.
 					s.features[tok.Name.Space] = data
+					if feature.sm != nil {
+						s.sm = newSMState(feature.sm)
+					}
 					continue parsefeatures
 				}
 			}
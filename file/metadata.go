@@ -16,14 +16,41 @@ import (
 )
 
 const (
-	// The namespace used by this package, provided as a convenience.
-	NSMeta = `urn:xmpp:file:metadata:0`
+	// The namespaces used by this package, provided as a convenience.
+	NSMeta   = `urn:xmpp:file:metadata:0`
+	NSThumbs = `urn:xmpp:thumbs:1`
 )
 
 // TODO: the "desc" element is not specified on meta. We need a better way to
 // handle xml:lang first.
-// TODO: the "thumbnail" element is not specified, we need to implement
-// XEP-02654: File Transfer Thumbnails first.
+
+// Thumbnail is a reference to a small preview image of a file as defined in
+// XEP-0264: Jingle Content Thumbnails.
+// The URI is often a content ID that can be fetched using bits of binary.
+type Thumbnail struct {
+	URI       string
+	MediaType string
+	Width     uint64
+	Height    uint64
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (t Thumbnail) TokenReader() xml.TokenReader {
+	attrs := []xml.Attr{{Name: xml.Name{Local: "uri"}, Value: t.URI}}
+	if t.MediaType != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "media-type"}, Value: t.MediaType})
+	}
+	if t.Width != 0 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "width"}, Value: strconv.FormatUint(t.Width, 10)})
+	}
+	if t.Height != 0 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "height"}, Value: strconv.FormatUint(t.Height, 10)})
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSThumbs, Local: "thumbnail"},
+		Attr: attrs,
+	})
+}
 
 // Meta is an element that contains metadata about a file.
 type Meta struct {
@@ -35,10 +62,16 @@ type Meta struct {
 	Width     uint64
 	Height    uint64
 	Length    uint64
+
+	Thumbnails []Thumbnail
 }
 
 // TokenReader satisfies the xmlstream.Marshaler interface.
 func (m *Meta) TokenReader() xml.TokenReader {
+	thumbnails := make([]xml.TokenReader, 0, len(m.Thumbnails))
+	for _, t := range m.Thumbnails {
+		thumbnails = append(thumbnails, t.TokenReader())
+	}
 	return xmlstream.Wrap(
 		xmlstream.MultiReader(
 			xmlstream.Wrap(
@@ -84,6 +117,7 @@ func (m *Meta) TokenReader() xml.TokenReader {
 					Name: xml.Name{Local: "length"},
 				},
 			),
+			xmlstream.MultiReader(thumbnails...),
 		),
 		xml.StartElement{
 			Name: xml.Name{Space: NSMeta, Local: "file"},
@@ -115,6 +149,12 @@ func (m *Meta) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 		Width     uint64            `xml:"width"`
 		Height    uint64            `xml:"height"`
 		Length    uint64            `xml:"length"`
+		Thumbnail []struct {
+			URI       string `xml:"uri,attr"`
+			MediaType string `xml:"media-type,attr"`
+			Width     uint64 `xml:"width,attr"`
+			Height    uint64 `xml:"height,attr"`
+		} `xml:"urn:xmpp:thumbs:1 thumbnail"`
 	}{}
 	err := d.DecodeElement(&in, &start)
 	if err != nil {
@@ -128,5 +168,9 @@ func (m *Meta) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	m.Width = in.Width
 	m.Height = in.Height
 	m.Length = in.Length
+	m.Thumbnails = nil
+	for _, t := range in.Thumbnail {
+		m.Thumbnails = append(m.Thumbnails, Thumbnail(t))
+	}
 	return nil
 }
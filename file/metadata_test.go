@@ -25,6 +25,27 @@ var marshalTestCases = []xmpptest.EncodingTestCase{
 		},
 		XML: `<file xmlns="urn:xmpp:file:metadata:0"><media-type>text/plain</media-type><name></name><date>2024-01-01T01:01:01Z</date><size>0</size><hash xmlns="urn:xmpp:hashes:2" algo="sha-256">AQID</hash><width>0</width><height>0</height><length>0</length></file>`,
 	},
+	1: {
+		Value: &file.Meta{
+			MediaType: "image/jpeg",
+			Name:      "summit.jpg",
+			Date:      time.Date(2015, 07, 26, 21, 46, 00, 00, time.UTC),
+			Size:      3032449,
+			Hash: crypto.HashOutput{
+				Hash: crypto.SHA256,
+				Out:  []byte{1, 2, 3},
+			},
+			Width:  4096,
+			Height: 2160,
+			Thumbnails: []file.Thumbnail{{
+				URI:       "cid:sha1+ffd7c8d28e9c5e82afea41f97108c6b4@bob.xmpp.org",
+				MediaType: "image/png",
+				Width:     128,
+				Height:    96,
+			}},
+		},
+		XML: `<file xmlns="urn:xmpp:file:metadata:0"><media-type>image/jpeg</media-type><name>summit.jpg</name><date>2015-07-26T21:46:00Z</date><size>3032449</size><hash xmlns="urn:xmpp:hashes:2" algo="sha-256">AQID</hash><width>4096</width><height>2160</height><length>0</length><thumbnail xmlns="urn:xmpp:thumbs:1" uri="cid:sha1+ffd7c8d28e9c5e82afea41f97108c6b4@bob.xmpp.org" media-type="image/png" width="128" height="96"></thumbnail></file>`,
+	},
 }
 
 func TestEncode(t *testing.T) {
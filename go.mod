@@ -5,6 +5,7 @@ go 1.23.0
 toolchain go1.24.2
 
 require (
+	golang.org/x/crypto v0.37.0
 	golang.org/x/image v0.23.0
 	golang.org/x/net v0.39.0
 	golang.org/x/sys v0.32.0
@@ -15,7 +16,6 @@ require (
 )
 
 require (
-	golang.org/x/crypto v0.37.0 // indirect
 	golang.org/x/mod v0.24.0 // indirect
 	golang.org/x/sync v0.13.0 // indirect
 	mellium.im/reader v0.1.0 // indirect
@@ -0,0 +1,169 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/delay"
+	"github.com/kamrankamilli/xmpp/forward"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// DefaultMaxPage is the maximum number of messages returned in a single page
+// if an Archive does not set MaxPage.
+const DefaultMaxPage = 50
+
+// HandleArchive returns an option that registers an Archive to respond to
+// history queries.
+func HandleArchive(a *Archive) mux.Option {
+	return func(m *mux.ServeMux) {
+		query := xml.Name{Space: NS, Local: "query"}
+		mux.IQ(stanza.GetIQ, query, a)(m)
+		mux.IQ(stanza.SetIQ, query, a)(m)
+	}
+}
+
+// Archive responds to history queries with messages from a Store.
+//
+// Queries are answered from the archive owned by the bare JID that the query
+// was addressed to, or by the bare JID of the sender if the query was not
+// addressed to a specific entity (for example, a user querying their own
+// archive on their server).
+// Queries from anybody other than the owner of the archive are rejected with a
+// forbidden error unless Authorize allows them.
+type Archive struct {
+	// Store contains the archived messages.
+	Store Store
+
+	// Authorize is called when a query is sent by an entity other than the
+	// owner of the archive and reports whether requester may query the archive
+	// owned by owner (for example, the occupants of a group chat querying the
+	// group chat's archive).
+	// The requester is the "from" address of the query which may be empty if the
+	// query was received on a client-to-server stream.
+	// If Authorize is nil, only the owner may query an archive.
+	Authorize func(requester, owner jid.JID) bool
+
+	// MaxPage is the maximum number of messages that will be returned in a
+	// single page.
+	// If it is zero, DefaultMaxPage is used.
+	MaxPage uint64
+}
+
+// HandleIQ implements mux.IQHandler.
+// Get requests are answered with the form that can be used to filter queries
+// and set requests are treated as queries.
+func (a *Archive) HandleIQ(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if iq.Type == stanza.GetIQ {
+		_, err := xmlstream.Copy(r, iq.Result(xmlstream.Wrap(
+			newForm().TokenReader(),
+			xml.StartElement{Name: xml.Name{Space: NS, Local: "query"}},
+		)))
+		return err
+	}
+
+	var q Query
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&q)
+	if err != nil {
+		_, err = xmlstream.Copy(r, iq.Error(stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.BadRequest,
+		}))
+		return err
+	}
+	max := a.MaxPage
+	if max == 0 {
+		max = DefaultMaxPage
+	}
+	if q.Limit == 0 || q.Limit > max {
+		q.Limit = max
+	}
+
+	owner := iq.To.Bare()
+	if owner.Equal(jid.JID{}) {
+		owner = iq.From.Bare()
+	}
+	if !iq.From.Bare().Equal(owner) && (a.Authorize == nil || !a.Authorize(iq.From, owner)) {
+		_, err = xmlstream.Copy(r, iq.Error(stanza.Error{
+			Type:      stanza.Auth,
+			Condition: stanza.Forbidden,
+		}))
+		return err
+	}
+	msgs, res, err := a.Store.Query(context.Background(), owner, q)
+	if err != nil {
+		stanzaErr := stanza.Error{}
+		if !errors.As(err, &stanzaErr) {
+			stanzaErr = stanza.Error{
+				Type:      stanza.Wait,
+				Condition: stanza.InternalServerError,
+			}
+		}
+		_, err = xmlstream.Copy(r, iq.Error(stanzaErr))
+		return err
+	}
+
+	if q.Reverse {
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+	for _, msg := range msgs {
+		_, err = xmlstream.Copy(r, stanza.Message{
+			ID:   attr.RandomID(),
+			To:   iq.From,
+			From: iq.To,
+		}.Wrap(xmlstream.Wrap(
+			forward.Forwarded{
+				Delay: delay.Delay{Time: msg.Time},
+			}.Wrap(stanzaReader(msg.Stanza)),
+			xml.StartElement{
+				Name: xml.Name{Space: NS, Local: "result"},
+				Attr: []xml.Attr{
+					{Name: xml.Name{Local: "queryid"}, Value: q.ID},
+					{Name: xml.Name{Local: "id"}, Value: msg.ID},
+				},
+			},
+		)))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = xmlstream.Copy(r, iq.Result(res.TokenReader()))
+	return err
+}
+
+// stanzaReader decodes an archived stanza, putting it in the client namespace
+// if it was stored without one.
+func stanzaReader(b []byte) xml.TokenReader {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	var depth int
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		tok, err := d.Token()
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 && t.Name.Space == "" {
+				t.Name.Space = stanza.NSClient
+				tok = t
+			}
+		case xml.EndElement:
+			if depth == 1 && t.Name.Space == "" {
+				t.Name.Space = stanza.NSClient
+				tok = t
+			}
+			depth--
+		}
+		return tok, err
+	})
+}
@@ -0,0 +1,349 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package history_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/history"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+var (
+	_ mux.IQHandler    = (*history.Archive)(nil)
+	_ info.FeatureIter = (*history.Archive)(nil)
+)
+
+var (
+	archiveAddr = jid.MustParse("romeo@montague.lit")
+	juliet      = jid.MustParse("juliet@capulet.lit/balcony")
+	benvolio    = jid.MustParse("benvolio@montague.lit")
+)
+
+// newTestStore returns a store containing 5 messages with the IDs 0-4, one
+// minute apart, alternating between conversations with juliet and benvolio.
+func newTestStore(t *testing.T) history.Store {
+	t.Helper()
+	store := history.NewStore()
+	for i := 0; i < 5; i++ {
+		with := juliet
+		if i%2 == 1 {
+			with = benvolio
+		}
+		msg, err := xml.Marshal(struct {
+			stanza.Message
+			Body string `xml:"body"`
+		}{
+			Message: stanza.Message{From: with, To: archiveAddr, Type: stanza.ChatMessage},
+			Body:    strconv.Itoa(i),
+		})
+		if err != nil {
+			t.Fatalf("error marshaling message: %v", err)
+		}
+		_, err = store.Append(context.Background(), archiveAddr, history.Message{
+			ID:     strconv.Itoa(i),
+			Time:   time.Unix(int64(i)*60, 0).UTC(),
+			With:   with,
+			Stanza: msg,
+		})
+		if err != nil {
+			t.Fatalf("error appending message %d: %v", i, err)
+		}
+	}
+	return store
+}
+
+var storeTestCases = [...]struct {
+	q        history.Query
+	ids      []string
+	complete bool
+	count    uint64
+	err      error
+}{
+	0: {
+		ids:      []string{"0", "1", "2", "3", "4"},
+		complete: true,
+		count:    5,
+	},
+	1: {
+		q:     history.Query{Limit: 2},
+		ids:   []string{"0", "1"},
+		count: 5,
+	},
+	2: {
+		q:        history.Query{Limit: 2, PageID: "2"},
+		ids:      []string{"3", "4"},
+		complete: true,
+		count:    5,
+	},
+	3: {
+		q:     history.Query{Limit: 2, Last: true},
+		ids:   []string{"3", "4"},
+		count: 5,
+	},
+	4: {
+		q:        history.Query{Limit: 2, Last: true, PageID: "2"},
+		ids:      []string{"0", "1"},
+		complete: true,
+		count:    5,
+	},
+	5: {
+		q:        history.Query{With: juliet.Bare()},
+		ids:      []string{"0", "2", "4"},
+		complete: true,
+		count:    3,
+	},
+	6: {
+		q:        history.Query{With: jid.MustParse("juliet@capulet.lit/other")},
+		complete: true,
+	},
+	7: {
+		q:        history.Query{Start: time.Unix(60, 0), End: time.Unix(180, 0)},
+		ids:      []string{"1", "2", "3"},
+		complete: true,
+		count:    3,
+	},
+	8: {
+		q:        history.Query{AfterID: "1", BeforeID: "4"},
+		ids:      []string{"2", "3"},
+		complete: true,
+		count:    2,
+	},
+	9: {
+		q:        history.Query{IDs: []string{"4", "1"}},
+		ids:      []string{"1", "4"},
+		complete: true,
+		count:    2,
+	},
+	10: {
+		q:   history.Query{PageID: "nope"},
+		err: stanza.Error{Condition: stanza.ItemNotFound},
+	},
+	11: {
+		q:   history.Query{AfterID: "nope"},
+		err: stanza.Error{Condition: stanza.ItemNotFound},
+	},
+}
+
+func TestStore(t *testing.T) {
+	store := newTestStore(t)
+	for i, tc := range storeTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			msgs, res, err := store.Query(context.Background(), archiveAddr, tc.q)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			var ids []string
+			for _, msg := range msgs {
+				ids = append(ids, msg.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.ids) {
+				t.Errorf("wrong messages: want=%v, got=%v", tc.ids, ids)
+			}
+			if res.Complete != tc.complete {
+				t.Errorf("wrong value for complete: want=%t, got=%t", tc.complete, res.Complete)
+			}
+			if res.Set.Count == nil || *res.Set.Count != tc.count {
+				t.Errorf("wrong count: want=%d, got=%v", tc.count, res.Set.Count)
+			}
+			if len(ids) > 0 && (res.Set.First.ID != ids[0] || res.Set.Last != ids[len(ids)-1]) {
+				t.Errorf("wrong page: want=%s-%s, got=%s-%s", ids[0], ids[len(ids)-1], res.Set.First.ID, res.Set.Last)
+			}
+		})
+	}
+}
+
+// connectedUser authorizes queries that were received without a "from"
+// address as if the test server had authenticated the client as the owner of
+// the archive.
+func connectedUser(requester, owner jid.JID) bool {
+	return requester.Equal(jid.JID{}) && owner.Equal(archiveAddr)
+}
+
+func TestArchive(t *testing.T) {
+	h := history.NewHandler(nil)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(stanza.NSClient, history.HandleArchive(&history.Archive{
+			Store:     newTestStore(t),
+			MaxPage:   2,
+			Authorize: connectedUser,
+		}))),
+		xmpptest.ClientHandler(mux.New("", history.Handle(h))),
+	)
+
+	var bodies []string
+	iter := h.Fetch(context.Background(), history.Query{Reverse: true}, archiveAddr, cs.Client)
+	for iter.Next() {
+		var msg struct {
+			XMLName xml.Name `xml:"message"`
+			Result  struct {
+				ID        string `xml:"id,attr"`
+				Forwarded struct {
+					Delay struct {
+						Stamp string `xml:"stamp,attr"`
+					} `xml:"urn:xmpp:delay delay"`
+					Message struct {
+						Body string `xml:"body"`
+					} `xml:"jabber:client message"`
+				} `xml:"urn:xmpp:forward:0 forwarded"`
+			} `xml:"urn:xmpp:mam:2 result"`
+		}
+		err := xml.NewTokenDecoder(iter.Current()).Decode(&msg)
+		if err != nil {
+			t.Fatalf("error decoding result: %v", err)
+		}
+		if msg.Result.ID != msg.Result.Forwarded.Message.Body {
+			t.Errorf("archive ID and message do not match: %q, %q", msg.Result.ID, msg.Result.Forwarded.Message.Body)
+		}
+		if msg.Result.Forwarded.Delay.Stamp == "" {
+			t.Errorf("expected delay to be set on forwarded message")
+		}
+		bodies = append(bodies, msg.Result.Forwarded.Message.Body)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating over results: %v", err)
+	}
+	if expected := []string{"1", "0"}; !reflect.DeepEqual(bodies, expected) {
+		t.Errorf("wrong messages: want=%v, got=%v", expected, bodies)
+	}
+}
+
+func TestArchiveResult(t *testing.T) {
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(stanza.NSClient, history.HandleArchive(&history.Archive{
+			Store:     newTestStore(t),
+			Authorize: connectedUser,
+		}))),
+	)
+	res, err := history.Fetch(context.Background(), history.Query{Limit: 3, PageID: "0"}, archiveAddr, cs.Client)
+	if err != nil {
+		t.Fatalf("error fetching history: %v", err)
+	}
+	if res.Complete || res.Unstable {
+		t.Errorf("wrong flags: complete=%t, unstable=%t", res.Complete, res.Unstable)
+	}
+	if res.Set.First.ID != "1" || res.Set.Last != "3" {
+		t.Errorf("wrong page: want=1-3, got=%s-%s", res.Set.First.ID, res.Set.Last)
+	}
+
+	_, err = history.Fetch(context.Background(), history.Query{PageID: "nope"}, archiveAddr, cs.Client)
+	if !errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+		t.Errorf("wrong error: want=%v, got=%v", stanza.ItemNotFound, err)
+	}
+}
+
+func TestArchiveForbidden(t *testing.T) {
+	for i, tc := range [...]struct {
+		authorize func(requester, owner jid.JID) bool
+		from      jid.JID
+		err       error
+	}{
+		0: {from: jid.MustParse("romeo@montague.lit/orchard")},
+		1: {from: juliet, err: stanza.Error{Condition: stanza.Forbidden}},
+		2: {authorize: connectedUser, from: juliet, err: stanza.Error{Condition: stanza.Forbidden}},
+		3: {
+			authorize: func(requester, owner jid.JID) bool {
+				return requester.Equal(juliet) && owner.Equal(archiveAddr)
+			},
+			from: juliet,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			cs := xmpptest.NewClientServer(
+				xmpptest.ServerHandler(mux.New(stanza.NSClient, history.HandleArchive(&history.Archive{
+					Store:     newTestStore(t),
+					Authorize: tc.authorize,
+				}))),
+			)
+			var resp struct {
+				XMLName xml.Name `xml:"urn:xmpp:mam:2 fin"`
+			}
+			err := cs.Client.UnmarshalIQElement(context.Background(), (&history.Query{}).TokenReader(), stanza.IQ{
+				Type: stanza.SetIQ,
+				From: tc.from,
+				To:   archiveAddr,
+			}, &resp)
+			if !errors.Is(err, tc.err) || (tc.err == nil && err != nil) {
+				t.Errorf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestArchiveForm(t *testing.T) {
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(stanza.NSClient, history.HandleArchive(&history.Archive{
+			Store: history.NewStore(),
+		}))),
+	)
+	var resp struct {
+		XMLName xml.Name   `xml:"urn:xmpp:mam:2 query"`
+		Form    *form.Data `xml:"jabber:x:data x"`
+	}
+	err := cs.Client.UnmarshalIQElement(context.Background(), xmlstream.Wrap(
+		nil,
+		xml.StartElement{Name: xml.Name{Space: history.NS, Local: "query"}},
+	), stanza.IQ{Type: stanza.GetIQ}, &resp)
+	if err != nil {
+		t.Fatalf("error fetching form: %v", err)
+	}
+	var fields []string
+	resp.Form.ForFields(func(f form.FieldData) {
+		fields = append(fields, f.Var)
+	})
+	if s := strings.Join(fields, ","); s != "FORM_TYPE,with,start,end,after-id,before-id,ids" {
+		t.Errorf("wrong fields: %s", s)
+	}
+}
+
+func TestHandleMessageResultNotFirst(t *testing.T) {
+	var found int
+	h := history.NewHandler(mux.MessageHandlerFunc(func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+		tok, err := r.Token()
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "result" {
+			found++
+		}
+		return nil
+	}))
+	m := mux.New(stanza.NSClient, history.Handle(h))
+	const in = `<message xmlns="jabber:client"><other xmlns="urn:example"><a/></other><result xmlns="urn:xmpp:mam:2" queryid="123" id="1"/></message>`
+	d := xml.NewDecoder(strings.NewReader(in))
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatalf("error popping start token: %v", err)
+	}
+	start := tok.(xml.StartElement)
+	err = m.HandleXMPP(struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: d,
+	}, &start)
+	if err != nil {
+		t.Fatalf("error handling message: %v", err)
+	}
+	if found != 1 {
+		t.Errorf("expected result to be passed to the inner handler once, got %d", found)
+	}
+}
@@ -2,7 +2,10 @@
 // Use of this source code is governed by the BSD 2-clause
 // license that can be found in the LICENSE file.
 
-// Package history implements fetching messages from an archive.
+//go:generate go run ../internal/genfeature -receiver "a *Archive" -vars "Feature:NS,FeatureExt:NSExt"
+
+// Package history implements fetching messages from an archive and answering
+// queries for messages from a local archive.
 package history // import "github.com/kamrankamilli/xmpp/history"
 
 // The namespaces used by this package, provided as a convenience.
@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"sync"
 
 	"mellium.im/xmlstream"
@@ -57,23 +58,45 @@ func (h *Handler) remove(id string) {
 
 // HandleMessage implements mux.MessageHandler.
 func (h *Handler) HandleMessage(msg stanza.Message, r xmlstream.TokenReadEncoder) error {
-	// TODO: iterate through and find result.
 	msgTok, err := r.Token()
 	if err != nil {
 		return err
 	}
-	tok, err := r.Token()
-	if err != nil {
-		return err
-	}
-	start := tok.(xml.StartElement)
-	var queryID string
-	for _, attr := range start.Attr {
-		if attr.Name.Local == "queryid" {
-			queryID = attr.Value
-			break
+
+	// Find the result payload, keeping any other children of the message that
+	// come before it so that they can be passed on with the message.
+	var (
+		start  xml.StartElement
+		before []xml.Token
+	)
+	for start.Name.Local == "" {
+		tok, err := r.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space == NS && t.Name.Local == "result" {
+				start = t.Copy()
+				continue
+			}
+			inner, err := xmlstream.ReadAll(xmlstream.InnerElement(r))
+			if err != nil {
+				return err
+			}
+			before = append(before, t.Copy())
+			for _, tok := range inner {
+				before = append(before, xml.CopyToken(tok))
+			}
+		case xml.EndElement:
+			// The message did not contain a result.
+			return nil
+		default:
+			before = append(before, xml.CopyToken(tok))
 		}
 	}
+
+	_, queryID := attr.Get(start.Attr, "queryid")
 	h.trackedM.Lock()
 	defer h.trackedM.Unlock()
 	iter, ok := h.tracked[queryID]
@@ -83,17 +106,41 @@ func (h *Handler) HandleMessage(msg stanza.Message, r xmlstream.TokenReadEncoder
 				xml.TokenReader
 				xmlstream.Encoder
 			}{
-				TokenReader: xmlstream.MultiReader(xmlstream.Token(tok), xmlstream.InnerElement(r)),
+				TokenReader: xmlstream.MultiReader(xmlstream.Token(start), xmlstream.InnerElement(r)),
 				Encoder:     r,
 			})
 		}
 		return nil
 	}
 
-	iter.msgC <- xmlstream.MultiReader(xmlstream.Token(msgTok), xmlstream.Token(tok), r)
+	// The message is buffered so that it can still be read by the iterator after
+	// the handler returns and processing of the stream continues.
+	toks := append([]xml.Token{xml.CopyToken(msgTok)}, before...)
+	toks = append(toks, start)
+	rest, err := xmlstream.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	for _, tok := range rest {
+		toks = append(toks, xml.CopyToken(tok))
+	}
+	iter.msgC <- &tokenSlice{toks: toks}
 	return nil
 }
 
+type tokenSlice struct {
+	toks []xml.Token
+}
+
+func (t *tokenSlice) Token() (xml.Token, error) {
+	if len(t.toks) == 0 {
+		return nil, io.EOF
+	}
+	tok := t.toks[0]
+	t.toks = t.toks[1:]
+	return tok, nil
+}
+
 // Fetch requests messages from the archive and returns an iterator over the
 // results.
 // Any errors encountered are deferred and returned by the iterator.
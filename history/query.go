@@ -55,9 +55,10 @@ const (
 	fieldIDs    = "ids"
 )
 
-// TokenReader implements xmlstream.Marshaler.
-func (f *Query) TokenReader() xml.TokenReader {
-	dataForm := form.New(
+// newForm returns the data form containing all the fields that can be used to
+// filter queries.
+func newForm() *form.Data {
+	return form.New(
 		form.Hidden("FORM_TYPE", form.Value(NS)),
 		form.JID(fieldWith),
 		form.Text(fieldStart),
@@ -66,6 +67,11 @@ func (f *Query) TokenReader() xml.TokenReader {
 		form.Text(fieldBefore),
 		form.ListMulti(fieldIDs),
 	)
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (f *Query) TokenReader() xml.TokenReader {
+	dataForm := newForm()
 	if !f.With.Equal(jid.JID{}) {
 		/* #nosec */
 		dataForm.Set(fieldWith, f.With)
@@ -147,6 +153,7 @@ func (f *Query) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 			After   string   `xml:"after"`
 			Before  struct {
 				XMLName xml.Name `xml:"before"`
+				ID      string   `xml:",chardata"`
 			}
 		}
 	}{}
@@ -156,28 +163,42 @@ func (f *Query) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	}
 
 	f.ID = s.ID
-	f.With, _ = s.Form.GetJID(fieldWith)
-	startTime, ok := s.Form.GetString(fieldStart)
-	if ok {
-		//panic(startTime)
-		f.Start, err = time.Parse(time.RFC3339, startTime)
+	// Clients do not always include the field types in their submissions, so
+	// use the raw values instead of relying on the types to parse them.
+	if v, ok := s.Form.Raw(fieldWith); ok && len(v) > 0 {
+		f.With, err = jid.Parse(v[0])
 		if err != nil {
 			return err
 		}
 	}
-	endTime, ok := s.Form.GetString(fieldEnd)
-	if ok {
-		f.End, err = time.Parse(time.RFC3339, endTime)
+	if v, ok := s.Form.Raw(fieldStart); ok && len(v) > 0 {
+		f.Start, err = time.Parse(time.RFC3339, v[0])
 		if err != nil {
 			return err
 		}
 	}
-	f.BeforeID, _ = s.Form.GetString(fieldBefore)
-	f.AfterID, _ = s.Form.GetString(fieldAfter)
-	f.IDs, _ = s.Form.GetStrings(fieldIDs)
+	if v, ok := s.Form.Raw(fieldEnd); ok && len(v) > 0 {
+		f.End, err = time.Parse(time.RFC3339, v[0])
+		if err != nil {
+			return err
+		}
+	}
+	if v, ok := s.Form.Raw(fieldBefore); ok && len(v) > 0 {
+		f.BeforeID = v[0]
+	}
+	if v, ok := s.Form.Raw(fieldAfter); ok && len(v) > 0 {
+		f.AfterID = v[0]
+	}
+	if v, ok := s.Form.Raw(fieldIDs); ok && len(v) > 0 {
+		f.IDs = v
+	}
 	f.Limit = s.Set.Max
 
 	f.Last = s.Set.Before.XMLName.Local == "before"
+	f.PageID = s.Set.After
+	if f.Last {
+		f.PageID = s.Set.Before.ID
+	}
 	f.Reverse = s.Flip.XMLName.Local == "flip-page"
 	return nil
 }
@@ -70,6 +70,33 @@ var queryEncodingTestCases = []xmpptest.EncodingTestCase{
 		},
 		XML: `<query xmlns="urn:xmpp:mam:2" queryid=""><x xmlns="jabber:x:data" type="submit"><field type="hidden" var="FORM_TYPE"><value>urn:xmpp:mam:2</value></field></x><set xmlns="http://jabber.org/protocol/rsm"><before></before></set><flip-page></flip-page></query>`,
 	},
+	8: {
+		Value: &history.Query{
+			Limit:  10,
+			PageID: "123",
+		},
+		XML: `<query xmlns="urn:xmpp:mam:2" queryid=""><x xmlns="jabber:x:data" type="submit"><field type="hidden" var="FORM_TYPE"><value>urn:xmpp:mam:2</value></field></x><set xmlns="http://jabber.org/protocol/rsm"><max>10</max><after>123</after></set></query>`,
+	},
+	9: {
+		Value: &history.Query{
+			Last:   true,
+			PageID: "123",
+		},
+		XML: `<query xmlns="urn:xmpp:mam:2" queryid=""><x xmlns="jabber:x:data" type="submit"><field type="hidden" var="FORM_TYPE"><value>urn:xmpp:mam:2</value></field></x><set xmlns="http://jabber.org/protocol/rsm"><before>123</before></set></query>`,
+	},
+	10: {
+		Value: &history.Query{
+			ID:   "abc",
+			With: jid.MustParse("juliet@capulet.lit"),
+		},
+		XML:       `<query xmlns="urn:xmpp:mam:2" queryid="abc"><x xmlns="jabber:x:data" type="submit"><field var="FORM_TYPE"><value>urn:xmpp:mam:2</value></field><field var="with"><value>juliet@capulet.lit</value></field></x></query>`,
+		NoMarshal: true,
+	},
+	11: {
+		Value:     &history.Query{ID: "abc"},
+		XML:       `<query xmlns="urn:xmpp:mam:2" queryid="abc"/>`,
+		NoMarshal: true,
+	},
 }
 
 func TestEncodeQuery(t *testing.T) {
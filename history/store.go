@@ -0,0 +1,189 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package history
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Message is a message stored in an archive.
+type Message struct {
+	// ID is the unique ID of the message within the archive.
+	ID string
+
+	// Time is the time at which the message was archived.
+	Time time.Time
+
+	// With is the address of the entity that the owner of the archive was
+	// communicating with and is used when filtering queries.
+	With jid.JID
+
+	// Stanza is the XML encoding of the archived message.
+	Stanza []byte
+}
+
+// Store is a message archive that can be queried by an Archive.
+type Store interface {
+	// Append adds a message to the archive owned by the bare JID archive and
+	// returns its ID.
+	// If the message does not have an ID or time one is assigned by the store.
+	Append(ctx context.Context, archive jid.JID, msg Message) (string, error)
+
+	// Query returns the page of messages from the archive owned by the bare JID
+	// archive that match the filters in q, oldest first, along with metadata
+	// about the page.
+	// If the query references a message that does not exist in the archive an
+	// item-not-found stanza error is returned.
+	Query(ctx context.Context, archive jid.JID, q Query) ([]Message, Result, error)
+}
+
+// NewStore returns a Store that keeps archives in memory.
+// It is stable, meaning that messages are never reordered or removed once they
+// have been appended.
+func NewStore() Store {
+	return &memStore{
+		archives: make(map[string][]Message),
+	}
+}
+
+type memStore struct {
+	sync.Mutex
+	archives map[string][]Message
+}
+
+func (m *memStore) Append(_ context.Context, archive jid.JID, msg Message) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	if msg.ID == "" {
+		msg.ID = attr.RandomID()
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	key := archive.Bare().String()
+	m.archives[key] = append(m.archives[key], msg)
+	return msg.ID, nil
+}
+
+var errItemNotFound = stanza.Error{
+	Type:      stanza.Cancel,
+	Condition: stanza.ItemNotFound,
+}
+
+func indexOf(msgs []Message, id string) int {
+	for i, msg := range msgs {
+		if msg.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q Query) match(msg Message) bool {
+	if !q.With.Equal(jid.JID{}) {
+		with := msg.With
+		if q.With.Resourcepart() == "" {
+			with = with.Bare()
+		}
+		if !with.Equal(q.With) {
+			return false
+		}
+	}
+	if !q.Start.IsZero() && msg.Time.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && msg.Time.After(q.End) {
+		return false
+	}
+	if len(q.IDs) > 0 {
+		for _, id := range q.IDs {
+			if id == msg.ID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (m *memStore) Query(_ context.Context, archive jid.JID, q Query) ([]Message, Result, error) {
+	m.Lock()
+	defer m.Unlock()
+	msgs := m.archives[archive.Bare().String()]
+
+	// Limit the archive to the range of messages allowed by the after-id and
+	// before-id filters.
+	if q.AfterID != "" {
+		idx := indexOf(msgs, q.AfterID)
+		if idx == -1 {
+			return nil, Result{}, errItemNotFound
+		}
+		msgs = msgs[idx+1:]
+	}
+	if q.BeforeID != "" {
+		idx := indexOf(msgs, q.BeforeID)
+		if idx == -1 {
+			return nil, Result{}, errItemNotFound
+		}
+		msgs = msgs[:idx]
+	}
+
+	var matched []Message
+	for _, msg := range msgs {
+		if q.match(msg) {
+			matched = append(matched, msg)
+		}
+	}
+
+	var begin, end int
+	var complete bool
+	switch {
+	case q.Last:
+		end = len(matched)
+		if q.PageID != "" {
+			end = indexOf(matched, q.PageID)
+			if end == -1 {
+				return nil, Result{}, errItemNotFound
+			}
+		}
+		begin = 0
+		if q.Limit > 0 && uint64(end) > q.Limit {
+			begin = end - int(q.Limit)
+		}
+		complete = begin == 0
+	default:
+		if q.PageID != "" {
+			idx := indexOf(matched, q.PageID)
+			if idx == -1 {
+				return nil, Result{}, errItemNotFound
+			}
+			begin = idx + 1
+		}
+		end = len(matched)
+		if q.Limit > 0 && uint64(end-begin) > q.Limit {
+			end = begin + int(q.Limit)
+		}
+		complete = end == len(matched)
+	}
+
+	page := make([]Message, end-begin)
+	copy(page, matched[begin:end])
+	count := uint64(len(matched))
+	res := Result{Complete: complete}
+	res.Set.Count = &count
+	if len(page) > 0 {
+		index := uint64(begin)
+		res.Set.First.ID = page[0].ID
+		res.Set.First.Index = &index
+		res.Set.Last = page[len(page)-1].ID
+	}
+	return page, res, nil
+}
@@ -8,8 +8,16 @@ package ns // import "github.com/kamrankamilli/xmpp/internal/ns"
 
 // List of commonly used namespaces.
 const (
-	Bind     = "urn:ietf:params:xml:ns:xmpp-bind"
-	SASL     = "urn:ietf:params:xml:ns:xmpp-sasl"
-	StartTLS = "urn:ietf:params:xml:ns:xmpp-tls"
-	XML      = "http://www.w3.org/XML/1998/namespace"
+	Bind            = "urn:ietf:params:xml:ns:xmpp-bind"
+	Bind2           = "urn:xmpp:bind:0"
+	Carbons         = "urn:xmpp:carbons:2"
+	Compress        = "http://jabber.org/protocol/compress"
+	CompressFeature = "http://jabber.org/features/compress"
+	FAST            = "urn:xmpp:fast:0"
+	SASL            = "urn:ietf:params:xml:ns:xmpp-sasl"
+	SASL2           = "urn:xmpp:sasl:2"
+	SASLCB          = "urn:xmpp:sasl-cb:0"
+	SM              = "urn:xmpp:sm:3"
+	StartTLS        = "urn:ietf:params:xml:ns:xmpp-tls"
+	XML             = "http://www.w3.org/XML/1998/namespace"
 )
@@ -0,0 +1,400 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package scram implements the SCRAM family of SASL mechanisms with support for
+// channel binding types that are not handled by the sasl package and for
+// downgrade protection as defined in XEP-0474: SASL SCRAM Downgrade Protection.
+package scram // import "github.com/kamrankamilli/xmpp/internal/scram"
+
+import (
+	"bytes"
+	"crypto/hmac"
+	/* #nosec */
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"mellium.im/sasl"
+)
+
+// Errors returned by the SCRAM mechanisms.
+var (
+	ErrDowngrade = errors.New("scram: the list of mechanisms or channel binding types was tampered with")
+	ErrBinding   = errors.New("scram: channel binding failed")
+)
+
+var (
+	clientKeyInput = []byte("Client Key")
+	serverKeyInput = []byte("Server Key")
+)
+
+// Hash returns the hash function used by the SCRAM mechanism with the given
+// name (with or without the -PLUS suffix).
+func Hash(name string) (func() hash.Hash, bool) {
+	switch strings.TrimSuffix(name, "-PLUS") {
+	case "SCRAM-SHA-1":
+		return sha1.New, true
+	case "SCRAM-SHA-256":
+		return sha256.New, true
+	case "SCRAM-SHA-512":
+		return sha512.New, true
+	}
+	return nil, false
+}
+
+// Config controls channel binding and downgrade protection for a single
+// negotiation.
+type Config struct {
+	// Binding is the channel binding type used by the client.
+	// If Binding is empty the client does not support channel binding on the
+	// current connection.
+	// It is ignored by servers.
+	Binding string
+
+	// Data returns the channel binding data of the given type for the current
+	// connection or an error if the type is not supported.
+	Data func(typ string) ([]byte, error)
+
+	// Mechanisms and Bindings are the SASL mechanisms and channel binding types
+	// advertised by the server.
+	// The server includes a hash of both lists in its first message and clients
+	// verify it against the lists that they received to detect if any of them
+	// were removed by an attacker.
+	// If Mechanisms is nil downgrade protection is disabled.
+	Mechanisms []string
+	Bindings   []string
+}
+
+func (cfg Config) remoteCB() bool {
+	for _, m := range cfg.Mechanisms {
+		if strings.HasSuffix(m, "-PLUS") {
+			return true
+		}
+	}
+	return false
+}
+
+// downgradeHash returns the value of the "d" attribute calculated over the
+// sorted lists of mechanisms and channel binding types.
+func (cfg Config) downgradeHash(fn func() hash.Hash) []byte {
+	mechs := append([]string(nil), cfg.Mechanisms...)
+	sort.Strings(mechs)
+	bindings := append([]string(nil), cfg.Bindings...)
+	sort.Strings(bindings)
+	h := fn()
+	/* #nosec */
+	h.Write([]byte(strings.Join(mechs, ",") + "|" + strings.Join(bindings, ",")))
+	sum := h.Sum(nil)
+	d := make([]byte, base64.StdEncoding.EncodedLen(len(sum)))
+	base64.StdEncoding.Encode(d, sum)
+	return d
+}
+
+type clientFirst struct {
+	gs2  []byte
+	bare []byte
+}
+
+type serverFirst struct {
+	cbind     []byte
+	nonce     []byte
+	authMsg   []byte
+	storedKey []byte
+	serverKey []byte
+}
+
+// Mechanism returns a SCRAM mechanism with the given name that uses fn as its
+// hash function.
+// Names ending in -PLUS use channel binding.
+func Mechanism(name string, fn func() hash.Hash, cfg Config) sasl.Mechanism {
+	plus := strings.HasSuffix(name, "-PLUS")
+	return sasl.Mechanism{
+		Name: name,
+		Start: func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+			user, _, identity := n.Credentials()
+			var gs2 []byte
+			switch {
+			case plus && cfg.Binding == "":
+				return false, nil, nil, fmt.Errorf("scram: %s requires a channel binding type", name)
+			case plus:
+				gs2 = []byte("p=" + cfg.Binding + ",")
+			case cfg.Binding != "" && !cfg.remoteCB():
+				gs2 = []byte("y,")
+			default:
+				gs2 = []byte("n,")
+			}
+			if len(identity) > 0 {
+				gs2 = append(gs2, "a="...)
+				gs2 = append(gs2, escape(identity)...)
+			}
+			gs2 = append(gs2, ',')
+			bare := concat([]byte("n="), escape(user), []byte(",r="), n.Nonce())
+			return true, concat(gs2, bare), clientFirst{gs2: gs2, bare: bare}, nil
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			if len(challenge) == 0 {
+				return false, nil, nil, sasl.ErrInvalidChallenge
+			}
+			if n.State()&sasl.Receiving == sasl.Receiving {
+				return serverNext(name, plus, fn, cfg, n, challenge, data)
+			}
+			return clientNext(fn, cfg, n, challenge, data)
+		},
+	}
+}
+
+func clientNext(fn func() hash.Hash, cfg Config, n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+	switch n.State() & sasl.StepMask {
+	case sasl.AuthTextSent:
+		first, ok := data.(clientFirst)
+		if !ok {
+			return false, nil, nil, sasl.ErrInvalidState
+		}
+		var nonce, salt, d []byte
+		iter := -1
+		for _, field := range bytes.Split(challenge, []byte{','}) {
+			if len(field) < 2 || field[1] != '=' {
+				continue
+			}
+			var err error
+			switch field[0] {
+			case 'r':
+				nonce = field[2:]
+			case 's':
+				salt, err = base64.StdEncoding.DecodeString(string(field[2:]))
+			case 'i':
+				iter, err = strconv.Atoi(string(field[2:]))
+			case 'd':
+				d = field[2:]
+			case 'm':
+				err = errors.New("scram: server sent reserved attribute m")
+			}
+			if err != nil {
+				return false, nil, nil, err
+			}
+		}
+		switch {
+		case iter <= 0:
+			return false, nil, nil, errors.New("scram: iteration count is invalid")
+		case len(salt) == 0:
+			return false, nil, nil, errors.New("scram: server sent empty salt")
+		case !bytes.HasPrefix(nonce, n.Nonce()) || len(nonce) == len(n.Nonce()):
+			return false, nil, nil, errors.New("scram: server nonce does not match client nonce")
+		}
+		if d != nil && cfg.Mechanisms != nil && subtle.ConstantTimeCompare(d, cfg.downgradeHash(fn)) != 1 {
+			return false, nil, nil, ErrDowngrade
+		}
+
+		cbind, err := cbindInput(first.gs2, cfg)
+		if err != nil {
+			return false, nil, nil, err
+		}
+		withoutProof := concat([]byte("c="), cbind, []byte(",r="), nonce)
+		authMsg := concat(first.bare, []byte{','}, challenge, []byte{','}, withoutProof)
+
+		_, password, _ := n.Credentials()
+		salted := pbkdf2.Key(password, salt, iter, fn().Size(), fn)
+		clientKey := mac(fn, salted, clientKeyInput)
+		storedKey := sum(fn, clientKey)
+		proof := make([]byte, len(clientKey))
+		subtle.XORBytes(proof, clientKey, mac(fn, storedKey, authMsg))
+		serverSig := mac(fn, mac(fn, salted, serverKeyInput), authMsg)
+
+		return true, concat(withoutProof, []byte(",p="), encode(proof)), serverSig, nil
+	case sasl.ResponseSent:
+		if bytes.HasPrefix(challenge, []byte("e=")) {
+			return false, nil, nil, fmt.Errorf("scram: server returned error %q", challenge[2:])
+		}
+		serverSig, ok := data.([]byte)
+		if !ok {
+			return false, nil, nil, sasl.ErrInvalidState
+		}
+		if !hmac.Equal(challenge, concat([]byte("v="), encode(serverSig))) {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, nil, nil, nil
+	}
+	return false, nil, nil, sasl.ErrInvalidState
+}
+
+func serverNext(name string, plus bool, fn func() hash.Hash, cfg Config, n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+	switch n.State() & sasl.StepMask {
+	case sasl.AuthTextSent:
+		// gs2-header = gs2-cbind-flag "," [ authzid ] ","
+		parts := bytes.SplitN(challenge, []byte{','}, 3)
+		if len(parts) != 3 {
+			return false, nil, nil, sasl.ErrInvalidChallenge
+		}
+		flag, authzid, bare := parts[0], parts[1], parts[2]
+		gs2 := challenge[:len(challenge)-len(bare)]
+		cfg.Binding = ""
+		switch {
+		case bytes.HasPrefix(flag, []byte("p=")) && plus:
+			cfg.Binding = string(flag[2:])
+		case bytes.Equal(flag, []byte("n")) && !plus:
+		case bytes.Equal(flag, []byte("y")) && !plus:
+			// The client supports channel binding but did not see a -PLUS mechanism
+			// in the list that we advertised.
+			if cfg.remoteCB() {
+				return false, nil, nil, ErrDowngrade
+			}
+		default:
+			return false, nil, nil, ErrBinding
+		}
+		if len(authzid) > 0 {
+			if !bytes.HasPrefix(authzid, []byte("a=")) {
+				return false, nil, nil, sasl.ErrInvalidChallenge
+			}
+			authzid = unescape(authzid[2:])
+		}
+
+		var user, clientNonce []byte
+		for i, field := range bytes.Split(bare, []byte{','}) {
+			switch {
+			case i == 0 && bytes.HasPrefix(field, []byte("n=")):
+				user = unescape(field[2:])
+			case i == 1 && bytes.HasPrefix(field, []byte("r=")):
+				clientNonce = field[2:]
+			case i < 2, bytes.HasPrefix(field, []byte("m=")):
+				return false, nil, nil, sasl.ErrInvalidChallenge
+			}
+		}
+		if len(user) == 0 || len(clientNonce) == 0 {
+			return false, nil, nil, sasl.ErrInvalidChallenge
+		}
+
+		cbind, err := cbindInput(gs2, cfg)
+		if err != nil {
+			return false, nil, nil, ErrBinding
+		}
+		salt, salted, iter, err := n.SaltedCredentials(user, authzid)
+		if err != nil {
+			return false, nil, nil, err
+		}
+
+		nonce := concat(clientNonce, n.Nonce())
+		resp := concat(
+			[]byte("r="), nonce,
+			[]byte(",s="), encode(salt),
+			[]byte(",i="), []byte(strconv.FormatInt(iter, 10)),
+		)
+		if cfg.Mechanisms != nil {
+			resp = concat(resp, []byte(",d="), cfg.downgradeHash(fn))
+		}
+		return true, resp, serverFirst{
+			cbind:     cbind,
+			nonce:     nonce,
+			authMsg:   concat(bare, []byte{','}, resp),
+			storedKey: sum(fn, mac(fn, salted, clientKeyInput)),
+			serverKey: mac(fn, salted, serverKeyInput),
+		}, nil
+	case sasl.ResponseSent:
+		first, ok := data.(serverFirst)
+		if !ok {
+			return false, nil, nil, sasl.ErrInvalidState
+		}
+		idx := bytes.LastIndex(challenge, []byte(",p="))
+		if idx < 0 {
+			return false, nil, nil, sasl.ErrInvalidChallenge
+		}
+		withoutProof := challenge[:idx]
+		proof, err := base64.StdEncoding.DecodeString(string(challenge[idx+3:]))
+		if err != nil {
+			return false, nil, nil, sasl.ErrInvalidChallenge
+		}
+		fields := bytes.Split(withoutProof, []byte{','})
+		if len(fields) < 2 || !bytes.HasPrefix(fields[0], []byte("c=")) || !bytes.HasPrefix(fields[1], []byte("r=")) {
+			return false, nil, nil, sasl.ErrInvalidChallenge
+		}
+		if subtle.ConstantTimeCompare(fields[0][2:], first.cbind) != 1 {
+			return false, nil, nil, ErrBinding
+		}
+		if !bytes.Equal(fields[1][2:], first.nonce) {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+
+		authMsg := concat(first.authMsg, []byte{','}, withoutProof)
+		clientSig := mac(fn, first.storedKey, authMsg)
+		if len(proof) != len(clientSig) {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		clientKey := make([]byte, len(proof))
+		subtle.XORBytes(clientKey, proof, clientSig)
+		if !hmac.Equal(sum(fn, clientKey), first.storedKey) {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, concat([]byte("v="), encode(mac(fn, first.serverKey, authMsg))), nil, nil
+	}
+	return false, nil, nil, sasl.ErrInvalidState
+}
+
+// cbindInput returns the base64 encoded value of the "c" attribute.
+func cbindInput(gs2 []byte, cfg Config) ([]byte, error) {
+	if !bytes.HasPrefix(gs2, []byte("p=")) {
+		return encode(gs2), nil
+	}
+	if cfg.Data == nil {
+		return nil, ErrBinding
+	}
+	data, err := cfg.Data(cfg.Binding)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrBinding
+	}
+	return encode(concat(gs2, data)), nil
+}
+
+func mac(fn func() hash.Hash, key, msg []byte) []byte {
+	h := hmac.New(fn, key)
+	/* #nosec */
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+func sum(fn func() hash.Hash, b []byte) []byte {
+	h := fn()
+	/* #nosec */
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+func encode(b []byte) []byte {
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(out, b)
+	return out
+}
+
+func concat(pieces ...[]byte) []byte {
+	var l int
+	for _, p := range pieces {
+		l += len(p)
+	}
+	out := make([]byte, 0, l)
+	for _, p := range pieces {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// escape replaces "=" and "," in a saslname with "=3D" and "=2C".
+func escape(name []byte) []byte {
+	name = bytes.ReplaceAll(name, []byte{'='}, []byte("=3D"))
+	return bytes.ReplaceAll(name, []byte{','}, []byte("=2C"))
+}
+
+func unescape(name []byte) []byte {
+	name = bytes.ReplaceAll(name, []byte("=2C"), []byte{','})
+	return bytes.ReplaceAll(name, []byte("=3D"), []byte{'='})
+}
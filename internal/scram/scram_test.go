@@ -0,0 +1,208 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package scram_test
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+	"mellium.im/sasl"
+	"github.com/kamrankamilli/xmpp/internal/scram"
+)
+
+var testSalt = []byte("saltsaltsalt")
+
+func credentials(user, pass string) sasl.Option {
+	return sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte(user), []byte(pass), nil
+	})
+}
+
+func saltedCredentials(user, identity []byte, mechanism string) ([]byte, []byte, int64, error) {
+	if u := string(user); u != "user" && u != "u=s,er" {
+		return nil, nil, 0, sasl.ErrAuthn
+	}
+	fn, ok := scram.Hash(mechanism)
+	if !ok {
+		return nil, nil, 0, fmt.Errorf("unknown mechanism %s", mechanism)
+	}
+	return testSalt, pbkdf2.Key([]byte("pencil"), testSalt, 4096, fn().Size(), fn), 4096, nil
+}
+
+func bindingData(data string) func(string) ([]byte, error) {
+	return func(typ string) ([]byte, error) {
+		if typ != "tls-server-end-point" {
+			return nil, errors.New("unsupported channel binding type")
+		}
+		return []byte(data), nil
+	}
+}
+
+// negotiate runs a SASL exchange and returns any error from the client and the
+// server.
+func negotiate(client, server *sasl.Negotiator) (clientErr, serverErr error) {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err, nil
+	}
+	for i := 0; more; i++ {
+		if i > 5 {
+			return errors.New("too many steps"), nil
+		}
+		var challenge []byte
+		more, challenge, err = server.Step(resp)
+		if err != nil {
+			return nil, err
+		}
+		clientMore, r, err := client.Step(challenge)
+		if err != nil {
+			return err, nil
+		}
+		resp = r
+		more = more || clientMore
+	}
+	return nil, nil
+}
+
+var negotiateTestCases = [...]struct {
+	mechanism string
+	user      string
+	password  string
+	client    scram.Config
+	server    scram.Config
+	clientErr error
+	serverErr error
+}{
+	0: {
+		mechanism: "SCRAM-SHA-1",
+	},
+	1: {
+		mechanism: "SCRAM-SHA-256",
+		client:    scram.Config{Mechanisms: []string{"SCRAM-SHA-256", "PLAIN"}},
+		server:    scram.Config{Mechanisms: []string{"PLAIN", "SCRAM-SHA-256"}},
+	},
+	2: {
+		mechanism: "SCRAM-SHA-512-PLUS",
+		client: scram.Config{
+			Binding:    "tls-server-end-point",
+			Data:       bindingData("cert"),
+			Mechanisms: []string{"SCRAM-SHA-512-PLUS", "SCRAM-SHA-512"},
+			Bindings:   []string{"tls-server-end-point", "tls-exporter"},
+		},
+		server: scram.Config{
+			Data:       bindingData("cert"),
+			Mechanisms: []string{"SCRAM-SHA-512", "SCRAM-SHA-512-PLUS"},
+			Bindings:   []string{"tls-exporter", "tls-server-end-point"},
+		},
+	},
+	3: {
+		// Different certificates, for example because of a MITM.
+		mechanism: "SCRAM-SHA-256-PLUS",
+		client:    scram.Config{Binding: "tls-server-end-point", Data: bindingData("mitm")},
+		server:    scram.Config{Data: bindingData("cert")},
+		serverErr: scram.ErrBinding,
+	},
+	4: {
+		// The server does not support the channel binding type.
+		mechanism: "SCRAM-SHA-256-PLUS",
+		client:    scram.Config{Binding: "tls-exporter", Data: bindingData("cert")},
+		server:    scram.Config{Data: bindingData("cert")},
+		serverErr: scram.ErrBinding,
+	},
+	5: {
+		// An attacker removed the -PLUS mechanisms from the list so the client
+		// thinks the server does not support channel binding.
+		mechanism: "SCRAM-SHA-256",
+		client: scram.Config{
+			Binding:    "tls-server-end-point",
+			Data:       bindingData("cert"),
+			Mechanisms: []string{"SCRAM-SHA-256"},
+		},
+		server: scram.Config{
+			Data:       bindingData("cert"),
+			Mechanisms: []string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256"},
+		},
+		serverErr: scram.ErrDowngrade,
+	},
+	6: {
+		// An attacker removed a channel binding type from the list.
+		mechanism: "SCRAM-SHA-256-PLUS",
+		client: scram.Config{
+			Binding:    "tls-server-end-point",
+			Data:       bindingData("cert"),
+			Mechanisms: []string{"SCRAM-SHA-256-PLUS"},
+			Bindings:   []string{"tls-server-end-point"},
+		},
+		server: scram.Config{
+			Data:       bindingData("cert"),
+			Mechanisms: []string{"SCRAM-SHA-256-PLUS"},
+			Bindings:   []string{"tls-exporter", "tls-server-end-point"},
+		},
+		clientErr: scram.ErrDowngrade,
+	},
+	7: {
+		mechanism: "SCRAM-SHA-256",
+		password:  "wrong",
+		serverErr: sasl.ErrAuthn,
+	},
+	8: {
+		mechanism: "SCRAM-SHA-256",
+		user:      "unknown",
+		serverErr: sasl.ErrAuthn,
+	},
+	9: {
+		// Usernames containing special characters are escaped.
+		mechanism: "SCRAM-SHA-256",
+		user:      "u=s,er",
+	},
+}
+
+func TestNegotiate(t *testing.T) {
+	for i, tc := range negotiateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			user, pass := tc.user, tc.password
+			if user == "" {
+				user = "user"
+			}
+			if pass == "" {
+				pass = "pencil"
+			}
+			fn, _ := scram.Hash(tc.mechanism)
+			client := sasl.NewClient(scram.Mechanism(tc.mechanism, fn, tc.client), credentials(user, pass))
+			server := sasl.NewServer(scram.Mechanism(tc.mechanism, fn, tc.server), nil, sasl.SaltedCredentials(saltedCredentials))
+			clientErr, serverErr := negotiate(client, server)
+			if fmt.Sprint(clientErr) != fmt.Sprint(tc.clientErr) {
+				t.Errorf("unexpected client error: want=%v, got=%v", tc.clientErr, clientErr)
+			}
+			if !errors.Is(serverErr, tc.serverErr) {
+				t.Errorf("unexpected server error: want=%v, got=%v", tc.serverErr, serverErr)
+			}
+		})
+	}
+}
+
+// The mechanisms must be compatible with the sasl package when channel binding
+// is not used.
+func TestInterop(t *testing.T) {
+	fn, _ := scram.Hash("SCRAM-SHA-256")
+	cfg := scram.Config{Mechanisms: []string{"SCRAM-SHA-256"}}
+
+	client := sasl.NewClient(sasl.ScramSha256, credentials("user", "pencil"))
+	server := sasl.NewServer(scram.Mechanism("SCRAM-SHA-256", fn, cfg), nil, sasl.SaltedCredentials(saltedCredentials))
+	clientErr, serverErr := negotiate(client, server)
+	if clientErr != nil || serverErr != nil {
+		t.Errorf("error negotiating with sasl client: client=%v, server=%v", clientErr, serverErr)
+	}
+
+	client = sasl.NewClient(scram.Mechanism("SCRAM-SHA-256", fn, cfg), credentials("user", "pencil"))
+	server = sasl.NewServer(sasl.ScramSha256, nil, sasl.SaltedCredentials(saltedCredentials))
+	clientErr, serverErr = negotiate(client, server)
+	if clientErr != nil || serverErr != nil {
+		t.Errorf("error negotiating with sasl server: client=%v, server=%v", clientErr, serverErr)
+	}
+}
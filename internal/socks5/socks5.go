@@ -0,0 +1,189 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package socks5 implements the subset of the SOCKS5 protocol used by
+// XEP-0065: SOCKS5 Bytestreams.
+//
+// Only the CONNECT command without authentication is supported and the
+// destination is always a domain name with a port of zero.
+package socks5 // import "github.com/kamrankamilli/xmpp/internal/socks5"
+
+import (
+	/* #nosec */
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	version    = 0x05
+	noAuth     = 0x00
+	noMethods  = 0xff
+	cmdConnect = 0x01
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+	repSuccess = 0x00
+	repFailure = 0x01
+	repRefused = 0x05
+)
+
+var (
+	errVersion = errors.New("socks5: unsupported version")
+	errMethod  = errors.New("socks5: no acceptable authentication method")
+	errRequest = errors.New("socks5: unsupported request")
+)
+
+// DstAddr returns the destination address that is used to identify a
+// bytestream.
+// It is the hex encoded SHA-1 hash of the stream ID, the address of the
+// requester, and the address of the target.
+func DstAddr(sid, requester, target string) string {
+	/* #nosec */
+	h := sha1.New()
+	// hash.Write never returns an error per the documentation.
+	/* #nosec */
+	_, _ = io.WriteString(h, sid+requester+target)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Connect performs the client side of the handshake, asking the server to
+// connect to addr.
+func Connect(rw io.ReadWriter, addr string) error {
+	if len(addr) > 255 {
+		return errRequest
+	}
+	_, err := rw.Write([]byte{version, 1, noAuth})
+	if err != nil {
+		return err
+	}
+	var b [4]byte
+	_, err = io.ReadFull(rw, b[:2])
+	if err != nil {
+		return err
+	}
+	switch {
+	case b[0] != version:
+		return errVersion
+	case b[1] != noAuth:
+		return errMethod
+	}
+
+	req := make([]byte, 0, 7+len(addr))
+	req = append(req, version, cmdConnect, 0, atypDomain, byte(len(addr)))
+	req = append(req, addr...)
+	req = append(req, 0, 0)
+	_, err = rw.Write(req)
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(rw, b[:])
+	if err != nil {
+		return err
+	}
+	if b[0] != version {
+		return errVersion
+	}
+	_, err = readAddr(rw, b[3])
+	if err != nil {
+		return err
+	}
+	if b[1] != repSuccess {
+		return fmt.Errorf("socks5: connect failed with code %d", b[1])
+	}
+	return nil
+}
+
+// ReadRequest performs the server side of the handshake up to the connect
+// request and returns the requested address.
+// The caller must respond to the request using WriteReply.
+func ReadRequest(rw io.ReadWriter) (string, error) {
+	var b [4]byte
+	_, err := io.ReadFull(rw, b[:2])
+	if err != nil {
+		return "", err
+	}
+	if b[0] != version {
+		return "", errVersion
+	}
+	methods := make([]byte, b[1])
+	_, err = io.ReadFull(rw, methods)
+	if err != nil {
+		return "", err
+	}
+	method := byte(noMethods)
+	for _, m := range methods {
+		if m == noAuth {
+			method = noAuth
+			break
+		}
+	}
+	_, err = rw.Write([]byte{version, method})
+	if err != nil {
+		return "", err
+	}
+	if method != noAuth {
+		return "", errMethod
+	}
+
+	_, err = io.ReadFull(rw, b[:])
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case b[0] != version:
+		return "", errVersion
+	case b[1] != cmdConnect || b[3] != atypDomain:
+		/* #nosec */
+		writeReply(rw, repFailure, "")
+		return "", errRequest
+	}
+	return readAddr(rw, b[3])
+}
+
+// WriteReply responds to a connect request.
+// If ok is false the request is refused, and the connection should be closed.
+func WriteReply(w io.Writer, addr string, ok bool) error {
+	if !ok {
+		return writeReply(w, repRefused, addr)
+	}
+	return writeReply(w, repSuccess, addr)
+}
+
+func writeReply(w io.Writer, rep byte, addr string) error {
+	reply := make([]byte, 0, 7+len(addr))
+	reply = append(reply, version, rep, 0, atypDomain, byte(len(addr)))
+	reply = append(reply, addr...)
+	reply = append(reply, 0, 0)
+	_, err := w.Write(reply)
+	return err
+}
+
+// readAddr reads an address and port, discarding the port.
+func readAddr(r io.Reader, atyp byte) (string, error) {
+	var l int
+	switch atyp {
+	case atypDomain:
+		var b [1]byte
+		_, err := io.ReadFull(r, b[:])
+		if err != nil {
+			return "", err
+		}
+		l = int(b[0])
+	case atypIPv4:
+		l = 4
+	case atypIPv6:
+		l = 16
+	default:
+		return "", errRequest
+	}
+	addr := make([]byte, l+2)
+	_, err := io.ReadFull(r, addr)
+	if err != nil {
+		return "", err
+	}
+	return string(addr[:l]), nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package socks5_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/kamrankamilli/xmpp/internal/socks5"
+)
+
+func TestDstAddr(t *testing.T) {
+	addr := socks5.DstAddr("vxf9n471bn46", "romeo@montague.lit/orchard", "juliet@capulet.lit/balcony")
+	if len(addr) != 40 {
+		t.Errorf("wrong length for hex encoded SHA-1: %d", len(addr))
+	}
+	if other := socks5.DstAddr("vxf9n471bn46", "juliet@capulet.lit/balcony", "romeo@montague.lit/orchard"); other == addr {
+		t.Errorf("expected address to depend on the order of requester and target")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	for i, ok := range []bool{true, false} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			const addr = "a8d8c8e1f3b2f4cfbb9a1d7b0c2e2e4c7d1f3a9b"
+			errs := make(chan error, 1)
+			go func() {
+				got, err := socks5.ReadRequest(server)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if got != addr {
+					t.Errorf("wrong address: want=%q, got=%q", addr, got)
+				}
+				errs <- socks5.WriteReply(server, got, ok)
+			}()
+			err := socks5.Connect(client, addr)
+			if ok && err != nil {
+				t.Errorf("unexpected error connecting: %v", err)
+			}
+			if !ok && err == nil {
+				t.Errorf("expected refused connection to return an error")
+			}
+			if err := <-errs; err != nil {
+				t.Errorf("unexpected error from server: %v", err)
+			}
+		})
+	}
+}
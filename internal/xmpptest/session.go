@@ -51,18 +51,20 @@ func NopNegotiator(state xmpp.SessionState, streamNS string) xmpp.Negotiator {
 // NewClientSession panics on error for ease of use in testing, where a panic is
 // acceptable.
 func NewClientSession(finalState xmpp.SessionState, rw io.ReadWriter) *xmpp.Session {
-	return newSession(finalState, rw, stanza.NSClient)
+	return newSession(finalState, rw, stanza.NSClient, jid.JID{})
 }
 
 // NewServerSession is like NewClientSession except that the stream uses the
 // server-to-server namespace.
 func NewServerSession(finalState xmpp.SessionState, rw io.ReadWriter) *xmpp.Session {
-	return newSession(finalState, rw, stanza.NSServer)
+	return newSession(finalState, rw, stanza.NSServer, jid.JID{})
 }
 
-func newSession(finalState xmpp.SessionState, rw io.ReadWriter, streamNS string) *xmpp.Session {
+func newSession(finalState xmpp.SessionState, rw io.ReadWriter, streamNS string, origin jid.JID) *xmpp.Session {
 	location := jid.MustParse("example.net")
-	origin := jid.MustParse("test@example.net")
+	if origin.Equal(jid.JID{}) {
+		origin = jid.MustParse("test@example.net")
+	}
 
 	to, from := origin, location
 	if finalState&xmpp.Received == xmpp.Received {
@@ -108,6 +110,14 @@ func ServerState(state xmpp.SessionState) Option {
 	}
 }
 
+// ClientAddr sets the address of the client in place of the default,
+// test@example.net.
+func ClientAddr(j jid.JID) Option {
+	return func(c *ClientServer) {
+		c.clientAddr = j
+	}
+}
+
 // ClientHandler sets up the client side of a ClientServer.
 func ClientHandler(handler xmpp.Handler) Option {
 	return func(c *ClientServer) {
@@ -144,6 +154,7 @@ type ClientServer struct {
 	serverHandler xmpp.Handler
 	clientState   xmpp.SessionState
 	serverState   xmpp.SessionState
+	clientAddr    jid.JID
 }
 
 // NewClientServer returns a ClientServer with the client and server goroutines
@@ -159,8 +170,8 @@ func NewClientServer(opts ...Option) *ClientServer {
 	}
 
 	clientConn, serverConn := net.Pipe()
-	cs.Client = NewClientSession(cs.clientState, clientConn)
-	cs.Server = NewServerSession(cs.serverState, serverConn)
+	cs.Client = newSession(cs.clientState, clientConn, stanza.NSClient, cs.clientAddr)
+	cs.Server = newSession(cs.serverState, serverConn, stanza.NSServer, cs.clientAddr)
 	/* #nosec */
 	go cs.Client.Serve(cs.clientHandler)
 	/* #nosec */
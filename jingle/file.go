@@ -0,0 +1,572 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle
+
+import (
+	"bytes"
+	"context"
+	// The default hash used for checksums.
+	_ "crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/crypto"
+	"github.com/kamrankamilli/xmpp/file"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// NSFileTransfer is the namespace of the file transfer application format.
+const NSFileTransfer = "urn:xmpp:jingle:apps:file-transfer:5"
+
+// The name of the content used by file transfer sessions.
+const fileContent = "file"
+
+var (
+	errNotFileTransfer = errors.New("jingle: session is not a file transfer")
+	errChecksum        = errors.New("jingle: checksum of received file does not match")
+)
+
+// Range is a range of bytes in a file.
+// A zero Length means that the range extends to the end of the file.
+type Range struct {
+	Offset uint64
+	Length uint64
+}
+
+// File describes a file that is offered or requested.
+type File struct {
+	file.Meta
+
+	// Desc is a human readable description of the file.
+	Desc string
+
+	// Range is the part of the file that is to be transferred.
+	// If it is the zero value the entire file is transferred.
+	Range Range
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+// Unlike file.Meta only the fields that are set are included.
+func (f File) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	text := func(local, v string) {
+		if v == "" {
+			return
+		}
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(v)),
+			xml.StartElement{Name: xml.Name{Local: local}},
+		))
+	}
+	text("media-type", f.MediaType)
+	text("name", f.Name)
+	if !f.Date.IsZero() {
+		text("date", f.Date.UTC().Format(time.RFC3339))
+	}
+	if f.Size != 0 {
+		text("size", strconv.FormatUint(f.Size, 10))
+	}
+	text("desc", f.Desc)
+	if f.Hash.Hash.Available() && len(f.Hash.Out) > 0 {
+		inner = append(inner, f.Hash.TokenReader())
+	}
+	if f.Range != (Range{}) {
+		attrs := []xml.Attr{{Name: xml.Name{Local: "offset"}, Value: strconv.FormatUint(f.Range.Offset, 10)}}
+		if f.Range.Length != 0 {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "length"}, Value: strconv.FormatUint(f.Range.Length, 10)})
+		}
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "range"}, Attr: attrs}))
+	}
+	for _, t := range f.Thumbnails {
+		inner = append(inner, t.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NSFileTransfer, Local: "file"}},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (f File) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, f.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (f File) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := f.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (f *File) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	in := struct {
+		MediaType string              `xml:"media-type"`
+		Name      string              `xml:"name"`
+		Date      string              `xml:"date"`
+		Size      uint64              `xml:"size"`
+		Desc      string              `xml:"desc"`
+		Hash      []crypto.HashOutput `xml:"urn:xmpp:hashes:2 hash"`
+		Range     *struct {
+			Offset uint64 `xml:"offset,attr"`
+			Length uint64 `xml:"length,attr"`
+		} `xml:"range"`
+		Thumbnail []struct {
+			URI       string `xml:"uri,attr"`
+			MediaType string `xml:"media-type,attr"`
+			Width     uint64 `xml:"width,attr"`
+			Height    uint64 `xml:"height,attr"`
+		} `xml:"urn:xmpp:thumbs:1 thumbnail"`
+	}{}
+	err := d.DecodeElement(&in, &start)
+	if err != nil {
+		return err
+	}
+	*f = File{Desc: in.Desc}
+	f.MediaType = in.MediaType
+	f.Name = in.Name
+	f.Size = in.Size
+	if in.Date != "" {
+		f.Date, err = time.Parse(time.RFC3339, in.Date)
+		if err != nil {
+			return err
+		}
+	}
+	// Prefer the first hash that we can actually verify.
+	for _, h := range in.Hash {
+		if h.Hash.Available() {
+			f.Hash = h
+			break
+		}
+	}
+	if in.Range != nil {
+		f.Range = Range{Offset: in.Range.Offset, Length: in.Range.Length}
+	}
+	for _, t := range in.Thumbnail {
+		f.Thumbnails = append(f.Thumbnails, file.Thumbnail(t))
+	}
+	return nil
+}
+
+// description is the description element of a file transfer content.
+type description struct {
+	File File
+}
+
+func (d description) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(
+		d.File.TokenReader(),
+		xml.StartElement{Name: xml.Name{Space: NSFileTransfer, Local: "description"}},
+	)
+}
+
+// fileInfo is a session-info payload of a file transfer session.
+type fileInfo struct {
+	XMLName xml.Name
+	Creator Creator `xml:"creator,attr"`
+	Name    string  `xml:"name,attr"`
+	File    *File   `xml:"file"`
+}
+
+func infoPayload(local string, c Content, f *File) xml.TokenReader {
+	var inner xml.TokenReader
+	if f != nil {
+		inner = f.TokenReader()
+	}
+	return xmlstream.Wrap(inner, xml.StartElement{
+		Name: xml.Name{Space: NSFileTransfer, Local: local},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "creator"}, Value: string(c.Creator)},
+			{Name: xml.Name{Local: "name"}, Value: c.Name},
+		},
+	})
+}
+
+// Describe returns the file described by a file transfer session and whether
+// the local entity is the one that sends it.
+// This is the case for files offered by the local entity and for files that
+// the peer requested from it.
+func Describe(s *Session) (f File, send bool, err error) {
+	c, err := fileTransferContent(s)
+	if err != nil {
+		return f, false, err
+	}
+	d, ok := c.Description.(Element)
+	if !ok {
+		return describe(c.Description), c.Senders == sendersFor(s.Role()), nil
+	}
+	err = d.decodeFile(&f)
+	return f, c.Senders == sendersFor(s.Role()), err
+}
+
+// describe returns the file from a description that was created by the local
+// entity.
+func describe(m xmlstream.Marshaler) File {
+	d, _ := m.(description)
+	return d.File
+}
+
+func (e Element) decodeFile(f *File) error {
+	d := struct {
+		File File `xml:"file"`
+	}{}
+	err := e.Decode(&d)
+	*f = d.File
+	return err
+}
+
+func sendersFor(c Creator) Senders {
+	if c == Initiator {
+		return SendInitiator
+	}
+	return SendResponder
+}
+
+func fileTransferContent(s *Session) (Content, error) {
+	for _, c := range s.Contents() {
+		switch d := c.Description.(type) {
+		case description:
+			return c, nil
+		case Element:
+			if d.XMLName.Space == NSFileTransfer {
+				return c, nil
+			}
+		}
+	}
+	return Content{}, errNotFileTransfer
+}
+
+// transfer is the state of a file transfer session that is modified by
+// informational payloads sent by the peer.
+type transfer struct {
+	checksum chan crypto.HashOutput
+	received chan struct{}
+}
+
+// FileTransfer is an Application that implements XEP-0234: Jingle File
+// Transfer.
+//
+// Sessions transfer a single file over a content named "file" and the entity
+// that sends the file ends the session once the receiver has acknowledged it.
+// The zero value is ready to use, but must not be copied after first use.
+type FileTransfer struct {
+	mu        sync.Mutex
+	transfers map[*Session]*transfer
+}
+
+func (ft *FileTransfer) transfer(s *Session) *transfer {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	t, ok := ft.transfers[s]
+	if ok {
+		return t
+	}
+	if ft.transfers == nil {
+		ft.transfers = make(map[*Session]*transfer)
+	}
+	t = &transfer{
+		checksum: make(chan crypto.HashOutput, 1),
+		received: make(chan struct{}, 1),
+	}
+	ft.transfers[s] = t
+	go func() {
+		<-s.Done()
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+		delete(ft.transfers, s)
+	}()
+	return t
+}
+
+// Namespace satisfies Application.
+func (*FileTransfer) Namespace() string {
+	return NSFileTransfer
+}
+
+// HandleContent satisfies Application.
+// File transfer sessions only transfer a single file, so any content that is
+// added to them is rejected.
+func (*FileTransfer) HandleContent(s *Session, c Content) {
+	/* #nosec */
+	s.RejectContent(context.Background(), c.Name, Reason{Condition: UnsupportedApplications})
+}
+
+// HandleInfo satisfies Application.
+func (ft *FileTransfer) HandleInfo(s *Session, a Action, info Element) error {
+	if a != SessionInfo {
+		return ErrUnsupportedInfo
+	}
+	p := fileInfo{}
+	err := info.Decode(&p)
+	if err != nil {
+		return err
+	}
+	t := ft.transfer(s)
+	switch p.XMLName.Local {
+	case "received":
+		select {
+		case t.received <- struct{}{}:
+		default:
+		}
+	case "checksum":
+		if p.File == nil || !p.File.Hash.Hash.Available() {
+			return errBadRequest
+		}
+		select {
+		case t.checksum <- p.File.Hash:
+		default:
+		}
+	default:
+		return ErrUnsupportedInfo
+	}
+	return nil
+}
+
+// Offer offers a file to the peer and sends the contents of r once it is
+// accepted.
+// If the peer asks for a range of the file and r is an io.Seeker, the start of
+// the range is seeked to, otherwise the data before it is discarded.
+//
+// If f has a hash that the peer can use to verify the file it should be set,
+// otherwise a checksum is calculated while sending.
+// Offer returns once the peer has acknowledged receipt of the file.
+func (ft *FileTransfer) Offer(ctx context.Context, m *Manager, s *xmpp.Session, to jid.JID, f File, r io.Reader) error {
+	f.Range = Range{}
+	sess, err := m.Initiate(ctx, s, to, Content{
+		Name:        fileContent,
+		Senders:     SendInitiator,
+		Description: description{File: f},
+	})
+	if err != nil {
+		return err
+	}
+	return ft.send(ctx, sess, f, r)
+}
+
+// Send accepts a session in which the peer requested a file and sends the
+// contents of r.
+// Range requests are handled in the same way as by Offer.
+func (ft *FileTransfer) Send(ctx context.Context, sess *Session, r io.Reader) error {
+	f, send, err := Describe(sess)
+	if err != nil {
+		return err
+	}
+	if !send {
+		return errors.New("jingle: file was not requested")
+	}
+	err = sess.Accept(ctx)
+	if err != nil {
+		return err
+	}
+	return ft.send(ctx, sess, f, r)
+}
+
+func (ft *FileTransfer) send(ctx context.Context, sess *Session, f File, r io.Reader) error {
+	t := ft.transfer(sess)
+	conn, err := sess.Conn(ctx, fileContent)
+	if err != nil {
+		/* #nosec */
+		sess.Terminate(ctx, Reason{Condition: FailedTransport, Text: err.Error()})
+		return err
+	}
+
+	// The receiver of an offer may have asked for a range when accepting.
+	accepted, _, err := Describe(sess)
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		return err
+	}
+	rng := accepted.Range
+	if rng.Offset > 0 {
+		if seeker, ok := r.(io.Seeker); ok {
+			_, err = seeker.Seek(int64(rng.Offset), io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, r, int64(rng.Offset))
+		}
+		if err != nil {
+			/* #nosec */
+			conn.Close()
+			/* #nosec */
+			sess.Terminate(ctx, Reason{Condition: MediaError, Text: err.Error()})
+			return err
+		}
+	}
+	if rng.Length > 0 {
+		r = io.LimitReader(r, int64(rng.Length))
+	}
+
+	// The checksum is only meaningful if the entire file is sent.
+	var h hash.Hash
+	algo := f.Hash.Hash
+	if !algo.Available() {
+		algo = crypto.SHA256
+	}
+	if rng == (Range{}) && algo.Available() {
+		h = algo.New()
+		r = io.TeeReader(r, h)
+	}
+	_, err = io.Copy(conn, r)
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		/* #nosec */
+		sess.Terminate(ctx, Reason{Condition: ConnectivityError, Text: err.Error()})
+		return err
+	}
+
+	// The checksum is sent before the stream is closed so that the receiver has
+	// it by the time it has read the entire file.
+	if h != nil {
+		c, err := fileTransferContent(sess)
+		if err == nil {
+			err = sess.Info(ctx, infoPayload("checksum", c, &File{
+				Meta: file.Meta{Hash: crypto.HashOutput{Hash: algo, Out: h.Sum(nil)}},
+			}))
+		}
+		if err != nil {
+			/* #nosec */
+			conn.Close()
+			return err
+		}
+	}
+	err = conn.Close()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sess.Done():
+		return sess.Err()
+	case <-t.received:
+	}
+	return sess.Terminate(ctx, Reason{Condition: Success})
+}
+
+// Request asks the peer to send a file and writes it to w.
+// The file is identified by its name or hash and a range of the file may be
+// requested.
+// If the peer provides a checksum for the entire file it is verified.
+func (ft *FileTransfer) Request(ctx context.Context, m *Manager, s *xmpp.Session, to jid.JID, f File, w io.Writer) (File, error) {
+	sess, err := m.Initiate(ctx, s, to, Content{
+		Name:        fileContent,
+		Senders:     SendResponder,
+		Description: description{File: f},
+	})
+	if err != nil {
+		return File{}, err
+	}
+	return ft.receive(ctx, sess, f, w)
+}
+
+// Receive accepts a session in which the peer offered a file and writes the
+// file to w.
+// If the offered file or a checksum provided by the peer has a hash it is
+// verified.
+func (ft *FileTransfer) Receive(ctx context.Context, sess *Session, w io.Writer) (File, error) {
+	return ft.ReceiveRange(ctx, sess, w, Range{})
+}
+
+// ReceiveRange is like Receive except that only the provided range of the file
+// is requested.
+// This can be used to resume a transfer that was interrupted.
+// Hashes are not verified if a range is requested.
+func (ft *FileTransfer) ReceiveRange(ctx context.Context, sess *Session, w io.Writer, rng Range) (File, error) {
+	f, send, err := Describe(sess)
+	if err != nil {
+		return f, err
+	}
+	if send {
+		return f, errors.New("jingle: file was not offered")
+	}
+	f.Range = rng
+	err = sess.describe(fileContent, description{File: f})
+	if err != nil {
+		return f, err
+	}
+	err = sess.Accept(ctx)
+	if err != nil {
+		return f, err
+	}
+	return ft.receive(ctx, sess, f, w)
+}
+
+func (ft *FileTransfer) receive(ctx context.Context, sess *Session, f File, w io.Writer) (File, error) {
+	t := ft.transfer(sess)
+	conn, err := sess.Conn(ctx, fileContent)
+	if err != nil {
+		return f, err
+	}
+	// The sender may have described the file in more detail when it accepted a
+	// request.
+	if accepted, _, err := Describe(sess); err == nil {
+		accepted.Range = f.Range
+		if accepted.Hash.Hash.Available() || !f.Hash.Hash.Available() {
+			f = accepted
+		}
+	}
+
+	// If the hash is not yet known, guess that the sender will provide a
+	// checksum using the algorithm it uses by default.
+	var h hash.Hash
+	verify := f.Range == (Range{})
+	algo := f.Hash.Hash
+	if !algo.Available() {
+		algo = crypto.SHA256
+	}
+	if verify {
+		h = algo.New()
+		w = io.MultiWriter(w, h)
+	}
+	_, err = io.Copy(w, conn)
+	/* #nosec */
+	conn.Close()
+	if err != nil {
+		/* #nosec */
+		sess.Terminate(ctx, Reason{Condition: ConnectivityError, Text: err.Error()})
+		return f, err
+	}
+
+	c, err := fileTransferContent(sess)
+	if err != nil {
+		return f, err
+	}
+	received := infoPayload("received", c, nil)
+	if !verify {
+		return f, sess.Info(ctx, received)
+	}
+
+	// If the hash was not known before the file was sent the sender should
+	// have provided a checksum before closing the stream.
+	if !f.Hash.Hash.Available() {
+		select {
+		case f.Hash = <-t.checksum:
+		default:
+		}
+	}
+	if f.Hash.Hash != algo {
+		// We guessed the wrong algorithm or the sender did not provide a
+		// checksum, so the file can't be verified.
+		return f, sess.Info(ctx, received)
+	}
+
+	sum := h.Sum(nil)
+	if !bytes.Equal(sum, f.Hash.Out) {
+		/* #nosec */
+		sess.Terminate(ctx, Reason{Condition: MediaError, Text: "checksum mismatch"})
+		return f, fmt.Errorf("%w: want=%x, got=%x", errChecksum, f.Hash.Out, sum)
+	}
+	return f, sess.Info(ctx, received)
+}
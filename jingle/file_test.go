@@ -0,0 +1,179 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamrankamilli/xmpp/crypto"
+	"github.com/kamrankamilli/xmpp/file"
+	"github.com/kamrankamilli/xmpp/ibb"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jingle"
+)
+
+const fileData = "Three Rings for the Elven-kings under the sky"
+
+func TestEncodeFile(t *testing.T) {
+	xmpptest.RunEncodingTests(t, []xmpptest.EncodingTestCase{
+		0: {
+			Value: &jingle.File{},
+			XML:   `<file xmlns="urn:xmpp:jingle:apps:file-transfer:5"></file>`,
+		},
+		1: {
+			Value: &jingle.File{
+				Meta: file.Meta{
+					MediaType: "text/plain",
+					Name:      "rings.txt",
+					Date:      time.Date(2026, 01, 01, 01, 01, 01, 00, time.UTC),
+					Size:      45,
+					Hash: crypto.HashOutput{
+						Hash: crypto.SHA256,
+						Out:  []byte{1, 2, 3},
+					},
+				},
+				Desc:  "A poem",
+				Range: jingle.Range{Offset: 10, Length: 5},
+			},
+			XML: `<file xmlns="urn:xmpp:jingle:apps:file-transfer:5"><media-type>text/plain</media-type><name>rings.txt</name><date>2026-01-01T01:01:01Z</date><size>45</size><desc>A poem</desc><hash xmlns="urn:xmpp:hashes:2" algo="sha-256">AQID</hash><range offset="10" length="5"></range></file>`,
+		},
+	})
+}
+
+func socks5Only(*ibb.Handler) []jingle.Transport {
+	return []jingle.Transport{jingle.SOCKS5{Addr: "127.0.0.1:0"}}
+}
+
+func fileTransfer(t *testing.T, p peers) {
+	ctx := context.Background()
+	l := p.server.Listen(p.cs.Server)
+
+	sum := sha256.Sum256([]byte(fileData))
+	offered := jingle.File{Meta: file.Meta{
+		Name: "rings.txt",
+		Size: uint64(len(fileData)),
+		Hash: crypto.HashOutput{Hash: crypto.SHA256, Out: sum[:]},
+	}}
+	recv := make(chan string, 1)
+	go func() {
+		defer close(recv)
+		sess, err := l.Accept()
+		if err != nil {
+			t.Errorf("error accepting session: %v", err)
+			return
+		}
+		f, send, err := jingle.Describe(sess)
+		if err != nil {
+			t.Errorf("error describing file: %v", err)
+		}
+		if send || f.Name != offered.Name || f.Size != offered.Size {
+			t.Errorf("wrong file description: send=%t, file=%+v", send, f)
+		}
+		var buf bytes.Buffer
+		_, err = p.serverFT.Receive(ctx, sess, &buf)
+		if err != nil {
+			t.Errorf("error receiving file: %v", err)
+		}
+		recv <- buf.String()
+	}()
+
+	err := p.clientFT.Offer(ctx, p.client, p.cs.Client, p.cs.Server.LocalAddr(), offered, strings.NewReader(fileData))
+	if err != nil {
+		t.Fatalf("error offering file: %v", err)
+	}
+	if got := <-recv; got != fileData {
+		t.Errorf("wrong file received: want=%q, got=%q", fileData, got)
+	}
+}
+
+func TestOfferSOCKS5(t *testing.T) {
+	fileTransfer(t, newPeers(socks5Only, socks5Only))
+}
+
+func TestOfferFallback(t *testing.T) {
+	// Without any candidates to connect to the transfer falls back to IBB.
+	unreachable := func(h *ibb.Handler) []jingle.Transport {
+		return []jingle.Transport{jingle.SOCKS5{}, jingle.IBB{Handler: h}}
+	}
+	p := newPeers(unreachable, unreachable)
+	p.client.Fallback = true
+	p.server.Fallback = true
+	fileTransfer(t, p)
+}
+
+func TestRequestRange(t *testing.T) {
+	p := newPeers(socks5Only, socks5Only)
+	ctx := context.Background()
+	l := p.server.Listen(p.cs.Server)
+
+	go func() {
+		sess, err := l.Accept()
+		if err != nil {
+			t.Errorf("error accepting session: %v", err)
+			return
+		}
+		f, send, err := jingle.Describe(sess)
+		if err != nil {
+			t.Errorf("error describing file: %v", err)
+		}
+		if !send || f.Name != "rings.txt" {
+			t.Errorf("wrong file request: send=%t, file=%+v", send, f)
+		}
+		err = p.serverFT.Send(ctx, sess, strings.NewReader(fileData))
+		if err != nil {
+			t.Errorf("error sending file: %v", err)
+		}
+	}()
+
+	var buf bytes.Buffer
+	_, err := p.clientFT.Request(ctx, p.client, p.cs.Client, p.cs.Server.LocalAddr(), jingle.File{
+		Meta:  file.Meta{Name: "rings.txt"},
+		Range: jingle.Range{Offset: 6, Length: 5},
+	}, &buf)
+	if err != nil {
+		t.Fatalf("error requesting file: %v", err)
+	}
+	if want := fileData[6:11]; buf.String() != want {
+		t.Errorf("wrong range received: want=%q, got=%q", want, buf.String())
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	p := newPeers(ibbOnly, ibbOnly)
+	ctx := context.Background()
+	l := p.server.Listen(p.cs.Server)
+
+	errs := make(chan error, 1)
+	go func() {
+		sess, err := l.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		_, err = p.serverFT.Receive(ctx, sess, &bytes.Buffer{})
+		errs <- err
+	}()
+
+	offered := jingle.File{Meta: file.Meta{
+		Name: "rings.txt",
+		Hash: crypto.HashOutput{Hash: crypto.SHA256, Out: make([]byte, sha256.Size)},
+	}}
+	/* #nosec */
+	p.clientFT.Offer(ctx, p.client, p.cs.Client, p.cs.Server.LocalAddr(), offered, strings.NewReader(fileData))
+	err := <-errs
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected checksum error, got: %v", err)
+	}
+	var r jingle.Reason
+	if errors.As(err, &r) {
+		t.Errorf("unexpected reason: %v", r)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"strconv"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/ibb"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// NSIBB is the namespace of the in-band bytestreams transport.
+const NSIBB = "urn:xmpp:jingle:transports:ibb:1"
+
+// IBB is a Transport that exchanges data over in-band bytestreams as defined
+// in XEP-0261: Jingle In-Band Bytestreams Transport Method.
+//
+// Because IBB is inefficient it should generally be the least preferred
+// transport.
+// The Handler must also be registered on the mux that handles stanzas for the
+// XMPP session (see ibb.Handle).
+type IBB struct {
+	Handler *ibb.Handler
+
+	// BlockSize is the largest block size that will be offered or accepted.
+	// If it is zero, ibb.BlockSize is used.
+	BlockSize uint16
+}
+
+type ibbTransport struct {
+	XMLName   xml.Name `xml:"urn:xmpp:jingle:transports:ibb:1 transport"`
+	BlockSize uint16   `xml:"block-size,attr"`
+	SID       string   `xml:"sid,attr"`
+}
+
+func (t IBB) blockSize() uint16 {
+	if t.BlockSize == 0 {
+		return ibb.BlockSize
+	}
+	return t.BlockSize
+}
+
+// Namespace satisfies Transport.
+func (IBB) Namespace() string {
+	return NSIBB
+}
+
+// Offer satisfies Transport.
+// The local entity opens the bytestream once the content is accepted.
+func (t IBB) Offer(s *Session, _ string) (Negotiation, error) {
+	return &ibbNegotiation{
+		h:         t.Handler,
+		s:         s,
+		sid:       attr.RandomID(),
+		blockSize: t.blockSize(),
+	}, nil
+}
+
+// Accept satisfies Transport.
+// The local entity immediately starts waiting for the peer to open the
+// bytestream.
+func (t IBB) Accept(s *Session, _ string, offer Element) (Negotiation, error) {
+	p := ibbTransport{}
+	err := offer.Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+	if p.SID == "" {
+		return nil, errors.New("jingle: missing IBB session ID")
+	}
+	blockSize := t.blockSize()
+	if p.BlockSize > 0 && p.BlockSize < blockSize {
+		blockSize = p.BlockSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &ibbNegotiation{
+		h:         t.Handler,
+		s:         s,
+		sid:       p.SID,
+		blockSize: blockSize,
+		cancel:    cancel,
+		expect:    make(chan ibbResult, 1),
+	}
+	l := t.Handler.Listen(s.XMPPSession())
+	go func() {
+		conn, err := l.Expect(ctx, s.Peer(), p.SID)
+		n.expect <- ibbResult{conn: conn, err: err}
+	}()
+	return n, nil
+}
+
+type ibbResult struct {
+	conn net.Conn
+	err  error
+}
+
+type ibbNegotiation struct {
+	h         *ibb.Handler
+	s         *Session
+	sid       string
+	blockSize uint16
+	cancel    context.CancelFunc
+	expect    chan ibbResult
+}
+
+func (n *ibbNegotiation) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSIBB, Local: "transport"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "block-size"}, Value: strconv.FormatUint(uint64(n.blockSize), 10)},
+			{Name: xml.Name{Local: "sid"}, Value: n.sid},
+		},
+	})
+}
+
+func (n *ibbNegotiation) Accepted(answer Element) error {
+	p := ibbTransport{}
+	err := answer.Decode(&p)
+	if err != nil {
+		return err
+	}
+	if p.SID != "" && p.SID != n.sid {
+		return errBadRequest
+	}
+	// The responder may only lower the block size.
+	if p.BlockSize > 0 && p.BlockSize < n.blockSize {
+		n.blockSize = p.BlockSize
+	}
+	return nil
+}
+
+func (n *ibbNegotiation) HandleInfo(Element) error {
+	return ErrUnsupportedInfo
+}
+
+func (n *ibbNegotiation) Connect(ctx context.Context) (net.Conn, error) {
+	if n.expect == nil {
+		conn, err := n.h.OpenIQ(ctx, stanza.IQ{To: n.s.Peer()}, n.s.XMPPSession(), true, n.blockSize, n.sid)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-n.expect:
+		return result.conn, result.err
+	}
+}
+
+func (n *ibbNegotiation) Close() error {
+	if n.cancel != nil {
+		n.cancel()
+	}
+	return nil
+}
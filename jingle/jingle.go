@@ -0,0 +1,279 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package jingle implements XEP-0166: Jingle.
+//
+// Jingle is a framework for negotiating sessions between two entities over
+// XMPP.
+// This package manages the sessions and their state while the application
+// formats (what is being exchanged, such as a file) and transport methods (how
+// it is exchanged, such as in-band bytestreams) are provided by plugins that
+// implement the Application and Transport interfaces.
+//
+// File transfer (XEP-0234: Jingle File Transfer) is provided by the
+// FileTransfer application, and files may be exchanged over SOCKS5
+// bytestreams (XEP-0260) or in-band bytestreams (XEP-0261).
+package jingle // import "github.com/kamrankamilli/xmpp/jingle"
+
+import (
+	"encoding/xml"
+	"io"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS       = "urn:xmpp:jingle:1"
+	NSErrors = "urn:xmpp:jingle:errors:1"
+)
+
+// Feature is the service discovery feature advertised by entities that support
+// Jingle.
+var Feature = info.Feature{Var: NS}
+
+// Action is the action attribute of a Jingle element.
+type Action string
+
+// A list of actions.
+const (
+	ContentAccept    Action = "content-accept"
+	ContentAdd       Action = "content-add"
+	ContentModify    Action = "content-modify"
+	ContentReject    Action = "content-reject"
+	ContentRemove    Action = "content-remove"
+	DescriptionInfo  Action = "description-info"
+	SecurityInfo     Action = "security-info"
+	SessionAccept    Action = "session-accept"
+	SessionInfo      Action = "session-info"
+	SessionInitiate  Action = "session-initiate"
+	SessionTerminate Action = "session-terminate"
+	TransportAccept  Action = "transport-accept"
+	TransportInfo    Action = "transport-info"
+	TransportReject  Action = "transport-reject"
+	TransportReplace Action = "transport-replace"
+)
+
+// Creator is the party that originally generated a content.
+type Creator string
+
+// A list of creators.
+const (
+	Initiator Creator = "initiator"
+	Responder Creator = "responder"
+)
+
+// Senders is the parties in a session that will be generating content.
+type Senders string
+
+// A list of senders.
+// The empty value means SendBoth.
+const (
+	SendBoth      Senders = "both"
+	SendInitiator Senders = "initiator"
+	SendNone      Senders = "none"
+	SendResponder Senders = "responder"
+)
+
+// Content is a content definition.
+//
+// When decoded from a Jingle element the description and transport are of
+// type Element.
+// Contents that are added to a session locally may use any type for their
+// description, but their transport is always created by the Transport plugin
+// that is chosen for the content.
+type Content struct {
+	Creator     Creator
+	Disposition string
+	Name        string
+	Senders     Senders
+	Description xmlstream.Marshaler
+	Transport   xmlstream.Marshaler
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (c Content) TokenReader() xml.TokenReader {
+	start := xml.StartElement{
+		Name: xml.Name{Local: "content"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "creator"}, Value: string(c.Creator)},
+			{Name: xml.Name{Local: "name"}, Value: c.Name},
+		},
+	}
+	if c.Disposition != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "disposition"}, Value: c.Disposition})
+	}
+	if c.Senders != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "senders"}, Value: string(c.Senders)})
+	}
+	var inner []xml.TokenReader
+	if c.Description != nil {
+		inner = append(inner, c.Description.TokenReader())
+	}
+	if c.Transport != nil {
+		inner = append(inner, c.Transport.TokenReader())
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (c Content) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML satisfies the xml.Marshaler interface.
+func (c Content) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML satisfies the xml.Unmarshaler interface.
+func (c *Content) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		Creator     Creator  `xml:"creator,attr"`
+		Disposition string   `xml:"disposition,attr"`
+		Name        string   `xml:"name,attr"`
+		Senders     Senders  `xml:"senders,attr"`
+		Description *Element `xml:"description"`
+		Transport   *Element `xml:"transport"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	c.Creator = s.Creator
+	c.Disposition = s.Disposition
+	c.Name = s.Name
+	c.Senders = s.Senders
+	c.Description = nil
+	if s.Description != nil {
+		c.Description = *s.Description
+	}
+	c.Transport = nil
+	if s.Transport != nil {
+		c.Transport = *s.Transport
+	}
+	return nil
+}
+
+// Element is an element sent by the peer that is not understood by this
+// package, such as a description, transport, or informational payload.
+// The tokens of the element are kept so that it can be decoded by the plugin
+// that understands its namespace.
+type Element struct {
+	XMLName xml.Name
+	toks    []xml.Token
+}
+
+// removeNS removes namespace declarations from elements since they are added
+// back by the encoder. See https://mellium.im/issue/75
+var removeNS = xmlstream.RemoveAttr(func(_ xml.StartElement, attr xml.Attr) bool {
+	return attr.Name.Local == "xmlns" || attr.Name.Space == "xmlns"
+})
+
+// UnmarshalXML satisfies the xml.Unmarshaler interface.
+func (e *Element) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	e.XMLName = start.Name
+	e.toks = append(e.toks[:0], start.Copy())
+	inner := xmlstream.Inner(d)
+	for {
+		tok, err := inner.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		e.toks = append(e.toks, xml.CopyToken(tok))
+	}
+	e.toks = append(e.toks, start.End())
+	return nil
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (e Element) TokenReader() xml.TokenReader {
+	return removeNS(&tokenSlice{toks: e.toks})
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (e Element) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, e.TokenReader())
+}
+
+// Decode unmarshals the element into v.
+func (e Element) Decode(v interface{}) error {
+	return xml.NewTokenDecoder(e.TokenReader()).Decode(v)
+}
+
+// tokenReader turns an xml.TokenReader into an xmlstream.Marshaler that may
+// only be used once.
+type tokenReader struct {
+	r xml.TokenReader
+}
+
+func (t tokenReader) TokenReader() xml.TokenReader {
+	return t.r
+}
+
+type tokenSlice struct {
+	toks []xml.Token
+}
+
+func (t *tokenSlice) Token() (xml.Token, error) {
+	if len(t.toks) == 0 {
+		return nil, io.EOF
+	}
+	tok := t.toks[0]
+	t.toks = t.toks[1:]
+	return tok, nil
+}
+
+// jingle is the Jingle element that is the payload of every Jingle IQ.
+type jingle struct {
+	XMLName   xml.Name  `xml:"urn:xmpp:jingle:1 jingle"`
+	Action    Action    `xml:"action,attr"`
+	Initiator jid.JID   `xml:"initiator,attr"`
+	Responder jid.JID   `xml:"responder,attr"`
+	SID       string    `xml:"sid,attr"`
+	Contents  []Content `xml:"content"`
+	Reason    *Reason   `xml:"reason"`
+	Info      *Element  `xml:",any"`
+
+	// payload is an informational payload to send along with the action.
+	payload xml.TokenReader
+}
+
+func (j jingle) TokenReader() xml.TokenReader {
+	start := xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "jingle"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "action"}, Value: string(j.Action)},
+			{Name: xml.Name{Local: "sid"}, Value: j.SID},
+		},
+	}
+	if !j.Initiator.Equal(jid.JID{}) {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "initiator"}, Value: j.Initiator.String()})
+	}
+	if !j.Responder.Equal(jid.JID{}) {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "responder"}, Value: j.Responder.String()})
+	}
+	var inner []xml.TokenReader
+	for _, c := range j.Contents {
+		inner = append(inner, c.TokenReader())
+	}
+	if j.Reason != nil {
+		inner = append(inner, j.Reason.TokenReader())
+	}
+	if j.payload != nil {
+		inner = append(inner, j.payload)
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start)
+}
@@ -0,0 +1,324 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Handle returns an option that registers a Manager to handle Jingle actions.
+func Handle(m *Manager) mux.Option {
+	return mux.IQ(stanza.SetIQ, xml.Name{Space: NS, Local: "jingle"}, m)
+}
+
+// Manager keeps track of Jingle sessions and dispatches actions to them.
+type Manager struct {
+	// Applications are the application formats that may be used in sessions.
+	Applications []Application
+
+	// Transports are the transport methods that may be used in sessions, in
+	// order of preference.
+	// Contents offered by the local entity use the first transport.
+	Transports []Transport
+
+	// Fallback causes Session.Conn to replace the transport of a content with
+	// the next transport in order of preference if it fails to connect.
+	// It should be set by both parties to a session.
+	Fallback bool
+
+	mu        sync.Mutex
+	sessions  map[string]*Session
+	listeners map[string]*Listener
+}
+
+func sessionKey(peer jid.JID, sid string) string {
+	return peer.String() + ":" + sid
+}
+
+func (m *Manager) application(ns string) Application {
+	for _, app := range m.Applications {
+		if app.Namespace() == ns {
+			return app
+		}
+	}
+	return nil
+}
+
+func (m *Manager) transport(ns string) Transport {
+	for _, t := range m.Transports {
+		if t.Namespace() == ns {
+			return t
+		}
+	}
+	return nil
+}
+
+func (m *Manager) session(peer jid.JID, sid string) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[sessionKey(peer, sid)]
+}
+
+// add registers a session and reports whether the session ID was free.
+func (m *Manager) add(s *Session) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := sessionKey(s.peer, s.sid)
+	if _, ok := m.sessions[key]; ok {
+		return false
+	}
+	if m.sessions == nil {
+		m.sessions = make(map[string]*Session)
+	}
+	m.sessions[key] = s
+	return true
+}
+
+func (m *Manager) remove(s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := sessionKey(s.peer, s.sid)
+	if m.sessions[key] == s {
+		delete(m.sessions, key)
+	}
+}
+
+// ForFeatures implements info.FeatureIter.
+func (m *Manager) ForFeatures(node string, f func(info.Feature) error) error {
+	if node != "" {
+		return nil
+	}
+	err := f(Feature)
+	if err != nil {
+		return err
+	}
+	for _, app := range m.Applications {
+		err = f(info.Feature{Var: app.Namespace()})
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range m.Transports {
+		err = f(info.Feature{Var: t.Namespace()})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Initiate starts a new session with the peer at the provided address.
+// The contents are offered using the most preferred transport, their creator
+// and transport are ignored.
+//
+// Initiate returns once the peer has acknowledged the session, not once it has
+// been accepted.
+func (m *Manager) Initiate(ctx context.Context, s *xmpp.Session, to jid.JID, contents ...Content) (*Session, error) {
+	if len(contents) == 0 {
+		return nil, errors.New("jingle: no contents to initiate session with")
+	}
+	sess := newSession(m, s, to, attr.RandomID(), Initiator)
+	sess.initiator = s.LocalAddr()
+	for _, c := range contents {
+		newContent, err := sess.offer(c)
+		if err != nil {
+			sess.end(Reason{Condition: FailedTransport})
+			return nil, err
+		}
+		sess.addContent(newContent)
+	}
+	if !m.add(sess) {
+		sess.end(Reason{Condition: GeneralError})
+		return nil, errors.New("jingle: session ID already in use")
+	}
+
+	err := sess.send(ctx, jingle{
+		Action:    SessionInitiate,
+		Initiator: sess.initiator,
+		Contents:  sess.Contents(),
+	})
+	if err != nil {
+		sess.end(Reason{Condition: ConnectivityError})
+		return nil, err
+	}
+	return sess, nil
+}
+
+// Listen creates a listener that accepts sessions initiated by peers of s.
+//
+// If a listener has already been created for the given session it is returned
+// unaltered.
+func (m *Manager) Listen(s *xmpp.Session) *Listener {
+	addr := s.LocalAddr().String()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.listeners == nil {
+		m.listeners = make(map[string]*Listener)
+	}
+	l, ok := m.listeners[addr]
+	if ok {
+		return l
+	}
+	l = &Listener{
+		s:      s,
+		m:      m,
+		c:      make(chan *Session),
+		closed: make(chan struct{}),
+	}
+	m.listeners[addr] = l
+	return l
+}
+
+func (m *Manager) listener(to jid.JID) *Listener {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.listeners[to.String()]
+	if !ok {
+		l = m.listeners[""]
+	}
+	return l
+}
+
+// HandleIQ implements mux.IQHandler.
+// It should generally be registered on a mux using Handle.
+func (m *Manager) HandleIQ(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	j := jingle{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&j)
+	if err == nil {
+		if j.Action == SessionInitiate {
+			err = m.initiated(iq, j)
+		} else {
+			sess := m.session(iq.From, j.SID)
+			if sess == nil {
+				err = errUnknownSession
+			} else {
+				err = sess.handle(j)
+			}
+		}
+	}
+	if err != nil {
+		var condErr condError
+		stanzaErr := stanza.Error{}
+		var payload xml.TokenReader
+		switch {
+		case errors.As(err, &condErr):
+			payload = condErr.TokenReader()
+		case errors.As(err, &stanzaErr):
+			payload = stanzaErr.TokenReader()
+		default:
+			payload = errBadRequest.TokenReader()
+		}
+		iq.Type = stanza.ErrorIQ
+		iq.From, iq.To = iq.To, iq.From
+		_, err = xmlstream.Copy(r, iq.Wrap(payload))
+		return err
+	}
+	_, err = xmlstream.Copy(r, iq.Result(nil))
+	return err
+}
+
+// initiated creates a session that was initiated by the peer and passes it to
+// the listener once the session-initiate has been acknowledged.
+func (m *Manager) initiated(iq stanza.IQ, j jingle) error {
+	if j.SID == "" || len(j.Contents) == 0 {
+		return errBadRequest
+	}
+	l := m.listener(iq.To)
+	if l == nil {
+		return stanza.Error{Type: stanza.Cancel, Condition: stanza.ServiceUnavailable}
+	}
+	sess := newSession(m, l.s, iq.From, j.SID, Responder)
+	sess.initiator = j.Initiator
+	if sess.initiator.Equal(jid.JID{}) {
+		sess.initiator = iq.From
+	}
+	if !m.add(sess) {
+		return errTieBreak
+	}
+
+	var unsupported error
+	for _, c := range j.Contents {
+		newContent, err := sess.answer(c)
+		if err != nil {
+			unsupported = err
+			break
+		}
+		sess.addContent(newContent)
+	}
+	if unsupported != nil {
+		r, _ := unsupported.(Reason)
+		go func() {
+			/* #nosec */
+			sess.Terminate(context.Background(), r)
+		}()
+		return nil
+	}
+
+	go func() {
+		select {
+		case l.c <- sess:
+		case <-sess.Done():
+		case <-l.closed:
+			/* #nosec */
+			sess.Terminate(context.Background(), Reason{Condition: Decline})
+		}
+	}()
+	return nil
+}
+
+// Listener accepts sessions initiated by peers.
+type Listener struct {
+	s      *xmpp.Session
+	m      *Manager
+	c      chan *Session
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Accept waits for the next session to be initiated by a peer.
+// The session is pending until it is accepted or terminated.
+// If the listener is closed pending Accept calls unblock and return an error.
+func (l *Listener) Accept() (*Session, error) {
+	select {
+	case sess := <-l.c:
+		return sess, nil
+	case <-l.closed:
+		return nil, errors.New("jingle: accept on closed listener")
+	}
+}
+
+// Close stops listening and causes any pending Accept calls to unblock and
+// return an error.
+// Sessions that have not yet been accepted from the listener are declined.
+func (l *Listener) Close() error {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	addr := l.s.LocalAddr().String()
+	if l.m.listeners[addr] == l {
+		delete(l.m.listeners, addr)
+	}
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr returns the local address for which this listener is accepting
+// sessions.
+func (l *Listener) Addr() net.Addr {
+	return l.s.LocalAddr()
+}
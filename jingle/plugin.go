@@ -0,0 +1,81 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle
+
+import (
+	"context"
+	"net"
+
+	"mellium.im/xmlstream"
+)
+
+// Application is a plugin that implements an application format, the "what"
+// of a session.
+//
+// Sessions that are initiated by the peer are accepted from a Listener, but
+// content that is added to an existing session and informational payloads are
+// passed to the application that understands the description of the content.
+type Application interface {
+	// Namespace returns the namespace of the description element of contents
+	// that use the application.
+	Namespace() string
+
+	// HandleContent is called in its own goroutine when the peer adds content
+	// that uses the application to an existing session.
+	// The application should accept or reject the content.
+	HandleContent(s *Session, c Content)
+
+	// HandleInfo is called when the peer sends a session-info or
+	// description-info action with a payload in the namespace of the
+	// application.
+	// It is called from the goroutine that handles incoming stanzas and must
+	// not block on sending other actions.
+	// If an error is returned it is sent to the peer, ErrUnsupportedInfo should
+	// be returned for payloads that the application does not understand.
+	HandleInfo(s *Session, a Action, info Element) error
+}
+
+// Transport is a plugin that implements a transport method, the "how" of a
+// session.
+type Transport interface {
+	// Namespace returns the namespace of the transport element.
+	Namespace() string
+
+	// Offer starts negotiating the transport for content that is being offered
+	// by the local entity.
+	Offer(s *Session, content string) (Negotiation, error)
+
+	// Accept starts negotiating the transport for content that is being offered
+	// by the peer.
+	Accept(s *Session, content string, offer Element) (Negotiation, error)
+}
+
+// Negotiation is the state of a transport for a single content.
+//
+// The transport element that is sent to the peer is the element returned by
+// TokenReader.
+type Negotiation interface {
+	xmlstream.Marshaler
+
+	// Accepted is called with the transport element of the peer when it
+	// accepts content that was offered by the local entity.
+	Accepted(answer Element) error
+
+	// HandleInfo is called when the peer sends a transport-info action for the
+	// content.
+	// It is called from the goroutine that handles incoming stanzas and must
+	// not block on sending other actions.
+	HandleInfo(info Element) error
+
+	// Connect establishes the stream once the content has been accepted.
+	// It is called by both parties.
+	Connect(ctx context.Context) (net.Conn, error)
+
+	// Close releases any resources held by the negotiation.
+	// It is called when the content is removed, its transport is replaced, or
+	// the session ends.
+	// Streams that were already returned from Connect are not closed.
+	Close() error
+}
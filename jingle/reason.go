@@ -0,0 +1,154 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Condition is the machine readable reason for an action such as terminating
+// a session or rejecting a content.
+type Condition string
+
+// A list of reason conditions.
+const (
+	AlternativeSession      Condition = "alternative-session"
+	Busy                    Condition = "busy"
+	Cancel                  Condition = "cancel"
+	ConnectivityError       Condition = "connectivity-error"
+	Decline                 Condition = "decline"
+	Expired                 Condition = "expired"
+	FailedApplication       Condition = "failed-application"
+	FailedTransport         Condition = "failed-transport"
+	GeneralError            Condition = "general-error"
+	Gone                    Condition = "gone"
+	IncompatibleParameters  Condition = "incompatible-parameters"
+	MediaError              Condition = "media-error"
+	SecurityError           Condition = "security-error"
+	Success                 Condition = "success"
+	Timeout                 Condition = "timeout"
+	UnsupportedApplications Condition = "unsupported-applications"
+	UnsupportedTransports   Condition = "unsupported-transports"
+)
+
+// Reason is the reason element included in some actions, most notably when a
+// session is terminated.
+// If a session ended for any reason other than Success, its Reason is returned
+// as an error.
+type Reason struct {
+	Condition Condition
+	Text      string
+
+	// SID is the ID of the session that should be used instead when the
+	// condition is AlternativeSession.
+	SID string
+}
+
+// Error satisfies the error interface.
+func (r Reason) Error() string {
+	s := "jingle: " + string(r.Condition)
+	if r.Text != "" {
+		s += ": " + r.Text
+	}
+	return s
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (r Reason) TokenReader() xml.TokenReader {
+	var cond xml.TokenReader
+	if r.Condition == AlternativeSession && r.SID != "" {
+		cond = xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(r.SID)),
+			xml.StartElement{Name: xml.Name{Local: "sid"}},
+		)
+	}
+	inner := []xml.TokenReader{
+		xmlstream.Wrap(cond, xml.StartElement{Name: xml.Name{Local: string(r.Condition)}}),
+	}
+	if r.Text != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(r.Text)),
+			xml.StartElement{Name: xml.Name{Local: "text"}},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "reason"}},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (r Reason) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML satisfies the xml.Marshaler interface.
+func (r Reason) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML satisfies the xml.Unmarshaler interface.
+// Application specific elements in the reason are ignored.
+func (r *Reason) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		Text  string `xml:"text"`
+		Conds []struct {
+			XMLName xml.Name
+			SID     string `xml:"sid"`
+		} `xml:",any"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	r.Text = s.Text
+	for _, cond := range s.Conds {
+		if cond.XMLName.Space == NS {
+			r.Condition = Condition(cond.XMLName.Local)
+			r.SID = cond.SID
+			break
+		}
+	}
+	return nil
+}
+
+// condError is a stanza error with an additional Jingle specific condition.
+type condError struct {
+	err  stanza.Error
+	cond string
+}
+
+func (e condError) Error() string {
+	return e.err.Error()
+}
+
+func (e condError) Unwrap() error {
+	return e.err
+}
+
+func (e condError) TokenReader() xml.TokenReader {
+	return e.err.Wrap(xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSErrors, Local: e.cond},
+	}))
+}
+
+var (
+	errOutOfOrder     = condError{err: stanza.Error{Type: stanza.Wait, Condition: stanza.UnexpectedRequest}, cond: "out-of-order"}
+	errTieBreak       = condError{err: stanza.Error{Type: stanza.Cancel, Condition: stanza.Conflict}, cond: "tie-break"}
+	errUnknownSession = condError{err: stanza.Error{Type: stanza.Cancel, Condition: stanza.ItemNotFound}, cond: "unknown-session"}
+	errBadRequest     = stanza.Error{Type: stanza.Cancel, Condition: stanza.BadRequest}
+)
+
+// ErrUnsupportedInfo may be returned by plugins that are passed an
+// informational payload that they do not understand.
+var ErrUnsupportedInfo error = condError{err: stanza.Error{Type: stanza.Modify, Condition: stanza.FeatureNotImplemented}, cond: "unsupported-info"}
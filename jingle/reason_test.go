@@ -0,0 +1,41 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle_test
+
+import (
+	"testing"
+
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jingle"
+)
+
+func TestEncodeReason(t *testing.T) {
+	xmpptest.RunEncodingTests(t, []xmpptest.EncodingTestCase{
+		0: {
+			Value: &jingle.Reason{Condition: jingle.Success},
+			XML:   `<reason xmlns="urn:xmpp:jingle:1"><success></success></reason>`,
+		},
+		1: {
+			Value: &jingle.Reason{Condition: jingle.Decline, Text: "No thanks"},
+			XML:   `<reason xmlns="urn:xmpp:jingle:1"><decline></decline><text>No thanks</text></reason>`,
+		},
+		2: {
+			Value: &jingle.Reason{Condition: jingle.AlternativeSession, SID: "b84tkkwlmb48kgfb"},
+			XML:   `<reason xmlns="urn:xmpp:jingle:1"><alternative-session><sid>b84tkkwlmb48kgfb</sid></alternative-session></reason>`,
+		},
+		3: {
+			Value:     &jingle.Reason{Condition: jingle.MediaError},
+			XML:       `<reason xmlns="urn:xmpp:jingle:1"><media-error/><unsupported xmlns="urn:example:app"/></reason>`,
+			NoMarshal: true,
+		},
+	})
+}
+
+func TestReasonError(t *testing.T) {
+	err := jingle.Reason{Condition: jingle.Busy, Text: "in a meeting"}
+	if s := err.Error(); s != "jingle: busy: in a meeting" {
+		t.Errorf("wrong error string: %q", s)
+	}
+}
@@ -0,0 +1,793 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -type=State
+
+package jingle
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// State is the state of a session.
+type State uint8
+
+// A list of session states.
+const (
+	// Pending sessions have been initiated but not yet accepted.
+	Pending State = iota
+
+	// Active sessions have been accepted by the responder.
+	Active
+
+	// Ended sessions have been terminated by either party.
+	Ended
+)
+
+var (
+	errNotPending  = errors.New("jingle: session is not pending")
+	errNoContent   = errors.New("jingle: no such content")
+	errNoTransport = errors.New("jingle: no transports configured")
+)
+
+// content is the state of a single content in a session.
+type content struct {
+	Content
+
+	// pending is true until the content has been accepted.
+	pending bool
+	neg     Negotiation
+	ns      string
+
+	// replacing is the new transport of the content while a transport-replace
+	// sent by the local entity is waiting to be accepted.
+	replacing   Negotiation
+	replacingNS string
+}
+
+// Session is a Jingle session with a peer.
+//
+// Sessions are created by initiating them with a Manager or by accepting them
+// from a Listener.
+type Session struct {
+	m         *Manager
+	s         *xmpp.Session
+	sid       string
+	peer      jid.JID
+	role      Creator
+	initiator jid.JID
+
+	mu        sync.Mutex
+	responder jid.JID
+	state     State
+	reason    Reason
+	contents  map[string]*content
+	order     []string
+	changed   chan struct{}
+	done      chan struct{}
+}
+
+func newSession(m *Manager, s *xmpp.Session, peer jid.JID, sid string, role Creator) *Session {
+	return &Session{
+		m:        m,
+		s:        s,
+		sid:      sid,
+		peer:     peer,
+		role:     role,
+		contents: make(map[string]*content),
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// SID returns the session ID.
+func (s *Session) SID() string {
+	return s.sid
+}
+
+// Peer returns the address of the other party in the session.
+func (s *Session) Peer() jid.JID {
+	return s.peer
+}
+
+// Role returns whether the local entity is the initiator or responder of the
+// session.
+func (s *Session) Role() Creator {
+	return s.role
+}
+
+// Initiator returns the address of the entity that initiated the session.
+func (s *Session) Initiator() jid.JID {
+	return s.initiator
+}
+
+// Responder returns the address of the entity that responded to the session.
+// It may be the zero value until the session has been accepted.
+func (s *Session) Responder() jid.JID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.responder
+}
+
+// XMPPSession returns the XMPP session over which the session is negotiated.
+// It is provided for the use of plugins.
+func (s *Session) XMPPSession() *xmpp.Session {
+	return s.s
+}
+
+// State returns the current state of the session.
+func (s *Session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Contents returns the contents of the session in the order they were added.
+// The transport of each content is its current Negotiation.
+func (s *Session) Contents() []Content {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.contentsLocked()
+}
+
+func (s *Session) contentsLocked() []Content {
+	contents := make([]Content, 0, len(s.order))
+	for _, name := range s.order {
+		contents = append(contents, s.contents[name].Content)
+	}
+	return contents
+}
+
+// Done returns a channel that is closed when the session ends.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason that the session ended, or nil if the session has not
+// ended or ended successfully.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != Ended || s.reason.Condition == Success {
+		return nil
+	}
+	return s.reason
+}
+
+// notify wakes any goroutines waiting for the state of the session to change.
+// It must be called with the lock held.
+func (s *Session) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// end marks the session as ended and releases the transports of all contents.
+func (s *Session) end(r Reason) {
+	s.mu.Lock()
+	if s.state == Ended {
+		s.mu.Unlock()
+		return
+	}
+	s.state = Ended
+	s.reason = r
+	for _, c := range s.contents {
+		closeContent(c)
+	}
+	close(s.done)
+	s.notify()
+	s.mu.Unlock()
+	s.m.remove(s)
+}
+
+func closeContent(c *content) {
+	/* #nosec */
+	c.neg.Close()
+	if c.replacing != nil {
+		/* #nosec */
+		c.replacing.Close()
+	}
+}
+
+func (s *Session) send(ctx context.Context, j jingle) error {
+	j.SID = s.sid
+	return s.s.UnmarshalIQElement(ctx, j.TokenReader(), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   s.peer,
+	}, nil)
+}
+
+// offer creates a content that is being offered by the local entity using the
+// most preferred transport.
+func (s *Session) offer(c Content) (*content, error) {
+	if len(s.m.Transports) == 0 {
+		return nil, errNoTransport
+	}
+	t := s.m.Transports[0]
+	neg, err := t.Offer(s, c.Name)
+	if err != nil {
+		return nil, err
+	}
+	c.Creator = s.role
+	c.Transport = neg
+	return &content{Content: c, pending: true, neg: neg, ns: t.Namespace()}, nil
+}
+
+// answer creates a content that is being offered by the peer.
+// If the content cannot be used, the reason is returned as an error.
+func (s *Session) answer(c Content) (*content, error) {
+	desc, ok := c.Description.(Element)
+	if !ok || s.m.application(desc.XMLName.Space) == nil {
+		return nil, Reason{Condition: UnsupportedApplications}
+	}
+	offer, ok := c.Transport.(Element)
+	if !ok {
+		return nil, Reason{Condition: UnsupportedTransports}
+	}
+	t := s.m.transport(offer.XMLName.Space)
+	if t == nil {
+		return nil, Reason{Condition: UnsupportedTransports}
+	}
+	neg, err := t.Accept(s, c.Name, offer)
+	if err != nil {
+		return nil, Reason{Condition: FailedTransport, Text: err.Error()}
+	}
+	c.Transport = neg
+	return &content{Content: c, pending: true, neg: neg, ns: t.Namespace()}, nil
+}
+
+func (s *Session) addContent(c *content) {
+	s.contents[c.Name] = c
+	s.order = append(s.order, c.Name)
+}
+
+func (s *Session) removeContent(name string) *content {
+	c, ok := s.contents[name]
+	if !ok {
+		return nil
+	}
+	delete(s.contents, name)
+	for i, n := range s.order {
+		if n == name {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	closeContent(c)
+	return c
+}
+
+// describe replaces the description of a pending content that was offered by
+// the peer before it is accepted.
+func (s *Session) describe(name string, d xmlstream.Marshaler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.contents[name]
+	if !ok || !c.pending || c.Creator == s.role {
+		return errNoContent
+	}
+	c.Description = d
+	return nil
+}
+
+// Accept accepts a session that was initiated by the peer.
+// All contents that are still pending are accepted along with the session.
+func (s *Session) Accept(ctx context.Context) error {
+	s.mu.Lock()
+	if s.role != Responder || s.state != Pending {
+		s.mu.Unlock()
+		return errNotPending
+	}
+	s.state = Active
+	s.responder = s.s.LocalAddr()
+	for _, c := range s.contents {
+		c.pending = false
+	}
+	responder := s.responder
+	contents := s.contentsLocked()
+	s.notify()
+	s.mu.Unlock()
+
+	return s.send(ctx, jingle{
+		Action:    SessionAccept,
+		Responder: responder,
+		Contents:  contents,
+	})
+}
+
+// Terminate ends the session.
+// The session is ended even if an error is returned while informing the peer.
+func (s *Session) Terminate(ctx context.Context, r Reason) error {
+	if s.State() == Ended {
+		return nil
+	}
+	s.end(r)
+	return s.send(ctx, jingle{
+		Action: SessionTerminate,
+		Reason: &r,
+	})
+}
+
+// Info sends a session-info action with the provided payload.
+// If payload is nil the action acts as a ping.
+func (s *Session) Info(ctx context.Context, payload xml.TokenReader) error {
+	return s.send(ctx, jingle{
+		Action:  SessionInfo,
+		payload: payload,
+	})
+}
+
+// TransportInfo sends a transport-info action for the named content.
+// It is meant to be used by Negotiations to exchange transport specific
+// information such as candidates.
+func (s *Session) TransportInfo(ctx context.Context, name string, payload xml.TokenReader) error {
+	s.mu.Lock()
+	c, ok := s.contents[name]
+	s.mu.Unlock()
+	if !ok {
+		return errNoContent
+	}
+	return s.send(ctx, jingle{
+		Action: TransportInfo,
+		Contents: []Content{{
+			Creator:   c.Creator,
+			Name:      c.Name,
+			Transport: tokenReader{payload},
+		}},
+	})
+}
+
+// AddContent adds content to the session using the most preferred transport.
+// The creator and transport of c are ignored.
+// The content cannot be used until the peer has accepted it.
+func (s *Session) AddContent(ctx context.Context, c Content) error {
+	newContent, err := s.offer(c)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.state == Ended {
+		s.mu.Unlock()
+		/* #nosec */
+		newContent.neg.Close()
+		return s.reason
+	}
+	if _, ok := s.contents[c.Name]; ok {
+		s.mu.Unlock()
+		/* #nosec */
+		newContent.neg.Close()
+		return errors.New("jingle: content already exists")
+	}
+	s.addContent(newContent)
+	s.mu.Unlock()
+
+	err = s.send(ctx, jingle{
+		Action:   ContentAdd,
+		Contents: []Content{newContent.Content},
+	})
+	if err != nil {
+		s.mu.Lock()
+		if s.contents[c.Name] == newContent {
+			s.removeContent(c.Name)
+			s.notify()
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// AcceptContent accepts content that was added to the session by the peer.
+func (s *Session) AcceptContent(ctx context.Context, name string) error {
+	s.mu.Lock()
+	c, ok := s.contents[name]
+	if !ok || !c.pending || c.Creator == s.role {
+		s.mu.Unlock()
+		return errNoContent
+	}
+	c.pending = false
+	accepted := c.Content
+	s.notify()
+	s.mu.Unlock()
+
+	return s.send(ctx, jingle{
+		Action:   ContentAccept,
+		Contents: []Content{accepted},
+	})
+}
+
+// RejectContent rejects content that was added to the session by the peer.
+func (s *Session) RejectContent(ctx context.Context, name string, r Reason) error {
+	return s.dropContent(ctx, ContentReject, name, r)
+}
+
+// RemoveContent removes content from the session.
+func (s *Session) RemoveContent(ctx context.Context, name string, r Reason) error {
+	return s.dropContent(ctx, ContentRemove, name, r)
+}
+
+func (s *Session) dropContent(ctx context.Context, action Action, name string, r Reason) error {
+	s.mu.Lock()
+	c := s.removeContent(name)
+	if c != nil {
+		s.notify()
+	}
+	s.mu.Unlock()
+	if c == nil {
+		return errNoContent
+	}
+	return s.send(ctx, jingle{
+		Action: action,
+		Contents: []Content{{
+			Creator: c.Creator,
+			Name:    c.Name,
+		}},
+		Reason: &r,
+	})
+}
+
+// ReplaceTransport asks the peer to use a different transport for the named
+// content, for example because the original transport failed to connect.
+// The new transport is not used until the peer accepts it.
+func (s *Session) ReplaceTransport(ctx context.Context, name string, t Transport) error {
+	neg, err := t.Offer(s, name)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	c, ok := s.contents[name]
+	if !ok {
+		s.mu.Unlock()
+		/* #nosec */
+		neg.Close()
+		return errNoContent
+	}
+	if c.replacing != nil {
+		/* #nosec */
+		c.replacing.Close()
+	}
+	c.replacing = neg
+	c.replacingNS = t.Namespace()
+	replace := Content{
+		Creator:   c.Creator,
+		Name:      c.Name,
+		Transport: neg,
+	}
+	s.notify()
+	s.mu.Unlock()
+
+	err = s.send(ctx, jingle{
+		Action:   TransportReplace,
+		Contents: []Content{replace},
+	})
+	if err != nil {
+		s.mu.Lock()
+		if c.replacing == neg {
+			/* #nosec */
+			neg.Close()
+			c.replacing = nil
+			s.notify()
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Conn waits for the named content to be accepted and then establishes a
+// stream using its transport.
+// Conn should only be called once for each transport that is used by a
+// content.
+//
+// If Fallback is set on the Manager and the transport fails to connect, the
+// initiator of the session replaces it with the next transport that has not
+// yet been tried in order of preference and the responder waits for it to do
+// so.
+// If there are no more transports to try the error from the last transport is
+// returned.
+// In this case Conn should only be called once for each content.
+func (s *Session) Conn(ctx context.Context, name string) (net.Conn, error) {
+	var (
+		failed  Negotiation
+		connErr error
+		tried   = make(map[string]struct{})
+	)
+	for {
+		s.mu.Lock()
+		if s.state == Ended {
+			s.mu.Unlock()
+			return nil, s.reason
+		}
+		c, ok := s.contents[name]
+		if !ok {
+			s.mu.Unlock()
+			return nil, errNoContent
+		}
+		if s.state == Active && !c.pending && c.replacing == nil {
+			neg := c.neg
+			if !s.m.Fallback {
+				s.mu.Unlock()
+				return neg.Connect(ctx)
+			}
+			if neg != failed {
+				tried[c.ns] = struct{}{}
+				s.mu.Unlock()
+				conn, err := neg.Connect(ctx)
+				if err == nil {
+					return conn, nil
+				}
+				failed, connErr = neg, err
+				continue
+			}
+			if s.role == Initiator {
+				t := s.fallback(tried)
+				s.mu.Unlock()
+				if t == nil {
+					return nil, connErr
+				}
+				tried[t.Namespace()] = struct{}{}
+				err := s.ReplaceTransport(ctx, name, t)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// fallback returns the most preferred transport that has not been tried.
+func (s *Session) fallback(tried map[string]struct{}) Transport {
+	for _, t := range s.m.Transports {
+		if _, ok := tried[t.Namespace()]; !ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// handle updates the session in response to an action from the peer.
+// It is called from the goroutine that handles incoming stanzas and any
+// actions that must be sent in response are sent from new goroutines.
+func (s *Session) handle(j jingle) error {
+	// Actions that are passed to plugins or that end the session are handled
+	// without holding the lock.
+	switch j.Action {
+	case SessionTerminate:
+		r := Reason{Condition: Success}
+		if j.Reason != nil {
+			r = *j.Reason
+		}
+		s.end(r)
+		return nil
+	case SessionInfo:
+		// An empty session-info is a ping.
+		if j.Info == nil {
+			return nil
+		}
+		app := s.m.application(j.Info.XMLName.Space)
+		if app == nil {
+			return ErrUnsupportedInfo
+		}
+		return app.HandleInfo(s, SessionInfo, *j.Info)
+	case DescriptionInfo:
+		if len(j.Contents) == 0 {
+			return errBadRequest
+		}
+		desc, ok := j.Contents[0].Description.(Element)
+		if !ok {
+			return errBadRequest
+		}
+		app := s.m.application(desc.XMLName.Space)
+		if app == nil {
+			return ErrUnsupportedInfo
+		}
+		return app.HandleInfo(s, DescriptionInfo, desc)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == Ended {
+		return errUnknownSession
+	}
+
+	switch j.Action {
+	case SessionAccept:
+		if s.role != Initiator || s.state != Pending {
+			return errOutOfOrder
+		}
+		for _, accepted := range j.Contents {
+			c, ok := s.contents[accepted.Name]
+			if !ok {
+				continue
+			}
+			// The responder may have refined the description.
+			if accepted.Description != nil {
+				c.Description = accepted.Description
+			}
+			if answer, ok := accepted.Transport.(Element); ok {
+				err := c.neg.Accepted(answer)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, c := range s.contents {
+			c.pending = false
+		}
+		s.responder = j.Responder
+		if s.responder.Equal(jid.JID{}) {
+			s.responder = s.peer
+		}
+		s.state = Active
+	case ContentAdd:
+		for _, added := range j.Contents {
+			if _, ok := s.contents[added.Name]; ok {
+				return errBadRequest
+			}
+		}
+		for _, added := range j.Contents {
+			c, err := s.answer(added)
+			if err != nil {
+				r, _ := err.(Reason)
+				reject := Content{Creator: added.Creator, Name: added.Name}
+				go func() {
+					/* #nosec */
+					s.send(context.Background(), jingle{
+						Action:   ContentReject,
+						Contents: []Content{reject},
+						Reason:   &r,
+					})
+				}()
+				continue
+			}
+			s.addContent(c)
+			desc := c.Description.(Element)
+			go s.m.application(desc.XMLName.Space).HandleContent(s, c.Content)
+		}
+	case ContentAccept:
+		for _, accepted := range j.Contents {
+			c, ok := s.contents[accepted.Name]
+			if !ok || !c.pending || c.Creator != s.role {
+				return errOutOfOrder
+			}
+			if answer, ok := accepted.Transport.(Element); ok {
+				err := c.neg.Accepted(answer)
+				if err != nil {
+					return err
+				}
+			}
+			c.pending = false
+		}
+	case ContentReject, ContentRemove:
+		for _, removed := range j.Contents {
+			s.removeContent(removed.Name)
+		}
+		if j.Action == ContentRemove && len(s.contents) == 0 {
+			// A session without any contents is void, so end it.
+			r := Reason{Condition: Success}
+			if j.Reason != nil {
+				r = *j.Reason
+			}
+			go func() {
+				/* #nosec */
+				s.Terminate(context.Background(), r)
+			}()
+		}
+	case ContentModify:
+		for _, modified := range j.Contents {
+			c, ok := s.contents[modified.Name]
+			if !ok {
+				return errBadRequest
+			}
+			c.Senders = modified.Senders
+		}
+	case TransportReplace:
+		for _, replaced := range j.Contents {
+			c, ok := s.contents[replaced.Name]
+			if !ok {
+				return errBadRequest
+			}
+			offer, _ := replaced.Transport.(Element)
+			var (
+				neg Negotiation
+				err error
+			)
+			t := s.m.transport(offer.XMLName.Space)
+			if t != nil {
+				neg, err = t.Accept(s, c.Name, offer)
+			}
+			if neg == nil || err != nil {
+				reject := replaced
+				go func() {
+					/* #nosec */
+					s.send(context.Background(), jingle{
+						Action:   TransportReject,
+						Contents: []Content{reject},
+					})
+				}()
+				continue
+			}
+			/* #nosec */
+			c.neg.Close()
+			c.neg = neg
+			c.ns = t.Namespace()
+			c.Transport = neg
+			accept := Content{Creator: c.Creator, Name: c.Name, Transport: neg}
+			go func() {
+				/* #nosec */
+				s.send(context.Background(), jingle{
+					Action:   TransportAccept,
+					Contents: []Content{accept},
+				})
+			}()
+		}
+	case TransportAccept:
+		for _, accepted := range j.Contents {
+			c, ok := s.contents[accepted.Name]
+			if !ok || c.replacing == nil {
+				return errOutOfOrder
+			}
+			/* #nosec */
+			c.neg.Close()
+			c.neg, c.replacing = c.replacing, nil
+			c.ns = c.replacingNS
+			c.Transport = c.neg
+			if answer, ok := accepted.Transport.(Element); ok {
+				err := c.neg.Accepted(answer)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case TransportReject:
+		for _, rejected := range j.Contents {
+			c, ok := s.contents[rejected.Name]
+			if !ok || c.replacing == nil {
+				return errOutOfOrder
+			}
+			/* #nosec */
+			c.replacing.Close()
+			c.replacing = nil
+		}
+	case TransportInfo:
+		for _, info := range j.Contents {
+			c, ok := s.contents[info.Name]
+			if !ok {
+				return errBadRequest
+			}
+			payload, ok := info.Transport.(Element)
+			if !ok {
+				return errBadRequest
+			}
+			neg := c.neg
+			if c.replacing != nil {
+				neg = c.replacing
+			}
+			err := neg.HandleInfo(payload)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrUnsupportedInfo
+	}
+	s.notify()
+	return nil
+}
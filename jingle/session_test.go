@@ -0,0 +1,412 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/ibb"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/jingle"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+const nsApp = "urn:example:app"
+
+// desc is the description of the test application.
+type desc struct {
+	XMLName xml.Name `xml:"urn:example:app description"`
+	Name    string   `xml:"name,attr"`
+}
+
+func (d desc) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: nsApp, Local: "description"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: d.Name}},
+	})
+}
+
+// app accepts any content that is added and records informational payloads.
+type app struct {
+	info chan xml.Name
+}
+
+func (app) Namespace() string {
+	return nsApp
+}
+
+func (app) HandleContent(s *jingle.Session, c jingle.Content) {
+	/* #nosec */
+	s.AcceptContent(context.Background(), c.Name)
+}
+
+func (a app) HandleInfo(_ *jingle.Session, _ jingle.Action, info jingle.Element) error {
+	if info.XMLName.Local != "ringing" {
+		return jingle.ErrUnsupportedInfo
+	}
+	a.info <- info.XMLName
+	return nil
+}
+
+// brokenTransport is a transport that never manages to connect.
+type brokenTransport struct{}
+
+func (brokenTransport) Namespace() string {
+	return "urn:example:transport"
+}
+
+func (t brokenTransport) Offer(*jingle.Session, string) (jingle.Negotiation, error) {
+	return t, nil
+}
+
+func (t brokenTransport) Accept(*jingle.Session, string, jingle.Element) (jingle.Negotiation, error) {
+	return t, nil
+}
+
+func (brokenTransport) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: "urn:example:transport", Local: "transport"},
+	})
+}
+
+func (brokenTransport) Accepted(jingle.Element) error   { return nil }
+func (brokenTransport) HandleInfo(jingle.Element) error { return nil }
+func (brokenTransport) Close() error                    { return nil }
+
+func (brokenTransport) Connect(context.Context) (net.Conn, error) {
+	return nil, errors.New("connection refused")
+}
+
+// stamp sets the from attribute on stanzas like a server would do.
+func stamp(from jid.JID, h xmpp.Handler) xmpp.Handler {
+	return xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "from"}, Value: from.String()})
+		return h.HandleXMPP(t, start)
+	})
+}
+
+type peers struct {
+	cs         *xmpptest.ClientServer
+	client     *jingle.Manager
+	server     *jingle.Manager
+	clientFT   *jingle.FileTransfer
+	serverFT   *jingle.FileTransfer
+	serverInfo chan xml.Name
+}
+
+func newPeers(clientTransports, serverTransports func(*ibb.Handler) []jingle.Transport) peers {
+	clientIBB := &ibb.Handler{}
+	serverIBB := &ibb.Handler{}
+	p := peers{
+		clientFT:   &jingle.FileTransfer{},
+		serverFT:   &jingle.FileTransfer{},
+		serverInfo: make(chan xml.Name, 1),
+	}
+	p.client = &jingle.Manager{
+		Applications: []jingle.Application{app{info: make(chan xml.Name, 1)}, p.clientFT},
+		Transports:   clientTransports(clientIBB),
+	}
+	p.server = &jingle.Manager{
+		Applications: []jingle.Application{app{info: p.serverInfo}, p.serverFT},
+		Transports:   serverTransports(serverIBB),
+	}
+	clientAddr := jid.MustParse("test@example.net")
+	serverAddr := jid.MustParse("example.net")
+	p.cs = xmpptest.NewClientServer(
+		xmpptest.ClientHandler(stamp(serverAddr, mux.New("", jingle.Handle(p.client), ibb.Handle(clientIBB)))),
+		xmpptest.ServerHandler(stamp(clientAddr, mux.New(stanza.NSClient, jingle.Handle(p.server), ibb.Handle(serverIBB)))),
+	)
+	return p
+}
+
+func ibbOnly(h *ibb.Handler) []jingle.Transport {
+	return []jingle.Transport{jingle.IBB{Handler: h}}
+}
+
+func TestSession(t *testing.T) {
+	p := newPeers(ibbOnly, ibbOnly)
+	ctx := context.Background()
+	l := p.server.Listen(p.cs.Server)
+
+	const payload = "Not all those who wander are lost."
+	recv := make(chan string, 1)
+	accepted := make(chan *jingle.Session, 1)
+	go func() {
+		sess, err := l.Accept()
+		if err != nil {
+			t.Errorf("error accepting session: %v", err)
+			close(recv)
+			return
+		}
+		accepted <- sess
+		contents := sess.Contents()
+		if len(contents) != 1 || sess.State() != jingle.Pending {
+			t.Errorf("wrong initial session: state=%v, contents=%+v", sess.State(), contents)
+		}
+		d := desc{}
+		err = contents[0].Description.(jingle.Element).Decode(&d)
+		if err != nil || d.Name != "file" {
+			t.Errorf("wrong description: %+v, err=%v", d, err)
+		}
+		err = sess.Accept(ctx)
+		if err != nil {
+			t.Errorf("error accepting session: %v", err)
+		}
+		conn, err := sess.Conn(ctx, "file")
+		if err != nil {
+			t.Errorf("error connecting: %v", err)
+			close(recv)
+			return
+		}
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			t.Errorf("error reading: %v", err)
+		}
+		recv <- string(b)
+	}()
+
+	sess, err := p.client.Initiate(ctx, p.cs.Client, p.cs.Server.LocalAddr(), jingle.Content{
+		Name:        "file",
+		Senders:     jingle.SendInitiator,
+		Description: desc{Name: "file"},
+	})
+	if err != nil {
+		t.Fatalf("error initiating session: %v", err)
+	}
+	conn, err := sess.Conn(ctx, "file")
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	if sess.State() != jingle.Active {
+		t.Errorf("wrong state after accept: want=%v, got=%v", jingle.Active, sess.State())
+	}
+	_, err = io.WriteString(conn, payload)
+	if err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	err = conn.Close()
+	if err != nil {
+		t.Fatalf("error closing conn: %v", err)
+	}
+	if got := <-recv; got != payload {
+		t.Errorf("wrong payload: want=%q, got=%q", payload, got)
+	}
+	serverSess := <-accepted
+
+	// Informational payloads are passed to the application.
+	err = sess.Info(ctx, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsApp, Local: "ringing"}}))
+	if err != nil {
+		t.Errorf("error sending session-info: %v", err)
+	}
+	if name := <-p.serverInfo; name.Local != "ringing" {
+		t.Errorf("wrong info payload: %v", name)
+	}
+	err = sess.Info(ctx, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsApp, Local: "hold"}}))
+	if !errors.Is(err, stanza.Error{Condition: stanza.FeatureNotImplemented}) {
+		t.Errorf("wrong error for unsupported info: %v", err)
+	}
+	err = sess.Info(ctx, nil)
+	if err != nil {
+		t.Errorf("error sending ping: %v", err)
+	}
+
+	// Content added later is handled by the application.
+	err = sess.AddContent(ctx, jingle.Content{Name: "other", Description: desc{Name: "other"}})
+	if err != nil {
+		t.Fatalf("error adding content: %v", err)
+	}
+	otherConn := make(chan net.Conn, 1)
+	go func() {
+		conn, err := serverSess.Conn(ctx, "other")
+		if err != nil {
+			t.Errorf("error connecting to added content: %v", err)
+		}
+		otherConn <- conn
+	}()
+	conn, err = sess.Conn(ctx, "other")
+	if err != nil {
+		t.Fatalf("error connecting to added content: %v", err)
+	}
+	/* #nosec */
+	conn.Close()
+	<-otherConn
+
+	err = sess.Terminate(ctx, jingle.Reason{Condition: jingle.Success})
+	if err != nil {
+		t.Errorf("error terminating session: %v", err)
+	}
+	<-serverSess.Done()
+	if err := serverSess.Err(); err != nil {
+		t.Errorf("unexpected error for successful session: %v", err)
+	}
+	if serverSess.State() != jingle.Ended {
+		t.Errorf("wrong state after terminate: want=%v, got=%v", jingle.Ended, serverSess.State())
+	}
+	err = sess.Info(ctx, nil)
+	if !errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+		t.Errorf("wrong error for ended session: %v", err)
+	}
+}
+
+func TestTransportReplace(t *testing.T) {
+	both := func(h *ibb.Handler) []jingle.Transport {
+		return []jingle.Transport{brokenTransport{}, jingle.IBB{Handler: h}}
+	}
+	p := newPeers(both, both)
+	ctx := context.Background()
+	l := p.server.Listen(p.cs.Server)
+
+	recv := make(chan string, 1)
+	replaced := make(chan struct{})
+	go func() {
+		sess, err := l.Accept()
+		if err != nil {
+			t.Errorf("error accepting session: %v", err)
+			close(recv)
+			return
+		}
+		err = sess.Accept(ctx)
+		if err != nil {
+			t.Errorf("error accepting session: %v", err)
+		}
+		_, err = sess.Conn(ctx, "file")
+		if err == nil {
+			t.Errorf("expected first transport to fail")
+		}
+		<-replaced
+		conn, err := sess.Conn(ctx, "file")
+		if err != nil {
+			t.Errorf("error connecting: %v", err)
+			close(recv)
+			return
+		}
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			t.Errorf("error reading: %v", err)
+		}
+		recv <- string(b)
+	}()
+
+	sess, err := p.client.Initiate(ctx, p.cs.Client, p.cs.Server.LocalAddr(), jingle.Content{
+		Name:        "file",
+		Description: desc{Name: "file"},
+	})
+	if err != nil {
+		t.Fatalf("error initiating session: %v", err)
+	}
+	_, err = sess.Conn(ctx, "file")
+	if err == nil {
+		t.Fatalf("expected first transport to fail")
+	}
+	err = sess.ReplaceTransport(ctx, "file", p.client.Transports[1])
+	if err != nil {
+		t.Fatalf("error replacing transport: %v", err)
+	}
+	close(replaced)
+	conn, err := sess.Conn(ctx, "file")
+	if err != nil {
+		t.Fatalf("error connecting with replaced transport: %v", err)
+	}
+	_, err = io.WriteString(conn, "fallback")
+	if err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	err = conn.Close()
+	if err != nil {
+		t.Fatalf("error closing conn: %v", err)
+	}
+	if got := <-recv; got != "fallback" {
+		t.Errorf("wrong payload: want=%q, got=%q", "fallback", got)
+	}
+}
+
+func TestTransportFallback(t *testing.T) {
+	both := func(h *ibb.Handler) []jingle.Transport {
+		return []jingle.Transport{brokenTransport{}, jingle.IBB{Handler: h}}
+	}
+	p := newPeers(both, both)
+	p.client.Fallback = true
+	p.server.Fallback = true
+	ctx := context.Background()
+	l := p.server.Listen(p.cs.Server)
+
+	recv := make(chan string, 1)
+	go func() {
+		sess, err := l.Accept()
+		if err != nil {
+			t.Errorf("error accepting session: %v", err)
+			close(recv)
+			return
+		}
+		err = sess.Accept(ctx)
+		if err != nil {
+			t.Errorf("error accepting session: %v", err)
+		}
+		conn, err := sess.Conn(ctx, "file")
+		if err != nil {
+			t.Errorf("error connecting: %v", err)
+			close(recv)
+			return
+		}
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			t.Errorf("error reading: %v", err)
+		}
+		recv <- string(b)
+	}()
+
+	sess, err := p.client.Initiate(ctx, p.cs.Client, p.cs.Server.LocalAddr(), jingle.Content{
+		Name:        "file",
+		Description: desc{Name: "file"},
+	})
+	if err != nil {
+		t.Fatalf("error initiating session: %v", err)
+	}
+	conn, err := sess.Conn(ctx, "file")
+	if err != nil {
+		t.Fatalf("error connecting with fallback transport: %v", err)
+	}
+	_, err = io.WriteString(conn, "fallback")
+	if err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	err = conn.Close()
+	if err != nil {
+		t.Fatalf("error closing conn: %v", err)
+	}
+	if got := <-recv; got != "fallback" {
+		t.Errorf("wrong payload: want=%q, got=%q", "fallback", got)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	p := newPeers(func(*ibb.Handler) []jingle.Transport {
+		return []jingle.Transport{brokenTransport{}}
+	}, ibbOnly)
+	ctx := context.Background()
+	p.server.Listen(p.cs.Server)
+
+	sess, err := p.client.Initiate(ctx, p.cs.Client, p.cs.Server.LocalAddr(), jingle.Content{
+		Name:        "file",
+		Description: desc{Name: "file"},
+	})
+	if err != nil {
+		t.Fatalf("error initiating session: %v", err)
+	}
+	<-sess.Done()
+	r := jingle.Reason{}
+	if !errors.As(sess.Err(), &r) || r.Condition != jingle.UnsupportedTransports {
+		t.Errorf("wrong reason: want=%v, got=%v", jingle.UnsupportedTransports, sess.Err())
+	}
+}
@@ -0,0 +1,403 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/internal/socks5"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// NSSOCKS5 is the namespace of the SOCKS5 bytestreams transport.
+const NSSOCKS5 = "urn:xmpp:jingle:transports:s5b:1"
+
+// The type preference of direct candidates used to calculate their priority.
+const directPreference = 126
+
+var errNoCandidates = errors.New("jingle: no SOCKS5 candidates could be connected")
+
+// SOCKS5 is a Transport that exchanges data over direct TCP connections using
+// SOCKS5 Bytestreams as defined in XEP-0260: Jingle SOCKS5 Bytestreams
+// Transport Method.
+//
+// Both parties offer candidates that the other party tries to connect to.
+// If neither party can connect to the other, the transport fails and, if
+// Fallback is set on the Manager, the session falls back to the next transport
+// in order of preference, so SOCKS5 should generally be preferred over IBB.
+type SOCKS5 struct {
+	// Addr is the TCP address that is listened on for connections from the peer
+	// and that is offered as a direct candidate, for example "127.0.0.1:0".
+	// If the port is zero a random port is chosen for each negotiation.
+	// If Addr is empty no candidates are offered, but the candidates of the peer
+	// are still tried.
+	Addr string
+
+	// Dialer is used to connect to the candidates of the peer.
+	// If it is nil a zero value net.Dialer is used.
+	Dialer *net.Dialer
+}
+
+// candidate is a streamhost that the party that offered it can be reached on.
+type candidate struct {
+	CID      string  `xml:"cid,attr"`
+	Host     string  `xml:"host,attr"`
+	JID      jid.JID `xml:"jid,attr"`
+	Port     uint16  `xml:"port,attr"`
+	Priority uint32  `xml:"priority,attr"`
+	Type     string  `xml:"type,attr"`
+}
+
+func (c candidate) TokenReader() xml.TokenReader {
+	typ := c.Type
+	if typ == "" {
+		typ = "direct"
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "candidate"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "cid"}, Value: c.CID},
+			{Name: xml.Name{Local: "host"}, Value: c.Host},
+			{Name: xml.Name{Local: "jid"}, Value: c.JID.String()},
+			{Name: xml.Name{Local: "port"}, Value: strconv.FormatUint(uint64(c.Port), 10)},
+			{Name: xml.Name{Local: "priority"}, Value: strconv.FormatUint(uint64(c.Priority), 10)},
+			{Name: xml.Name{Local: "type"}, Value: typ},
+		},
+	})
+}
+
+type socks5Transport struct {
+	XMLName    xml.Name    `xml:"urn:xmpp:jingle:transports:s5b:1 transport"`
+	SID        string      `xml:"sid,attr"`
+	Mode       string      `xml:"mode,attr"`
+	Candidates []candidate `xml:"candidate"`
+	Used       *struct {
+		CID string `xml:"cid,attr"`
+	} `xml:"candidate-used"`
+	Error *struct{} `xml:"candidate-error"`
+}
+
+// Namespace satisfies Transport.
+func (SOCKS5) Namespace() string {
+	return NSSOCKS5
+}
+
+// Offer satisfies Transport.
+func (t SOCKS5) Offer(s *Session, content string) (Negotiation, error) {
+	return t.negotiation(s, content, attr.RandomID())
+}
+
+// Accept satisfies Transport.
+func (t SOCKS5) Accept(s *Session, content string, offer Element) (Negotiation, error) {
+	p := socks5Transport{}
+	err := offer.Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.SID == "":
+		return nil, errors.New("jingle: missing SOCKS5 session ID")
+	case p.Mode != "" && p.Mode != "tcp":
+		return nil, errors.New("jingle: unsupported SOCKS5 mode")
+	}
+	n, err := t.negotiation(s, content, p.SID)
+	if err != nil {
+		return nil, err
+	}
+	n.remote = p.Candidates
+	return n, nil
+}
+
+func (t SOCKS5) negotiation(s *Session, content, sid string) (*socks5Negotiation, error) {
+	n := &socks5Negotiation{
+		dialer:  t.Dialer,
+		s:       s,
+		content: content,
+		sid:     sid,
+		result:  make(chan socks5Transport, 1),
+		inbound: make(chan net.Conn, 1),
+		closed:  make(chan struct{}),
+	}
+	if n.dialer == nil {
+		n.dialer = &net.Dialer{}
+	}
+	if t.Addr == "" {
+		return n, nil
+	}
+	ln, err := net.Listen("tcp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	n.ln = ln
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		/* #nosec */
+		ln.Close()
+		return nil, err
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		/* #nosec */
+		ln.Close()
+		return nil, err
+	}
+	n.local = []candidate{{
+		CID:      attr.RandomID(),
+		Host:     host,
+		JID:      s.XMPPSession().LocalAddr(),
+		Port:     uint16(portNum),
+		Priority: directPreference << 16,
+		Type:     "direct",
+	}}
+	go n.serve()
+	return n, nil
+}
+
+type socks5Negotiation struct {
+	dialer  *net.Dialer
+	s       *Session
+	content string
+	sid     string
+	ln      net.Listener
+	local   []candidate
+
+	// result receives the candidate-used or candidate-error sent by the peer.
+	result chan socks5Transport
+	// inbound receives the first connection from the peer to a local
+	// candidate.
+	inbound chan net.Conn
+	closed  chan struct{}
+	once    sync.Once
+
+	mu     sync.Mutex
+	remote []candidate
+}
+
+// serve accepts connections from the peer to the local candidate.
+func (n *socks5Negotiation) serve() {
+	// The peer is the requester when it connects to candidates offered by the
+	// local entity.
+	dstAddr := socks5.DstAddr(n.sid, n.s.Peer().String(), n.s.XMPPSession().LocalAddr().String())
+	for {
+		conn, err := n.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			addr, err := socks5.ReadRequest(conn)
+			if err != nil {
+				/* #nosec */
+				conn.Close()
+				return
+			}
+			ok := addr == dstAddr
+			err = socks5.WriteReply(conn, addr, ok)
+			if err != nil || !ok {
+				/* #nosec */
+				conn.Close()
+				return
+			}
+			select {
+			case <-n.closed:
+				/* #nosec */
+				conn.Close()
+				return
+			case n.inbound <- conn:
+			default:
+				/* #nosec */
+				conn.Close()
+			}
+		}()
+	}
+}
+
+func (n *socks5Negotiation) TokenReader() xml.TokenReader {
+	candidates := make([]xml.TokenReader, 0, len(n.local))
+	for _, c := range n.local {
+		candidates = append(candidates, c.TokenReader())
+	}
+	return n.wrap(xmlstream.MultiReader(candidates...), xml.Attr{Name: xml.Name{Local: "mode"}, Value: "tcp"})
+}
+
+func (n *socks5Negotiation) wrap(payload xml.TokenReader, attrs ...xml.Attr) xml.TokenReader {
+	return xmlstream.Wrap(payload, xml.StartElement{
+		Name: xml.Name{Space: NSSOCKS5, Local: "transport"},
+		Attr: append([]xml.Attr{{Name: xml.Name{Local: "sid"}, Value: n.sid}}, attrs...),
+	})
+}
+
+func (n *socks5Negotiation) Accepted(answer Element) error {
+	p := socks5Transport{}
+	err := answer.Decode(&p)
+	if err != nil {
+		return err
+	}
+	if p.SID != "" && p.SID != n.sid {
+		return errBadRequest
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.remote = p.Candidates
+	return nil
+}
+
+func (n *socks5Negotiation) HandleInfo(info Element) error {
+	p := socks5Transport{}
+	err := info.Decode(&p)
+	if err != nil {
+		return err
+	}
+	switch {
+	case p.SID != n.sid:
+		return errBadRequest
+	case p.Used != nil:
+		if _, ok := n.localCandidate(p.Used.CID); !ok {
+			return errBadRequest
+		}
+	case p.Error == nil:
+		return ErrUnsupportedInfo
+	}
+	select {
+	case n.result <- p:
+	default:
+		return errOutOfOrder
+	}
+	return nil
+}
+
+func (n *socks5Negotiation) localCandidate(cid string) (candidate, bool) {
+	for _, c := range n.local {
+		if c.CID == cid {
+			return c, true
+		}
+	}
+	return candidate{}, false
+}
+
+// dial tries to connect to the candidates of the peer in order of priority.
+func (n *socks5Negotiation) dial(ctx context.Context) (net.Conn, candidate, error) {
+	n.mu.Lock()
+	remote := append([]candidate(nil), n.remote...)
+	n.mu.Unlock()
+	sort.SliceStable(remote, func(i, j int) bool {
+		return remote[i].Priority > remote[j].Priority
+	})
+
+	// The local entity is the requester when it connects to candidates offered
+	// by the peer.
+	dstAddr := socks5.DstAddr(n.sid, n.s.XMPPSession().LocalAddr().String(), n.s.Peer().String())
+	for _, c := range remote {
+		if c.Type != "" && c.Type != "direct" {
+			continue
+		}
+		addr := net.JoinHostPort(c.Host, strconv.FormatUint(uint64(c.Port), 10))
+		conn, err := n.dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			continue
+		}
+		err = socks5.Connect(conn, dstAddr)
+		if err != nil {
+			/* #nosec */
+			conn.Close()
+			continue
+		}
+		return conn, c, nil
+	}
+	return nil, candidate{}, errNoCandidates
+}
+
+func (n *socks5Negotiation) Connect(ctx context.Context) (net.Conn, error) {
+	out, used, dialErr := n.dial(ctx)
+	payload := xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "candidate-error"}})
+	if dialErr == nil {
+		payload = xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "candidate-used"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "cid"}, Value: used.CID}},
+		})
+	}
+
+	// The initiator reports the candidate it used first and the responder
+	// waits for it before doing the same so that the reports never cross.
+	var (
+		peer socks5Transport
+		err  error
+	)
+	if n.s.Role() == Initiator {
+		err = n.s.TransportInfo(ctx, n.content, n.wrap(payload))
+	}
+	if err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case peer = <-n.result:
+		}
+	}
+	if err == nil && n.s.Role() == Responder {
+		err = n.s.TransportInfo(ctx, n.content, n.wrap(payload))
+	}
+	if err != nil {
+		if out != nil {
+			/* #nosec */
+			out.Close()
+		}
+		return nil, err
+	}
+
+	useInbound := false
+	switch {
+	case peer.Used == nil && dialErr != nil:
+		return nil, errNoCandidates
+	case peer.Used == nil:
+	case dialErr != nil:
+		useInbound = true
+	default:
+		// Both parties connected, the candidate with the highest priority wins
+		// and if they are equal the candidate that the initiator connected to
+		// wins.
+		nominated, _ := n.localCandidate(peer.Used.CID)
+		useInbound = nominated.Priority > used.Priority ||
+			(nominated.Priority == used.Priority && n.s.Role() == Responder)
+	}
+	if !useInbound {
+		return out, nil
+	}
+	if out != nil {
+		/* #nosec */
+		out.Close()
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.closed:
+		return nil, errNoCandidates
+	case conn := <-n.inbound:
+		return conn, nil
+	}
+}
+
+func (n *socks5Negotiation) Close() error {
+	n.once.Do(func() {
+		close(n.closed)
+	})
+	if n.ln == nil {
+		return nil
+	}
+	err := n.ln.Close()
+	select {
+	case conn := <-n.inbound:
+		/* #nosec */
+		conn.Close()
+	default:
+	}
+	return err
+}
@@ -0,0 +1,314 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+const nsRegister = `jabber:iq:register`
+
+// VoiceRequest is a request from a visitor to be granted voice in a moderated
+// channel.
+// It is sent to the moderators of the channel by the channel itself.
+type VoiceRequest struct {
+	// Room is the bare address of the channel.
+	Room jid.JID
+
+	// JID is the real address of the occupant requesting voice, if the channel
+	// shares it with moderators.
+	JID jid.JID
+
+	// Nick is the nickname of the occupant requesting voice.
+	Nick string
+
+	// Form is the original request form, used when approving the request.
+	Form *form.Data
+}
+
+// voiceRequest parses a voice request form sent by the channel at from.
+// Requests are only accepted if they were sent by a channel that we have
+// joined as a moderator and if they are on behalf of a visitor in that channel,
+// which can only exist in moderated channels.
+func (c *Client) voiceRequest(from jid.JID, data *form.Data) (VoiceRequest, bool) {
+	req := VoiceRequest{
+		Room: from.Bare(),
+		Form: data,
+	}
+	if from.Resourcepart() != "" {
+		return req, false
+	}
+	if v, _ := data.Raw("muc#jid"); len(v) > 0 {
+		req.JID, _ = jid.Parse(v[0])
+	}
+	if v, _ := data.Raw("muc#roomnick"); len(v) > 0 {
+		req.Nick = v[0]
+	}
+
+	c.managedM.Lock()
+	channel := c.channel(from)
+	c.managedM.Unlock()
+	if channel == nil {
+		return req, false
+	}
+	me, ok := channel.Occupant(channel.Me().Resourcepart())
+	if !ok || me.Role != RoleModerator {
+		return req, false
+	}
+	occupant, ok := channel.Occupant(req.Nick)
+	return req, ok && occupant.Role == RoleVisitor
+}
+
+func reasonReader(reason string) xml.TokenReader {
+	if reason == "" {
+		return nil
+	}
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(reason)),
+		xml.StartElement{Name: xml.Name{Local: "reason"}},
+	)
+}
+
+// setItems sends an admin query containing the provided items.
+func (c *Channel) setItems(ctx context.Context, items []xml.TokenReader) error {
+	return c.session.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.MultiReader(items...),
+		xml.StartElement{Name: xml.Name{Space: NSAdmin, Local: "query"}},
+	), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   c.addr.Bare(),
+	}, nil)
+}
+
+// getItems requests a list of items with the provided attribute from the
+// channel.
+func (c *Channel) getItems(ctx context.Context, attr xml.Attr) ([]Item, error) {
+	var resp struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/muc#admin query"`
+		Items   []Item   `xml:"item"`
+	}
+	err := c.session.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{attr},
+		}),
+		xml.StartElement{Name: xml.Name{Space: NSAdmin, Local: "query"}},
+	), stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   c.addr.Bare(),
+	}, &resp)
+	return resp.Items, err
+}
+
+// SetAffiliations changes the affiliations of multiple users at once.
+// The JID of each item should be the users real bare JID (not their room JID)
+// and the role is ignored.
+func (c *Channel) SetAffiliations(ctx context.Context, items []Item) error {
+	payload := make([]xml.TokenReader, 0, len(items))
+	for _, item := range items {
+		attr := []xml.Attr{
+			{Name: xml.Name{Local: "affiliation"}, Value: item.Affiliation.String()},
+			{Name: xml.Name{Local: "jid"}, Value: item.JID.Bare().String()},
+		}
+		if item.Nick != "" {
+			attr = append(attr, xml.Attr{Name: xml.Name{Local: "nick"}, Value: item.Nick})
+		}
+		payload = append(payload, xmlstream.Wrap(
+			reasonReader(item.Reason),
+			xml.StartElement{Name: xml.Name{Local: "item"}, Attr: attr},
+		))
+	}
+	return c.setItems(ctx, payload)
+}
+
+// GetAffiliations returns the list of users with the provided affiliation, for
+// example the member list or the ban list (outcasts).
+func (c *Channel) GetAffiliations(ctx context.Context, a Affiliation) ([]Item, error) {
+	return c.getItems(ctx, xml.Attr{Name: xml.Name{Local: "affiliation"}, Value: a.String()})
+}
+
+// SetRole changes the role of the occupant with the provided nickname.
+func (c *Channel) SetRole(ctx context.Context, r Role, nick, reason string) error {
+	return c.SetRoles(ctx, []Item{{Role: r, Nick: nick, Reason: reason}})
+}
+
+// SetRoles changes the roles of multiple occupants at once.
+// Occupants are identified by the nickname of each item and the JID and
+// affiliation are ignored.
+func (c *Channel) SetRoles(ctx context.Context, items []Item) error {
+	payload := make([]xml.TokenReader, 0, len(items))
+	for _, item := range items {
+		payload = append(payload, xmlstream.Wrap(
+			reasonReader(item.Reason),
+			xml.StartElement{Name: xml.Name{Local: "item"}, Attr: []xml.Attr{
+				{Name: xml.Name{Local: "nick"}, Value: item.Nick},
+				{Name: xml.Name{Local: "role"}, Value: item.Role.String()},
+			}},
+		))
+	}
+	return c.setItems(ctx, payload)
+}
+
+// GetRoles returns the list of occupants with the provided role, for example
+// the list of moderators or the voice list (participants).
+func (c *Channel) GetRoles(ctx context.Context, r Role) ([]Item, error) {
+	return c.getItems(ctx, xml.Attr{Name: xml.Name{Local: "role"}, Value: r.String()})
+}
+
+// Kick removes the occupant with the provided nickname from the channel.
+func (c *Channel) Kick(ctx context.Context, nick, reason string) error {
+	return c.SetRole(ctx, RoleNone, nick, reason)
+}
+
+// GrantVoice allows the occupant with the provided nickname to send messages in
+// a moderated channel.
+func (c *Channel) GrantVoice(ctx context.Context, nick, reason string) error {
+	return c.SetRole(ctx, RoleParticipant, nick, reason)
+}
+
+// RevokeVoice prevents the occupant with the provided nickname from sending
+// messages in a moderated channel.
+func (c *Channel) RevokeVoice(ctx context.Context, nick, reason string) error {
+	return c.SetRole(ctx, RoleVisitor, nick, reason)
+}
+
+// GrantModerator makes the occupant with the provided nickname a moderator.
+func (c *Channel) GrantModerator(ctx context.Context, nick, reason string) error {
+	return c.SetRole(ctx, RoleModerator, nick, reason)
+}
+
+// RevokeModerator removes moderator privileges from the occupant with the
+// provided nickname, leaving them as a participant.
+func (c *Channel) RevokeModerator(ctx context.Context, nick, reason string) error {
+	return c.SetRole(ctx, RoleParticipant, nick, reason)
+}
+
+// Destroy destroys the channel.
+// If an alternate venue is provided occupants are informed that they may join
+// it instead, optionally using the provided password.
+func (c *Channel) Destroy(ctx context.Context, alternate jid.JID, password, reason string) error {
+	var attr []xml.Attr
+	if !alternate.Equal(jid.JID{}) {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "jid"}, Value: alternate.String()})
+	}
+	return c.session.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.MultiReader(
+				optionalString(password, xml.Name{Local: "password"}),
+				reasonReader(reason),
+			),
+			xml.StartElement{Name: xml.Name{Local: "destroy"}, Attr: attr},
+		),
+		xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "query"}},
+	), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   c.addr.Bare(),
+	}, nil)
+}
+
+// SetNick changes our nickname in the channel.
+// It blocks until the channel has confirmed the change.
+func (c *Channel) SetNick(ctx context.Context, nick string) error {
+	newAddr, err := c.addr.WithResource(nick)
+	if err != nil {
+		return err
+	}
+	return c.sendSelfPresence(ctx, stanza.Presence{
+		ID: attr.RandomID(),
+		To: newAddr,
+	}, nil)
+}
+
+// SendPrivate sends a private message with the provided body to the occupant
+// with the provided nickname.
+func (c *Channel) SendPrivate(ctx context.Context, nick, body string) error {
+	return c.SendPrivateMessage(ctx, nick, stanza.Message{}, xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(body)),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	))
+}
+
+// SendPrivateMessage is like SendPrivate except that it allows you to customize
+// the message stanza and payload.
+// Changing the recipient or type has no effect.
+func (c *Channel) SendPrivateMessage(ctx context.Context, nick string, m stanza.Message, payload xml.TokenReader) error {
+	to, err := c.addr.WithResource(nick)
+	if err != nil {
+		return err
+	}
+	m.To = to
+	m.Type = stanza.ChatMessage
+	return c.session.Send(ctx, m.Wrap(xmlstream.MultiReader(
+		payload,
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: NSUser, Local: "x"}}),
+	)))
+}
+
+// RequestVoice asks the moderators of the channel to allow us to send messages.
+// It returns immediately after the request has been sent.
+func (c *Channel) RequestVoice(ctx context.Context) error {
+	submission, _ := form.New(
+		form.Hidden("FORM_TYPE", form.Value(NSRequest)),
+		form.List("muc#role", form.Value(RoleParticipant.String())),
+	).Submit()
+	return c.session.Send(ctx, stanza.Message{
+		To:   c.addr.Bare(),
+		Type: stanza.NormalMessage,
+	}.Wrap(submission))
+}
+
+// ApproveVoice approves a voice request that was sent to us as a moderator of
+// the channel.
+func (c *Channel) ApproveVoice(ctx context.Context, req VoiceRequest) error {
+	ok, err := req.Form.Set("muc#request_allow", true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("muc: voice request is missing the approval field")
+	}
+	submission, _ := req.Form.Submit()
+	return c.session.Send(ctx, stanza.Message{
+		To:   c.addr.Bare(),
+		Type: stanza.NormalMessage,
+	}.Wrap(submission))
+}
+
+// GetRegistrationForm requests the form used to register with the channel.
+func (c *Channel) GetRegistrationForm(ctx context.Context) (*form.Data, error) {
+	resp := struct {
+		XMLName xml.Name   `xml:"jabber:iq:register query"`
+		Form    *form.Data `xml:"jabber:x:data x"`
+	}{}
+	err := c.session.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		nil,
+		xml.StartElement{Name: xml.Name{Space: nsRegister, Local: "query"}},
+	), stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   c.addr.Bare(),
+	}, &resp)
+	return resp.Form, err
+}
+
+// Register submits a registration form returned by GetRegistrationForm to the
+// channel to become a member.
+func (c *Channel) Register(ctx context.Context, data *form.Data) error {
+	submission, _ := data.Submit()
+	return c.session.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		submission,
+		xml.StartElement{Name: xml.Name{Space: nsRegister, Local: "query"}},
+	), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   c.addr.Bare(),
+	}, nil)
+}
@@ -0,0 +1,401 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/muc"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+var testRoom = jid.MustParse("room@example.net/me")
+
+// selfPresence echoes a self-presence back to the client, indicating that the
+// join is complete.
+func selfPresence(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+	p.To, p.From = p.From, p.To
+	_, err := xmlstream.Copy(r, p.Wrap(xmlstream.Wrap(
+		nil,
+		xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+	)))
+	return err
+}
+
+// recordIQ returns an IQ handler that sends the inner XML of the payload on
+// handled and responds with the provided payload.
+func recordIQ(handled chan<- string, resp xml.TokenReader) mux.IQHandlerFunc {
+	return func(iq stanza.IQ, r xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+		var buf strings.Builder
+		e := xml.NewEncoder(&buf)
+		_, err := xmlstream.Copy(e, xmlstream.Inner(r))
+		if err != nil {
+			return err
+		}
+		err = e.Flush()
+		if err != nil {
+			return err
+		}
+		handled <- buf.String()
+		_, err = xmlstream.Copy(r, iq.Result(resp))
+		return err
+	}
+}
+
+func joinTestChannel(t *testing.T, h *muc.Client, opt ...mux.Option) (*muc.Channel, *xmpptest.ClientServer) {
+	t.Helper()
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New("", muc.HandleClient(h))),
+		xmpptest.ServerHandler(mux.New(stanza.NSClient, append([]mux.Option{
+			mux.PresenceFunc("", xml.Name{Local: "x"}, selfPresence),
+		}, opt...)...)),
+	)
+	channel, err := h.Join(context.Background(), testRoom, s.Client)
+	if err != nil {
+		t.Fatalf("error joining: %v", err)
+	}
+	return channel, s
+}
+
+var roleTestCases = []struct {
+	f func(*muc.Channel) error
+	x string
+}{
+	0: {
+		f: func(c *muc.Channel) error { return c.Kick(context.Background(), "nick", "reason") },
+		x: `<item xmlns="http://jabber.org/protocol/muc#admin" nick="nick" role="none"><reason xmlns="http://jabber.org/protocol/muc#admin">reason</reason></item>`,
+	},
+	1: {
+		f: func(c *muc.Channel) error { return c.GrantVoice(context.Background(), "nick", "") },
+		x: `<item xmlns="http://jabber.org/protocol/muc#admin" nick="nick" role="participant"></item>`,
+	},
+	2: {
+		f: func(c *muc.Channel) error { return c.RevokeVoice(context.Background(), "nick", "") },
+		x: `<item xmlns="http://jabber.org/protocol/muc#admin" nick="nick" role="visitor"></item>`,
+	},
+	3: {
+		f: func(c *muc.Channel) error { return c.GrantModerator(context.Background(), "nick", "") },
+		x: `<item xmlns="http://jabber.org/protocol/muc#admin" nick="nick" role="moderator"></item>`,
+	},
+	4: {
+		f: func(c *muc.Channel) error {
+			return c.SetRoles(context.Background(), []muc.Item{
+				{Nick: "a", Role: muc.RoleVisitor},
+				{Nick: "b", Role: muc.RoleParticipant},
+			})
+		},
+		x: `<item xmlns="http://jabber.org/protocol/muc#admin" nick="a" role="visitor"></item><item xmlns="http://jabber.org/protocol/muc#admin" nick="b" role="participant"></item>`,
+	},
+	5: {
+		f: func(c *muc.Channel) error {
+			return c.SetAffiliations(context.Background(), []muc.Item{
+				{JID: jid.MustParse("a@example.net/a"), Affiliation: muc.AffiliationOutcast, Reason: "spam"},
+				{JID: jid.MustParse("b@example.net"), Affiliation: muc.AffiliationAdmin},
+			})
+		},
+		x: `<item xmlns="http://jabber.org/protocol/muc#admin" affiliation="outcast" jid="a@example.net"><reason xmlns="http://jabber.org/protocol/muc#admin">spam</reason></item><item xmlns="http://jabber.org/protocol/muc#admin" affiliation="admin" jid="b@example.net"></item>`,
+	},
+}
+
+func TestSetRole(t *testing.T) {
+	h := &muc.Client{}
+	handled := make(chan string, 1)
+	channel, _ := joinTestChannel(t, h,
+		mux.IQ(stanza.SetIQ, xml.Name{Space: muc.NSAdmin, Local: "query"}, recordIQ(handled, nil)),
+	)
+
+	for i, tc := range roleTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := tc.f(channel)
+			if err != nil {
+				t.Fatalf("error setting role: %v", err)
+			}
+			x := <-handled
+			if x != tc.x {
+				t.Fatalf("wrong output:\nwant=%s,\n got=%s", tc.x, x)
+			}
+		})
+	}
+}
+
+func TestGetAffiliations(t *testing.T) {
+	h := &muc.Client{}
+	handled := make(chan string, 1)
+	channel, _ := joinTestChannel(t, h,
+		mux.IQ(stanza.GetIQ, xml.Name{Space: muc.NSAdmin, Local: "query"}, recordIQ(handled, xmlstream.Wrap(
+			xmlstream.MultiReader(
+				xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "item"}, Attr: []xml.Attr{
+					{Name: xml.Name{Local: "affiliation"}, Value: "outcast"},
+					{Name: xml.Name{Local: "jid"}, Value: "a@example.net"},
+				}}),
+				xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "item"}, Attr: []xml.Attr{
+					{Name: xml.Name{Local: "affiliation"}, Value: "outcast"},
+					{Name: xml.Name{Local: "jid"}, Value: "b@example.net"},
+				}}),
+			),
+			xml.StartElement{Name: xml.Name{Space: muc.NSAdmin, Local: "query"}},
+		))),
+	)
+
+	items, err := channel.GetAffiliations(context.Background(), muc.AffiliationOutcast)
+	if err != nil {
+		t.Fatalf("error getting affiliations: %v", err)
+	}
+	const expected = `<item xmlns="http://jabber.org/protocol/muc#admin" affiliation="outcast"></item>`
+	if x := <-handled; x != expected {
+		t.Errorf("wrong request:\nwant=%s,\n got=%s", expected, x)
+	}
+	if len(items) != 2 {
+		t.Fatalf("wrong number of items: want=2, got=%d", len(items))
+	}
+	for i, j := range []string{"a@example.net", "b@example.net"} {
+		if items[i].Affiliation != muc.AffiliationOutcast {
+			t.Errorf("wrong affiliation for item %d: want=%v, got=%v", i, muc.AffiliationOutcast, items[i].Affiliation)
+		}
+		if s := items[i].JID.String(); s != j {
+			t.Errorf("wrong JID for item %d: want=%s, got=%s", i, j, s)
+		}
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	h := &muc.Client{}
+	handled := make(chan string, 1)
+	channel, _ := joinTestChannel(t, h,
+		mux.IQ(stanza.SetIQ, xml.Name{Space: muc.NSOwner, Local: "query"}, recordIQ(handled, nil)),
+	)
+
+	err := channel.Destroy(context.Background(), jid.MustParse("other@example.net"), "pass", "moving")
+	if err != nil {
+		t.Fatalf("error destroying channel: %v", err)
+	}
+	const expected = `<destroy xmlns="http://jabber.org/protocol/muc#owner" jid="other@example.net"><password xmlns="http://jabber.org/protocol/muc#owner">pass</password><reason xmlns="http://jabber.org/protocol/muc#owner">moving</reason></destroy>`
+	if x := <-handled; x != expected {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, x)
+	}
+}
+
+func TestSetNick(t *testing.T) {
+	h := &muc.Client{}
+	channel, _ := joinTestChannel(t, h,
+		mux.PresenceFunc("", xml.Name{}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+			// Announce that the old nickname is leaving then send the new
+			// self-presence.
+			_, err := xmlstream.Copy(r, stanza.Presence{
+				From: testRoom,
+				To:   p.From,
+				Type: stanza.UnavailablePresence,
+			}.Wrap(xmlstream.Wrap(
+				xmlstream.MultiReader(
+					xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "item"}, Attr: []xml.Attr{
+						{Name: xml.Name{Local: "nick"}, Value: p.To.Resourcepart()},
+					}}),
+					xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "status"}, Attr: []xml.Attr{
+						{Name: xml.Name{Local: "code"}, Value: "303"},
+					}}),
+				),
+				xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+			)))
+			if err != nil {
+				return err
+			}
+			return selfPresence(p, r)
+		}),
+	)
+
+	err := channel.SetNick(context.Background(), "new")
+	if err != nil {
+		t.Fatalf("error changing nickname: %v", err)
+	}
+	if me := channel.Me().String(); me != "room@example.net/new" {
+		t.Errorf("wrong address after nickname change: want=room@example.net/new, got=%s", me)
+	}
+}
+
+func TestSendPrivate(t *testing.T) {
+	h := &muc.Client{}
+	handled := make(chan stanza.Message, 1)
+	channel, _ := joinTestChannel(t, h,
+		mux.MessageFunc(stanza.ChatMessage, xml.Name{Local: "body"}, func(m stanza.Message, r xmlstream.TokenReadEncoder) error {
+			handled <- m
+			return nil
+		}),
+	)
+
+	err := channel.SendPrivate(context.Background(), "other", "hello")
+	if err != nil {
+		t.Fatalf("error sending private message: %v", err)
+	}
+	m := <-handled
+	if s := m.To.String(); s != "room@example.net/other" {
+		t.Errorf("wrong recipient: want=room@example.net/other, got=%s", s)
+	}
+}
+
+func TestVoiceRequest(t *testing.T) {
+	reqs := make(chan muc.VoiceRequest, 10)
+	h := &muc.Client{
+		HandleVoiceRequest: func(req muc.VoiceRequest) {
+			reqs <- req
+		},
+	}
+	handled := make(chan *form.Data, 1)
+	channel, s := joinTestChannel(t, h,
+		mux.MessageFunc(stanza.NormalMessage, xml.Name{Space: form.NS, Local: "x"}, func(m stanza.Message, r xmlstream.TokenReadEncoder) error {
+			msg := struct {
+				stanza.Message
+				Form *form.Data `xml:"jabber:x:data x"`
+			}{}
+			err := xml.NewTokenDecoder(r).Decode(&msg)
+			handled <- msg.Form
+			return err
+		}),
+	)
+
+	err := channel.RequestVoice(context.Background())
+	if err != nil {
+		t.Fatalf("error requesting voice: %v", err)
+	}
+	data := <-handled
+	if v, _ := data.Raw("FORM_TYPE"); len(v) != 1 || v[0] != muc.NSRequest {
+		t.Errorf("wrong form type: want=%s, got=%v", muc.NSRequest, v)
+	}
+	if v, _ := data.Raw("muc#role"); len(v) != 1 || v[0] != "participant" {
+		t.Errorf("wrong role: want=participant, got=%v", v)
+	}
+
+	sendRequest := func(from jid.JID, nick string) {
+		t.Helper()
+		err := s.Server.Send(context.Background(), stanza.Message{
+			From: from,
+			To:   s.Client.LocalAddr(),
+			Type: stanza.NormalMessage,
+		}.Wrap(form.New(
+			form.Hidden("FORM_TYPE", form.Value(muc.NSRequest)),
+			form.List("muc#role", form.Value("participant")),
+			form.JID("muc#jid", form.Value(nick+"@example.net/res")),
+			form.Text("muc#roomnick", form.Value(nick)),
+			form.Boolean("muc#request_allow", form.Value("0")),
+		).TokenReader()))
+		if err != nil {
+			t.Fatalf("error sending voice request: %v", err)
+		}
+	}
+	sendPresence := func(nick string, role muc.Role, status ...muc.Status) {
+		t.Helper()
+		from, _ := testRoom.WithResource(nick)
+		var codes []xml.TokenReader
+		for _, code := range status {
+			codes = append(codes, xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "status"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "code"}, Value: strconv.Itoa(int(code))}},
+			}))
+		}
+		err := s.Server.Send(context.Background(), stanza.Presence{
+			From: from,
+			To:   s.Client.LocalAddr(),
+		}.Wrap(xmlstream.Wrap(
+			xmlstream.MultiReader(append([]xml.TokenReader{xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "item"},
+				Attr: []xml.Attr{
+					{Name: xml.Name{Local: "affiliation"}, Value: "none"},
+					{Name: xml.Name{Local: "role"}, Value: role.String()},
+				},
+			})}, codes...)...),
+			xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+		)))
+		if err != nil {
+			t.Fatalf("error sending presence: %v", err)
+		}
+	}
+
+	// Requests are ignored unless we are a moderator.
+	sendPresence("visitor", muc.RoleVisitor)
+	sendRequest(testRoom.Bare(), "visitor")
+
+	// Requests are ignored unless they are sent by the channel itself on behalf
+	// of a visitor.
+	sendPresence(testRoom.Resourcepart(), muc.RoleModerator, muc.StatusSelf)
+	sendPresence("participant", muc.RoleParticipant)
+	sendRequest(jid.MustParse("room@example.net/visitor"), "visitor")
+	sendRequest(jid.MustParse("mallory@example.net"), "visitor")
+	sendRequest(testRoom.Bare(), "participant")
+	sendRequest(testRoom.Bare(), "stranger")
+
+	sendRequest(testRoom.Bare(), "visitor")
+	req := <-reqs
+	select {
+	case req := <-reqs:
+		t.Fatalf("unexpected voice request: %+v", req)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !req.Room.Equal(testRoom.Bare()) {
+		t.Errorf("wrong room: want=%v, got=%v", testRoom.Bare(), req.Room)
+	}
+	if req.Nick != "visitor" {
+		t.Errorf("wrong nick: want=visitor, got=%s", req.Nick)
+	}
+	if s := req.JID.String(); s != "visitor@example.net/res" {
+		t.Errorf("wrong JID: want=visitor@example.net/res, got=%s", s)
+	}
+
+	err = channel.ApproveVoice(context.Background(), req)
+	if err != nil {
+		t.Fatalf("error approving voice request: %v", err)
+	}
+	data = <-handled
+	if v, _ := data.Raw("muc#request_allow"); len(v) != 1 || v[0] != "true" {
+		t.Errorf("request was not approved: got=%v", v)
+	}
+	if v, _ := data.Raw("muc#roomnick"); len(v) != 1 || v[0] != "visitor" {
+		t.Errorf("wrong nick in approval: want=visitor, got=%v", v)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	h := &muc.Client{}
+	handled := make(chan string, 1)
+	channel, _ := joinTestChannel(t, h,
+		mux.IQ(stanza.GetIQ, xml.Name{Space: "jabber:iq:register", Local: "query"}, recordIQ(handled, xmlstream.Wrap(
+			form.New(
+				form.Hidden("FORM_TYPE", form.Value(muc.NSRegister)),
+				form.Text("muc#register_roomnick"),
+			).TokenReader(),
+			xml.StartElement{Name: xml.Name{Space: "jabber:iq:register", Local: "query"}},
+		))),
+		mux.IQ(stanza.SetIQ, xml.Name{Space: "jabber:iq:register", Local: "query"}, recordIQ(handled, nil)),
+	)
+
+	data, err := channel.GetRegistrationForm(context.Background())
+	if err != nil {
+		t.Fatalf("error getting registration form: %v", err)
+	}
+	<-handled
+	if data == nil {
+		t.Fatalf("no form returned")
+	}
+	ok, err := data.Set("muc#register_roomnick", "me")
+	if err != nil || !ok {
+		t.Fatalf("error setting nick: ok=%t, err=%v", ok, err)
+	}
+	err = channel.Register(context.Background(), data)
+	if err != nil {
+		t.Fatalf("error registering: %v", err)
+	}
+	x := <-handled
+	if !strings.Contains(x, `var="muc#register_roomnick"`) || !strings.Contains(x, `type="submit"`) {
+		t.Errorf("unexpected registration submission: %s", x)
+	}
+}
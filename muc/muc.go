@@ -9,11 +9,12 @@
 // Unlike many Multi-User Chat (MUC) implementations, the muc package tries to
 // be as stateless as possible.
 // It allows you to receive chat messages and invites sent through a channel,
-// for example, but does not keep track of what users are joined to the channel
-// at any given time.
-// This is best left up to the user who may want to use a distributed datastore
-// to keep track of users in a large system for searching many public channels,
-// or may want a simple in-memory map for a small client.
+// for example, and only keeps track of the occupants of channels that have been
+// joined.
+// Anything else, such as the history of a channel, is best left up to the user
+// who may want to use a distributed datastore to keep track of users in a large
+// system for searching many public channels, or may want a simple in-memory map
+// for a small client.
 //
 // The main entrypoint into the muc package (for clients) is the Client type.
 // It can be used to join MUCs and has callbacks for receiving MUC events such
@@ -55,6 +56,12 @@ const (
 	NSOwner = `http://jabber.org/protocol/muc#owner`
 	NSAdmin = `http://jabber.org/protocol/muc#admin`
 
+	// NSRequest is the form type used to request voice in a moderated channel.
+	NSRequest = `http://jabber.org/protocol/muc#request`
+
+	// NSRegister is the form type used to register with a channel.
+	NSRegister = `http://jabber.org/protocol/muc#register`
+
 	// NSConf is the legacy conference namespace, now only used for direct MUC
 	// invitations and backwards compatibility.
 	NSConf = `jabber:x:conference`
@@ -114,6 +121,9 @@ func SetConfigIQ(ctx context.Context, iq stanza.IQ, form *form.Data, s *xmpp.Ses
 
 // HandleClient returns an option that registers the handler for use with a
 // multiplexer.
+// Messages containing data forms are only handled if h.HandleVoiceRequest is
+// set when the option is applied so that they can otherwise be handled by
+// other packages, such as commands or pubsub.
 func HandleClient(h *Client) mux.Option {
 	return func(m *mux.ServeMux) {
 		userPresence := xml.Name{Space: NSUser, Local: "x"}
@@ -121,6 +131,10 @@ func HandleClient(h *Client) mux.Option {
 		mux.Presence(stanza.AvailablePresence, userPresence, h)(m)
 		mux.Presence(stanza.UnavailablePresence, userPresence, h)(m)
 		mux.Message(stanza.NormalMessage, userPresence, h)(m)
+		mux.Message(stanza.GroupChatMessage, userPresence, h)(m)
+		if h.HandleVoiceRequest != nil {
+			mux.Message(stanza.NormalMessage, xml.Name{Space: form.NS, Local: "x"}, h)(m)
+		}
 	}
 }
 
@@ -133,6 +147,22 @@ type Client struct {
 	// HandleInvite will be called if we receive a mediated MUC invitation.
 	HandleInvite       func(Invitation)
 	HandleUserPresence func(stanza.Presence, Item)
+
+	// HandleVoiceRequest will be called if we are a moderator of a channel and
+	// a visitor in the channel requests voice.
+	// Requests that are not sent by a channel that we have joined are ignored.
+	// It must be set before the Client is registered using HandleClient.
+	HandleVoiceRequest func(VoiceRequest)
+
+	// HandleOccupant will be called when an occupant joins or leaves a channel
+	// that we have joined, or when their nickname, role, affiliation, or
+	// presence changes.
+	HandleOccupant func(*Channel, OccupantEvent)
+
+	// HandleChannelStatus will be called when a channel that we have joined
+	// sends status codes that are not related to a specific occupant, for
+	// example when its configuration changes.
+	HandleChannelStatus func(*Channel, []Status)
 }
 
 // HandleMessage satisfies mux.MessageHandler.
@@ -140,9 +170,13 @@ type Client struct {
 // user.
 func (c *Client) HandleMessage(p stanza.Message, r xmlstream.TokenReadEncoder) error {
 	d := xml.NewTokenDecoder(r)
+	if p.Type == stanza.GroupChatMessage {
+		return c.handleChannelStatus(p, d)
+	}
 	msg := struct {
 		stanza.Message
-		X Invitation `xml:"http://jabber.org/protocol/muc#user x"`
+		X    Invitation `xml:"http://jabber.org/protocol/muc#user x"`
+		Form *form.Data `xml:"jabber:x:data x"`
 	}{}
 	err := d.Decode(&msg)
 	if err != nil {
@@ -153,21 +187,70 @@ func (c *Client) HandleMessage(p stanza.Message, r xmlstream.TokenReadEncoder) e
 		c.HandleInvite(msg.X)
 		return nil
 	}
+	if formType, _ := msg.Form.Raw("FORM_TYPE"); len(formType) == 1 && formType[0] == NSRequest && c.HandleVoiceRequest != nil {
+		if req, ok := c.voiceRequest(msg.From, msg.Form); ok {
+			c.HandleVoiceRequest(req)
+		}
+	}
+	return nil
+}
+
+// handleChannelStatus decodes status codes sent by a channel in a groupchat
+// message.
+func (c *Client) handleChannelStatus(p stanza.Message, d *xml.Decoder) error {
+	msg := struct {
+		stanza.Message
+		X struct {
+			Status []struct {
+				Code Status `xml:"code,attr"`
+			} `xml:"status"`
+		} `xml:"http://jabber.org/protocol/muc#user x"`
+	}{}
+	err := d.Decode(&msg)
+	if err != nil {
+		return err
+	}
+	if c.HandleChannelStatus == nil || len(msg.X.Status) == 0 {
+		return nil
+	}
+
+	c.managedM.Lock()
+	channel := c.channel(p.From)
+	c.managedM.Unlock()
+	if channel == nil {
+		return nil
+	}
+	codes := make([]Status, 0, len(msg.X.Status))
+	for _, status := range msg.X.Status {
+		codes = append(codes, status.Code)
+	}
+	c.HandleChannelStatus(channel, codes)
 	return nil
 }
 
 type mucPresence struct {
 	stanza.Presence
-	X struct {
+	Show   string `xml:"show"`
+	Status string `xml:"status"`
+	X      struct {
 		XMLName xml.Name
-		Item    Item `xml:"item"`
-		Status  []struct {
-			Code int `xml:"code,attr"`
+		Item    struct {
+			Item
+			Actor struct {
+				Nick string `xml:"nick,attr"`
+			} `xml:"actor"`
+		} `xml:"item"`
+		Status []struct {
+			Code Status `xml:"code,attr"`
 		} `xml:"status,omitempty"`
+		Destroy *struct {
+			JID    jid.JID `xml:"jid,attr"`
+			Reason string  `xml:"reason"`
+		} `xml:"destroy"`
 	} `xml:"x"`
 }
 
-func (p *mucPresence) HasStatus(code int) bool {
+func (p *mucPresence) HasStatus(code Status) bool {
 	for _, status := range p.X.Status {
 		if status.Code == code {
 			return true
@@ -176,19 +259,33 @@ func (p *mucPresence) HasStatus(code int) bool {
 	return false
 }
 
+// channel returns the joined channel with the same bare address as addr.
+// It must be called with managedM held.
+func (c *Client) channel(addr jid.JID) *Channel {
+	room := addr.Bare()
+	for _, channel := range c.managed {
+		if channel.addr.Bare().Equal(room) {
+			return channel
+		}
+	}
+	return nil
+}
+
+func (p *mucPresence) statuses() []Status {
+	if len(p.X.Status) == 0 {
+		return nil
+	}
+	codes := make([]Status, 0, len(p.X.Status))
+	for _, status := range p.X.Status {
+		codes = append(codes, status.Code)
+	}
+	return codes
+}
+
 // HandlePresence satisfies mux.PresenceHandler.
 // it is used by the multiplexer and normally does not need to be called by the
 // user.
 func (c *Client) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
-	// If this is a self-presence, check if we're joining or departing and send on
-	// the channel.
-	c.managedM.Lock()
-	defer c.managedM.Unlock()
-	channel, ok := c.managed[p.From.String()]
-	// TODO: what do we do with presences that aren't managed?
-	if !ok {
-		return nil
-	}
 	d := xml.NewTokenDecoder(r)
 	var decodedPresence mucPresence
 	err := d.Decode(&decodedPresence)
@@ -196,6 +293,45 @@ func (c *Client) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder)
 		return err
 	}
 
+	c.managedM.Lock()
+	channel, self := c.managed[p.From.String()]
+	if !self {
+		channel = c.channel(p.From)
+		// TODO: what do we do with presences that aren't managed?
+		if channel == nil {
+			c.managedM.Unlock()
+			return nil
+		}
+		// If the service has assigned us a different nickname, track the channel
+		// under the new address.
+		if decodedPresence.HasStatus(StatusSelf) {
+			self = true
+			delete(c.managed, channel.addr.String())
+			c.managed[p.From.String()] = channel
+		}
+	}
+
+	var joined bool
+	if self {
+		joined = c.handleSelf(channel, p, decodedPresence)
+	}
+	c.managedM.Unlock()
+
+	e, changed := channel.updateOccupants(decodedPresence, self)
+	if changed && c.HandleOccupant != nil {
+		c.HandleOccupant(channel, e)
+	}
+	if !joined && p.Type == stanza.AvailablePresence && decodedPresence.X.XMLName.Space == NSUser && c.HandleUserPresence != nil {
+		c.HandleUserPresence(decodedPresence.Presence, decodedPresence.X.Item.Item)
+	}
+	return nil
+}
+
+// handleSelf checks if we're joining or departing a channel when a
+// self-presence is received and unblocks any pending join or leave calls.
+// It reports whether a pending join call was completed and must be called with
+// managedM held.
+func (c *Client) handleSelf(channel *Channel, p stanza.Presence, decodedPresence mucPresence) bool {
 	switch p.Type {
 	case stanza.AvailablePresence:
 		// If any join functions are pending awaiting the join to complete, unblock
@@ -205,7 +341,7 @@ func (c *Client) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder)
 		case c := <-channel.join:
 			select {
 			case c.j <- p.From:
-				return nil
+				return true
 			case <-c.done:
 				// If the call to Join has timed out, try again to see if we have a
 				// subsequent call to Join (and if not, send the call to the user
@@ -214,17 +350,24 @@ func (c *Client) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder)
 			}
 		default:
 		}
-		if decodedPresence.X.XMLName.Space == NSUser && c.HandleUserPresence != nil {
-			c.HandleUserPresence(decodedPresence.Presence, decodedPresence.X.Item)
-		}
 	case stanza.UnavailablePresence:
-		delete(c.managed, channel.addr.String())
+		// If our nickname is being changed, track the channel under its new address
+		// until the self-presence for the new nickname is received.
+		if decodedPresence.HasStatus(StatusNewNick) && decodedPresence.X.Item.Nick != "" {
+			newAddr, err := p.From.WithResource(decodedPresence.X.Item.Nick)
+			if err == nil {
+				delete(c.managed, p.From.String())
+				c.managed[newAddr.String()] = channel
+				return false
+			}
+		}
+		delete(c.managed, p.From.String())
 		select {
 		case channel.depart <- struct{}{}:
 		default:
 		}
 	}
-	return nil
+	return false
 }
 
 // Join a MUC on the provided session.
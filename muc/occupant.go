@@ -0,0 +1,246 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"sort"
+
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Status is a status code sent by a channel to provide context for a presence
+// or message.
+type Status uint16
+
+// A list of status codes.
+const (
+	// Room statuses sent in a self-presence when joining or in a message when
+	// the configuration changes.
+	StatusNonAnonymous       Status = 100
+	StatusAffiliationChanged Status = 101
+	StatusShowUnavailable    Status = 102
+	StatusHideUnavailable    Status = 103
+	StatusConfigChanged      Status = 104
+	StatusLogging            Status = 170
+	StatusNotLogging         Status = 171
+	StatusNowNonAnonymous    Status = 172
+	StatusNowSemiAnonymous   Status = 173
+
+	// StatusSelf indicates that the presence refers to ourself.
+	StatusSelf Status = 110
+
+	// StatusCreated indicates that a new channel has been created by joining it.
+	StatusCreated Status = 201
+
+	// StatusNickAssigned indicates that the service has assigned or modified our
+	// nickname.
+	StatusNickAssigned Status = 210
+
+	// Statuses sent when an occupant leaves the channel.
+	StatusBanned             Status = 301
+	StatusNewNick            Status = 303
+	StatusKicked             Status = 307
+	StatusAffiliationRemoved Status = 321
+	StatusMembersOnly        Status = 322
+	StatusShutdown           Status = 332
+	StatusError              Status = 333
+)
+
+// EventType indicates the kind of change that caused an OccupantEvent.
+type EventType uint8
+
+// A list of event types.
+const (
+	// EventJoin is sent when an occupant joins the channel, including when
+	// receiving the list of occupants after we join.
+	EventJoin EventType = iota
+
+	// EventUpdate is sent when the role, affiliation, or presence of an
+	// occupant changes.
+	EventUpdate
+
+	// EventNickChange is sent when an occupant changes their nickname.
+	EventNickChange
+
+	// EventLeave is sent when an occupant leaves the channel.
+	EventLeave
+
+	// EventKick and EventBan are sent when an occupant is kicked or banned from
+	// the channel.
+	EventKick
+	EventBan
+
+	// EventRemoved is sent when an occupant is removed from the channel because
+	// of an affiliation change, because the channel became members-only, or
+	// because the service is shutting down or encountered an error.
+	EventRemoved
+
+	// EventDestroy is sent when the channel has been destroyed.
+	EventDestroy
+)
+
+// Occupant is a user in a channel.
+type Occupant struct {
+	Nick string
+
+	// JID is the real address of the occupant if the channel is non-anonymous
+	// or we are a moderator.
+	JID jid.JID
+
+	Affiliation Affiliation
+	Role        Role
+
+	// Show and Status are the availability and status message from the
+	// occupants presence.
+	Show   string
+	Status string
+}
+
+func (o Occupant) equal(other Occupant) bool {
+	return o.Nick == other.Nick &&
+		o.JID.Equal(other.JID) &&
+		o.Affiliation == other.Affiliation &&
+		o.Role == other.Role &&
+		o.Show == other.Show &&
+		o.Status == other.Status
+}
+
+// OccupantEvent describes a change to the occupants of a channel.
+type OccupantEvent struct {
+	Type EventType
+
+	// Occupant is the occupant after the change.
+	// For events that remove an occupant from the channel it is the last known
+	// state of the occupant.
+	Occupant Occupant
+
+	// Self is true if the event refers to us.
+	Self bool
+
+	// OldNick is the previous nickname of the occupant if the event is an
+	// EventNickChange.
+	OldNick string
+
+	// Status is the list of status codes sent with the event.
+	Status []Status
+
+	// Reason and Actor are the reason for the change and the nickname of the
+	// moderator who made it, if provided by the channel.
+	Reason string
+	Actor  string
+
+	// Alternate is the address of an alternate venue if the event is an
+	// EventDestroy.
+	Alternate jid.JID
+}
+
+// HasStatus returns true if the status code was sent with the event.
+func (e OccupantEvent) HasStatus(s Status) bool {
+	for _, status := range e.Status {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Occupants returns the current list of occupants in the channel sorted by
+// nickname.
+func (c *Channel) Occupants() []Occupant {
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+	occupants := make([]Occupant, 0, len(c.occupants))
+	for _, o := range c.occupants {
+		occupants = append(occupants, o)
+	}
+	sort.Slice(occupants, func(i, j int) bool {
+		return occupants[i].Nick < occupants[j].Nick
+	})
+	return occupants
+}
+
+// Occupant returns the occupant with the provided nickname.
+func (c *Channel) Occupant(nick string) (Occupant, bool) {
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+	o, ok := c.occupants[nick]
+	return o, ok
+}
+
+// updateOccupants applies a presence from the channel to the list of occupants
+// and returns the resulting event.
+// If the presence did not change anything, ok is false.
+func (c *Channel) updateOccupants(p mucPresence, self bool) (e OccupantEvent, ok bool) {
+	nick := p.From.Resourcepart()
+	e = OccupantEvent{
+		Self:   self,
+		Status: p.statuses(),
+		Reason: p.X.Item.Reason,
+		Actor:  p.X.Item.Actor.Nick,
+		Occupant: Occupant{
+			Nick:        nick,
+			JID:         p.X.Item.JID,
+			Affiliation: p.X.Item.Affiliation,
+			Role:        p.X.Item.Role,
+			Show:        p.Show,
+			Status:      p.Status,
+		},
+	}
+
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+	if c.occupants == nil {
+		c.occupants = make(map[string]Occupant)
+	}
+	prev, existed := c.occupants[nick]
+
+	if p.Type == stanza.UnavailablePresence {
+		delete(c.occupants, nick)
+		if existed {
+			e.Occupant = prev
+		}
+		switch {
+		case p.HasStatus(StatusNewNick) && p.X.Item.Nick != "":
+			e.Type = EventNickChange
+			e.OldNick = nick
+			e.Occupant.Nick = p.X.Item.Nick
+			c.occupants[e.Occupant.Nick] = e.Occupant
+			return e, true
+		case p.X.Destroy != nil:
+			e.Type = EventDestroy
+			e.Alternate = p.X.Destroy.JID
+			e.Reason = p.X.Destroy.Reason
+		case p.HasStatus(StatusBanned):
+			e.Type = EventBan
+		case p.HasStatus(StatusKicked):
+			e.Type = EventKick
+		case p.HasStatus(StatusAffiliationRemoved),
+			p.HasStatus(StatusMembersOnly),
+			p.HasStatus(StatusShutdown),
+			p.HasStatus(StatusError):
+			e.Type = EventRemoved
+		default:
+			e.Type = EventLeave
+		}
+		// Once we are no longer in the channel we will not receive any more
+		// updates, so forget about the other occupants.
+		if self {
+			c.occupants = nil
+		}
+		return e, true
+	}
+
+	c.occupants[nick] = e.Occupant
+	switch {
+	case !existed:
+		e.Type = EventJoin
+	case !prev.equal(e.Occupant):
+		e.Type = EventUpdate
+	default:
+		return e, false
+	}
+	return e, true
+}
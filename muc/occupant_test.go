@@ -0,0 +1,229 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/muc"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// occupantPresence returns a presence from the occupant of the test room with
+// the provided nickname.
+func occupantPresence(nick string, typ stanza.PresenceType, item []xml.Attr, payload xml.TokenReader, codes ...muc.Status) xml.TokenReader {
+	inner := []xml.TokenReader{
+		xmlstream.Wrap(payload, xml.StartElement{Name: xml.Name{Local: "item"}, Attr: item}),
+	}
+	for _, code := range codes {
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "status"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "code"}, Value: strconv.Itoa(int(code))}},
+		}))
+	}
+	from, _ := testRoom.WithResource(nick)
+	return stanza.Presence{
+		From: from,
+		To:   jid.MustParse("test@example.net"),
+		Type: typ,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+	))
+}
+
+func itemAttr(kv ...string) []xml.Attr {
+	attrs := make([]xml.Attr, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: kv[i]}, Value: kv[i+1]})
+	}
+	return attrs
+}
+
+func TestOccupants(t *testing.T) {
+	events := make(chan muc.OccupantEvent, 10)
+	statuses := make(chan []muc.Status, 1)
+	h := &muc.Client{
+		HandleOccupant: func(_ *muc.Channel, e muc.OccupantEvent) {
+			events <- e
+		},
+		HandleChannelStatus: func(_ *muc.Channel, s []muc.Status) {
+			statuses <- s
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New("", muc.HandleClient(h))),
+		xmpptest.ServerHandler(mux.New(stanza.NSClient,
+			mux.PresenceFunc("", xml.Name{Local: "x"}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+				// Send the existing occupant and then a self-presence with a nickname
+				// assigned by the service.
+				_, err := xmlstream.Copy(r, occupantPresence("alice", "", itemAttr(
+					"affiliation", "owner",
+					"role", "moderator",
+					"jid", "alice@example.net/res",
+				), nil))
+				if err != nil {
+					return err
+				}
+				_, err = xmlstream.Copy(r, occupantPresence("assigned", "", itemAttr(
+					"affiliation", "none",
+					"role", "participant",
+				), nil, muc.StatusSelf, muc.StatusCreated, muc.StatusNickAssigned))
+				return err
+			}),
+		)),
+	)
+
+	channel, err := h.Join(context.Background(), testRoom, s.Client)
+	if err != nil {
+		t.Fatalf("error joining: %v", err)
+	}
+	if me := channel.Me().String(); me != "room@example.net/assigned" {
+		t.Errorf("wrong assigned nickname: want=room@example.net/assigned, got=%s", me)
+	}
+
+	send := func(r xml.TokenReader) {
+		t.Helper()
+		err := s.Server.Send(context.Background(), r)
+		if err != nil {
+			t.Fatalf("error sending: %v", err)
+		}
+	}
+	send(occupantPresence("bob", "", itemAttr("affiliation", "member", "role", "participant"), nil))
+	// Re-sending the same presence should not result in an event.
+	send(occupantPresence("bob", "", itemAttr("affiliation", "member", "role", "participant"), nil))
+	send(occupantPresence("bob", "", itemAttr("affiliation", "member", "role", "visitor"), nil))
+	send(occupantPresence("alice", stanza.UnavailablePresence, itemAttr(
+		"affiliation", "owner",
+		"role", "moderator",
+		"jid", "alice@example.net/res",
+		"nick", "carol",
+	), nil, muc.StatusNewNick))
+	send(occupantPresence("carol", "", itemAttr(
+		"affiliation", "owner",
+		"role", "moderator",
+		"jid", "alice@example.net/res",
+	), nil))
+	send(occupantPresence("bob", stanza.UnavailablePresence, itemAttr("affiliation", "member", "role", "none"), xmlstream.MultiReader(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "actor"}, Attr: itemAttr("nick", "carol")}),
+		xmlstream.Wrap(xmlstream.Token(xml.CharData("spam")), xml.StartElement{Name: xml.Name{Local: "reason"}}),
+	), muc.StatusKicked))
+
+	expected := []struct {
+		typ     muc.EventType
+		nick    string
+		self    bool
+		role    muc.Role
+		oldNick string
+		status  []muc.Status
+		actor   string
+		reason  string
+	}{
+		{typ: muc.EventJoin, nick: "alice", role: muc.RoleModerator},
+		{typ: muc.EventJoin, nick: "assigned", self: true, role: muc.RoleParticipant, status: []muc.Status{muc.StatusSelf, muc.StatusCreated, muc.StatusNickAssigned}},
+		{typ: muc.EventJoin, nick: "bob", role: muc.RoleParticipant},
+		{typ: muc.EventUpdate, nick: "bob", role: muc.RoleVisitor},
+		{typ: muc.EventNickChange, nick: "carol", role: muc.RoleModerator, oldNick: "alice", status: []muc.Status{muc.StatusNewNick}},
+		{typ: muc.EventKick, nick: "bob", role: muc.RoleVisitor, status: []muc.Status{muc.StatusKicked}, actor: "carol", reason: "spam"},
+	}
+	for i, want := range expected {
+		e := <-events
+		if e.Type != want.typ {
+			t.Errorf("%d: wrong event type: want=%v, got=%v", i, want.typ, e.Type)
+		}
+		if e.Occupant.Nick != want.nick {
+			t.Errorf("%d: wrong nick: want=%s, got=%s", i, want.nick, e.Occupant.Nick)
+		}
+		if e.Self != want.self {
+			t.Errorf("%d: wrong value for self: want=%t, got=%t", i, want.self, e.Self)
+		}
+		if e.Occupant.Role != want.role {
+			t.Errorf("%d: wrong role: want=%v, got=%v", i, want.role, e.Occupant.Role)
+		}
+		if e.OldNick != want.oldNick {
+			t.Errorf("%d: wrong old nick: want=%s, got=%s", i, want.oldNick, e.OldNick)
+		}
+		if !reflect.DeepEqual(e.Status, want.status) {
+			t.Errorf("%d: wrong status codes: want=%v, got=%v", i, want.status, e.Status)
+		}
+		if e.Actor != want.actor {
+			t.Errorf("%d: wrong actor: want=%s, got=%s", i, want.actor, e.Actor)
+		}
+		if e.Reason != want.reason {
+			t.Errorf("%d: wrong reason: want=%s, got=%s", i, want.reason, e.Reason)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event: %+v", e)
+	default:
+	}
+
+	occupants := channel.Occupants()
+	if len(occupants) != 2 || occupants[0].Nick != "assigned" || occupants[1].Nick != "carol" {
+		t.Fatalf("wrong occupants: %+v", occupants)
+	}
+	carol, ok := channel.Occupant("carol")
+	if !ok {
+		t.Fatalf("occupant not found")
+	}
+	if s := carol.JID.String(); s != "alice@example.net/res" {
+		t.Errorf("wrong real JID: want=alice@example.net/res, got=%s", s)
+	}
+	if carol.Affiliation != muc.AffiliationOwner {
+		t.Errorf("wrong affiliation: want=%v, got=%v", muc.AffiliationOwner, carol.Affiliation)
+	}
+
+	send(stanza.Message{
+		From: testRoom.Bare(),
+		To:   jid.MustParse("test@example.net"),
+		Type: stanza.GroupChatMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "status"},
+			Attr: itemAttr("code", "104"),
+		}),
+		xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+	)))
+	if codes := <-statuses; !reflect.DeepEqual(codes, []muc.Status{muc.StatusConfigChanged}) {
+		t.Errorf("wrong channel status: want=%v, got=%v", []muc.Status{muc.StatusConfigChanged}, codes)
+	}
+}
+
+func TestOccupantsLeave(t *testing.T) {
+	events := make(chan muc.OccupantEvent, 10)
+	h := &muc.Client{
+		HandleOccupant: func(_ *muc.Channel, e muc.OccupantEvent) {
+			events <- e
+		},
+	}
+	channel, s := joinTestChannel(t, h)
+	if e := <-events; e.Type != muc.EventJoin || !e.Self {
+		t.Fatalf("expected self join event, got %+v", e)
+	}
+
+	err := s.Server.Send(context.Background(), occupantPresence("me", stanza.UnavailablePresence, itemAttr(
+		"affiliation", "none",
+		"role", "none",
+	), nil, muc.StatusSelf))
+	if err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+	e := <-events
+	if e.Type != muc.EventLeave || !e.Self {
+		t.Errorf("expected self leave event, got %+v", e)
+	}
+	if occupants := channel.Occupants(); len(occupants) != 0 {
+		t.Errorf("expected occupants to be cleared, got %+v", occupants)
+	}
+}
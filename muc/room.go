@@ -7,6 +7,7 @@ package muc
 import (
 	"context"
 	"encoding/xml"
+	"sync"
 
 	"mellium.im/xmlstream"
 	"github.com/kamrankamilli/xmpp"
@@ -23,7 +24,7 @@ type joinCtx struct {
 // Channel represents a group chat, conference, or chatroom.
 //
 // Channel aims to be as stateless as possible, so details such as the channel
-// subject and participant list are not stored.
+// subject are not stored and only the list of occupants is tracked.
 // Instead, it is up to the user to store this information and associate it with
 // the channel (probably by mapping details to the channel address).
 type Channel struct {
@@ -34,6 +35,9 @@ type Channel struct {
 
 	join   chan joinCtx
 	depart chan struct{}
+
+	occupantsM sync.Mutex
+	occupants  map[string]Occupant
 }
 
 // Addr returns the address of the channel.
@@ -148,34 +152,12 @@ func (c *Channel) Invite(ctx context.Context, reason string, to jid.JID) error {
 // SetAffiliation changes the affiliation of the provided JID which should be
 // the users real bare-JID (not their room JID).
 func (c *Channel) SetAffiliation(ctx context.Context, a Affiliation, j jid.JID, nick, reason string) error {
-	var reasonEl xml.TokenReader
-	if reason != "" {
-		reasonEl = xmlstream.Wrap(
-			xmlstream.Token(xml.CharData(reason)),
-			xml.StartElement{Name: xml.Name{Local: "reason"}},
-		)
-	}
-	attr := []xml.Attr{
-		{Name: xml.Name{Local: "affiliation"}, Value: a.String()},
-		{Name: xml.Name{Local: "jid"}, Value: j.Bare().String()},
-	}
-	if nick != "" {
-		attr = append(attr, xml.Attr{Name: xml.Name{Local: "nick"}, Value: nick})
-	}
-	payload := xmlstream.Wrap(
-		xmlstream.Wrap(
-			reasonEl,
-			xml.StartElement{
-				Name: xml.Name{Local: "item"},
-				Attr: attr,
-			},
-		),
-		xml.StartElement{Name: xml.Name{Space: NSAdmin, Local: "query"}},
-	)
-	return c.session.UnmarshalIQElement(ctx, payload, stanza.IQ{
-		Type: stanza.SetIQ,
-		To:   c.addr.Bare(),
-	}, nil)
+	return c.SetAffiliations(ctx, []Item{{
+		Affiliation: a,
+		JID:         j,
+		Nick:        nick,
+		Reason:      reason,
+	}})
 }
 
 // Join is like the Join function except that it joins or re-synchronizes the
@@ -196,7 +178,6 @@ func (c *Channel) JoinPresence(ctx context.Context, p stanza.Presence, opt ...Op
 	if p.ID == "" {
 		p.ID = attr.RandomID()
 	}
-	p.To = c.addr
 
 	conf := config{}
 	for _, o := range opt {
@@ -210,7 +191,14 @@ func (c *Channel) JoinPresence(ctx context.Context, p stanza.Presence, opt ...Op
 		}
 		c.addr = newAddr
 	}
+	p.To = c.addr
+
+	return c.sendSelfPresence(ctx, p, conf.TokenReader())
+}
 
+// sendSelfPresence sends a presence to the channel and blocks until the
+// channel responds with a self-presence or an error.
+func (c *Channel) sendSelfPresence(ctx context.Context, p stanza.Presence, payload xml.TokenReader) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -228,7 +216,7 @@ func (c *Channel) JoinPresence(ctx context.Context, p stanza.Presence, opt ...Op
 	go func(errChan chan<- error) {
 		defer cancel()
 
-		resp, err := c.session.SendPresenceElement(ctx, conf.TokenReader(), p)
+		resp, err := c.session.SendPresenceElement(ctx, payload, p)
 		if err != nil {
 			select {
 			case errChan <- err:
@@ -0,0 +1,187 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"strconv"
+
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// NSRoomConfig is the form type used for room configuration forms.
+const NSRoomConfig = `http://jabber.org/protocol/muc#roomconfig`
+
+// RoomConfig is the configuration of a room hosted by a Service.
+type RoomConfig struct {
+	Name        string
+	Description string
+
+	// Persistent rooms are saved to the store and are not destroyed when the last
+	// occupant leaves.
+	Persistent bool
+
+	// Public rooms are listed in service discovery item queries.
+	Public bool
+
+	// MembersOnly rooms may only be joined by users with an affiliation of
+	// member or higher.
+	MembersOnly bool
+
+	// Moderated rooms only allow occupants with voice to send messages and new
+	// occupants without an affiliation join as visitors.
+	Moderated bool
+
+	// NonAnonymous rooms show the real JIDs of occupants to all other occupants
+	// instead of only to moderators.
+	NonAnonymous bool
+
+	// ChangeSubject allows participants to change the subject in addition to
+	// moderators.
+	ChangeSubject bool
+
+	// Password is required to enter the room if it is not empty.
+	Password string
+
+	// MaxUsers is the maximum number of occupants that may be in the room at the
+	// same time, not counting owners and admins.
+	// If it is zero, there is no limit.
+	MaxUsers uint64
+
+	// MaxHistory is the maximum number of messages kept to send to new
+	// occupants.
+	MaxHistory uint64
+}
+
+// DefaultRoomConfig is the configuration used for new rooms if a Service does
+// not set a default.
+var DefaultRoomConfig = RoomConfig{
+	Public:     true,
+	MaxHistory: 20,
+}
+
+func formBool(b bool) form.Option {
+	if b {
+		return form.Value("1")
+	}
+	return form.Value("0")
+}
+
+// Form returns a room configuration form with its fields set to the values of
+// the configuration.
+func (c RoomConfig) Form() *form.Data {
+	whois := "moderators"
+	if c.NonAnonymous {
+		whois = "anyone"
+	}
+	return form.New(
+		form.Hidden("FORM_TYPE", form.Value(NSRoomConfig)),
+		form.Text("muc#roomconfig_roomname", form.Label("Natural-Language Room Name"), form.Value(c.Name)),
+		form.Text("muc#roomconfig_roomdesc", form.Label("Short Description of Room"), form.Value(c.Description)),
+		form.Boolean("muc#roomconfig_persistentroom", form.Label("Make Room Persistent?"), formBool(c.Persistent)),
+		form.Boolean("muc#roomconfig_publicroom", form.Label("Make Room Publicly Searchable?"), formBool(c.Public)),
+		form.Boolean("muc#roomconfig_membersonly", form.Label("Make Room Members-Only?"), formBool(c.MembersOnly)),
+		form.Boolean("muc#roomconfig_moderatedroom", form.Label("Make Room Moderated?"), formBool(c.Moderated)),
+		form.List("muc#roomconfig_whois",
+			form.Label("Who May Discover Real JIDs?"),
+			form.Value(whois),
+			form.ListItem("Moderators Only", "moderators"),
+			form.ListItem("Anyone", "anyone"),
+		),
+		form.Boolean("muc#roomconfig_changesubject", form.Label("Allow Occupants to Change Subject?"), formBool(c.ChangeSubject)),
+		form.Boolean("muc#roomconfig_passwordprotectedroom", form.Label("Password Required for Entry?"), formBool(c.Password != "")),
+		form.TextPrivate("muc#roomconfig_roomsecret", form.Label("Password"), form.Value(c.Password)),
+		form.Text("muc#roomconfig_maxusers",
+			form.Label("Maximum Number of Occupants (0 for no limit)"),
+			form.Value(strconv.FormatUint(c.MaxUsers, 10)),
+		),
+		form.Text("muc#maxhistoryfetch",
+			form.Label("Maximum Number of History Messages Returned by Room"),
+			form.Value(strconv.FormatUint(c.MaxHistory, 10)),
+		),
+	)
+}
+
+var errBadRequest = stanza.Error{
+	Type:      stanza.Modify,
+	Condition: stanza.BadRequest,
+}
+
+// apply sets any fields from a submitted configuration form on the
+// configuration.
+// Fields that are not present in the form are left unchanged.
+func (c *RoomConfig) apply(data *form.Data) error {
+	if typ, ok := data.Raw("FORM_TYPE"); ok && (len(typ) != 1 || typ[0] != NSRoomConfig) {
+		return errBadRequest
+	}
+	single := func(id string) (string, bool) {
+		v, ok := data.Raw(id)
+		if !ok || len(v) == 0 {
+			return "", false
+		}
+		return v[0], true
+	}
+	number := func(id string, u *uint64) error {
+		v, ok := single(id)
+		if !ok {
+			return nil
+		}
+		parsed, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return errBadRequest
+		}
+		*u = parsed
+		return nil
+	}
+
+	if v, ok := single("muc#roomconfig_roomname"); ok {
+		c.Name = v
+	}
+	if v, ok := single("muc#roomconfig_roomdesc"); ok {
+		c.Description = v
+	}
+	if v, ok := single("muc#roomconfig_whois"); ok {
+		switch v {
+		case "moderators":
+			c.NonAnonymous = false
+		case "anyone":
+			c.NonAnonymous = true
+		default:
+			return errBadRequest
+		}
+	}
+	if v, ok := single("muc#roomconfig_roomsecret"); ok {
+		c.Password = v
+	}
+	protected := c.Password != ""
+	for id, b := range map[string]*bool{
+		"muc#roomconfig_persistentroom":        &c.Persistent,
+		"muc#roomconfig_publicroom":            &c.Public,
+		"muc#roomconfig_membersonly":           &c.MembersOnly,
+		"muc#roomconfig_moderatedroom":         &c.Moderated,
+		"muc#roomconfig_changesubject":         &c.ChangeSubject,
+		"muc#roomconfig_passwordprotectedroom": &protected,
+	} {
+		v, ok := single(id)
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return errBadRequest
+		}
+		*b = parsed
+	}
+	if !protected {
+		c.Password = ""
+	} else if c.Password == "" {
+		return stanza.Error{Type: stanza.Modify, Condition: stanza.NotAcceptable}
+	}
+	err := number("muc#roomconfig_maxusers", &c.MaxUsers)
+	if err != nil {
+		return err
+	}
+	return number("muc#maxhistoryfetch", &c.MaxHistory)
+}
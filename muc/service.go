@@ -0,0 +1,1320 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/delay"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/disco/items"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// HandleService returns an option that registers a Service to handle presence,
+// messages, and requests sent to the rooms that it hosts.
+//
+// The service answers service discovery requests itself since the response
+// depends on which room the request was sent to, so disco.Handle should not be
+// registered on the same multiplexer.
+func HandleService(s *Service) mux.Option {
+	return func(m *mux.ServeMux) {
+		mux.PresenceFunc(stanza.AvailablePresence, xml.Name{Space: NS, Local: "x"}, s.handleJoin)(m)
+		mux.PresenceFunc(stanza.AvailablePresence, xml.Name{}, s.handlePresence)(m)
+		mux.PresenceFunc(stanza.UnavailablePresence, xml.Name{}, s.handlePresence)(m)
+		h := &messageHandler{s: s, m: m}
+		mux.Message(stanza.GroupChatMessage, xml.Name{}, h)(m)
+		mux.Message(stanza.ChatMessage, xml.Name{}, h)(m)
+		for _, name := range []xml.Name{
+			{Space: NSOwner, Local: "query"},
+			{Space: NSAdmin, Local: "query"},
+		} {
+			mux.IQ(stanza.GetIQ, name, s)(m)
+			mux.IQ(stanza.SetIQ, name, s)(m)
+		}
+		mux.IQ(stanza.GetIQ, xml.Name{Space: disco.NSInfo, Local: "query"}, s)(m)
+		mux.IQ(stanza.GetIQ, xml.Name{Space: disco.NSItems, Local: "query"}, s)(m)
+	}
+}
+
+// Service is a multi-user chat service that hosts rooms.
+//
+// Rooms are created when the first user joins them and that user becomes the
+// owner of the room.
+// New rooms are unlocked immediately using the default configuration (an
+// instant room) and may be reconfigured by the owner afterwards.
+// Temporary rooms are destroyed when the last occupant leaves, and rooms that
+// are configured to be persistent are saved to the Store.
+//
+// Presence and messages are written to the stream on which the stanza that
+// triggered them was received unless Send is set.
+// This is suitable for services running as a component where the server routes
+// all outgoing stanzas.
+type Service struct {
+	// Store contains the persistent rooms hosted by the service.
+	// If it is nil, an in-memory store created with NewStore is used.
+	Store Store
+
+	// Name is the name of the service returned in service discovery responses.
+	Name string
+
+	// DefaultConfig is the configuration of new rooms.
+	// If it is nil, DefaultRoomConfig is used.
+	DefaultConfig *RoomConfig
+
+	// Send, if set, is used to transmit outgoing stanzas.
+	Send func(ctx context.Context, r xml.TokenReader) error
+
+	mu    sync.Mutex
+	rooms map[string]*liveRoom
+}
+
+type serviceOccupant struct {
+	nick   string
+	jid    jid.JID
+	role   Role
+	show   string
+	status string
+}
+
+type historyMessage struct {
+	id      string
+	nick    string
+	stamp   time.Time
+	payload []byte
+}
+
+// liveRoom is the state of a room including its current occupants.
+type liveRoom struct {
+	Room
+	created   bool
+	occupants []*serviceOccupant
+	history   []historyMessage
+}
+
+func (r *liveRoom) occupant(nick string) *serviceOccupant {
+	for _, o := range r.occupants {
+		if o.nick == nick {
+			return o
+		}
+	}
+	return nil
+}
+
+func (r *liveRoom) occupantByJID(j jid.JID) *serviceOccupant {
+	for _, o := range r.occupants {
+		if o.jid.Equal(j) {
+			return o
+		}
+	}
+	return nil
+}
+
+func (r *liveRoom) remove(o *serviceOccupant) {
+	for i, occupant := range r.occupants {
+		if occupant == o {
+			r.occupants = append(r.occupants[:i], r.occupants[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *liveRoom) affiliation(o *serviceOccupant) Affiliation {
+	return r.Affiliation(o.jid)
+}
+
+// defaultRole returns the role of a new occupant with the given affiliation.
+func (r *liveRoom) defaultRole(a Affiliation) Role {
+	switch {
+	case a == AffiliationOwner || a == AffiliationAdmin:
+		return RoleModerator
+	case a == AffiliationNone && r.Config.Moderated:
+		return RoleVisitor
+	}
+	return RoleParticipant
+}
+
+// rank orders affiliations by their privileges.
+func rank(a Affiliation) int {
+	switch a {
+	case AffiliationOwner:
+		return 4
+	case AffiliationAdmin:
+		return 3
+	case AffiliationMember:
+		return 2
+	case AffiliationOutcast:
+		return 0
+	}
+	return 1
+}
+
+var (
+	errForbidden = stanza.Error{
+		Type:      stanza.Auth,
+		Condition: stanza.Forbidden,
+	}
+	errNotAllowed = stanza.Error{
+		Type:      stanza.Cancel,
+		Condition: stanza.NotAllowed,
+	}
+	errNotAcceptable = stanza.Error{
+		Type:      stanza.Modify,
+		Condition: stanza.NotAcceptable,
+	}
+	errConflict = stanza.Error{
+		Type:      stanza.Cancel,
+		Condition: stanza.Conflict,
+	}
+)
+
+// outbox collects stanzas that are sent after a request has been handled.
+type outbox []xml.TokenReader
+
+func (o *outbox) add(r xml.TokenReader) {
+	*o = append(*o, r)
+}
+
+func (s *Service) flush(ctx context.Context, w xmlstream.TokenWriter, out outbox) error {
+	for _, r := range out {
+		var err error
+		if s.Send != nil {
+			err = s.Send(ctx, r)
+		} else {
+			_, err = xmlstream.Copy(w, r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) defaultConfig() RoomConfig {
+	if s.DefaultConfig != nil {
+		return *s.DefaultConfig
+	}
+	return DefaultRoomConfig
+}
+
+// store returns the store of persistent rooms, defaulting to an in-memory
+// store if none was set.
+// It must be called with mu held.
+func (s *Service) store() Store {
+	if s.Store == nil {
+		s.Store = NewStore()
+	}
+	return s.Store
+}
+
+// room returns the live room with the provided address, loading it from the
+// store if it is persistent.
+// It must be called with mu held.
+func (s *Service) room(ctx context.Context, addr jid.JID) (*liveRoom, error) {
+	key := addr.Bare().String()
+	if r, ok := s.rooms[key]; ok {
+		return r, nil
+	}
+	stored, err := s.store().Room(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	r := &liveRoom{Room: stored}
+	if s.rooms == nil {
+		s.rooms = make(map[string]*liveRoom)
+	}
+	s.rooms[key] = r
+	return r, nil
+}
+
+// save persists the room if it is configured to be persistent and removes it
+// from the store otherwise.
+func (s *Service) save(ctx context.Context, r *liveRoom) error {
+	if r.Config.Persistent {
+		return s.store().SetRoom(ctx, r.Room)
+	}
+	err := s.store().DeleteRoom(ctx, r.Addr)
+	if errors.Is(err, errItemNotFound) {
+		return nil
+	}
+	return err
+}
+
+// cleanup destroys temporary rooms once the last occupant has left.
+func (s *Service) cleanup(r *liveRoom) {
+	if len(r.occupants) == 0 && !r.Config.Persistent {
+		delete(s.rooms, r.Addr.String())
+	}
+}
+
+// presenceInfo contains the details of an occupant presence that is sent to
+// the occupants of a room.
+type presenceInfo struct {
+	id        string
+	typ       stanza.PresenceType
+	codes     []Status
+	selfCodes []Status
+	newNick   string
+	reason    string
+	actor     string
+	destroy   xml.TokenReader
+}
+
+// presence returns a presence from occupant o addressed to occupant to.
+func (r *liveRoom) presence(o, to *serviceOccupant, pi presenceInfo) xml.TokenReader {
+	from, _ := r.Addr.WithResource(o.nick)
+	role := o.role
+	if pi.typ == stanza.UnavailablePresence && pi.newNick == "" {
+		role = RoleNone
+	}
+	attrs := []xml.Attr{
+		{Name: xml.Name{Local: "affiliation"}, Value: r.affiliation(o).String()},
+		{Name: xml.Name{Local: "role"}, Value: role.String()},
+	}
+	if r.Config.NonAnonymous || to.role == RoleModerator || to == o {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "jid"}, Value: o.jid.String()})
+	}
+	if pi.newNick != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "nick"}, Value: pi.newNick})
+	}
+	var actor xml.TokenReader
+	if pi.actor != "" {
+		actor = xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "actor"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "nick"}, Value: pi.actor}},
+		})
+	}
+
+	codes := pi.codes
+	if to == o {
+		codes = append(append([]Status{StatusSelf}, pi.selfCodes...), codes...)
+	}
+	x := []xml.TokenReader{xmlstream.Wrap(
+		xmlstream.MultiReader(actor, reasonReader(pi.reason)),
+		xml.StartElement{Name: xml.Name{Local: "item"}, Attr: attrs},
+	)}
+	if pi.destroy != nil {
+		x = append(x, pi.destroy)
+	}
+	for _, code := range codes {
+		x = append(x, statusReader(code))
+	}
+
+	var show, status xml.TokenReader
+	if pi.typ != stanza.UnavailablePresence {
+		show = optionalString(o.show, xml.Name{Local: "show"})
+		status = optionalString(o.status, xml.Name{Local: "status"})
+	}
+	var id string
+	if to == o {
+		id = pi.id
+	}
+	return stanza.Presence{
+		ID:   id,
+		From: from,
+		To:   to.jid,
+		Type: pi.typ,
+	}.Wrap(xmlstream.MultiReader(
+		show,
+		status,
+		xmlstream.Wrap(
+			xmlstream.MultiReader(x...),
+			xml.StartElement{Name: xml.Name{Space: NSUser, Local: "x"}},
+		),
+	))
+}
+
+func statusReader(code Status) xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "status"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "code"}, Value: strconv.Itoa(int(code))}},
+	})
+}
+
+// broadcast queues a presence from occupant o for every occupant of the room.
+func (r *liveRoom) broadcast(out *outbox, o *serviceOccupant, pi presenceInfo) {
+	for _, to := range r.occupants {
+		out.add(r.presence(o, to, pi))
+	}
+}
+
+// statusMessage queues a message with the provided status codes for every
+// occupant of the room.
+func (r *liveRoom) statusMessage(out *outbox, codes ...Status) {
+	x := make([]xml.TokenReader, 0, len(codes))
+	for _, code := range codes {
+		x = append(x, statusReader(code))
+	}
+	for _, to := range r.occupants {
+		out.add(stanza.Message{
+			From: r.Addr,
+			To:   to.jid,
+			Type: stanza.GroupChatMessage,
+		}.Wrap(xmlstream.Wrap(
+			xmlstream.MultiReader(x...),
+			xml.StartElement{Name: xml.Name{Space: NSUser, Local: "x"}},
+		)))
+	}
+}
+
+// evict removes an occupant from the room and informs everyone including the
+// occupant.
+func (r *liveRoom) evict(out *outbox, o *serviceOccupant, pi presenceInfo) {
+	pi.typ = stanza.UnavailablePresence
+	r.broadcast(out, o, pi)
+	r.remove(o)
+}
+
+func presenceError(p stanza.Presence, err error) xml.TokenReader {
+	return stanza.Presence{
+		ID:   p.ID,
+		From: p.To,
+		To:   p.From,
+		Type: stanza.ErrorPresence,
+	}.Wrap(xmlstream.MultiReader(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: NS, Local: "x"}}),
+		toStanzaError(err).TokenReader(),
+	))
+}
+
+func messageError(m stanza.Message, err error) xml.TokenReader {
+	return stanza.Message{
+		ID:   m.ID,
+		From: m.To,
+		To:   m.From,
+		Type: stanza.ErrorMessage,
+	}.Wrap(toStanzaError(err).TokenReader())
+}
+
+func toStanzaError(err error) stanza.Error {
+	stanzaErr := stanza.Error{}
+	if errors.As(err, &stanzaErr) {
+		return stanzaErr
+	}
+	return stanza.Error{
+		Type:      stanza.Wait,
+		Condition: stanza.InternalServerError,
+	}
+}
+
+type servicePresence struct {
+	stanza.Presence
+	Show   string  `xml:"show"`
+	Status string  `xml:"status"`
+	X      *config `xml:"http://jabber.org/protocol/muc x"`
+}
+
+func (s *Service) handleJoin(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+	return s.presence(p, r, true)
+}
+
+func (s *Service) handlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+	return s.presence(p, r, false)
+}
+
+// presence handles all presence sent to a room.
+// Since the multiplexer calls presence handlers once for every child element
+// all changes are idempotent, and joins are only handled by the handler
+// registered for the MUC payload.
+func (s *Service) presence(p stanza.Presence, r xmlstream.TokenReadEncoder, join bool) error {
+	var dec servicePresence
+	err := xml.NewTokenDecoder(r).Decode(&dec)
+	if err != nil {
+		return err
+	}
+	if !join && dec.X != nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	var out outbox
+	s.mu.Lock()
+	if p.Type == stanza.UnavailablePresence {
+		err = s.leave(ctx, p, dec, &out)
+	} else {
+		err = s.join(ctx, p, dec, &out)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		out = outbox{presenceError(p, err)}
+	}
+	return s.flush(ctx, r, out)
+}
+
+func (s *Service) join(ctx context.Context, p stanza.Presence, dec servicePresence, out *outbox) error {
+	nick := p.To.Resourcepart()
+	if nick == "" {
+		return stanza.Error{Type: stanza.Modify, Condition: stanza.JIDMalformed}
+	}
+	room, err := s.room(ctx, p.To)
+	switch {
+	case errors.Is(err, errItemNotFound):
+		room = &liveRoom{
+			Room: Room{
+				Addr:         p.To.Bare(),
+				Config:       s.defaultConfig(),
+				Affiliations: map[string]Affiliation{p.From.Bare().String(): AffiliationOwner},
+			},
+			created: true,
+		}
+		err = s.save(ctx, room)
+		if err != nil {
+			return err
+		}
+		if s.rooms == nil {
+			s.rooms = make(map[string]*liveRoom)
+		}
+		s.rooms[room.Addr.String()] = room
+	case err != nil:
+		return err
+	}
+
+	if o := room.occupantByJID(p.From); o != nil {
+		if o.nick != nick {
+			return s.changeNick(room, o, nick, out)
+		}
+		// A join presence from an existing occupant is a request to resynchronize
+		// the room state.
+		if dec.X != nil {
+			s.sendRoomState(room, o, p.ID, nil, dec.X.history, out)
+			return nil
+		}
+		if o.show == dec.Show && o.status == dec.Status {
+			return nil
+		}
+		o.show, o.status = dec.Show, dec.Status
+		room.broadcast(out, o, presenceInfo{})
+		return nil
+	}
+
+	aff := room.Affiliation(p.From)
+	if other := room.occupant(nick); other != nil {
+		return errConflict
+	}
+	switch {
+	case aff == AffiliationOutcast:
+		return errForbidden
+	case room.Config.MembersOnly && rank(aff) < rank(AffiliationMember):
+		return stanza.Error{Type: stanza.Auth, Condition: stanza.RegistrationRequired}
+	case room.Config.Password != "" && (dec.X == nil || dec.X.password != room.Config.Password):
+		return stanza.Error{Type: stanza.Auth, Condition: stanza.NotAuthorized}
+	case room.Config.MaxUsers > 0 && uint64(len(room.occupants)) >= room.Config.MaxUsers && rank(aff) < rank(AffiliationAdmin):
+		return stanza.Error{Type: stanza.Wait, Condition: stanza.ServiceUnavailable}
+	}
+
+	o := &serviceOccupant{
+		nick:   nick,
+		jid:    p.From,
+		role:   room.defaultRole(aff),
+		show:   dec.Show,
+		status: dec.Status,
+	}
+	// Send the existing occupants to the new occupant, and the new occupant to
+	// everyone else.
+	for _, other := range room.occupants {
+		out.add(room.presence(other, o, presenceInfo{}))
+	}
+	for _, other := range room.occupants {
+		out.add(room.presence(o, other, presenceInfo{}))
+	}
+	room.occupants = append(room.occupants, o)
+
+	var selfCodes []Status
+	if room.Config.NonAnonymous {
+		selfCodes = append(selfCodes, StatusNonAnonymous)
+	}
+	if room.created {
+		selfCodes = append(selfCodes, StatusCreated)
+		room.created = false
+	}
+	var history historyConfig
+	if dec.X != nil {
+		history = dec.X.history
+	}
+	s.sendRoomState(room, o, p.ID, selfCodes, history, out)
+	return nil
+}
+
+// sendRoomState sends the self-presence, history, and subject to an occupant
+// that has just joined the room.
+// The self-presence reflects the ID of the join presence.
+func (s *Service) sendRoomState(room *liveRoom, o *serviceOccupant, id string, selfCodes []Status, h historyConfig, out *outbox) {
+	out.add(room.presence(o, o, presenceInfo{id: id, selfCodes: selfCodes}))
+	for _, msg := range room.historyFor(h, o.jid) {
+		out.add(msg)
+	}
+	out.add(stanza.Message{
+		From: room.Addr,
+		To:   o.jid,
+		Type: stanza.GroupChatMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(room.Subject)),
+		xml.StartElement{Name: xml.Name{Local: "subject"}},
+	)))
+}
+
+// historyStanza returns a message from the history of the room as it is sent
+// to the occupant at to.
+func (r *liveRoom) historyStanza(msg historyMessage, to jid.JID) xml.TokenReader {
+	from, _ := r.Addr.WithResource(msg.nick)
+	return stanza.Message{
+		ID:   msg.id,
+		From: from,
+		To:   to,
+		Type: stanza.GroupChatMessage,
+	}.Wrap(xmlstream.MultiReader(
+		payloadReader(msg.payload),
+		delay.Delay{From: r.Addr, Time: msg.stamp}.TokenReader(),
+	))
+}
+
+// historyFor returns the messages from the history of the room that match the
+// history request of a new occupant at to.
+// Limits on the number of characters apply to the complete message stanzas,
+// not just their payloads.
+func (r *liveRoom) historyFor(h historyConfig, to jid.JID) []xml.TokenReader {
+	msgs := r.history
+	if h.maxStanzas != nil && uint64(len(msgs)) > *h.maxStanzas {
+		msgs = msgs[uint64(len(msgs))-*h.maxStanzas:]
+	}
+	var after time.Time
+	if h.seconds != nil {
+		after = time.Now().Add(-time.Duration(*h.seconds) * time.Second)
+	}
+	if h.since != nil {
+		since, err := time.Parse(time.RFC3339Nano, *h.since)
+		if err == nil && since.After(after) {
+			after = since
+		}
+	}
+	for len(msgs) > 0 && msgs[0].stamp.Before(after) {
+		msgs = msgs[1:]
+	}
+
+	if h.maxChars != nil {
+		// Work backwards from the most recent message, keeping messages until the
+		// limit would be exceeded.
+		var total uint64
+		i := len(msgs)
+		for i > 0 {
+			var buf bytes.Buffer
+			e := xml.NewEncoder(&buf)
+			_, err := xmlstream.Copy(e, r.historyStanza(msgs[i-1], to))
+			if err == nil {
+				err = e.Flush()
+			}
+			if err != nil {
+				break
+			}
+			total += uint64(utf8.RuneCount(buf.Bytes()))
+			if total > *h.maxChars {
+				break
+			}
+			i--
+		}
+		msgs = msgs[i:]
+	}
+	stanzas := make([]xml.TokenReader, 0, len(msgs))
+	for _, msg := range msgs {
+		stanzas = append(stanzas, r.historyStanza(msg, to))
+	}
+	return stanzas
+}
+
+func (s *Service) changeNick(room *liveRoom, o *serviceOccupant, nick string, out *outbox) error {
+	if room.occupant(nick) != nil {
+		return errConflict
+	}
+	room.broadcast(out, o, presenceInfo{
+		typ:     stanza.UnavailablePresence,
+		codes:   []Status{StatusNewNick},
+		newNick: nick,
+	})
+	o.nick = nick
+	room.broadcast(out, o, presenceInfo{})
+	return nil
+}
+
+func (s *Service) leave(ctx context.Context, p stanza.Presence, dec servicePresence, out *outbox) error {
+	room, err := s.room(ctx, p.To)
+	if err != nil {
+		// Ignore unavailable presence for rooms that don't exist.
+		return nil
+	}
+	o := room.occupantByJID(p.From)
+	if o == nil {
+		return nil
+	}
+	o.status = dec.Status
+	room.evict(out, o, presenceInfo{})
+	s.cleanup(room)
+	return nil
+}
+
+// stanzaMessage is a message sent to a room along with its payload.
+type stanzaMessage struct {
+	stanza.Message
+	payload  []byte
+	children []xml.Name
+	body     *string
+	subject  *string
+}
+
+// readMessage captures the payload of a message and the names of its child
+// elements.
+// Elements in the stanza namespace are stored without a namespace so that they
+// can be sent on streams with a different stanza namespace.
+func readMessage(m stanza.Message, r xml.TokenReader) (stanzaMessage, error) {
+	msg := stanzaMessage{Message: m}
+	tok, err := r.Token()
+	if err != nil {
+		return msg, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return msg, errBadRequest
+	}
+	ns := start.Name.Space
+	var buf bytes.Buffer
+	var depth int
+	e := xml.NewEncoder(&buf)
+	_, err = xmlstream.Copy(e, removeNS(xmlstream.Map(func(t xml.Token) xml.Token {
+		switch tt := t.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				msg.children = append(msg.children, tt.Name)
+			}
+			depth++
+			if tt.Name.Space == ns {
+				tt.Name.Space = ""
+			}
+			return tt
+		case xml.EndElement:
+			depth--
+			if tt.Name.Space == ns {
+				tt.Name.Space = ""
+			}
+			return tt
+		}
+		return t
+	})(xmlstream.Inner(r))))
+	if err != nil {
+		return msg, err
+	}
+	err = e.Flush()
+	if err != nil {
+		return msg, err
+	}
+	msg.payload = buf.Bytes()
+
+	fields := struct {
+		Body    *string `xml:"body"`
+		Subject *string `xml:"subject"`
+	}{}
+	err = xml.NewDecoder(io.MultiReader(
+		strings.NewReader("<message>"),
+		bytes.NewReader(msg.payload),
+		strings.NewReader("</message>"),
+	)).Decode(&fields)
+	msg.body, msg.subject = fields.Body, fields.Subject
+	return msg, err
+}
+
+// removeNS removes namespace declarations from payloads since they are added
+// back by the encoder. See https://mellium.im/issue/75
+var removeNS = xmlstream.RemoveAttr(func(_ xml.StartElement, attr xml.Attr) bool {
+	return attr.Name.Local == "xmlns" || attr.Name.Space == "xmlns"
+})
+
+func payloadReader(p []byte) xml.TokenReader {
+	if len(p) == 0 {
+		return nil
+	}
+	return removeNS(xml.NewDecoder(bytes.NewReader(p)))
+}
+
+// messageHandler routes groupchat and private messages to the service.
+// The multiplexer calls the handler once for every child element of a message
+// that does not have a more specific handler (or once if the message has no
+// children), so the number of remaining calls for the last message received
+// from each sender is recorded and those calls are ignored.
+type messageHandler struct {
+	s    *Service
+	m    *mux.ServeMux
+	mu   sync.Mutex
+	skip map[string]int
+}
+
+// HandleMessage implements mux.MessageHandler.
+func (h *messageHandler) HandleMessage(m stanza.Message, r xmlstream.TokenReadEncoder) error {
+	from := m.From.String()
+	h.mu.Lock()
+	if n := h.skip[from]; n > 0 {
+		if n == 1 {
+			delete(h.skip, from)
+		} else {
+			h.skip[from] = n - 1
+		}
+		h.mu.Unlock()
+		return nil
+	}
+	h.mu.Unlock()
+
+	msg, err := readMessage(m, r)
+	if err != nil {
+		return err
+	}
+	var calls int
+	for _, name := range msg.children {
+		if handler, _ := h.m.MessageHandler(m.Type, name); handler == mux.MessageHandler(h) {
+			calls++
+		}
+	}
+	if calls > 1 {
+		h.mu.Lock()
+		if h.skip == nil {
+			h.skip = make(map[string]int)
+		}
+		h.skip[from] = calls - 1
+		h.mu.Unlock()
+	}
+
+	ctx := context.Background()
+	var out outbox
+	h.s.mu.Lock()
+	if m.Type == stanza.GroupChatMessage {
+		err = h.s.broadcastMessage(ctx, msg, &out)
+	} else {
+		err = h.s.privateMessage(ctx, msg, &out)
+	}
+	h.s.mu.Unlock()
+	if err != nil {
+		out = outbox{messageError(m, err)}
+	}
+	return h.s.flush(ctx, r, out)
+}
+
+func (s *Service) broadcastMessage(ctx context.Context, msg stanzaMessage, out *outbox) error {
+	room, err := s.room(ctx, msg.To)
+	if err != nil {
+		return err
+	}
+	o := room.occupantByJID(msg.From)
+	if o == nil {
+		return errNotAcceptable
+	}
+	if o.role == RoleVisitor || o.role == RoleNone {
+		return errForbidden
+	}
+
+	if msg.body == nil && msg.subject != nil {
+		if o.role != RoleModerator && !room.Config.ChangeSubject {
+			return errForbidden
+		}
+		room.Subject = *msg.subject
+		err = s.save(ctx, room)
+		if err != nil {
+			return err
+		}
+	} else if msg.body != nil && room.Config.MaxHistory > 0 {
+		room.history = append(room.history, historyMessage{
+			id:      msg.ID,
+			nick:    o.nick,
+			stamp:   time.Now().UTC(),
+			payload: msg.payload,
+		})
+		if n := uint64(len(room.history)); n > room.Config.MaxHistory {
+			room.history = append([]historyMessage(nil), room.history[n-room.Config.MaxHistory:]...)
+		}
+	}
+
+	from, _ := room.Addr.WithResource(o.nick)
+	for _, to := range room.occupants {
+		out.add(stanza.Message{
+			ID:   msg.ID,
+			From: from,
+			To:   to.jid,
+			Type: stanza.GroupChatMessage,
+		}.Wrap(payloadReader(msg.payload)))
+	}
+	return nil
+}
+
+func (s *Service) privateMessage(ctx context.Context, msg stanzaMessage, out *outbox) error {
+	room, err := s.room(ctx, msg.To)
+	if err != nil {
+		return err
+	}
+	o := room.occupantByJID(msg.From)
+	if o == nil {
+		return errNotAcceptable
+	}
+	to := room.occupant(msg.To.Resourcepart())
+	if to == nil {
+		return errItemNotFound
+	}
+	from, _ := room.Addr.WithResource(o.nick)
+	out.add(stanza.Message{
+		ID:   msg.ID,
+		From: from,
+		To:   to.jid,
+		Type: stanza.ChatMessage,
+	}.Wrap(xmlstream.MultiReader(
+		// Remove any MUC payload sent by the occupant so that it is not
+		// duplicated.
+		xmlstream.RemoveElement(func(start xml.StartElement) bool {
+			return start.Name.Space == NSUser
+		})(payloadReader(msg.payload)),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: NSUser, Local: "x"}}),
+	)))
+	return nil
+}
+
+// HandleIQ implements mux.IQHandler.
+func (s *Service) HandleIQ(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	ctx := context.Background()
+	var out outbox
+	s.mu.Lock()
+	resp, err := s.handleIQ(ctx, iq, xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)), start, &out)
+	s.mu.Unlock()
+	if err != nil {
+		_, err = xmlstream.Copy(r, iq.Error(toStanzaError(err)))
+		return err
+	}
+	_, err = xmlstream.Copy(r, iq.Result(resp))
+	if err != nil {
+		return err
+	}
+	return s.flush(ctx, r, out)
+}
+
+func (s *Service) handleIQ(ctx context.Context, iq stanza.IQ, d *xml.Decoder, start *xml.StartElement, out *outbox) (xml.TokenReader, error) {
+	switch start.Name.Space {
+	case disco.NSInfo:
+		return s.discoInfo(ctx, iq, start)
+	case disco.NSItems:
+		return s.discoItems(ctx, iq, start)
+	}
+
+	room, err := s.room(ctx, iq.To)
+	if err != nil {
+		return nil, err
+	}
+	switch start.Name.Space {
+	case NSOwner:
+		var query struct {
+			Form    *form.Data `xml:"jabber:x:data x"`
+			Destroy *struct {
+				JID    jid.JID `xml:"jid,attr"`
+				Reason string  `xml:"reason"`
+			} `xml:"destroy"`
+		}
+		err = d.Decode(&query)
+		if err != nil {
+			return nil, errBadRequest
+		}
+		if room.Affiliation(iq.From) != AffiliationOwner {
+			return nil, errForbidden
+		}
+		switch {
+		case iq.Type == stanza.GetIQ:
+			return xmlstream.Wrap(
+				room.Config.Form().TokenReader(),
+				xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "query"}},
+			), nil
+		case query.Destroy != nil:
+			return nil, s.destroy(ctx, room, query.Destroy.JID, query.Destroy.Reason, out)
+		case query.Form != nil:
+			return nil, s.configure(ctx, room, query.Form, out)
+		}
+		return nil, errBadRequest
+	case NSAdmin:
+		var query struct {
+			Items []adminItem `xml:"item"`
+		}
+		err = d.Decode(&query)
+		if err != nil || len(query.Items) == 0 {
+			return nil, errBadRequest
+		}
+		if iq.Type == stanza.GetIQ {
+			return s.list(room, iq.From, query.Items[0])
+		}
+		return nil, s.admin(ctx, room, iq.From, query.Items, out)
+	}
+	return nil, stanza.Error{Type: stanza.Cancel, Condition: stanza.FeatureNotImplemented}
+}
+
+func (s *Service) configure(ctx context.Context, room *liveRoom, data *form.Data, out *outbox) error {
+	conf := room.Config
+	err := conf.apply(data)
+	if err != nil {
+		return err
+	}
+	wasMembersOnly := room.Config.MembersOnly
+	room.Config = conf
+	err = s.save(ctx, room)
+	if err != nil {
+		return err
+	}
+	if conf.MembersOnly && !wasMembersOnly {
+		for _, o := range append([]*serviceOccupant(nil), room.occupants...) {
+			if rank(room.affiliation(o)) < rank(AffiliationMember) {
+				room.evict(out, o, presenceInfo{codes: []Status{StatusMembersOnly}})
+			}
+		}
+	}
+	room.statusMessage(out, StatusConfigChanged)
+	return nil
+}
+
+func (s *Service) destroy(ctx context.Context, room *liveRoom, alternate jid.JID, reason string, out *outbox) error {
+	err := s.store().DeleteRoom(ctx, room.Addr)
+	if err != nil && !errors.Is(err, errItemNotFound) {
+		return err
+	}
+	var attrs []xml.Attr
+	if !alternate.Equal(jid.JID{}) {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "jid"}, Value: alternate.String()})
+	}
+	for _, o := range append([]*serviceOccupant(nil), room.occupants...) {
+		for _, to := range room.occupants {
+			out.add(room.presence(o, to, presenceInfo{
+				typ: stanza.UnavailablePresence,
+				destroy: xmlstream.Wrap(
+					reasonReader(reason),
+					xml.StartElement{Name: xml.Name{Local: "destroy"}, Attr: attrs},
+				),
+			}))
+		}
+		room.remove(o)
+	}
+	delete(s.rooms, room.Addr.String())
+	return nil
+}
+
+type adminItem struct {
+	Affiliation string  `xml:"affiliation,attr"`
+	Role        string  `xml:"role,attr"`
+	JID         jid.JID `xml:"jid,attr"`
+	Nick        string  `xml:"nick,attr"`
+	Reason      string  `xml:"reason"`
+}
+
+func parseAffiliation(s string) (Affiliation, error) {
+	var a Affiliation
+	err := a.UnmarshalXMLAttr(xml.Attr{Value: s})
+	if err != nil {
+		return a, errBadRequest
+	}
+	return a, nil
+}
+
+func parseRole(s string) (Role, error) {
+	var r Role
+	err := r.UnmarshalXMLAttr(xml.Attr{Value: s})
+	if err != nil {
+		return r, errBadRequest
+	}
+	return r, nil
+}
+
+// list returns the occupants with a role or the users with an affiliation.
+func (s *Service) list(room *liveRoom, from jid.JID, item adminItem) (xml.TokenReader, error) {
+	var listItems []xml.TokenReader
+	switch {
+	case item.Role != "":
+		role, err := parseRole(item.Role)
+		if err != nil {
+			return nil, err
+		}
+		if o := room.occupantByJID(from); o == nil || o.role != RoleModerator {
+			return nil, errForbidden
+		}
+		for _, o := range room.occupants {
+			if o.role != role {
+				continue
+			}
+			listItems = append(listItems, xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "item"},
+				Attr: []xml.Attr{
+					{Name: xml.Name{Local: "affiliation"}, Value: room.affiliation(o).String()},
+					{Name: xml.Name{Local: "jid"}, Value: o.jid.String()},
+					{Name: xml.Name{Local: "nick"}, Value: o.nick},
+					{Name: xml.Name{Local: "role"}, Value: o.role.String()},
+				},
+			}))
+		}
+	case item.Affiliation != "":
+		aff, err := parseAffiliation(item.Affiliation)
+		if err != nil {
+			return nil, err
+		}
+		if rank(room.Affiliation(from)) < rank(AffiliationAdmin) {
+			return nil, errForbidden
+		}
+		var addrs []string
+		for addr, a := range room.Affiliations {
+			if a == aff {
+				addrs = append(addrs, addr)
+			}
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			listItems = append(listItems, xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "item"},
+				Attr: []xml.Attr{
+					{Name: xml.Name{Local: "affiliation"}, Value: aff.String()},
+					{Name: xml.Name{Local: "jid"}, Value: addr},
+				},
+			}))
+		}
+	default:
+		return nil, errBadRequest
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(listItems...),
+		xml.StartElement{Name: xml.Name{Space: NSAdmin, Local: "query"}},
+	), nil
+}
+
+// admin changes the roles and affiliations of users.
+// All items are checked before any changes are made.
+func (s *Service) admin(ctx context.Context, room *liveRoom, from jid.JID, adminItems []adminItem, out *outbox) error {
+	actor := room.occupantByJID(from)
+	fromAff := room.Affiliation(from)
+	var actorNick string
+	if actor != nil {
+		actorNick = actor.nick
+	}
+
+	type roleChange struct {
+		o      *serviceOccupant
+		role   Role
+		reason string
+	}
+	type affChange struct {
+		addr   jid.JID
+		aff    Affiliation
+		reason string
+	}
+	var roles []roleChange
+	var affs []affChange
+	owners := 0
+	for _, a := range room.Affiliations {
+		if a == AffiliationOwner {
+			owners++
+		}
+	}
+
+	for _, item := range adminItems {
+		switch {
+		case item.Role != "" && item.Nick != "":
+			role, err := parseRole(item.Role)
+			if err != nil {
+				return err
+			}
+			if actor == nil || actor.role != RoleModerator {
+				return errForbidden
+			}
+			target := room.occupant(item.Nick)
+			if target == nil {
+				return errItemNotFound
+			}
+			targetAff := room.affiliation(target)
+			if rank(targetAff) >= rank(AffiliationAdmin) && rank(targetAff) >= rank(fromAff) {
+				return errNotAllowed
+			}
+			if (role == RoleModerator || target.role == RoleModerator) && rank(fromAff) < rank(AffiliationAdmin) {
+				return errNotAllowed
+			}
+			roles = append(roles, roleChange{o: target, role: role, reason: item.Reason})
+		case item.Affiliation != "" && !item.JID.Equal(jid.JID{}):
+			aff, err := parseAffiliation(item.Affiliation)
+			if err != nil {
+				return err
+			}
+			if rank(fromAff) < rank(AffiliationAdmin) {
+				return errForbidden
+			}
+			addr := item.JID.Bare()
+			current := room.Affiliation(addr)
+			if fromAff != AffiliationOwner && (rank(current) >= rank(AffiliationAdmin) || rank(aff) >= rank(AffiliationAdmin)) {
+				return errNotAllowed
+			}
+			if current == AffiliationOwner && aff != AffiliationOwner {
+				owners--
+				if owners < 1 {
+					return errConflict
+				}
+			} else if current != AffiliationOwner && aff == AffiliationOwner {
+				owners++
+			}
+			affs = append(affs, affChange{addr: addr, aff: aff, reason: item.Reason})
+		default:
+			return errBadRequest
+		}
+	}
+
+	for _, change := range roles {
+		if change.role == RoleNone {
+			room.evict(out, change.o, presenceInfo{
+				codes:  []Status{StatusKicked},
+				reason: change.reason,
+				actor:  actorNick,
+			})
+			continue
+		}
+		change.o.role = change.role
+		room.broadcast(out, change.o, presenceInfo{
+			reason: change.reason,
+			actor:  actorNick,
+		})
+	}
+	for _, change := range affs {
+		if change.aff == AffiliationNone {
+			delete(room.Affiliations, change.addr.String())
+		} else {
+			if room.Affiliations == nil {
+				room.Affiliations = make(map[string]Affiliation)
+			}
+			room.Affiliations[change.addr.String()] = change.aff
+		}
+		for _, o := range append([]*serviceOccupant(nil), room.occupants...) {
+			if !o.jid.Bare().Equal(change.addr) {
+				continue
+			}
+			pi := presenceInfo{
+				reason: change.reason,
+				actor:  actorNick,
+			}
+			switch {
+			case change.aff == AffiliationOutcast:
+				pi.codes = []Status{StatusBanned}
+				room.evict(out, o, pi)
+			case room.Config.MembersOnly && change.aff == AffiliationNone:
+				pi.codes = []Status{StatusAffiliationRemoved}
+				room.evict(out, o, pi)
+			default:
+				o.role = room.defaultRole(change.aff)
+				room.broadcast(out, o, pi)
+			}
+		}
+	}
+	s.cleanup(room)
+	if len(affs) > 0 {
+		return s.save(ctx, room)
+	}
+	return nil
+}
+
+// A list of service discovery features advertised by rooms depending on their
+// configuration.
+const (
+	featurePersistent        = "muc_persistent"
+	featureTemporary         = "muc_temporary"
+	featurePublic            = "muc_public"
+	featureHidden            = "muc_hidden"
+	featureMembersOnly       = "muc_membersonly"
+	featureOpen              = "muc_open"
+	featureModerated         = "muc_moderated"
+	featureUnmoderated       = "muc_unmoderated"
+	featureNonAnonymous      = "muc_nonanonymous"
+	featureSemiAnonymous     = "muc_semianonymous"
+	featurePasswordProtected = "muc_passwordprotected"
+	featureUnsecured         = "muc_unsecured"
+)
+
+func choose(b bool, ifTrue, ifFalse string) string {
+	if b {
+		return ifTrue
+	}
+	return ifFalse
+}
+
+func (s *Service) discoInfo(ctx context.Context, iq stanza.IQ, start *xml.StartElement) (xml.TokenReader, error) {
+	identity := disco.ConferenceText
+	features := []string{NS}
+	if iq.To.Localpart() == "" {
+		identity.Name = s.Name
+		features = append(features, disco.NSInfo, disco.NSItems)
+	} else {
+		room, err := s.room(ctx, iq.To)
+		if err != nil {
+			return nil, err
+		}
+		conf := room.Config
+		identity.Name = conf.Name
+		features = append(features,
+			choose(conf.Persistent, featurePersistent, featureTemporary),
+			choose(conf.Public, featurePublic, featureHidden),
+			choose(conf.MembersOnly, featureMembersOnly, featureOpen),
+			choose(conf.Moderated, featureModerated, featureUnmoderated),
+			choose(conf.NonAnonymous, featureNonAnonymous, featureSemiAnonymous),
+			choose(conf.Password != "", featurePasswordProtected, featureUnsecured),
+		)
+	}
+	payload := []xml.TokenReader{identity.TokenReader()}
+	for _, feature := range features {
+		payload = append(payload, info.Feature{Var: feature}.TokenReader())
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(payload...), *start), nil
+}
+
+func (s *Service) discoItems(ctx context.Context, iq stanza.IQ, start *xml.StartElement) (xml.TokenReader, error) {
+	if iq.To.Localpart() != "" {
+		_, err := s.room(ctx, iq.To)
+		if err != nil {
+			return nil, err
+		}
+		return xmlstream.Wrap(nil, *start), nil
+	}
+
+	rooms := make(map[string]RoomConfig)
+	stored, err := s.store().Rooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range stored {
+		room, err := s.room(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		rooms[room.Addr.String()] = room.Config
+	}
+	for addr, room := range s.rooms {
+		rooms[addr] = room.Config
+	}
+	addrs := make([]string, 0, len(rooms))
+	for addr, conf := range rooms {
+		if conf.Public {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	payload := make([]xml.TokenReader, 0, len(addrs))
+	for _, addr := range addrs {
+		j, err := jid.Parse(addr)
+		if err != nil {
+			continue
+		}
+		payload = append(payload, items.Item{JID: j, Name: rooms[addr].Name}.TokenReader())
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(payload...), *start), nil
+}
@@ -0,0 +1,534 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/muc"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+var (
+	serviceRoom = jid.MustParse("room@conference.example.net")
+	alice       = jid.MustParse("alice@example.net/res")
+	bob         = jid.MustParse("bob@example.net/res")
+	carol       = jid.MustParse("carol@example.net/res")
+)
+
+type serviceTest struct {
+	t   *testing.T
+	s   *xmpptest.ClientServer
+	out chan string
+}
+
+// newServiceTest returns a client connected to a multi-user chat service that
+// records all presence and messages sent by the service.
+func newServiceTest(t *testing.T) *serviceTest {
+	out := make(chan string, 100)
+	svc := &muc.Service{
+		Store: muc.NewStore(),
+		Name:  "Chatrooms",
+		Send: func(_ context.Context, r xml.TokenReader) error {
+			var buf strings.Builder
+			e := xml.NewEncoder(&buf)
+			_, err := xmlstream.Copy(e, r)
+			if err != nil {
+				return err
+			}
+			err = e.Flush()
+			if err != nil {
+				return err
+			}
+			out <- buf.String()
+			return nil
+		},
+	}
+	return &serviceTest{
+		t:   t,
+		out: out,
+		s: xmpptest.NewClientServer(
+			xmpptest.ServerHandler(mux.New(stanza.NSClient, muc.HandleService(svc))),
+		),
+	}
+}
+
+func (st *serviceTest) send(r xml.TokenReader) {
+	st.t.Helper()
+	err := st.s.Client.Send(context.Background(), r)
+	if err != nil {
+		st.t.Fatalf("error sending: %v", err)
+	}
+}
+
+func (st *serviceTest) join(from jid.JID, nick string, payload xml.TokenReader) {
+	st.t.Helper()
+	to, _ := serviceRoom.WithResource(nick)
+	st.send(stanza.Presence{From: from, To: to, ID: "join"}.Wrap(xmlstream.Wrap(
+		payload,
+		xml.StartElement{Name: xml.Name{Space: muc.NS, Local: "x"}},
+	)))
+}
+
+func (st *serviceTest) message(from jid.JID, body string) {
+	st.t.Helper()
+	st.send(stanza.Message{From: from, To: serviceRoom, Type: stanza.GroupChatMessage, ID: body}.Wrap(xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(body)),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	)))
+}
+
+// expect waits for the service to send the provided number of stanzas and
+// checks that they contain the provided strings in order.
+func (st *serviceTest) expect(contains ...[]string) {
+	st.t.Helper()
+	for i, want := range contains {
+		var got string
+		select {
+		case got = <-st.out:
+		case <-time.After(5 * time.Second):
+			st.t.Fatalf("%d: timed out waiting for stanza", i)
+		}
+		for _, s := range want {
+			if !strings.Contains(got, s) {
+				st.t.Errorf("%d: expected stanza to contain %q, got=%s", i, s, got)
+			}
+		}
+	}
+	select {
+	case got := <-st.out:
+		st.t.Errorf("unexpected stanza: %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (st *serviceTest) iq(from jid.JID, typ stanza.IQType, to jid.JID, payload xml.TokenReader, v interface{}) error {
+	return st.s.Client.UnmarshalIQElement(context.Background(), payload, stanza.IQ{
+		From: from,
+		To:   to,
+		Type: typ,
+	}, v)
+}
+
+func TestServiceJoin(t *testing.T) {
+	st := newServiceTest(t)
+
+	st.join(alice, "alice", nil)
+	st.expect(
+		[]string{`id="join"`, `from="room@conference.example.net/alice"`, `affiliation="owner"`, `role="moderator"`, `code="110"`, `code="201"`},
+		[]string{`to="alice@example.net/res"`, `<subject></subject>`},
+	)
+
+	st.join(bob, "bob", nil)
+	st.expect(
+		[]string{`to="bob@example.net/res"`, `from="room@conference.example.net/alice"`},
+		[]string{`to="alice@example.net/res"`, `from="room@conference.example.net/bob"`, `jid="bob@example.net/res"`, `role="participant"`},
+		[]string{`to="bob@example.net/res"`, `from="room@conference.example.net/bob"`, `code="110"`},
+		[]string{`to="bob@example.net/res"`, `<subject></subject>`},
+	)
+
+	// The nickname is already in use.
+	st.join(carol, "bob", nil)
+	st.expect([]string{`type="error"`, `to="carol@example.net/res"`, `<conflict`})
+
+	// Changing a nickname.
+	st.send(stanza.Presence{From: bob, To: jid.MustParse("room@conference.example.net/robert")}.Wrap(nil))
+	st.expect(
+		[]string{`to="alice@example.net/res"`, `type="unavailable"`, `nick="robert"`, `code="303"`},
+		[]string{`to="bob@example.net/res"`, `type="unavailable"`, `nick="robert"`, `code="110"`, `code="303"`},
+		[]string{`to="alice@example.net/res"`, `from="room@conference.example.net/robert"`},
+		[]string{`to="bob@example.net/res"`, `from="room@conference.example.net/robert"`, `code="110"`},
+	)
+
+	st.send(stanza.Presence{From: bob, To: jid.MustParse("room@conference.example.net/robert"), Type: stanza.UnavailablePresence}.Wrap(nil))
+	st.expect(
+		[]string{`to="alice@example.net/res"`, `type="unavailable"`, `role="none"`},
+		[]string{`to="bob@example.net/res"`, `type="unavailable"`, `code="110"`},
+	)
+}
+
+func TestServiceHistory(t *testing.T) {
+	st := newServiceTest(t)
+	st.join(alice, "alice", nil)
+	st.expect(nil, nil)
+	st.join(bob, "bob", nil)
+	st.expect(nil, nil, nil, nil)
+
+	st.message(alice, "one")
+	st.expect(
+		[]string{`id="one"`, `from="room@conference.example.net/alice"`, `to="alice@example.net/res"`, `<body>one</body>`},
+		[]string{`id="one"`, `from="room@conference.example.net/alice"`, `to="bob@example.net/res"`, `<body>one</body>`},
+	)
+	st.message(bob, "two")
+	st.expect(nil, nil)
+
+	// Messages from users that are not in the room are rejected.
+	st.message(carol, "three")
+	st.expect([]string{`type="error"`, `<not-acceptable`})
+
+	st.join(carol, "carol", xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "history"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "maxstanzas"}, Value: "1"}},
+	}))
+	st.expect(
+		nil, nil, nil, nil,
+		[]string{`to="carol@example.net/res"`, `code="110"`},
+		[]string{`to="carol@example.net/res"`, `from="room@conference.example.net/bob"`, `<body>two</body>`, `xmlns="urn:xmpp:delay"`},
+		[]string{`to="carol@example.net/res"`, `<subject></subject>`},
+	)
+}
+
+func TestServiceHistoryMaxChars(t *testing.T) {
+	st := newServiceTest(t)
+	st.join(alice, "alice", nil)
+	st.expect(nil, nil)
+	st.message(alice, "one")
+	st.expect(nil)
+	st.message(alice, "two")
+	st.expect(nil)
+
+	// The limit applies to the full stanzas including the delay element, so only
+	// one message fits even though the payloads of both messages would.
+	st.join(bob, "bob", xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "history"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "maxchars"}, Value: "300"}},
+	}))
+	st.expect(
+		nil, nil, nil,
+		[]string{`to="bob@example.net/res"`, `<body>two</body>`, `xmlns="urn:xmpp:delay"`},
+		[]string{`to="bob@example.net/res"`, `<subject></subject>`},
+	)
+
+	st.join(carol, "carol", xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "history"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "maxchars"}, Value: "0"}},
+	}))
+	st.expect(
+		nil, nil, nil, nil, nil,
+		[]string{`to="carol@example.net/res"`, `<subject></subject>`},
+	)
+}
+
+func TestServiceMessagePayloads(t *testing.T) {
+	const nsChatStates = "http://jabber.org/protocol/chatstates"
+	st := newServiceTest(t)
+	st.join(alice, "alice", nil)
+	st.expect(nil, nil)
+	st.join(bob, "bob", nil)
+	st.expect(nil, nil, nil, nil)
+
+	// Messages without a body are broadcast once even if they have several
+	// payloads.
+	st.send(stanza.Message{From: alice, To: serviceRoom, Type: stanza.GroupChatMessage, ID: "state"}.Wrap(xmlstream.MultiReader(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsChatStates, Local: "composing"}}),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: "urn:xmpp:hints", Local: "no-store"}}),
+	)))
+	st.expect(
+		[]string{`id="state"`, `to="alice@example.net/res"`, `<composing`},
+		[]string{`id="state"`, `to="bob@example.net/res"`, `<composing`},
+	)
+
+	// Users that have not joined the room may not send them.
+	st.send(stanza.Message{From: carol, To: serviceRoom, Type: stanza.GroupChatMessage}.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsChatStates, Local: "active"}}),
+	))
+	st.expect([]string{`type="error"`, `to="carol@example.net/res"`, `<not-acceptable`})
+
+	// Messages with a body and other payloads are also broadcast once.
+	st.send(stanza.Message{From: bob, To: serviceRoom, Type: stanza.GroupChatMessage, ID: "body"}.Wrap(xmlstream.MultiReader(
+		xmlstream.Wrap(xmlstream.Token(xml.CharData("hi")), xml.StartElement{Name: xml.Name{Local: "body"}}),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsChatStates, Local: "active"}}),
+	)))
+	st.expect(
+		[]string{`id="body"`, `to="alice@example.net/res"`, `<body>hi</body>`},
+		[]string{`id="body"`, `to="bob@example.net/res"`, `<body>hi</body>`},
+	)
+
+	// Private messages without a body are delivered once.
+	st.send(stanza.Message{From: alice, To: jid.MustParse("room@conference.example.net/bob"), Type: stanza.ChatMessage, ID: "private"}.Wrap(xmlstream.MultiReader(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsChatStates, Local: "composing"}}),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: "urn:xmpp:hints", Local: "no-store"}}),
+	)))
+	st.expect([]string{`id="private"`, `from="room@conference.example.net/alice"`, `to="bob@example.net/res"`, `<composing`})
+
+	// Only messages with a body are added to the history.
+	st.join(carol, "carol", nil)
+	st.expect(
+		nil, nil, nil, nil,
+		[]string{`to="carol@example.net/res"`, `code="110"`},
+		[]string{`to="carol@example.net/res"`, `<body>hi</body>`},
+		[]string{`to="carol@example.net/res"`, `<subject></subject>`},
+	)
+}
+
+func TestServiceAdmin(t *testing.T) {
+	st := newServiceTest(t)
+	st.join(alice, "alice", nil)
+	st.expect(nil, nil)
+	st.join(bob, "bob", nil)
+	st.expect(nil, nil, nil, nil)
+
+	kick := xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "nick"}, Value: "alice"},
+				{Name: xml.Name{Local: "role"}, Value: "none"},
+			},
+		}),
+		xml.StartElement{Name: xml.Name{Space: muc.NSAdmin, Local: "query"}},
+	)
+	err := st.iq(bob, stanza.SetIQ, serviceRoom, kick, nil)
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.Forbidden {
+		t.Fatalf("expected participant to be forbidden from kicking, got %v", err)
+	}
+
+	ban := xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.Wrap(xmlstream.Token(xml.CharData("spam")), xml.StartElement{Name: xml.Name{Local: "reason"}}),
+			xml.StartElement{
+				Name: xml.Name{Local: "item"},
+				Attr: []xml.Attr{
+					{Name: xml.Name{Local: "jid"}, Value: bob.Bare().String()},
+					{Name: xml.Name{Local: "affiliation"}, Value: "outcast"},
+				},
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: muc.NSAdmin, Local: "query"}},
+	)
+	err = st.iq(alice, stanza.SetIQ, serviceRoom, ban, nil)
+	if err != nil {
+		t.Fatalf("error banning: %v", err)
+	}
+	st.expect(
+		[]string{`to="alice@example.net/res"`, `type="unavailable"`, `affiliation="outcast"`, `code="301"`, `<actor nick="alice"></actor>`, `<reason>spam</reason>`},
+		[]string{`to="bob@example.net/res"`, `type="unavailable"`, `code="110"`, `code="301"`},
+	)
+
+	st.join(bob, "bob", nil)
+	st.expect([]string{`type="error"`, `<forbidden`})
+
+	var list struct {
+		Items []muc.Item `xml:"item"`
+	}
+	err = st.iq(alice, stanza.GetIQ, serviceRoom, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "affiliation"}, Value: "outcast"}},
+		}),
+		xml.StartElement{Name: xml.Name{Space: muc.NSAdmin, Local: "query"}},
+	), &list)
+	if err != nil {
+		t.Fatalf("error getting outcasts: %v", err)
+	}
+	if len(list.Items) != 1 || !list.Items[0].JID.Equal(bob.Bare()) {
+		t.Errorf("wrong list of outcasts: %+v", list.Items)
+	}
+
+	// The last owner cannot be removed.
+	err = st.iq(alice, stanza.SetIQ, serviceRoom, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "jid"}, Value: alice.Bare().String()},
+				{Name: xml.Name{Local: "affiliation"}, Value: "member"},
+			},
+		}),
+		xml.StartElement{Name: xml.Name{Space: muc.NSAdmin, Local: "query"}},
+	), nil)
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.Conflict {
+		t.Errorf("expected conflict removing the last owner, got %v", err)
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	st := newServiceTest(t)
+	st.join(alice, "alice", nil)
+	st.expect(nil, nil)
+	st.join(bob, "bob", nil)
+	st.expect(nil, nil, nil, nil)
+
+	var query struct {
+		Form form.Data `xml:"jabber:x:data x"`
+	}
+	err := st.iq(bob, stanza.GetIQ, serviceRoom, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: muc.NSOwner, Local: "query"},
+	}), &query)
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.Forbidden {
+		t.Fatalf("expected participant to be forbidden from configuring the room, got %v", err)
+	}
+	err = st.iq(alice, stanza.GetIQ, serviceRoom, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: muc.NSOwner, Local: "query"},
+	}), &query)
+	if err != nil {
+		t.Fatalf("error getting config: %v", err)
+	}
+	for id, v := range map[string]interface{}{
+		"muc#roomconfig_roomname":    "Test",
+		"muc#roomconfig_membersonly": true,
+	} {
+		_, err = query.Form.Set(id, v)
+		if err != nil {
+			t.Fatalf("error setting %s: %v", id, err)
+		}
+	}
+	submit, _ := query.Form.Submit()
+	err = st.iq(alice, stanza.SetIQ, serviceRoom, xmlstream.Wrap(submit, xml.StartElement{
+		Name: xml.Name{Space: muc.NSOwner, Local: "query"},
+	}), nil)
+	if err != nil {
+		t.Fatalf("error setting config: %v", err)
+	}
+	st.expect(
+		[]string{`to="alice@example.net/res"`, `type="unavailable"`, `code="322"`},
+		[]string{`to="bob@example.net/res"`, `type="unavailable"`, `code="322"`},
+		[]string{`to="alice@example.net/res"`, `type="groupchat"`, `code="104"`},
+	)
+
+	var info struct {
+		Identity []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"identity"`
+		Feature []struct {
+			Var string `xml:"var,attr"`
+		} `xml:"feature"`
+	}
+	err = st.iq(bob, stanza.GetIQ, serviceRoom, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: disco.NSInfo, Local: "query"},
+	}), &info)
+	if err != nil {
+		t.Fatalf("error querying room info: %v", err)
+	}
+	if len(info.Identity) != 1 || info.Identity[0].Name != "Test" {
+		t.Errorf("wrong identity: %+v", info.Identity)
+	}
+	var features []string
+	for _, f := range info.Feature {
+		features = append(features, f.Var)
+	}
+	const expectedFeatures = "http://jabber.org/protocol/muc muc_temporary muc_public muc_membersonly muc_unmoderated muc_semianonymous muc_unsecured"
+	if s := strings.Join(features, " "); s != expectedFeatures {
+		t.Errorf("wrong features:\nwant=%s,\n got=%s", expectedFeatures, s)
+	}
+
+	var items struct {
+		Item []struct {
+			JID  string `xml:"jid,attr"`
+			Name string `xml:"name,attr"`
+		} `xml:"item"`
+	}
+	err = st.iq(bob, stanza.GetIQ, serviceRoom.Domain(), xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: disco.NSItems, Local: "query"},
+	}), &items)
+	if err != nil {
+		t.Fatalf("error querying rooms: %v", err)
+	}
+	if len(items.Item) != 1 || items.Item[0].JID != serviceRoom.String() || items.Item[0].Name != "Test" {
+		t.Errorf("wrong rooms: %+v", items.Item)
+	}
+}
+
+// TestServiceClient checks that the service works with the client side of the
+// package.
+// The zero value service is used to make sure that persistent rooms are kept in
+// memory when no store is set.
+func TestServiceClient(t *testing.T) {
+	bodies := make(chan string, 10)
+	h := &muc.Client{}
+	svc := &muc.Service{}
+	serviceMux := mux.New(stanza.NSClient, muc.HandleService(svc))
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New("",
+			muc.HandleClient(h),
+			mux.MessageFunc(stanza.GroupChatMessage, xml.Name{Local: "body"}, func(m stanza.Message, r xmlstream.TokenReadEncoder) error {
+				var msg struct {
+					Body  string `xml:"body"`
+					Delay *struct {
+						Stamp string `xml:"stamp,attr"`
+					} `xml:"urn:xmpp:delay delay"`
+				}
+				err := xml.NewTokenDecoder(r).Decode(&msg)
+				if err != nil {
+					return err
+				}
+				if msg.Delay != nil {
+					msg.Body = "delayed " + msg.Body
+				}
+				bodies <- msg.Body
+				return nil
+			}),
+		)),
+		// Stamp the address of the client on stanzas like a server would do.
+		xmpptest.ServerHandler(xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "from"}, Value: alice.String()})
+			return serviceMux.HandleXMPP(t, start)
+		})),
+	)
+	ctx := context.Background()
+	room := jid.MustParse("room@conference.example.net/alice")
+
+	channel, err := h.Join(ctx, room, s.Client)
+	if err != nil {
+		t.Fatalf("error joining: %v", err)
+	}
+	occupants := channel.Occupants()
+	if len(occupants) != 1 || occupants[0].Affiliation != muc.AffiliationOwner || occupants[0].Role != muc.RoleModerator {
+		t.Fatalf("wrong occupants after joining: %+v", occupants)
+	}
+	for _, body := range []string{"one", "two"} {
+		err = s.Client.Send(ctx, stanza.Message{To: room.Bare(), Type: stanza.GroupChatMessage}.Wrap(xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(body)),
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+		)))
+		if err != nil {
+			t.Fatalf("error sending message: %v", err)
+		}
+		if got := <-bodies; got != body {
+			t.Fatalf("wrong message: want=%s, got=%s", body, got)
+		}
+	}
+
+	data, err := muc.GetConfig(ctx, room.Bare(), s.Client)
+	if err != nil {
+		t.Fatalf("error getting config: %v", err)
+	}
+	_, err = data.Set("muc#roomconfig_persistentroom", true)
+	if err != nil {
+		t.Fatalf("error setting persistent: %v", err)
+	}
+	err = muc.SetConfig(ctx, room.Bare(), data, s.Client)
+	if err != nil {
+		t.Fatalf("error setting config: %v", err)
+	}
+
+	err = channel.Leave(ctx, "")
+	if err != nil {
+		t.Fatalf("error leaving: %v", err)
+	}
+	channel, err = h.Join(ctx, room, s.Client, muc.MaxHistory(1))
+	if err != nil {
+		t.Fatalf("error rejoining: %v", err)
+	}
+	if got := <-bodies; got != "delayed two" {
+		t.Errorf("wrong history: want=delayed two, got=%s", got)
+	}
+	if _, ok := channel.Occupant("alice"); !ok {
+		t.Errorf("expected to be an occupant after rejoining")
+	}
+}
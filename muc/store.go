@@ -0,0 +1,123 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Room is the persistent state of a channel hosted by a Service.
+type Room struct {
+	// Addr is the bare address of the room.
+	Addr jid.JID
+
+	// Config is the current configuration of the room.
+	Config RoomConfig
+
+	// Affiliations maps bare JIDs (as strings) to their affiliation with the
+	// room.
+	// Users that are not in the map have no affiliation.
+	Affiliations map[string]Affiliation
+
+	// Subject is the current subject of the room.
+	Subject string
+}
+
+// Affiliation returns the affiliation of the bare JID of addr with the room.
+func (r Room) Affiliation(addr jid.JID) Affiliation {
+	return r.Affiliations[addr.Bare().String()]
+}
+
+func (r Room) copy() Room {
+	affs := make(map[string]Affiliation, len(r.Affiliations))
+	for k, v := range r.Affiliations {
+		affs[k] = v
+	}
+	r.Affiliations = affs
+	return r
+}
+
+// Store persists rooms for a Service.
+// Only rooms that are configured to be persistent are saved to the store.
+type Store interface {
+	// Room returns the room with the provided bare address.
+	// If the room does not exist an item-not-found stanza error is returned.
+	Room(ctx context.Context, addr jid.JID) (Room, error)
+
+	// SetRoom creates a room or replaces an existing room with the same address.
+	SetRoom(ctx context.Context, room Room) error
+
+	// DeleteRoom removes a room.
+	// If the room does not exist an item-not-found stanza error is returned.
+	DeleteRoom(ctx context.Context, addr jid.JID) error
+
+	// Rooms returns the addresses of all rooms in the store sorted by their
+	// string representation.
+	Rooms(ctx context.Context) ([]jid.JID, error)
+}
+
+// NewStore returns a Store that keeps all rooms in memory.
+func NewStore() Store {
+	return &memStore{
+		rooms: make(map[string]Room),
+	}
+}
+
+type memStore struct {
+	sync.Mutex
+	rooms map[string]Room
+}
+
+var errItemNotFound = stanza.Error{
+	Type:      stanza.Cancel,
+	Condition: stanza.ItemNotFound,
+}
+
+func (m *memStore) Room(_ context.Context, addr jid.JID) (Room, error) {
+	m.Lock()
+	defer m.Unlock()
+	room, ok := m.rooms[addr.Bare().String()]
+	if !ok {
+		return Room{}, errItemNotFound
+	}
+	return room.copy(), nil
+}
+
+func (m *memStore) SetRoom(_ context.Context, room Room) error {
+	m.Lock()
+	defer m.Unlock()
+	room.Addr = room.Addr.Bare()
+	m.rooms[room.Addr.String()] = room.copy()
+	return nil
+}
+
+func (m *memStore) DeleteRoom(_ context.Context, addr jid.JID) error {
+	m.Lock()
+	defer m.Unlock()
+	key := addr.Bare().String()
+	if _, ok := m.rooms[key]; !ok {
+		return errItemNotFound
+	}
+	delete(m.rooms, key)
+	return nil
+}
+
+func (m *memStore) Rooms(context.Context) ([]jid.JID, error) {
+	m.Lock()
+	defer m.Unlock()
+	addrs := make([]jid.JID, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		addrs = append(addrs, room.Addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].String() < addrs[j].String()
+	})
+	return addrs, nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// NSSCE is the namespace of XEP-0420: Stanza Content Encryption envelopes.
+const NSSCE = "urn:xmpp:sce:1"
+
+const (
+	payloadInfo = "OMEMO Payload"
+	maxPadding  = 200
+)
+
+var errBadEnvelope = errors.New("omemo: invalid stanza content encryption envelope")
+
+// sealEnvelope wraps the payload in an SCE envelope along with random padding
+// and the sender's address and serializes it.
+func sealEnvelope(payload xml.TokenReader, from jid.JID) ([]byte, error) {
+	var padLen [1]byte
+	_, err := rand.Read(padLen[:])
+	if err != nil {
+		return nil, err
+	}
+	pad := make([]byte, int(padLen[0])%maxPadding+1)
+	_, err = rand.Read(pad)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err = xmlstream.Copy(e, xmlstream.Wrap(
+		xmlstream.MultiReader(
+			xmlstream.Wrap(
+				xmlstream.ReaderFunc(func() (xml.Token, error) {
+					tok, err := payload.Token()
+					if tok != nil {
+						tok = clientNS(tok)
+					}
+					return tok, err
+				}),
+				xml.StartElement{Name: xml.Name{Local: "content"}},
+			),
+			xmlstream.Wrap(
+				xmlstream.Token(xml.CharData(base64.StdEncoding.EncodeToString(pad))),
+				xml.StartElement{Name: xml.Name{Local: "rpad"}},
+			),
+			xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "from"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: from.String()}},
+			}),
+		),
+		xml.StartElement{Name: xml.Name{Space: NSSCE, Local: "envelope"}},
+	))
+	if err != nil {
+		return nil, err
+	}
+	err = e.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// openEnvelope parses a serialized SCE envelope and returns the tokens of its
+// content.
+// If the from affix does not match the bare JID from an error is returned.
+func openEnvelope(b []byte, from jid.JID) ([]xml.Token, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || start.Name.Space != NSSCE || start.Name.Local != "envelope" {
+		return nil, errBadEnvelope
+	}
+
+	var (
+		content  []xml.Token
+		sender   jid.JID
+		haveFrom bool
+	)
+	iter := xmlstream.NewIter(d)
+	for iter.Next() {
+		child, r := iter.Current()
+		if child == nil || child.Name.Space != NSSCE {
+			continue
+		}
+		switch child.Name.Local {
+		case "content":
+			r = xmlstream.Inner(r)
+			for {
+				tok, err := r.Token()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				content = append(content, contentToken(xml.CopyToken(tok)))
+			}
+		case "from":
+			for _, attr := range child.Attr {
+				if attr.Name.Local == "jid" {
+					sender, err = jid.Parse(attr.Value)
+					if err != nil {
+						return nil, err
+					}
+					haveFrom = true
+				}
+			}
+		}
+	}
+	if err = iter.Err(); err != nil {
+		return nil, err
+	}
+	if !haveFrom || !sender.Bare().Equal(from.Bare()) {
+		return nil, errBadEnvelope
+	}
+	return content, nil
+}
+
+// clientNS puts elements in the content that do not have a namespace into the
+// client namespace so that they do not inherit the envelope namespace.
+func clientNS(t xml.Token) xml.Token {
+	switch tok := t.(type) {
+	case xml.StartElement:
+		if tok.Name.Space == "" {
+			tok.Name.Space = stanza.NSClient
+		}
+		return tok
+	case xml.EndElement:
+		if tok.Name.Space == "" {
+			tok.Name.Space = stanza.NSClient
+		}
+		return tok
+	}
+	return t
+}
+
+// contentToken removes namespace declarations from a decoded token so that
+// it can be re-encoded as part of the message that it is delivered in.
+// Elements that incorrectly inherited the envelope namespace are put back into
+// the namespace of the stanza.
+func contentToken(t xml.Token) xml.Token {
+	switch tok := t.(type) {
+	case xml.StartElement:
+		if tok.Name.Space == NSSCE {
+			tok.Name.Space = ""
+		}
+		attrs := tok.Attr[:0]
+		for _, attr := range tok.Attr {
+			if attr.Name.Local == "xmlns" || attr.Name.Space == "xmlns" {
+				continue
+			}
+			attrs = append(attrs, attr)
+		}
+		tok.Attr = attrs
+		return tok
+	case xml.EndElement:
+		if tok.Name.Space == NSSCE {
+			tok.Name.Space = ""
+		}
+		return tok
+	}
+	return t
+}
+
+// encryptPayload encrypts the serialized envelope with a new random key and
+// returns the ciphertext along with the key material that must be encrypted
+// for each recipient device.
+func encryptPayload(plaintext []byte) (ciphertext, keyMaterial []byte, err error) {
+	key := make([]byte, keySize)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	encKey, authKey, iv := payloadKeys(key)
+	ciphertext, err = cbcEncrypt(encKey, iv, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ciphertext, append(key, payloadMAC(authKey, ciphertext)...), nil
+}
+
+// decryptPayload reverses encryptPayload.
+func decryptPayload(ciphertext, keyMaterial []byte) ([]byte, error) {
+	if len(keyMaterial) != keySize+macSize {
+		return nil, errMalformed
+	}
+	encKey, authKey, iv := payloadKeys(keyMaterial[:keySize])
+	if !hmac.Equal(keyMaterial[keySize:], payloadMAC(authKey, ciphertext)) {
+		return nil, errBadMAC
+	}
+	return cbcDecrypt(encKey, iv, ciphertext)
+}
+
+func payloadKeys(key []byte) (encKey, authKey, iv []byte) {
+	out := kdf(key, nil, payloadInfo, 2*keySize+aes.BlockSize)
+	return out[:keySize], out[keySize : 2*keySize], out[2*keySize:]
+}
+
+func payloadMAC(authKey, ciphertext []byte) []byte {
+	mac := hmac.New(sha256.New, authKey)
+	/* #nosec */
+	mac.Write(ciphertext)
+	return mac.Sum(nil)[:macSize]
+}
@@ -0,0 +1,796 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package omemo implements XEP-0384: OMEMO Encryption.
+//
+// OMEMO provides end-to-end encryption between all devices of the participants
+// in a conversation.
+// Each device publishes a bundle of public keys over PEP that other devices use
+// to agree on a shared secret with X3DH, and each pair of devices then keeps a
+// Double Ratchet session that provides forward secrecy.
+// Message payloads are wrapped in an XEP-0420: Stanza Content Encryption
+// envelope and encrypted once with a random key that is then encrypted
+// separately for every device.
+//
+// Only version 2 of the protocol (namespace "urn:xmpp:omemo:2") is supported.
+//
+// # Trust
+//
+// Messages are only encrypted for devices with trusted identity keys and
+// messages from devices without trusted identity keys are rejected.
+// Keys are trusted or distrusted manually using the Authenticate and Distrust
+// methods of a Manager, and the decisions are persisted in a crypto.TrustStore
+// that may be shared with other encryption schemes.
+// Setting BlindTrust on a Manager treats keys that have not been authenticated
+// or distrusted as trusted.
+//
+// If ATM is set on a Manager, trust decisions are shared with our other
+// devices and with contacts using XEP-0450: Automatic Trust Management.
+package omemo // import "github.com/kamrankamilli/xmpp/omemo"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/crypto"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/pubsub"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Namespaces and PEP nodes used by this package.
+const (
+	NS          = "urn:xmpp:omemo:2"
+	NodeDevices = NS + ":devices"
+	NodeBundles = NS + ":bundles"
+)
+
+// Errors returned by this package.
+var (
+	ErrNoDevices    = errors.New("omemo: recipient has no trusted devices")
+	ErrNotEncrypted = errors.New("omemo: message is not encrypted for this device")
+	ErrNoSession    = errors.New("omemo: no session with the sending device")
+	ErrUntrusted    = errors.New("omemo: message sent by a device that is not trusted")
+)
+
+// Manager encrypts and decrypts messages for a single device.
+//
+// A Manager should be registered with a pubsub.Subscriptions for both NS (the
+// namespace of device list payloads) and NodeDevices (so that the correct PEP
+// notifications are requested) to keep track of the devices of other accounts.
+type Manager struct {
+	// Store persists keys and sessions.
+	// It must not be nil.
+	Store Store
+
+	// Trust persists the trust placed in identity keys.
+	// If nil, an in-memory store is used and trust decisions are lost when the
+	// program exits.
+	Trust crypto.TrustStore
+
+	// Label is an optional human readable name for the device that is shown in
+	// the device list.
+	Label string
+
+	// BlindTrust causes identity keys that have not been authenticated or
+	// distrusted to be treated as trusted.
+	BlindTrust bool
+
+	// ATM enables XEP-0450: Automatic Trust Management.
+	// Trust messages received from other devices are processed instead of being
+	// passed to the message handler, and trust messages are sent when keys are
+	// authenticated or distrusted.
+	ATM bool
+
+	// HandleError is called with any errors that occur while decrypting
+	// incoming messages.
+	// The messages are otherwise dropped.
+	HandleError func(stanza.Message, error)
+
+	mu        sync.Mutex
+	keys      *keys
+	devices   map[string][]Device
+	session   *xmpp.Session
+	trustOnce sync.Once
+}
+
+// DeviceID returns the ID of our device, generating new keys if necessary.
+func (m *Manager) DeviceID(ctx context.Context) (uint32, error) {
+	k, err := m.loadKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return k.DeviceID, nil
+}
+
+// IdentityKey returns the public identity key of our device, generating new
+// keys if necessary.
+// Other devices see the same key, so it can be compared to verify the device
+// (for example by showing a fingerprint or QR code) or sent in trust messages.
+func (m *Manager) IdentityKey(ctx context.Context) ([]byte, error) {
+	k, err := m.loadKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return k.identityPublic(), nil
+}
+
+func (m *Manager) loadKeys(ctx context.Context) (*keys, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.loadKeysLocked(ctx)
+}
+
+func (m *Manager) loadKeysLocked(ctx context.Context) (*keys, error) {
+	if m.keys != nil {
+		return m.keys, nil
+	}
+	b, err := m.Store.LoadKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		k := &keys{}
+		err = json.Unmarshal(b, k)
+		if err != nil {
+			return nil, err
+		}
+		m.keys = k
+		return k, nil
+	}
+	k, err := newKeys()
+	if err != nil {
+		return nil, err
+	}
+	err = m.saveKeysLocked(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	m.keys = k
+	return k, nil
+}
+
+func (m *Manager) saveKeysLocked(ctx context.Context, k *keys) error {
+	b, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return m.Store.SaveKeys(ctx, b)
+}
+
+// Publish publishes our bundle and adds our device to the device list of the
+// account, generating new keys if necessary.
+// It should be called once after the session is established.
+//
+// The session is remembered so that the bundle can be republished when one of
+// its one-time prekeys is used by another device.
+func (m *Manager) Publish(ctx context.Context, s *xmpp.Session) error {
+	k, err := m.loadKeys(ctx)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.session = s
+	b := k.bundle()
+	m.mu.Unlock()
+
+	err = m.trustStore().SetTrust(ctx, NS, s.LocalAddr().Bare(), k.identityPublic(), crypto.Authenticated)
+	if err != nil {
+		return err
+	}
+	_, err = pubsub.Publish(ctx, s, NodeBundles, strconv.FormatUint(uint64(k.DeviceID), 10), b.TokenReader())
+	if err != nil {
+		return err
+	}
+	return m.publishDevice(ctx, s, k.DeviceID)
+}
+
+// publishDevice adds our device to the device list of the account if it is
+// missing.
+func (m *Manager) publishDevice(ctx context.Context, s *xmpp.Session, id uint32) error {
+	devices, err := FetchDevices(ctx, s, s.LocalAddr().Bare())
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		if d.ID == id {
+			return nil
+		}
+	}
+	devices = append(devices, Device{ID: id, Label: m.Label})
+	m.setDevices(s.LocalAddr(), devices)
+	_, err = pubsub.Publish(ctx, s, NodeDevices, "current", DeviceList{Devices: devices}.TokenReader())
+	return err
+}
+
+func (m *Manager) republish(msg stanza.Message) {
+	m.mu.Lock()
+	s := m.session
+	b := m.keys.bundle()
+	id := m.keys.DeviceID
+	m.mu.Unlock()
+	if s == nil {
+		return
+	}
+	_, err := pubsub.Publish(context.Background(), s, NodeBundles, strconv.FormatUint(uint64(id), 10), b.TokenReader())
+	if err != nil {
+		m.handleError(msg, err)
+	}
+}
+
+func (m *Manager) handleError(msg stanza.Message, err error) {
+	if m.HandleError != nil {
+		m.HandleError(msg, err)
+	}
+}
+
+// FetchDevices requests the device list of the account addr.
+// If the account has not published a device list no devices and no error are
+// returned.
+func FetchDevices(ctx context.Context, s *xmpp.Session, addr jid.JID) ([]Device, error) {
+	iter := pubsub.FetchIQ(ctx, stanza.IQ{To: addr.Bare()}, s, pubsub.Query{
+		Node: NodeDevices,
+		Item: "current",
+	})
+	/* #nosec */
+	defer iter.Close()
+	var list DeviceList
+	for iter.Next() {
+		_, r := iter.Item()
+		err := xml.NewTokenDecoder(r).Decode(&list)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := iter.Err()
+	if errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+		return nil, nil
+	}
+	return list.Devices, err
+}
+
+// Devices returns the devices of the account addr.
+// Device lists that have been received in PEP notifications are used if
+// available, otherwise the device list is fetched and remembered.
+func (m *Manager) Devices(ctx context.Context, s *xmpp.Session, addr jid.JID) ([]Device, error) {
+	m.mu.Lock()
+	devices, ok := m.devices[addr.Bare().String()]
+	m.mu.Unlock()
+	if ok {
+		return devices, nil
+	}
+	devices, err := FetchDevices(ctx, s, addr)
+	if err != nil {
+		return nil, err
+	}
+	m.setDevices(addr, devices)
+	return devices, nil
+}
+
+func (m *Manager) setDevices(addr jid.JID, devices []Device) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.devices == nil {
+		m.devices = make(map[string][]Device)
+	}
+	m.devices[addr.Bare().String()] = devices
+}
+
+// HandleEvent implements pubsub.Handler by recording device lists received in
+// PEP notifications.
+func (m *Manager) HandleEvent(msg stanza.Message, e pubsub.Event) error {
+	if e.Type != pubsub.EventItems || e.Node != NodeDevices {
+		return nil
+	}
+	for e.Items.Next() {
+		id, r := e.Items.Item()
+		if id != "current" || r == nil {
+			continue
+		}
+		var list DeviceList
+		err := xml.NewTokenDecoder(r).Decode(&list)
+		if err != nil {
+			return err
+		}
+		m.setDevices(msg.From, list.Devices)
+	}
+	return e.Items.Err()
+}
+
+func (m *Manager) trustStore() crypto.TrustStore {
+	m.trustOnce.Do(func() {
+		if m.Trust == nil {
+			m.Trust = crypto.NewTrustStore()
+		}
+	})
+	return m.Trust
+}
+
+func (m *Manager) atm(own jid.JID, k *keys) *crypto.ATM {
+	return &crypto.ATM{
+		Store:      m.trustStore(),
+		Encryption: NS,
+		Addr:       own.Bare(),
+		Key:        k.identityPublic(),
+	}
+}
+
+func (m *Manager) trusted(ctx context.Context, addr jid.JID, ik []byte) (bool, error) {
+	state, err := m.trustStore().Trust(ctx, NS, addr.Bare(), ik)
+	if err != nil {
+		return false, err
+	}
+	return state == crypto.Authenticated || (state == crypto.Undecided && m.BlindTrust), nil
+}
+
+// Authenticate marks the identity key ik of the account owner as
+// authenticated after it has been verified manually (for example by comparing
+// fingerprints or scanning a QR code).
+// If ATM is enabled, trust messages announcing the decision are sent over s.
+func (m *Manager) Authenticate(ctx context.Context, s *xmpp.Session, owner jid.JID, ik []byte) error {
+	k, err := m.loadKeys(ctx)
+	if err != nil {
+		return err
+	}
+	updates, err := m.atm(s.LocalAddr(), k).Authenticate(ctx, owner, ik)
+	if err != nil {
+		return err
+	}
+	return m.sendTrust(ctx, s, updates)
+}
+
+// Distrust marks the identity key ik of the account owner as distrusted.
+// If ATM is enabled, trust messages announcing the decision are sent to our
+// other devices over s.
+func (m *Manager) Distrust(ctx context.Context, s *xmpp.Session, owner jid.JID, ik []byte) error {
+	k, err := m.loadKeys(ctx)
+	if err != nil {
+		return err
+	}
+	updates, err := m.atm(s.LocalAddr(), k).Distrust(ctx, owner, ik)
+	if err != nil {
+		return err
+	}
+	return m.sendTrust(ctx, s, updates)
+}
+
+func (m *Manager) sendTrust(ctx context.Context, s *xmpp.Session, updates []crypto.TrustUpdate) error {
+	if !m.ATM {
+		return nil
+	}
+	for _, u := range updates {
+		u := u
+		r, err := m.encrypt(ctx, s, stanza.Message{To: u.To, Type: stanza.ChatMessage}, u.Message.TokenReader(), func(addr jid.JID, ik []byte) bool {
+			if !addr.Equal(u.To) {
+				return false
+			}
+			for _, key := range u.Keys {
+				if bytes.Equal(key, ik) {
+					return true
+				}
+			}
+			return false
+		})
+		if err != nil {
+			return err
+		}
+		err = s.Send(ctx, r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) loadSession(ctx context.Context, addr jid.JID, device uint32) (*session, error) {
+	b, err := m.Store.LoadSession(ctx, addr.Bare(), device)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return unmarshalSession(b)
+}
+
+func (m *Manager) saveSession(ctx context.Context, addr jid.JID, device uint32, sess *session) error {
+	b, err := sess.marshal()
+	if err != nil {
+		return err
+	}
+	return m.Store.SaveSession(ctx, addr.Bare(), device, b)
+}
+
+func (m *Manager) fetchBundle(ctx context.Context, s *xmpp.Session, addr jid.JID, device uint32) (bundle, error) {
+	iter := pubsub.FetchIQ(ctx, stanza.IQ{To: addr.Bare()}, s, pubsub.Query{
+		Node: NodeBundles,
+		Item: strconv.FormatUint(uint64(device), 10),
+	})
+	/* #nosec */
+	defer iter.Close()
+	var (
+		b     bundle
+		found bool
+	)
+	for iter.Next() {
+		_, r := iter.Item()
+		err := xml.NewTokenDecoder(r).Decode(&b)
+		if err != nil {
+			return b, err
+		}
+		found = true
+	}
+	if err := iter.Err(); err != nil {
+		return b, err
+	}
+	if !found {
+		return b, stanza.Error{Condition: stanza.ItemNotFound}
+	}
+	return b, nil
+}
+
+type recipientDevice struct {
+	addr   jid.JID
+	device uint32
+	bundle *bundle
+}
+
+// Encrypt encrypts the payload for all trusted devices of the recipient and
+// of our own account, and returns the message with the encrypted payload.
+// The "from" affix of the encryption envelope is set to the address of s.
+//
+// If the recipient does not have any trusted devices ErrNoDevices is returned.
+// Devices whose bundles cannot be retrieved are skipped.
+func (m *Manager) Encrypt(ctx context.Context, s *xmpp.Session, msg stanza.Message, payload xml.TokenReader) (xml.TokenReader, error) {
+	return m.encrypt(ctx, s, msg, payload, nil)
+}
+
+// encrypt is like Encrypt except that if include is not nil, only devices for
+// which it returns true are encrypted to.
+func (m *Manager) encrypt(ctx context.Context, s *xmpp.Session, msg stanza.Message, payload xml.TokenReader, include func(addr jid.JID, ik []byte) bool) (xml.TokenReader, error) {
+	k, err := m.loadKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	own := s.LocalAddr()
+	recipients := []jid.JID{msg.To.Bare()}
+	if !own.Bare().Equal(msg.To.Bare()) {
+		recipients = append(recipients, own.Bare())
+	}
+
+	// Bundles for devices without a session must be fetched before taking the
+	// lock since the responses may need to be handled before it is released.
+	var devices []recipientDevice
+	for _, addr := range recipients {
+		list, err := m.Devices(ctx, s, addr)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range list {
+			if d.ID == k.DeviceID && addr.Equal(own.Bare()) {
+				continue
+			}
+			rd := recipientDevice{addr: addr, device: d.ID}
+			sess, err := m.loadSession(ctx, addr, d.ID)
+			if err != nil {
+				return nil, err
+			}
+			if sess == nil {
+				b, err := m.fetchBundle(ctx, s, addr, d.ID)
+				if err != nil {
+					continue
+				}
+				rd.bundle = &b
+			}
+			devices = append(devices, rd)
+		}
+	}
+
+	plaintext, err := sealEnvelope(payload, own)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, keyMaterial, err := encryptPayload(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	e := encrypted{SID: k.DeviceID, Payload: ciphertext}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var encryptedForRecipient bool
+	for _, rd := range devices {
+		sess, err := m.loadSession(ctx, rd.addr, rd.device)
+		if err != nil {
+			return nil, err
+		}
+		if sess == nil {
+			if rd.bundle == nil {
+				continue
+			}
+			sess, err = initiate(k, *rd.bundle)
+			if err != nil {
+				continue
+			}
+		}
+		if include != nil && !include(rd.addr, sess.IK) {
+			continue
+		}
+		ok, err := m.trusted(ctx, rd.addr, sess.IK)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		data, kex, err := sess.encrypt(keyMaterial)
+		if err != nil {
+			return nil, err
+		}
+		err = m.saveSession(ctx, rd.addr, rd.device, sess)
+		if err != nil {
+			return nil, err
+		}
+		e.addKey(rd.addr, encryptedKey{RID: rd.device, KEX: kex, Data: data})
+		if rd.addr.Equal(msg.To.Bare()) {
+			encryptedForRecipient = true
+		}
+	}
+	if !encryptedForRecipient {
+		return nil, ErrNoDevices
+	}
+	return msg.Wrap(xmlstream.MultiReader(
+		e.TokenReader(),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: "urn:xmpp:hints", Local: "store"}}),
+	)), nil
+}
+
+func (e *encrypted) addKey(addr jid.JID, key encryptedKey) {
+	for i, rk := range e.Keys {
+		if rk.JID.Equal(addr) {
+			e.Keys[i].Keys = append(e.Keys[i].Keys, key)
+			return
+		}
+	}
+	e.Keys = append(e.Keys, recipientKeys{JID: addr, Keys: []encryptedKey{key}})
+}
+
+// Send encrypts the payload and sends it in msg over s.
+// For more information see Encrypt.
+func (m *Manager) Send(ctx context.Context, s *xmpp.Session, msg stanza.Message, payload xml.TokenReader) error {
+	r, err := m.Encrypt(ctx, s, msg, payload)
+	if err != nil {
+		return err
+	}
+	return s.Send(ctx, r)
+}
+
+// decrypt returns the content of an encrypted message.
+// If the message did not have a payload (for example because it was only sent
+// to complete a key exchange) no tokens and no error are returned.
+func (m *Manager) decrypt(ctx context.Context, msg stanza.Message, e encrypted) ([]xml.Token, error) {
+	sender := msg.From.Bare()
+	m.mu.Lock()
+	k, err := m.loadKeysLocked(ctx)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	own := msg.To.Bare()
+	if m.session != nil {
+		own = m.session.LocalAddr().Bare()
+	}
+	var key *encryptedKey
+	for _, rk := range e.Keys {
+		if !rk.JID.Equal(own) {
+			continue
+		}
+		for i := range rk.Keys {
+			if rk.Keys[i].RID == k.DeviceID {
+				key = &rk.Keys[i]
+			}
+		}
+	}
+	if key == nil {
+		m.mu.Unlock()
+		return nil, ErrNotEncrypted
+	}
+
+	sess, keyMaterial, preKey, err := m.openKey(ctx, k, sender, e.SID, *key)
+	if err == nil {
+		err = m.saveSession(ctx, sender, e.SID, sess)
+	}
+	if err == nil && preKey != 0 {
+		// Replace the used one-time prekey so that it cannot be used again.
+		delete(k.PreKeys, preKey)
+		err = k.addPreKey()
+		if err == nil {
+			err = m.saveKeysLocked(ctx, k)
+		}
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if preKey != 0 {
+		go m.republish(msg)
+	}
+
+	state, err := m.trustStore().Trust(ctx, NS, sender, sess.IK)
+	if err != nil {
+		return nil, err
+	}
+	ok := state == crypto.Authenticated || (state == crypto.Undecided && m.BlindTrust)
+	// Trust messages from keys that have not been authenticated yet must still
+	// be decrypted so that they can be cached by the ATM.
+	if state == crypto.Distrusted || (!ok && (!m.ATM || e.Payload == nil)) {
+		return nil, ErrUntrusted
+	}
+	if e.Payload == nil {
+		return nil, nil
+	}
+	plaintext, err := decryptPayload(e.Payload, keyMaterial)
+	if err != nil {
+		return nil, err
+	}
+	content, err := openEnvelope(plaintext, msg.From)
+	if err != nil {
+		return nil, err
+	}
+	if m.ATM {
+		if tm, isTrust := trustMessage(content); isTrust {
+			return nil, m.atm(own, k).Receive(ctx, sender, sess.IK, tm)
+		}
+	}
+	if !ok {
+		return nil, ErrUntrusted
+	}
+	return content, nil
+}
+
+// trustMessage decodes the trust message in the decrypted content of a
+// message, if any.
+func trustMessage(content []xml.Token) (crypto.TrustMessage, bool) {
+	var tm crypto.TrustMessage
+	// Keys in trust messages are decoded from the raw inner XML, so the content
+	// has to be re-encoded before it can be unmarshaled.
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, &tokenSlice{toks: content})
+	if err == nil {
+		err = e.Flush()
+	}
+	if err != nil {
+		return tm, false
+	}
+	d := xml.NewDecoder(&buf)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return tm, false
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name != (xml.Name{Space: crypto.NSTrust, Local: "trust-message"}) {
+			err = d.Skip()
+			if err != nil {
+				return tm, false
+			}
+			continue
+		}
+		err = d.DecodeElement(&tm, &start)
+		return tm, err == nil
+	}
+}
+
+// openKey decrypts the key material for our device, creating a new session if
+// the key is part of a key exchange.
+// If a new session was created, the ID of the one-time prekey that it used is
+// returned.
+func (m *Manager) openKey(ctx context.Context, k *keys, sender jid.JID, device uint32, key encryptedKey) (sess *session, keyMaterial []byte, preKey uint32, err error) {
+	sess, err = m.loadSession(ctx, sender, device)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var am authMessage
+	if key.KEX {
+		var kex keyExchange
+		err = kex.unmarshal(key.Data)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		am = kex.message
+		// Key exchanges are repeated until we respond, so only start a new
+		// session if this is a different key exchange.
+		if sess == nil || !bytes.Equal(sess.KexEK, kex.ek) {
+			sess, err = respond(k, kex)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			preKey = kex.pkID
+		}
+	} else {
+		if sess == nil {
+			return nil, nil, 0, ErrNoSession
+		}
+		err = am.unmarshal(key.Data)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	keyMaterial, err = sess.decrypt(am)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return sess, keyMaterial, preKey, nil
+}
+
+// Handle returns an option that registers a handler for encrypted messages.
+// Messages are decrypted and passed to h with the decrypted content in place
+// of the encrypted payload.
+// Messages that cannot be decrypted are passed to the Manager's HandleError
+// function and are not passed to h.
+func Handle(m *Manager, h mux.MessageHandler) mux.Option {
+	return func(sm *mux.ServeMux) {
+		name := xml.Name{Space: NS, Local: "encrypted"}
+		handler := messageHandler{m: m, h: h}
+		mux.Message(stanza.ChatMessage, name, handler)(sm)
+		mux.Message(stanza.NormalMessage, name, handler)(sm)
+	}
+}
+
+type messageHandler struct {
+	m *Manager
+	h mux.MessageHandler
+}
+
+func (h messageHandler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	decoded := struct {
+		stanza.Message
+		Encrypted *encrypted `xml:"urn:xmpp:omemo:2 encrypted"`
+	}{}
+	err := xml.NewTokenDecoder(t).Decode(&decoded)
+	if err != nil {
+		return err
+	}
+	if decoded.Encrypted == nil {
+		return nil
+	}
+	content, err := h.m.decrypt(context.Background(), msg, *decoded.Encrypted)
+	if err != nil {
+		h.m.handleError(msg, err)
+		return nil
+	}
+	if content == nil {
+		return nil
+	}
+	return h.h.HandleMessage(msg, struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: msg.Wrap(&tokenSlice{toks: content}),
+		Encoder:     t,
+	})
+}
+
+type tokenSlice struct {
+	toks []xml.Token
+}
+
+func (t *tokenSlice) Token() (xml.Token, error) {
+	if len(t.toks) == 0 {
+		return nil, io.EOF
+	}
+	tok := t.toks[0]
+	t.toks = t.toks[1:]
+	return tok, nil
+}
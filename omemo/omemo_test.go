@@ -0,0 +1,387 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/crypto"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/omemo"
+	"github.com/kamrankamilli/xmpp/pubsub"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+func TestEncodeDeviceList(t *testing.T) {
+	xmpptest.RunEncodingTests(t, []xmpptest.EncodingTestCase{
+		0: {
+			Value: &omemo.DeviceList{
+				XMLName: xml.Name{Space: omemo.NS, Local: "devices"},
+				Devices: []omemo.Device{{ID: 12345, Label: "Phone"}, {ID: 4223}},
+			},
+			XML: `<devices xmlns="urn:xmpp:omemo:2"><device id="12345" label="Phone"></device><device id="4223"></device></devices>`,
+		},
+	})
+}
+
+type account struct {
+	addr     jid.JID
+	cs       *xmpptest.ClientServer
+	m        *omemo.Manager
+	mux      *mux.ServeMux
+	received chan string
+	errs     chan error
+	sent     chan []byte
+}
+
+// newPEP returns PEP services for each of the accounts.
+func newPEP(accounts ...string) map[string]*pubsub.Service {
+	pep := make(map[string]*pubsub.Service)
+	for _, addr := range accounts {
+		pep[addr] = &pubsub.Service{Store: pubsub.NewStore(), AutoCreate: true}
+	}
+	return pep
+}
+
+// newAccount returns a client for addr connected to a server that routes
+// pubsub requests to the PEP service of the account they are addressed to and
+// records any messages that are sent.
+func newAccount(t *testing.T, addr jid.JID, pep map[string]*pubsub.Service) *account {
+	t.Helper()
+	a := &account{
+		addr:     addr,
+		received: make(chan string, 10),
+		errs:     make(chan error, 10),
+		sent:     make(chan []byte, 10),
+	}
+	a.m = &omemo.Manager{
+		Store:      omemo.NewStore(),
+		BlindTrust: true,
+		HandleError: func(_ stanza.Message, err error) {
+			a.errs <- err
+		},
+	}
+	subs := &pubsub.Subscriptions{}
+	subs.Register(omemo.NS, a.m)
+	a.mux = mux.New("", pubsub.Handle(subs), omemo.Handle(a.m, mux.MessageHandlerFunc(func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+		msg := struct {
+			stanza.Message
+			Body string `xml:"body"`
+		}{}
+		err := xml.NewTokenDecoder(r).Decode(&msg)
+		if err != nil {
+			return err
+		}
+		a.received <- msg.Body
+		return nil
+	})))
+	a.cs = xmpptest.NewClientServer(
+		xmpptest.ClientAddr(addr),
+		xmpptest.ServerHandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			owner := addr.Bare()
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "to" && attr.Value != "" {
+					owner = jid.MustParse(attr.Value).Bare()
+				}
+			}
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "from"}, Value: addr.String()})
+			if start.Name.Local == "message" {
+				var buf bytes.Buffer
+				e := xml.NewEncoder(&buf)
+				start.Name.Space = ""
+				_, err := xmlstream.Copy(e, xmlstream.MultiReader(
+					xmlstream.Token(*start),
+					xmlstream.Inner(t),
+					xmlstream.Token(start.End()),
+				))
+				if err != nil {
+					return err
+				}
+				err = e.Flush()
+				if err != nil {
+					return err
+				}
+				a.sent <- buf.Bytes()
+				return nil
+			}
+			return mux.New(stanza.NSClient, pubsub.HandleService(pep[owner.String()])).HandleXMPP(t, start)
+		}),
+	)
+	err := a.m.Publish(context.Background(), a.cs.Client)
+	if err != nil {
+		t.Fatalf("error publishing keys for %s: %v", addr, err)
+	}
+	return a
+}
+
+// encrypt returns a serialized encrypted message from a to b.
+func (a *account) encrypt(t *testing.T, b *account, body string) ([]byte, error) {
+	t.Helper()
+	msg := stanza.Message{From: a.addr, To: b.addr.Bare(), Type: stanza.ChatMessage}
+	r, err := a.m.Encrypt(context.Background(), a.cs.Client, msg, xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(body)),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err = xmlstream.Copy(e, r)
+	if err != nil {
+		t.Fatalf("error encoding message: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing message: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// deliver passes a serialized message to the handlers of a.
+func (a *account) deliver(t *testing.T, msg []byte) {
+	t.Helper()
+	d := xml.NewDecoder(bytes.NewReader(msg))
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatalf("error reading message start: %v", err)
+	}
+	start := tok.(xml.StartElement)
+	err = a.mux.HandleXMPP(struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: d,
+		Encoder:     xml.NewEncoder(&bytes.Buffer{}),
+	}, &start)
+	if err != nil {
+		t.Fatalf("error handling message: %v", err)
+	}
+}
+
+// notify delivers a PEP notification with the current device list of owner to
+// a.
+func (a *account) notify(t *testing.T, owner jid.JID) {
+	t.Helper()
+	devices, err := omemo.FetchDevices(context.Background(), a.cs.Client, owner)
+	if err != nil {
+		t.Fatalf("error fetching devices: %v", err)
+	}
+	list, err := xml.Marshal(omemo.DeviceList{Devices: devices})
+	if err != nil {
+		t.Fatalf("error encoding devices: %v", err)
+	}
+	a.deliver(t, []byte(`<message type="headline" from="`+owner.String()+`"><event xmlns="`+pubsub.NSEvent+`"><items node="`+omemo.NodeDevices+`"><item id="current">`+string(list)+`</item></items></event></message>`))
+}
+
+// next returns the next message sent by a.
+func (a *account) next(t *testing.T) []byte {
+	t.Helper()
+	select {
+	case msg := <-a.sent:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s to send a message", a.addr)
+	}
+	return nil
+}
+
+func (a *account) expect(t *testing.T, body string) {
+	t.Helper()
+	select {
+	case got := <-a.received:
+		if got != body {
+			t.Errorf("wrong body: want=%q, got=%q", body, got)
+		}
+	case err := <-a.errs:
+		t.Errorf("error decrypting %q: %v", body, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q", body)
+	}
+}
+
+func (a *account) expectErr(t *testing.T, want error) {
+	t.Helper()
+	select {
+	case got := <-a.received:
+		t.Errorf("expected message to be rejected, got %q", got)
+	case err := <-a.errs:
+		if !errors.Is(err, want) && want != nil {
+			t.Errorf("wrong error: want=%v, got=%v", want, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for error")
+	}
+}
+
+func mustEncrypt(t *testing.T, from, to *account, body string) []byte {
+	t.Helper()
+	msg, err := from.encrypt(t, to, body)
+	if err != nil {
+		t.Fatalf("error encrypting %q: %v", body, err)
+	}
+	return msg
+}
+
+func TestConversation(t *testing.T) {
+	pep := newPEP("alice@example.net", "bob@example.net", "carol@example.net")
+	alice := newAccount(t, jid.MustParse("alice@example.net/phone"), pep)
+	bob := newAccount(t, jid.MustParse("bob@example.net/laptop"), pep)
+	ctx := context.Background()
+
+	// Key exchanges are repeated until the recipient replies.
+	bob.deliver(t, mustEncrypt(t, alice, bob, "Hello"))
+	bob.expect(t, "Hello")
+	bob.deliver(t, mustEncrypt(t, alice, bob, "Are you there?"))
+	bob.expect(t, "Are you there?")
+
+	alice.deliver(t, mustEncrypt(t, bob, alice, "Yes"))
+	alice.expect(t, "Yes")
+
+	// Messages may arrive out of order, but may not be replayed.
+	first := mustEncrypt(t, alice, bob, "first")
+	second := mustEncrypt(t, alice, bob, "second")
+	bob.deliver(t, second)
+	bob.expect(t, "second")
+	bob.deliver(t, first)
+	bob.expect(t, "first")
+	bob.deliver(t, first)
+	bob.expectErr(t, nil)
+
+	// Messages addressed to other devices are reported.
+	bobIK, err := bob.m.IdentityKey(ctx)
+	if err != nil {
+		t.Fatalf("error getting identity key: %v", err)
+	}
+	carol := newAccount(t, jid.MustParse("carol@example.net/desktop"), pep)
+	carol.deliver(t, mustEncrypt(t, alice, bob, "not for carol"))
+	carol.expectErr(t, omemo.ErrNotEncrypted)
+
+	// Distrusted devices are not encrypted to and cannot send messages.
+	err = alice.m.Distrust(ctx, alice.cs.Client, bob.addr.Bare(), bobIK)
+	if err != nil {
+		t.Fatalf("error distrusting key: %v", err)
+	}
+	_, err = alice.encrypt(t, bob, "secret")
+	if !errors.Is(err, omemo.ErrNoDevices) {
+		t.Errorf("wrong error encrypting for distrusted device: want=%v, got=%v", omemo.ErrNoDevices, err)
+	}
+	alice.deliver(t, mustEncrypt(t, bob, alice, "let me in"))
+	alice.expectErr(t, omemo.ErrUntrusted)
+}
+
+func TestNoDevices(t *testing.T) {
+	pep := newPEP("alice@example.net", "bob@example.net", "juliet@example.net")
+	alice := newAccount(t, jid.MustParse("alice@example.net/phone"), pep)
+	_, err := alice.encrypt(t, &account{addr: jid.MustParse("juliet@example.net")}, "Hello")
+	if !errors.Is(err, omemo.ErrNoDevices) {
+		t.Errorf("wrong error: want=%v, got=%v", omemo.ErrNoDevices, err)
+	}
+
+	// Without blind trust new devices must be verified first.
+	bob := newAccount(t, jid.MustParse("bob@example.net/laptop"), pep)
+	alice.m.BlindTrust = false
+	_, err = alice.encrypt(t, bob, "Hello")
+	if !errors.Is(err, omemo.ErrNoDevices) {
+		t.Errorf("wrong error for unverified device: want=%v, got=%v", omemo.ErrNoDevices, err)
+	}
+	bobIK, err := bob.m.IdentityKey(context.Background())
+	if err != nil {
+		t.Fatalf("error getting identity key: %v", err)
+	}
+	err = alice.m.Authenticate(context.Background(), alice.cs.Client, bob.addr.Bare(), bobIK)
+	if err != nil {
+		t.Fatalf("error trusting key: %v", err)
+	}
+	bob.deliver(t, mustEncrypt(t, alice, bob, "Hello"))
+	bob.expect(t, "Hello")
+}
+
+func identityKey(t *testing.T, a *account) []byte {
+	t.Helper()
+	ik, err := a.m.IdentityKey(context.Background())
+	if err != nil {
+		t.Fatalf("error getting identity key: %v", err)
+	}
+	return ik
+}
+
+func expectTrust(t *testing.T, a *account, owner jid.JID, ik []byte, want crypto.TrustState) {
+	t.Helper()
+	got, err := a.m.Trust.Trust(context.Background(), omemo.NS, owner.Bare(), ik)
+	if err != nil {
+		t.Fatalf("error loading trust: %v", err)
+	}
+	if got != want {
+		t.Errorf("wrong trust in key of %s on %s: want=%v, got=%v", owner, a.addr, want, got)
+	}
+}
+
+func TestATM(t *testing.T) {
+	pep := newPEP("alice@example.net", "bob@example.net")
+	phone := newAccount(t, jid.MustParse("alice@example.net/phone"), pep)
+	tablet := newAccount(t, jid.MustParse("alice@example.net/tablet"), pep)
+	bob := newAccount(t, jid.MustParse("bob@example.net/laptop"), pep)
+	phone.notify(t, phone.addr.Bare())
+	for _, a := range []*account{phone, tablet, bob} {
+		a.m.ATM = true
+		a.m.BlindTrust = false
+	}
+	phoneIK := identityKey(t, phone)
+	tabletIK := identityKey(t, tablet)
+	bobIK := identityKey(t, bob)
+	ctx := context.Background()
+
+	// Trust messages from devices that have not been authenticated yet are
+	// cached.
+	err := phone.m.Authenticate(ctx, phone.cs.Client, tablet.addr, tabletIK)
+	if err != nil {
+		t.Fatalf("error authenticating tablet: %v", err)
+	}
+	tablet.deliver(t, phone.next(t))
+	err = phone.m.Authenticate(ctx, phone.cs.Client, bob.addr, bobIK)
+	if err != nil {
+		t.Fatalf("error authenticating bob: %v", err)
+	}
+	tablet.deliver(t, phone.next(t))
+	bob.deliver(t, phone.next(t))
+	expectTrust(t, tablet, bob.addr, bobIK, crypto.Undecided)
+	expectTrust(t, bob, tablet.addr, tabletIK, crypto.Undecided)
+
+	// Once the sending device is authenticated its trust messages are applied.
+	err = tablet.m.Authenticate(ctx, tablet.cs.Client, phone.addr, phoneIK)
+	if err != nil {
+		t.Fatalf("error authenticating phone: %v", err)
+	}
+	expectTrust(t, tablet, bob.addr, bobIK, crypto.Authenticated)
+	phone.deliver(t, tablet.next(t))
+	bob.deliver(t, tablet.next(t))
+
+	err = bob.m.Authenticate(ctx, bob.cs.Client, phone.addr, phoneIK)
+	if err != nil {
+		t.Fatalf("error authenticating phone: %v", err)
+	}
+	expectTrust(t, bob, tablet.addr, tabletIK, crypto.Authenticated)
+
+	// Trust messages are not passed to the message handler and messages from
+	// authenticated devices are accepted.
+	select {
+	case body := <-tablet.received:
+		t.Errorf("unexpected message %q", body)
+	case err := <-tablet.errs:
+		t.Errorf("unexpected error: %v", err)
+	default:
+	}
+	tablet.deliver(t, mustEncrypt(t, bob, tablet, "verified"))
+	tablet.expect(t, "verified")
+}
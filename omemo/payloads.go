@@ -0,0 +1,262 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"strconv"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// Device is an OMEMO enabled client as advertised in a device list.
+type Device struct {
+	ID    uint32 `xml:"id,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (d Device) TokenReader() xml.TokenReader {
+	attrs := []xml.Attr{{
+		Name:  xml.Name{Local: "id"},
+		Value: strconv.FormatUint(uint64(d.ID), 10),
+	}}
+	if d.Label != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "label"}, Value: d.Label})
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "device"},
+		Attr: attrs,
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (d Device) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, d.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (d Device) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := d.WriteXML(e)
+	return err
+}
+
+// DeviceList is the list of devices published by an account.
+type DeviceList struct {
+	XMLName xml.Name `xml:"urn:xmpp:omemo:2 devices"`
+	Devices []Device `xml:"device"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (l DeviceList) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, d := range l.Devices {
+		inner = append(inner, d.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "devices"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (l DeviceList) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, l.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (l DeviceList) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := l.WriteXML(e)
+	return err
+}
+
+func b64Element(local string, b []byte, attr ...xml.Attr) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(base64.StdEncoding.EncodeToString(b))),
+		xml.StartElement{Name: xml.Name{Local: local}, Attr: attr},
+	)
+}
+
+func idAttr(local string, id uint32) xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: local}, Value: strconv.FormatUint(uint64(id), 10)}
+}
+
+// b64 is binary data that is base64 encoded in XML.
+type b64 []byte
+
+func (b *b64) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var s string
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	*b, err = base64.StdEncoding.DecodeString(s)
+	return err
+}
+
+type preKey struct {
+	ID  uint32
+	Key b64
+}
+
+func (pk *preKey) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "id" {
+			id, err := strconv.ParseUint(attr.Value, 10, 32)
+			if err != nil {
+				return err
+			}
+			pk.ID = uint32(id)
+		}
+	}
+	return pk.Key.UnmarshalXML(d, start)
+}
+
+// bundle contains the public keys that other devices need to establish a
+// session with a device.
+type bundle struct {
+	SPKID   uint32
+	SPK     []byte
+	SPKSig  []byte
+	IK      []byte
+	PreKeys []preKey
+}
+
+func (b bundle) TokenReader() xml.TokenReader {
+	var pks []xml.TokenReader
+	for _, pk := range b.PreKeys {
+		pks = append(pks, b64Element("pk", pk.Key, idAttr("id", pk.ID)))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(
+			b64Element("spk", b.SPK, idAttr("id", b.SPKID)),
+			b64Element("spks", b.SPKSig),
+			b64Element("ik", b.IK),
+			xmlstream.Wrap(
+				xmlstream.MultiReader(pks...),
+				xml.StartElement{Name: xml.Name{Local: "prekeys"}},
+			),
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "bundle"}},
+	)
+}
+
+func (b *bundle) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	decoded := struct {
+		SPK     preKey   `xml:"spk"`
+		SPKSig  b64      `xml:"spks"`
+		IK      b64      `xml:"ik"`
+		PreKeys []preKey `xml:"prekeys>pk"`
+	}{}
+	err := d.DecodeElement(&decoded, &start)
+	if err != nil {
+		return err
+	}
+	b.SPKID = decoded.SPK.ID
+	b.SPK = decoded.SPK.Key
+	b.SPKSig = decoded.SPKSig
+	b.IK = decoded.IK
+	b.PreKeys = decoded.PreKeys
+	if len(b.SPK) != keySize || len(b.IK) != keySize {
+		return errBadKey
+	}
+	return nil
+}
+
+// encryptedKey is a message key encrypted for a single device.
+type encryptedKey struct {
+	RID  uint32
+	KEX  bool
+	Data []byte
+}
+
+// recipientKeys are the keys encrypted for the devices of a single account.
+type recipientKeys struct {
+	JID  jid.JID
+	Keys []encryptedKey
+}
+
+// encrypted is the element that replaces the payload of an encrypted message.
+type encrypted struct {
+	SID     uint32
+	Keys    []recipientKeys
+	Payload []byte
+}
+
+func (e encrypted) TokenReader() xml.TokenReader {
+	var keys []xml.TokenReader
+	for _, rk := range e.Keys {
+		var inner []xml.TokenReader
+		for _, k := range rk.Keys {
+			attrs := []xml.Attr{idAttr("rid", k.RID)}
+			if k.KEX {
+				attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "kex"}, Value: "true"})
+			}
+			inner = append(inner, b64Element("key", k.Data, attrs...))
+		}
+		keys = append(keys, xmlstream.Wrap(
+			xmlstream.MultiReader(inner...),
+			xml.StartElement{
+				Name: xml.Name{Local: "keys"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: rk.JID.String()}},
+			},
+		))
+	}
+	var payload xml.TokenReader
+	if e.Payload != nil {
+		payload = b64Element("payload", e.Payload)
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(
+			xmlstream.Wrap(
+				xmlstream.MultiReader(keys...),
+				xml.StartElement{Name: xml.Name{Local: "header"}, Attr: []xml.Attr{idAttr("sid", e.SID)}},
+			),
+			payload,
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "encrypted"}},
+	)
+}
+
+func (e *encrypted) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	decoded := struct {
+		Header struct {
+			SID  uint32 `xml:"sid,attr"`
+			Keys []struct {
+				JID  jid.JID `xml:"jid,attr"`
+				Keys []struct {
+					RID  uint32 `xml:"rid,attr"`
+					KEX  bool   `xml:"kex,attr"`
+					Data string `xml:",chardata"`
+				} `xml:"key"`
+			} `xml:"keys"`
+		} `xml:"header"`
+		Payload *b64 `xml:"payload"`
+	}{}
+	err := d.DecodeElement(&decoded, &start)
+	if err != nil {
+		return err
+	}
+	e.SID = decoded.Header.SID
+	e.Keys = e.Keys[:0]
+	for _, rk := range decoded.Header.Keys {
+		keys := recipientKeys{JID: rk.JID}
+		for _, k := range rk.Keys {
+			data, err := base64.StdEncoding.DecodeString(k.Data)
+			if err != nil {
+				return err
+			}
+			keys.Keys = append(keys.Keys, encryptedKey{RID: k.RID, KEX: k.KEX, Data: data})
+		}
+		e.Keys = append(e.Keys, keys)
+	}
+	e.Payload = nil
+	if decoded.Payload != nil {
+		e.Payload = *decoded.Payload
+	}
+	return nil
+}
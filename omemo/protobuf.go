@@ -0,0 +1,187 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The OMEMO wire format uses a small subset of protocol buffers: unsigned
+// integers and byte strings.
+// Rather than depending on a protobuf library, the three messages are encoded
+// and decoded by hand.
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+var errMalformed = errors.New("omemo: malformed message")
+
+func appendUint(b []byte, field int, v uint32) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireVarint))
+	return binary.AppendUvarint(b, uint64(v))
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireBytes))
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// parseFields calls f for each field in b.
+// Exactly one of v and data is meaningful depending on the wire type.
+func parseFields(b []byte, f func(field int, v uint32, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 || v > 1<<32-1 {
+				return errMalformed
+			}
+			b = b[n:]
+			if err := f(field, uint32(v), nil); err != nil {
+				return err
+			}
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errMalformed
+			}
+			data := b[n : n+int(l)]
+			b = b[n+int(l):]
+			if err := f(field, 0, data); err != nil {
+				return err
+			}
+		default:
+			return errMalformed
+		}
+	}
+	return nil
+}
+
+// message is an OMEMOMessage.
+type message struct {
+	n          uint32
+	pn         uint32
+	dhPub      []byte
+	ciphertext []byte
+}
+
+func (m message) marshal() []byte {
+	var b []byte
+	b = appendUint(b, 1, m.n)
+	b = appendUint(b, 2, m.pn)
+	b = appendBytes(b, 3, m.dhPub)
+	if m.ciphertext != nil {
+		b = appendBytes(b, 4, m.ciphertext)
+	}
+	return b
+}
+
+func (m *message) unmarshal(b []byte) error {
+	err := parseFields(b, func(field int, v uint32, data []byte) error {
+		switch field {
+		case 1:
+			m.n = v
+		case 2:
+			m.pn = v
+		case 3:
+			m.dhPub = data
+		case 4:
+			m.ciphertext = data
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(m.dhPub) != keySize {
+		return errMalformed
+	}
+	return nil
+}
+
+// authMessage is an OMEMOAuthenticatedMessage.
+type authMessage struct {
+	mac     []byte
+	message []byte
+}
+
+func (m authMessage) marshal() []byte {
+	var b []byte
+	b = appendBytes(b, 1, m.mac)
+	return appendBytes(b, 2, m.message)
+}
+
+func (m *authMessage) unmarshal(b []byte) error {
+	err := parseFields(b, func(field int, _ uint32, data []byte) error {
+		switch field {
+		case 1:
+			m.mac = data
+		case 2:
+			m.message = data
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(m.mac) != macSize || m.message == nil {
+		return errMalformed
+	}
+	return nil
+}
+
+// keyExchange is an OMEMOKeyExchange.
+type keyExchange struct {
+	pkID    uint32
+	spkID   uint32
+	ik      []byte
+	ek      []byte
+	message authMessage
+}
+
+func (m keyExchange) marshal() []byte {
+	var b []byte
+	b = appendUint(b, 1, m.pkID)
+	b = appendUint(b, 2, m.spkID)
+	b = appendBytes(b, 3, m.ik)
+	b = appendBytes(b, 4, m.ek)
+	return appendBytes(b, 5, m.message.marshal())
+}
+
+func (m *keyExchange) unmarshal(b []byte) error {
+	var msg []byte
+	err := parseFields(b, func(field int, v uint32, data []byte) error {
+		switch field {
+		case 1:
+			m.pkID = v
+		case 2:
+			m.spkID = v
+		case 3:
+			m.ik = data
+		case 4:
+			m.ek = data
+		case 5:
+			msg = data
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(m.ik) != keySize || len(m.ek) != keySize || msg == nil {
+		return errMalformed
+	}
+	return m.message.unmarshal(msg)
+}
@@ -0,0 +1,339 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	macSize = 16
+	// maxSkip is the number of message keys that may be skipped in a single
+	// chain and the number of skipped keys that are remembered in total.
+	maxSkip = 1000
+
+	rootInfo    = "OMEMO Root Chain"
+	messageInfo = "OMEMO Message Key Material"
+)
+
+var (
+	errBadMAC         = errors.New("omemo: message authentication failed")
+	errTooManySkipped = errors.New("omemo: too many skipped messages")
+	errNoPreKey       = errors.New("omemo: unknown prekey")
+	errNoChain        = errors.New("omemo: session cannot send messages yet")
+)
+
+// session is the Double Ratchet state shared with a single remote device.
+// It is stored as JSON using the Store.
+type session struct {
+	DHs []byte `json:"dhs"`
+	DHr []byte `json:"dhr,omitempty"`
+	RK  []byte `json:"rk"`
+	CKs []byte `json:"cks,omitempty"`
+	CKr []byte `json:"ckr,omitempty"`
+	Ns  uint32 `json:"ns"`
+	Nr  uint32 `json:"nr"`
+	PN  uint32 `json:"pn"`
+	// AD is the associated data that every message is authenticated with.
+	AD []byte `json:"ad"`
+	// IK is the Ed25519 identity key of the remote device.
+	IK      []byte       `json:"ik"`
+	Skipped []skippedKey `json:"skipped,omitempty"`
+
+	// Kex is the key exchange that must be sent along with every message until
+	// the remote device responds.
+	Kex *pendingKex `json:"kex,omitempty"`
+	// KexEK is the ephemeral key of the key exchange that created the session
+	// if it was initiated by the remote device, used to recognize repeated key
+	// exchanges.
+	KexEK []byte `json:"kex_ek,omitempty"`
+}
+
+type pendingKex struct {
+	PKID  uint32 `json:"pk_id"`
+	SPKID uint32 `json:"spk_id"`
+	EK    []byte `json:"ek"`
+}
+
+type skippedKey struct {
+	DH []byte `json:"dh"`
+	N  uint32 `json:"n"`
+	MK []byte `json:"mk"`
+}
+
+func newInitiatorSession(sk, ad []byte, remote *ecdh.PublicKey) (*session, error) {
+	dhs, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := dh(dhs, remote)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		DHs: dhs.Bytes(),
+		DHr: remote.Bytes(),
+		AD:  ad,
+	}
+	s.RK, s.CKs = kdfRK(sk, secret)
+	return s, nil
+}
+
+func newResponderSession(sk, ad []byte, spk *ecdh.PrivateKey) *session {
+	return &session{
+		DHs: spk.Bytes(),
+		RK:  sk,
+		AD:  ad,
+	}
+}
+
+func unmarshalSession(b []byte) (*session, error) {
+	s := &session{}
+	err := json.Unmarshal(b, s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *session) marshal() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *session) clone() *session {
+	// The session only contains JSON friendly types, so round tripping is a
+	// convenient way to make a deep copy.
+	b, _ := s.marshal()
+	c, _ := unmarshalSession(b)
+	return c
+}
+
+func kdfRK(rk, dhOut []byte) (root, chain []byte) {
+	out := kdf(dhOut, rk, rootInfo, 2*keySize)
+	return out[:keySize], out[keySize:]
+}
+
+func kdfCK(ck []byte) (chain, mk []byte) {
+	mac := hmac.New(sha256.New, ck)
+	/* #nosec */
+	mac.Write([]byte{0x01})
+	mk = mac.Sum(nil)
+	mac.Reset()
+	/* #nosec */
+	mac.Write([]byte{0x02})
+	return mac.Sum(nil), mk
+}
+
+// encrypt encrypts plaintext using the sending chain and returns a serialized
+// OMEMOAuthenticatedMessage, or an OMEMOKeyExchange if the remote device has
+// not yet responded to the key exchange.
+func (s *session) encrypt(plaintext []byte) (data []byte, kex bool, err error) {
+	if s.CKs == nil {
+		return nil, false, errNoChain
+	}
+	dhs, err := ecdh.X25519().NewPrivateKey(s.DHs)
+	if err != nil {
+		return nil, false, err
+	}
+	var mk []byte
+	s.CKs, mk = kdfCK(s.CKs)
+	msg := message{
+		n:     s.Ns,
+		pn:    s.PN,
+		dhPub: dhs.PublicKey().Bytes(),
+	}
+	s.Ns++
+
+	encKey, authKey, iv := messageKeys(mk)
+	msg.ciphertext, err = cbcEncrypt(encKey, iv, plaintext)
+	if err != nil {
+		return nil, false, err
+	}
+	am := authMessage{message: msg.marshal()}
+	am.mac = s.mac(authKey, am.message)
+	if s.Kex == nil {
+		return am.marshal(), false, nil
+	}
+	return keyExchange{
+		pkID:    s.Kex.PKID,
+		spkID:   s.Kex.SPKID,
+		ik:      s.AD[:keySize],
+		ek:      s.Kex.EK,
+		message: am,
+	}.marshal(), true, nil
+}
+
+// decrypt authenticates and decrypts a message.
+// If decryption fails the session is left unmodified.
+func (s *session) decrypt(am authMessage) ([]byte, error) {
+	var msg message
+	err := msg.unmarshal(am.message)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, skipped := range s.Skipped {
+		if skipped.N != msg.n || !bytes.Equal(skipped.DH, msg.dhPub) {
+			continue
+		}
+		plaintext, err := s.open(skipped.MK, am, msg)
+		if err != nil {
+			return nil, err
+		}
+		s.Skipped = append(s.Skipped[:i], s.Skipped[i+1:]...)
+		return plaintext, nil
+	}
+
+	next := s.clone()
+	if !bytes.Equal(msg.dhPub, next.DHr) {
+		err = next.skip(msg.pn)
+		if err != nil {
+			return nil, err
+		}
+		err = next.ratchet(msg.dhPub)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = next.skip(msg.n)
+	if err != nil {
+		return nil, err
+	}
+	var mk []byte
+	next.CKr, mk = kdfCK(next.CKr)
+	next.Nr++
+	plaintext, err := next.open(mk, am, msg)
+	if err != nil {
+		return nil, err
+	}
+	// The remote device has received our key exchange once it replies.
+	next.Kex = nil
+	*s = *next
+	return plaintext, nil
+}
+
+func (s *session) open(mk []byte, am authMessage, msg message) ([]byte, error) {
+	encKey, authKey, iv := messageKeys(mk)
+	if !hmac.Equal(am.mac, s.mac(authKey, am.message)) {
+		return nil, errBadMAC
+	}
+	return cbcDecrypt(encKey, iv, msg.ciphertext)
+}
+
+func (s *session) mac(authKey, msg []byte) []byte {
+	mac := hmac.New(sha256.New, authKey)
+	/* #nosec */
+	mac.Write(s.AD)
+	/* #nosec */
+	mac.Write(msg)
+	return mac.Sum(nil)[:macSize]
+}
+
+// skip stores the message keys of the receiving chain up to (but not
+// including) message number until.
+func (s *session) skip(until uint32) error {
+	if s.CKr == nil {
+		return nil
+	}
+	if until > s.Nr && until-s.Nr > maxSkip {
+		return errTooManySkipped
+	}
+	for s.Nr < until {
+		var mk []byte
+		s.CKr, mk = kdfCK(s.CKr)
+		s.Skipped = append(s.Skipped, skippedKey{DH: s.DHr, N: s.Nr, MK: mk})
+		s.Nr++
+	}
+	if len(s.Skipped) > maxSkip {
+		s.Skipped = s.Skipped[len(s.Skipped)-maxSkip:]
+	}
+	return nil
+}
+
+// ratchet performs a Diffie-Hellman ratchet step after receiving a new
+// ratchet public key from the remote device.
+func (s *session) ratchet(remote []byte) error {
+	pub, err := ecdh.X25519().NewPublicKey(remote)
+	if err != nil {
+		return errBadKey
+	}
+	dhs, err := ecdh.X25519().NewPrivateKey(s.DHs)
+	if err != nil {
+		return err
+	}
+	secret, err := dh(dhs, pub)
+	if err != nil {
+		return err
+	}
+	s.PN = s.Ns
+	s.Ns = 0
+	s.Nr = 0
+	s.DHr = append([]byte(nil), remote...)
+	s.RK, s.CKr = kdfRK(s.RK, secret)
+
+	dhs, err = ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	secret, err = dh(dhs, pub)
+	if err != nil {
+		return err
+	}
+	s.DHs = dhs.Bytes()
+	s.RK, s.CKs = kdfRK(s.RK, secret)
+	return nil
+}
+
+// messageKeys expands a message key into an encryption key, an authentication
+// key, and an IV.
+func messageKeys(mk []byte) (encKey, authKey, iv []byte) {
+	out := kdf(mk, nil, messageInfo, 2*keySize+aes.BlockSize)
+	return out[:keySize], out[keySize : 2*keySize], out[2*keySize:]
+}
+
+// cbcEncrypt encrypts plaintext with AES-256 in CBC mode using PKCS#7 padding.
+func cbcEncrypt(key, iv, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	out := make([]byte, len(plaintext), len(plaintext)+pad)
+	copy(out, plaintext)
+	out = append(out, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return out, nil
+}
+
+// cbcDecrypt reverses cbcEncrypt.
+func cbcDecrypt(key, iv, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errMalformed
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, ciphertext)
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errMalformed
+	}
+	for _, b := range out[len(out)-pad:] {
+		if int(b) != pad {
+			return nil, errMalformed
+		}
+	}
+	return out[:len(out)-pad], nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// Store persists the state of OMEMO sessions.
+// Trust decisions are persisted separately using a crypto.TrustStore.
+//
+// Keys and sessions are opaque to the store and must be saved and returned
+// unmodified.
+// They contain private key material and should be protected accordingly.
+// All addresses passed to the store are bare JIDs.
+type Store interface {
+	// LoadKeys returns the private keys of our own device.
+	// If no keys have been saved it should return nil and no error.
+	LoadKeys(ctx context.Context) ([]byte, error)
+
+	// SaveKeys replaces the private keys of our own device.
+	SaveKeys(ctx context.Context, keys []byte) error
+
+	// LoadSession returns the session with the given device of the account
+	// addr.
+	// If no session has been saved it should return nil and no error.
+	LoadSession(ctx context.Context, addr jid.JID, device uint32) ([]byte, error)
+
+	// SaveSession replaces the session with the given device of the account
+	// addr.
+	SaveSession(ctx context.Context, addr jid.JID, device uint32, session []byte) error
+}
+
+// NewStore returns a Store that keeps all state in memory.
+// It is mostly useful for testing since keys and sessions are lost when the
+// program exits.
+func NewStore() Store {
+	return &memStore{
+		sessions: make(map[string][]byte),
+	}
+}
+
+type memStore struct {
+	sync.Mutex
+	keys     []byte
+	sessions map[string][]byte
+}
+
+func (m *memStore) LoadKeys(context.Context) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.keys, nil
+}
+
+func (m *memStore) SaveKeys(_ context.Context, keys []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.keys = keys
+	return nil
+}
+
+func (m *memStore) LoadSession(_ context.Context, addr jid.JID, device uint32) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.sessions[addr.String()+"/"+strconv.FormatUint(uint64(device), 10)], nil
+}
+
+func (m *memStore) SaveSession(_ context.Context, addr jid.JID, device uint32, session []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.sessions[addr.String()+"/"+strconv.FormatUint(uint64(device), 10)] = session
+	return nil
+}
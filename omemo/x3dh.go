@@ -0,0 +1,312 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	keySize     = 32
+	numPreKeys  = 100
+	x3dhInfo    = "OMEMO X3DH"
+	maxDeviceID = 1<<31 - 1
+)
+
+var (
+	errBadSignature = errors.New("omemo: bad signed prekey signature")
+	errBadKey       = errors.New("omemo: invalid public key")
+)
+
+// keys is the private key material of our own device.
+// It is stored as JSON using the Store.
+type keys struct {
+	DeviceID uint32 `json:"device_id"`
+	// Identity is the seed of the Ed25519 identity key.
+	Identity []byte `json:"identity"`
+	SPKID    uint32 `json:"spk_id"`
+	SPK      []byte `json:"spk"`
+	SPKSig   []byte `json:"spk_sig"`
+	// PreKeys maps the IDs of unused one-time prekeys to their private keys.
+	PreKeys    map[uint32][]byte `json:"prekeys"`
+	NextPreKey uint32            `json:"next_prekey"`
+}
+
+// newKeys generates an identity key, a signed prekey, and a batch of one-time
+// prekeys for a new device.
+func newKeys() (*keys, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	k := &keys{
+		DeviceID: id,
+		Identity: make([]byte, ed25519.SeedSize),
+		PreKeys:  make(map[uint32][]byte),
+	}
+	_, err = rand.Read(k.Identity)
+	if err != nil {
+		return nil, err
+	}
+	spk, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	k.SPKID = 1
+	k.SPK = spk.Bytes()
+	k.SPKSig = ed25519.Sign(k.identity(), spk.PublicKey().Bytes())
+	for len(k.PreKeys) < numPreKeys {
+		err = k.addPreKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// randomID returns a random device ID in the range 1 to 2^31-1.
+func randomID() (uint32, error) {
+	var buf [4]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buf[:])%maxDeviceID + 1, nil
+}
+
+func (k *keys) addPreKey() error {
+	pk, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	k.NextPreKey++
+	k.PreKeys[k.NextPreKey] = pk.Bytes()
+	return nil
+}
+
+func (k *keys) identity() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(k.Identity)
+}
+
+func (k *keys) identityPublic() ed25519.PublicKey {
+	return k.identity().Public().(ed25519.PublicKey)
+}
+
+// identityX25519 returns the identity key converted to its Montgomery form for
+// use in Diffie-Hellman key agreements.
+func (k *keys) identityX25519() *ecdh.PrivateKey {
+	h := sha512.Sum512(k.Identity)
+	// The scalar is clamped by NewPrivateKey, so this cannot fail.
+	priv, _ := ecdh.X25519().NewPrivateKey(h[:keySize])
+	return priv
+}
+
+func (k *keys) bundle() bundle {
+	spk, _ := ecdh.X25519().NewPrivateKey(k.SPK)
+	b := bundle{
+		SPKID:  k.SPKID,
+		SPK:    spk.PublicKey().Bytes(),
+		SPKSig: k.SPKSig,
+		IK:     k.identityPublic(),
+	}
+	for id, priv := range k.PreKeys {
+		pk, _ := ecdh.X25519().NewPrivateKey(priv)
+		b.PreKeys = append(b.PreKeys, preKey{ID: id, Key: pk.PublicKey().Bytes()})
+	}
+	return b
+}
+
+// fieldPrime is 2^255 - 19.
+var fieldPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// montgomery converts an Ed25519 public key to the X25519 public key of the
+// same private key using the birational map u = (1+y)/(1-y).
+func montgomery(pub []byte) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, errBadKey
+	}
+	le := make([]byte, keySize)
+	copy(le, pub)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(fieldPrime) >= 0 {
+		return nil, errBadKey
+	}
+	one := big.NewInt(1)
+	num := new(big.Int).Add(one, y)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, fieldPrime)
+	if den.Sign() == 0 {
+		return nil, errBadKey
+	}
+	den.ModInverse(den, fieldPrime)
+	u := num.Mul(num, den)
+	u.Mod(u, fieldPrime)
+	out := make([]byte, keySize)
+	u.FillBytes(out)
+	return ecdh.X25519().NewPublicKey(reverse(out))
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// kdf derives n bytes of key material using HKDF-SHA-256.
+// A nil salt is treated as 32 zero bytes.
+func kdf(ikm, salt []byte, info string, n int) []byte {
+	if salt == nil {
+		salt = make([]byte, sha256.Size)
+	}
+	out := make([]byte, n)
+	/* #nosec */
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte(info)), out)
+	return out
+}
+
+func dh(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) ([]byte, error) {
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, errBadKey
+	}
+	return secret, nil
+}
+
+// agree derives the shared secret from the four Diffie-Hellman outputs.
+func agree(dhs ...[]byte) []byte {
+	ikm := bytes.Repeat([]byte{0xff}, keySize)
+	for _, d := range dhs {
+		ikm = append(ikm, d...)
+	}
+	return kdf(ikm, nil, x3dhInfo, keySize)
+}
+
+// initiate performs the initiator side of X3DH with a bundle fetched from the
+// remote device and returns a new session ready to encrypt messages.
+func initiate(own *keys, b bundle) (*session, error) {
+	if !ed25519.Verify(b.IK, b.SPK, b.SPKSig) {
+		return nil, errBadSignature
+	}
+	if len(b.PreKeys) == 0 {
+		return nil, errBadKey
+	}
+	var pkBuf [2]byte
+	_, err := rand.Read(pkBuf[:])
+	if err != nil {
+		return nil, err
+	}
+	pk := b.PreKeys[int(binary.BigEndian.Uint16(pkBuf[:]))%len(b.PreKeys)]
+
+	ikB, err := montgomery(b.IK)
+	if err != nil {
+		return nil, err
+	}
+	spkB, err := ecdh.X25519().NewPublicKey(b.SPK)
+	if err != nil {
+		return nil, errBadKey
+	}
+	opkB, err := ecdh.X25519().NewPublicKey(pk.Key)
+	if err != nil {
+		return nil, errBadKey
+	}
+	ek, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var dhs [4][]byte
+	for i, pair := range []struct {
+		priv *ecdh.PrivateKey
+		pub  *ecdh.PublicKey
+	}{
+		{own.identityX25519(), spkB},
+		{ek, ikB},
+		{ek, spkB},
+		{ek, opkB},
+	} {
+		dhs[i], err = dh(pair.priv, pair.pub)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sk := agree(dhs[:]...)
+	ad := append(append([]byte(nil), own.identityPublic()...), b.IK...)
+
+	s, err := newInitiatorSession(sk, ad, spkB)
+	if err != nil {
+		return nil, err
+	}
+	s.IK = b.IK
+	s.Kex = &pendingKex{
+		PKID:  pk.ID,
+		SPKID: b.SPKID,
+		EK:    ek.PublicKey().Bytes(),
+	}
+	return s, nil
+}
+
+// respond performs the responder side of X3DH for a received key exchange
+// and returns a new session ready to decrypt the message that it contains.
+func respond(own *keys, kex keyExchange) (*session, error) {
+	if kex.spkID != own.SPKID {
+		return nil, errNoPreKey
+	}
+	opkPriv, ok := own.PreKeys[kex.pkID]
+	if !ok {
+		return nil, errNoPreKey
+	}
+	spk, err := ecdh.X25519().NewPrivateKey(own.SPK)
+	if err != nil {
+		return nil, err
+	}
+	opk, err := ecdh.X25519().NewPrivateKey(opkPriv)
+	if err != nil {
+		return nil, err
+	}
+	ikA, err := montgomery(kex.ik)
+	if err != nil {
+		return nil, err
+	}
+	ekA, err := ecdh.X25519().NewPublicKey(kex.ek)
+	if err != nil {
+		return nil, errBadKey
+	}
+
+	var dhs [4][]byte
+	for i, pair := range []struct {
+		priv *ecdh.PrivateKey
+		pub  *ecdh.PublicKey
+	}{
+		{spk, ikA},
+		{own.identityX25519(), ekA},
+		{spk, ekA},
+		{opk, ekA},
+	} {
+		dhs[i], err = dh(pair.priv, pair.pub)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sk := agree(dhs[:]...)
+	ad := append(append([]byte(nil), kex.ik...), own.identityPublic()...)
+
+	s := newResponderSession(sk, ad, spk)
+	s.IK = append([]byte(nil), kex.ik...)
+	s.KexEK = append([]byte(nil), kex.ek...)
+	return s, nil
+}
@@ -0,0 +1,367 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package presence keeps track of the availability of other entities.
+//
+// A Tracker records the last presence received from each full JID along with
+// any entity capabilities (XEP-0115 or XEP-0390) that were advertised.
+// It can then be used to pick the resource of a contact that should receive a
+// message or to find all resources that support a given feature, for example
+// to decide where a file transfer or command should be sent.
+// Features are looked up and cached using a disco.CapsCache.
+package presence // import "github.com/kamrankamilli/xmpp/presence"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/delay"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Resource is the last presence received from a full JID.
+type Resource struct {
+	JID      jid.JID
+	Show     string
+	Status   string
+	Priority int8
+	Caps     disco.Caps
+	// Delay is set if the presence was delayed, for example if it was sent by the
+	// server when we first came online.
+	Delay delay.Delay
+}
+
+func (r Resource) equal(other Resource) bool {
+	return r.JID.Equal(other.JID) &&
+		r.Show == other.Show &&
+		r.Status == other.Status &&
+		r.Priority == other.Priority &&
+		r.Caps == other.Caps &&
+		r.Delay.From.Equal(other.Delay.From) &&
+		r.Delay.Time.Equal(other.Delay.Time) &&
+		r.Delay.Reason == other.Delay.Reason
+}
+
+// showRank orders the values of show from most to least available.
+func showRank(show string) int {
+	switch show {
+	case "chat":
+		return 0
+	case "":
+		return 1
+	case "away":
+		return 2
+	case "xa":
+		return 3
+	}
+	// dnd and any unknown values.
+	return 4
+}
+
+type entry struct {
+	Resource
+	// seq is incremented each time the resource changes so that the resource
+	// that most recently changed its presence can be preferred.
+	seq uint64
+}
+
+// Handle returns an option that registers the tracker to receive available,
+// unavailable, and error presence.
+//
+// The tracker is registered for presence with any payload and for presence
+// containing entity capabilities, so it may not be used on the same mux as
+// disco.HandleCaps, disco.HandleCaps2, or disco.HandleCache.
+// Entity capabilities are recorded in the Caps cache of the tracker instead.
+// Presence that only contains payloads handled by other handlers registered on
+// the same mux (for example, presence from channels handled by the muc
+// package) is only seen by the tracker if those handlers are wrapped using
+// Wrap.
+func Handle(t *Tracker) mux.Option {
+	return func(m *mux.ServeMux) {
+		mux.Presence(stanza.AvailablePresence, xml.Name{}, t)(m)
+		mux.Presence(stanza.AvailablePresence, xml.Name{Space: disco.NSCaps, Local: "c"}, t)(m)
+		mux.Presence(stanza.AvailablePresence, xml.Name{Space: disco.NSCaps2, Local: "c"}, t)(m)
+		mux.Presence(stanza.UnavailablePresence, xml.Name{}, t)(m)
+		mux.Presence(stanza.ErrorPresence, xml.Name{}, t)(m)
+	}
+}
+
+// Wrap returns a presence handler that records presence using the tracker
+// before passing it on to h.
+// It should be used for handlers that are registered for specific presence
+// payloads on the same mux as the tracker.
+func (t *Tracker) Wrap(h mux.PresenceHandler) mux.PresenceHandler {
+	return mux.PresenceHandlerFunc(func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+		toks, err := xmlstream.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		err = t.HandlePresence(p, struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: &tokenSlice{toks: toks},
+			Encoder:     r,
+		})
+		if err != nil {
+			return err
+		}
+		return h.HandlePresence(p, struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: &tokenSlice{toks: toks},
+			Encoder:     r,
+		})
+	})
+}
+
+type tokenSlice struct {
+	toks []xml.Token
+}
+
+func (t *tokenSlice) Token() (xml.Token, error) {
+	if len(t.toks) == 0 {
+		return nil, io.EOF
+	}
+	tok := t.toks[0]
+	t.toks = t.toks[1:]
+	return tok, nil
+}
+
+// Tracker records the availability of other entities.
+// The zero value is a Tracker that does not record entity capabilities.
+type Tracker struct {
+	// Caps, if set, records the entity capabilities advertised by available
+	// resources and is used to look up the features that they support.
+	Caps *disco.CapsCache
+
+	// HandleChange, if set, is called when a resource becomes available, when
+	// its presence changes, and with the last known presence when it becomes
+	// unavailable.
+	HandleChange func(r Resource, available bool)
+
+	mu        sync.Mutex
+	seq       uint64
+	resources map[string]map[string]entry
+}
+
+// HandlePresence satisfies mux.PresenceHandler.
+// It should generally be registered on a mux using Handle.
+func (t *Tracker) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+	if p.From.Equal(jid.JID{}) {
+		return nil
+	}
+
+	switch p.Type {
+	case stanza.AvailablePresence:
+	case stanza.UnavailablePresence, stanza.ErrorPresence:
+		t.remove(p.From)
+		return nil
+	default:
+		return nil
+	}
+
+	// This handler is called once for each child of the presence, so the entire
+	// presence is decoded each time and changes are only reported once.
+	pres := struct {
+		stanza.Presence
+		Show     string `xml:"show"`
+		Status   string `xml:"status"`
+		Priority string `xml:"priority"`
+		Caps     struct {
+			Hash string `xml:"hash,attr"`
+			Node string `xml:"node,attr"`
+			Ver  string `xml:"ver,attr"`
+		} `xml:"http://jabber.org/protocol/caps c"`
+		Caps2 disco.Caps2 `xml:"urn:xmpp:caps c"`
+		Delay delay.Delay `xml:"urn:xmpp:delay delay"`
+	}{}
+	err := xml.NewTokenDecoder(r).Decode(&pres)
+	if err != nil {
+		return err
+	}
+	// Invalid priorities are treated as the default priority of 0.
+	priority, _ := strconv.ParseInt(pres.Priority, 10, 8)
+	caps := disco.Caps{
+		Node: pres.Caps.Node,
+		Ver:  pres.Caps.Ver,
+	}
+	// Caps using unknown hash functions are kept, but can never be resolved.
+	/* #nosec */
+	(&caps.Hash).UnmarshalXMLAttr(xml.Attr{Value: pres.Caps.Hash})
+	if t.Caps != nil {
+		t.Caps.SetCaps(p.From, caps)
+		t.Caps.SetCaps2(p.From, pres.Caps2)
+	}
+	t.update(Resource{
+		JID:      p.From,
+		Show:     pres.Show,
+		Status:   pres.Status,
+		Priority: int8(priority),
+		Caps:     caps,
+		Delay:    pres.Delay,
+	})
+	return nil
+}
+
+func (t *Tracker) update(res Resource) {
+	bare := res.JID.Bare().String()
+	resourcepart := res.JID.Resourcepart()
+
+	t.mu.Lock()
+	if t.resources == nil {
+		t.resources = make(map[string]map[string]entry)
+	}
+	resources := t.resources[bare]
+	if resources == nil {
+		resources = make(map[string]entry)
+		t.resources[bare] = resources
+	}
+	if old, ok := resources[resourcepart]; ok && old.equal(res) {
+		t.mu.Unlock()
+		return
+	}
+	t.seq++
+	resources[resourcepart] = entry{Resource: res, seq: t.seq}
+	t.mu.Unlock()
+
+	if t.HandleChange != nil {
+		t.HandleChange(res, true)
+	}
+}
+
+func (t *Tracker) remove(j jid.JID) {
+	bare := j.Bare().String()
+	resourcepart := j.Resourcepart()
+
+	var removed []Resource
+	t.mu.Lock()
+	resources := t.resources[bare]
+	for part, e := range resources {
+		// If the presence is from the bare JID (for example, an error because we
+		// are not subscribed to the contact) all of its resources are removed.
+		if resourcepart == "" || part == resourcepart {
+			removed = append(removed, e.Resource)
+			delete(resources, part)
+		}
+	}
+	if len(resources) == 0 {
+		delete(t.resources, bare)
+	}
+	t.mu.Unlock()
+
+	for _, res := range removed {
+		if t.Caps != nil {
+			t.Caps.Forget(res.JID)
+		}
+		if t.HandleChange != nil {
+			t.HandleChange(res, false)
+		}
+	}
+}
+
+// Resource returns the last presence received from the full JID j.
+func (t *Tracker) Resource(j jid.JID) (Resource, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.resources[j.Bare().String()][j.Resourcepart()]
+	return e.Resource, ok
+}
+
+// Resources returns all available resources of the bare JID j from most to
+// least preferred.
+//
+// Resources are ordered by priority, then by availability (chat, online, away,
+// xa, and then dnd), and finally the resource that most recently changed its
+// presence is preferred.
+func (t *Tracker) Resources(j jid.JID) []Resource {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sorted(j, func(Resource) bool { return true })
+}
+
+// Best returns the most preferred available resource of the bare JID j as
+// defined by Resources.
+// Resources with a negative priority are never returned.
+func (t *Tracker) Best(j jid.JID) (Resource, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	resources := t.sorted(j, func(r Resource) bool { return r.Priority >= 0 })
+	if len(resources) == 0 {
+		return Resource{}, false
+	}
+	return resources[0], true
+}
+
+// Supports reports whether the full JID j is available and has advertised
+// support for feature using entity capabilities.
+// If the service discovery information that the entity capabilities refer to
+// is not already known it is looked up and verified by the Caps cache over s.
+// Entities that do not advertise entity capabilities, or that advertise
+// capabilities that do not match their service discovery information, are not
+// considered to support any features.
+func (t *Tracker) Supports(ctx context.Context, s *xmpp.Session, j jid.JID, feature string) (bool, error) {
+	if _, ok := t.Resource(j); !ok || t.Caps == nil {
+		return false, nil
+	}
+	ok, err := t.Caps.Supports(ctx, s, j, feature)
+	if errors.Is(err, disco.ErrNoCaps) || errors.Is(err, disco.ErrVerification) {
+		return false, nil
+	}
+	return ok, err
+}
+
+// Supporting returns all available resources of the bare JID j that have
+// advertised support for feature ordered in the same way as Resources.
+// For more information see Supports.
+func (t *Tracker) Supporting(ctx context.Context, s *xmpp.Session, j jid.JID, feature string) ([]Resource, error) {
+	var supporting []Resource
+	for _, r := range t.Resources(j) {
+		ok, err := t.Supports(ctx, s, r.JID, feature)
+		if err != nil {
+			return supporting, err
+		}
+		if ok {
+			supporting = append(supporting, r)
+		}
+	}
+	return supporting, nil
+}
+
+// sorted must be called with the lock held.
+func (t *Tracker) sorted(j jid.JID, filter func(Resource) bool) []Resource {
+	resources := t.resources[j.Bare().String()]
+	entries := make([]entry, 0, len(resources))
+	for _, e := range resources {
+		if filter(e.Resource) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if ra, rb := showRank(a.Show), showRank(b.Show); ra != rb {
+			return ra < rb
+		}
+		return a.seq > b.seq
+	})
+	list := make([]Resource, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e.Resource)
+	}
+	return list
+}
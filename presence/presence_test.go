@@ -0,0 +1,266 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package presence_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/xml"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/crypto"
+	"github.com/kamrankamilli/xmpp/delay"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/presence"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+
+func element(name, val string) xml.TokenReader {
+	return xmlstream.Wrap(xmlstream.Token(xml.CharData(val)), xml.StartElement{Name: xml.Name{Local: name}})
+}
+
+func send(t *testing.T, m *mux.ServeMux, p stanza.Presence, payload ...xml.TokenReader) {
+	t.Helper()
+	r := p.Wrap(xmlstream.MultiReader(payload...))
+	tok, err := r.Token()
+	if err != nil {
+		t.Fatalf("error popping start token: %v", err)
+	}
+	start := tok.(xml.StartElement)
+	err = m.HandleXMPP(struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: r,
+		Encoder:     xml.NewEncoder(discard{}),
+	}, &start)
+	if err != nil {
+		t.Fatalf("error handling presence: %v", err)
+	}
+}
+
+func resourceparts(resources []presence.Resource) []string {
+	s := make([]string, 0, len(resources))
+	for _, r := range resources {
+		s = append(s, r.JID.Resourcepart())
+	}
+	return s
+}
+
+func TestTracker(t *testing.T) {
+	var changes []string
+	tracker := &presence.Tracker{
+		HandleChange: func(r presence.Resource, available bool) {
+			change := r.JID.Resourcepart()
+			if !available {
+				change = "-" + change
+			}
+			changes = append(changes, change)
+		},
+	}
+	m := mux.New("", presence.Handle(tracker))
+	juliet := jid.MustParse("juliet@example.com")
+	stamp := time.Date(2002, time.September, 10, 23, 8, 25, 0, time.UTC)
+
+	send(t, m, stanza.Presence{From: jid.MustParse("juliet@example.com/balcony")},
+		element("show", "away"),
+		element("status", "be right back"),
+		element("priority", "1"),
+		delay.Delay{Time: stamp}.TokenReader(),
+	)
+	send(t, m, stanza.Presence{From: jid.MustParse("juliet@example.com/chamber")}, element("priority", "1"))
+	send(t, m, stanza.Presence{From: jid.MustParse("juliet@example.com/garden")}, element("priority", "5"), element("show", "dnd"))
+	send(t, m, stanza.Presence{From: jid.MustParse("juliet@example.com/hidden")}, element("priority", "-1"))
+	send(t, m, stanza.Presence{From: jid.MustParse("juliet@example.com/tomb")})
+
+	// Presence with several children must only be reported once.
+	want := []string{"balcony", "chamber", "garden", "hidden", "tomb"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("wrong changes: want=%v, got=%v", want, changes)
+	}
+
+	res, ok := tracker.Resource(jid.MustParse("juliet@example.com/balcony"))
+	if !ok {
+		t.Fatalf("expected resource to be tracked")
+	}
+	if res.Show != "away" || res.Status != "be right back" || res.Priority != 1 || !res.Delay.Time.Equal(stamp) {
+		t.Errorf("wrong resource recorded: %+v", res)
+	}
+
+	want = []string{"garden", "chamber", "balcony", "tomb", "hidden"}
+	if got := resourceparts(tracker.Resources(juliet)); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong resource order: want=%v, got=%v", want, got)
+	}
+	best, ok := tracker.Best(juliet)
+	if !ok || best.JID.Resourcepart() != "garden" {
+		t.Errorf("wrong best resource: want=garden, got=%v", best.JID)
+	}
+
+	// The most recently updated resource wins ties.
+	send(t, m, stanza.Presence{From: jid.MustParse("juliet@example.com/balcony")}, element("priority", "1"))
+	send(t, m, stanza.Presence{From: jid.MustParse("juliet@example.com/garden"), Type: stanza.UnavailablePresence})
+	best, _ = tracker.Best(juliet)
+	if best.JID.Resourcepart() != "balcony" {
+		t.Errorf("wrong best resource after update: want=balcony, got=%v", best.JID)
+	}
+
+	// Negative priority resources are never the best resource.
+	for _, part := range []string{"balcony", "chamber", "tomb"} {
+		send(t, m, stanza.Presence{From: jid.MustParse("juliet@example.com/" + part), Type: stanza.UnavailablePresence})
+	}
+	if best, ok = tracker.Best(juliet); ok {
+		t.Errorf("unexpected best resource: %v", best.JID)
+	}
+
+	// An error from the bare JID removes all resources.
+	send(t, m, stanza.Presence{From: juliet, Type: stanza.ErrorPresence})
+	if resources := tracker.Resources(juliet); len(resources) != 0 {
+		t.Errorf("expected no resources after error, got %v", resourceparts(resources))
+	}
+	want = []string{"balcony", "chamber", "garden", "hidden", "tomb", "balcony", "-garden", "-balcony", "-chamber", "-tomb", "-hidden"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("wrong changes: want=%v, got=%v", want, changes)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	userX := xml.Name{Space: "http://jabber.org/protocol/muc#user", Local: "x"}
+	var wrapped []string
+	tracker := &presence.Tracker{}
+	h := tracker.Wrap(mux.PresenceHandlerFunc(func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+		// The wrapped handler must still be able to decode the presence.
+		pres := struct {
+			stanza.Presence
+			X struct {
+				Item struct {
+					Role string `xml:"role,attr"`
+				} `xml:"item"`
+			} `xml:"http://jabber.org/protocol/muc#user x"`
+		}{}
+		err := xml.NewTokenDecoder(r).Decode(&pres)
+		wrapped = append(wrapped, pres.From.Resourcepart()+":"+pres.X.Item.Role)
+		return err
+	}))
+	m := mux.New("",
+		presence.Handle(tracker),
+		mux.Presence(stanza.AvailablePresence, userX, h),
+		mux.Presence(stanza.UnavailablePresence, userX, h),
+	)
+
+	item := xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "role"}, Value: "participant"}},
+		}),
+		xml.StartElement{Name: userX},
+	)
+	occupant := jid.MustParse("room@conference.example.net/juliet")
+	send(t, m, stanza.Presence{From: occupant}, item)
+	if _, ok := tracker.Resource(occupant); !ok {
+		t.Errorf("expected presence handled by the wrapped handler to be tracked")
+	}
+	send(t, m, stanza.Presence{From: occupant, Type: stanza.UnavailablePresence}, xmlstream.Wrap(nil, xml.StartElement{Name: userX}))
+	if _, ok := tracker.Resource(occupant); ok {
+		t.Errorf("expected unavailable presence handled by the wrapped handler to be tracked")
+	}
+	want := []string{"juliet:participant", "juliet:"}
+	if !reflect.DeepEqual(wrapped, want) {
+		t.Errorf("wrong presence passed to wrapped handler: want=%v, got=%v", want, wrapped)
+	}
+}
+
+func TestSupporting(t *testing.T) {
+	const feature = "urn:xmpp:jingle:apps:file-transfer:5"
+	phoneInfo := disco.Info{
+		Identity: []info.Identity{{Category: "client", Type: "phone", Name: "Phone"}},
+		Features: []info.Feature{{Var: disco.NSInfo}, {Var: feature}},
+	}
+	phoneCaps := disco.Caps{Hash: crypto.SHA1, Node: "https://example.net/phone", Ver: phoneInfo.Hash(sha1.New())}
+	pcInfo := disco.Info{
+		Identity: []info.Identity{{Category: "client", Type: "pc", Name: "PC"}},
+		Features: []info.Feature{{Var: disco.NSInfo}},
+	}
+	pcCaps := disco.Caps{Hash: crypto.SHA1, Node: "https://example.net/pc", Ver: pcInfo.Hash(sha1.New())}
+
+	// All entities are sent the info of the pc to make sure that the phone is
+	// verified.
+	var lookups int32
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(mux.New(stanza.NSClient,
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: disco.NSInfo, Local: "query"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+			atomic.AddInt32(&lookups, 1)
+			_, err := xmlstream.Copy(r, iq.Result(pcInfo.TokenReader()))
+			return err
+		}),
+	)))
+	ctx := context.Background()
+
+	store := disco.NewCapsStore()
+	tracker := &presence.Tracker{Caps: &disco.CapsCache{Store: store}}
+	m := mux.New("", presence.Handle(tracker))
+	romeo := jid.MustParse("romeo@example.net")
+	send(t, m, stanza.Presence{From: jid.MustParse("romeo@example.net/phone")}, phoneCaps.TokenReader())
+	send(t, m, stanza.Presence{From: jid.MustParse("romeo@example.net/pc")}, pcCaps.TokenReader(), element("priority", "10"))
+	send(t, m, stanza.Presence{From: jid.MustParse("romeo@example.net/none")})
+
+	supporting := func(feature string) []string {
+		t.Helper()
+		resources, err := tracker.Supporting(ctx, cs.Client, romeo, feature)
+		if err != nil {
+			t.Fatalf("error finding resources supporting %s: %v", feature, err)
+		}
+		return resourceparts(resources)
+	}
+	if got := supporting(feature); len(got) != 0 {
+		t.Errorf("unverified info should not be used, got %v", got)
+	}
+	if got := supporting(disco.NSInfo); !reflect.DeepEqual(got, []string{"pc"}) {
+		t.Errorf("wrong resources supporting disco: want=[pc], got=%v", got)
+	}
+
+	// Information that is already in the store is used without a lookup.
+	err := store.Put(ctx, disco.NSCaps+"#sha-1."+phoneCaps.Ver, phoneInfo)
+	if err != nil {
+		t.Fatalf("error storing info: %v", err)
+	}
+	if got := supporting(feature); !reflect.DeepEqual(got, []string{"phone"}) {
+		t.Errorf("wrong resources supporting feature: want=[phone], got=%v", got)
+	}
+	if got := supporting(disco.NSInfo); !reflect.DeepEqual(got, []string{"pc", "phone"}) {
+		t.Errorf("wrong resources supporting disco: want=[pc phone], got=%v", got)
+	}
+	ok, err := tracker.Supports(ctx, cs.Client, jid.MustParse("romeo@example.net/pc"), feature)
+	if err != nil || ok {
+		t.Errorf("did not expect pc to support feature: ok=%t, err=%v", ok, err)
+	}
+
+	// Seeing the caps again does not look them up again.
+	before := atomic.LoadInt32(&lookups)
+	send(t, m, stanza.Presence{From: jid.MustParse("romeo@example.net/tablet")}, pcCaps.TokenReader())
+	if got := supporting(disco.NSInfo); !reflect.DeepEqual(got, []string{"pc", "tablet", "phone"}) {
+		t.Errorf("wrong resources supporting disco: want=[pc tablet phone], got=%v", got)
+	}
+	if after := atomic.LoadInt32(&lookups); after != before {
+		t.Errorf("unexpected lookups: %d", after-before)
+	}
+
+	// Resources that go offline do not support anything.
+	send(t, m, stanza.Presence{From: jid.MustParse("romeo@example.net/phone"), Type: stanza.UnavailablePresence})
+	ok, err = tracker.Supports(ctx, cs.Client, jid.MustParse("romeo@example.net/phone"), feature)
+	if err != nil || ok {
+		t.Errorf("did not expect offline phone to support feature: ok=%t, err=%v", ok, err)
+	}
+}
@@ -3,7 +3,7 @@
 // license that can be found in the LICENSE file.
 
 //go:generate go run ../internal/genpubsub
-//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -output=string.go -type=SubType,Condition,Feature -linecomment
+//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -output=string.go -type=SubType,Condition,Feature,EventType -linecomment
 
 // Package pubsub implements data storage using a publish–subscribe pattern.
 package pubsub // import "github.com/kamrankamilli/xmpp/pubsub"
@@ -0,0 +1,367 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"sort"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/paging"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// EventType is the kind of notification contained in an Event.
+type EventType uint8
+
+// A list of possible event types.
+const (
+	EventItems        EventType = iota // items
+	EventPurge                         // purge
+	EventDelete                        // delete
+	EventConfig                        // configuration
+	EventSubscription                  // subscription
+)
+
+// Event is a notification sent by a pubsub service.
+type Event struct {
+	// Type is the kind of notification.
+	// Only the fields that apply to the given type will be set.
+	Type EventType
+
+	// Node is the node that generated the event.
+	Node string
+
+	// Items iterates over any items that were published to the node.
+	// It is never nil, but will not contain any items unless Type is EventItems.
+	// Handlers do not need to consume or close the iterator.
+	Items *Iter
+
+	// Retract contains the IDs of any items that were removed from the node if
+	// Type is EventItems.
+	Retract []string
+
+	// Redirect is the URI of a node that replaces the deleted node if Type is
+	// EventDelete.
+	Redirect string
+
+	// Config is the new configuration of the node if Type is EventConfig and
+	// the service included the configuration in the notification.
+	Config *form.Data
+
+	// Subscription is the new state of a subscription if Type is
+	// EventSubscription.
+	Subscription Subscription
+}
+
+// Handler responds to pubsub events.
+type Handler interface {
+	HandleEvent(stanza.Message, Event) error
+}
+
+// The HandlerFunc type is an adapter to allow the use of ordinary functions as
+// event handlers.
+// If f is a function with the appropriate signature, HandlerFunc(f) is a
+// Handler that calls f.
+type HandlerFunc func(stanza.Message, Event) error
+
+// HandleEvent calls f(msg, e).
+func (f HandlerFunc) HandleEvent(msg stanza.Message, e Event) error {
+	return f(msg, e)
+}
+
+type nopHandler struct{}
+
+func (nopHandler) HandleEvent(stanza.Message, Event) error { return nil }
+
+// Handle returns an option that registers the handler for use with a
+// multiplexer.
+func Handle(s *Subscriptions) mux.Option {
+	return func(m *mux.ServeMux) {
+		event := xml.Name{Space: NSEvent, Local: "event"}
+		mux.Message(stanza.NormalMessage, event, s)(m)
+		mux.Message(stanza.HeadlineMessage, event, s)(m)
+	}
+}
+
+// Subscriptions is a handler that, when registered against a mux with Handle,
+// multiplexes incoming pubsub events to handlers based on the namespace of
+// the events payload.
+//
+// Events are dispatched to the handler registered for the namespace of the
+// first published item, or for the node name if the event does not contain a
+// payload (for example retractions, purges, deletions, configuration changes,
+// and subscription changes).
+// For personal eventing (PEP) nodes the node name is conventionally the same as
+// the payload namespace.
+//
+// The zero value is ready to use and Subscriptions is safe for concurrent use
+// by multiple goroutines.
+type Subscriptions struct {
+	handlers map[string]Handler
+	mu       sync.Mutex
+}
+
+// Handler returns the handler to use for events with the given namespace.
+// If no exact match is found, a default noop handler is returned (h is always
+// non-nil) and ok will be false.
+func (s *Subscriptions) Handler(ns string) (h Handler, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok = s.handlers[ns]
+	if !ok {
+		return nopHandler{}, false
+	}
+	return h, true
+}
+
+// Register adds a handler for events with the given namespace without
+// subscribing to any node.
+// This is useful for personal eventing (PEP) where the server subscribes us to
+// nodes based on the "+notify" features that we advertise.
+// If a handler is already registered for the namespace it is replaced.
+//
+// The namespace should not include the +notify suffix.
+func (s *Subscriptions) Register(ns string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[string]Handler)
+	}
+	s.handlers[ns] = h
+}
+
+// RegisterFunc is like Register except that it takes a HandlerFunc.
+func (s *Subscriptions) RegisterFunc(ns string, h HandlerFunc) {
+	s.Register(ns, h)
+}
+
+// Unregister removes the handler for the given namespace (if any) and stops
+// handling events for it without unsubscribing from the node.
+func (s *Subscriptions) Unregister(ns string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.handlers, ns)
+}
+
+// Subscribe subscribes to the node named ns on the service at the address to,
+// and begins forwarding events to h.
+//
+// The namespace should not include the +notify suffix.
+// The subscribe request is always sent, and if a handler is already registered
+// it is only replaced if no error is returned in response.
+func (s *Subscriptions) Subscribe(ctx context.Context, to jid.JID, session *xmpp.Session, ns string, h Handler) (Subscription, error) {
+	sub, err := SubscribeIQ(ctx, session, stanza.IQ{To: to}, ns)
+	if err != nil {
+		return sub, err
+	}
+	s.Register(ns, h)
+	return sub, nil
+}
+
+// SubscribeFunc is like Subscribe except that it takes a HandlerFunc.
+func (s *Subscriptions) SubscribeFunc(ctx context.Context, to jid.JID, session *xmpp.Session, ns string, h HandlerFunc) (Subscription, error) {
+	return s.Subscribe(ctx, to, session, ns, h)
+}
+
+// Unsubscribe removes the handler for ns and sends a request to unsubscribe
+// from the node on the service at the address to.
+// The request is sent regardless of whether a handler was registered in case
+// the service subscribed us automatically.
+func (s *Subscriptions) Unsubscribe(ctx context.Context, to jid.JID, session *xmpp.Session, ns string) error {
+	s.Unregister(ns)
+	return UnsubscribeIQ(ctx, session, stanza.IQ{To: to}, ns, "")
+}
+
+// ForFeatures implements info.FeatureIter by advertising the "+notify" variant
+// of every registered namespace.
+func (s *Subscriptions) ForFeatures(node string, f func(info.Feature) error) error {
+	if node != "" {
+		return nil
+	}
+	s.mu.Lock()
+	nss := make([]string, 0, len(s.handlers))
+	for ns := range s.handlers {
+		nss = append(nss, ns)
+	}
+	s.mu.Unlock()
+	sort.Strings(nss)
+	for _, ns := range nss {
+		err := f(info.Feature{Var: ns + "+notify"})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleMessage satisfies mux.MessageHandler.
+// It decodes any pubsub events in the message and passes them to the
+// registered handlers.
+func (s *Subscriptions) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	// Pop the start message token.
+	_, err := t.Token()
+	if err != nil {
+		return err
+	}
+
+	iter := xmlstream.NewIter(t)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, r := iter.Current()
+		if start == nil || start.Name.Space != NSEvent || start.Name.Local != "event" {
+			continue
+		}
+		err = s.handleEvent(msg, r)
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func (s *Subscriptions) handleEvent(msg stanza.Message, r xml.TokenReader) error {
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, r := iter.Current()
+		if start == nil {
+			continue
+		}
+		_, node := attr.Get(start.Attr, "node")
+		e := Event{Node: node}
+		key := node
+		switch start.Name.Local {
+		case "items":
+			var ns string
+			var err error
+			e.Type = EventItems
+			e.Items, e.Retract, ns, err = decodeItems(r)
+			if err != nil {
+				return err
+			}
+			if ns != "" {
+				key = ns
+			}
+		case "purge":
+			e.Type = EventPurge
+		case "delete":
+			e.Type = EventDelete
+			var del struct {
+				Redirect struct {
+					URI string `xml:"uri,attr"`
+				} `xml:"redirect"`
+			}
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&del)
+			if err != nil {
+				return err
+			}
+			e.Redirect = del.Redirect.URI
+		case "configuration":
+			e.Type = EventConfig
+			var cfg struct {
+				Data *form.Data `xml:"jabber:x:data x"`
+			}
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&cfg)
+			if err != nil {
+				return err
+			}
+			e.Config = cfg.Data
+		case "subscription":
+			e.Type = EventSubscription
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&e.Subscription)
+			if err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		if e.Items == nil {
+			e.Items = &Iter{iter: paging.WrapIter(xmlstream.NewIter(eofReader{}), 0)}
+		}
+		h, _ := s.Handler(key)
+		err := h.HandleEvent(msg, e)
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// decodeItems buffers any published items from an items event so that they can
+// be iterated over, and returns the IDs of any retracted items along with the
+// namespace of the first payload.
+func decodeItems(r xml.TokenReader) (*Iter, []string, string, error) {
+	var (
+		toks    []xml.Token
+		retract []string
+		ns      string
+	)
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, r := iter.Current()
+		if start == nil {
+			continue
+		}
+		switch start.Name.Local {
+		case "item":
+			toks = append(toks, start.Copy())
+			for {
+				tok, err := r.Token()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, nil, "", err
+				}
+				if payload, ok := tok.(xml.StartElement); ok && ns == "" {
+					ns = payload.Name.Space
+				}
+				toks = append(toks, xml.CopyToken(tok))
+			}
+		case "retract":
+			_, id := attr.Get(start.Attr, "id")
+			retract = append(retract, id)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, nil, "", err
+	}
+	return &Iter{
+		iter: paging.WrapIter(xmlstream.NewIter(&tokenSlice{toks: toks}), 0),
+	}, retract, ns, nil
+}
+
+type eofReader struct{}
+
+func (eofReader) Token() (xml.Token, error) {
+	return nil, io.EOF
+}
+
+type tokenSlice struct {
+	toks []xml.Token
+}
+
+func (t *tokenSlice) Token() (xml.Token, error) {
+	if len(t.toks) == 0 {
+		return nil, io.EOF
+	}
+	tok := t.toks[0]
+	t.toks = t.toks[1:]
+	return tok, nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/pubsub"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+var (
+	_ mux.MessageHandler = (*pubsub.Subscriptions)(nil)
+	_ info.FeatureIter   = (*pubsub.Subscriptions)(nil)
+)
+
+type event struct {
+	Type     pubsub.EventType
+	Node     string
+	Items    map[string]string
+	Retract  []string
+	Redirect string
+	Config   bool
+	Sub      pubsub.SubType
+}
+
+var eventTestCases = [...]struct {
+	in      string
+	ns      string
+	handled bool
+	event   event
+}{
+	0: {
+		in:      `<message xmlns="jabber:client" from="pubsub.shakespeare.lit"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="princely_musings"><item id="ae890ac52d0df67ed7cfdf51b644e901"><entry xmlns="http://www.w3.org/2005/Atom"><title>Soliloquy</title></entry></item><item id="2"><entry xmlns="http://www.w3.org/2005/Atom"/></item></items></event></message>`,
+		ns:      "http://www.w3.org/2005/Atom",
+		handled: true,
+		event: event{
+			Type: pubsub.EventItems,
+			Node: "princely_musings",
+			Items: map[string]string{
+				"ae890ac52d0df67ed7cfdf51b644e901": "Soliloquy",
+				"2":                                "",
+			},
+		},
+	},
+	1: {
+		in:      `<message xmlns="jabber:client" type="headline"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="princely_musings"><retract id="1"/><retract id="2"/></items></event></message>`,
+		ns:      "princely_musings",
+		handled: true,
+		event: event{
+			Type:    pubsub.EventItems,
+			Node:    "princely_musings",
+			Retract: []string{"1", "2"},
+		},
+	},
+	2: {
+		in:      `<message xmlns="jabber:client"><event xmlns="http://jabber.org/protocol/pubsub#event"><purge node="princely_musings"/></event></message>`,
+		ns:      "princely_musings",
+		handled: true,
+		event: event{
+			Type: pubsub.EventPurge,
+			Node: "princely_musings",
+		},
+	},
+	3: {
+		in:      `<message xmlns="jabber:client"><event xmlns="http://jabber.org/protocol/pubsub#event"><delete node="princely_musings"><redirect uri="xmpp:hamlet@denmark.lit?;node=blog"/></delete></event></message>`,
+		ns:      "princely_musings",
+		handled: true,
+		event: event{
+			Type:     pubsub.EventDelete,
+			Node:     "princely_musings",
+			Redirect: "xmpp:hamlet@denmark.lit?;node=blog",
+		},
+	},
+	4: {
+		in:      `<message xmlns="jabber:client"><event xmlns="http://jabber.org/protocol/pubsub#event"><configuration node="princely_musings"><x xmlns="jabber:x:data" type="result"><field var="FORM_TYPE" type="hidden"><value>http://jabber.org/protocol/pubsub#node_config</value></field></x></configuration></event></message>`,
+		ns:      "princely_musings",
+		handled: true,
+		event: event{
+			Type:   pubsub.EventConfig,
+			Node:   "princely_musings",
+			Config: true,
+		},
+	},
+	5: {
+		in:      `<message xmlns="jabber:client"><event xmlns="http://jabber.org/protocol/pubsub#event"><subscription node="princely_musings" jid="horatio@denmark.lit" subscription="none"/></event></message>`,
+		ns:      "princely_musings",
+		handled: true,
+		event: event{
+			Type: pubsub.EventSubscription,
+			Node: "princely_musings",
+			Sub:  pubsub.SubNone,
+		},
+	},
+	6: {
+		in: `<message xmlns="jabber:client"><event xmlns="http://jabber.org/protocol/pubsub#event"><purge node="other"/></event></message>`,
+		ns: "princely_musings",
+	},
+	7: {
+		in: `<message xmlns="jabber:client" type="chat"><event xmlns="http://jabber.org/protocol/pubsub#event"><purge node="princely_musings"/></event></message>`,
+		ns: "princely_musings",
+	},
+}
+
+func TestHandleEvent(t *testing.T) {
+	for i, tc := range eventTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var (
+				handled bool
+				got     event
+			)
+			subs := &pubsub.Subscriptions{}
+			subs.RegisterFunc(tc.ns, func(_ stanza.Message, e pubsub.Event) error {
+				handled = true
+				got = event{
+					Type:     e.Type,
+					Node:     e.Node,
+					Retract:  e.Retract,
+					Redirect: e.Redirect,
+					Config:   e.Config != nil,
+					Sub:      e.Subscription.Subscription,
+				}
+				for e.Items.Next() {
+					id, r := e.Items.Item()
+					var entry struct {
+						Title string `xml:"title"`
+					}
+					err := xml.NewTokenDecoder(r).Decode(&entry)
+					if err != nil {
+						return err
+					}
+					if got.Items == nil {
+						got.Items = make(map[string]string)
+					}
+					got.Items[id] = entry.Title
+				}
+				return e.Items.Err()
+			})
+			m := mux.New(stanza.NSClient, pubsub.Handle(subs))
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("error popping start token: %v", err)
+			}
+			start := tok.(xml.StartElement)
+			err = m.HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+			}, &start)
+			if err != nil {
+				t.Fatalf("error handling event: %v", err)
+			}
+			if handled != tc.handled {
+				t.Fatalf("wrong value for handled: want=%t, got=%t", tc.handled, handled)
+			}
+			if !reflect.DeepEqual(got, tc.event) {
+				t.Errorf("wrong event:\nwant=%+v,\n got=%+v", tc.event, got)
+			}
+		})
+	}
+}
+
+func TestSubscriptionsFeatures(t *testing.T) {
+	subs := &pubsub.Subscriptions{}
+	nop := pubsub.HandlerFunc(func(stanza.Message, pubsub.Event) error { return nil })
+	subs.Register("urn:xmpp:omemo:2:devices", nop)
+	subs.Register("urn:xmpp:avatar:metadata", nop)
+	subs.Register("urn:xmpp:bookmarks:1", nop)
+	subs.Unregister("urn:xmpp:bookmarks:1")
+
+	var features []string
+	err := subs.ForFeatures("", func(f info.Feature) error {
+		features = append(features, f.Var)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error iterating over features: %v", err)
+	}
+	expected := []string{"urn:xmpp:avatar:metadata+notify", "urn:xmpp:omemo:2:devices+notify"}
+	if !reflect.DeepEqual(features, expected) {
+		t.Errorf("wrong features: want=%v, got=%v", expected, features)
+	}
+	if _, ok := subs.Handler("urn:xmpp:bookmarks:1"); ok {
+		t.Errorf("expected handler to be removed")
+	}
+	if h, ok := subs.Handler("urn:xmpp:avatar:metadata"); !ok || h == nil {
+		t.Errorf("expected handler to be registered")
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"strconv"
+
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// NSNodeConfig is the form type used for node configuration forms.
+const NSNodeConfig = `http://jabber.org/protocol/pubsub#node_config`
+
+// AccessModel controls which entities may subscribe to a node and retrieve its
+// items.
+type AccessModel string
+
+// A list of access models supported by Service.
+const (
+	// AccessOpen allows any entity to subscribe and retrieve items.
+	AccessOpen AccessModel = "open"
+
+	// AccessPresence allows entities with a subscription to the presence of a
+	// node owner to subscribe and retrieve items.
+	AccessPresence AccessModel = "presence"
+
+	// AccessRoster allows entities in one of the roster groups listed in the
+	// node configuration of a node owner to subscribe and retrieve items.
+	AccessRoster AccessModel = "roster"
+
+	// AccessWhitelist allows only entities that are affiliated with the node to
+	// subscribe and retrieve items.
+	AccessWhitelist AccessModel = "whitelist"
+)
+
+// PublishModel controls which entities may publish to a node.
+type PublishModel string
+
+// A list of publish models supported by Service.
+const (
+	// PublishPublishers allows only owners and publishers to publish items.
+	PublishPublishers PublishModel = "publishers"
+
+	// PublishSubscribers allows subscribers and members to publish items in
+	// addition to owners and publishers.
+	PublishSubscribers PublishModel = "subscribers"
+
+	// PublishOpen allows any entity that is not an outcast to publish items.
+	PublishOpen PublishModel = "open"
+)
+
+// NodeConfig is the configuration of a node hosted by a Service.
+type NodeConfig struct {
+	Title        string
+	AccessModel  AccessModel
+	PublishModel PublishModel
+
+	// RosterGroups is the list of roster groups that are allowed to access the
+	// node when using the AccessRoster model.
+	RosterGroups []string
+
+	// MaxItems is the maximum number of items that are persisted to the node.
+	// If it is zero, there is no limit.
+	MaxItems uint64
+
+	// PersistItems controls whether published items are stored.
+	// If it is false, items are delivered to subscribers and then discarded.
+	PersistItems bool
+
+	// DeliverPayloads controls whether item payloads are included in
+	// notifications, or only their IDs.
+	DeliverPayloads bool
+
+	// NotifyConfig, NotifyDelete, and NotifyRetract control whether subscribers
+	// are notified when the configuration of the node changes, when the node is
+	// deleted, and when items are retracted respectively.
+	NotifyConfig  bool
+	NotifyDelete  bool
+	NotifyRetract bool
+}
+
+// DefaultNodeConfig is the configuration used for new nodes if a Service does
+// not set a default.
+var DefaultNodeConfig = NodeConfig{
+	AccessModel:     AccessOpen,
+	PublishModel:    PublishPublishers,
+	MaxItems:        10,
+	PersistItems:    true,
+	DeliverPayloads: true,
+	NotifyDelete:    true,
+	NotifyRetract:   true,
+}
+
+func formBool(b bool) form.Option {
+	if b {
+		return form.Value("1")
+	}
+	return form.Value("0")
+}
+
+// Form returns a node configuration form with its fields set to the values of
+// the configuration.
+func (c NodeConfig) Form() *form.Data {
+	groups := []form.Option{form.Label("Roster groups allowed to access the node")}
+	for _, group := range c.RosterGroups {
+		groups = append(groups, form.Value(group), form.ListItem(group, group))
+	}
+	return form.New(
+		form.Hidden("FORM_TYPE", form.Value(NSNodeConfig)),
+		form.Text("pubsub#title", form.Label("A friendly name for the node"), form.Value(c.Title)),
+		form.List("pubsub#access_model",
+			form.Label("Specify the access model"),
+			form.Value(string(c.AccessModel)),
+			form.ListItem("Subscription requests are approved automatically", string(AccessOpen)),
+			form.ListItem("Entities with a presence subscription may subscribe", string(AccessPresence)),
+			form.ListItem("Entities in the allowed roster groups may subscribe", string(AccessRoster)),
+			form.ListItem("Only affiliated entities may subscribe", string(AccessWhitelist)),
+		),
+		form.List("pubsub#publish_model",
+			form.Label("Specify the publisher model"),
+			form.Value(string(c.PublishModel)),
+			form.ListItem("Only publishers may publish", string(PublishPublishers)),
+			form.ListItem("Subscribers may publish", string(PublishSubscribers)),
+			form.ListItem("Anyone may publish", string(PublishOpen)),
+		),
+		form.ListMulti("pubsub#roster_groups_allowed", groups...),
+		form.Text("pubsub#max_items",
+			form.Label("Max number of items to persist"),
+			form.Value(maxItemsValue(c.MaxItems)),
+		),
+		form.Boolean("pubsub#persist_items", form.Label("Persist items to storage"), formBool(c.PersistItems)),
+		form.Boolean("pubsub#deliver_payloads", form.Label("Deliver payloads with event notifications"), formBool(c.DeliverPayloads)),
+		form.Boolean("pubsub#notify_config", form.Label("Notify subscribers when the node configuration changes"), formBool(c.NotifyConfig)),
+		form.Boolean("pubsub#notify_delete", form.Label("Notify subscribers when the node is deleted"), formBool(c.NotifyDelete)),
+		form.Boolean("pubsub#notify_retract", form.Label("Notify subscribers when items are removed from the node"), formBool(c.NotifyRetract)),
+	)
+}
+
+func maxItemsValue(max uint64) string {
+	if max == 0 {
+		return "max"
+	}
+	return strconv.FormatUint(max, 10)
+}
+
+var errBadRequest = stanza.Error{
+	Type:      stanza.Modify,
+	Condition: stanza.BadRequest,
+}
+
+// apply sets any fields from a submitted configuration form on the
+// configuration.
+// Fields that are not present in the form are left unchanged.
+func (c *NodeConfig) apply(data *form.Data) error {
+	if typ, ok := data.Raw("FORM_TYPE"); ok && (len(typ) != 1 || typ[0] != NSNodeConfig) {
+		return errBadRequest
+	}
+	single := func(id string) (string, bool) {
+		v, ok := data.Raw(id)
+		if !ok || len(v) == 0 {
+			return "", false
+		}
+		return v[0], true
+	}
+	boolean := func(id string, b *bool) error {
+		v, ok := single(id)
+		if !ok {
+			return nil
+		}
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return errBadRequest
+		}
+		*b = parsed
+		return nil
+	}
+
+	if v, ok := single("pubsub#title"); ok {
+		c.Title = v
+	}
+	if v, ok := single("pubsub#access_model"); ok {
+		switch model := AccessModel(v); model {
+		case AccessOpen, AccessPresence, AccessRoster, AccessWhitelist:
+			c.AccessModel = model
+		default:
+			return newCondError(stanza.Modify, stanza.NotAcceptable, CondUnsupportedAccessModel)
+		}
+	}
+	if v, ok := single("pubsub#publish_model"); ok {
+		switch model := PublishModel(v); model {
+		case PublishPublishers, PublishSubscribers, PublishOpen:
+			c.PublishModel = model
+		default:
+			return errBadRequest
+		}
+	}
+	if v, ok := data.Raw("pubsub#roster_groups_allowed"); ok {
+		c.RosterGroups = append([]string(nil), v...)
+	}
+	if v, ok := single("pubsub#max_items"); ok {
+		if v == "max" {
+			c.MaxItems = 0
+		} else {
+			max, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return errBadRequest
+			}
+			c.MaxItems = max
+		}
+	}
+	for id, b := range map[string]*bool{
+		"pubsub#persist_items":    &c.PersistItems,
+		"pubsub#deliver_payloads": &c.DeliverPayloads,
+		"pubsub#notify_config":    &c.NotifyConfig,
+		"pubsub#notify_delete":    &c.NotifyDelete,
+		"pubsub#notify_retract":   &c.NotifyRetract,
+	} {
+		if err := boolean(id, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
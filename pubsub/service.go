@@ -0,0 +1,1084 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/disco/items"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// HandleService returns an option that registers a Service to respond to
+// pubsub and pubsub owner requests.
+func HandleService(s *Service) mux.Option {
+	return func(m *mux.ServeMux) {
+		for _, name := range []xml.Name{
+			{Space: NS, Local: "pubsub"},
+			{Space: NSOwner, Local: "pubsub"},
+		} {
+			mux.IQ(stanza.GetIQ, name, s)(m)
+			mux.IQ(stanza.SetIQ, name, s)(m)
+		}
+	}
+}
+
+// Service is a publish–subscribe service that stores nodes, items, and
+// subscriptions in a Store.
+//
+// Event notifications are written to the stream on which the request that
+// triggered them was received unless Send is set.
+// This is suitable for services running as a component where the server routes
+// all outgoing stanzas.
+type Service struct {
+	// Store contains the nodes hosted by the service.
+	Store Store
+
+	// Addr is the address of the service.
+	// It is used as the sender of event notifications and nodes are only listed
+	// in service discovery item queries if it is set.
+	// If it is not set, notifications are sent from the address that the request
+	// which triggered them was sent to.
+	Addr jid.JID
+
+	// DefaultConfig is the configuration of new nodes.
+	// If it is nil, DefaultNodeConfig is used.
+	DefaultConfig *NodeConfig
+
+	// AutoCreate causes publishing to a node that does not exist to create it
+	// with the default configuration.
+	AutoCreate bool
+
+	// Send, if set, is used to transmit event notifications.
+	Send func(ctx context.Context, r xml.TokenReader) error
+
+	// Subscribed reports whether contact is subscribed to the presence of owner.
+	// It is used by nodes with the AccessPresence model and if it is nil access
+	// is always denied.
+	Subscribed func(ctx context.Context, owner, contact jid.JID) bool
+
+	// Groups returns the groups that contact belongs to in the roster of owner.
+	// It is used by nodes with the AccessRoster model and if it is nil access is
+	// always denied.
+	Groups func(ctx context.Context, owner, contact jid.JID) []string
+
+	mu    sync.Mutex
+	locks map[string]*nodeLock
+}
+
+// nodeLock serializes changes to a single node.
+type nodeLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockNode locks the node with the given ID while a change that reads from and
+// then writes to the store is made.
+// Other nodes are not affected and the returned function must be called to
+// release the lock.
+func (s *Service) lockNode(id string) (unlock func()) {
+	s.mu.Lock()
+	if s.locks == nil {
+		s.locks = make(map[string]*nodeLock)
+	}
+	l, ok := s.locks[id]
+	if !ok {
+		l = &nodeLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.mu.Unlock()
+	}
+}
+
+var serviceFeatures = []Feature{
+	FeatureAccessOpen,
+	FeatureAccessPresence,
+	FeatureAccessRoster,
+	FeatureAccessWhitelist,
+	FeatureConfigNode,
+	FeatureCreateAndConfigure,
+	FeatureCreateNodes,
+	FeatureDeleteItems,
+	FeatureDeleteNodes,
+	FeatureInstantNodes,
+	FeatureItemIDs,
+	FeatureMemberAffiliation,
+	FeatureModifyAffiliations,
+	FeatureOutcastAffiliation,
+	FeaturePersistentItems,
+	FeaturePublish,
+	FeaturePublishOnlyAffiliation,
+	FeaturePublisherAffiliation,
+	FeaturePurgeNodes,
+	FeatureRetractItems,
+	FeatureRetrieveAffiliations,
+	FeatureRetrieveDefault,
+	FeatureRetrieveItems,
+	FeatureRetrieveSubscriptions,
+	FeatureSubscribe,
+}
+
+// ForFeatures implements info.FeatureIter.
+func (s *Service) ForFeatures(node string, f func(info.Feature) error) error {
+	err := f(info.Feature{Var: NS})
+	if err != nil || node != "" {
+		return err
+	}
+	features := serviceFeatures
+	if s.AutoCreate {
+		features = append([]Feature{FeatureAutoCreate}, features...)
+	}
+	for _, feature := range features {
+		err = f(info.Feature{Var: NS + "#" + feature.String()})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForIdentities implements info.IdentityIter.
+func (s *Service) ForIdentities(node string, f func(info.Identity) error) error {
+	if node == "" {
+		return f(disco.PubsubService)
+	}
+	_, err := s.Store.Node(context.Background(), node)
+	if err != nil {
+		return nil
+	}
+	return f(disco.PubsubLeaf)
+}
+
+// ForItems implements items.Iter.
+func (s *Service) ForItems(node string, f func(items.Item) error) error {
+	if node != "" || s.Addr.Equal(jid.JID{}) {
+		return nil
+	}
+	ids, err := s.Store.Nodes(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err = f(items.Item{JID: s.Addr, Node: id})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// condError is a stanza error with an additional pubsub specific condition.
+type condError struct {
+	err     stanza.Error
+	cond    Condition
+	feature Feature
+}
+
+func (e condError) Error() string {
+	return e.err.Error()
+}
+
+func (e condError) Unwrap() error {
+	return e.err
+}
+
+func newCondError(typ stanza.ErrorType, cond stanza.Condition, pubsubCond Condition) condError {
+	return condError{
+		err:  stanza.Error{Type: typ, Condition: cond},
+		cond: pubsubCond,
+	}
+}
+
+func unsupported(feature Feature) condError {
+	return condError{
+		err:     stanza.Error{Type: stanza.Cancel, Condition: stanza.FeatureNotImplemented},
+		cond:    CondUnsupported,
+		feature: feature,
+	}
+}
+
+func (e condError) TokenReader() xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Space: NSErrors, Local: e.cond.String()}}
+	if e.cond == CondUnsupported {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: "feature"},
+			Value: e.feature.String(),
+		})
+	}
+	return e.err.Wrap(xmlstream.Wrap(nil, start))
+}
+
+var (
+	errForbidden = stanza.Error{
+		Type:      stanza.Auth,
+		Condition: stanza.Forbidden,
+	}
+	errNodeRequired = newCondError(stanza.Modify, stanza.BadRequest, CondNodeIDRequired)
+)
+
+// removeNS removes namespace declarations from item payloads since they are
+// added back by the encoder. See https://mellium.im/issue/75
+var removeNS = xmlstream.RemoveAttr(func(_ xml.StartElement, attr xml.Attr) bool {
+	return attr.Name.Local == "xmlns" || attr.Name.Space == "xmlns"
+})
+
+// payload captures the XML encoding of an element.
+type payload []byte
+
+func (p *payload) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, removeNS(xmlstream.MultiReader(
+		xmlstream.Token(start),
+		xmlstream.Inner(d),
+		xmlstream.Token(start.End()),
+	)))
+	if err != nil {
+		return err
+	}
+	err = e.Flush()
+	*p = buf.Bytes()
+	return err
+}
+
+func payloadReader(p []byte) xml.TokenReader {
+	if len(p) == 0 {
+		return nil
+	}
+	return removeNS(xml.NewDecoder(bytes.NewReader(p)))
+}
+
+type nodeRequest struct {
+	Node string `xml:"node,attr"`
+}
+
+type subRequest struct {
+	Node  string  `xml:"node,attr"`
+	JID   jid.JID `xml:"jid,attr"`
+	SubID string  `xml:"subid,attr"`
+}
+
+type itemRequest struct {
+	ID      string  `xml:"id,attr"`
+	Payload payload `xml:",any"`
+}
+
+// request is a pubsub or pubsub owner request.
+// Elements from both namespaces are decoded but only the ones that are valid
+// for the namespace and IQ type of the request are used.
+type request struct {
+	Create    *nodeRequest `xml:"create"`
+	Configure *struct {
+		Node string     `xml:"node,attr"`
+		Form *form.Data `xml:"jabber:x:data x"`
+	} `xml:"configure"`
+	Default *struct{} `xml:"default"`
+	Publish *struct {
+		Node  string        `xml:"node,attr"`
+		Items []itemRequest `xml:"item"`
+	} `xml:"publish"`
+	Retract *struct {
+		Node   string        `xml:"node,attr"`
+		Notify string        `xml:"notify,attr"`
+		Items  []itemRequest `xml:"item"`
+	} `xml:"retract"`
+	Items *struct {
+		Node     string        `xml:"node,attr"`
+		MaxItems uint64        `xml:"max_items,attr"`
+		Item     string        `xml:"item,attr"`
+		Items    []itemRequest `xml:"item"`
+	} `xml:"items"`
+	Subscribe     *subRequest  `xml:"subscribe"`
+	Unsubscribe   *subRequest  `xml:"unsubscribe"`
+	Options       *subRequest  `xml:"options"`
+	Subscriptions *nodeRequest `xml:"subscriptions"`
+	Affiliations  *struct {
+		Node         string `xml:"node,attr"`
+		Affiliations []struct {
+			JID         jid.JID     `xml:"jid,attr"`
+			Affiliation Affiliation `xml:"affiliation,attr"`
+		} `xml:"affiliation"`
+	} `xml:"affiliations"`
+	Delete *struct {
+		Node     string `xml:"node,attr"`
+		Redirect struct {
+			URI string `xml:"uri,attr"`
+		} `xml:"redirect"`
+	} `xml:"delete"`
+	Purge *nodeRequest `xml:"purge"`
+}
+
+// notifier collects event notifications that are sent after the response to a
+// request.
+type notifier struct {
+	from jid.JID
+	msgs []xml.TokenReader
+}
+
+// notify queues an event for every subscriber to a node that has an active
+// subscription.
+func (n *notifier) notify(subs []Subscription, payload func() xml.TokenReader) {
+	for _, sub := range subs {
+		if sub.Subscription != SubSubscribed {
+			continue
+		}
+		n.msgs = append(n.msgs, stanza.Message{
+			ID:   attr.RandomID(),
+			To:   sub.Addr,
+			From: n.from,
+			Type: stanza.NormalMessage,
+		}.Wrap(xmlstream.Wrap(
+			payload(),
+			xml.StartElement{Name: xml.Name{Space: NSEvent, Local: "event"}},
+		)))
+	}
+}
+
+func wrapNode(payload xml.TokenReader, local, node string) xml.TokenReader {
+	return xmlstream.Wrap(payload, xml.StartElement{
+		Name: xml.Name{Local: local},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}},
+	})
+}
+
+func itemReader(item Item, withPayload bool) xml.TokenReader {
+	var inner xml.TokenReader
+	if withPayload {
+		inner = payloadReader(item.Payload)
+	}
+	return xmlstream.Wrap(inner, xml.StartElement{
+		Name: xml.Name{Local: "item"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: item.ID}},
+	})
+}
+
+// HandleIQ implements mux.IQHandler.
+func (s *Service) HandleIQ(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	var req request
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&req)
+	if err != nil {
+		_, err = xmlstream.Copy(r, iq.Error(errBadRequest))
+		return err
+	}
+
+	ctx := context.Background()
+	n := &notifier{from: s.Addr}
+	if n.from.Equal(jid.JID{}) {
+		n.from = iq.To
+	}
+	resp, err := s.handle(ctx, iq, start.Name.Space == NSOwner, &req, n)
+	if err != nil {
+		_, err = xmlstream.Copy(r, iqError(iq, err))
+		return err
+	}
+	_, err = xmlstream.Copy(r, iq.Result(resp))
+	if err != nil {
+		return err
+	}
+	for _, msg := range n.msgs {
+		if s.Send != nil {
+			err = s.Send(ctx, msg)
+		} else {
+			_, err = xmlstream.Copy(r, msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func iqError(iq stanza.IQ, err error) xml.TokenReader {
+	var payload xml.TokenReader
+	var condErr condError
+	stanzaErr := stanza.Error{}
+	switch {
+	case errors.As(err, &condErr):
+		payload = condErr.TokenReader()
+	case errors.As(err, &stanzaErr):
+		payload = stanzaErr.TokenReader()
+	default:
+		payload = stanza.Error{
+			Type:      stanza.Wait,
+			Condition: stanza.InternalServerError,
+		}.TokenReader()
+	}
+	iq.Type = stanza.ErrorIQ
+	iq.From, iq.To = iq.To, iq.From
+	return iq.Wrap(payload)
+}
+
+func (s *Service) handle(ctx context.Context, iq stanza.IQ, owner bool, req *request, n *notifier) (xml.TokenReader, error) {
+	var payload xml.TokenReader
+	var err error
+	switch {
+	case owner && iq.Type == stanza.GetIQ && req.Configure != nil:
+		payload, err = s.getConfig(ctx, iq.From, req.Configure.Node)
+	case owner && iq.Type == stanza.GetIQ && req.Default != nil:
+		payload = xmlstream.Wrap(
+			s.defaultConfig().Form().TokenReader(),
+			xml.StartElement{Name: xml.Name{Local: "default"}},
+		)
+	case owner && iq.Type == stanza.GetIQ && req.Affiliations != nil:
+		payload, err = s.getNodeAffiliations(ctx, iq.From, req.Affiliations.Node)
+	case owner && iq.Type == stanza.SetIQ && req.Configure != nil:
+		return nil, s.setConfig(ctx, iq.From, req.Configure.Node, req.Configure.Form, n)
+	case owner && iq.Type == stanza.SetIQ && req.Delete != nil:
+		return nil, s.deleteNode(ctx, iq.From, req.Delete.Node, req.Delete.Redirect.URI, n)
+	case owner && iq.Type == stanza.SetIQ && req.Purge != nil:
+		return nil, s.purge(ctx, iq.From, req.Purge.Node, n)
+	case owner && iq.Type == stanza.SetIQ && req.Affiliations != nil:
+		return nil, s.setAffiliations(ctx, iq.From, req)
+	case owner:
+		return nil, stanza.Error{Type: stanza.Cancel, Condition: stanza.FeatureNotImplemented}
+
+	case iq.Type == stanza.GetIQ && req.Items != nil:
+		payload, err = s.items(ctx, iq.From, req)
+	case iq.Type == stanza.GetIQ && req.Subscriptions != nil:
+		payload, err = s.subscriptions(ctx, iq.From, req.Subscriptions.Node)
+	case iq.Type == stanza.GetIQ && req.Affiliations != nil:
+		payload, err = s.affiliations(ctx, iq.From, req.Affiliations.Node)
+	case iq.Type == stanza.SetIQ && req.Create != nil:
+		payload, err = s.create(ctx, iq.From, req)
+	case iq.Type == stanza.SetIQ && req.Publish != nil:
+		payload, err = s.publish(ctx, iq.From, req, n)
+	case iq.Type == stanza.SetIQ && req.Retract != nil:
+		return nil, s.retract(ctx, iq.From, req, n)
+	case iq.Type == stanza.SetIQ && req.Subscribe != nil:
+		payload, err = s.subscribe(ctx, iq.From, req.Subscribe)
+	case iq.Type == stanza.SetIQ && req.Unsubscribe != nil:
+		return nil, s.unsubscribe(ctx, iq.From, req.Unsubscribe)
+	case req.Options != nil:
+		return nil, unsupported(FeatureSubscriptionOptions)
+	default:
+		return nil, stanza.Error{Type: stanza.Cancel, Condition: stanza.FeatureNotImplemented}
+	}
+	if err != nil || payload == nil {
+		return nil, err
+	}
+	ns := NS
+	if owner {
+		ns = NSOwner
+	}
+	return xmlstream.Wrap(payload, xml.StartElement{Name: xml.Name{Space: ns, Local: "pubsub"}}), nil
+}
+
+func (s *Service) defaultConfig() NodeConfig {
+	if s.DefaultConfig != nil {
+		return *s.DefaultConfig
+	}
+	return DefaultNodeConfig
+}
+
+// ownerNode returns the node with the given ID if addr is one of its owners.
+func (s *Service) ownerNode(ctx context.Context, addr jid.JID, id string) (Node, error) {
+	if id == "" {
+		return Node{}, errNodeRequired
+	}
+	node, err := s.Store.Node(ctx, id)
+	if err != nil {
+		return node, err
+	}
+	if node.Affiliation(addr) != AffiliationOwner {
+		return node, errForbidden
+	}
+	return node, nil
+}
+
+func (n Node) owners() []jid.JID {
+	var owners []jid.JID
+	for k, aff := range n.Affiliations {
+		if aff != AffiliationOwner {
+			continue
+		}
+		j, err := jid.Parse(k)
+		if err != nil {
+			continue
+		}
+		owners = append(owners, j)
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		return owners[i].String() < owners[j].String()
+	})
+	return owners
+}
+
+// access returns an error if addr is not allowed to subscribe to the node or
+// retrieve its items.
+func (s *Service) access(ctx context.Context, node Node, addr jid.JID) error {
+	switch node.Affiliation(addr) {
+	case AffiliationOwner, AffiliationPublisher, AffiliationMember:
+		return nil
+	case AffiliationOutcast:
+		return errForbidden
+	}
+
+	switch node.Config.AccessModel {
+	case AccessPresence:
+		if s.Subscribed != nil {
+			for _, owner := range node.owners() {
+				if s.Subscribed(ctx, owner, addr.Bare()) {
+					return nil
+				}
+			}
+		}
+		return newCondError(stanza.Auth, stanza.NotAuthorized, CondPresenceRequired)
+	case AccessRoster:
+		if s.Groups != nil {
+			for _, owner := range node.owners() {
+				for _, group := range s.Groups(ctx, owner, addr.Bare()) {
+					for _, allowed := range node.Config.RosterGroups {
+						if group == allowed {
+							return nil
+						}
+					}
+				}
+			}
+		}
+		return newCondError(stanza.Auth, stanza.NotAuthorized, CondNotInRosterGroup)
+	case AccessWhitelist:
+		return newCondError(stanza.Cancel, stanza.NotAllowed, CondClosedNode)
+	}
+	return nil
+}
+
+// canPublish returns an error if addr is not allowed to publish to the node.
+func canPublish(node Node, subs []Subscription, addr jid.JID) error {
+	aff := node.Affiliation(addr)
+	switch aff {
+	case AffiliationOwner, AffiliationPublisher, AffiliationPublishOnly:
+		return nil
+	case AffiliationOutcast:
+		return errForbidden
+	}
+
+	switch node.Config.PublishModel {
+	case PublishOpen:
+		return nil
+	case PublishSubscribers:
+		if aff == AffiliationMember {
+			return nil
+		}
+		for _, sub := range subs {
+			if sub.Subscription == SubSubscribed && sub.Addr.Bare().Equal(addr.Bare()) {
+				return nil
+			}
+		}
+	}
+	return errForbidden
+}
+
+func (s *Service) create(ctx context.Context, from jid.JID, req *request) (xml.TokenReader, error) {
+	id := req.Create.Node
+	instant := id == ""
+	if instant {
+		id = attr.RandomID()
+	}
+	cfg := s.defaultConfig()
+	if req.Configure != nil && req.Configure.Form != nil {
+		err := cfg.apply(req.Configure.Form)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := s.Store.CreateNode(ctx, Node{
+		ID:     id,
+		Config: cfg,
+		Affiliations: map[string]Affiliation{
+			from.Bare().String(): AffiliationOwner,
+		},
+	})
+	if err != nil || !instant {
+		return nil, err
+	}
+	return wrapNode(nil, "create", id), nil
+}
+
+func (s *Service) publish(ctx context.Context, from jid.JID, req *request, n *notifier) (xml.TokenReader, error) {
+	if req.Publish.Node == "" {
+		return nil, errNodeRequired
+	}
+	node, err := s.Store.Node(ctx, req.Publish.Node)
+	if errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) && s.AutoCreate {
+		node = Node{
+			ID:     req.Publish.Node,
+			Config: s.defaultConfig(),
+			Affiliations: map[string]Affiliation{
+				from.Bare().String(): AffiliationOwner,
+			},
+		}
+		err = s.Store.CreateNode(ctx, node)
+	}
+	if err != nil {
+		return nil, err
+	}
+	subs, err := s.Store.Subscriptions(ctx, node.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = canPublish(node, subs, from)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := node.Config
+	item := Item{Publisher: from}
+	switch len(req.Publish.Items) {
+	case 0:
+		if cfg.PersistItems || cfg.DeliverPayloads {
+			return nil, newCondError(stanza.Modify, stanza.BadRequest, CondItemRequired)
+		}
+	case 1:
+		item.ID = req.Publish.Items[0].ID
+		item.Payload = req.Publish.Items[0].Payload
+		if len(item.Payload) == 0 && (cfg.PersistItems || cfg.DeliverPayloads) {
+			return nil, newCondError(stanza.Modify, stanza.BadRequest, CondPayloadRequired)
+		}
+	default:
+		return nil, newCondError(stanza.Modify, stanza.BadRequest, CondInvalidPayload)
+	}
+	if item.ID == "" {
+		item.ID = attr.RandomID()
+	}
+
+	if cfg.PersistItems {
+		err = s.Store.SetItem(ctx, node.ID, item, cfg.MaxItems)
+		if err != nil {
+			return nil, err
+		}
+	}
+	n.notify(subs, func() xml.TokenReader {
+		return wrapNode(itemReader(item, cfg.DeliverPayloads), "items", node.ID)
+	})
+	return wrapNode(itemReader(item, false), "publish", node.ID), nil
+}
+
+func (s *Service) retract(ctx context.Context, from jid.JID, req *request, n *notifier) error {
+	if req.Retract.Node == "" {
+		return errNodeRequired
+	}
+	if len(req.Retract.Items) == 0 {
+		return newCondError(stanza.Modify, stanza.BadRequest, CondItemRequired)
+	}
+	node, err := s.Store.Node(ctx, req.Retract.Node)
+	if err != nil {
+		return err
+	}
+	stored, err := s.Store.Items(ctx, node.ID)
+	if err != nil {
+		return err
+	}
+	aff := node.Affiliation(from)
+	var ids []string
+	for _, it := range req.Retract.Items {
+		idx := -1
+		for i, item := range stored {
+			if item.ID == it.ID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return errItemNotFound
+		}
+		if aff != AffiliationOwner && aff != AffiliationPublisher && !stored[idx].Publisher.Bare().Equal(from.Bare()) {
+			return errForbidden
+		}
+		ids = append(ids, it.ID)
+	}
+	for _, id := range ids {
+		err = s.Store.DeleteItem(ctx, node.ID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	notify, _ := strconv.ParseBool(req.Retract.Notify)
+	if !notify && !node.Config.NotifyRetract {
+		return nil
+	}
+	subs, err := s.Store.Subscriptions(ctx, node.ID)
+	if err != nil {
+		return err
+	}
+	n.notify(subs, func() xml.TokenReader {
+		var retracts []xml.TokenReader
+		for _, id := range ids {
+			retracts = append(retracts, xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "retract"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+			}))
+		}
+		return wrapNode(xmlstream.MultiReader(retracts...), "items", node.ID)
+	})
+	return nil
+}
+
+func (s *Service) items(ctx context.Context, from jid.JID, req *request) (xml.TokenReader, error) {
+	if req.Items.Node == "" {
+		return nil, errNodeRequired
+	}
+	node, err := s.Store.Node(ctx, req.Items.Node)
+	if err != nil {
+		return nil, err
+	}
+	err = s.access(ctx, node, from)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.Store.Items(ctx, node.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]struct{})
+	if req.Items.Item != "" {
+		ids[req.Items.Item] = struct{}{}
+	}
+	for _, item := range req.Items.Items {
+		ids[item.ID] = struct{}{}
+	}
+	var matched []Item
+	for _, item := range stored {
+		if _, ok := ids[item.ID]; len(ids) == 0 || ok {
+			matched = append(matched, item)
+		}
+	}
+	if max := req.Items.MaxItems; max > 0 && uint64(len(matched)) > max {
+		matched = matched[uint64(len(matched))-max:]
+	}
+
+	var inner []xml.TokenReader
+	for _, item := range matched {
+		inner = append(inner, itemReader(item, true))
+	}
+	return wrapNode(xmlstream.MultiReader(inner...), "items", node.ID), nil
+}
+
+func (s *Service) subscribe(ctx context.Context, from jid.JID, req *subRequest) (xml.TokenReader, error) {
+	if req.Node == "" {
+		return nil, errNodeRequired
+	}
+	if req.JID.Equal(jid.JID{}) {
+		return nil, newCondError(stanza.Modify, stanza.BadRequest, CondJIDRequired)
+	}
+	if !req.JID.Bare().Equal(from.Bare()) {
+		return nil, newCondError(stanza.Modify, stanza.BadRequest, CondInvalidJID)
+	}
+	node, err := s.Store.Node(ctx, req.Node)
+	if err != nil {
+		return nil, err
+	}
+	err = s.access(ctx, node, req.JID)
+	if err != nil {
+		return nil, err
+	}
+	unlock := s.lockNode(node.ID)
+	defer unlock()
+	subs, err := s.Store.Subscriptions(ctx, node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		if sub.Addr.Equal(req.JID) {
+			return sub.TokenReader(), nil
+		}
+	}
+
+	sub := Subscription{
+		ID:           attr.RandomID(),
+		Node:         node.ID,
+		Addr:         req.JID,
+		Subscription: SubSubscribed,
+	}
+	err = s.Store.SetSubscription(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+	return sub.TokenReader(), nil
+}
+
+func (s *Service) unsubscribe(ctx context.Context, from jid.JID, req *subRequest) error {
+	if req.Node == "" {
+		return errNodeRequired
+	}
+	if req.JID.Equal(jid.JID{}) {
+		return newCondError(stanza.Modify, stanza.BadRequest, CondJIDRequired)
+	}
+	if !req.JID.Bare().Equal(from.Bare()) {
+		return errForbidden
+	}
+	subs, err := s.Store.Subscriptions(ctx, req.Node)
+	if err != nil {
+		return err
+	}
+	var matched []Subscription
+	for _, sub := range subs {
+		if sub.Addr.Equal(req.JID) {
+			matched = append(matched, sub)
+		}
+	}
+
+	var sub *Subscription
+	switch {
+	case len(matched) == 0:
+		return newCondError(stanza.Cancel, stanza.UnexpectedRequest, CondNotSubscribed)
+	case req.SubID == "" && len(matched) > 1:
+		return newCondError(stanza.Modify, stanza.BadRequest, CondSubIDRequired)
+	case req.SubID == "":
+		sub = &matched[0]
+	default:
+		for i := range matched {
+			if matched[i].ID == req.SubID {
+				sub = &matched[i]
+				break
+			}
+		}
+		if sub == nil {
+			return newCondError(stanza.Modify, stanza.NotAcceptable, CondInvalidSubID)
+		}
+	}
+	return s.Store.DeleteSubscription(ctx, sub.Node, sub.Addr, sub.ID)
+}
+
+// nodes returns the node with the given ID or all nodes if id is empty.
+func (s *Service) nodes(ctx context.Context, id string) ([]string, error) {
+	if id != "" {
+		_, err := s.Store.Node(ctx, id)
+		return []string{id}, err
+	}
+	return s.Store.Nodes(ctx)
+}
+
+func (s *Service) subscriptions(ctx context.Context, from jid.JID, id string) (xml.TokenReader, error) {
+	ids, err := s.nodes(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var inner []xml.TokenReader
+	for _, id := range ids {
+		subs, err := s.Store.Subscriptions(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, sub := range subs {
+			if sub.Addr.Bare().Equal(from.Bare()) {
+				inner = append(inner, sub.TokenReader())
+			}
+		}
+	}
+	start := xml.StartElement{Name: xml.Name{Local: "subscriptions"}}
+	if id != "" {
+		start.Attr = []xml.Attr{{Name: xml.Name{Local: "node"}, Value: id}}
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start), nil
+}
+
+func affiliationReader(local, value string, aff Affiliation) xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "affiliation"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: local}, Value: value},
+			{Name: xml.Name{Local: "affiliation"}, Value: string(aff)},
+		},
+	})
+}
+
+func (s *Service) affiliations(ctx context.Context, from jid.JID, id string) (xml.TokenReader, error) {
+	ids, err := s.nodes(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var inner []xml.TokenReader
+	for _, id := range ids {
+		node, err := s.Store.Node(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if aff := node.Affiliation(from); aff != AffiliationNone {
+			inner = append(inner, affiliationReader("node", id, aff))
+		}
+	}
+	start := xml.StartElement{Name: xml.Name{Local: "affiliations"}}
+	if id != "" {
+		start.Attr = []xml.Attr{{Name: xml.Name{Local: "node"}, Value: id}}
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start), nil
+}
+
+func (s *Service) getNodeAffiliations(ctx context.Context, from jid.JID, id string) (xml.TokenReader, error) {
+	node, err := s.ownerNode(ctx, from, id)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(node.Affiliations))
+	for addr := range node.Affiliations {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	var inner []xml.TokenReader
+	for _, addr := range addrs {
+		inner = append(inner, affiliationReader("jid", addr, node.Affiliations[addr]))
+	}
+	return wrapNode(xmlstream.MultiReader(inner...), "affiliations", id), nil
+}
+
+func (s *Service) setAffiliations(ctx context.Context, from jid.JID, req *request) error {
+	unlock := s.lockNode(req.Affiliations.Node)
+	defer unlock()
+	node, err := s.ownerNode(ctx, from, req.Affiliations.Node)
+	if err != nil {
+		return err
+	}
+	var outcasts []jid.JID
+	for _, a := range req.Affiliations.Affiliations {
+		if a.JID.Equal(jid.JID{}) || !a.Affiliation.valid() {
+			return errBadRequest
+		}
+		key := a.JID.Bare().String()
+		switch a.Affiliation {
+		case AffiliationNone:
+			delete(node.Affiliations, key)
+		case AffiliationOutcast:
+			outcasts = append(outcasts, a.JID.Bare())
+			fallthrough
+		default:
+			node.Affiliations[key] = a.Affiliation
+		}
+	}
+	if len(node.owners()) == 0 {
+		return stanza.Error{Type: stanza.Modify, Condition: stanza.NotAcceptable}
+	}
+	err = s.Store.SetNode(ctx, node)
+	if err != nil || len(outcasts) == 0 {
+		return err
+	}
+
+	// Outcasts are not allowed to remain subscribed to the node.
+	subs, err := s.Store.Subscriptions(ctx, node.ID)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		for _, outcast := range outcasts {
+			if sub.Addr.Bare().Equal(outcast) {
+				err = s.Store.DeleteSubscription(ctx, sub.Node, sub.Addr, sub.ID)
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Service) getConfig(ctx context.Context, from jid.JID, id string) (xml.TokenReader, error) {
+	node, err := s.ownerNode(ctx, from, id)
+	if err != nil {
+		return nil, err
+	}
+	return wrapNode(node.Config.Form().TokenReader(), "configure", id), nil
+}
+
+func (s *Service) setConfig(ctx context.Context, from jid.JID, id string, data *form.Data, n *notifier) error {
+	unlock := s.lockNode(id)
+	defer unlock()
+	node, err := s.ownerNode(ctx, from, id)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return errBadRequest
+	}
+	err = node.Config.apply(data)
+	if err != nil {
+		return err
+	}
+	err = s.Store.SetNode(ctx, node)
+	if err != nil || !node.Config.NotifyConfig {
+		return err
+	}
+	subs, err := s.Store.Subscriptions(ctx, id)
+	if err != nil {
+		return err
+	}
+	n.notify(subs, func() xml.TokenReader {
+		return wrapNode(nil, "configuration", id)
+	})
+	return nil
+}
+
+func (s *Service) deleteNode(ctx context.Context, from jid.JID, id, redirect string, n *notifier) error {
+	node, err := s.ownerNode(ctx, from, id)
+	if err != nil {
+		return err
+	}
+	subs, err := s.Store.Subscriptions(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = s.Store.DeleteNode(ctx, id)
+	if err != nil || !node.Config.NotifyDelete {
+		return err
+	}
+	n.notify(subs, func() xml.TokenReader {
+		var inner xml.TokenReader
+		if redirect != "" {
+			inner = xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "redirect"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "uri"}, Value: redirect}},
+			})
+		}
+		return wrapNode(inner, "delete", id)
+	})
+	return nil
+}
+
+func (s *Service) purge(ctx context.Context, from jid.JID, id string, n *notifier) error {
+	node, err := s.ownerNode(ctx, from, id)
+	if err != nil {
+		return err
+	}
+	if !node.Config.PersistItems {
+		return unsupported(FeaturePersistentItems)
+	}
+	err = s.Store.Purge(ctx, id)
+	if err != nil || !node.Config.NotifyRetract {
+		return err
+	}
+	subs, err := s.Store.Subscriptions(ctx, id)
+	if err != nil {
+		return err
+	}
+	n.notify(subs, func() xml.TokenReader {
+		return wrapNode(nil, "purge", id)
+	})
+	return nil
+}
@@ -0,0 +1,590 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/disco/items"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/pubsub"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+var (
+	_ mux.IQHandler     = (*pubsub.Service)(nil)
+	_ info.FeatureIter  = (*pubsub.Service)(nil)
+	_ info.IdentityIter = (*pubsub.Service)(nil)
+	_ items.Iter        = (*pubsub.Service)(nil)
+)
+
+const testPayloadNS = "urn:example"
+
+var (
+	// The client address in xmpptest sessions.
+	clientAddr = jid.MustParse("test@example.net/res")
+	nodeOwner  = jid.MustParse("hamlet@denmark.lit/elsinore")
+)
+
+func testPayload(s string) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(s)),
+		xml.StartElement{Name: xml.Name{Space: testPayloadNS, Local: "payload"}},
+	)
+}
+
+type serviceEvent struct {
+	Type    pubsub.EventType
+	Node    string
+	Items   map[string]string
+	Retract []string
+}
+
+// newServiceTest returns a client/server pair where the server is running the
+// provided service and events received by the client are sent on the returned
+// channel.
+func newServiceTest(t *testing.T, s *pubsub.Service) (*xmpptest.ClientServer, chan serviceEvent) {
+	t.Helper()
+	events := make(chan serviceEvent, 10)
+	subs := &pubsub.Subscriptions{}
+	h := pubsub.HandlerFunc(func(_ stanza.Message, e pubsub.Event) error {
+		se := serviceEvent{Type: e.Type, Node: e.Node, Retract: e.Retract}
+		for e.Items.Next() {
+			id, r := e.Items.Item()
+			var p struct {
+				Text string `xml:",chardata"`
+			}
+			if r != nil {
+				err := xml.NewTokenDecoder(r).Decode(&p)
+				if err != nil {
+					return err
+				}
+			}
+			if se.Items == nil {
+				se.Items = make(map[string]string)
+			}
+			se.Items[id] = p.Text
+		}
+		events <- se
+		return e.Items.Err()
+	})
+	subs.Register(testPayloadNS, h)
+	subs.Register("princely_musings", h)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(stanza.NSClient, pubsub.HandleService(s))),
+		xmpptest.ClientHandler(mux.New("", pubsub.Handle(subs))),
+	)
+	return cs, events
+}
+
+func nextEvent(t *testing.T, events chan serviceEvent) serviceEvent {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	return serviceEvent{}
+}
+
+func TestService(t *testing.T) {
+	store := pubsub.NewStore()
+	cs, events := newServiceTest(t, &pubsub.Service{Store: store})
+	ctx := context.Background()
+	iq := stanza.IQ{From: clientAddr}
+
+	err := pubsub.CreateNodeIQ(ctx, cs.Client, iq, "princely_musings", nil)
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+	err = pubsub.CreateNodeIQ(ctx, cs.Client, iq, "princely_musings", nil)
+	if !errors.Is(err, stanza.Error{Condition: stanza.Conflict}) {
+		t.Fatalf("wrong error creating duplicate node: want=%v, got=%v", stanza.Conflict, err)
+	}
+	sub, err := pubsub.SubscribeIQ(ctx, cs.Client, iq, "princely_musings")
+	if err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+	if sub.Subscription != pubsub.SubSubscribed || sub.ID == "" || !sub.Addr.Equal(clientAddr.Bare()) {
+		t.Errorf("unexpected subscription: %+v", sub)
+	}
+
+	for i := 0; i < 3; i++ {
+		id, err := pubsub.PublishIQ(ctx, cs.Client, iq, "princely_musings", strconv.Itoa(i), testPayload("item "+strconv.Itoa(i)))
+		if err != nil {
+			t.Fatalf("error publishing item %d: %v", i, err)
+		}
+		if id != strconv.Itoa(i) {
+			t.Errorf("wrong item ID: want=%d, got=%s", i, id)
+		}
+		e := nextEvent(t, events)
+		expected := serviceEvent{
+			Type:  pubsub.EventItems,
+			Node:  "princely_musings",
+			Items: map[string]string{id: "item " + id},
+		}
+		if !reflect.DeepEqual(e, expected) {
+			t.Errorf("wrong event:\nwant=%+v,\n got=%+v", expected, e)
+		}
+	}
+	id, err := pubsub.PublishIQ(ctx, cs.Client, iq, "princely_musings", "", testPayload("generated"))
+	if err != nil {
+		t.Fatalf("error publishing item without ID: %v", err)
+	}
+	if id == "" {
+		t.Errorf("expected the service to generate an item ID")
+	}
+	nextEvent(t, events)
+
+	iter := pubsub.FetchIQ(ctx, iq, cs.Client, pubsub.Query{Node: "princely_musings", MaxItems: 2})
+	var fetched []string
+	for iter.Next() {
+		itemID, r := iter.Item()
+		var p struct {
+			XMLName xml.Name `xml:"urn:example payload"`
+			Text    string   `xml:",chardata"`
+		}
+		err = xml.NewTokenDecoder(r).Decode(&p)
+		if err != nil {
+			t.Fatalf("error decoding item %s: %v", itemID, err)
+		}
+		fetched = append(fetched, itemID+":"+p.Text)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error fetching items: %v", err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatalf("error closing iter: %v", err)
+	}
+	if expected := []string{"2:item 2", id + ":generated"}; !reflect.DeepEqual(fetched, expected) {
+		t.Errorf("wrong items: want=%v, got=%v", expected, fetched)
+	}
+
+	err = pubsub.DeleteIQ(ctx, cs.Client, iq, "princely_musings", "1", true)
+	if err != nil {
+		t.Fatalf("error retracting item: %v", err)
+	}
+	e := nextEvent(t, events)
+	if expected := (serviceEvent{Type: pubsub.EventItems, Node: "princely_musings", Retract: []string{"1"}}); !reflect.DeepEqual(e, expected) {
+		t.Errorf("wrong retract event:\nwant=%+v,\n got=%+v", expected, e)
+	}
+	err = pubsub.DeleteIQ(ctx, cs.Client, iq, "princely_musings", "1", true)
+	if !errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+		t.Errorf("wrong error retracting missing item: want=%v, got=%v", stanza.ItemNotFound, err)
+	}
+
+	stored, err := store.Items(ctx, "princely_musings")
+	if err != nil {
+		t.Fatalf("error fetching items from store: %v", err)
+	}
+	if len(stored) != 3 {
+		t.Errorf("wrong number of stored items: want=3, got=%d", len(stored))
+	}
+
+	subs, err := pubsub.GetSubscriptionsIQ(ctx, cs.Client, iq, "")
+	if err != nil {
+		t.Fatalf("error fetching subscriptions: %v", err)
+	}
+	if len(subs) != 1 || subs[0].ID != sub.ID {
+		t.Errorf("wrong subscriptions: want=[%+v], got=%+v", sub, subs)
+	}
+	err = pubsub.UnsubscribeIQ(ctx, cs.Client, iq, "princely_musings", "")
+	if err != nil {
+		t.Fatalf("error unsubscribing: %v", err)
+	}
+	err = pubsub.UnsubscribeIQ(ctx, cs.Client, iq, "princely_musings", "")
+	if !errors.Is(err, stanza.Error{Condition: stanza.UnexpectedRequest}) {
+		t.Errorf("wrong error unsubscribing twice: want=%v, got=%v", stanza.UnexpectedRequest, err)
+	}
+}
+
+func TestServiceOwner(t *testing.T) {
+	cs, events := newServiceTest(t, &pubsub.Service{Store: pubsub.NewStore()})
+	ctx := context.Background()
+	iq := stanza.IQ{From: clientAddr}
+
+	cfg, err := pubsub.GetDefaultConfigIQ(ctx, cs.Client, iq)
+	if err != nil {
+		t.Fatalf("error fetching default config: %v", err)
+	}
+	if model, _ := cfg.GetString("pubsub#access_model"); model != string(pubsub.AccessOpen) {
+		t.Errorf("wrong default access model: want=%s, got=%s", pubsub.AccessOpen, model)
+	}
+	_, err = cfg.Set("pubsub#max_items", "1")
+	if err != nil {
+		t.Fatalf("error setting max items: %v", err)
+	}
+	err = pubsub.CreateNodeIQ(ctx, cs.Client, iq, "princely_musings", cfg)
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+	cfg, err = pubsub.GetConfigIQ(ctx, cs.Client, iq, "princely_musings")
+	if err != nil {
+		t.Fatalf("error fetching config: %v", err)
+	}
+	if max, _ := cfg.GetString("pubsub#max_items"); max != "1" {
+		t.Errorf("wrong max items: want=1, got=%s", max)
+	}
+
+	_, err = cfg.Set("pubsub#notify_config", true)
+	if err != nil {
+		t.Fatalf("error setting notify config: %v", err)
+	}
+	_, err = pubsub.SubscribeIQ(ctx, cs.Client, iq, "princely_musings")
+	if err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+	err = pubsub.SetConfigIQ(ctx, cs.Client, iq, "princely_musings", cfg)
+	if err != nil {
+		t.Fatalf("error setting config: %v", err)
+	}
+	if e := nextEvent(t, events); e.Type != pubsub.EventConfig {
+		t.Errorf("wrong event type: want=%v, got=%v", pubsub.EventConfig, e.Type)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = pubsub.PublishIQ(ctx, cs.Client, iq, "princely_musings", strconv.Itoa(i), testPayload(""))
+		if err != nil {
+			t.Fatalf("error publishing: %v", err)
+		}
+		nextEvent(t, events)
+	}
+	iter := pubsub.FetchIQ(ctx, iq, cs.Client, pubsub.Query{Node: "princely_musings"})
+	var ids []string
+	for iter.Next() {
+		id, _ := iter.Item()
+		ids = append(ids, id)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatalf("error closing iter: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Errorf("expected max items to be respected, got items %v", ids)
+	}
+
+	// Other entities may not configure the node.
+	_, err = pubsub.GetConfigIQ(ctx, cs.Client, stanza.IQ{From: nodeOwner}, "princely_musings")
+	if !errors.Is(err, stanza.Error{Condition: stanza.Forbidden}) {
+		t.Errorf("wrong error configuring node as non-owner: want=%v, got=%v", stanza.Forbidden, err)
+	}
+
+	err = cs.Client.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "purge"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: "princely_musings"}},
+		}),
+		xml.StartElement{Name: xml.Name{Space: pubsub.NSOwner, Local: "pubsub"}},
+	), stanza.IQ{Type: stanza.SetIQ, From: clientAddr}, nil)
+	if err != nil {
+		t.Fatalf("error purging node: %v", err)
+	}
+	if e := nextEvent(t, events); e.Type != pubsub.EventPurge {
+		t.Errorf("wrong event type: want=%v, got=%v", pubsub.EventPurge, e.Type)
+	}
+
+	err = cs.Client.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "delete"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: "princely_musings"}},
+		}),
+		xml.StartElement{Name: xml.Name{Space: pubsub.NSOwner, Local: "pubsub"}},
+	), stanza.IQ{Type: stanza.SetIQ, From: clientAddr}, nil)
+	if err != nil {
+		t.Fatalf("error deleting node: %v", err)
+	}
+	if e := nextEvent(t, events); e.Type != pubsub.EventDelete {
+		t.Errorf("wrong event type: want=%v, got=%v", pubsub.EventDelete, e.Type)
+	}
+	_, err = pubsub.GetConfigIQ(ctx, cs.Client, iq, "princely_musings")
+	if !errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+		t.Errorf("wrong error fetching config of deleted node: want=%v, got=%v", stanza.ItemNotFound, err)
+	}
+}
+
+var accessTestCases = [...]struct {
+	model      pubsub.AccessModel
+	subscribed bool
+	groups     []string
+	aff        pubsub.Affiliation
+	err        error
+}{
+	0: {model: pubsub.AccessOpen},
+	1: {model: pubsub.AccessOpen, aff: pubsub.AffiliationOutcast, err: stanza.Error{Condition: stanza.Forbidden}},
+	2: {model: pubsub.AccessPresence, err: stanza.Error{Condition: stanza.NotAuthorized}},
+	3: {model: pubsub.AccessPresence, subscribed: true},
+	4: {model: pubsub.AccessRoster, groups: []string{"Enemies"}, err: stanza.Error{Condition: stanza.NotAuthorized}},
+	5: {model: pubsub.AccessRoster, groups: []string{"Enemies", "Friends"}},
+	6: {model: pubsub.AccessWhitelist, err: stanza.Error{Condition: stanza.NotAllowed}},
+	7: {model: pubsub.AccessWhitelist, aff: pubsub.AffiliationMember},
+}
+
+func TestServiceAccess(t *testing.T) {
+	for i, tc := range accessTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx := context.Background()
+			store := pubsub.NewStore()
+			cfg := pubsub.DefaultNodeConfig
+			cfg.AccessModel = tc.model
+			cfg.RosterGroups = []string{"Friends"}
+			affs := map[string]pubsub.Affiliation{
+				nodeOwner.Bare().String(): pubsub.AffiliationOwner,
+			}
+			if tc.aff != "" {
+				affs[clientAddr.Bare().String()] = tc.aff
+			}
+			err := store.CreateNode(ctx, pubsub.Node{ID: "princely_musings", Config: cfg, Affiliations: affs})
+			if err != nil {
+				t.Fatalf("error creating node: %v", err)
+			}
+			cs, _ := newServiceTest(t, &pubsub.Service{
+				Store: store,
+				Subscribed: func(_ context.Context, owner, contact jid.JID) bool {
+					return tc.subscribed && owner.Equal(nodeOwner.Bare()) && contact.Equal(clientAddr.Bare())
+				},
+				Groups: func(_ context.Context, owner, contact jid.JID) []string {
+					return tc.groups
+				},
+			})
+
+			_, err = pubsub.SubscribeIQ(ctx, cs.Client, stanza.IQ{From: clientAddr}, "princely_musings")
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("wrong error subscribing: want=%v, got=%v", tc.err, err)
+			}
+			iter := pubsub.FetchIQ(ctx, stanza.IQ{From: clientAddr}, cs.Client, pubsub.Query{Node: "princely_musings"})
+			for iter.Next() {
+			}
+			err = iter.Err()
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("wrong error fetching items: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+// TestServiceCallbackRequest checks that the roster callbacks are not called
+// while the service is locked so that they can make requests to the service.
+func TestServiceCallbackRequest(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	store := pubsub.NewStore()
+	cfg := pubsub.DefaultNodeConfig
+	cfg.AccessModel = pubsub.AccessPresence
+	err := store.CreateNode(ctx, pubsub.Node{
+		ID:           "princely_musings",
+		Config:       cfg,
+		Affiliations: map[string]pubsub.Affiliation{nodeOwner.Bare().String(): pubsub.AffiliationOwner},
+	})
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+
+	var other *xmpptest.ClientServer
+	svc := &pubsub.Service{Store: store}
+	svc.Subscribed = func(ctx context.Context, owner, contact jid.JID) bool {
+		// Look up the subscription using a request to the service over another
+		// stream, as a service that stores rosters in another node might.
+		_, err := pubsub.PublishIQ(ctx, other.Client, stanza.IQ{From: nodeOwner}, "presence", "1", testPayload("subscribed"))
+		return err == nil
+	}
+	cs, _ := newServiceTest(t, svc)
+	other, _ = newServiceTest(t, svc)
+	err = pubsub.CreateNodeIQ(ctx, other.Client, stanza.IQ{From: nodeOwner}, "presence", nil)
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+
+	_, err = pubsub.SubscribeIQ(ctx, cs.Client, stanza.IQ{From: clientAddr}, "princely_musings")
+	if err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+}
+
+func TestServicePublishModel(t *testing.T) {
+	ctx := context.Background()
+	store := pubsub.NewStore()
+	err := store.CreateNode(ctx, pubsub.Node{
+		ID:     "princely_musings",
+		Config: pubsub.DefaultNodeConfig,
+		Affiliations: map[string]pubsub.Affiliation{
+			nodeOwner.Bare().String(): pubsub.AffiliationOwner,
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+	cs, _ := newServiceTest(t, &pubsub.Service{Store: store, AutoCreate: true})
+	iq := stanza.IQ{From: clientAddr}
+
+	_, err = pubsub.PublishIQ(ctx, cs.Client, iq, "princely_musings", "", testPayload(""))
+	if !errors.Is(err, stanza.Error{Condition: stanza.Forbidden}) {
+		t.Errorf("wrong error publishing without permission: want=%v, got=%v", stanza.Forbidden, err)
+	}
+	_, err = pubsub.PublishIQ(ctx, cs.Client, iq, "new_node", "", testPayload(""))
+	if err != nil {
+		t.Fatalf("error publishing to auto-created node: %v", err)
+	}
+	node, err := store.Node(ctx, "new_node")
+	if err != nil {
+		t.Fatalf("expected node to be created: %v", err)
+	}
+	if aff := node.Affiliation(clientAddr); aff != pubsub.AffiliationOwner {
+		t.Errorf("wrong affiliation for publisher of auto-created node: want=%s, got=%s", pubsub.AffiliationOwner, aff)
+	}
+}
+
+func TestServiceErrorCondition(t *testing.T) {
+	m := mux.New(stanza.NSClient, pubsub.HandleService(&pubsub.Service{Store: pubsub.NewStore()}))
+	const in = `<iq xmlns="jabber:client" type="set" id="123" from="test@example.net"><pubsub xmlns="http://jabber.org/protocol/pubsub"><subscribe node="princely_musings"/></pubsub></iq>`
+	d := xml.NewDecoder(strings.NewReader(in))
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatalf("error popping start token: %v", err)
+	}
+	start := tok.(xml.StartElement)
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	err = m.HandleXMPP(struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: d,
+		Encoder:     e,
+	}, &start)
+	if err != nil {
+		t.Fatalf("error handling IQ: %v", err)
+	}
+	if err = e.Flush(); err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const expected = `<jid-required xmlns="http://jabber.org/protocol/pubsub#errors"></jid-required>`
+	if s := buf.String(); !strings.Contains(s, expected) || !strings.Contains(s, "bad-request") {
+		t.Errorf("expected bad-request error with pubsub condition, got: %s", s)
+	}
+}
+
+func TestServiceDisco(t *testing.T) {
+	ctx := context.Background()
+	store := pubsub.NewStore()
+	err := store.CreateNode(ctx, pubsub.Node{ID: "princely_musings", Config: pubsub.DefaultNodeConfig})
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+	addr := jid.MustParse("pubsub.shakespeare.lit")
+	s := &pubsub.Service{Store: store, Addr: addr}
+
+	var features []string
+	err = s.ForFeatures("", func(f info.Feature) error {
+		features = append(features, f.Var)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error iterating over features: %v", err)
+	}
+	for _, feature := range []pubsub.Feature{pubsub.FeatureCreateNodes, pubsub.FeaturePublish, pubsub.FeatureSubscribe, pubsub.FeatureAccessWhitelist} {
+		found := false
+		for _, f := range features {
+			if f == pubsub.NS+"#"+feature.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("feature %s not advertised", feature)
+		}
+	}
+
+	var nodes []items.Item
+	err = s.ForItems("", func(i items.Item) error {
+		nodes = append(nodes, i)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error iterating over items: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].Node != "princely_musings" || !nodes[0].JID.Equal(addr) {
+		t.Errorf("wrong items: %+v", nodes)
+	}
+}
+
+func TestNodeConfigForm(t *testing.T) {
+	cfg := pubsub.NodeConfig{
+		Title:        "Princely Musings",
+		AccessModel:  pubsub.AccessRoster,
+		PublishModel: pubsub.PublishOpen,
+		RosterGroups: []string{"Friends"},
+		PersistItems: true,
+	}
+	data := cfg.Form()
+	for field, expected := range map[string]string{
+		"FORM_TYPE":            pubsub.NSNodeConfig,
+		"pubsub#title":         "Princely Musings",
+		"pubsub#access_model":  "roster",
+		"pubsub#publish_model": "open",
+		"pubsub#max_items":     "max",
+	} {
+		if v, _ := data.GetString(field); v != expected {
+			t.Errorf("wrong value for %s: want=%s, got=%s", field, expected, v)
+		}
+	}
+	if groups, _ := data.GetStrings("pubsub#roster_groups_allowed"); !reflect.DeepEqual(groups, []string{"Friends"}) {
+		t.Errorf("wrong roster groups: %v", groups)
+	}
+	if persist, _ := data.GetBool("pubsub#persist_items"); !persist {
+		t.Errorf("expected persist items to be set")
+	}
+	var fields int
+	data.ForFields(func(form.FieldData) { fields++ })
+	if fields != 11 {
+		t.Errorf("wrong number of fields: want=11, got=%d", fields)
+	}
+}
+
+func TestStoreMaxItems(t *testing.T) {
+	ctx := context.Background()
+	store := pubsub.NewStore()
+	err := store.SetItem(ctx, "princely_musings", pubsub.Item{ID: "1"}, 0)
+	if !errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+		t.Errorf("wrong error publishing to missing node: want=%v, got=%v", stanza.ItemNotFound, err)
+	}
+	err = store.CreateNode(ctx, pubsub.Node{ID: "princely_musings"})
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+	for _, id := range []string{"1", "2", "3", "2"} {
+		err = store.SetItem(ctx, "princely_musings", pubsub.Item{ID: id}, 2)
+		if err != nil {
+			t.Fatalf("error setting item %s: %v", id, err)
+		}
+	}
+	stored, err := store.Items(ctx, "princely_musings")
+	if err != nil {
+		t.Fatalf("error fetching items: %v", err)
+	}
+	var ids []string
+	for _, item := range stored {
+		ids = append(ids, item.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"3", "2"}) {
+		t.Errorf("wrong items: want=[3 2], got=%v", ids)
+	}
+	err = store.DeleteItem(ctx, "princely_musings", "1")
+	if !errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+		t.Errorf("wrong error deleting missing item: want=%v, got=%v", stanza.ItemNotFound, err)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Affiliation is the relationship between an entity and a node.
+type Affiliation string
+
+// A list of possible affiliations.
+const (
+	AffiliationOwner       Affiliation = "owner"
+	AffiliationPublisher   Affiliation = "publisher"
+	AffiliationPublishOnly Affiliation = "publish-only"
+	AffiliationMember      Affiliation = "member"
+	AffiliationNone        Affiliation = "none"
+	AffiliationOutcast     Affiliation = "outcast"
+)
+
+func (a Affiliation) valid() bool {
+	switch a {
+	case AffiliationOwner, AffiliationPublisher, AffiliationPublishOnly,
+		AffiliationMember, AffiliationNone, AffiliationOutcast:
+		return true
+	}
+	return false
+}
+
+// Node is a node hosted by a Service.
+type Node struct {
+	// ID is the unique identifier of the node on the service.
+	ID string
+
+	// Config is the current configuration of the node.
+	Config NodeConfig
+
+	// Affiliations maps bare JIDs (as strings) to their affiliation with the
+	// node.
+	// Entities that are not in the map have no affiliation.
+	Affiliations map[string]Affiliation
+}
+
+// Affiliation returns the affiliation of the bare JID of addr with the node.
+func (n Node) Affiliation(addr jid.JID) Affiliation {
+	aff, ok := n.Affiliations[addr.Bare().String()]
+	if !ok {
+		return AffiliationNone
+	}
+	return aff
+}
+
+func (n Node) copy() Node {
+	affs := make(map[string]Affiliation, len(n.Affiliations))
+	for k, v := range n.Affiliations {
+		affs[k] = v
+	}
+	n.Affiliations = affs
+	n.Config.RosterGroups = append([]string(nil), n.Config.RosterGroups...)
+	return n
+}
+
+// Item is an item published to a node.
+type Item struct {
+	// ID is the unique ID of the item within the node.
+	ID string
+
+	// Publisher is the address of the entity that published the item.
+	Publisher jid.JID
+
+	// Payload is the XML encoding of the item payload.
+	// It may be empty for items that only trigger a notification.
+	Payload []byte
+}
+
+// Store persists nodes, subscriptions, and items for a Service.
+//
+// Methods that operate on a node that does not exist should return an
+// item-not-found stanza error.
+// Stores must be safe for concurrent use since requests for different nodes are
+// handled in parallel.
+type Store interface {
+	// CreateNode adds a new node to the store.
+	// If the node already exists a conflict stanza error is returned.
+	CreateNode(ctx context.Context, node Node) error
+
+	// Node returns the node with the given ID.
+	Node(ctx context.Context, id string) (Node, error)
+
+	// SetNode replaces the configuration and affiliations of an existing node.
+	SetNode(ctx context.Context, node Node) error
+
+	// DeleteNode removes a node along with all of its items and subscriptions.
+	DeleteNode(ctx context.Context, id string) error
+
+	// Nodes returns the IDs of all nodes in the store in sorted order.
+	Nodes(ctx context.Context) ([]string, error)
+
+	// SetSubscription adds a subscription or replaces an existing subscription
+	// with the same node, address, and subscription ID.
+	SetSubscription(ctx context.Context, sub Subscription) error
+
+	// DeleteSubscription removes a subscription.
+	DeleteSubscription(ctx context.Context, node string, addr jid.JID, subID string) error
+
+	// Subscriptions returns all subscriptions to a node.
+	Subscriptions(ctx context.Context, node string) ([]Subscription, error)
+
+	// SetItem publishes an item to a node, replacing any existing item with the
+	// same ID.
+	// The newest item is always last, and if max is non-zero the oldest items
+	// are removed until at most max items remain.
+	SetItem(ctx context.Context, node string, item Item, max uint64) error
+
+	// Items returns all items in a node, oldest first.
+	Items(ctx context.Context, node string) ([]Item, error)
+
+	// DeleteItem removes an item from a node.
+	// If the item does not exist an item-not-found stanza error is returned.
+	DeleteItem(ctx context.Context, node, id string) error
+
+	// Purge removes all items from a node.
+	Purge(ctx context.Context, node string) error
+}
+
+// NewStore returns a Store that keeps all nodes in memory.
+func NewStore() Store {
+	return &memStore{
+		nodes: make(map[string]*memNode),
+	}
+}
+
+type memNode struct {
+	node  Node
+	subs  []Subscription
+	items []Item
+}
+
+type memStore struct {
+	sync.Mutex
+	nodes map[string]*memNode
+}
+
+var (
+	errItemNotFound = stanza.Error{
+		Type:      stanza.Cancel,
+		Condition: stanza.ItemNotFound,
+	}
+	errConflict = stanza.Error{
+		Type:      stanza.Cancel,
+		Condition: stanza.Conflict,
+	}
+)
+
+func (m *memStore) get(id string) (*memNode, error) {
+	n, ok := m.nodes[id]
+	if !ok {
+		return nil, errItemNotFound
+	}
+	return n, nil
+}
+
+func (m *memStore) CreateNode(_ context.Context, node Node) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.nodes[node.ID]; ok {
+		return errConflict
+	}
+	m.nodes[node.ID] = &memNode{node: node.copy()}
+	return nil
+}
+
+func (m *memStore) Node(_ context.Context, id string) (Node, error) {
+	m.Lock()
+	defer m.Unlock()
+	n, err := m.get(id)
+	if err != nil {
+		return Node{}, err
+	}
+	return n.node.copy(), nil
+}
+
+func (m *memStore) SetNode(_ context.Context, node Node) error {
+	m.Lock()
+	defer m.Unlock()
+	n, err := m.get(node.ID)
+	if err != nil {
+		return err
+	}
+	n.node = node.copy()
+	return nil
+}
+
+func (m *memStore) DeleteNode(_ context.Context, id string) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, err := m.get(id); err != nil {
+		return err
+	}
+	delete(m.nodes, id)
+	return nil
+}
+
+func (m *memStore) Nodes(context.Context) ([]string, error) {
+	m.Lock()
+	defer m.Unlock()
+	ids := make([]string, 0, len(m.nodes))
+	for id := range m.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *memStore) SetSubscription(_ context.Context, sub Subscription) error {
+	m.Lock()
+	defer m.Unlock()
+	n, err := m.get(sub.Node)
+	if err != nil {
+		return err
+	}
+	for i, s := range n.subs {
+		if s.ID == sub.ID && s.Addr.Equal(sub.Addr) {
+			n.subs[i] = sub
+			return nil
+		}
+	}
+	n.subs = append(n.subs, sub)
+	return nil
+}
+
+func (m *memStore) DeleteSubscription(_ context.Context, node string, addr jid.JID, subID string) error {
+	m.Lock()
+	defer m.Unlock()
+	n, err := m.get(node)
+	if err != nil {
+		return err
+	}
+	for i, s := range n.subs {
+		if s.ID == subID && s.Addr.Equal(addr) {
+			n.subs = append(n.subs[:i], n.subs[i+1:]...)
+			return nil
+		}
+	}
+	return errItemNotFound
+}
+
+func (m *memStore) Subscriptions(_ context.Context, node string) ([]Subscription, error) {
+	m.Lock()
+	defer m.Unlock()
+	n, err := m.get(node)
+	if err != nil {
+		return nil, err
+	}
+	return append([]Subscription(nil), n.subs...), nil
+}
+
+func (m *memStore) SetItem(_ context.Context, node string, item Item, max uint64) error {
+	m.Lock()
+	defer m.Unlock()
+	n, err := m.get(node)
+	if err != nil {
+		return err
+	}
+	for i, it := range n.items {
+		if it.ID == item.ID {
+			n.items = append(n.items[:i], n.items[i+1:]...)
+			break
+		}
+	}
+	n.items = append(n.items, item)
+	if max > 0 && uint64(len(n.items)) > max {
+		n.items = append([]Item(nil), n.items[uint64(len(n.items))-max:]...)
+	}
+	return nil
+}
+
+func (m *memStore) Items(_ context.Context, node string) ([]Item, error) {
+	m.Lock()
+	defer m.Unlock()
+	n, err := m.get(node)
+	if err != nil {
+		return nil, err
+	}
+	return append([]Item(nil), n.items...), nil
+}
+
+func (m *memStore) DeleteItem(_ context.Context, node, id string) error {
+	m.Lock()
+	defer m.Unlock()
+	n, err := m.get(node)
+	if err != nil {
+		return err
+	}
+	for i, it := range n.items {
+		if it.ID == id {
+			n.items = append(n.items[:i], n.items[i+1:]...)
+			return nil
+		}
+	}
+	return errItemNotFound
+}
+
+func (m *memStore) Purge(_ context.Context, node string) error {
+	m.Lock()
+	defer m.Unlock()
+	n, err := m.get(node)
+	if err != nil {
+		return err
+	}
+	n.items = nil
+	return nil
+}
@@ -0,0 +1,290 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// UnmarshalXMLAttr satisfies xml.UnmarshalerAttr.
+func (s *SubType) UnmarshalXMLAttr(attr xml.Attr) error {
+	for sub := SubNone; sub <= SubUnconfigured; sub++ {
+		if sub.String() == attr.Value {
+			*s = sub
+			return nil
+		}
+	}
+	*s = SubNone
+	return nil
+}
+
+// MarshalXMLAttr satisfies xml.MarshalerAttr.
+func (s SubType) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: name, Value: s.String()}, nil
+}
+
+// Subscription is a description of a particular subscription for which we will
+// receive events.
+type Subscription struct {
+	// ID is the subscription ID, used to distinguish between multiple
+	// subscriptions to the same node by the same entity.
+	// It may be empty if the service does not support multiple subscriptions.
+	ID string
+
+	// Node is the node to which the subscription applies.
+	Node string
+
+	// Addr is the address of the subscriber.
+	Addr jid.JID
+
+	// Subscription is the current state of the subscription.
+	Subscription SubType
+
+	// Configurable indicates that the subscription supports subscription
+	// options and ConfigRequired indicates that the options must be submitted
+	// before the subscription can receive events.
+	Configurable   bool
+	ConfigRequired bool
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (s Subscription) TokenReader() xml.TokenReader {
+	attrs := []xml.Attr{{Name: xml.Name{Local: "node"}, Value: s.Node}}
+	if !s.Addr.Equal(jid.JID{}) {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "jid"}, Value: s.Addr.String()})
+	}
+	if s.ID != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "subid"}, Value: s.ID})
+	}
+	attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "subscription"}, Value: s.Subscription.String()})
+
+	var inner xml.TokenReader
+	if s.Configurable || s.ConfigRequired {
+		var required xml.TokenReader
+		if s.ConfigRequired {
+			required = xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "required"}})
+		}
+		inner = xmlstream.Wrap(required, xml.StartElement{Name: xml.Name{Local: "subscribe-options"}})
+	}
+	return xmlstream.Wrap(
+		inner,
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "subscription"}, Attr: attrs},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (s Subscription) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (s Subscription) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := s.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (s *Subscription) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var sub struct {
+		Node         string  `xml:"node,attr"`
+		JID          string  `xml:"jid,attr"`
+		ID           string  `xml:"subid,attr"`
+		Subscription SubType `xml:"subscription,attr"`
+		Options      *struct {
+			Required *struct{} `xml:"required"`
+		} `xml:"subscribe-options"`
+	}
+	err := d.DecodeElement(&sub, &start)
+	if err != nil {
+		return err
+	}
+	var addr jid.JID
+	if sub.JID != "" {
+		addr, err = jid.Parse(sub.JID)
+		if err != nil {
+			return err
+		}
+	}
+	*s = Subscription{
+		ID:             sub.ID,
+		Node:           sub.Node,
+		Addr:           addr,
+		Subscription:   sub.Subscription,
+		Configurable:   sub.Options != nil,
+		ConfigRequired: sub.Options != nil && sub.Options.Required != nil,
+	}
+	return nil
+}
+
+func subAttrs(node string, addr jid.JID, subID string) []xml.Attr {
+	attrs := []xml.Attr{
+		{Name: xml.Name{Local: "node"}, Value: node},
+		{Name: xml.Name{Local: "jid"}, Value: addr.String()},
+	}
+	if subID != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "subid"}, Value: subID})
+	}
+	return attrs
+}
+
+// Subscribe subscribes the bare JID of the session to the provided node.
+func Subscribe(ctx context.Context, s *xmpp.Session, node string) (Subscription, error) {
+	return SubscribeIQ(ctx, s, stanza.IQ{}, node)
+}
+
+// SubscribeIQ is like Subscribe except that it allows modifying the IQ.
+// Changes to the IQ type will have no effect.
+func SubscribeIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node string) (Subscription, error) {
+	iq.Type = stanza.SetIQ
+	addr := s.LocalAddr().Bare()
+	var resp struct {
+		XMLName      xml.Name      `xml:"http://jabber.org/protocol/pubsub pubsub"`
+		Subscription *Subscription `xml:"subscription"`
+	}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			nil,
+			xml.StartElement{Name: xml.Name{Local: "subscribe"}, Attr: subAttrs(node, addr, "")},
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "pubsub"}},
+	), iq, &resp)
+	if err != nil {
+		return Subscription{}, err
+	}
+	// The service is not required to include the subscription in its response,
+	// in which case the subscription is assumed to have been successful.
+	if resp.Subscription == nil {
+		return Subscription{
+			Node:         node,
+			Addr:         addr,
+			Subscription: SubSubscribed,
+		}, nil
+	}
+	sub := *resp.Subscription
+	if sub.Node == "" {
+		sub.Node = node
+	}
+	if sub.Addr.Equal(jid.JID{}) {
+		sub.Addr = addr
+	}
+	return sub, nil
+}
+
+// Unsubscribe removes the subscription of the bare JID of the session from the
+// provided node.
+// If the service supports multiple subscriptions to the same node, subID may be
+// used to select the subscription to remove.
+func Unsubscribe(ctx context.Context, s *xmpp.Session, node, subID string) error {
+	return UnsubscribeIQ(ctx, s, stanza.IQ{}, node, subID)
+}
+
+// UnsubscribeIQ is like Unsubscribe except that it allows modifying the IQ.
+// Changes to the IQ type will have no effect.
+func UnsubscribeIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node, subID string) error {
+	iq.Type = stanza.SetIQ
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			nil,
+			xml.StartElement{Name: xml.Name{Local: "unsubscribe"}, Attr: subAttrs(node, s.LocalAddr().Bare(), subID)},
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "pubsub"}},
+	), iq, nil)
+}
+
+// GetSubscriptions returns all subscriptions of the session to nodes on the
+// service.
+// If node is not empty, only subscriptions to that node are returned.
+func GetSubscriptions(ctx context.Context, s *xmpp.Session, node string) ([]Subscription, error) {
+	return GetSubscriptionsIQ(ctx, s, stanza.IQ{}, node)
+}
+
+// GetSubscriptionsIQ is like GetSubscriptions except that it allows modifying
+// the IQ.
+// Changes to the IQ type will have no effect.
+func GetSubscriptionsIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node string) ([]Subscription, error) {
+	iq.Type = stanza.GetIQ
+	var attrs []xml.Attr
+	if node != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "node"}, Value: node})
+	}
+	var resp struct {
+		XMLName       xml.Name       `xml:"http://jabber.org/protocol/pubsub pubsub"`
+		Subscriptions []Subscription `xml:"subscriptions>subscription"`
+	}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			nil,
+			xml.StartElement{Name: xml.Name{Local: "subscriptions"}, Attr: attrs},
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "pubsub"}},
+	), iq, &resp)
+	return resp.Subscriptions, err
+}
+
+// GetSubscriptionOptions fetches the configurable options for the sessions
+// subscription to the given node.
+func GetSubscriptionOptions(ctx context.Context, s *xmpp.Session, node, subID string) (*form.Data, error) {
+	return GetSubscriptionOptionsIQ(ctx, s, stanza.IQ{}, node, subID)
+}
+
+// GetSubscriptionOptionsIQ is like GetSubscriptionOptions except that it allows
+// modifying the IQ.
+// Changes to the IQ type will have no effect.
+func GetSubscriptionOptionsIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node, subID string) (*form.Data, error) {
+	iq.Type = stanza.GetIQ
+	var resp struct {
+		XMLName xml.Name   `xml:"http://jabber.org/protocol/pubsub pubsub"`
+		Data    *form.Data `xml:"options>x"`
+	}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			nil,
+			xml.StartElement{Name: xml.Name{Local: "options"}, Attr: subAttrs(node, s.LocalAddr().Bare(), subID)},
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "pubsub"}},
+	), iq, &resp)
+	return resp.Data, err
+}
+
+// SetSubscriptionOptions submits the provided dataform to the server to
+// configure the sessions subscription to the given node.
+func SetSubscriptionOptions(ctx context.Context, s *xmpp.Session, node, subID string, opts *form.Data) error {
+	return SetSubscriptionOptionsIQ(ctx, s, stanza.IQ{}, node, subID, opts)
+}
+
+// SetSubscriptionOptionsIQ is like SetSubscriptionOptions except that it allows
+// modifying the IQ.
+// Changes to the IQ type will have no effect.
+// If opts is nil or any of its required fields are not set an error is returned
+// without sending the request.
+func SetSubscriptionOptionsIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node, subID string, opts *form.Data) error {
+	if opts == nil {
+		return errors.New("pubsub: no subscription options provided")
+	}
+	data, ok := opts.Submit()
+	if !ok {
+		return errors.New("pubsub: required subscription options are not set")
+	}
+	iq.Type = stanza.SetIQ
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			data,
+			xml.StartElement{Name: xml.Name{Local: "options"}, Attr: subAttrs(node, s.LocalAddr().Bare(), subID)},
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "pubsub"}},
+	), iq, nil)
+}
@@ -0,0 +1,210 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/pubsub"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = pubsub.Subscription{}
+	_ xml.Unmarshaler     = (*pubsub.Subscription)(nil)
+	_ xmlstream.Marshaler = pubsub.Subscription{}
+	_ xmlstream.WriterTo  = pubsub.Subscription{}
+	_ xml.MarshalerAttr   = pubsub.SubSubscribed
+	_ xml.UnmarshalerAttr = (*pubsub.SubType)(nil)
+)
+
+var subEncodingTestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: &pubsub.Subscription{
+			Node:         "princely_musings",
+			Addr:         jid.MustParse("francisco@denmark.lit"),
+			Subscription: pubsub.SubSubscribed,
+		},
+		XML: `<subscription xmlns="http://jabber.org/protocol/pubsub" node="princely_musings" jid="francisco@denmark.lit" subscription="subscribed"></subscription>`,
+	},
+	1: {
+		Value: &pubsub.Subscription{
+			ID:             "ba49252aaa4f5d320c24d3766f0bdcade78c78d3",
+			Node:           "princely_musings",
+			Addr:           jid.MustParse("francisco@denmark.lit"),
+			Subscription:   pubsub.SubUnconfigured,
+			Configurable:   true,
+			ConfigRequired: true,
+		},
+		XML: `<subscription xmlns="http://jabber.org/protocol/pubsub" node="princely_musings" jid="francisco@denmark.lit" subid="ba49252aaa4f5d320c24d3766f0bdcade78c78d3" subscription="unconfigured"><subscribe-options><required></required></subscribe-options></subscription>`,
+	},
+	2: {
+		Value: &pubsub.Subscription{
+			Node:         "princely_musings",
+			Subscription: pubsub.SubPending,
+			Configurable: true,
+		},
+		XML: `<subscription xmlns="http://jabber.org/protocol/pubsub" node="princely_musings" subscription="pending"><subscribe-options></subscribe-options></subscription>`,
+	},
+}
+
+func TestEncodeSubscription(t *testing.T) {
+	xmpptest.RunEncodingTests(t, subEncodingTestCases)
+}
+
+// subServer returns a client/server pair where the server records any pubsub
+// IQs that it receives and responds with the provided payload.
+func subServer(t *testing.T, typ stanza.IQType, out *bytes.Buffer, payload string) *xmpptest.ClientServer {
+	t.Helper()
+	e := xml.NewEncoder(out)
+	return xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(stanza.NSClient,
+			mux.IQFunc(typ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				_, err := xmlstream.Copy(e, xmlstream.MultiReader(xmlstream.Token(*start), r))
+				if err != nil {
+					return err
+				}
+				err = e.Flush()
+				if err != nil {
+					return err
+				}
+				d := xml.NewDecoder(bytes.NewReader([]byte(payload)))
+				_, err = xmlstream.Copy(r, iq.Result(d))
+				return err
+			}),
+		)),
+	)
+}
+
+func TestSubscribe(t *testing.T) {
+	var buf bytes.Buffer
+	cs := subServer(t, stanza.SetIQ, &buf, `<pubsub xmlns="http://jabber.org/protocol/pubsub"><subscription node="princely_musings" jid="test@example.net" subid="123" subscription="unconfigured"><subscribe-options><required/></subscribe-options></subscription></pubsub>`)
+	sub, err := pubsub.Subscribe(context.Background(), cs.Client, "princely_musings")
+	if err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+	const expected = `<pubsub xmlns="http://jabber.org/protocol/pubsub" xmlns="http://jabber.org/protocol/pubsub"><subscribe xmlns="http://jabber.org/protocol/pubsub" node="princely_musings" jid="test@example.net"></subscribe></pubsub>`
+	if s := buf.String(); s != expected {
+		t.Errorf("wrong XML:\nwant=%s,\n got=%s", expected, s)
+	}
+	expectedSub := pubsub.Subscription{
+		ID:             "123",
+		Node:           "princely_musings",
+		Addr:           jid.MustParse("test@example.net"),
+		Subscription:   pubsub.SubUnconfigured,
+		Configurable:   true,
+		ConfigRequired: true,
+	}
+	if !reflect.DeepEqual(sub, expectedSub) {
+		t.Errorf("wrong subscription:\nwant=%+v,\n got=%+v", expectedSub, sub)
+	}
+}
+
+func TestSubscribeEmptyResponse(t *testing.T) {
+	var buf bytes.Buffer
+	cs := subServer(t, stanza.SetIQ, &buf, ``)
+	sub, err := pubsub.Subscribe(context.Background(), cs.Client, "princely_musings")
+	if err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+	if sub.Subscription != pubsub.SubSubscribed || sub.Node != "princely_musings" {
+		t.Errorf("wrong subscription for empty response: %+v", sub)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	var buf bytes.Buffer
+	cs := subServer(t, stanza.SetIQ, &buf, ``)
+	err := pubsub.Unsubscribe(context.Background(), cs.Client, "princely_musings", "123")
+	if err != nil {
+		t.Fatalf("error unsubscribing: %v", err)
+	}
+	const expected = `<pubsub xmlns="http://jabber.org/protocol/pubsub" xmlns="http://jabber.org/protocol/pubsub"><unsubscribe xmlns="http://jabber.org/protocol/pubsub" node="princely_musings" jid="test@example.net" subid="123"></unsubscribe></pubsub>`
+	if s := buf.String(); s != expected {
+		t.Errorf("wrong XML:\nwant=%s,\n got=%s", expected, s)
+	}
+}
+
+func TestGetSubscriptions(t *testing.T) {
+	var buf bytes.Buffer
+	cs := subServer(t, stanza.GetIQ, &buf, `<pubsub xmlns="http://jabber.org/protocol/pubsub"><subscriptions><subscription node="node1" jid="test@example.net" subscription="subscribed"/><subscription node="node2" jid="test@example.net" subscription="pending"/></subscriptions></pubsub>`)
+	subs, err := pubsub.GetSubscriptions(context.Background(), cs.Client, "")
+	if err != nil {
+		t.Fatalf("error fetching subscriptions: %v", err)
+	}
+	const expected = `<pubsub xmlns="http://jabber.org/protocol/pubsub" xmlns="http://jabber.org/protocol/pubsub"><subscriptions xmlns="http://jabber.org/protocol/pubsub"></subscriptions></pubsub>`
+	if s := buf.String(); s != expected {
+		t.Errorf("wrong XML:\nwant=%s,\n got=%s", expected, s)
+	}
+	addr := jid.MustParse("test@example.net")
+	expectedSubs := []pubsub.Subscription{
+		{Node: "node1", Addr: addr, Subscription: pubsub.SubSubscribed},
+		{Node: "node2", Addr: addr, Subscription: pubsub.SubPending},
+	}
+	if !reflect.DeepEqual(subs, expectedSubs) {
+		t.Errorf("wrong subscriptions:\nwant=%+v,\n got=%+v", expectedSubs, subs)
+	}
+}
+
+func TestGetSubscriptionOptions(t *testing.T) {
+	var buf bytes.Buffer
+	cs := subServer(t, stanza.GetIQ, &buf, `<pubsub xmlns="http://jabber.org/protocol/pubsub"><options node="princely_musings" jid="test@example.net"><x xmlns="jabber:x:data" type="form"><field var="FORM_TYPE" type="hidden"><value>http://jabber.org/protocol/pubsub#subscribe_options</value></field><field var="pubsub#deliver" type="boolean"><value>1</value></field></x></options></pubsub>`)
+	data, err := pubsub.GetSubscriptionOptions(context.Background(), cs.Client, "princely_musings", "")
+	if err != nil {
+		t.Fatalf("error fetching options: %v", err)
+	}
+	const expected = `<pubsub xmlns="http://jabber.org/protocol/pubsub" xmlns="http://jabber.org/protocol/pubsub"><options xmlns="http://jabber.org/protocol/pubsub" node="princely_musings" jid="test@example.net"></options></pubsub>`
+	if s := buf.String(); s != expected {
+		t.Errorf("wrong XML:\nwant=%s,\n got=%s", expected, s)
+	}
+	if data == nil {
+		t.Fatalf("expected options form")
+	}
+	deliver, ok := data.GetBool("pubsub#deliver")
+	if !ok || !deliver {
+		t.Errorf("wrong value for deliver option: want=true, got=%t (%t)", deliver, ok)
+	}
+}
+
+func TestSetSubscriptionOptions(t *testing.T) {
+	var buf bytes.Buffer
+	cs := subServer(t, stanza.SetIQ, &buf, ``)
+	ctx := context.Background()
+
+	err := pubsub.SetSubscriptionOptions(ctx, cs.Client, "princely_musings", "", nil)
+	if err == nil {
+		t.Errorf("expected error when setting nil options")
+	}
+	required := form.New(form.Boolean("pubsub#deliver", form.Required))
+	err = pubsub.SetSubscriptionOptions(ctx, cs.Client, "princely_musings", "", required)
+	if err == nil {
+		t.Errorf("expected error when required options are missing")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("invalid options should not be sent, got=%s", buf.String())
+	}
+
+	_, err = required.Set("pubsub#deliver", true)
+	if err != nil {
+		t.Fatalf("error setting option: %v", err)
+	}
+	err = pubsub.SetSubscriptionOptions(ctx, cs.Client, "princely_musings", "", required)
+	if err != nil {
+		t.Fatalf("error setting options: %v", err)
+	}
+	const expected = `<pubsub xmlns="http://jabber.org/protocol/pubsub" xmlns="http://jabber.org/protocol/pubsub"><options xmlns="http://jabber.org/protocol/pubsub" node="princely_musings" jid="test@example.net"><x xmlns="jabber:x:data" xmlns="jabber:x:data" type="submit"><field xmlns="jabber:x:data" type="boolean" var="pubsub#deliver"><required xmlns="jabber:x:data"></required><value xmlns="jabber:x:data">true</value></field></x></options></pubsub>`
+	if s := buf.String(); s != expected {
+		t.Errorf("wrong XML:\nwant=%s,\n got=%s", expected, s)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package reconnect
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// SetDial overrides the function used to dial connections and the initial
+// state of new sessions so that tests can connect without TLS or SASL.
+func SetDial(c *Client, state xmpp.SessionState, f func(ctx context.Context, network string, addr jid.JID) (net.Conn, error)) {
+	c.dial = f
+	c.state = state
+}
+
+// Backoff returns the delay before the next attempt to connect.
+func Backoff(c *Client, attempt int) time.Duration {
+	return c.backoff(attempt)
+}
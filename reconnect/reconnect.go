@@ -0,0 +1,456 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -type=State
+
+// Package reconnect provides a client that keeps an XMPP session established.
+//
+// A Client dials and negotiates a session, serves it using a handler, and when
+// the connection is lost dials a new one after waiting for an exponentially
+// increasing amount of time.
+// If the server supports XEP-0198: Stream Management, the Client resumes the
+// previous session so that no stanzas are lost.
+// Otherwise a fresh session is established: the initial presence is sent
+// again, message carbons are re-enabled, and any channels joined using the
+// Client are joined again.
+package reconnect // import "github.com/kamrankamilli/xmpp/reconnect"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/carbons"
+	"github.com/kamrankamilli/xmpp/dial"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/muc"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// Default values used by a Client if the corresponding fields are not set.
+const (
+	DefaultMinDelay = time.Second
+	DefaultMaxDelay = 5 * time.Minute
+	DefaultTimeout  = 30 * time.Second
+)
+
+var (
+	errNotConnected = errors.New("reconnect: not connected")
+	errNoMUC        = errors.New("reconnect: no MUC client configured")
+)
+
+// State is the connection state of a Client.
+type State uint8
+
+// A list of possible states.
+const (
+	// StateDisconnected is the state of a Client before Run is called, while it
+	// is waiting to reconnect, and after Run returns.
+	StateDisconnected State = iota
+
+	// StateConnecting is the state of a Client while it dials and negotiates a
+	// session.
+	StateConnecting
+
+	// StateConnected is the state of a Client once a session has been
+	// negotiated and any session state has been re-established.
+	StateConnected
+)
+
+// Event describes a change to the connection state of a Client.
+type Event struct {
+	State State
+
+	// Session is the new session if State is StateConnected.
+	Session *xmpp.Session
+
+	// Resumed is true if the new session resumed the previous session using
+	// stream management.
+	Resumed bool
+
+	// Attempt is the number of failed attempts to connect since the last
+	// session was established.
+	Attempt int
+
+	// Err is the error that caused the connection to be lost or the attempt to
+	// connect to fail if State is StateDisconnected.
+	// If State is StateConnected, it contains any errors that occurred while
+	// re-joining channels.
+	Err error
+
+	// Delay is the amount of time until the next attempt to connect if State is
+	// StateDisconnected.
+	Delay time.Duration
+}
+
+// Client maintains an XMPP session, reconnecting when the connection is lost.
+// Fields should not be modified after Run has been called.
+type Client struct {
+	// Addr is the address used to log in.
+	Addr jid.JID
+
+	// Dialer is used to connect to the server.
+	// If it is nil, the zero value of dial.Dialer is used.
+	Dialer *dial.Dialer
+
+	// Features is the list of stream features negotiated on every new
+	// connection such as StartTLS, SASL, and BindResource.
+	// A StreamManagement feature that resumes the previous session is added
+	// automatically.
+	// If Features already contains a stream management feature it is replaced
+	// by one that resumes the previous session instead of being negotiated
+	// twice.
+	Features []xmpp.StreamFeature
+
+	// Handler is used to serve each session.
+	Handler xmpp.Handler
+
+	// Presence is called to get the initial presence that is sent each time a
+	// fresh session is established.
+	// If Presence is nil an empty available presence is sent, and if it returns
+	// nil no presence is sent.
+	Presence func() xml.TokenReader
+
+	// Carbons enables message carbons each time a fresh session is established.
+	Carbons bool
+
+	// MUC is used to join channels.
+	// It must also be registered on the Handler.
+	MUC *muc.Client
+
+	// HandleState is called whenever the connection state changes.
+	HandleState func(Event)
+
+	// MinDelay and MaxDelay are the minimum and maximum amount of time to wait
+	// before reconnecting.
+	// Each failed attempt doubles the delay, and a random jitter of up to half
+	// the delay is subtracted so that many clients do not reconnect at once.
+	// If they are zero, DefaultMinDelay and DefaultMaxDelay are used.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	// Timeout bounds the amount of time spent connecting, negotiating, and
+	// re-establishing the session state.
+	// If it is zero, DefaultTimeout is used.
+	Timeout time.Duration
+
+	// dial and state are overridden in tests.
+	dial  func(ctx context.Context, network string, addr jid.JID) (net.Conn, error)
+	state xmpp.SessionState
+
+	mu       sync.Mutex
+	session  *xmpp.Session
+	channels map[string]*channel
+}
+
+// channel is a channel joined using the Client.
+type channel struct {
+	room jid.JID
+	opts []muc.Option
+	c    *muc.Channel
+}
+
+// Session returns the current session or nil if the Client is not connected.
+func (c *Client) Session() *xmpp.Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// Join joins a channel on the current session and remembers it so that it can
+// be joined again after reconnecting.
+// Room should be a full JID in which the desired nickname is the resourcepart.
+//
+// The returned channel is only valid until the connection is lost, use Channel
+// to get the channel for the current session.
+func (c *Client) Join(ctx context.Context, room jid.JID, opt ...muc.Option) (*muc.Channel, error) {
+	if c.MUC == nil {
+		return nil, errNoMUC
+	}
+	session := c.Session()
+	if session == nil {
+		return nil, errNotConnected
+	}
+	ch, err := c.MUC.Join(ctx, room, session, opt...)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.channels == nil {
+		c.channels = make(map[string]*channel)
+	}
+	c.channels[room.Bare().String()] = &channel{room: ch.Me(), opts: opt, c: ch}
+	return ch, nil
+}
+
+// Leave exits a channel and stops joining it after reconnecting.
+func (c *Client) Leave(ctx context.Context, room jid.JID, status string) error {
+	c.mu.Lock()
+	ch, ok := c.channels[room.Bare().String()]
+	delete(c.channels, room.Bare().String())
+	c.mu.Unlock()
+	if !ok || ch.c == nil {
+		return nil
+	}
+	return ch.c.Leave(ctx, status)
+}
+
+// Channel returns the channel with the provided address on the current
+// session or nil if it has not been joined.
+func (c *Client) Channel(room jid.JID) *muc.Channel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.channels[room.Bare().String()]
+	if !ok {
+		return nil
+	}
+	return ch.c
+}
+
+func (c *Client) setState(e Event) {
+	if c.HandleState != nil {
+		c.HandleState(e)
+	}
+}
+
+// backoff returns the delay before the next attempt to connect after the
+// provided number of failed attempts.
+func (c *Client) backoff(attempt int) time.Duration {
+	minDelay, maxDelay := c.MinDelay, c.MaxDelay
+	if minDelay <= 0 {
+		minDelay = DefaultMinDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+	d := minDelay
+	for i := 0; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	/* #nosec */
+	return d - rand.N(d/2+1)
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultTimeout
+}
+
+// Run connects to the server and serves the session, reconnecting each time
+// the connection is lost.
+// Run blocks until ctx is canceled, at which point the session is closed and
+// the context error is returned.
+func (c *Client) Run(ctx context.Context) error {
+	var prev *xmpp.SMState
+	var lost time.Time
+	attempt := 0
+	for {
+		c.setState(Event{State: StateConnecting, Attempt: attempt})
+		session, err := c.connect(ctx, prev)
+		var established bool
+		if err == nil {
+			established, err = c.serve(ctx, session, lost)
+			prev = nil
+			if state, ok := session.SMState(); ok && state.ID != "" {
+				prev = &state
+			}
+		}
+		// Only sessions that were fully established reset the backoff, otherwise
+		// a server that accepts connections but fails afterwards would be retried
+		// without any delay.
+		if established {
+			attempt = 0
+			lost = time.Now()
+		} else {
+			attempt++
+		}
+		if ctx.Err() != nil {
+			c.setState(Event{State: StateDisconnected, Err: err})
+			return ctx.Err()
+		}
+
+		delay := c.backoff(attempt)
+		c.setState(Event{
+			State:   StateDisconnected,
+			Attempt: attempt,
+			Err:     err,
+			Delay:   delay,
+		})
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// connect dials and negotiates a new session, resuming prev if possible.
+func (c *Client) connect(ctx context.Context, prev *xmpp.SMState) (*xmpp.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	dialFunc := c.dial
+	if dialFunc == nil {
+		d := c.Dialer
+		if d == nil {
+			d = &dial.Dialer{}
+		}
+		dialFunc = d.Dial
+	}
+	conn, err := dialFunc(ctx, "tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	sm := xmpp.StreamManagement(prev)
+	features := make([]xmpp.StreamFeature, 0, len(c.Features)+1)
+	var found bool
+	for _, f := range c.Features {
+		if f.Name == sm.Name {
+			if found {
+				continue
+			}
+			f, found = sm, true
+		}
+		features = append(features, f)
+	}
+	if !found {
+		features = append([]xmpp.StreamFeature{sm}, features...)
+	}
+	session, err := xmpp.NewSession(ctx, c.Addr.Domain(), c.Addr, conn, c.state, xmpp.NewNegotiator(func(*xmpp.Session, *xmpp.StreamConfig) xmpp.StreamConfig {
+		return xmpp.StreamConfig{
+			Features: features,
+		}
+	}))
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// serve serves the session until the connection is lost or ctx is canceled.
+// Established reports whether the session was established and used before it
+// was lost.
+// Lost is the time at which the previous session was lost and is used to
+// request channel history that may have been missed.
+func (c *Client) serve(ctx context.Context, session *xmpp.Session, lost time.Time) (established bool, err error) {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- session.Serve(c.Handler)
+	}()
+
+	resumed := session.Resumed()
+	joinErr, err := c.establish(ctx, session, resumed, lost)
+	if err != nil {
+		/* #nosec */
+		session.Close()
+		/* #nosec */
+		session.Conn().Close()
+		<-serveErr
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.session = session
+	c.mu.Unlock()
+	c.setState(Event{
+		State:   StateConnected,
+		Session: session,
+		Resumed: resumed,
+		Err:     joinErr,
+	})
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		/* #nosec */
+		session.Close()
+		/* #nosec */
+		session.Conn().Close()
+		err = <-serveErr
+	}
+
+	c.mu.Lock()
+	c.session = nil
+	for _, ch := range c.channels {
+		ch.c = nil
+	}
+	c.mu.Unlock()
+	return true, err
+}
+
+// establish sends the initial presence, enables carbons, and joins channels
+// on a new session.
+// If the session was resumed only the channels are joined again so that the
+// channels are bound to the new session.
+// Errors joining channels are returned separately since they do not affect
+// the rest of the session.
+func (c *Client) establish(ctx context.Context, session *xmpp.Session, resumed bool, lost time.Time) (joinErr, err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	if !resumed {
+		presence := stanza.Presence{}.Wrap(nil)
+		if c.Presence != nil {
+			presence = c.Presence()
+		}
+		if presence != nil {
+			err = session.Send(ctx, presence)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if c.Carbons {
+			err = carbons.Enable(ctx, session)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if c.MUC == nil {
+		return nil, nil
+	}
+	c.mu.Lock()
+	channels := make([]*channel, 0, len(c.channels))
+	for _, ch := range c.channels {
+		channels = append(channels, ch)
+	}
+	c.mu.Unlock()
+
+	var errs []error
+	for _, ch := range channels {
+		opts := ch.opts
+		switch {
+		case resumed:
+			// No messages were lost so we don't need any history.
+			opts = append(opts[:len(opts):len(opts)], muc.MaxHistory(0))
+		case !lost.IsZero():
+			opts = append(opts[:len(opts):len(opts)], muc.Since(lost))
+		}
+		joined, err := c.MUC.Join(ctx, ch.room, session, opts...)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.mu.Lock()
+		ch.c = joined
+		c.mu.Unlock()
+	}
+	return errors.Join(errs...), nil
+}
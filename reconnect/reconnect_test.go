@@ -0,0 +1,351 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package reconnect_test
+
+import (
+	"context"
+	"encoding/xml"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/carbons"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/muc"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/reconnect"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+func TestBackoff(t *testing.T) {
+	c := &reconnect.Client{
+		MinDelay: time.Second,
+		MaxDelay: 10 * time.Second,
+	}
+	for attempt, want := range []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	} {
+		for i := 0; i < 10; i++ {
+			d := reconnect.Backoff(c, attempt)
+			if d > want || d < want/2 {
+				t.Errorf("%d: delay out of range: want=[%v, %v], got=%v", attempt, want/2, want, d)
+			}
+		}
+	}
+}
+
+// testServer accepts connections and records the stanzas that the client uses
+// to establish its session.
+type testServer struct {
+	t    *testing.T
+	ln   net.Listener
+	recv chan string
+
+	mu          sync.Mutex
+	store       xmpp.SMStore
+	conns       []net.Conn
+	failCarbons bool
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	srv := &testServer{
+		t:     t,
+		ln:    ln,
+		recv:  make(chan string, 10),
+		store: xmpp.NewSMStore(0),
+	}
+	t.Cleanup(func() {
+		/* #nosec */
+		ln.Close()
+	})
+	go srv.accept()
+	return srv
+}
+
+func (srv *testServer) accept() {
+	m := mux.New(stanza.NSClient,
+		mux.PresenceFunc(stanza.AvailablePresence, xml.Name{}, func(stanza.Presence, xmlstream.TokenReadEncoder) error {
+			srv.recv <- "presence"
+			return nil
+		}),
+		mux.PresenceFunc(stanza.AvailablePresence, xml.Name{Space: muc.NS, Local: "x"}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+			var join struct {
+				History struct {
+					MaxStanzas string `xml:"maxstanzas,attr"`
+					Since      string `xml:"since,attr"`
+				} `xml:"http://jabber.org/protocol/muc x>history"`
+			}
+			err := xml.NewTokenDecoder(r).Decode(&join)
+			if err != nil {
+				return err
+			}
+			switch {
+			case join.History.MaxStanzas != "":
+				srv.recv <- "join maxstanzas=" + join.History.MaxStanzas
+			case join.History.Since != "":
+				srv.recv <- "join since"
+			default:
+				srv.recv <- "join"
+			}
+			p.To, p.From = p.From, p.To
+			_, err = xmlstream.Copy(r, p.Wrap(xmlstream.Wrap(
+				nil,
+				xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+			)))
+			return err
+		}),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: carbons.NS, Local: "enable"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+			srv.recv <- "carbons"
+			srv.mu.Lock()
+			fail := srv.failCarbons
+			srv.mu.Unlock()
+			if fail {
+				_, err := xmlstream.Copy(r, iq.Error(stanza.Error{Type: stanza.Cancel, Condition: stanza.ServiceUnavailable}))
+				return err
+			}
+			_, err := xmlstream.Copy(r, iq.Result(nil))
+			return err
+		}),
+	)
+	for {
+		conn, err := srv.ln.Accept()
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		srv.conns = append(srv.conns, conn)
+		store := srv.store
+		srv.mu.Unlock()
+		go func() {
+			s, err := xmpp.ReceiveSession(context.Background(), conn, xmpp.Secure|xmpp.Authn, xmpp.NewNegotiator(func(*xmpp.Session, *xmpp.StreamConfig) xmpp.StreamConfig {
+				return xmpp.StreamConfig{
+					Features: []xmpp.StreamFeature{
+						xmpp.StreamManagementServer(store),
+						xmpp.BindResource(),
+					},
+				}
+			}))
+			if err != nil {
+				/* #nosec */
+				conn.Close()
+				return
+			}
+			/* #nosec */
+			s.Serve(m)
+		}()
+	}
+}
+
+// drop closes the most recent connection and sets the store used to resume
+// sessions on the next connection.
+func (srv *testServer) drop(store xmpp.SMStore) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.store = store
+	/* #nosec */
+	srv.conns[len(srv.conns)-1].Close()
+}
+
+func (srv *testServer) expect(want ...string) {
+	srv.t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-srv.recv:
+			if got != w {
+				srv.t.Errorf("wrong stanza received: want=%q, got=%q", w, got)
+			}
+		case <-time.After(5 * time.Second):
+			srv.t.Fatalf("timed out waiting for %q", w)
+		}
+	}
+	select {
+	case got := <-srv.recv:
+		srv.t.Errorf("unexpected stanza received: %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan reconnect.Event, state reconnect.State) reconnect.Event {
+	t.Helper()
+	for {
+		select {
+		case e := <-events:
+			if e.State == state {
+				return e
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for state %v", state)
+		}
+	}
+}
+
+// waitResumable waits until the server has enabled resumption on the session.
+func waitResumable(t *testing.T, s *xmpp.Session) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state, ok := s.SMState()
+		if ok && state.ID != "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for stream management to be enabled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		features []xmpp.StreamFeature
+	}{
+		{name: "default", features: []xmpp.StreamFeature{xmpp.BindResource()}},
+		// A stream management feature in the list must not be negotiated twice or
+		// prevent resumption.
+		{name: "sm", features: []xmpp.StreamFeature{xmpp.StreamManagement(nil), xmpp.BindResource()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testReconnect(t, tc.features)
+		})
+	}
+}
+
+func testReconnect(t *testing.T, features []xmpp.StreamFeature) {
+	srv := newTestServer(t)
+	events := make(chan reconnect.Event, 20)
+	mucClient := &muc.Client{}
+	c := &reconnect.Client{
+		Addr:        jid.MustParse("me@example.net"),
+		Features:    features,
+		Handler:     mux.New("", muc.HandleClient(mucClient)),
+		Carbons:     true,
+		MUC:         mucClient,
+		MinDelay:    time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+		HandleState: func(e reconnect.Event) { events <- e },
+	}
+	reconnect.SetDial(c, xmpp.Secure|xmpp.Authn, func(ctx context.Context, network string, _ jid.JID) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, srv.ln.Addr().String())
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(ctx)
+	}()
+
+	e := nextEvent(t, events, reconnect.StateConnected)
+	if e.Resumed {
+		t.Errorf("first session should not be resumed")
+	}
+	srv.expect("presence", "carbons")
+	room := jid.MustParse("room@conference.example.net/me")
+	_, err := c.Join(ctx, room)
+	if err != nil {
+		t.Fatalf("error joining channel: %v", err)
+	}
+	srv.expect("join")
+	waitResumable(t, e.Session)
+
+	// Resuming the session should only re-join channels.
+	srv.drop(srv.store)
+	e = nextEvent(t, events, reconnect.StateDisconnected)
+	if e.Err == nil {
+		t.Errorf("expected lost connection to be reported")
+	}
+	e = nextEvent(t, events, reconnect.StateConnected)
+	if !e.Resumed {
+		t.Errorf("expected session to be resumed")
+	}
+	srv.expect("join maxstanzas=0")
+	if ch := c.Channel(room); ch == nil {
+		t.Errorf("expected channel after resumption")
+	}
+	waitResumable(t, e.Session)
+
+	// If resumption fails, the session must be established again.
+	srv.drop(xmpp.NewSMStore(0))
+	e = nextEvent(t, events, reconnect.StateConnected)
+	if e.Resumed {
+		t.Errorf("expected fresh session when resumption fails")
+	}
+	// The join from the previous session was never acknowledged so it is sent
+	// again by stream management.
+	srv.expect("join maxstanzas=0", "presence", "carbons", "join since")
+
+	cancel()
+	select {
+	case err = <-runErr:
+		if err != context.Canceled {
+			t.Errorf("wrong error from Run: want=%v, got=%v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for Run to return")
+	}
+	if s := c.Session(); s != nil {
+		t.Errorf("expected no session after Run returns")
+	}
+}
+
+func TestEstablishFailure(t *testing.T) {
+	srv := newTestServer(t)
+	srv.failCarbons = true
+	go func() {
+		for range srv.recv {
+		}
+	}()
+	events := make(chan reconnect.Event, 20)
+	c := &reconnect.Client{
+		Addr:        jid.MustParse("me@example.net"),
+		Features:    []xmpp.StreamFeature{xmpp.BindResource()},
+		Carbons:     true,
+		MinDelay:    time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+		HandleState: func(e reconnect.Event) { events <- e },
+	}
+	reconnect.SetDial(c, xmpp.Secure|xmpp.Authn, func(ctx context.Context, network string, _ jid.JID) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, srv.ln.Addr().String())
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(ctx)
+	}()
+
+	// Sessions that connect but cannot be established count as failed attempts
+	// and must not reset the backoff.
+	for want := 1; want <= 3; want++ {
+		e := nextEvent(t, events, reconnect.StateDisconnected)
+		if e.Err == nil {
+			t.Errorf("%d: expected error establishing session", want)
+		}
+		if e.Attempt != want {
+			t.Errorf("wrong attempt: want=%d, got=%d", want, e.Attempt)
+		}
+	}
+	cancel()
+	select {
+	case <-runErr:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for Run to return")
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// Store persists a roster and its version so that only changes need to be
+// fetched after reconnecting (roster versioning as defined in RFC 6121 §2.6).
+type Store interface {
+	// Load returns the roster version and items that were last saved.
+	// If nothing has been saved it returns an empty version and no items.
+	Load(ctx context.Context) (ver string, items []Item, err error)
+
+	// Replace replaces the entire stored roster.
+	Replace(ctx context.Context, ver string, items []Item) error
+
+	// Update sets the version and creates or replaces a single item.
+	// If the items subscription is "remove", the item is deleted instead.
+	Update(ctx context.Context, ver string, item Item) error
+}
+
+// NewStore returns a Store that keeps the roster in memory.
+func NewStore() Store {
+	return &memStore{
+		items: make(map[string]Item),
+	}
+}
+
+type memStore struct {
+	sync.Mutex
+	ver   string
+	items map[string]Item
+}
+
+func (m *memStore) Load(context.Context) (string, []Item, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.ver, sortedItems(m.items), nil
+}
+
+func (m *memStore) Replace(_ context.Context, ver string, items []Item) error {
+	m.Lock()
+	defer m.Unlock()
+	m.ver = ver
+	m.items = make(map[string]Item, len(items))
+	for _, item := range items {
+		m.items[item.JID.Bare().String()] = copyItem(item)
+	}
+	return nil
+}
+
+func (m *memStore) Update(_ context.Context, ver string, item Item) error {
+	m.Lock()
+	defer m.Unlock()
+	m.ver = ver
+	applyItem(m.items, item)
+	return nil
+}
+
+func copyItem(item Item) Item {
+	item.Group = append([]string(nil), item.Group...)
+	return item
+}
+
+// applyItem creates, replaces, or removes an item.
+func applyItem(items map[string]Item, item Item) {
+	key := item.JID.Bare().String()
+	if item.Subscription == "remove" {
+		delete(items, key)
+		return
+	}
+	items[key] = copyItem(item)
+}
+
+func sortedItems(items map[string]Item) []Item {
+	list := make([]Item, 0, len(items))
+	for _, item := range items {
+		list = append(list, copyItem(item))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].JID.String() < list[j].JID.String()
+	})
+	return list
+}
+
+// Manager keeps a copy of the roster in sync with the server.
+//
+// The roster is loaded from the Store (if any) with Load, the changes since the
+// stored version are requested with Fetch, and any roster pushes received
+// using the Handler returned by Handler are applied and saved.
+// The zero value is a Manager that keeps the roster in memory.
+type Manager struct {
+	// Store is used to persist the roster.
+	// If it is nil, the roster is only kept in memory.
+	Store Store
+
+	// Push, if set, is called after a roster push has been applied.
+	Push func(ver string, item Item)
+
+	mu    sync.Mutex
+	ver   string
+	items map[string]Item
+}
+
+// Load replaces the roster with the one saved in the store.
+func (m *Manager) Load(ctx context.Context) error {
+	if m.Store == nil {
+		return nil
+	}
+	ver, items, err := m.Store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ver = ver
+	m.items = make(map[string]Item, len(items))
+	for _, item := range items {
+		applyItem(m.items, item)
+	}
+	return nil
+}
+
+// Fetch requests the roster from the server.
+// The current version is sent with the request, so if the server supports
+// roster versioning and the roster has not changed it responds with no items
+// and the roster is kept.
+// Otherwise the roster is replaced and saved to the store.
+func (m *Manager) Fetch(ctx context.Context, s *xmpp.Session) error {
+	m.mu.Lock()
+	cur := m.ver
+	m.mu.Unlock()
+
+	iq := IQ{}
+	iq.Query.Ver = cur
+	iter := FetchIQ(ctx, iq, s)
+	var items []Item
+	for iter.Next() {
+		items = append(items, iter.Item())
+	}
+	err := iter.Err()
+	if err != nil {
+		/* #nosec */
+		iter.Close()
+		return err
+	}
+	err = iter.Close()
+	if err != nil {
+		return err
+	}
+	ver := iter.Version()
+	// An empty response means that the roster has not changed since the version
+	// that we requested.
+	if len(items) == 0 && ver == cur && cur != "" {
+		return nil
+	}
+
+	if m.Store != nil {
+		err = m.Store.Replace(ctx, ver, items)
+		if err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ver = ver
+	m.items = make(map[string]Item, len(items))
+	for _, item := range items {
+		applyItem(m.items, item)
+	}
+	return nil
+}
+
+// Handler returns a roster push handler that applies pushes to the roster.
+func (m *Manager) Handler() Handler {
+	return Handler{
+		Push: m.handlePush,
+	}
+}
+
+func (m *Manager) handlePush(ver string, item Item) error {
+	if m.Store != nil {
+		err := m.Store.Update(context.Background(), ver, item)
+		if err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	if m.items == nil {
+		m.items = make(map[string]Item)
+	}
+	if ver != "" {
+		m.ver = ver
+	}
+	applyItem(m.items, item)
+	m.mu.Unlock()
+	if m.Push != nil {
+		m.Push(ver, item)
+	}
+	return nil
+}
+
+// Version returns the version of the roster or the empty string if the server
+// does not support roster versioning.
+func (m *Manager) Version() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ver
+}
+
+// Items returns all items in the roster sorted by JID.
+func (m *Manager) Items() []Item {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedItems(m.items)
+}
+
+// Item returns the roster item for the bare JID of j.
+func (m *Manager) Item(j jid.JID) (Item, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[j.Bare().String()]
+	return copyItem(item), ok
+}
+
+// Groups returns the names of all groups in the roster in sorted order.
+func (m *Manager) Groups() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]struct{})
+	var groups []string
+	for _, item := range m.items {
+		for _, g := range item.Group {
+			if _, ok := seen[g]; ok {
+				continue
+			}
+			seen[g] = struct{}{}
+			groups = append(groups, g)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Group returns the items in the named group sorted by JID.
+// If group is empty, items that are not in any group are returned.
+func (m *Manager) Group(group string) []Item {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	matched := make(map[string]Item)
+	for key, item := range m.items {
+		if group == "" && len(item.Group) == 0 {
+			matched[key] = item
+			continue
+		}
+		for _, g := range item.Group {
+			if g == group {
+				matched[key] = item
+				break
+			}
+		}
+	}
+	return sortedItems(matched)
+}
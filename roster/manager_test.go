@@ -0,0 +1,188 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/roster"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+var (
+	juliet   = roster.Item{JID: jid.MustParse("juliet@example.com"), Subscription: "both", Group: []string{"Friends", "Capulets"}}
+	benvolio = roster.Item{JID: jid.MustParse("benvolio@example.org"), Subscription: "to", Group: []string{"Friends"}}
+	nurse    = roster.Item{JID: jid.MustParse("nurse@example.com"), Subscription: "none"}
+)
+
+func jids(items []roster.Item) []string {
+	s := make([]string, 0, len(items))
+	for _, item := range items {
+		s = append(s, item.JID.String())
+	}
+	return s
+}
+
+func TestManager(t *testing.T) {
+	const ver = "ver1"
+	requested := make(chan string, 2)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandlerFunc(func(e xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			iq := roster.IQ{}
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), e)).Decode(&iq)
+			if err != nil {
+				return err
+			}
+			requested <- iq.Query.Ver
+			resp := roster.IQ{IQ: stanza.IQ{ID: iq.ID, Type: stanza.ResultIQ}}
+			// If the client already has the latest version, send an empty result.
+			if iq.Query.Ver == ver {
+				_, err = xmlstream.Copy(e, resp.IQ.Wrap(nil))
+				return err
+			}
+			resp.Query.Ver = ver
+			resp.Query.Item = []roster.Item{juliet, benvolio}
+			_, err = xmlstream.Copy(e, resp.TokenReader())
+			return err
+		}),
+	)
+	store := roster.NewStore()
+	m := &roster.Manager{Store: store}
+	ctx := context.Background()
+
+	err := m.Fetch(ctx, cs.Client)
+	if err != nil {
+		t.Fatalf("error fetching roster: %v", err)
+	}
+	if v := <-requested; v != "" {
+		t.Errorf("wrong version requested: want=%q, got=%q", "", v)
+	}
+	if v := m.Version(); v != ver {
+		t.Errorf("wrong version: want=%q, got=%q", ver, v)
+	}
+
+	// Loading the roster into a new manager should result in the same version
+	// being requested and the cached items being kept.
+	m = &roster.Manager{Store: store}
+	err = m.Load(ctx)
+	if err != nil {
+		t.Fatalf("error loading roster: %v", err)
+	}
+	err = m.Fetch(ctx, cs.Client)
+	if err != nil {
+		t.Fatalf("error fetching roster again: %v", err)
+	}
+	if v := <-requested; v != ver {
+		t.Errorf("wrong version requested: want=%q, got=%q", ver, v)
+	}
+	want := []string{"benvolio@example.org", "juliet@example.com"}
+	if got := jids(m.Items()); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong items: want=%v, got=%v", want, got)
+	}
+	if groups := m.Groups(); !reflect.DeepEqual(groups, []string{"Capulets", "Friends"}) {
+		t.Errorf("wrong groups: %v", groups)
+	}
+	if got := jids(m.Group("Friends")); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong items in group: want=%v, got=%v", want, got)
+	}
+
+	// Apply pushes that add and remove items.
+	pushed := make(chan roster.Item, 2)
+	m.Push = func(_ string, item roster.Item) {
+		pushed <- item
+	}
+	pushes := mux.New("", roster.Handle(m.Handler()))
+	for i, item := range []roster.Item{nurse, {JID: benvolio.JID, Subscription: "remove"}} {
+		iq := roster.IQ{IQ: stanza.IQ{Type: stanza.SetIQ}}
+		iq.Query.Ver = "ver" + string(rune('2'+i))
+		iq.Query.Item = []roster.Item{item}
+		r := iq.TokenReader()
+		tok, err := r.Token()
+		if err != nil {
+			t.Fatalf("error popping start token: %v", err)
+		}
+		start := tok.(xml.StartElement)
+		err = pushes.HandleXMPP(struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: r,
+			Encoder:     xml.NewEncoder(discard{}),
+		}, &start)
+		if err != nil {
+			t.Fatalf("error handling push: %v", err)
+		}
+		if got := <-pushed; !got.JID.Equal(item.JID) {
+			t.Errorf("wrong item pushed: want=%v, got=%v", item.JID, got.JID)
+		}
+	}
+	if v := m.Version(); v != "ver3" {
+		t.Errorf("wrong version after push: want=ver3, got=%q", v)
+	}
+	want = []string{"juliet@example.com", "nurse@example.com"}
+	if got := jids(m.Items()); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong items after push: want=%v, got=%v", want, got)
+	}
+	if got := jids(m.Group("")); !reflect.DeepEqual(got, []string{"nurse@example.com"}) {
+		t.Errorf("wrong ungrouped items: %v", got)
+	}
+	if _, ok := m.Item(benvolio.JID); ok {
+		t.Errorf("expected removed item to be gone")
+	}
+	storedVer, stored, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("error loading store: %v", err)
+	}
+	if storedVer != "ver3" || !reflect.DeepEqual(jids(stored), want) {
+		t.Errorf("wrong stored roster: ver=%q, items=%v", storedVer, jids(stored))
+	}
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestSubscriptions(t *testing.T) {
+	to := jid.MustParse("juliet@example.com/balcony")
+	for _, tc := range []struct {
+		f   func(context.Context, *xmpp.Session, jid.JID) error
+		typ stanza.PresenceType
+	}{
+		{f: roster.Subscribe, typ: stanza.SubscribePresence},
+		{f: roster.Approve, typ: stanza.SubscribedPresence},
+		{f: roster.PreApprove, typ: stanza.SubscribedPresence},
+		{f: roster.Deny, typ: stanza.UnsubscribedPresence},
+		{f: roster.Cancel, typ: stanza.UnsubscribedPresence},
+		{f: roster.Unsubscribe, typ: stanza.UnsubscribePresence},
+	} {
+		t.Run(string(tc.typ), func(t *testing.T) {
+			recv := make(chan stanza.Presence, 1)
+			cs := xmpptest.NewClientServer(
+				xmpptest.ServerHandler(mux.New(stanza.NSClient,
+					mux.PresenceFunc(tc.typ, xml.Name{}, func(p stanza.Presence, _ xmlstream.TokenReadEncoder) error {
+						recv <- p
+						return nil
+					}),
+				)),
+			)
+			err := tc.f(context.Background(), cs.Client, to)
+			if err != nil {
+				t.Fatalf("error sending presence: %v", err)
+			}
+			p := <-recv
+			if !p.To.Equal(to.Bare()) {
+				t.Errorf("wrong address: want=%v, got=%v", to.Bare(), p.To)
+			}
+		})
+	}
+}
@@ -32,12 +32,24 @@ func Handle(h Handler) mux.Option {
 // Handler responds to roster pushes.
 // If Push returns a stanza.Error it is sent as an error response to the IQ
 // push, otherwise it is passed through and returned from HandleIQ.
+//
+// As required by RFC 6121 §2.1.6, pushes are only accepted from the server
+// (without a "from" attribute) or from the user's own bare JID.
+// Pushes from anybody else are rejected with a service-unavailable error and
+// Push is not called.
 type Handler struct {
 	Push func(ver string, item Item) error
 }
 
 // HandleIQ responds to roster push IQs.
 func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if !iq.From.Equal(jid.JID{}) && !iq.From.Equal(iq.To.Bare()) {
+		_, err := xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.ServiceUnavailable,
+		}))
+		return err
+	}
 	item := Item{}
 	err := xml.NewTokenDecoder(t).Decode(&item)
 	if err != nil {
@@ -214,6 +214,63 @@ func TestReceivePushError(t *testing.T) {
 	}
 }
 
+func TestReceivePushFrom(t *testing.T) {
+	for i, tc := range [...]struct {
+		from     string
+		accepted bool
+	}{
+		0: {accepted: true},
+		1: {from: "juliet@example.com", accepted: true},
+		2: {from: "juliet@example.com/balcony"},
+		3: {from: "mallory@example.net"},
+		4: {from: "example.com"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			from := ""
+			if tc.from != "" {
+				from = ` from='` + tc.from + `'`
+			}
+			x := `<iq xmlns='jabber:client' id='123'` + from + ` to='juliet@example.com/chamber' type='set'><query xmlns='jabber:iq:roster'><item jid='nurse@example.com'/></query></iq>`
+			d := xml.NewDecoder(strings.NewReader(x))
+			var b strings.Builder
+			e := xml.NewEncoder(&b)
+
+			called := false
+			h := roster.Handler{
+				Push: func(string, roster.Item) error {
+					called = true
+					return nil
+				},
+			}
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("unexpected error popping start token: %v", err)
+			}
+			start := tok.(xml.StartElement)
+			err = mux.New(stanza.NSClient, roster.Handle(h)).HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+				Encoder:     e,
+			}, &start)
+			if err != nil {
+				t.Fatalf("unexpected error in handler: %v", err)
+			}
+			err = e.Flush()
+			if err != nil {
+				t.Fatalf("unexpected error flushing encoder: %v", err)
+			}
+			if called != tc.accepted {
+				t.Errorf("wrong push handling: want called=%t, got=%t", tc.accepted, called)
+			}
+			if rejected := strings.Contains(b.String(), "service-unavailable"); rejected == tc.accepted {
+				t.Errorf("wrong response: %s", b.String())
+			}
+		})
+	}
+}
+
 type errReadWriter struct{}
 
 func (errReadWriter) Write([]byte) (int, error) {
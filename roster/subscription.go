@@ -0,0 +1,58 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"context"
+
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// NSPreApproval is the namespace of the stream feature advertised by servers
+// that support subscription pre-approval.
+const NSPreApproval = "urn:xmpp:features:pre-approval"
+
+func sendSubscription(ctx context.Context, s *xmpp.Session, typ stanza.PresenceType, to jid.JID) error {
+	return s.Send(ctx, stanza.Presence{
+		To:   to.Bare(),
+		Type: typ,
+	}.Wrap(nil))
+}
+
+// Subscribe requests a subscription to the presence of the contact.
+func Subscribe(ctx context.Context, s *xmpp.Session, to jid.JID) error {
+	return sendSubscription(ctx, s, stanza.SubscribePresence, to)
+}
+
+// Approve approves a request from the contact to subscribe to our presence.
+func Approve(ctx context.Context, s *xmpp.Session, to jid.JID) error {
+	return sendSubscription(ctx, s, stanza.SubscribedPresence, to)
+}
+
+// PreApprove approves a subscription request from the contact before it has
+// been received so that the server can approve it automatically.
+// It should only be used if the server supports pre-approval (see
+// NSPreApproval).
+func PreApprove(ctx context.Context, s *xmpp.Session, to jid.JID) error {
+	return Approve(ctx, s, to)
+}
+
+// Deny denies a request from the contact to subscribe to our presence.
+func Deny(ctx context.Context, s *xmpp.Session, to jid.JID) error {
+	return sendSubscription(ctx, s, stanza.UnsubscribedPresence, to)
+}
+
+// Cancel cancels an existing subscription of the contact to our presence or a
+// pre-approval.
+func Cancel(ctx context.Context, s *xmpp.Session, to jid.JID) error {
+	return sendSubscription(ctx, s, stanza.UnsubscribedPresence, to)
+}
+
+// Unsubscribe cancels our subscription to the presence of the contact.
+func Unsubscribe(ctx context.Context, s *xmpp.Session, to jid.JID) error {
+	return sendSubscription(ctx, s, stanza.UnsubscribePresence, to)
+}
@@ -9,12 +9,16 @@ import (
 	"encoding/base64"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 
 	"mellium.im/sasl"
 	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/internal/attr"
 	"github.com/kamrankamilli/xmpp/internal/ns"
 	"github.com/kamrankamilli/xmpp/internal/saslerr"
+	"github.com/kamrankamilli/xmpp/jid"
 )
 
 var (
@@ -34,23 +38,38 @@ var (
 // troubleshoot an issue.
 // Normally it is left blank and the localpart of the Origin JID is used.
 func SASL(identity, password string, mechanisms ...sasl.Mechanism) StreamFeature {
-	return newSASL(identity, password, nil, mechanisms...)
+	return newSASL(identity, password, nil, nil, mechanisms...)
 }
 
 // SASLServer is like SASL but the returned feature uses the provided
 // permissions func to validate credentials provided by the client.
+// Clients may not request an authorization identity other than their own bare
+// JID.
 func SASLServer(permissions func(*sasl.Negotiator) bool, mechanisms ...sasl.Mechanism) StreamFeature {
-	return newSASL("", "", permissions, mechanisms...)
+	return newSASL("", "", permissions, nil, mechanisms...)
 }
 
-func newSASL(identity, password string, permissions func(*sasl.Negotiator) bool, mechanisms ...sasl.Mechanism) StreamFeature {
+// SASLServerCredentials is like SASLServer except that clients are
+// authenticated using the accounts in store.
+// Unlike SASLServer this supports SCRAM mechanisms, and failures are reported
+// to the client using the condition matching the error returned by the store,
+// for example ErrAccountDisabled results in an account-disabled failure.
+// Authentication also fails if the "from" attribute on the stream header names
+// an account other than the authenticated user or the identity they act on
+// behalf of.
+func SASLServerCredentials(store CredentialStore, mechanisms ...sasl.Mechanism) StreamFeature {
+	return newSASL("", "", nil, store, mechanisms...)
+}
+
+func newSASL(identity, password string, permissions func(*sasl.Negotiator) bool, store CredentialStore, mechanisms ...sasl.Mechanism) StreamFeature {
 	if len(mechanisms) == 0 {
 		panic("xmpp: must specify at least one SASL mechanism")
 	}
 	return StreamFeature{
-		Name:       xml.Name{Space: ns.SASL, Local: "mechanisms"},
-		Necessary:  Secure,
-		Prohibited: Authn,
+		Name:           xml.Name{Space: ns.SASL, Local: "mechanisms"},
+		Necessary:      Secure,
+		Prohibited:     Authn,
+		channelBinding: true,
 		List: func(ctx context.Context, e xmlstream.TokenWriter, start xml.StartElement) (bool, error) {
 			err := e.EncodeToken(start)
 			if err != nil {
@@ -87,7 +106,7 @@ func newSASL(identity, password string, permissions func(*sasl.Negotiator) bool,
 		},
 		Negotiate: func(ctx context.Context, session *Session, data interface{}) (SessionState, io.ReadWriter, error) {
 			if (session.State() & Received) == Received {
-				return negotiateServer(ctx, identity, password, permissions, session, data, mechanisms...)
+				return negotiateServer(ctx, identity, password, permissions, store, session, data, mechanisms...)
 			}
 
 			return negotiateClient(ctx, identity, password, session, data, mechanisms...)
@@ -95,7 +114,7 @@ func newSASL(identity, password string, permissions func(*sasl.Negotiator) bool,
 	}
 }
 
-func negotiateServer(ctx context.Context, identity, password string, permissions func(*sasl.Negotiator) bool, session *Session, data interface{}, mechanisms ...sasl.Mechanism) (SessionState, io.ReadWriter, error) {
+func negotiateServer(ctx context.Context, identity, password string, permissions func(*sasl.Negotiator) bool, store CredentialStore, session *Session, data interface{}, mechanisms ...sasl.Mechanism) (SessionState, io.ReadWriter, error) {
 	w := session.TokenWriter()
 	/* #nosec */
 	defer w.Close()
@@ -107,6 +126,7 @@ func negotiateServer(ctx context.Context, identity, password string, permissions
 	var (
 		selected sasl.Mechanism
 		server   *sasl.Negotiator
+		auth     *saslAuth
 		resp     []byte
 	)
 	for more := true; more; {
@@ -166,7 +186,9 @@ func negotiateServer(ctx context.Context, identity, password string, permissions
 				opts = append(opts, sasl.TLSState(connState))
 			}
 
-			server = sasl.NewServer(selected, permissions, opts...)
+			auth = newSASLAuth(ctx, store, permissions, session)
+			opts = append(opts, auth.options()...)
+			server = sasl.NewServer(serverMechanism(session, selected, mechanisms), auth.permissions, opts...)
 		case xml.Name{Space: ns.SASL, Local: "abort"}:
 			err = sendSASLError(w, saslerr.Error{
 				Condition: saslerr.ConditionAborted,
@@ -210,18 +232,15 @@ func negotiateServer(ctx context.Context, identity, password string, permissions
 			decodedData = decodedData[:n]
 		}
 		more, resp, err = server.Step(decodedData)
-		switch err {
-		case nil:
-		case sasl.ErrAuthn:
+		err = auth.result(more, err)
+		if err != nil {
 			e := sendSASLError(w, saslerr.Error{
-				Condition: saslerr.ConditionNotAuthorized,
+				Condition: saslCondition(err),
 			})
 			if e != nil {
 				err = e
 			}
 			return 0, nil, err
-		default:
-			return 0, nil, err
 		}
 
 		// RFC6120 §6.4.2:
@@ -290,12 +309,16 @@ func negotiateClient(ctx context.Context, identity, password string, session *Se
 	defer w.Close()
 
 	var selected sasl.Mechanism
-	// Select a mechanism, preferring the client order.
+	// Select a mechanism, preferring the client order and skipping mechanisms
+	// that require channel binding if no channel binding type can be used.
 selectmechanism:
 	for _, m := range mechanisms {
 		for _, name := range data.([]string) {
-			if name == m.Name {
-				selected = m
+			if name != m.Name {
+				continue
+			}
+			if mech, ok := clientMechanism(session, m, data.([]string)); ok {
+				selected = mech
 				break selectmechanism
 			}
 		}
@@ -463,6 +486,117 @@ func decodeSASLChallenge(d *xml.Decoder, start xml.StartElement, allowChallenge
 	}
 }
 
+// saslAuth tracks the user that is authenticated during SASL negotiation when
+// we are the receiving entity and, if a CredentialStore is configured,
+// authenticates them using the store.
+type saslAuth struct {
+	ctx     context.Context
+	store   CredentialStore
+	perms   func(*sasl.Negotiator) bool
+	session *Session
+
+	// user is the username that was authenticated by the mechanism and identity
+	// is the authorization identity that the client requested, if any.
+	user     string
+	identity string
+
+	// err is an error returned by the store during the exchange and deferred
+	// is an error that is only reported if the exchange succeeds.
+	err      error
+	deferred error
+}
+
+// newSASLAuth returns a saslAuth that authenticates users using store or, if
+// store is nil, the provided permissions func.
+func newSASLAuth(ctx context.Context, store CredentialStore, permissions func(*sasl.Negotiator) bool, session *Session) *saslAuth {
+	return &saslAuth{ctx: ctx, store: store, perms: permissions, session: session}
+}
+
+// options returns the SASL options that provide SCRAM credentials from the
+// store, if any.
+func (a *saslAuth) options() []sasl.Option {
+	if a.store == nil {
+		return nil
+	}
+	return []sasl.Option{sasl.SaltedCredentials(func(username, identity []byte, mechanism string) ([]byte, []byte, int64, error) {
+		a.user, a.identity = string(username), string(identity)
+		hash := strings.TrimSuffix(strings.TrimPrefix(mechanism, "SCRAM-"), "-PLUS")
+		salt, salted, iter, err := a.store.SaltedCredentials(a.ctx, a.user, hash)
+		if salted != nil && (errors.Is(err, ErrAccountDisabled) || errors.Is(err, ErrCredentialsExpired)) {
+			a.deferred = err
+			return salt, salted, iter, nil
+		}
+		a.err = err
+		return salt, salted, iter, err
+	})}
+}
+
+// permissions records the credentials sent by the client and authenticates
+// them using the store or the wrapped permissions func.
+func (a *saslAuth) permissions(n *sasl.Negotiator) bool {
+	username, password, identity := n.Credentials()
+	a.user, a.identity = string(username), string(identity)
+	if a.store == nil {
+		return a.perms(n)
+	}
+	a.err = a.store.VerifyPassword(a.ctx, a.user, string(password))
+	return a.err == nil
+}
+
+// result is called after each step of the SASL exchange and returns the error
+// that should be reported.
+// Once the exchange has succeeded it reports any deferred error, checks that
+// the user is authorized to act on behalf of the requested identity, and
+// records the address that resources should be bound to on the session.
+// If a store is configured it also checks that the stream origin (if any) does
+// not name a different account.
+func (a *saslAuth) result(more bool, err error) error {
+	switch {
+	case a.err != nil:
+		return a.err
+	case err != nil || more:
+		return err
+	case a.deferred != nil:
+		return a.deferred
+	}
+	if a.user == "" {
+		// Mechanisms such as ANONYMOUS do not identify a user, so one is made up
+		// as described in XEP-0175: Best Practices for Use of SASL ANONYMOUS.
+		a.user = attr.RandomID()
+	}
+	domain := a.session.LocalAddr().Domainpart()
+	user, err := jid.New(a.user, domain, "")
+	if err != nil {
+		return sasl.ErrAuthn
+	}
+	addr := user
+	if a.identity != "" {
+		addr, err = jid.Parse(a.identity)
+		switch {
+		case err != nil || addr.Resourcepart() != "" || addr.Domainpart() != domain:
+			return ErrInvalidAuthzID
+		case addr.Equal(user):
+		case a.store == nil:
+			// Without a store there is no way to know whether the user may act on
+			// behalf of somebody else.
+			return ErrInvalidAuthzID
+		default:
+			err = a.store.Authorize(a.ctx, a.user, a.identity)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if a.store != nil {
+		origin := a.session.RemoteAddr()
+		if origin.Localpart() != "" && !origin.Bare().Equal(addr) && !origin.Bare().Equal(user) {
+			return fmt.Errorf("xmpp: stream origin %s does not match authenticated user %s: %w", origin, user, sasl.ErrAuthn)
+		}
+	}
+	a.session.authAddr = addr
+	return nil
+}
+
 func sendSASLError(w xmlstream.TokenWriteFlusher, fail saslerr.Error) error {
 	_, err := xmlstream.Copy(w, fail.TokenReader())
 	if err != nil {
@@ -0,0 +1,762 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+
+	"mellium.im/sasl"
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/internal/ns"
+	"github.com/kamrankamilli/xmpp/internal/saslerr"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+// UserAgent identifies the software and device of a client during SASL2
+// authentication.
+type UserAgent struct {
+	// ID is a stable identifier for the client installation.
+	// If set it must be a UUIDv4.
+	ID string `xml:"id,attr"`
+
+	// Software is the name of the client software, for example "Gajim".
+	Software string `xml:"software"`
+
+	// Device is a human readable description of the device, for example
+	// "Juliet's Phone".
+	Device string `xml:"device"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (ua UserAgent) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	if ua.Software != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(ua.Software)),
+			xml.StartElement{Name: xml.Name{Local: "software"}},
+		))
+	}
+	if ua.Device != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(ua.Device)),
+			xml.StartElement{Name: xml.Name{Local: "device"}},
+		))
+	}
+	start := xml.StartElement{Name: xml.Name{Space: ns.SASL2, Local: "user-agent"}}
+	if ua.ID != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "id"}, Value: ua.ID})
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (ua UserAgent) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, ua.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (ua UserAgent) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := ua.WriteXML(e)
+	return err
+}
+
+// SASL2Config configures the client side of SASL2 authentication.
+type SASL2Config struct {
+	// UserAgent identifies the client to the server.
+	// It is only sent if it is not the zero value.
+	UserAgent UserAgent
+
+	// Tag is the name of the client software sent when binding a resource.
+	// Servers may use it to generate a resourcepart that identifies the client.
+	Tag string
+
+	// Carbons requests that XEP-0280: Message Carbons be enabled when the
+	// resource is bound if the server supports it.
+	Carbons bool
+
+	// StreamManagement requests that XEP-0198: Stream Management be enabled with
+	// resumption when the resource is bound if the server supports it.
+	StreamManagement bool
+
+	// FAST enables XEP-0484: Fast Authentication Streamlining Tokens if the
+	// server supports it and UserAgent has an ID.
+	// If a usable token is stored it is used to authenticate instead of the
+	// password, otherwise a token is requested after authenticating with one of
+	// the provided mechanisms.
+	// Tokens that are rejected by the server are removed from the store so that
+	// the next attempt falls back to the password.
+	FAST FASTClientStore
+}
+
+// SASL2ServerConfig configures the server side of SASL2 authentication.
+type SASL2ServerConfig struct {
+	// Bind is called to generate the address that is bound to the session.
+	// It is passed the bare address that the client authenticated as and the tag
+	// sent by the client (or an empty string if no tag was sent).
+	// If Bind is nil a random resourcepart prefixed with the tag is generated.
+	Bind func(addr jid.JID, tag string) (jid.JID, error)
+
+	// Carbons advertises support for enabling XEP-0280: Message Carbons when the
+	// resource is bound.
+	// Whether the client requested it can be checked with
+	// Session.CarbonsEnabled.
+	Carbons bool
+
+	// StreamManagement advertises support for enabling XEP-0198: Stream
+	// Management when the resource is bound.
+	// If SMStore is not nil clients may also request resumption (see
+	// StreamManagementServer).
+	StreamManagement bool
+	SMStore          SMStore
+
+	// FAST advertises support for XEP-0484: Fast Authentication Streamlining
+	// Tokens and persists the tokens issued to clients.
+	// Tokens are only issued to clients that send a UserAgent with an ID and are
+	// valid for FASTLifetime, or DefaultFASTLifetime if it is zero.
+	// When a token that is more than halfway through its lifetime is used a new
+	// token is issued.
+	FAST         FASTServerStore
+	FASTLifetime time.Duration
+
+	// Credentials is used to authenticate clients instead of the permissions
+	// func passed to SASL2Server, as described in SASLServerCredentials.
+	Credentials CredentialStore
+}
+
+// SASL2 returns a stream feature for performing authentication using
+// XEP-0388: Extensible SASL Profile.
+// It panics if no mechanisms are specified.
+// Mechanisms are used in the same way as SASL.
+//
+// If the server supports XEP-0386: Bind 2, a resource is bound as part of
+// authentication along with any inline features requested in cfg, so the
+// session is ready as soon as authentication completes and BindResource is not
+// needed.
+// Otherwise authentication completes without a stream restart and the
+// remaining features are negotiated as usual.
+func SASL2(identity, password string, cfg SASL2Config, mechanisms ...sasl.Mechanism) StreamFeature {
+	return newSASL2(identity, password, nil, cfg, SASL2ServerConfig{}, mechanisms...)
+}
+
+// SASL2Server is like SASL2 but for server sessions.
+// The provided permissions func is used to validate credentials provided by
+// the client (unless cfg.Credentials is set, in which case it may be nil) and
+// cfg determines which inline features are offered.
+func SASL2Server(permissions func(*sasl.Negotiator) bool, cfg SASL2ServerConfig, mechanisms ...sasl.Mechanism) StreamFeature {
+	return newSASL2("", "", permissions, SASL2Config{}, cfg, mechanisms...)
+}
+
+// sasl2Features is the data parsed from a SASL2 feature.
+type sasl2Features struct {
+	mechanisms []string
+	bind       bool
+	inline     []string
+	fast       []string
+}
+
+func (f sasl2Features) supports(feature string) bool {
+	for _, v := range f.inline {
+		if v == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func newSASL2(identity, password string, permissions func(*sasl.Negotiator) bool, cfg SASL2Config, serverCfg SASL2ServerConfig, mechanisms ...sasl.Mechanism) StreamFeature {
+	if len(mechanisms) == 0 {
+		panic("xmpp: must specify at least one SASL mechanism")
+	}
+	return StreamFeature{
+		Name:           xml.Name{Space: ns.SASL2, Local: "authentication"},
+		Necessary:      Secure,
+		Prohibited:     Authn,
+		channelBinding: true,
+		List: func(ctx context.Context, e xmlstream.TokenWriter, start xml.StartElement) (bool, error) {
+			var inner []xml.TokenReader
+			for _, m := range mechanisms {
+				inner = append(inner, xmlstream.Wrap(
+					xmlstream.Token(xml.CharData(m.Name)),
+					xml.StartElement{Name: xml.Name{Local: "mechanism"}},
+				))
+			}
+			var features []xml.TokenReader
+			if serverCfg.Carbons {
+				features = append(features, bind2Feature(ns.Carbons))
+			}
+			if serverCfg.StreamManagement {
+				features = append(features, bind2Feature(ns.SM))
+			}
+			inline := []xml.TokenReader{xmlstream.Wrap(
+				xmlstream.Wrap(
+					xmlstream.MultiReader(features...),
+					xml.StartElement{Name: xml.Name{Local: "inline"}},
+				),
+				xml.StartElement{Name: xml.Name{Space: ns.Bind2, Local: "bind"}},
+			)}
+			if serverCfg.FAST != nil {
+				var fast []xml.TokenReader
+				for _, m := range fastMechanisms {
+					fast = append(fast, xmlstream.Wrap(
+						xmlstream.Token(xml.CharData(m)),
+						xml.StartElement{Name: xml.Name{Local: "mechanism"}},
+					))
+				}
+				inline = append(inline, xmlstream.Wrap(
+					xmlstream.MultiReader(fast...),
+					xml.StartElement{Name: xml.Name{Space: ns.FAST, Local: "fast"}},
+				))
+			}
+			inner = append(inner, xmlstream.Wrap(
+				xmlstream.MultiReader(inline...),
+				xml.StartElement{Name: xml.Name{Local: "inline"}},
+			))
+			_, err := xmlstream.Copy(e, xmlstream.Wrap(xmlstream.MultiReader(inner...), start))
+			return true, err
+		},
+		Parse: func(ctx context.Context, d *xml.Decoder, start *xml.StartElement) (bool, interface{}, error) {
+			parsed := struct {
+				XMLName    xml.Name `xml:"urn:xmpp:sasl:2 authentication"`
+				Mechanisms []string `xml:"urn:xmpp:sasl:2 mechanism"`
+				Inline     struct {
+					Bind *struct {
+						Features []struct {
+							Var string `xml:"var,attr"`
+						} `xml:"inline>feature"`
+					} `xml:"urn:xmpp:bind:0 bind"`
+					FAST struct {
+						Mechanisms []string `xml:"mechanism"`
+					} `xml:"urn:xmpp:fast:0 fast"`
+				} `xml:"urn:xmpp:sasl:2 inline"`
+			}{}
+			err := d.DecodeElement(&parsed, start)
+			data := sasl2Features{
+				mechanisms: parsed.Mechanisms,
+				bind:       parsed.Inline.Bind != nil,
+				fast:       parsed.Inline.FAST.Mechanisms,
+			}
+			if parsed.Inline.Bind != nil {
+				for _, f := range parsed.Inline.Bind.Features {
+					data.inline = append(data.inline, f.Var)
+				}
+			}
+			return true, data, err
+		},
+		Negotiate: func(ctx context.Context, session *Session, data interface{}) (SessionState, io.ReadWriter, error) {
+			if (session.State() & Received) == Received {
+				return negotiateSASL2Server(ctx, permissions, serverCfg, session, mechanisms...)
+			}
+			return negotiateSASL2Client(ctx, identity, password, cfg, session, data.(sasl2Features), mechanisms...)
+		},
+	}
+}
+
+func bind2Feature(v string) xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "feature"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "var"}, Value: v}},
+	})
+}
+
+// encodeSASLPayload base64 encodes a SASL payload, using "=" for empty
+// payloads.
+func encodeSASLPayload(b []byte) xml.CharData {
+	if len(b) == 0 {
+		return xml.CharData{'='}
+	}
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(encoded, b)
+	return encoded
+}
+
+// decodeSASLPayload reverses encodeSASLPayload.
+func decodeSASLPayload(b []byte) ([]byte, error) {
+	if len(b) == 0 || string(b) == "=" {
+		return nil, nil
+	}
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(b)))
+	n, err := base64.StdEncoding.Decode(decoded, b)
+	if err != nil {
+		return nil, err
+	}
+	return decoded[:n], nil
+}
+
+func sasl2Element(local string, payload []byte) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(encodeSASLPayload(payload)),
+		xml.StartElement{Name: xml.Name{Space: ns.SASL2, Local: local}},
+	)
+}
+
+// sendSASL2Error sends a SASL2 failure.
+// The condition is the same as in a SASL failure, but the failure itself is in
+// the SASL2 namespace.
+func sendSASL2Error(w xmlstream.TokenWriteFlusher, condition saslerr.Condition) error {
+	_, err := xmlstream.Copy(w, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: ns.SASL, Local: condition.String()}}),
+		xml.StartElement{Name: xml.Name{Space: ns.SASL2, Local: "failure"}},
+	))
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+type sasl2Success struct {
+	AdditionalData []byte `xml:"urn:xmpp:sasl:2 additional-data"`
+	AuthzID        string `xml:"urn:xmpp:sasl:2 authorization-identifier"`
+	Bound          *struct {
+		Enabled *smPayload `xml:"urn:xmpp:sm:3 enabled"`
+	} `xml:"urn:xmpp:bind:0 bound"`
+	Token *fastTokenPayload `xml:"urn:xmpp:fast:0 token"`
+}
+
+func negotiateSASL2Client(ctx context.Context, identity, password string, cfg SASL2Config, session *Session, data sasl2Features, mechanisms ...sasl.Mechanism) (SessionState, io.ReadWriter, error) {
+	fast, err := newFASTClient(ctx, session, cfg, data.fast)
+	if err != nil {
+		return 0, nil, err
+	}
+	// Prefer a FAST token if we have one, otherwise select a mechanism,
+	// preferring the client order and skipping mechanisms that require channel
+	// binding if no channel binding type can be used.
+	selected, _ := fast.mechanism(session)
+selectmechanism:
+	for _, m := range mechanisms {
+		if selected.Name != "" {
+			break
+		}
+		for _, name := range data.mechanisms {
+			if name != m.Name {
+				continue
+			}
+			if mech, ok := clientMechanism(session, m, data.mechanisms); ok {
+				selected = mech
+				break selectmechanism
+			}
+		}
+	}
+	if selected.Name == "" {
+		return 0, nil, errNoMechanisms
+	}
+
+	opts := []sasl.Option{
+		sasl.Credentials(func() ([]byte, []byte, []byte) {
+			return []byte(session.LocalAddr().Localpart()), []byte(password), []byte(identity)
+		}),
+		sasl.RemoteMechanisms(data.mechanisms...),
+	}
+	if connState := session.ConnectionState(); connState.Version != 0 {
+		opts = append(opts, sasl.TLSState(connState))
+	}
+	client := sasl.NewClient(selected, opts...)
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	inner := []xml.TokenReader{xmlstream.Wrap(
+		xmlstream.Token(encodeSASLPayload(resp)),
+		xml.StartElement{Name: xml.Name{Local: "initial-response"}},
+	)}
+	if cfg.UserAgent != (UserAgent{}) {
+		inner = append(inner, cfg.UserAgent.TokenReader())
+	}
+	var carbons bool
+	session.sm = nil
+	if data.bind {
+		var bind []xml.TokenReader
+		if cfg.Tag != "" {
+			bind = append(bind, xmlstream.Wrap(
+				xmlstream.Token(xml.CharData(cfg.Tag)),
+				xml.StartElement{Name: xml.Name{Local: "tag"}},
+			))
+		}
+		if cfg.Carbons && data.supports(ns.Carbons) {
+			carbons = true
+			bind = append(bind, xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Space: ns.Carbons, Local: "enable"},
+			}))
+		}
+		if cfg.StreamManagement && data.supports(ns.SM) {
+			session.sm = newSMState(&smConfig{})
+			bind = append(bind, smElement("enable", xml.Attr{
+				Name:  xml.Name{Local: "resume"},
+				Value: "true",
+			}))
+		}
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.MultiReader(bind...),
+			xml.StartElement{Name: xml.Name{Space: ns.Bind2, Local: "bind"}},
+		))
+	}
+	if el := fast.element(); el != nil {
+		inner = append(inner, el)
+	}
+
+	w := session.TokenWriter()
+	/* #nosec */
+	defer w.Close()
+	_, err = xmlstream.Copy(w, xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{
+			Name: xml.Name{Space: ns.SASL2, Local: "authenticate"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "mechanism"}, Value: selected.Name}},
+		},
+	))
+	if err != nil {
+		return 0, nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	r := session.TokenReader()
+	/* #nosec */
+	defer r.Close()
+	d := xml.NewTokenDecoder(r)
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		default:
+		}
+		tok, err := d.Token()
+		if err != nil {
+			return 0, nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			return 0, nil, errUnexpectedPayload
+		}
+		switch start.Name {
+		case xml.Name{Space: ns.SASL2, Local: "challenge"}:
+			challenge := struct {
+				Data []byte `xml:",chardata"`
+			}{}
+			err = d.DecodeElement(&challenge, &start)
+			if err != nil {
+				return 0, nil, err
+			}
+			payload, err := decodeSASLPayload(challenge.Data)
+			if err != nil {
+				return 0, nil, err
+			}
+			more, resp, err = client.Step(payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			_, err = xmlstream.Copy(w, sasl2Element("response", resp))
+			if err != nil {
+				return 0, nil, err
+			}
+			err = w.Flush()
+			if err != nil {
+				return 0, nil, err
+			}
+		case xml.Name{Space: ns.SASL2, Local: "success"}:
+			success := sasl2Success{}
+			err = d.DecodeElement(&success, &start)
+			if err != nil {
+				return 0, nil, err
+			}
+			// The final message from the server (if any) is sent with the success so
+			// that it can be verified, eg. the server signature in SCRAM.
+			if more {
+				payload, err := decodeSASLPayload(success.AdditionalData)
+				if err != nil {
+					return 0, nil, err
+				}
+				_, _, err = client.Step(payload)
+				if err != nil {
+					return 0, nil, err
+				}
+			}
+			err = fast.success(ctx, success.Token)
+			if err != nil {
+				return 0, nil, err
+			}
+			return sasl2Bound(session, success, carbons)
+		case xml.Name{Space: ns.SASL2, Local: "failure"}:
+			fail := saslerr.Error{}
+			err = d.DecodeElement(&fail, &start)
+			if err != nil {
+				return 0, nil, err
+			}
+			err = fast.failure(ctx)
+			if err != nil {
+				return 0, nil, err
+			}
+			return 0, nil, fail
+		default:
+			return 0, nil, errUnexpectedPayload
+		}
+	}
+}
+
+// sasl2Bound updates the client session after successful authentication.
+func sasl2Bound(session *Session, success sasl2Success, carbons bool) (SessionState, io.ReadWriter, error) {
+	if success.AuthzID != "" {
+		j, err := jid.Parse(success.AuthzID)
+		if err != nil {
+			return 0, nil, err
+		}
+		session.UpdateAddr(j)
+	}
+	if success.Bound == nil {
+		session.sm = nil
+		return Authn, nil, nil
+	}
+	session.carbons = carbons
+	if sm := session.sm; sm != nil {
+		enabled := success.Bound.Enabled
+		if enabled == nil {
+			session.sm = nil
+			return Authn | Ready, nil, nil
+		}
+		sm.Lock()
+		sm.countIn = true
+		sm.countOut = true
+		sm.id = enabled.ID
+		sm.resume = enabled.resume() && enabled.ID != ""
+		sm.location = enabled.Location
+		if max, err := strconv.ParseUint(enabled.Max, 10, 32); err == nil {
+			sm.max = time.Duration(max) * time.Second
+		}
+		sm.Unlock()
+	}
+	return Authn | Ready, nil, nil
+}
+
+func negotiateSASL2Server(ctx context.Context, permissions func(*sasl.Negotiator) bool, cfg SASL2ServerConfig, session *Session, mechanisms ...sasl.Mechanism) (SessionState, io.ReadWriter, error) {
+	w := session.TokenWriter()
+	/* #nosec */
+	defer w.Close()
+	r := session.TokenReader()
+	/* #nosec */
+	defer r.Close()
+	d := xml.NewTokenDecoder(r)
+
+	tok, err := d.Token()
+	if err != nil {
+		return 0, nil, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || start.Name != (xml.Name{Space: ns.SASL2, Local: "authenticate"}) {
+		err = sendSASL2Error(w, saslerr.ConditionMalformedRequest)
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, errUnexpectedPayload
+	}
+	req := struct {
+		Mechanism       string    `xml:"mechanism,attr"`
+		InitialResponse []byte    `xml:"urn:xmpp:sasl:2 initial-response"`
+		UserAgent       UserAgent `xml:"urn:xmpp:sasl:2 user-agent"`
+		Bind            *struct {
+			Tag    string      `xml:"tag"`
+			Enable []smPayload `xml:",any"`
+		} `xml:"urn:xmpp:bind:0 bind"`
+		fastRequest
+	}{}
+	err = d.DecodeElement(&req, &start)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	auth := newSASLAuth(ctx, cfg.Credentials, permissions, session)
+	fast := newFASTServer(cfg, session, auth, req.UserAgent.ID, req.fastRequest)
+	selected, ok := fast.mechanism(ctx, req.Mechanism)
+	for _, m := range mechanisms {
+		if !ok && req.Mechanism == m.Name {
+			selected, ok = serverMechanism(session, m, mechanisms), true
+		}
+	}
+	if !ok {
+		err = sendSASL2Error(w, saslerr.ConditionInvalidMechanism)
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, errNoMechanisms
+	}
+
+	opts := []sasl.Option{
+		sasl.Credentials(func() ([]byte, []byte, []byte) {
+			return []byte(session.LocalAddr().Localpart()), nil, nil
+		}),
+	}
+	if connState := session.ConnectionState(); connState.Version != 0 {
+		opts = append(opts, sasl.TLSState(connState))
+	}
+	opts = append(opts, auth.options()...)
+	server := sasl.NewServer(selected, auth.permissions, opts...)
+
+	payload, err := decodeSASLPayload(req.InitialResponse)
+	if err != nil {
+		return 0, nil, err
+	}
+	var resp []byte
+	for {
+		var more bool
+		more, resp, err = server.Step(payload)
+		err = auth.result(more, err)
+		if err != nil {
+			e := sendSASL2Error(w, saslCondition(err))
+			if e != nil {
+				err = e
+			}
+			return 0, nil, err
+		}
+		if !more {
+			break
+		}
+
+		_, err = xmlstream.Copy(w, sasl2Element("challenge", resp))
+		if err != nil {
+			return 0, nil, err
+		}
+		err = w.Flush()
+		if err != nil {
+			return 0, nil, err
+		}
+		tok, err := d.Token()
+		if err != nil {
+			return 0, nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			return 0, nil, errUnexpectedPayload
+		}
+		switch start.Name {
+		case xml.Name{Space: ns.SASL2, Local: "response"}:
+			response := struct {
+				Data []byte `xml:",chardata"`
+			}{}
+			err = d.DecodeElement(&response, &start)
+			if err != nil {
+				return 0, nil, err
+			}
+			payload, err = decodeSASLPayload(response.Data)
+			if err != nil {
+				return 0, nil, err
+			}
+		case xml.Name{Space: ns.SASL2, Local: "abort"}:
+			err = sendSASL2Error(w, saslerr.ConditionAborted)
+			if err != nil {
+				return 0, nil, err
+			}
+			return 0, nil, errTerminated
+		default:
+			err = sendSASL2Error(w, saslerr.ConditionMalformedRequest)
+			if err != nil {
+				return 0, nil, err
+			}
+			return 0, nil, errUnexpectedPayload
+		}
+	}
+	session.userAgent = req.UserAgent
+
+	var inner []xml.TokenReader
+	if len(resp) > 0 {
+		inner = append(inner, sasl2Element("additional-data", resp))
+	}
+	mask := Authn
+	addr := session.peerAddr()
+	var bound []xml.TokenReader
+	if req.Bind != nil {
+		if cfg.Bind != nil {
+			addr, err = cfg.Bind(addr, req.Bind.Tag)
+		} else {
+			res := attr.RandomID()
+			if req.Bind.Tag != "" {
+				res = req.Bind.Tag + "." + res
+			}
+			addr, err = addr.WithResource(res)
+		}
+		if err != nil {
+			e := sendSASL2Error(w, saslerr.ConditionTemporaryAuthFailure)
+			if e != nil {
+				err = e
+			}
+			return 0, nil, err
+		}
+		session.updateRemoteAddr(addr)
+		mask |= Ready
+		for _, enable := range req.Bind.Enable {
+			if enable.XMLName.Local != "enable" {
+				continue
+			}
+			switch {
+			case enable.XMLName.Space == ns.Carbons && cfg.Carbons:
+				session.carbons = true
+			case enable.XMLName.Space == ns.SM && cfg.StreamManagement:
+				sm := newSMState(&smConfig{store: cfg.SMStore})
+				sm.countIn = true
+				sm.countOut = true
+				var attrs []xml.Attr
+				if enable.resume() && sm.store != nil {
+					sm.id = attr.RandomID()
+					sm.resume = true
+					attrs = append(attrs,
+						xml.Attr{Name: xml.Name{Local: "id"}, Value: sm.id},
+						xml.Attr{Name: xml.Name{Local: "resume"}, Value: "true"},
+					)
+				}
+				session.sm = sm
+				bound = append(bound, smElement("enabled", attrs...))
+			}
+		}
+	}
+	inner = append(inner, xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(addr.String())),
+		xml.StartElement{Name: xml.Name{Local: "authorization-identifier"}},
+	))
+	if req.Bind != nil {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.MultiReader(bound...),
+			xml.StartElement{Name: xml.Name{Space: ns.Bind2, Local: "bound"}},
+		))
+	}
+	token, err := fast.finish(ctx)
+	if err != nil {
+		e := sendSASL2Error(w, saslerr.ConditionTemporaryAuthFailure)
+		if e != nil {
+			err = e
+		}
+		return 0, nil, err
+	}
+	if token != nil {
+		inner = append(inner, token)
+	}
+	_, err = xmlstream.Copy(w, xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: ns.SASL2, Local: "success"}},
+	))
+	if err != nil {
+		return 0, nil, err
+	}
+	return mask, nil, w.Flush()
+}
+
+// UserAgent returns the user agent that the client sent during SASL2
+// authentication (see SASL2Server).
+// If no user agent was sent, the zero value is returned.
+func (s *Session) UserAgent() UserAgent {
+	return s.userAgent
+}
+
+// CarbonsEnabled reports whether XEP-0280: Message Carbons were enabled when the
+// resource was bound during SASL2 authentication.
+func (s *Session) CarbonsEnabled() bool {
+	return s.carbons
+}
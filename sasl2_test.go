@@ -0,0 +1,185 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"mellium.im/sasl"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/internal/saslerr"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+)
+
+func bindTest(addr jid.JID, tag string) (jid.JID, error) {
+	return addr.WithResource(tag + ".1")
+}
+
+func allowPlain(n *sasl.Negotiator) bool {
+	_, pass, _ := n.Credentials()
+	return bytes.Equal(pass, []byte("pass"))
+}
+
+var sasl2TestCases = [...]xmpptest.FeatureTestCase{
+	0: {
+		Feature:    xmpp.SASL2("", "", xmpp.SASL2Config{Tag: "test", Carbons: true}, sasl.Plain),
+		In:         `<success xmlns="urn:xmpp:sasl:2"><authorization-identifier>test@example.net/test.1</authorization-identifier><bound xmlns="urn:xmpp:bind:0"/></success>`,
+		Out:        `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHRlc3QA</initial-response><bind xmlns="urn:xmpp:bind:0"><tag>test</tag></bind></authenticate>`,
+		FinalState: xmpp.Authn | xmpp.Ready,
+	},
+	1: {
+		Feature:    xmpp.SASL2("", "", xmpp.SASL2Config{}, sasl.Plain),
+		In:         `<success xmlns="urn:xmpp:sasl:2"><authorization-identifier>test@example.net</authorization-identifier></success>`,
+		Out:        `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHRlc3QA</initial-response><bind xmlns="urn:xmpp:bind:0"></bind></authenticate>`,
+		FinalState: xmpp.Authn,
+	},
+	2: {
+		Feature: xmpp.SASL2("", "", xmpp.SASL2Config{UserAgent: xmpp.UserAgent{ID: "d4565fa7-4d72-4749-b3d3-740edbf87770", Software: "Test"}}, sasl.Plain),
+		In:      `<failure xmlns="urn:xmpp:sasl:2"><not-authorized xmlns="urn:ietf:params:xml:ns:xmpp-sasl"/></failure>`,
+		Out:     `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHRlc3QA</initial-response><user-agent xmlns="urn:xmpp:sasl:2" id="d4565fa7-4d72-4749-b3d3-740edbf87770"><software>Test</software></user-agent><bind xmlns="urn:xmpp:bind:0"></bind></authenticate>`,
+		Err:     saslerr.Error{Condition: saslerr.ConditionNotAuthorized},
+	},
+	3: {
+		Feature: xmpp.SASL2("", "", xmpp.SASL2Config{}, sasl.Plain),
+		In:      `<success xmlns="urn:ietf:params:xml:ns:xmpp-sasl"/>`,
+		Out:     `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHRlc3QA</initial-response><bind xmlns="urn:xmpp:bind:0"></bind></authenticate>`,
+		Err:     xmpp.ErrUnexpectedPayload,
+	},
+	4: {
+		State:   xmpp.Received,
+		Feature: xmpp.SASL2Server(panicPerms, xmpp.SASL2ServerConfig{}, sasl.Plain),
+		In:      `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">AHRlc3QA</auth>`,
+		Out:     `<failure xmlns="urn:xmpp:sasl:2"><malformed-request xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></malformed-request></failure>`,
+		Err:     xmpp.ErrUnexpectedPayload,
+	},
+	5: {
+		State:   xmpp.Received,
+		Feature: xmpp.SASL2Server(panicPerms, xmpp.SASL2ServerConfig{}, sasl.Plain),
+		In:      `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="SCRAM-SHA-1"><initial-response>=</initial-response></authenticate>`,
+		Out:     `<failure xmlns="urn:xmpp:sasl:2"><invalid-mechanism xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></invalid-mechanism></failure>`,
+		Err:     xmpp.ErrNoMechanisms,
+	},
+	6: {
+		State:   xmpp.Received,
+		Feature: xmpp.SASL2Server(allowPlain, xmpp.SASL2ServerConfig{}, sasl.Plain),
+		In:      `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHRlc3QA</initial-response></authenticate>`,
+		Out:     `<failure xmlns="urn:xmpp:sasl:2"><not-authorized xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></not-authorized></failure>`,
+		Err:     sasl.ErrAuthn,
+	},
+	7: {
+		State:      xmpp.Received,
+		Feature:    xmpp.SASL2Server(allowPlain, xmpp.SASL2ServerConfig{Bind: bindTest, Carbons: true}, sasl.Plain),
+		In:         `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHRlc3QAcGFzcw==</initial-response><user-agent id="d4565fa7-4d72-4749-b3d3-740edbf87770"><software>Test</software></user-agent><bind xmlns="urn:xmpp:bind:0"><tag>test</tag><enable xmlns="urn:xmpp:carbons:2"/></bind></authenticate>`,
+		Out:        `<success xmlns="urn:xmpp:sasl:2"><authorization-identifier>test@example.net/test.1</authorization-identifier><bound xmlns="urn:xmpp:bind:0"></bound></success>`,
+		FinalState: xmpp.Authn | xmpp.Ready,
+	},
+	8: {
+		State:      xmpp.Received,
+		Feature:    xmpp.SASL2Server(allowPlain, xmpp.SASL2ServerConfig{}, sasl.Plain),
+		In:         `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHRlc3QAcGFzcw==</initial-response></authenticate>`,
+		Out:        `<success xmlns="urn:xmpp:sasl:2"><authorization-identifier>test@example.net</authorization-identifier></success>`,
+		FinalState: xmpp.Authn,
+	},
+	9: {
+		// The stream origin (test@example.net) is not used when binding, only the
+		// user that was authenticated.
+		State:      xmpp.Received,
+		Feature:    xmpp.SASL2Server(allowPlain, xmpp.SASL2ServerConfig{Bind: bindTest}, sasl.Plain),
+		In:         `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AGJvYgBwYXNz</initial-response><bind xmlns="urn:xmpp:bind:0"><tag>test</tag></bind></authenticate>`,
+		Out:        `<success xmlns="urn:xmpp:sasl:2"><authorization-identifier>bob@example.net/test.1</authorization-identifier><bound xmlns="urn:xmpp:bind:0"></bound></success>`,
+		FinalState: xmpp.Authn | xmpp.Ready,
+	},
+	10: {
+		State:   xmpp.Received,
+		Feature: xmpp.SASL2Server(allowPlain, xmpp.SASL2ServerConfig{}, sasl.Plain),
+		In:      `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>YWRtaW5AZXhhbXBsZS5uZXQAdGVzdABwYXNz</initial-response></authenticate>`,
+		Out:     `<failure xmlns="urn:xmpp:sasl:2"><invalid-authzid xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></invalid-authzid></failure>`,
+		Err:     xmpp.ErrInvalidAuthzID,
+	},
+	11: {
+		State:      xmpp.Received,
+		Feature:    xmpp.SASL2Server(allowPlain, xmpp.SASL2ServerConfig{}, sasl.Plain),
+		In:         `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>dGVzdEBleGFtcGxlLm5ldAB0ZXN0AHBhc3M=</initial-response></authenticate>`,
+		Out:        `<success xmlns="urn:xmpp:sasl:2"><authorization-identifier>test@example.net</authorization-identifier></success>`,
+		FinalState: xmpp.Authn,
+	},
+}
+
+func TestSASL2(t *testing.T) {
+	xmpptest.RunFeatureTests(t, sasl2TestCases[:])
+}
+
+func TestSASL2Session(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	clientConn, serverConn := smPipe(t)
+	type result struct {
+		s   *xmpp.Session
+		err error
+	}
+	server := make(chan result, 1)
+	go func() {
+		s, err := xmpp.ReceiveSession(ctx, serverConn, xmpp.Secure, smNegotiator(
+			xmpp.SASL2Server(allowPlain, xmpp.SASL2ServerConfig{
+				Bind:             bindTest,
+				Carbons:          true,
+				StreamManagement: true,
+				SMStore:          xmpp.NewSMStore(0),
+			}, sasl.Plain),
+		))
+		server <- result{s: s, err: err}
+	}()
+
+	ua := xmpp.UserAgent{ID: "d4565fa7-4d72-4749-b3d3-740edbf87770", Software: "Test", Device: "Phone"}
+	client, err := xmpp.NewSession(ctx, jid.MustParse("example.net"), jid.MustParse("test@example.net"), clientConn, xmpp.Secure, smNegotiator(
+		xmpp.SASL2("", "pass", xmpp.SASL2Config{
+			UserAgent:        ua,
+			Tag:              "test",
+			Carbons:          true,
+			StreamManagement: true,
+		}, sasl.Plain),
+	))
+	if err != nil {
+		t.Fatalf("error negotiating client session: %v", err)
+	}
+	res := <-server
+	if res.err != nil {
+		t.Fatalf("error negotiating server session: %v", res.err)
+	}
+	/* #nosec */
+	go res.s.Serve(nil)
+	/* #nosec */
+	go client.Serve(nil)
+	defer client.Close()
+
+	if st := client.State(); st&(xmpp.Authn|xmpp.Ready) != xmpp.Authn|xmpp.Ready {
+		t.Errorf("wrong client state: %v", st)
+	}
+	if addr := client.LocalAddr(); addr.String() != "test@example.net/test.1" {
+		t.Errorf("wrong bound address: %v", addr)
+	}
+	if addr := res.s.RemoteAddr(); addr.String() != "test@example.net/test.1" {
+		t.Errorf("wrong remote address on server: %v", addr)
+	}
+	if !client.CarbonsEnabled() || !res.s.CarbonsEnabled() {
+		t.Errorf("expected carbons to be enabled on both sides")
+	}
+	if got := res.s.UserAgent(); got != ua {
+		t.Errorf("wrong user agent: want=%+v, got=%+v", ua, got)
+	}
+	state := waitSMState(ctx, t, client, func(xmpp.SMState) bool { return true })
+	if state.ID == "" {
+		t.Errorf("expected stream management to be resumable")
+	}
+	err = client.RequestAck(ctx)
+	if err != nil {
+		t.Fatalf("error requesting ack: %v", err)
+	}
+}
@@ -221,6 +221,14 @@ var saslTestCases = [...]xmpptest.FeatureTestCase{
 		Out:        `<success xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></success>`,
 		FinalState: xmpp.Authn,
 	},
+
+	// Mechanisms that require channel binding are skipped without TLS.
+	11: {
+		Feature:    xmpp.SASL("", "", sasl.ScramSha256Plus, sasl.Plain),
+		In:         `<success xmlns="urn:ietf:params:xml:ns:xmpp-sasl"/>`,
+		Out:        `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">AHRlc3QA</auth>`,
+		FinalState: xmpp.Authn,
+	},
 }
 
 func TestSASL(t *testing.T) {
@@ -7,6 +7,7 @@
 package xmpp
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/xml"
@@ -21,6 +22,7 @@ import (
 	"github.com/kamrankamilli/xmpp/dial"
 	"github.com/kamrankamilli/xmpp/internal/attr"
 	"github.com/kamrankamilli/xmpp/internal/marshal"
+	"github.com/kamrankamilli/xmpp/internal/ns"
 	intstream "github.com/kamrankamilli/xmpp/internal/stream"
 	"github.com/kamrankamilli/xmpp/internal/wskey"
 	"github.com/kamrankamilli/xmpp/jid"
@@ -123,6 +125,10 @@ const (
 
 	// S2S indicates that this is a server-to-server connection.
 	S2S
+
+	// Compressed indicates that the underlying connection is compressed, for
+	// instance after XEP-0138: Stream Compression has been negotiated.
+	Compressed
 )
 
 type tokenReadChan struct {
@@ -137,6 +143,10 @@ type Session struct {
 	conn      net.Conn
 	connState func() tls.ConnectionState
 
+	// The certificate presented to the client if this is a server session that
+	// negotiated StartTLS, used for tls-server-end-point channel binding.
+	tlsCert *tls.Certificate
+
 	state      SessionState
 	stateMutex sync.RWMutex
 
@@ -149,6 +159,23 @@ type Session struct {
 	sentStanzaMutex sync.Mutex
 	sentStanzas     map[string]tokenReadChan
 
+	// The stream management state if stream management was offered.
+	sm *smState
+
+	// The bare address that the remote entity authenticated as when we are the
+	// receiving entity.
+	// Resources are bound to this address and not to the unverified "from"
+	// attribute on the stream header.
+	authAddr jid.JID
+
+	// The user agent sent by the client and whether carbons were enabled during
+	// SASL2 authentication.
+	userAgent UserAgent
+	carbons   bool
+
+	// Payloads added to outgoing available presence.
+	presence presencePayloads
+
 	in struct {
 		stream.Info
 		d      xml.TokenReader
@@ -306,6 +333,7 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 			for k := range s.negotiated {
 				delete(s.negotiated, k)
 			}
+			s.sm = nil
 			s.conn = newConn(rw, s.conn)
 			if tc, ok := s.conn.(tlsConn); ok {
 				s.connState = tc.ConnectionState
@@ -317,12 +345,18 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 	}
 
 	s.in.d = intstream.Reader(s.in.d, s.ws)
-	se := &stanzaEncoder{TokenWriteFlusher: s.out.e, ns: s.out.Info.XMLNS}
+	se := &stanzaEncoder{TokenWriteFlusher: s.out.e, ns: s.out.Info.XMLNS, sm: s.sm, presence: &s.presence}
 	if s.out.Info.XMLNS == stanza.NSServer {
 		se.from = s.LocalAddr()
 	}
 	s.out.e = se
 
+	if s.sm != nil {
+		if err := s.sm.ready(ctx, s); err != nil {
+			return s, err
+		}
+	}
+
 	return s, nil
 }
 
@@ -460,6 +494,12 @@ func (s *Session) Serve(h Handler) (err error) {
 	defer func() {
 		s.closeInputStream()
 		e := s.Close()
+		// If the session was lost, give the client a chance to resume it.
+		if err != nil && s.sm != nil {
+			if saveErr := s.sm.save(s); saveErr != nil {
+				err = errors.Join(err, fmt.Errorf("xmpp: error saving stream management state: %w", saveErr))
+			}
+		}
 		if err == nil {
 			err = e
 		}
@@ -567,6 +607,17 @@ func handleInputStream(s *Session, handler Handler) (err error) {
 		return fmt.Errorf("xmpp: stream in a bad state, expected start element or whitespace but got %T", tok)
 	}
 
+	// Stream management elements are handled by the session and never passed to
+	// the handler.
+	// Every other stanza counts towards the number of handled stanzas once we're
+	// done with it.
+	if start.Name.Space == ns.SM {
+		return handleSM(s, r, start)
+	}
+	if s.sm != nil && stanza.Is(start.Name, s.in.XMLNS) {
+		defer s.sm.handled()
+	}
+
 	// If this is a stanza, normalize the "from" attribute.
 	if stanza.Is(start.Name, s.in.XMLNS) {
 		for i, attr := range start.Attr {
@@ -1033,6 +1084,17 @@ type stanzaEncoder struct {
 	depth int
 	from  jid.JID
 	ns    string
+
+	// If stream management is enabled, a copy of each stanza is encoded to buf
+	// so that it can be resent if it is not acknowledged.
+	sm  *smState
+	buf *bytes.Buffer
+	enc *xml.Encoder
+
+	// Payloads that will be added to the available presence currently being
+	// encoded unless it already contains an element with the same name.
+	presence *presencePayloads
+	pending  []presencePayload
 }
 
 func (se *stanzaEncoder) EncodeToken(t xml.Token) error {
@@ -1071,6 +1133,18 @@ func (se *stanzaEncoder) EncodeToken(t xml.Token) error {
 				attrs = append(attrs, attr)
 			}
 			tok.Attr = attrs
+			if se.presence != nil && isPresenceEmptySpace(tok.Name) {
+				if _, _, _, typ := getIDTyp(tok.Attr); typ == string(stanza.AvailablePresence) {
+					se.pending = se.presence.get()
+				}
+			}
+			if se.sm != nil && se.sm.countingOut() {
+				if se.sm.full() {
+					return errSMQueueFull
+				}
+				se.buf = &bytes.Buffer{}
+				se.enc = xml.NewEncoder(se.buf)
+			}
 			if f := se.from.String(); f != "" && !foundFrom {
 				tok.Attr = append(tok.Attr, xml.Attr{
 					Name:  xml.Name{Local: "from"},
@@ -1085,6 +1159,16 @@ func (se *stanzaEncoder) EncodeToken(t xml.Token) error {
 			}
 		}
 
+		if se.depth == 2 && len(se.pending) > 0 {
+			pending := se.pending[:0:0]
+			for _, p := range se.pending {
+				if p.name != tok.Name {
+					pending = append(pending, p)
+				}
+			}
+			se.pending = pending
+		}
+
 		// For all start elements, regardless of depth, prevent duplicate xmlns
 		// attributes. See https://mellium.im/issue/75
 		attrs := tok.Attr[:0]
@@ -1097,6 +1181,16 @@ func (se *stanzaEncoder) EncodeToken(t xml.Token) error {
 		tok.Attr = attrs
 		t = tok
 	case xml.EndElement:
+		if se.depth == 1 && len(se.pending) > 0 {
+			pending := se.pending
+			se.pending = nil
+			for _, p := range pending {
+				_, err := xmlstream.Copy(se, p.f())
+				if err != nil {
+					return err
+				}
+			}
+		}
 		if se.depth == 1 && tok.Name.Space == "" && isStanzaEmptySpace(tok.Name) {
 			tok.Name.Space = se.ns
 			t = tok
@@ -1104,7 +1198,30 @@ func (se *stanzaEncoder) EncodeToken(t xml.Token) error {
 		se.depth--
 	}
 
-	return se.TokenWriteFlusher.EncodeToken(t)
+	var requestAck bool
+	if se.enc != nil {
+		err := se.enc.EncodeToken(t)
+		if err != nil {
+			return err
+		}
+		if se.depth == 0 {
+			err = se.enc.Flush()
+			if err != nil {
+				return err
+			}
+			requestAck = se.sm.sent(se.buf.Bytes())
+			se.buf, se.enc = nil, nil
+		}
+	}
+
+	err := se.TokenWriteFlusher.EncodeToken(t)
+	if err != nil || !requestAck {
+		return err
+	}
+	// Periodically ask the remote entity to acknowledge the stanzas that we've
+	// sent so that they can be removed from the unacked queue.
+	_, err = xmlstream.Copy(se.TokenWriteFlusher, smElement("r"))
+	return err
 }
 
 // UpdateAddr sets the address used by the session.
@@ -1120,3 +1237,21 @@ func (s *Session) UpdateAddr(j jid.JID) (ok bool) {
 	s.out.Info.From = j
 	return true
 }
+
+// peerAddr returns the bare address that resources should be bound to when we
+// are the receiving entity.
+// This is the address that the remote entity authenticated as or, if it was
+// authenticated by some other means, the origin from the stream header.
+func (s *Session) peerAddr() jid.JID {
+	if !s.authAddr.Equal(jid.JID{}) {
+		return s.authAddr
+	}
+	return s.RemoteAddr().Bare()
+}
+
+// updateRemoteAddr sets the remote address of the session to the address that
+// was bound for the remote entity.
+func (s *Session) updateRemoteAddr(j jid.JID) {
+	s.in.Info.From = j
+	s.out.Info.To = j
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/xml"
 	"fmt"
+	"sync"
 
 	"mellium.im/xmlstream"
 	"github.com/kamrankamilli/xmpp/internal/attr"
@@ -19,6 +20,49 @@ func isPresenceEmptySpace(name xml.Name) bool {
 	return name.Local == "presence" && (name.Space == "" || name.Space == stanza.NSClient || name.Space == stanza.NSServer)
 }
 
+type presencePayload struct {
+	name xml.Name
+	f    func() xml.TokenReader
+}
+
+// presencePayloads are added to available presence by the stanza encoder.
+type presencePayloads struct {
+	sync.Mutex
+	p []presencePayload
+}
+
+func (pp *presencePayloads) get() []presencePayload {
+	pp.Lock()
+	defer pp.Unlock()
+	return pp.p
+}
+
+// SetPresencePayload sets a payload that is added to every available presence
+// sent over the session, for example to advertise entity capabilities.
+// The payload is not added if the presence already contains an element with the
+// same name.
+// f is called each time a presence is sent and must return a token reader
+// containing a single element with the given name.
+// If f is nil the payload is removed.
+//
+// SetPresencePayload is safe for concurrent use by multiple goroutines.
+func (s *Session) SetPresencePayload(name xml.Name, f func() xml.TokenReader) {
+	s.presence.Lock()
+	defer s.presence.Unlock()
+	// The slice is copied so that encoders that have already retrieved it are
+	// unaffected.
+	p := make([]presencePayload, 0, len(s.presence.p)+1)
+	for _, payload := range s.presence.p {
+		if payload.name != name {
+			p = append(p, payload)
+		}
+	}
+	if f != nil {
+		p = append(p, presencePayload{name: name, f: f})
+	}
+	s.presence.p = p
+}
+
 // SendPresence is like Send except that it returns an error if the first token
 // read from the input is not a presence start token and blocks until an error
 // response is received or the context times out.
@@ -7,7 +7,9 @@ package xmpp_test
 import (
 	"context"
 	"encoding/xml"
+	"io"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -131,3 +133,69 @@ func TestSendPresence(t *testing.T) {
 		})
 	}
 }
+
+var presencePayloadTests = [...]struct {
+	p   stanza.Presence
+	in  string
+	out string
+}{
+	0: {
+		p:   stanza.Presence{ID: testIQID},
+		out: `<presence xmlns="jabber:client" id="123"><c xmlns="urn:example"></c></presence>`,
+	},
+	1: {
+		p:   stanza.Presence{ID: testIQID, Type: stanza.UnavailablePresence},
+		out: `<presence xmlns="jabber:client" type="unavailable" id="123"></presence>`,
+	},
+	2: {
+		p:   stanza.Presence{ID: testIQID},
+		in:  `<c xmlns="urn:example">custom</c>`,
+		out: `<presence xmlns="jabber:client" id="123"><c xmlns="urn:example">custom</c></presence>`,
+	},
+	3: {
+		p:   stanza.Presence{ID: testIQID},
+		in:  `<status>away</status>`,
+		out: `<presence xmlns="jabber:client" id="123"><status>away</status><c xmlns="urn:example"></c></presence>`,
+	},
+}
+
+func TestSetPresencePayload(t *testing.T) {
+	name := xml.Name{Space: "urn:example", Local: "c"}
+	for i, tc := range presencePayloadTests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var b strings.Builder
+			s := xmpptest.NewClientSession(0, struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: strings.NewReader(""),
+				Writer: &b,
+			})
+			s.SetPresencePayload(name, func() xml.TokenReader {
+				return xmlstream.Wrap(nil, xml.StartElement{Name: name})
+			})
+			var payload xml.TokenReader
+			if tc.in != "" {
+				payload = xml.NewDecoder(strings.NewReader(tc.in))
+			}
+			err := s.Send(context.Background(), tc.p.Wrap(payload))
+			if err != nil {
+				t.Fatalf("error sending presence: %v", err)
+			}
+			if out := b.String(); out != tc.out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+			}
+
+			// Removing the payload stops it being added.
+			b.Reset()
+			s.SetPresencePayload(name, nil)
+			err = s.Send(context.Background(), stanza.Presence{ID: testIQID}.Wrap(nil))
+			if err != nil {
+				t.Fatalf("error sending presence: %v", err)
+			}
+			if out, want := b.String(), `<presence xmlns="jabber:client" id="123"></presence>`; out != want {
+				t.Errorf("payload not removed:\nwant=%s,\n got=%s", want, out)
+			}
+		})
+	}
+}
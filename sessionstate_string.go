@@ -11,6 +11,7 @@ const (
 	_SessionState_name_3 = "OutputStreamClosed"
 	_SessionState_name_4 = "InputStreamClosed"
 	_SessionState_name_5 = "S2S"
+	_SessionState_name_6 = "Compressed"
 )
 
 var (
@@ -32,6 +33,8 @@ func (i SessionState) String() string {
 		return _SessionState_name_4
 	case i == 64:
 		return _SessionState_name_5
+	case i == 128:
+		return _SessionState_name_6
 	default:
 		return "SessionState(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
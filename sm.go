@@ -0,0 +1,724 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/internal/ns"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+	"github.com/kamrankamilli/xmpp/stream"
+)
+
+var (
+	errSMDisabled  = errors.New("xmpp: stream management is not enabled")
+	errSMNotFound  = errors.New("xmpp: no resumable session found")
+	errSMQueueFull = errors.New("xmpp: too many stanzas have not been acknowledged")
+)
+
+const (
+	// smRequestInterval is the number of unacknowledged stanzas after which an
+	// acknowledgement is requested automatically.
+	smRequestInterval = 10
+
+	// smMaxUnacked is the maximum number of unacknowledged stanzas that are kept
+	// for resending.
+	// Once it is reached no more stanzas can be sent until the remote entity
+	// acknowledges some of them.
+	smMaxUnacked = 1000
+)
+
+// SMState is the stream management state of a session as defined in
+// XEP-0198: Stream Management.
+// It can be retrieved from a session that was lost and passed to
+// StreamManagement to resume the session on a new connection.
+type SMState struct {
+	// ID is the stream management ID assigned by the server.
+	// If it is empty the session cannot be resumed.
+	ID string
+
+	// Location is the address, if any, that the server would prefer clients
+	// reconnect to when resuming the session.
+	Location string
+
+	// Max is the maximum amount of time that the server will keep the session
+	// resumable or zero if the server did not specify a maximum.
+	Max time.Duration
+
+	// Addr is the address that was bound to the client: the local address on
+	// client sessions and the remote address on server sessions.
+	// It is used to restore the bound address after resumption, and servers only
+	// let the same user resume the session.
+	Addr jid.JID
+
+	// In is the number of stanzas handled by the local entity.
+	In uint32
+
+	// Out is the number of sent stanzas that have been acknowledged by the
+	// remote entity.
+	Out uint32
+
+	// Unacked contains the XML encoding of every sent stanza that has not yet
+	// been acknowledged by the remote entity in the order they were sent.
+	Unacked [][]byte
+}
+
+// SMStore is used by servers to save the stream management state of sessions
+// that have been lost so that they can be resumed later.
+type SMStore interface {
+	// Save stores the state of a lost session under its ID.
+	Save(ctx context.Context, state SMState) error
+
+	// Load removes the state of the session with the given ID from the store and
+	// returns it.
+	// If no session with the given ID exists, an error is returned.
+	Load(ctx context.Context, id string) (SMState, error)
+}
+
+// NewSMStore returns an SMStore that keeps the state of lost sessions in
+// memory.
+// If max is non-zero, sessions that were saved longer ago than max can no
+// longer be loaded.
+func NewSMStore(max time.Duration) SMStore {
+	return &memSMStore{
+		max:    max,
+		states: make(map[string]memSMState),
+	}
+}
+
+type memSMState struct {
+	SMState
+	saved time.Time
+}
+
+type memSMStore struct {
+	sync.Mutex
+	max    time.Duration
+	states map[string]memSMState
+}
+
+func (m *memSMStore) Save(_ context.Context, state SMState) error {
+	m.Lock()
+	defer m.Unlock()
+	m.states[state.ID] = memSMState{SMState: state, saved: time.Now()}
+	return nil
+}
+
+func (m *memSMStore) Load(_ context.Context, id string) (SMState, error) {
+	m.Lock()
+	defer m.Unlock()
+	state, ok := m.states[id]
+	if !ok {
+		return SMState{}, errSMNotFound
+	}
+	delete(m.states, id)
+	if m.max > 0 && time.Since(state.saved) > m.max {
+		return SMState{}, errSMNotFound
+	}
+	return state.SMState, nil
+}
+
+// StreamManagement returns a stream feature that enables XEP-0198: Stream
+// Management on client sessions.
+// Once resource binding is complete stream management is enabled and
+// resumption is requested.
+// The session then counts the stanzas that it handles and answers
+// acknowledgement requests from the server, and it keeps a copy of each sent
+// stanza until the server acknowledges it (see RequestAck).
+// An acknowledgement is requested automatically after every 10 unacknowledged
+// stanzas and once 1000 stanzas are unacknowledged sending further stanzas
+// fails until the remote entity acknowledges some of them.
+//
+// If prev is not nil and has an ID, the feature attempts to resume the previous
+// session instead of binding a new resource.
+// On success any stanzas in prev that were not acknowledged by the server are
+// sent again.
+// If resumption fails, a new resource is bound as normal and any unacknowledged
+// stanzas are sent on the new session once stream management has been
+// enabled.
+func StreamManagement(prev *SMState) StreamFeature {
+	return newSM(prev, nil)
+}
+
+// StreamManagementServer is like StreamManagement but for server sessions.
+// Clients may enable stream management after they have bound a resource.
+// If store is not nil, clients may also request resumption: if a resumable
+// session is lost its state is saved to store when Serve returns, and the
+// unacknowledged stanzas are sent again if a client resumes the session.
+// Only the user that the session was bound to may resume it.
+func StreamManagementServer(store SMStore) StreamFeature {
+	return newSM(nil, store)
+}
+
+func newSM(prev *SMState, store SMStore) StreamFeature {
+	cfg := &smConfig{prev: prev, store: store}
+	return StreamFeature{
+		Name:      xml.Name{Space: ns.SM, Local: "sm"},
+		Necessary: Authn,
+		List: func(ctx context.Context, e xmlstream.TokenWriter, start xml.StartElement) (bool, error) {
+			err := e.EncodeToken(start)
+			if err != nil {
+				return false, err
+			}
+			return false, e.EncodeToken(start.End())
+		},
+		Parse: func(ctx context.Context, d *xml.Decoder, start *xml.StartElement) (bool, interface{}, error) {
+			parsed := struct {
+				XMLName xml.Name `xml:"urn:xmpp:sm:3 sm"`
+			}{}
+			return false, nil, d.DecodeElement(&parsed, start)
+		},
+		Negotiate: func(ctx context.Context, session *Session, data interface{}) (SessionState, io.ReadWriter, error) {
+			if session.sm == nil {
+				session.sm = newSMState(cfg)
+			}
+			if (session.State() & Received) == Received {
+				return smResumeServer(ctx, session)
+			}
+			return smResumeClient(ctx, session)
+		},
+		sm: cfg,
+	}
+}
+
+// smConfig is the configuration of a stream management feature that is copied
+// to sessions when the feature is listed or parsed.
+type smConfig struct {
+	prev  *SMState
+	store SMStore
+}
+
+// smState is the stream management state of a session.
+type smState struct {
+	sync.Mutex
+	smConfig
+
+	countIn  bool
+	countOut bool
+	resumed  bool
+	resume   bool
+
+	id       string
+	location string
+	max      time.Duration
+	in       uint32
+	out      uint32
+	unacked  [][]byte
+
+	// Stanzas that should be sent again once the session is ready.
+	pending [][]byte
+}
+
+func newSMState(cfg *smConfig) *smState {
+	return &smState{smConfig: *cfg}
+}
+
+// restore sets the counters from a previous session and moves any stanzas
+// that were not acknowledged in h to the pending queue.
+// If h is not valid (see ack) an error is returned and every stanza that was
+// not previously acknowledged is moved to the pending queue.
+func (sm *smState) restore(state SMState, h uint32) error {
+	sm.Lock()
+	defer sm.Unlock()
+	sm.id = state.ID
+	sm.location = state.Location
+	sm.max = state.Max
+	sm.resume = true
+	sm.in = state.In
+	sm.out = state.Out
+	sm.unacked = state.Unacked
+	err := sm.ack(h)
+	sm.pending = sm.unacked
+	sm.unacked = nil
+	return err
+}
+
+// ack removes stanzas acknowledged by h from the unacked queue.
+// If h is lower than a previous acknowledgement or acknowledges more stanzas
+// than were sent, the queue is left untouched and an undefined-condition stream
+// error with a handled-count-too-high payload is returned.
+// It must be called with the lock held.
+func (sm *smState) ack(h uint32) error {
+	// Counters wrap around, so the difference is always computed modulo 2^32.
+	n := h - sm.out
+	if n > uint32(len(sm.unacked)) {
+		return stream.UndefinedCondition.ApplicationError(smElement("handled-count-too-high",
+			smH(h),
+			xml.Attr{
+				Name:  xml.Name{Local: "send-count"},
+				Value: strconv.FormatUint(uint64(sm.out+uint32(len(sm.unacked))), 10),
+			},
+		))
+	}
+	sm.unacked = sm.unacked[n:]
+	sm.out = h
+	return nil
+}
+
+func (sm *smState) handled() {
+	sm.Lock()
+	defer sm.Unlock()
+	if sm.countIn {
+		sm.in++
+	}
+}
+
+func (sm *smState) countingOut() bool {
+	sm.Lock()
+	defer sm.Unlock()
+	return sm.countOut
+}
+
+// full reports whether the unacked queue has reached its maximum size.
+func (sm *smState) full() bool {
+	sm.Lock()
+	defer sm.Unlock()
+	return len(sm.unacked) >= smMaxUnacked
+}
+
+// sent adds b to the unacked queue and reports whether an acknowledgement
+// should be requested.
+func (sm *smState) sent(b []byte) bool {
+	sm.Lock()
+	defer sm.Unlock()
+	if !sm.countOut {
+		return false
+	}
+	sm.unacked = append(sm.unacked, b)
+	return len(sm.unacked)%smRequestInterval == 0
+}
+
+func (sm *smState) state(addr jid.JID) SMState {
+	sm.Lock()
+	defer sm.Unlock()
+	unacked := make([][]byte, len(sm.unacked))
+	copy(unacked, sm.unacked)
+	var id string
+	if sm.resume {
+		id = sm.id
+	}
+	return SMState{
+		ID:       id,
+		Location: sm.location,
+		Max:      sm.max,
+		Addr:     addr,
+		In:       sm.in,
+		Out:      sm.out,
+		Unacked:  unacked,
+	}
+}
+
+// ready is called once session negotiation is complete.
+// Clients that did not resume a previous session or enable stream management
+// while binding a resource enable stream management and then any pending
+// stanzas are sent.
+func (sm *smState) ready(ctx context.Context, s *Session) error {
+	sm.Lock()
+	enable := !sm.resumed && !sm.countOut
+	sm.Unlock()
+	if s.State()&Received == 0 && enable {
+		sm.Lock()
+		sm.countOut = true
+		sm.Unlock()
+		err := s.Send(ctx, smElement("enable", xml.Attr{
+			Name:  xml.Name{Local: "resume"},
+			Value: "true",
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	sm.Lock()
+	pending := sm.pending
+	sm.pending = nil
+	sm.Unlock()
+	for _, b := range pending {
+		err := s.Send(ctx, xml.NewDecoder(bytes.NewReader(b)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save stores the state of a lost server session so that it can be resumed.
+func (sm *smState) save(s *Session) error {
+	sm.Lock()
+	resumable := sm.store != nil && sm.resume && (sm.countIn || sm.countOut)
+	sm.Unlock()
+	if !resumable || s.State()&Received == 0 {
+		return nil
+	}
+	return sm.store.Save(context.Background(), sm.state(s.RemoteAddr()))
+}
+
+// SMState returns the stream management state of the session.
+// If stream management was not enabled, ok will be false.
+func (s *Session) SMState() (state SMState, ok bool) {
+	if s.sm == nil {
+		return state, false
+	}
+	s.sm.Lock()
+	ok = s.sm.countIn || s.sm.countOut
+	s.sm.Unlock()
+	if !ok {
+		return state, false
+	}
+	addr := s.LocalAddr()
+	if s.State()&Received == Received {
+		addr = s.RemoteAddr()
+	}
+	return s.sm.state(addr), true
+}
+
+// Resumed reports whether the session was established by resuming a previous
+// session using stream management (see StreamManagement).
+// Resumed sessions keep the state of the previous session such as presence and
+// roster subscriptions, so it does not need to be established again.
+func (s *Session) Resumed() bool {
+	if s.sm == nil {
+		return false
+	}
+	s.sm.Lock()
+	defer s.sm.Unlock()
+	return s.sm.resumed
+}
+
+// RequestAck asks the remote entity to acknowledge the stanzas that it has
+// handled.
+// When the acknowledgement is received, any acknowledged stanzas are removed
+// from the unacknowledged queue (see SMState).
+// If stream management is not enabled an error is returned.
+func (s *Session) RequestAck(ctx context.Context) error {
+	if s.sm == nil || !s.sm.countingOut() {
+		return errSMDisabled
+	}
+	return s.Send(ctx, smElement("r"))
+}
+
+func smElement(local string, attrs ...xml.Attr) xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: ns.SM, Local: local},
+		Attr: attrs,
+	})
+}
+
+func smH(h uint32) xml.Attr {
+	return xml.Attr{
+		Name:  xml.Name{Local: "h"},
+		Value: strconv.FormatUint(uint64(h), 10),
+	}
+}
+
+func smFailed(condition stanza.Condition) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: stanza.NSError, Local: string(condition)},
+		}),
+		xml.StartElement{
+			Name: xml.Name{Space: ns.SM, Local: "failed"},
+		},
+	)
+}
+
+// smPayload is any of the stream management elements that we may receive.
+type smPayload struct {
+	XMLName  xml.Name
+	H        string `xml:"h,attr"`
+	ID       string `xml:"id,attr"`
+	PrevID   string `xml:"previd,attr"`
+	Resume   string `xml:"resume,attr"`
+	Max      string `xml:"max,attr"`
+	Location string `xml:"location,attr"`
+}
+
+func (p smPayload) h() (uint32, error) {
+	h, err := strconv.ParseUint(p.H, 10, 32)
+	return uint32(h), err
+}
+
+func (p smPayload) resume() bool {
+	return p.Resume == "true" || p.Resume == "1"
+}
+
+func decodeSM(r xml.TokenReader) (smPayload, error) {
+	d := xml.NewTokenDecoder(r)
+	tok, err := d.Token()
+	if err != nil {
+		return smPayload{}, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || start.Name.Space != ns.SM {
+		return smPayload{}, errUnexpectedPayload
+	}
+	p := smPayload{}
+	err = d.DecodeElement(&p, &start)
+	return p, err
+}
+
+func smResumeClient(ctx context.Context, session *Session) (SessionState, io.ReadWriter, error) {
+	sm := session.sm
+	prev := sm.prev
+	if prev == nil || prev.ID == "" {
+		return 0, nil, nil
+	}
+
+	w := session.TokenWriter()
+	defer w.Close()
+	r := session.TokenReader()
+	defer r.Close()
+
+	_, err := xmlstream.Copy(w, smElement("resume",
+		smH(prev.In),
+		xml.Attr{Name: xml.Name{Local: "previd"}, Value: prev.ID},
+	))
+	if err != nil {
+		return 0, nil, err
+	}
+	if err = w.Flush(); err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := decodeSM(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	switch resp.XMLName.Local {
+	case "resumed":
+		h, err := resp.h()
+		if err != nil {
+			return 0, nil, err
+		}
+		err = sm.restore(*prev, h)
+		if err != nil {
+			return 0, nil, err
+		}
+		sm.Lock()
+		sm.countIn = true
+		sm.countOut = true
+		sm.resumed = true
+		sm.Unlock()
+		if !prev.Addr.Equal(jid.JID{}) {
+			session.UpdateAddr(prev.Addr)
+		}
+		return Ready, nil, nil
+	case "failed":
+		// If the server told us how many stanzas it handled, don't resend those.
+		h, err := resp.h()
+		if err != nil {
+			h = prev.Out
+		}
+		if sm.restore(*prev, h) != nil {
+			// The server acknowledged stanzas that we never sent, so don't trust it
+			// and resend everything that was not previously acknowledged.
+			/* #nosec */
+			sm.restore(*prev, prev.Out)
+		}
+		sm.Lock()
+		sm.id = ""
+		sm.resume = false
+		sm.in = 0
+		sm.out = 0
+		sm.Unlock()
+		return 0, nil, nil
+	}
+	return 0, nil, errUnexpectedPayload
+}
+
+func smResumeServer(ctx context.Context, session *Session) (SessionState, io.ReadWriter, error) {
+	sm := session.sm
+
+	w := session.TokenWriter()
+	defer w.Close()
+	r := session.TokenReader()
+	defer r.Close()
+
+	req, err := decodeSM(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if req.XMLName.Local != "resume" {
+		_, err = xmlstream.Copy(w, smFailed(stanza.UnexpectedRequest))
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, w.Flush()
+	}
+	h, err := req.h()
+	if err != nil {
+		_, err = xmlstream.Copy(w, smFailed(stanza.BadRequest))
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, w.Flush()
+	}
+
+	var state SMState
+	if sm.store == nil {
+		err = errSMNotFound
+	} else {
+		state, err = sm.store.Load(ctx, req.PrevID)
+	}
+	// Only the user that the session was bound to may resume it.
+	// If somebody else tries, the state is put back so that the session can
+	// still be resumed by its owner.
+	if err == nil && !state.Addr.Bare().Equal(session.peerAddr()) {
+		err = sm.store.Save(ctx, state)
+		if err == nil {
+			err = errSMNotFound
+		}
+	}
+	if err != nil {
+		_, err = xmlstream.Copy(w, smFailed(stanza.ItemNotFound))
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, w.Flush()
+	}
+
+	err = sm.restore(state, h)
+	if se, ok := err.(stream.Error); ok {
+		_, err = se.WriteXML(w)
+		if err != nil {
+			return 0, nil, err
+		}
+		err = w.Flush()
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, se
+	}
+	session.updateRemoteAddr(state.Addr)
+	sm.Lock()
+	sm.countIn = true
+	sm.countOut = true
+	sm.resumed = true
+	sm.Unlock()
+	_, err = xmlstream.Copy(w, smElement("resumed",
+		smH(state.In),
+		xml.Attr{Name: xml.Name{Local: "previd"}, Value: state.ID},
+	))
+	if err != nil {
+		return 0, nil, err
+	}
+	return Ready, nil, w.Flush()
+}
+
+// handleSM handles stream management elements received after the session has
+// been negotiated.
+func handleSM(s *Session, r xml.TokenReader, start xml.StartElement) error {
+	p := smPayload{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(
+		xmlstream.Token(start),
+		xmlstream.InnerElement(r),
+	)).Decode(&p)
+	if err != nil {
+		return err
+	}
+
+	sm := s.sm
+	server := s.State()&Received == Received
+	if sm == nil {
+		if server && p.XMLName.Local == "enable" {
+			return sendSM(s, smFailed(stanza.UnexpectedRequest))
+		}
+		return nil
+	}
+
+	switch p.XMLName.Local {
+	case "r":
+		sm.Lock()
+		counting := sm.countIn
+		h := sm.in
+		sm.Unlock()
+		if !counting {
+			return nil
+		}
+		return sendSM(s, smElement("a", smH(h)))
+	case "a":
+		h, err := p.h()
+		if err != nil {
+			return err
+		}
+		sm.Lock()
+		err = sm.ack(h)
+		sm.Unlock()
+		if err != nil {
+			return err
+		}
+	case "enable":
+		if !server {
+			return nil
+		}
+		sm.Lock()
+		if sm.countIn || sm.countOut {
+			sm.Unlock()
+			return sendSM(s, smFailed(stanza.UnexpectedRequest))
+		}
+		sm.countIn = true
+		attrs := []xml.Attr{}
+		if p.resume() && sm.store != nil {
+			sm.id = attr.RandomID()
+			sm.resume = true
+			attrs = append(attrs,
+				xml.Attr{Name: xml.Name{Local: "id"}, Value: sm.id},
+				xml.Attr{Name: xml.Name{Local: "resume"}, Value: "true"},
+			)
+		}
+		sm.Unlock()
+		err = sendSM(s, smElement("enabled", attrs...))
+		if err != nil {
+			return err
+		}
+		sm.Lock()
+		sm.countOut = true
+		sm.Unlock()
+	case "enabled":
+		if server {
+			return nil
+		}
+		sm.Lock()
+		defer sm.Unlock()
+		sm.countIn = true
+		sm.id = p.ID
+		sm.resume = p.resume() && p.ID != ""
+		sm.location = p.Location
+		if max, err := strconv.ParseUint(p.Max, 10, 32); err == nil {
+			sm.max = time.Duration(max) * time.Second
+		}
+	case "failed":
+		if server {
+			return nil
+		}
+		sm.Lock()
+		defer sm.Unlock()
+		sm.countIn = false
+		sm.countOut = false
+		sm.resume = false
+		sm.unacked = nil
+	}
+	return nil
+}
+
+func sendSM(s *Session, r xml.TokenReader) error {
+	w := s.TokenWriter()
+	defer w.Close()
+	_, err := xmlstream.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
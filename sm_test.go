@@ -0,0 +1,369 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/stanza"
+	"github.com/kamrankamilli/xmpp/stream"
+)
+
+var smTestCases = [...]xmpptest.FeatureTestCase{
+	0: {
+		State:   xmpp.Received | xmpp.Authn,
+		Feature: xmpp.StreamManagementServer(nil),
+		In:      `<resume xmlns="urn:xmpp:sm:3" h="0" previd="123"/>`,
+		Out:     `<failed xmlns="urn:xmpp:sm:3"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></item-not-found></failed>`,
+	},
+	1: {
+		State:   xmpp.Received | xmpp.Authn,
+		Feature: xmpp.StreamManagementServer(xmpp.NewSMStore(0)),
+		In:      `<resume xmlns="urn:xmpp:sm:3" h="0" previd="123"/>`,
+		Out:     `<failed xmlns="urn:xmpp:sm:3"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></item-not-found></failed>`,
+	},
+	2: {
+		State:   xmpp.Received | xmpp.Authn,
+		Feature: xmpp.StreamManagementServer(nil),
+		In:      `<resume xmlns="urn:xmpp:sm:3" previd="123"/>`,
+		Out:     `<failed xmlns="urn:xmpp:sm:3"><bad-request xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></bad-request></failed>`,
+	},
+	3: {
+		Feature: xmpp.StreamManagement(nil),
+	},
+	4: {
+		Feature:    xmpp.StreamManagement(&xmpp.SMState{ID: "123", In: 3, Out: 2}),
+		In:         `<resumed xmlns="urn:xmpp:sm:3" h="2" previd="123"/>`,
+		Out:        `<resume xmlns="urn:xmpp:sm:3" h="3" previd="123"></resume>`,
+		FinalState: xmpp.Ready,
+	},
+	5: {
+		Feature: xmpp.StreamManagement(&xmpp.SMState{ID: "123", In: 3}),
+		In:      `<failed xmlns="urn:xmpp:sm:3"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></failed>`,
+		Out:     `<resume xmlns="urn:xmpp:sm:3" h="3" previd="123"></resume>`,
+	},
+	6: {
+		// The server acknowledged stanzas that were never sent.
+		Feature: xmpp.StreamManagement(&xmpp.SMState{ID: "123", In: 3, Out: 2}),
+		In:      `<resumed xmlns="urn:xmpp:sm:3" h="5" previd="123"/>`,
+		Out:     `<resume xmlns="urn:xmpp:sm:3" h="3" previd="123"></resume>`,
+		Err:     stream.UndefinedCondition,
+	},
+}
+
+func TestSMResumeServer(t *testing.T) {
+	ctx := context.Background()
+	store := xmpp.NewSMStore(0)
+	for _, state := range []xmpp.SMState{
+		{ID: "other", Addr: jid.MustParse("other@example.net/res")},
+		{ID: "test", Addr: jid.MustParse("test@example.net/res"), Out: 1},
+	} {
+		err := store.Save(ctx, state)
+		if err != nil {
+			t.Fatalf("error saving state: %v", err)
+		}
+	}
+
+	xmpptest.RunFeatureTests(t, []xmpptest.FeatureTestCase{
+		0: {
+			// Sessions may only be resumed by the user they were bound to.
+			State:   xmpp.Received | xmpp.Authn,
+			Feature: xmpp.StreamManagementServer(store),
+			In:      `<resume xmlns="urn:xmpp:sm:3" h="0" previd="other"/>`,
+			Out:     `<failed xmlns="urn:xmpp:sm:3"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></item-not-found></failed>`,
+		},
+		1: {
+			// The client acknowledged stanzas that were never sent.
+			State:   xmpp.Received | xmpp.Authn,
+			Feature: xmpp.StreamManagementServer(store),
+			In:      `<resume xmlns="urn:xmpp:sm:3" h="3" previd="test"/>`,
+			Out:     `<error xmlns="http://etherx.jabber.org/streams"><undefined-condition xmlns="urn:ietf:params:xml:ns:xmpp-streams"></undefined-condition><handled-count-too-high xmlns="urn:xmpp:sm:3" h="3" send-count="1"></handled-count-too-high></error>`,
+			Err:     stream.UndefinedCondition,
+		},
+	})
+
+	// The state is not lost when somebody else tries to resume the session.
+	state, err := store.Load(ctx, "other")
+	if err != nil || !state.Addr.Equal(jid.MustParse("other@example.net/res")) {
+		t.Errorf("expected state to remain in the store, got %+v (%v)", state, err)
+	}
+}
+
+func TestSM(t *testing.T) {
+	xmpptest.RunFeatureTests(t, smTestCases[:])
+}
+
+func smNegotiator(features ...xmpp.StreamFeature) xmpp.Negotiator {
+	return xmpp.NewNegotiator(func(*xmpp.Session, *xmpp.StreamConfig) xmpp.StreamConfig {
+		return xmpp.StreamConfig{
+			Features: features,
+		}
+	})
+}
+
+// smServe negotiates a server session with stream management and serves it,
+// sending the ID of any received messages over msgs.
+func smServe(ctx context.Context, conn net.Conn, store xmpp.SMStore, msgs chan<- string) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		s, err := xmpp.ReceiveSession(ctx, conn, xmpp.Secure|xmpp.Authn, smNegotiator(
+			xmpp.StreamManagementServer(store),
+			xmpp.BindResource(),
+		))
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- s.Serve(xmpp.HandlerFunc(func(_ xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			if start.Name.Local == "message" {
+				for _, a := range start.Attr {
+					if a.Name.Local == "id" {
+						msgs <- a.Value
+					}
+				}
+			}
+			return nil
+		}))
+	}()
+	return errs
+}
+
+func smDial(ctx context.Context, t *testing.T, conn net.Conn, prev *xmpp.SMState) *xmpp.Session {
+	t.Helper()
+	s, err := xmpp.NewSession(ctx, jid.MustParse("example.net"), jid.MustParse("test@example.net"), conn, xmpp.Secure|xmpp.Authn, smNegotiator(
+		xmpp.StreamManagement(prev),
+		xmpp.BindResource(),
+	))
+	if err != nil {
+		t.Fatalf("error negotiating client session: %v", err)
+	}
+	/* #nosec */
+	go s.Serve(nil)
+	return s
+}
+
+// smPipe returns a connected pair of TCP connections.
+// Unlike net.Pipe writes are buffered which lets both sides resend stanzas
+// right after negotiation without waiting for the other side to read.
+func smPipe(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	serverConn, ok := <-accepted
+	if !ok {
+		t.Fatalf("error accepting connection")
+	}
+	return clientConn, serverConn
+}
+
+func smMessage(id string) xml.TokenReader {
+	return stanza.Message{
+		ID:   id,
+		To:   jid.MustParse("test@example.net"),
+		Type: stanza.ChatMessage,
+	}.Wrap(nil)
+}
+
+func expectMessage(ctx context.Context, t *testing.T, msgs <-chan string, id string) {
+	t.Helper()
+	select {
+	case got := <-msgs:
+		if got != id {
+			t.Fatalf("wrong message received: want=%q, got=%q", id, got)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for message %q", id)
+	}
+}
+
+// waitSMState polls the session until its stream management state satisfies
+// f.
+func waitSMState(ctx context.Context, t *testing.T, s *xmpp.Session, f func(xmpp.SMState) bool) xmpp.SMState {
+	t.Helper()
+	for {
+		state, ok := s.SMState()
+		if ok && f(state) {
+			return state
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for stream management state, last state: %+v", state)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestSMResume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store := xmpp.NewSMStore(0)
+	msgs := make(chan string, 10)
+
+	clientConn, serverConn := net.Pipe()
+	serveErr := smServe(ctx, serverConn, store, msgs)
+	client := smDial(ctx, t, clientConn, nil)
+
+	err := client.Send(ctx, smMessage("1"))
+	if err != nil {
+		t.Fatalf("error sending first message: %v", err)
+	}
+	expectMessage(ctx, t, msgs, "1")
+	err = client.RequestAck(ctx)
+	if err != nil {
+		t.Fatalf("error requesting ack: %v", err)
+	}
+	waitSMState(ctx, t, client, func(state xmpp.SMState) bool {
+		return state.ID != "" && state.Out == 1 && len(state.Unacked) == 0
+	})
+
+	// Drop the connection and send a stanza that never makes it to the server.
+	err = serverConn.Close()
+	if err != nil {
+		t.Fatalf("error closing server conn: %v", err)
+	}
+	select {
+	case err = <-serveErr:
+		if err == nil {
+			t.Fatalf("expected lost connection to result in an error")
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for server to stop serving")
+	}
+	err = client.Send(ctx, smMessage("2"))
+	if err == nil {
+		t.Fatalf("expected error sending on closed connection")
+	}
+	state := waitSMState(ctx, t, client, func(state xmpp.SMState) bool {
+		return len(state.Unacked) == 1
+	})
+
+	clientConn, serverConn = net.Pipe()
+	serveErr = smServe(ctx, serverConn, store, msgs)
+	client = smDial(ctx, t, clientConn, &state)
+	expectMessage(ctx, t, msgs, "2")
+	if !client.Resumed() {
+		t.Errorf("expected session to be resumed")
+	}
+	if addr := client.LocalAddr(); !addr.Equal(state.Addr) {
+		t.Errorf("wrong address after resumption: want=%v, got=%v", state.Addr, addr)
+	}
+	newState := waitSMState(ctx, t, client, func(xmpp.SMState) bool { return true })
+	if newState.ID != state.ID {
+		t.Errorf("wrong ID after resumption: want=%q, got=%q", state.ID, newState.ID)
+	}
+	if newState.In != state.In {
+		t.Errorf("wrong inbound count after resumption: want=%d, got=%d", state.In, newState.In)
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Fatalf("error closing client: %v", err)
+	}
+	select {
+	case err = <-serveErr:
+		if err != nil {
+			t.Fatalf("unexpected error from server: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for server to stop serving")
+	}
+}
+
+func TestSMResumeFailed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msgs := make(chan string, 10)
+	var unacked [][]byte
+	for _, id := range []string{"1", "2"} {
+		b, err := xml.Marshal(stanza.Message{ID: id, Type: stanza.ChatMessage})
+		if err != nil {
+			t.Fatalf("error marshaling message: %v", err)
+		}
+		unacked = append(unacked, b)
+	}
+
+	clientConn, serverConn := smPipe(t)
+	smServe(ctx, serverConn, xmpp.NewSMStore(0), msgs)
+	client := smDial(ctx, t, clientConn, &xmpp.SMState{
+		ID:      "unknown",
+		Unacked: unacked,
+	})
+	expectMessage(ctx, t, msgs, "1")
+	expectMessage(ctx, t, msgs, "2")
+
+	state := waitSMState(ctx, t, client, func(state xmpp.SMState) bool {
+		return state.ID != ""
+	})
+	if state.ID == "unknown" {
+		t.Errorf("expected new stream management ID after failed resumption")
+	}
+	if len(state.Unacked) != 2 {
+		t.Errorf("expected resent stanzas to be unacknowledged, got %d", len(state.Unacked))
+	}
+}
+
+func TestSMAutoRequestAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msgs := make(chan string, 20)
+	clientConn, serverConn := smPipe(t)
+	smServe(ctx, serverConn, nil, msgs)
+	client := smDial(ctx, t, clientConn, nil)
+	/* #nosec */
+	defer client.Close()
+	waitSMState(ctx, t, client, func(xmpp.SMState) bool { return true })
+
+	// Acknowledgements are requested without calling RequestAck so that the
+	// unacknowledged queue does not grow forever.
+	for i := 0; i < 10; i++ {
+		err := client.Send(ctx, smMessage(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+		expectMessage(ctx, t, msgs, strconv.Itoa(i))
+	}
+	waitSMState(ctx, t, client, func(state xmpp.SMState) bool {
+		return state.Out == 10 && len(state.Unacked) == 0
+	})
+}
+
+func TestSMRequestAckDisabled(t *testing.T) {
+	s := xmpptest.NewClientSession(0, struct {
+		io.Reader
+		io.Writer
+	}{})
+	if err := s.RequestAck(context.Background()); err == nil {
+		t.Errorf("expected error requesting ack without stream management")
+	}
+}
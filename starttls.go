@@ -63,7 +63,9 @@ func StartTLS(cfg *tls.Config) StreamFeature {
 			var rw io.ReadWriter
 			if (state & Received) == Received {
 				fmt.Fprint(conn, `<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>`)
-				rw = tls.Server(conn, cfg)
+				rw = tls.Server(conn, recordCertificate(cfg, func(cert *tls.Certificate) {
+					session.tlsCert = cert
+				}))
 			} else {
 				// Select starttls for negotiation.
 				fmt.Fprint(conn, `<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>`)
@@ -0,0 +1,231 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp"
+	"github.com/kamrankamilli/xmpp/bin"
+	"github.com/kamrankamilli/xmpp/crypto"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/disco/items"
+	"github.com/kamrankamilli/xmpp/file"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/oob"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// ErrNoService is returned by FindService if the server does not advertise an
+// upload service.
+var ErrNoService = errors.New("upload: no upload service found")
+
+// FindService returns the address of the first upload service advertised by
+// the server that s is connected to.
+func FindService(ctx context.Context, s *xmpp.Session) (jid.JID, error) {
+	server := s.LocalAddr().Domain()
+	iter := disco.FetchItems(ctx, items.Item{JID: server}, s)
+	var candidates []jid.JID
+	for iter.Next() {
+		candidates = append(candidates, iter.Item().JID)
+	}
+	err := iter.Err()
+	if err != nil {
+		/* #nosec */
+		iter.Close()
+		return jid.JID{}, err
+	}
+	err = iter.Close()
+	if err != nil {
+		return jid.JID{}, err
+	}
+
+	for _, j := range candidates {
+		info, err := disco.GetInfo(ctx, "", j, s)
+		if err != nil {
+			continue
+		}
+		for _, f := range info.Features {
+			if f.Var == NS {
+				return j, nil
+			}
+		}
+	}
+	return jid.JID{}, ErrNoService
+}
+
+// Thumbnail is a small preview image that is sent along with a file using
+// bits of binary.
+type Thumbnail struct {
+	Type   string
+	Data   []byte
+	Width  uint64
+	Height uint64
+}
+
+// SendOptions configure how a file is uploaded and announced by SendFile.
+// The zero value is a valid configuration.
+type SendOptions struct {
+	// Service is the address of the upload service.
+	// If it is the zero value, the service is discovered using FindService.
+	Service jid.JID
+
+	// Client is the HTTP client used to upload the file.
+	// If it is nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Hash is the hash function used to calculate the hash of the file that is
+	// included in its metadata.
+	// If it is zero, SHA256 is used.
+	Hash crypto.Hash
+
+	// Progress, if set, is called with the total number of bytes uploaded each
+	// time some of the file is read.
+	// It may be called from a different goroutine.
+	Progress func(sent int64)
+
+	// Desc is an optional description of the file.
+	Desc string
+
+	// Date is the modification date of the file.
+	// If it is the zero value, the current time is used.
+	Date time.Time
+
+	// Width, Height, and Length describe images, videos, and audio files.
+	Width  uint64
+	Height uint64
+	Length uint64
+
+	// Thumbnails are sent in the message along with the metadata.
+	// Sending thumbnails requires that crypto/sha1 be linked into the binary.
+	Thumbnails []Thumbnail
+}
+
+type progressReader struct {
+	r    io.Reader
+	h    hash.Hash
+	sent int64
+	f    func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		// hash.Write never returns an error.
+		/* #nosec */
+		p.h.Write(b[:n])
+		p.sent += int64(n)
+		if p.f != nil {
+			p.f(p.sent)
+		}
+	}
+	return n, err
+}
+
+// SendFile uploads the contents of r to an upload service and sends a message
+// linking to it.
+//
+// The message is sent using msg (which should have its To and Type set) and
+// contains the URL of the file as its body, the URL as out of band data, the
+// metadata of the file (XEP-0446: File metadata element), and the data of any
+// thumbnails.
+// The size of the file must be set and must match the number of bytes in r.
+// The metadata that was sent is returned.
+func SendFile(ctx context.Context, s *xmpp.Session, msg stanza.Message, f File, r io.Reader, opts *SendOptions) (file.Meta, error) {
+	if opts == nil {
+		opts = &SendOptions{}
+	}
+	h := opts.Hash
+	if h == 0 {
+		h = crypto.SHA256
+	}
+	// Thumbnails are identified by their SHA-1 content ID.
+	if !h.Available() || (len(opts.Thumbnails) > 0 && !crypto.SHA1.Available()) {
+		return file.Meta{}, crypto.ErrUnlinkedAlgo
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	service := opts.Service
+	if service.Equal(jid.JID{}) {
+		var err error
+		service, err = FindService(ctx, s)
+		if err != nil {
+			return file.Meta{}, err
+		}
+	}
+
+	slot, err := GetSlot(ctx, f, service, s)
+	if err != nil {
+		return file.Meta{}, err
+	}
+	body := &progressReader{r: r, h: h.New(), f: opts.Progress}
+	req, err := slot.Put(ctx, body)
+	if err != nil {
+		return file.Meta{}, err
+	}
+	req.ContentLength = int64(f.Size)
+	if f.Type != "" {
+		req.Header.Set("Content-Type", f.Type)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return file.Meta{}, err
+	}
+	/* #nosec */
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return file.Meta{}, fmt.Errorf("upload: unexpected status uploading file: %s", resp.Status)
+	}
+
+	date := opts.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	meta := file.Meta{
+		MediaType: f.Type,
+		Name:      f.Name,
+		Date:      date.UTC(),
+		Size:      uint64(f.Size),
+		Hash:      crypto.HashOutput{Hash: h, Out: body.h.Sum(nil)},
+		Width:     opts.Width,
+		Height:    opts.Height,
+		Length:    opts.Length,
+	}
+	getURL := slot.GetURL.String()
+	payload := []xml.TokenReader{
+		xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(getURL)),
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+		),
+		oob.Data{URL: getURL, Desc: opts.Desc}.TokenReader(),
+	}
+	for _, t := range opts.Thumbnails {
+		data := &bin.Data{Type: t.Type, Data: t.Data}
+		cid := data.ContentID(crypto.SHA1)
+		data.CID = strings.TrimPrefix(cid, "cid:")
+		meta.Thumbnails = append(meta.Thumbnails, file.Thumbnail{
+			URI:       cid,
+			MediaType: t.Type,
+			Width:     t.Width,
+			Height:    t.Height,
+		})
+		payload = append(payload, data.TokenReader())
+	}
+	payload = append(payload, meta.TokenReader())
+
+	err = s.Send(ctx, msg.Wrap(xmlstream.MultiReader(payload...)))
+	return meta, err
+}
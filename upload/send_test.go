@@ -0,0 +1,137 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package upload_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/bin"
+	"github.com/kamrankamilli/xmpp/disco"
+	"github.com/kamrankamilli/xmpp/disco/items"
+	"github.com/kamrankamilli/xmpp/file"
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/oob"
+	"github.com/kamrankamilli/xmpp/stanza"
+	"github.com/kamrankamilli/xmpp/upload"
+)
+
+var uploadService = jid.MustParse("upload.example.net")
+
+// serverItems lists the upload service as an item of the server.
+type serverItems struct{}
+
+func (serverItems) HandleIQ(stanza.IQ, xmlstream.TokenReadEncoder, *xml.StartElement) error {
+	return nil
+}
+
+func (serverItems) ForItems(node string, f func(items.Item) error) error {
+	if node != "" {
+		return nil
+	}
+	return f(items.Item{JID: uploadService})
+}
+
+func TestSendFile(t *testing.T) {
+	svc, srv := newService(t)
+	type sentMessage struct {
+		Body string      `xml:"body"`
+		OOB  oob.Data    `xml:"jabber:x:oob x"`
+		Meta file.Meta   `xml:"urn:xmpp:file:metadata:0 file"`
+		Data []*bin.Data `xml:"urn:xmpp:bob data"`
+	}
+	sent := make(chan sentMessage, 1)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(stanza.NSClient,
+			upload.Handle(svc),
+			disco.Handle(),
+			mux.IQ(stanza.GetIQ, xml.Name{Space: "urn:example:items", Local: "query"}, serverItems{}),
+			mux.MessageFunc(stanza.ChatMessage, xml.Name{Space: file.NSMeta, Local: "file"}, func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+				msg := sentMessage{}
+				err := xml.NewTokenDecoder(r).Decode(&msg)
+				if err != nil {
+					return err
+				}
+				sent <- msg
+				return nil
+			}),
+		)),
+	)
+	ctx := context.Background()
+
+	j, err := upload.FindService(ctx, cs.Client)
+	if err != nil {
+		t.Fatalf("error finding upload service: %v", err)
+	}
+	if !j.Equal(uploadService) {
+		t.Errorf("wrong upload service: want=%v, got=%v", uploadService, j)
+	}
+
+	content := strings.Repeat("All work and no play makes Jack a dull boy. ", 20)
+	thumb := []byte{0x89, 'P', 'N', 'G'}
+	var progress int64
+	to := jid.MustParse("juliet@example.com")
+	meta, err := upload.SendFile(ctx, cs.Client, stanza.Message{To: to, Type: stanza.ChatMessage}, upload.File{
+		Name: "jack.txt",
+		Size: len(content),
+		Type: "text/plain",
+	}, strings.NewReader(content), &upload.SendOptions{
+		Client:   srv.Client(),
+		Progress: func(n int64) { progress = n },
+		Desc:     "The shining",
+		Thumbnails: []upload.Thumbnail{{
+			Type:   "image/png",
+			Data:   thumb,
+			Width:  32,
+			Height: 32,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("error sending file: %v", err)
+	}
+	if progress != int64(len(content)) {
+		t.Errorf("wrong final progress: want=%d, got=%d", len(content), progress)
+	}
+	sum := sha256.Sum256([]byte(content))
+	if !bytes.Equal(meta.Hash.Out, sum[:]) {
+		t.Errorf("wrong hash: want=%x, got=%x", sum, meta.Hash.Out)
+	}
+
+	msg := <-sent
+	if msg.Body != msg.OOB.URL || msg.OOB.Desc != "The shining" {
+		t.Errorf("wrong OOB data: body=%q, oob=%+v", msg.Body, msg.OOB)
+	}
+	if msg.Meta.Name != "jack.txt" || msg.Meta.Size != uint64(len(content)) || !bytes.Equal(msg.Meta.Hash.Out, sum[:]) {
+		t.Errorf("wrong metadata: %+v", msg.Meta)
+	}
+	if len(msg.Meta.Thumbnails) != 1 || len(msg.Data) != 1 {
+		t.Fatalf("wrong number of thumbnails: meta=%d, data=%d", len(msg.Meta.Thumbnails), len(msg.Data))
+	}
+	if uri := msg.Meta.Thumbnails[0].URI; uri != "cid:"+msg.Data[0].CID || !bytes.Equal(msg.Data[0].Data, thumb) {
+		t.Errorf("wrong thumbnail: uri=%q, cid=%q", uri, msg.Data[0].CID)
+	}
+
+	resp, err := srv.Client().Get(msg.OOB.URL)
+	if err != nil {
+		t.Fatalf("error downloading file: %v", err)
+	}
+	/* #nosec */
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if string(out) != content {
+		t.Errorf("wrong file contents downloaded")
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"mellium.im/xmlstream"
+	"github.com/kamrankamilli/xmpp/disco/info"
+	"github.com/kamrankamilli/xmpp/form"
+	"github.com/kamrankamilli/xmpp/internal/attr"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+)
+
+// DefaultPutExpiry is the amount of time that upload URLs are valid for if no
+// other expiry is set on the Service.
+const DefaultPutExpiry = 5 * time.Minute
+
+// Handle returns an option that registers a Service to issue upload slots.
+func Handle(s *Service) mux.Option {
+	return mux.IQ(stanza.GetIQ, xml.Name{Space: NS, Local: "request"}, s)
+}
+
+// Service issues upload slots and serves the uploaded files over HTTP.
+//
+// The upload and download URLs contain a signature and expiry time so that no
+// state has to be kept between issuing a slot and the file being uploaded.
+// Service is also an http.Handler that must be served at URL.
+type Service struct {
+	// URL is the base URL at which the service is served over HTTP.
+	URL *url.URL
+
+	// Key is the secret used to sign URLs.
+	Key []byte
+
+	// Store holds the uploaded files.
+	Store Store
+
+	// MaxSize is the maximum size of a file in bytes.
+	// If it is zero, files of any size may be uploaded.
+	MaxSize int
+
+	// Types is the list of content types that may be uploaded.
+	// Types may use a wildcard subtype (for example, "image/*").
+	// If it is empty, files of any type may be uploaded.
+	Types []string
+
+	// Quota, if set, is called before issuing a slot to check whether the
+	// requester may upload the file.
+	// If it returns a stanza.Error it is sent to the requester, any other error
+	// results in a resource-constraint error.
+	Quota func(from jid.JID, f File) error
+
+	// PutExpiry is the amount of time that upload URLs are valid for.
+	// If it is zero, DefaultPutExpiry is used.
+	PutExpiry time.Duration
+
+	// GetExpiry is the amount of time that download URLs are valid for.
+	// If it is zero, download URLs never expire.
+	GetExpiry time.Duration
+}
+
+// ForFeatures implements info.FeatureIter.
+func (s *Service) ForFeatures(node string, f func(info.Feature) error) error {
+	if node != "" {
+		return nil
+	}
+	return f(Feature)
+}
+
+// ForForms implements form.Iter.
+// If a maximum file size is set it is advertised in a form.
+func (s *Service) ForForms(node string, f func(*form.Data) error) error {
+	if node != "" || s.MaxSize == 0 {
+		return nil
+	}
+	return f(form.New(
+		form.Hidden("FORM_TYPE", form.Value(NS)),
+		form.Text("max-file-size", form.Value(strconv.Itoa(s.MaxSize))),
+	))
+}
+
+// fileTooLarge is the error returned when a slot is requested for a file that
+// is larger than the maximum size.
+type fileTooLarge struct {
+	max int
+}
+
+func (e fileTooLarge) Error() string {
+	return "upload: file too large"
+}
+
+func (e fileTooLarge) TokenReader() xml.TokenReader {
+	return stanza.Error{
+		Type:      stanza.Modify,
+		Condition: stanza.NotAcceptable,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(strconv.Itoa(e.max))),
+			xml.StartElement{Name: xml.Name{Local: "max-file-size"}},
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "file-too-large"}},
+	))
+}
+
+// HandleIQ satisfies mux.IQHandler.
+// It should generally be registered on a mux using Handle.
+func (s *Service) HandleIQ(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	f := File{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&f)
+	if err == nil {
+		err = s.check(iq.From, f)
+	}
+	if err != nil {
+		var tooLarge fileTooLarge
+		stanzaErr := stanza.Error{}
+		var payload xml.TokenReader
+		switch {
+		case errors.As(err, &tooLarge):
+			payload = tooLarge.TokenReader()
+		case errors.As(err, &stanzaErr):
+			payload = stanzaErr.TokenReader()
+		default:
+			payload = stanza.Error{
+				Type:      stanza.Modify,
+				Condition: stanza.BadRequest,
+			}.TokenReader()
+		}
+		iq.Type = stanza.ErrorIQ
+		iq.From, iq.To = iq.To, iq.From
+		_, err = xmlstream.Copy(r, iq.Wrap(payload))
+		return err
+	}
+	_, err = xmlstream.Copy(r, iq.Result(s.Slot(f).TokenReader()))
+	return err
+}
+
+func (s *Service) check(from jid.JID, f File) error {
+	if f.Name == "" || f.Size <= 0 {
+		return stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}
+	}
+	if s.MaxSize > 0 && f.Size > s.MaxSize {
+		return fileTooLarge{max: s.MaxSize}
+	}
+	if !s.allowedType(f.Type) {
+		return stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.NotAcceptable,
+			Text: map[string]string{
+				"": "content type not allowed",
+			},
+		}
+	}
+	if s.Quota != nil {
+		err := s.Quota(from, f)
+		if err != nil {
+			stanzaErr := stanza.Error{}
+			if errors.As(err, &stanzaErr) {
+				return stanzaErr
+			}
+			return stanza.Error{Type: stanza.Wait, Condition: stanza.ResourceConstraint}
+		}
+	}
+	return nil
+}
+
+func (s *Service) allowedType(typ string) bool {
+	if len(s.Types) == 0 {
+		return true
+	}
+	for _, allowed := range s.Types {
+		if allowed == typ {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(typ, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Slot returns a new slot for uploading f.
+// The file is not checked against the size and type restrictions of the
+// service.
+func (s *Service) Slot(f File) Slot {
+	name := attr.RandomID() + "/" + url.PathEscape(f.Name)
+	now := time.Now()
+
+	putExpiry := s.PutExpiry
+	if putExpiry == 0 {
+		putExpiry = DefaultPutExpiry
+	}
+	put := url.Values{}
+	put.Set("size", strconv.Itoa(f.Size))
+	if f.Type != "" {
+		put.Set("type", f.Type)
+	}
+	put.Set("expires", strconv.FormatInt(now.Add(putExpiry).Unix(), 10))
+	put.Set("sig", s.sign(http.MethodPut, name, put))
+
+	get := url.Values{}
+	if s.GetExpiry != 0 {
+		get.Set("expires", strconv.FormatInt(now.Add(s.GetExpiry).Unix(), 10))
+	}
+	get.Set("sig", s.sign(http.MethodGet, name, get))
+
+	return Slot{
+		PutURL: s.url(name, put),
+		GetURL: s.url(name, get),
+	}
+}
+
+func (s *Service) url(name string, q url.Values) *url.URL {
+	u := *s.URL
+	u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + name
+	// Path must be the unescaped form of RawPath for it to be used.
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	u.RawQuery = q.Encode()
+	return &u
+}
+
+// sign returns the signature of the request parameters other than the
+// signature itself.
+func (s *Service) sign(method, name string, q url.Values) string {
+	mac := hmac.New(sha256.New, s.Key)
+	for _, v := range []string{method, name, q.Get("size"), q.Get("type"), q.Get("expires")} {
+		/* #nosec */
+		io.WriteString(mac, v)
+		/* #nosec */
+		mac.Write([]byte{0})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and expiry of a request and returns the name
+// of the file.
+func (s *Service) verify(method string, r *http.Request) (string, bool) {
+	base := strings.TrimSuffix(s.URL.EscapedPath(), "/") + "/"
+	name, ok := strings.CutPrefix(r.URL.EscapedPath(), base)
+	if !ok || name == "" {
+		return "", false
+	}
+	q := r.URL.Query()
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get("sig"))
+	if err != nil {
+		return "", false
+	}
+	want, _ := base64.RawURLEncoding.DecodeString(s.sign(method, name, q))
+	if !hmac.Equal(sig, want) {
+		return "", false
+	}
+	if expires := q.Get("expires"); expires != "" {
+		t, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().Unix() > t {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// ServeHTTP satisfies http.Handler by accepting uploads with PUT requests and
+// serving uploaded files with GET and HEAD requests.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		s.put(w, r)
+	case http.MethodGet, http.MethodHead:
+		s.get(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) put(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.verify(http.MethodPut, r)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	size, err := strconv.ParseInt(q.Get("size"), 10, 64)
+	if err != nil || r.ContentLength != size {
+		http.Error(w, "content length does not match slot", http.StatusBadRequest)
+		return
+	}
+	contentType := q.Get("type")
+	if got := r.Header.Get("Content-Type"); contentType != "" && got != contentType {
+		http.Error(w, "content type does not match slot", http.StatusBadRequest)
+		return
+	}
+
+	// Read one more byte than expected so that a body that is longer than its
+	// content length can be detected.
+	body := &countReader{r: io.LimitReader(r.Body, size+1)}
+	err = s.Store.Put(r.Context(), name, contentType, &sizeReader{r: body, size: size})
+	if err != nil {
+		if errors.Is(err, errSize) {
+			http.Error(w, "content length does not match slot", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Service) get(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.verify(http.MethodGet, r)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	f, contentType, err := s.Store.Get(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	/* #nosec */
+	defer f.Close()
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method == http.MethodHead {
+		return
+	}
+	/* #nosec */
+	io.Copy(w, f)
+}
+
+var errSize = errors.New("upload: body does not match size")
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// sizeReader returns errSize instead of io.EOF if the underlying reader does not
+// contain exactly size bytes.
+type sizeReader struct {
+	r    *countReader
+	size int64
+}
+
+func (s *sizeReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if s.r.n > s.size || (err == io.EOF && s.r.n != s.size) {
+		return n, errSize
+	}
+	return n, err
+}
@@ -0,0 +1,177 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package upload_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamrankamilli/xmpp/internal/xmpptest"
+	"github.com/kamrankamilli/xmpp/jid"
+	"github.com/kamrankamilli/xmpp/mux"
+	"github.com/kamrankamilli/xmpp/stanza"
+	"github.com/kamrankamilli/xmpp/upload"
+)
+
+var _ http.Handler = (*upload.Service)(nil)
+
+func newService(t *testing.T) (*upload.Service, *httptest.Server) {
+	svc := &upload.Service{
+		Key:     []byte("secret"),
+		Store:   upload.Dir(t.TempDir()),
+		MaxSize: 1024,
+		Types:   []string{"text/*"},
+		Quota: func(_ jid.JID, f upload.File) error {
+			if f.Name == "quota.txt" {
+				return errors.New("over quota")
+			}
+			return nil
+		},
+	}
+	m := http.NewServeMux()
+	m.Handle("/files/", svc)
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL + "/files/")
+	if err != nil {
+		t.Fatalf("error parsing server URL: %v", err)
+	}
+	svc.URL = u
+	return svc, srv
+}
+
+func put(t *testing.T, srv *httptest.Server, slot upload.Slot, contentType string, body []byte, size int64) int {
+	t.Helper()
+	req, err := slot.Put(context.Background(), bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating put request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.ContentLength = size
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("error uploading: %v", err)
+	}
+	/* #nosec */
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestService(t *testing.T) {
+	svc, srv := newService(t)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(stanza.NSClient, upload.Handle(svc))),
+	)
+	ctx := context.Background()
+	to := jid.MustParse("upload.example.net")
+	file := []byte("Old One was he and his medicine was strong.")
+
+	slot, err := upload.GetSlot(ctx, upload.File{
+		Name: "incipit/1.txt",
+		Size: len(file),
+		Type: "text/plain",
+	}, to, cs.Client)
+	if err != nil {
+		t.Fatalf("error getting slot: %v", err)
+	}
+	if code := put(t, srv, slot, "text/plain", file, int64(len(file))); code != http.StatusCreated {
+		t.Fatalf("wrong status uploading file: want=%d, got=%d", http.StatusCreated, code)
+	}
+	resp, err := srv.Client().Get(slot.GetURL.String())
+	if err != nil {
+		t.Fatalf("error downloading file: %v", err)
+	}
+	/* #nosec */
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(out, file) {
+		t.Errorf("wrong download: status=%d, body=%q", resp.StatusCode, out)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("wrong content type: want=text/plain, got=%q", ct)
+	}
+
+	for _, tc := range []struct {
+		name string
+		file upload.File
+		cond stanza.Condition
+	}{
+		{name: "too large", file: upload.File{Name: "big.txt", Size: 2048, Type: "text/plain"}, cond: stanza.NotAcceptable},
+		{name: "type", file: upload.File{Name: "a.exe", Size: 10, Type: "application/x-msdownload"}, cond: stanza.NotAcceptable},
+		{name: "quota", file: upload.File{Name: "quota.txt", Size: 10, Type: "text/plain"}, cond: stanza.ResourceConstraint},
+		{name: "empty", file: upload.File{Name: "empty.txt", Type: "text/plain"}, cond: stanza.BadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := upload.GetSlot(ctx, tc.file, to, cs.Client)
+			stanzaErr := stanza.Error{}
+			if !errors.As(err, &stanzaErr) || stanzaErr.Condition != tc.cond {
+				t.Errorf("wrong error: want=%v, got=%v", tc.cond, err)
+			}
+		})
+	}
+}
+
+func TestServiceHTTP(t *testing.T) {
+	svc, srv := newService(t)
+	file := []byte("hello")
+	f := upload.File{Name: "hello.txt", Size: len(file), Type: "text/plain"}
+
+	slot := svc.Slot(f)
+	if code := put(t, srv, slot, "text/html", file, int64(len(file))); code != http.StatusBadRequest {
+		t.Errorf("wrong status for mismatched type: want=%d, got=%d", http.StatusBadRequest, code)
+	}
+	if code := put(t, srv, slot, "text/plain", []byte("hello world"), int64(len("hello world"))); code != http.StatusBadRequest {
+		t.Errorf("wrong status for mismatched size: want=%d, got=%d", http.StatusBadRequest, code)
+	}
+	resp, err := srv.Client().Get(slot.GetURL.String())
+	if err != nil {
+		t.Fatalf("error downloading file: %v", err)
+	}
+	/* #nosec */
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("rejected uploads should not be stored: got status %d", resp.StatusCode)
+	}
+
+	// Changing any signed parameter invalidates the URL.
+	tampered := svc.Slot(f)
+	q := tampered.PutURL.Query()
+	q.Set("size", "4")
+	tampered.PutURL.RawQuery = q.Encode()
+	if code := put(t, srv, tampered, "text/plain", file[:4], 4); code != http.StatusForbidden {
+		t.Errorf("wrong status for tampered URL: want=%d, got=%d", http.StatusForbidden, code)
+	}
+	tampered = svc.Slot(f)
+	tampered.GetURL.Path = strings.Replace(tampered.GetURL.Path, "hello", "other", 1)
+	tampered.GetURL.RawPath = ""
+	resp, err = srv.Client().Get(tampered.GetURL.String())
+	if err != nil {
+		t.Fatalf("error downloading file: %v", err)
+	}
+	/* #nosec */
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("wrong status for tampered download: want=%d, got=%d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	// Expired URLs are rejected.
+	svc.PutExpiry = -time.Minute
+	if code := put(t, srv, svc.Slot(f), "text/plain", file, int64(len(file))); code != http.StatusForbidden {
+		t.Errorf("wrong status for expired URL: want=%d, got=%d", http.StatusForbidden, code)
+	}
+}
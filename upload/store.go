@@ -0,0 +1,84 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Store holds files that were uploaded to a Service.
+type Store interface {
+	// Put stores the contents of r under name.
+	// If an error is returned no file must be stored.
+	Put(ctx context.Context, name, contentType string, r io.Reader) error
+
+	// Get returns the contents and content type of the file stored under name.
+	// If no such file exists, an error wrapping fs.ErrNotExist is returned.
+	Get(ctx context.Context, name string) (r io.ReadCloser, contentType string, err error)
+}
+
+// Dir is a Store that keeps files in a directory on the local filesystem.
+// Files are saved under a hash of their name so names may contain any
+// characters.
+type Dir string
+
+func (d Dir) path(name string) string {
+	h := sha256.Sum256([]byte(name))
+	return filepath.Join(string(d), hex.EncodeToString(h[:]))
+}
+
+// Put satisfies Store by writing r to a temporary file in the directory and
+// moving it into place once it has been written completely.
+func (d Dir) Put(_ context.Context, name, contentType string, r io.Reader) (e error) {
+	p := d.path(name)
+	f, err := os.CreateTemp(string(d), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e != nil {
+			/* #nosec */
+			os.Remove(f.Name())
+		}
+	}()
+	_, err = io.Copy(f, r)
+	if err != nil {
+		/* #nosec */
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(p+".type", []byte(contentType), 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Get satisfies Store.
+func (d Dir) Get(_ context.Context, name string) (io.ReadCloser, string, error) {
+	p := d.path(name)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, "", err
+	}
+	contentType, err := os.ReadFile(p + ".type")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		/* #nosec */
+		f.Close()
+		return nil, "", err
+	}
+	return f, string(contentType), nil
+}
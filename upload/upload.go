@@ -99,6 +99,9 @@ func (s Slot) Put(ctx context.Context, body io.Reader) (*http.Request, error) {
 		return nil, err
 	}
 	headers := s.Header.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
 	for name := range headers {
 		if !allowedHeader(http.CanonicalHeaderKey(name)) {
 			headers.Del(name)